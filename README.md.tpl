@@ -39,6 +39,20 @@ If you invoke `gql` without argument, it starts an interactive prompt,
 
 - `quit` : quits gql
 
+- `\watch [interval] expr` : evaluates the expression and prints its value
+  repeatedly, clearing the screen in between, until you press ^C. The interval
+  is a duration such as "10s", or a number of seconds. If it is omitted, the
+  expression is reevaluated whenever one of the files it reads changes. It is
+  handy for monitoring a TSV file that a running pipeline appends to:
+
+        \watch 5s read(`/tmp/metrics.tsv`) | sort(-&step)
+
+- `\transcript on file.md`, `\transcript off` : records every statement and
+  its output, with tables truncated to 20 rows, to a Markdown file.
+  `\transcript replay file.md` reexecutes the statements recorded in the file
+  and shows those whose outputs have changed, e.g., after the input files or
+  gql itself are updated.
+
 - Any other command will be evaluated as an GQL expression.  In an interactive
   mode, a newline will start evaluation, so an expression must fit in one line.
 
@@ -55,6 +69,29 @@ script.  In the above example, we pass two flags "in" and "out" to the script
 
     gql testdata/convert.gql -in=/tmp/test.tsv -out=/tmp/test.btsv
 
+Flags `-max-rows`, `-max-bytes`, and `-max-eval-time` limit the resources used
+by each evaluation, e.g., an expression typed at the interactive prompt. An
+evaluation that scans more rows, reads more bytes from table files, or runs
+longer than the limit fails with an error. They are useful for a shared server
+started with `-web`, so that one runaway query doesn't starve everyone else.
+An application that embeds gql sets them in `Opts.Limits` or calls
+`Session.SetLimits`.
+
+    gql -web=:8080 -max-rows=1000000000 -max-eval-time=10m
+
+The console runs arbitrary queries, which can read any file readable by the
+server, so an address without a host, e.g., `-web=:8080`, binds to localhost
+only. To serve other machines, pass the host explicitly, e.g.,
+`-web=0.0.0.0:8080`, and put the server behind an authenticating proxy. A
+request that takes longer than `-max-eval-time` plus a minute (an hour if
+`-max-eval-time` is unset) is cut off.
+
+In `-web` mode, users are identified by a cookie signed by the server. When
+the console runs behind an authenticating proxy, pass the proxy's network in
+`-web-trusted-proxy`, e.g., `-web-trusted-proxy=10.0.0.0/8`. The user name set
+by the proxy in `X-Forwarded-User` is honored only for requests from these
+networks.
+
 ### Basic functions
 
 
@@ -195,6 +232,20 @@ following flags will cause these functions to use AWS EC2 spot instances.
 
 To set bigslice up, follow the instructions in https://bigslice.io.
 
+If `shards` is zero or negative, the functions run sequentially in the GQL
+process. Without a bigslice cluster, `shards:=N` still splits the work into N
+tasks, but runs them as goroutines of the GQL process, at most one per CPU at a
+time, without starting bigslice. So the same script runs unchanged on a laptop
+and on a cluster. The results of `reduce` and `cogroup` computed this way are
+sorted by key. Unlike bigslice, the local execution keeps all the groups in
+memory, and for `cogroup`, all their rows, so a large `cogroup` may need a
+cluster. GQL logs a warning when more than ten million rows are grouped in
+memory.
+
+Function `jobs()` lists the parallel stages started by these functions, with
+their progress and failures, and `job_logs(id)` shows the errors of a failed
+stage.
+
 ## GQL implementation overview
 
 GQL is a dataflow language, much like other SQL variants. Each table, be it a
@@ -250,7 +301,7 @@ needed to run the expression is serialized and shipped to every bigslice shard.
       | assignment
       | 'func' symbol '(' params* ')' expr // shorthand for symbol:=|symbol...| expr
 
-    assignment := variable ':=' expr
+    assignment := variable ':=' expr | 'const' variable ':=' expr
 
     expr :=
       symbol                  // variable reference
@@ -292,6 +343,11 @@ the given file.  The `read` function supports the following file formats:
   first row of the file. GQL tries to guess the column type from the file
   contents.
 
+  - *.csv : Comma-separated-value file. It is read in the same way as a TSV
+  file. Cells that contain commas, newlines, or double quotes are quoted as
+  specified in RFC4180. `read(..., delimiter:=";")` reads a file that uses
+  another delimiter.
+
   - .prio : [Fragment file](https://sg.eng.grail.com/grail/grail/-/blob/go/src/grail.com/bio/fragments/f.go). Each fragment is mapped into a row of the following format:
 
 | Column name | type     |
@@ -333,7 +389,14 @@ Note: In `fragment.proto`, UNMETHYLATED=1, METHYLATED=2, VARIANT=4.
 
   - .bam, .pam : [BAM](https://samtools.github.io/hts-specs/SAMv1.pdf) or [PAM](https://github.com/grailbio/bio/blob/master/encoding/pam/README.md) file
 
-  - .bed: [BED file](https://genome.ucsc.edu/FAQ/FAQformat.html), three to four columns
+  - .bed: [BED file](https://genome.ucsc.edu/FAQ/FAQformat.html), three to four columns.
+  A compressed file, e.g., .bed.gz, is also read. `write` compresses a .bed.gz
+  file using gzip, or using BGZF with `bgzip:=true` for tabix.
+
+  - .jsonl, .ndjson, .json : JSON file. Each JSON value in the file becomes a
+  row. If the file is a JSON array, each element becomes a row. Objects become
+  structs, and arrays become tables. `write` writes one row per line, or a JSON
+  array if the path ends with .json.
 
   - .bincount : bincount file. Each row has the following columns:
 
@@ -456,6 +519,25 @@ valid only inside the block.
 
       { x := 10; y := x+1; x * y }
 
+The value of a block is the value of its last expression. A variable defined in
+a block may have the same name as a variable outside the block. The block-local
+variable shadows the outer one in the rest of the block, and the outer variable
+is unchanged after the block:
+
+      x := 1;
+      y := { x := x * 10; x + 1 };  // y is 11
+      x                             // still 1
+
+Such shadowing is often accidental. Flag `-shadowing=warn` makes GQL print a
+warning for each shadowing assignment in a block, and `-shadowing=error` makes
+it an error.
+
+An assignment of form `const x := expr` defines a constant. `expr` must be
+computable without reading any table or calling any nondeterministic function,
+e.g., `const threshold := 10 * 3`.  The value of a constant is substituted
+into expressions that refer to it, so it becomes part of their cache keys. A
+constant cannot be reassigned in the scope that defines it.
+
 A block is often used as a body of a function, which we describe next.
 
 ## Functions
@@ -490,13 +572,27 @@ The above example will print 75. The variables x and y defined in the body of
 > Note: function arguments are lexically bound. Functions can be nested, and they
 act as a closure.
 
+A closure captures the values of the variables it refers to when the function
+is created, so it remains valid after it escapes the block that defines it.
+Shadowing a captured variable later doesn't affect the closure:
+
+      g := { z := 100; |y| y + z };
+      g(1)                            // 101
+      { z := 1; g(1) }                // still 101
+
 The '&'-expressions introduced in [earlier examples](#basic-functions) are
 syntax sugar for user-defined functions. It is translated into a
 [function](#functions) by the GQL parser. The translation rules are the
 following:
 
-- '&'-translation applies only to a function-call argument. It is an error for
-  '&' to appear anywhere else.
+- '&'-translation applies only to a function-call argument, or to the body of
+  a one-arg function. It is an error for '&' to appear anywhere else.
+
+- When a function-call arg is itself a function of form `|row| expr`, or a
+  one-arg function is defined outside a function call, every occurrence of
+  '&col' in the function body is rewritten to become 'row.col'. '&' inside a
+  function-call arg in the body is translated by the next rule, relative to
+  that arg.
 
 - When a function-call arg contains '&' anywhere, then the entire argument is
   translated into a function with formal argument named '_', and every
@@ -508,6 +604,9 @@ After rewrite: table | map(|_|{x:_.col0, y:_.col1})
 Original:      table | map({x:&col0, y:&col1}) | sort(&x)
 After rewrite: table | map(|_|{x:_.col0, y:_.col1}) | sort(|_|_.x)
 
+Original:      table | map(|r| {x:&col0, y:&col1})
+After rewrite: table | map(|r| {x:r.col0, y:r.col1})
+
 
 The '&' rule applies recursively to the entire argument, so it may behave
 nonintuitively if '&' appears inside a nested function call. Consider the
@@ -538,6 +637,12 @@ difference between the two is that 'func` syntax requires '{' and '}' even if
 the body is a single expression. The '|args...|' form takes '{...}' only when
 the body is a [code block](#code-blocks).
 
+GQL prints a warning the first time it sees each use of a deprecated construct.
+Flag `-deny-deprecated` turns the warnings into errors, which is useful in
+automated tests. `gql -fix script.gql...` rewrites the deprecated constructs in
+the given scripts in place: `$col` becomes `&col`, `f := func(x) {...}` becomes
+`func f(x) ...`, and other `func(x) {...}` become `|x| ...`.
+
 ## Importing a GQL file
 
 The load statement can be used to load a gql into another gql file.
@@ -556,6 +661,34 @@ If you evaluate file2.gql, it will print "20".
 If a gql file can contain multiple load statements.  The load statement must
 appear before any other statement.
 
+A relative path in a load statement is looked up first in the directory of the
+file that contains the statement, then in the current directory, then in the
+directories listed in flag `-gql-path` (comma-separated; it defaults to
+`$GQLPATH`). The directories may be S3 prefixes, e.g.,
+`-gql-path=s3://bucket/gqllib`. Files read from S3 are cached in memory, and
+are reread only when they change.
+
+### Standard libraries
+
+A Go program can ship GQL library scripts by calling
+`gql.RegisterStdlib(name, script)`, typically from an `init` function. A
+library is not evaluated until a session first refers to a function or variable
+it defines. Registering a library with an existing name replaces it. Function
+`stdlib()` lists the registered libraries and their versions. A library's
+version is taken from a `// version: x.y` line in the script.
+
+### Requiring a gql version
+
+A script that uses recently added functions can state the oldest gql release
+it works with:
+
+       requires_version("2.1")
+
+A toplevel `requires_version` call is checked before any statement in the
+script runs, including load statements, so an old binary fails with a message
+asking to upgrade gql rather than with an obscure error in the middle of the
+script.
+
 ## Builtin functions
 
 {{builtin}}
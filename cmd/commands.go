@@ -11,6 +11,7 @@ import (
 	"sort"
 	"strings"
 	"text/tabwriter"
+	"time"
 	"unicode/utf8"
 
 	"github.com/grailbio/base/log"
@@ -41,8 +42,37 @@ type Env struct {
 	// display.
 	tmpVars *gql.TmpVars
 	orgLog  *vlog.Logger
+	// Renderer prints the command results.
+	renderer Renderer
+	// PageSize is the max number of table rows printed by a command.
+	pageSize int
+	// WatchPollInterval is the interval for polling remote files read by the
+	// expression given to "\watch".
+	watchPollInterval time.Duration
+	// Transcript, if nonnil, is the Markdown file that records the statements
+	// and their outputs. It is set by "\transcript on".
+	transcript *os.File
 }
 
+// Opts configures an Env.
+type Opts struct {
+	// Interactive should be true if this is an interactive commandline session.
+	Interactive bool
+	// Renderer prints the result of each command. If nil, values are printed as
+	// plain text.
+	Renderer Renderer
+	// PageSize is the max number of table rows printed by a command. If <= 0, all
+	// the rows are printed.
+	PageSize int
+	// WatchPollInterval is the interval for polling remote (e.g., S3) files read
+	// by the expression given to "\watch". If <= 0, DefaultWatchPollInterval is
+	// used.
+	WatchPollInterval time.Duration
+}
+
+// DefaultWatchPollInterval is the default value of Opts.WatchPollInterval.
+const DefaultWatchPollInterval = 30 * time.Second
+
 var (
 	pipeRE = regexp.MustCompile(`(.*)\|\s*(less)$`)
 
@@ -69,11 +99,25 @@ func parseRedirect(line string) (prefix string, out string, append bool, pipe bo
 // New creates a new environment. Arg interactive should be true if this is an
 // interactive commandline session.
 func New(sess *gql.Session, interactive bool) *Env {
+	return NewWithOpts(sess, Opts{Interactive: interactive})
+}
+
+// NewWithOpts creates a new environment with the given options.
+func NewWithOpts(sess *gql.Session, opts Opts) *Env {
 	env := &Env{
-		sess:        sess,
-		interactive: interactive,
-		orgLog:      vlog.Log,
-		tmpVars:     &gql.TmpVars{},
+		sess:              sess,
+		interactive:       opts.Interactive,
+		orgLog:            vlog.Log,
+		tmpVars:           &gql.TmpVars{},
+		renderer:          opts.Renderer,
+		pageSize:          opts.PageSize,
+		watchPollInterval: opts.WatchPollInterval,
+	}
+	if env.renderer == nil {
+		env.renderer = NewTextRenderer()
+	}
+	if env.watchPollInterval <= 0 {
+		env.watchPollInterval = DefaultWatchPollInterval
 	}
 
 	env.builtinCmds = map[string]command{
@@ -99,6 +143,28 @@ func New(sess *gql.Session, interactive bool) *Env {
 			help: `Usage: history
 
   Shows the list of past inputs.`},
+		`\watch`: command{
+			callback: env.runWatch,
+			help: `Usage: \watch [interval] expr
+
+  Watch evaluates the expression and prints its value repeatedly, clearing the
+  screen in between, until ^C is pressed. The interval is a duration such as
+  "10s", or a number of seconds. If the interval is omitted, the expression is
+  reevaluated when one of the files it reads changes. For example,
+
+    \watch 5s read("metrics.tsv") | filter(&step > 100)
+
+  Tables are truncated to fit the screen.`},
+		`\transcript`: command{
+			callback: env.runTranscript,
+			help: `Usage: \transcript on file.md
+       \transcript off
+       \transcript replay file.md
+
+  "\transcript on" records every statement and its output, with tables
+  truncated to 20 rows, to the Markdown file, until "\transcript off" is
+  run. "\transcript replay" reexecutes the statements recorded in the file and
+  shows those whose outputs have changed.`},
 	}
 	return env
 }
@@ -211,8 +277,26 @@ func (c *Env) runEval(ctx context.Context, line string) {
 				log.Printf("Found no statement")
 				return
 			}
-			val := c.sess.EvalStatements(ctx, statements)
-			c.PrintValue(ctx, val, gql.PrintValues, out)
+			func() {
+				defer c.recoverAndRenderError(ctx, c.printArgs(gql.PrintValues, out))
+				// Apply the limits to both the evaluation and the printing of the result.
+				ctx, cancel := c.sess.WithLimits(ctx)
+				defer cancel()
+				c.tmpVars.Expr = strings.TrimSpace(expr)
+				if c.transcript != nil {
+					defer func() {
+						if err := recover(); err != nil {
+							c.recordTranscript(c.tmpVars.Expr, transcriptError(err))
+							panic(err)
+						}
+					}()
+				}
+				val := c.sess.EvalStatements(ctx, statements)
+				c.PrintValue(ctx, val, gql.PrintValues, out)
+				if c.transcript != nil {
+					c.recordTranscript(c.tmpVars.Expr, transcriptOutput(ctx, val))
+				}
+			}()
 			return
 		case err != io.EOF:
 			log.Error.Printf("Readline error: %v", err)
@@ -231,21 +315,40 @@ func (c *Env) runEval(ctx context.Context, line string) {
 	}
 }
 
-// PrintValue prints the given value to the terminal with paging.
-func (c *Env) PrintValue(ctx context.Context, val gql.Value, mode gql.PrintMode, out termutil.Printer) {
-	defer func() {
-		if err := recover(); err != nil {
-			log.Printf("Recovered from error: %v: %v", err, string(debug.Stack()))
-		}
-	}()
-	args := gql.PrintArgs{
+func (c *Env) printArgs(mode gql.PrintMode, out termutil.Printer) gql.PrintArgs {
+	return gql.PrintArgs{
 		Out:     out,
 		Mode:    mode,
 		TmpVars: c.tmpVars,
 	}
-	val.Print(ctx, args)
+}
+
+// recoverAndRenderError recovers from a panic and reports it through the
+// renderer. It must be called via defer.
+func (c *Env) recoverAndRenderError(ctx context.Context, args gql.PrintArgs) {
+	if err := recover(); err != nil {
+		log.Printf("Recovered from error: %v: %v", err, string(debug.Stack()))
+		c.renderer.RenderError(ctx, args, fmt.Errorf("%v", err))
+	}
+}
+
+// PrintValue prints the given value to the terminal with paging. In
+// gql.PrintValues mode, the value is printed by the renderer.
+func (c *Env) PrintValue(ctx context.Context, val gql.Value, mode gql.PrintMode, out termutil.Printer) {
+	args := c.printArgs(mode, out)
+	defer c.recoverAndRenderError(ctx, args)
+	switch {
+	case mode != gql.PrintValues:
+		val.Print(ctx, args)
+		if args.Out.Ok() {
+			args.Out.Write([]byte("\n"))
+		}
+	case val.Type() == gql.TableType:
+		c.renderer.RenderTable(ctx, args, val.Table(nil), 0, c.pageSize)
+	default:
+		c.renderer.RenderScalar(ctx, args, val)
+	}
 	if args.Out.Ok() {
-		args.Out.Write([]byte("\n"))
 		c.tmpVars.Flush(c.sess)
 	}
 }
@@ -259,6 +362,8 @@ func (c *Env) NewOutput() termutil.Printer {
 }
 
 func (c *Env) runQuit(ctx context.Context, args string) {
+	c.stopTranscript()
+	gql.CleanupTempFiles(ctx)
 	os.Exit(0)
 }
 
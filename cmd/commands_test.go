@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"testing"
+	"time"
 
 	"github.com/grailbio/testutil/expect"
 )
@@ -29,3 +30,25 @@ func TestParseRedirect(t *testing.T) {
 	expect.False(t, append)
 	expect.True(t, pipe)
 }
+
+func TestParseWatchArgs(t *testing.T) {
+	d, expr, err := parseWatchArgs(" 5s read(`foo.tsv`) ")
+	expect.NoError(t, err)
+	expect.EQ(t, d, 5*time.Second)
+	expect.EQ(t, expr, "read(`foo.tsv`)")
+
+	d, expr, err = parseWatchArgs("0.5 x + 1")
+	expect.NoError(t, err)
+	expect.EQ(t, d, 500*time.Millisecond)
+	expect.EQ(t, expr, "x + 1")
+
+	d, expr, err = parseWatchArgs("read(`foo.tsv`) | filter(&A > 1)")
+	expect.NoError(t, err)
+	expect.EQ(t, d, time.Duration(0))
+	expect.EQ(t, expr, "read(`foo.tsv`) | filter(&A > 1)")
+
+	_, _, err = parseWatchArgs("-1s x")
+	expect.HasSubstr(t, err.Error(), "interval must be positive")
+	_, _, err = parseWatchArgs("  ")
+	expect.HasSubstr(t, err.Error(), "no expression")
+}
@@ -0,0 +1,231 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/gql"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/termutil"
+)
+
+// Renderer formats the results of commands for a particular frontend, e.g., a
+// terminal or a web console. Env uses a Renderer to print the value of each
+// command. Each method writes to args.Out. Args.TmpVars, if set, is used to name
+// nested tables that are too large to be rendered inline.
+type Renderer interface {
+	// RenderTable renders rows [start, start+limit) of the table. If limit <=
+	// 0, it renders all the rows from start.
+	RenderTable(ctx context.Context, args gql.PrintArgs, t gql.Table, start, limit int)
+	// RenderScalar renders a non-table value.
+	RenderScalar(ctx context.Context, args gql.PrintArgs, v gql.Value)
+	// RenderError renders an error raised while evaluating or rendering a value.
+	RenderError(ctx context.Context, args gql.PrintArgs, err error)
+}
+
+// NewRenderer creates a renderer by name. Name is one of "text", "json", or
+// "html".
+func NewRenderer(name string) (Renderer, error) {
+	switch name {
+	case "text", "":
+		return NewTextRenderer(), nil
+	case "json":
+		return NewJSONRenderer(), nil
+	case "html":
+		return NewHTMLRenderer(defaultMaxHTMLTableCells), nil
+	}
+	return nil, fmt.Errorf("unknown renderer '%s'; must be one of text, json, or html", name)
+}
+
+// tablePage extracts rows [start, start+limit) of the table. It also reports
+// whether the table has rows after the page.
+func tablePage(ctx context.Context, t gql.Table, start, limit int) (gql.Table, bool) {
+	if start <= 0 && limit <= 0 {
+		return t, false
+	}
+	var (
+		rows []gql.Value
+		more bool
+		sc   = t.Scanner(ctx, 0, 1, 1)
+	)
+	for i := 0; sc.Scan(); i++ {
+		if i < start {
+			continue
+		}
+		if limit > 0 && len(rows) >= limit {
+			more = true
+			break
+		}
+		rows = append(rows, sc.Value())
+	}
+	h := t.Hash().Merge(hash.Int(int64(start))).Merge(hash.Int(int64(limit)))
+	return gql.NewSimpleTable(rows, h, t.Attrs(ctx)), more
+}
+
+// textRenderer is the default renderer. It prints values in the same format as
+// Value.Print, with paging on interactive terminals.
+type textRenderer struct{}
+
+// NewTextRenderer creates a renderer that prints values as plain text.
+func NewTextRenderer() Renderer { return textRenderer{} }
+
+// RenderTable implements Renderer.
+func (textRenderer) RenderTable(ctx context.Context, args gql.PrintArgs, t gql.Table, start, limit int) {
+	page, more := tablePage(ctx, t, start, limit)
+	gql.NewTable(page).Print(ctx, args)
+	if args.Out.Ok() {
+		if more {
+			args.Out.WriteString("(more rows omitted)\n")
+		}
+		args.Out.Write([]byte("\n"))
+	}
+}
+
+// RenderScalar implements Renderer.
+func (textRenderer) RenderScalar(ctx context.Context, args gql.PrintArgs, v gql.Value) {
+	v.Print(ctx, args)
+	if args.Out.Ok() {
+		args.Out.Write([]byte("\n"))
+	}
+}
+
+// RenderError implements Renderer. It is a noop, since the error is already
+// reported in the log.
+func (textRenderer) RenderError(ctx context.Context, args gql.PrintArgs, err error) {}
+
+// jsonRenderer prints values as JSON objects, one per command:
+//
+//	{"value": 10}
+//	{"start": 0, "rows": [{"A": 10, "B": "ab0"}, ...], "more": false}
+//	{"error": "..."}
+type jsonRenderer struct{}
+
+// NewJSONRenderer creates a renderer that prints values as JSON.
+func NewJSONRenderer() Renderer { return jsonRenderer{} }
+
+// writeJSONString writes s as a quoted JSON string.
+func writeJSONString(out termutil.Printer, s string) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		log.Panicf("json: %v", err)
+	}
+	out.Write(data) // nolint: errcheck
+}
+
+// writeJSONValue writes the value in JSON. Structs become objects, and nested
+// tables become strings in the compact format.
+func writeJSONValue(ctx context.Context, args gql.PrintArgs, v gql.Value) {
+	out := args.Out
+	switch v.Type() {
+	case gql.NullType:
+		out.WriteString("null")
+	case gql.BoolType:
+		out.WriteString(strconv.FormatBool(v.Bool(nil)))
+	case gql.IntType:
+		out.WriteInt(v.Int(nil))
+	case gql.FloatType:
+		f := v.Float(nil)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			// JSON has no representation of NaN or Inf.
+			writeJSONString(out, strconv.FormatFloat(f, 'g', -1, 64))
+			return
+		}
+		out.WriteFloat(f)
+	case gql.StructType:
+		st := v.Struct(nil)
+		out.WriteString("{")
+		for fi := 0; fi < st.Len(); fi++ {
+			if fi > 0 {
+				out.WriteString(", ")
+			}
+			f := st.Field(fi)
+			writeJSONString(out, f.Name.Str())
+			out.WriteString(": ")
+			writeJSONValue(ctx, args, f.Value)
+		}
+		out.WriteString("}")
+	default:
+		buf := termutil.NewBufferPrinter()
+		v.Print(ctx, gql.PrintArgs{Out: buf, Mode: gql.PrintCompact, TmpVars: args.TmpVars})
+		writeJSONString(out, buf.String())
+	}
+}
+
+// RenderTable implements Renderer.
+func (jsonRenderer) RenderTable(ctx context.Context, args gql.PrintArgs, t gql.Table, start, limit int) {
+	page, more := tablePage(ctx, t, start, limit)
+	if start < 0 {
+		start = 0
+	}
+	args.Out.WriteString(`{"start": `)
+	args.Out.WriteInt(int64(start))
+	args.Out.WriteString(`, "rows": [`)
+	sc := page.Scanner(ctx, 0, 1, 1)
+	for n := 0; sc.Scan() && args.Out.Ok(); n++ {
+		if n > 0 {
+			args.Out.WriteString(", ")
+		}
+		writeJSONValue(ctx, args, sc.Value())
+	}
+	args.Out.WriteString(`], "more": ` + strconv.FormatBool(more) + "}\n")
+}
+
+// RenderScalar implements Renderer.
+func (jsonRenderer) RenderScalar(ctx context.Context, args gql.PrintArgs, v gql.Value) {
+	args.Out.WriteString(`{"value": `)
+	writeJSONValue(ctx, args, v)
+	args.Out.WriteString("}\n")
+}
+
+// RenderError implements Renderer.
+func (jsonRenderer) RenderError(ctx context.Context, args gql.PrintArgs, err error) {
+	args.Out.WriteString(`{"error": `)
+	writeJSONString(args.Out, err.Error())
+	args.Out.WriteString("}\n")
+}
+
+// defaultMaxHTMLTableCells is the max number of table cells rendered by the
+// renderer created by NewRenderer("html").
+const defaultMaxHTMLTableCells = 50000
+
+// htmlRenderer prints values as HTML fragments.
+type htmlRenderer struct {
+	maxTableCells int
+}
+
+// NewHTMLRenderer creates a renderer that prints values as HTML fragments.
+// Arg maxTableCells limits the size of a rendered table.
+func NewHTMLRenderer(maxTableCells int) Renderer {
+	return htmlRenderer{maxTableCells: maxTableCells}
+}
+
+// RenderTable implements Renderer.
+func (r htmlRenderer) RenderTable(ctx context.Context, args gql.PrintArgs, t gql.Table, start, limit int) {
+	page, more := tablePage(ctx, t, start, limit)
+	htmlArgs := args
+	htmlArgs.Out = termutil.NewHTMLPrinter(args.Out, r.maxTableCells)
+	htmlArgs.Mode = gql.PrintValues
+	gql.NewTable(page).Print(ctx, htmlArgs)
+	if more {
+		args.Out.WriteString("<small>More rows available</small>\n")
+	}
+}
+
+// RenderScalar implements Renderer.
+func (htmlRenderer) RenderScalar(ctx context.Context, args gql.PrintArgs, v gql.Value) {
+	buf := termutil.NewBufferPrinter()
+	bufArgs := args
+	bufArgs.Out = buf
+	v.Print(ctx, bufArgs)
+	args.Out.WriteString("<pre>" + html.EscapeString(buf.String()) + "</pre>\n")
+}
+
+// RenderError implements Renderer.
+func (htmlRenderer) RenderError(ctx context.Context, args gql.PrintArgs, err error) {
+	args.Out.WriteString(`<pre class="gql-error">` + html.EscapeString(err.Error()) + "</pre>\n")
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grailbio/gql/gql"
+	"github.com/grailbio/gql/gqltest"
+	"github.com/grailbio/gql/termutil"
+	"github.com/grailbio/testutil/expect"
+)
+
+func render(t *testing.T, r Renderer, expr string, start, limit int) string {
+	ctx := context.Background()
+	sess := gqltest.NewSession()
+	val := gqltest.Eval(t, expr, sess)
+	out := termutil.NewBufferPrinter()
+	args := gql.PrintArgs{Out: out, Mode: gql.PrintValues}
+	if val.Type() == gql.TableType {
+		r.RenderTable(ctx, args, val.Table(nil), start, limit)
+	} else {
+		r.RenderScalar(ctx, args, val)
+	}
+	return out.String()
+}
+
+func TestJSONRenderer(t *testing.T) {
+	r := NewJSONRenderer()
+	expect.EQ(t, render(t, r, `{a:10, b:"xy", c:NA}`, 0, 0), `{"value": {"a": 10, "b": "xy", "c": null}}`+"\n")
+	expect.EQ(t, render(t, r, `table({a:1}, {a:2}, {a:3})`, 1, 1), `{"start": 1, "rows": [{"a": 2}], "more": true}`+"\n")
+	expect.EQ(t, render(t, r, `table({a:1}, {a:2}, {a:3})`, 2, 0), `{"start": 2, "rows": [{"a": 3}], "more": false}`+"\n")
+
+	out := termutil.NewBufferPrinter()
+	r.RenderError(context.Background(), gql.PrintArgs{Out: out}, errors.New("bad"))
+	expect.EQ(t, out.String(), `{"error": "bad"}`+"\n")
+}
+
+func TestHTMLRenderer(t *testing.T) {
+	r := NewHTMLRenderer(1000)
+	expect.EQ(t, render(t, r, `"<b>"`, 0, 0), "<pre>&lt;b&gt;</pre>\n")
+	expect.HasSubstr(t, render(t, r, `table({a:"<i>"}, {a:"y"})`, 0, 1), "<td>&lt;i&gt;</td>")
+	expect.HasSubstr(t, render(t, r, `table({a:"<i>"}, {a:"y"})`, 0, 1), "More rows available")
+}
@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/gql"
+	"github.com/grailbio/gql/termutil"
+	"github.com/yasushi-saito/readline"
+)
+
+// A transcript is a Markdown file that records the statements run in the REPL.
+// Each statement is stored in a code block with info string "gql", followed by
+// a code block that stores the rendering of its value:
+//
+//   ```gql
+//   t0 := read("foo.tsv") | filter(&A > 10)
+//   ```
+//
+//   ```
+//   ...
+//   ```
+//
+// "\transcript replay" reexecutes the statements and reports those whose
+// outputs changed.
+
+const (
+	// transcriptMaxRows is the max number of table rows recorded for each
+	// statement.
+	transcriptMaxRows = 20
+	// transcriptMaxBytes is the max size of the output recorded for each
+	// statement.
+	transcriptMaxBytes = 16 << 10
+)
+
+// transcriptEntry is a statement and the rendering of its value.
+type transcriptEntry struct {
+	expr   string
+	output string
+}
+
+// transcriptFence returns a Markdown code fence that doesn't appear in s.
+func transcriptFence(s string) string {
+	fence := "```"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	return fence
+}
+
+// writeTranscriptEntry writes the entry as a pair of Markdown code blocks.
+func writeTranscriptEntry(w io.Writer, e transcriptEntry) error {
+	fence := transcriptFence(e.expr + "\n" + e.output)
+	_, err := fmt.Fprintf(w, "%sgql\n%s\n%s\n\n%s\n%s\n%s\n\n", fence, e.expr, fence, fence, e.output, fence)
+	return err
+}
+
+// parseTranscript parses the contents of a transcript file. Text outside the
+// code blocks is ignored, so the transcript may be annotated freely.
+func parseTranscript(data string) ([]transcriptEntry, error) {
+	var (
+		entries   []transcriptEntry
+		hasOutput bool // whether the output of the last entry was seen.
+		lines     = strings.Split(data, "\n")
+	)
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r")
+		if !strings.HasPrefix(line, "```") {
+			continue
+		}
+		fence := line[:len(line)-len(strings.TrimLeft(line, "`"))]
+		info := strings.TrimSpace(line[len(fence):])
+		start := i + 1
+		for i++; i < len(lines) && strings.TrimRight(lines[i], "\r") != fence; i++ {
+		}
+		if i >= len(lines) {
+			return nil, fmt.Errorf("line %d: unterminated code block", start)
+		}
+		block := strings.Join(lines[start:i], "\n")
+		switch {
+		case info == "gql":
+			entries = append(entries, transcriptEntry{expr: block})
+			hasOutput = false
+		case info == "" && len(entries) > 0 && !hasOutput:
+			entries[len(entries)-1].output = block
+			hasOutput = true
+		}
+	}
+	return entries, nil
+}
+
+// transcriptOutput renders the value for a transcript. Tables are truncated to
+// transcriptMaxRows rows.
+func transcriptOutput(ctx context.Context, val gql.Value) string {
+	out := termutil.NewBufferPrinter()
+	args := gql.PrintArgs{Out: out, Mode: gql.PrintValues}
+	r := NewTextRenderer()
+	if val.Type() == gql.TableType {
+		r.RenderTable(ctx, args, val.Table(nil), 0, transcriptMaxRows)
+	} else {
+		r.RenderScalar(ctx, args, val)
+	}
+	s := strings.TrimRight(out.String(), "\n")
+	if len(s) > transcriptMaxBytes {
+		s = s[:transcriptMaxBytes] + "\n(output truncated)"
+	}
+	return s
+}
+
+// transcriptError renders an error raised by a statement for a transcript.
+func transcriptError(err interface{}) string {
+	return fmt.Sprintf("error: %v", err)
+}
+
+// recordTranscript appends the statement and its output to the transcript, if
+// one is being recorded.
+func (c *Env) recordTranscript(expr, output string) {
+	if c.transcript == nil {
+		return
+	}
+	if err := writeTranscriptEntry(c.transcript, transcriptEntry{expr: expr, output: output}); err != nil {
+		log.Error.Printf("transcript %s: %v; recording stopped", c.transcript.Name(), err)
+		c.stopTranscript()
+	}
+}
+
+// startTranscript starts recording the statements to the given file. The file
+// is overwritten.
+func (c *Env) startTranscript(path string) {
+	c.stopTranscript()
+	f, err := os.Create(path)
+	if err != nil {
+		log.Error.Printf("transcript: %v", err)
+		return
+	}
+	if _, err := f.WriteString("# GQL transcript\n\n"); err != nil {
+		log.Error.Printf("transcript %s: %v", path, err)
+		f.Close() // nolint: errcheck
+		return
+	}
+	c.transcript = f
+}
+
+// stopTranscript stops recording the statements. It is a noop if no
+// transcript is being recorded.
+func (c *Env) stopTranscript() {
+	if c.transcript == nil {
+		return
+	}
+	if err := c.transcript.Close(); err != nil {
+		log.Error.Printf("transcript %s: %v", c.transcript.Name(), err)
+	}
+	c.transcript = nil
+}
+
+// evalTranscriptEntry evaluates the statement of the entry and returns its
+// rendering. An error raised by the statement is rendered in the same way as
+// when the transcript was recorded.
+func (c *Env) evalTranscriptEntry(ctx context.Context, expr string) (output string) {
+	defer func() {
+		if err := recover(); err != nil {
+			output = transcriptError(err)
+		}
+	}()
+	statements, err := c.sess.Parse("(stdin)", []byte(expr))
+	if err != nil {
+		return transcriptError(err)
+	}
+	ctx, cancel := c.sess.WithLimits(ctx)
+	defer cancel()
+	return transcriptOutput(ctx, c.sess.EvalStatements(ctx, statements))
+}
+
+// replayTranscript reexecutes the statements in the transcript file, and
+// reports to out the statements whose outputs differ from the recorded ones.
+// It returns the number of such statements.
+func (c *Env) replayTranscript(ctx context.Context, path string, out termutil.Printer) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := parseTranscript(string(data))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", path, err)
+	}
+	nChanged := 0
+	for i, e := range entries {
+		if ctx.Err() != nil {
+			return nChanged, ctx.Err()
+		}
+		output := c.evalTranscriptEntry(ctx, e.expr)
+		if output == e.output {
+			continue
+		}
+		nChanged++
+		out.WriteString(fmt.Sprintf("changed: statement %d: %s\n--- recorded\n%s\n--- now\n%s\n\n", i+1, e.expr, e.output, output))
+	}
+	out.WriteString(fmt.Sprintf("replayed %d statements, %d changed\n", len(entries), nChanged))
+	return nChanged, nil
+}
+
+// runTranscript implements the "\transcript" command.
+func (c *Env) runTranscript(ctx context.Context, args string) {
+	if err := readline.AddHistory(strings.TrimSpace(`\transcript ` + args)); err != nil {
+		log.Error.Printf("readline.AddHistory: %v", err)
+	}
+	tokens := strings.Fields(args)
+	switch {
+	case len(tokens) == 2 && tokens[0] == "on":
+		c.startTranscript(tokens[1])
+	case len(tokens) == 1 && tokens[0] == "off":
+		c.stopTranscript()
+	case len(tokens) == 2 && tokens[0] == "replay":
+		out := c.NewOutput()
+		defer out.Close()
+		if _, err := c.replayTranscript(ctx, tokens[1], out); err != nil {
+			log.Error.Printf("transcript replay: %v", err)
+		}
+	default:
+		log.Error.Printf(`transcript: usage: \transcript on file.md | off | replay file.md`)
+	}
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/gql/gqltest"
+	"github.com/grailbio/gql/termutil"
+	"github.com/grailbio/testutil"
+	"github.com/grailbio/testutil/expect"
+)
+
+func TestTranscriptFormat(t *testing.T) {
+	entries := []transcriptEntry{
+		{expr: "x := 10", output: "10"},
+		{expr: "read(`foo.tsv`)", output: "has ``` inside\nand more"},
+		{expr: "y", output: ""},
+	}
+	var buf bytes.Buffer
+	buf.WriteString("# Notes\n\nSome text.\n\n")
+	for _, e := range entries {
+		expect.NoError(t, writeTranscriptEntry(&buf, e))
+	}
+	got, err := parseTranscript(buf.String())
+	expect.NoError(t, err)
+	expect.EQ(t, got, entries)
+
+	_, err = parseTranscript("```gql\nx := 10\n")
+	expect.HasSubstr(t, err.Error(), "unterminated")
+}
+
+func TestTranscriptReplay(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	path := filepath.Join(tmpDir, "transcript.md")
+
+	c := NewWithOpts(gqltest.NewSession(), Opts{})
+	c.startTranscript(path)
+	for _, expr := range []string{"x := 10", "table({a:x}, {a:x+1})", "undefined_var + 1"} {
+		c.recordTranscript(expr, c.evalTranscriptEntry(ctx, expr))
+	}
+	c.stopTranscript()
+	data, err := ioutil.ReadFile(path)
+	expect.NoError(t, err)
+	expect.HasSubstr(t, string(data), "```gql\ntable({a:x}, {a:x+1})\n```")
+	expect.HasSubstr(t, string(data), "error: ")
+
+	out := termutil.NewBufferPrinter()
+	n, err := NewWithOpts(gqltest.NewSession(), Opts{}).replayTranscript(ctx, path, out)
+	expect.NoError(t, err)
+	expect.EQ(t, n, 0)
+	expect.HasSubstr(t, out.String(), "replayed 3 statements, 0 changed")
+
+	modified := strings.Replace(string(data), "x := 10", "x := 11", 1)
+	expect.NoError(t, ioutil.WriteFile(path, []byte(modified), 0600))
+	out = termutil.NewBufferPrinter()
+	n, err = NewWithOpts(gqltest.NewSession(), Opts{}).replayTranscript(ctx, path, out)
+	expect.NoError(t, err)
+	expect.EQ(t, n, 2)
+	expect.HasSubstr(t, out.String(), "changed: statement 2: table({a:x}, {a:x+1})")
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/gql"
+	"github.com/grailbio/gql/termutil"
+	"github.com/yasushi-saito/readline"
+)
+
+// defaultWatchInterval is the interval at which "\watch expr" reevaluates an
+// expression that reads no file.
+const defaultWatchInterval = 2 * time.Second
+
+// clearScreen is the ANSI escape sequence that clears the terminal and moves
+// the cursor to the top left corner.
+const clearScreen = "\033[H\033[2J"
+
+// parseWatchArgs parses the args of the "\watch" command, "[interval] expr".
+// The interval is either a duration such as "10s", or a number of seconds. It
+// returns interval=0 if the interval is not given.
+func parseWatchArgs(args string) (time.Duration, string, error) {
+	args = strings.TrimSpace(args)
+	if tokens := strings.SplitN(args, " ", 2); len(tokens) == 2 {
+		d, err := time.ParseDuration(tokens[0])
+		if err != nil {
+			var secs float64
+			if secs, err = strconv.ParseFloat(tokens[0], 64); err == nil {
+				d = time.Duration(secs * float64(time.Second))
+			}
+		}
+		if err == nil {
+			if d <= 0 {
+				return 0, "", fmt.Errorf("interval must be positive, but found %s", tokens[0])
+			}
+			return d, strings.TrimSpace(tokens[1]), nil
+		}
+	}
+	if args == "" {
+		return 0, "", fmt.Errorf("no expression given")
+	}
+	return 0, args, nil
+}
+
+// runWatch implements the "\watch" command. It evaluates the expression and
+// prints the result repeatedly until the user presses ^C.
+func (c *Env) runWatch(ctx context.Context, args string) {
+	if err := readline.AddHistory(strings.TrimSpace(`\watch ` + args)); err != nil {
+		log.Error.Printf("readline.AddHistory: %v", err)
+	}
+	interval, expr, err := parseWatchArgs(args)
+	if err != nil {
+		log.Error.Printf("watch: %v", err)
+		return
+	}
+	for {
+		var inputs gql.InputFiles
+		if interval > 0 {
+			c.renderWatch(ctx, fmt.Sprintf("Every %v: %s", interval, expr), expr)
+		} else {
+			stop := gql.RecordInputFiles()
+			func() {
+				defer func() { inputs = stop() }()
+				c.renderWatch(ctx, "On change: "+expr, expr)
+			}()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		wait := interval
+		if interval == 0 {
+			if len(inputs) > 0 {
+				if _, err := gql.WaitForInputChange(ctx, inputs, c.watchPollInterval); err != nil {
+					return
+				}
+				continue
+			}
+			// The expression reads no file, so poll it.
+			wait = defaultWatchInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// renderWatch clears the screen, then evaluates the expression and prints its
+// value below the header. A table is truncated to fit the screen.
+func (c *Env) renderWatch(ctx context.Context, header, expr string) {
+	out := termutil.NewBatchPrinter(os.Stdout)
+	defer out.Close()
+	if c.interactive {
+		out.WriteString(clearScreen)
+	}
+	out.WriteString(fmt.Sprintf("%s\t%s\n\n", header, time.Now().Format("2006-01-02 15:04:05")))
+	statements, err := c.sess.Parse("(watch)", []byte(expr))
+	if err != nil {
+		log.Error.Printf("watch: %v", err)
+		return
+	}
+	args := gql.PrintArgs{Out: out, Mode: gql.PrintValues}
+	defer c.recoverAndRenderError(ctx, args)
+	val := c.sess.EvalStatements(ctx, statements)
+	if val.Type() != gql.TableType {
+		c.renderer.RenderScalar(ctx, args, val)
+		return
+	}
+	_, limit := termutil.ScreenSize()
+	if c.pageSize > 0 && c.pageSize < limit {
+		limit = c.pageSize
+	}
+	c.renderer.RenderTable(ctx, args, val.Table(nil), 0, limit)
+}
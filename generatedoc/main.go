@@ -22,7 +22,7 @@ import (
 	"github.com/grailbio/base/must"
 	"github.com/grailbio/base/vcontext"
 	"github.com/grailbio/gql/gql"
-	"github.com/grailbio/gql/lib"
+	_ "github.com/grailbio/gql/lib" // Registers the standard library.
 	"github.com/grailbio/gql/symbol"
 	"github.com/grailbio/gql/termutil"
 )
@@ -76,9 +76,9 @@ func builtinFunctionsDoc() string {
 		translateDoc(fmt.Sprintf("#### %s\n\n%s\n\n", name, gql.DescribeValue(val)), out)
 	}
 	out.WriteString("### Table manipulation\n\n")
-	for _, name := range []string{"map", "filter", "reduce", "flatten", "concat", "cogroup",
-		"firstn", "minn", "sort", "join", "transpose", "gather", "spread", "collapse", "joinbed", "count", "pick",
-		"table", "readdir", "table_attrs", "force"} {
+	for _, name := range []string{"map", "filter", "reduce", "flatten", "flatmap", "concat", "union", "intersect", "except", "zip", "cross", "enumerate", "batch", "cogroup",
+		"firstn", "minn", "sort", "join", "transpose", "gather", "spread", "collapse", "to_long", "to_wide", "shuffle", "split", "sample_by", "joinbed", "genome", "genome_bins", "bin_assign", "count", "count_if", "sum_if", "count_distinct", "pick",
+		"table", "range", "repeat", "dates", "readdir", "table_attrs", "with_attrs", "force", "matview", "mask"} {
 		showHelp(name)
 	}
 	mark := func(ops []string) {
@@ -156,14 +156,14 @@ As an syntax suger, you can omit the "var." part if var is "_". So "map($x,
 
 `)
 
-	for _, name := range []string{"unionrow", "optionalfield", "contains"} {
+	for _, name := range []string{"unionrow", "optionalfield", "with_defaults", "contains"} {
 		showHelp(name)
 	}
 	out.WriteString("### File I/O\n\n")
-	for _, name := range []string{"read", "write", "writecols"} {
+	for _, name := range []string{"read", "write", "tee", "writecols", "write_matrix", "check_dict", "missing_columns", "build_index", "lookup"} {
 		showHelp(name)
 	}
-	mark([]string{"infix:==", "infix:!=", "infix:>=", "infix:>", "infix:==?", "infix:?==", "infix:?==?"})
+	mark([]string{"infix:==", "infix:!=", "infix:>=", "infix:>", "infix:==?", "infix:?==", "infix:?==?", "infix:>?", "infix:>=?"})
 	translateDoc(`### Predicates
 
     expr0 == expr1
@@ -178,22 +178,46 @@ As an syntax suger, you can omit the "var." part if var is "_". So "map($x,
     expr0 ==? expr1
     expr0 ?==? expr1
 
+    expr0 >? expr1
+    expr0 >=? expr1
+    expr0 <? expr1
+    expr0 <=? expr1
+
 These predicates can be applied to any scalar values, including
 ints, floats, strings, chars, and dates, and nulls.
 The two sides of the operator must be of the same type, or null.
 A null value is treated as ∞. Thus, 1 < NA, but 1 > -NA
 
+Comparison operators can also be applied to structs and tables. Structs are
+compared field by field, and they must have the same field names in the same
+order. Thus, {a:1, b:2} == {a:1, b:2}, and {a:1, b:2} < {a:1, b:3}.  Tables are
+compared row by row. A table with more than 100000 rows can be compared only
+with a table with the same hash, e.g., the same expression.
+
 Predicates "==?", "?==", "?==?" are the same as "==", as long as both sides are
 non-null. "X==?Y" is true if either X==Y, or Y is null. "X?==Y" is true if
 either X==Y, or X is null. "X?==?Y" is true if either X==Y, or X is null, or Y
 is null. These predicates can be used to do outer, left, or right joins with
 join builtin.
 
+Predicates ">?", ">=?", "<?", and "<=?" are the same as ">", ">=", "<", and
+"<=", as long as both sides are non-null. They are false if either side is
+null. Thus, 1 <? NA and NA >? 1 are both false. They are useful for threshold
+filters, since "filter(&depth > 100)" keeps the rows whose depth is NA, whereas
+"filter(&depth >? 100)" drops them. They can also express range conditions of
+join, e.g., "t0.pos >=? t1.start && t0.pos <? t1.end".
+
 `, out)
 
 	mark([]string{"infix:%", "infix:*", "infix:/", "infix:+", "infix:-", "prefix:-", "min", "max"})
 
+	showHelp("between")
+	showHelp("clamp")
+
 	showHelp("isnull")
+	showHelp("is_nan")
+	showHelp("is_inf")
+	showHelp("isin")
 	showHelp("istable")
 	showHelp("isstruct")
 
@@ -232,17 +256,25 @@ operator must be of the same type.
 
 	showHelp("string_count")
 	showHelp("regexp_match")
+	showHelp("like")
 	showHelp("regexp_replace")
 	showHelp("string_len")
 	showHelp("string_has_suffix")
 	showHelp("string_has_prefix")
 	showHelp("string_replace")
 	showHelp("substring")
+	showHelp("normalize_chrom")
 	showHelp("sprintf")
 	showHelp("string")
 	showHelp("int")
+	showHelp("try_int")
 	showHelp("float")
+	showHelp("convert_unit")
 	showHelp("hash64")
+	showHelp("hash64_seed")
+	showHelp("bucket")
+	showHelp("consistent_bucket")
+	showHelp("row_hash")
 	showHelp("land")
 	showHelp("lor")
 
@@ -255,8 +287,19 @@ operator must be of the same type.
 
 `, out)
 
+	out.WriteString("### Matrices\n\n")
+	for _, name := range []string{"to_matrix", "matrix_table", "mtranspose", "matmul", "madd", "msub", "mmul", "mdiv",
+		"mscale", "mrowagg", "mcolagg"} {
+		showHelp(name)
+	}
+
+	out.WriteString("### Statistics\n\n")
+	for _, name := range []string{"pca", "kmeans", "hclust", "t_test", "wilcoxon", "fisher_exact", "chisq", "bh_adjust", "bootstrap", "permute_test"} {
+		showHelp(name)
+	}
+
 	out.WriteString("### Miscellaneous functions\n\n")
-	for _, name := range []string{"print"} {
+	for _, name := range []string{"print", "notify", "tmpvars", "stdlib", "requires_version", "jobs", "job_logs"} {
 		showHelp(name)
 	}
 
@@ -420,10 +463,5 @@ func main() {
 	}
 	gql.Init(gql.Opts{OverwriteFiles: true})
 	sess = gql.NewSession()
-	lib, err := sess.Parse("lib", []byte(lib.Script))
-	if err != nil {
-		log.Panicf("load lib: %v", err)
-	}
-	sess.EvalStatements(ctx, lib)
 	generateDoc()
 }
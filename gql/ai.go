@@ -23,6 +23,10 @@ type AIType struct {
 	// Any is set when the type is unknown. An any type matches anything.
 	Any bool
 
+	// Const is set for a variable bound by "const var := expr". Such a variable
+	// cannot be rebound.
+	Const bool
+
 	// The following fields are set iff Type==FuncType
 
 	// Formal args to the function.
@@ -45,6 +49,7 @@ var (
 	AIStringType = AIType{Type: StringType}
 	AIStructType = AIType{Type: StructType}
 	AITableType  = AIType{Type: TableType}
+	AIMatrixType = AIType{Type: MatrixType}
 )
 
 // Is checks if t is of the given type. It always returns true if t.Any==true.
@@ -163,6 +168,17 @@ func (b aiBindings) Lookup(sym symbol.ID) (AIType, bool) {
 	return AIType{}, false
 }
 
+// names returns the names of all the variables in the bindings, including the
+// builtin functions and consts.
+func (b aiBindings) names() (vars []symbol.ID) {
+	for _, frame := range b.Frames {
+		for sym := range frame {
+			vars = append(vars, sym)
+		}
+	}
+	return vars
+}
+
 // setGlobal sets variable type in the global mutable frame (frames[1]).
 //
 // REQUIRES: sym is not a builtin function or value.
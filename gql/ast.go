@@ -68,13 +68,17 @@ type ASTStatement struct {
 	// Expr is the right-hand side of "var := expr". It is also set when the
 	// statement is a naked expression.
 	Expr ASTNode
+
+	// Const is set when the statement is of form "const var := expr". Expr must
+	// be a compile-time constant, and var cannot be rebound in the same scope.
+	Const bool
 }
 
 // ASTStatementOrLoad is a toplevel gql construct.
 type ASTStatementOrLoad struct {
 	ASTStatement
 	// Load is set when the statement is of form "load `path`". The value is the
-	// pathname. Other fields are unset.
+	// pathname. Pos is the location of the statement. Other fields are unset.
 	LoadPath string
 }
 
@@ -90,6 +94,9 @@ func (s ASTStatement) String() string {
 	if s.LHS == symbol.Invalid {
 		return s.Expr.String()
 	}
+	if s.Const {
+		return fmt.Sprintf("const %s:=%s", s.LHS.Str(), s.Expr)
+	}
 	return fmt.Sprintf("%s:=%s", s.LHS.Str(), s.Expr)
 }
 
@@ -207,6 +214,10 @@ type ASTLambda struct {
 	Args []FormalArg
 	// Body is the function body.
 	Body ASTNode
+
+	// legacy is set if the function is written in the deprecated form
+	// "func(args) {...}".
+	legacy *legacyFunctionBody
 }
 
 var _ ASTNode = &ASTLambda{}
@@ -576,10 +587,49 @@ func (n *ASTFuncall) eval(ctx context.Context, env *bindings) Value {
 	if !n.Analyzed {
 		Panicf(n, "analyze not called")
 	}
+	src, ok := n.pipeSource()
+	if !ok {
+		return n.call(ctx, env, nil)
+	}
+	// Evaluate a chain of pipes "a | f0(...) | f1(...) | ... | fn(...)" using an
+	// explicit stack instead of recursing through the pipe sources, so that a
+	// very long pipeline doesn't grow the Go stack.
+	stages := []*ASTFuncall{n}
+	for ok {
+		stages = append(stages, src)
+		src, ok = src.pipeSource()
+	}
+	val := stages[len(stages)-1].call(ctx, env, nil)
+	for i := len(stages) - 2; i >= 0; i-- {
+		val = stages[i].call(ctx, env, &val)
+	}
+	return val
+}
+
+// pipeSource returns the function call on the left-hand side of the pipe if n
+// is of form "fun0(...) | fun1(...)". The pipe source is always the first arg
+// of the function. It returns false if n is not a pipe, or the source is not a
+// function call.
+func (n *ASTFuncall) pipeSource() (*ASTFuncall, bool) {
+	if len(n.Raw) == 0 || !n.Raw[0].PipeSource || len(n.Args) == 0 || n.Args[0].Symbol != symbol.Invalid {
+		return nil, false
+	}
+	src, ok := n.Args[0].Expr.(*ASTFuncall)
+	if !ok || !src.Analyzed {
+		return nil, false
+	}
+	return src, true
+}
+
+// call evaluates the args and invokes the function. If src!=nil, it is used as
+// the value of the first arg instead of evaluating the pipe source.
+func (n *ASTFuncall) call(ctx context.Context, env *bindings, src *Value) Value {
 	f := n.Function.eval(ctx, env).Func(n)
 	actualArgs := actualArgPool.Get()
-	for _, at := range n.Args {
+	for i, at := range n.Args {
 		switch {
+		case i == 0 && src != nil:
+			actualArgs = append(actualArgs, ActualArg{Name: at.Name, Value: *src, Expr: at.Expr})
 		case at.Symbol != symbol.Invalid:
 			actualArgs = append(actualArgs, ActualArg{Name: at.Name, Symbol: at.Symbol, Expr: at.Expr})
 		case at.Expr != nil: // eager arg
@@ -682,7 +732,7 @@ func (n *ASTVarRef) hash(b *bindings) hash.Hash {
 		0x47, 0x97, 0xbf, 0xbc, 0x09, 0x9f, 0x3b, 0x36}
 	val, ok := b.Lookup(n.Var)
 	if !ok {
-		Panicf(n, "variable not found, bindings are: %+v", b.Describe())
+		Panicf(n, "%s", variableNotFoundError(n.Var, b.names(), b.Describe))
 	}
 	return h.Merge(n.Var.Hash()).Merge(val.Hash())
 }
@@ -691,7 +741,7 @@ func (n *ASTVarRef) eval(ctx context.Context, env *bindings) Value {
 	if val, ok := env.Lookup(n.Var); ok {
 		return val
 	}
-	Panicf(n, "variable not found; registered variables are:\n%v", env.Describe())
+	Panicf(n, "%s", variableNotFoundError(n.Var, env.names(), env.Describe))
 	return Value{}
 }
 
@@ -733,10 +783,11 @@ func (n *ASTColumnRef) eval(ctx context.Context, env *bindings) Value {
 	if row.Type() != StructType {
 		Panicf(n, "row not a struct type, but %v", row)
 	}
-	if val, ok := row.Struct(astUnknown).Value(n.Col); ok {
+	st := row.Struct(astUnknown)
+	if val, ok := st.Value(n.Col); ok {
 		return val
 	}
-	Panicf(n, "column not found. Row contents are: %v", row)
+	Panicf(n, "column '%s' not found%s", n.Col.Str(), columnNotFoundHint(n.Col, st))
 	return Value{}
 }
 
@@ -909,4 +960,5 @@ func init() {
 	gob.Register(&ASTStructFieldRef{})
 	gob.Register(&ASTStructFieldRegex{})
 	gob.Register(&ASTBlock{})
+	gob.Register(&ASTSharedTable{})
 }
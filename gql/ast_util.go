@@ -6,7 +6,6 @@ import (
 	"context"
 	"fmt"
 	"strings"
-	"sync"
 
 	"github.com/grailbio/gql/symbol"
 )
@@ -15,6 +14,7 @@ import (
 // in the program. Use add to register nodes in a tree. Thread compatible.
 type astTypes struct {
 	types map[ASTNode]AIType
+	depth int // current nesting depth of add() calls.
 }
 
 // NewASTTypes creates an empty astTypes.
@@ -24,6 +24,12 @@ func newASTTypes() *astTypes {
 
 // Add analyzes the types of the given node and its subtree.
 func (t *astTypes) add(n ASTNode, env *aiBindings) (typ AIType) {
+	t.depth++
+	defer func() { t.depth-- }()
+	if t.depth > maxExprDepth {
+		Panicf(n, "expression is nested more than %d levels deep (e.g., a pipeline with too many stages); "+
+			"split it into multiple statements, or raise the limit with -max-expr-depth", maxExprDepth)
+	}
 	switch n := n.(type) {
 	case *ASTLiteral:
 		typ = t.addLiteral(n)
@@ -85,7 +91,7 @@ func (t *astTypes) add(n ASTNode, env *aiBindings) (typ AIType) {
 		var ok bool
 		typ, ok = env.Lookup(n.Var)
 		if !ok {
-			Panicf(n, "analyze: variable not found, bindings are: %+v", env)
+			Panicf(n, "analyze: %s", variableNotFoundError(n.Var, env.names(), env.String))
 		}
 	case *ASTColumnRef:
 		name := symbol.AnonRow
@@ -109,6 +115,8 @@ func (t *astTypes) add(n ASTNode, env *aiBindings) (typ AIType) {
 		if !typ.Is(StructType) {
 			Panicf(n, "regex: not a struct (is %+v)", typ)
 		}
+	case *ASTImplicitColumnRef:
+		checkNoImplicitColumnRef(n)
 	default:
 		Panicf(n, "Unknown AST type")
 	}
@@ -151,6 +159,7 @@ func (t *astTypes) addLiteral(n *ASTLiteral) AIType {
 
 // addLambda is called by add() to analyze a function literal.
 func (t *astTypes) addLambda(n *ASTLambda, env *aiBindings) AIType {
+	replaceLambdaColumnRef(n)
 	newFrame := aiFrame{}
 	for _, arg := range n.Args {
 		if !arg.Required { // we don't support exotic args in lambdas yet.
@@ -167,6 +176,67 @@ func (t *astTypes) addLambda(n *ASTLambda, env *aiBindings) AIType {
 	}
 }
 
+// ShadowingCheck specifies how the analyzer treats a binding in a block that
+// hides another variable of the same name, e.g., "x" in "{x := x * 10; ...}".
+// The block-local variable is visible only in the rest of the block, and the
+// shadowed variable is unchanged.
+type ShadowingCheck int
+
+const (
+	// ShadowingAllow silently allows shadowing. It is the default.
+	ShadowingAllow ShadowingCheck = iota
+	// ShadowingWarn logs a warning for each shadowing binding.
+	ShadowingWarn
+	// ShadowingError causes a shadowing binding to fail the analysis.
+	ShadowingError
+)
+
+// ParseShadowingCheck parses "allow", "warn", or "error".
+func ParseShadowingCheck(s string) (ShadowingCheck, error) {
+	switch s {
+	case "allow", "":
+		return ShadowingAllow, nil
+	case "warn":
+		return ShadowingWarn, nil
+	case "error":
+		return ShadowingError, nil
+	}
+	return ShadowingAllow, fmt.Errorf("shadowing check '%s': must be one of allow, warn, or error", s)
+}
+
+// checkBinding checks the statement "s.LHS := s.Expr" that binds a variable in
+// the innermost frame of env. A const variable cannot be rebound. If
+// local=true, the statement is in a block, and shadowing of another variable
+// is reported as specified by shadowingCheck.
+func checkBinding(s *ASTStatement, env aiBindings, local bool) {
+	old, found := env.Lookup(s.LHS)
+	if !found {
+		return
+	}
+	if _, inFrame := env.Frames[len(env.Frames)-1][s.LHS]; inFrame && old.Const {
+		Panicf(s.Expr, "cannot rebind const variable '%s'", s.LHS.Str())
+	}
+	if !local {
+		return
+	}
+	switch shadowingCheck {
+	case ShadowingWarn:
+		Errorf(s.Expr, "warning: '%s := ...' shadows another variable of the same name", s.LHS.Str())
+	case ShadowingError:
+		Panicf(s.Expr, "'%s := ...' shadows another variable of the same name", s.LHS.Str())
+	}
+}
+
+// constType checks that the value of "const var := expr" is a compile-time
+// constant, and returns the type of the variable.
+func constType(s *ASTStatement, typ AIType) AIType {
+	if typ.Literal == nil {
+		Panicf(s.Expr, "const %s: the value is not a compile-time constant", s.LHS.Str())
+	}
+	typ.Const = true
+	return typ
+}
+
 // addLambda is called by add() to analyze '{ exprs... }'
 func (t *astTypes) addBlock(n *ASTBlock, env *aiBindings) AIType {
 	newFrame := aiFrame{}
@@ -179,9 +249,17 @@ func (t *astTypes) addBlock(n *ASTBlock, env *aiBindings) AIType {
 		s := &n.Statements[i]
 		typ = t.add(s.Expr, &newEnv)
 		if s.LHS != symbol.Invalid {
+			checkBinding(s, newEnv, true)
+			if s.Const {
+				typ = constType(s, typ)
+			}
 			newFrame[s.LHS] = typ
 		}
 	}
+	if typ.Const {
+		// The value of the block is not a variable.
+		typ.Const = false
+	}
 	return typ
 }
 
@@ -248,17 +326,20 @@ func (t *astTypes) addFuncall(n *ASTFuncall, env *aiBindings) AIType {
 		//
 		//   table | filter(|_|_.x==10) | sort(|_|_.y)
 		if !arg.PipeSource {
+			if isOperatorCall(n) {
+				// A '&' in an operand of an infix or prefix operator is left unexpanded
+				// only if the operator is outside any function-call arg or one-arg
+				// function.
+				checkNoImplicitColumnRef(expr)
+			}
 			replaceImplicitColumnRef(&expr)
 		}
-		warnDeprecatedColumnRef(expr)
 		_, argIsLambda := expr.(*ASTLambda)
 		switch {
 		case farg.Closure && !argIsLambda:
-			if len(farg.ClosureArgs) == 0 {
-				Panicf(n, "No closure arg supplied")
-			}
 			// Translate the "expr" to "func(args..) { expr }", where args... are
-			// names listed in farg.ClosureArgs.
+			// names listed in farg.ClosureArgs. If ClosureArgs is empty, the
+			// closure takes no arg, i.e., the expr is evaluated lazily.
 			var cargNames []string
 			for _, carg := range farg.ClosureArgs {
 				name := carg.Name
@@ -443,6 +524,8 @@ func astChildren(root ASTNode) (c []*ASTNode) {
 		c = append(c, &n.Parent)
 	case *ASTStructFieldRegex:
 		c = append(c, &n.parent)
+	case *ASTSharedTable:
+		c = append(c, &n.Expr)
 	default:
 		Panicf(n, "Invalid node type")
 	}
@@ -484,23 +567,14 @@ func hasColumnVarRef(root *ASTNode) bool {
 	return hasRef
 }
 
-var warnDeprecatedColumnRefOnce sync.Once
-
-func warnDeprecatedColumnRef(root ASTNode) {
-	visitRawASTTree(&root,
-		func(nptr *ASTNode) bool {
-			if n, ok := (*nptr).(*ASTColumnRef); ok && n.Deprecated {
-				warnDeprecatedColumnRefOnce.Do(func() {
-					Errorf(n, "$-syntax is deprecated. Use & instead. See https://phabricator.grailbio.com/w/docs/gql/#8-expansion for more details.")
-				})
-			}
-			return true
-		})
-}
-
 // replaceImplicitColumnRef rewrites an expression containing &xxx into
-// func(_){_.xxx}.
+// func(_){_.xxx}. If the expression is a function itself, e.g., "|row| &xxx",
+// it is left as is; replaceLambdaColumnRef expands '&' relative to the
+// function's arg.
 func replaceImplicitColumnRef(nptr *ASTNode) bool {
+	if _, ok := (*nptr).(*ASTLambda); ok {
+		return false
+	}
 	if !hasColumnVarRef(nptr) {
 		return false
 	}
@@ -525,6 +599,48 @@ func replaceImplicitColumnRef(nptr *ASTNode) bool {
 	return true
 }
 
+// isOperatorCall checks if n is an infix or prefix operator, such as "x+y" or
+// "!x".
+func isOperatorCall(n *ASTFuncall) bool {
+	_, ok := n.Function.(*ASTLiteral)
+	return ok
+}
+
+// replaceLambdaColumnRef rewrites "&xxx" in the body of a one-arg function
+// "|row| ...&xxx..." into "row.xxx". It doesn't look inside nested functions,
+// or args of function calls other than operators; '&' in a function-call arg
+// is expanded by replaceImplicitColumnRef into a function of its own.
+func replaceLambdaColumnRef(n *ASTLambda) {
+	visitRawASTTree(&n.Body, func(nptr *ASTNode) bool {
+		switch v := (*nptr).(type) {
+		case *ASTLambda:
+			return false
+		case *ASTFuncall:
+			return isOperatorCall(v)
+		case *ASTImplicitColumnRef:
+			if len(n.Args) != 1 {
+				Panicf(v, "'&%s' is ambiguous in a function with %d args; write 'arg.%s', where arg is one of the function args",
+					v.Col.Str(), len(n.Args), v.Col.Str())
+			}
+			*nptr = &ASTStructFieldRef{Parent: &ASTVarRef{Pos: v.Pos, Var: n.Args[0].Name}, Field: v.Col}
+		}
+		return true
+	})
+}
+
+// checkNoImplicitColumnRef panics with a message that explains where '&' can
+// be used if root contains an unexpanded "&xxx".
+func checkNoImplicitColumnRef(root ASTNode) {
+	visitRawASTTree(&root, func(nptr *ASTNode) bool {
+		if v, ok := (*nptr).(*ASTImplicitColumnRef); ok {
+			Panicf(v, "'&%s' can appear only in an argument to a function call, such as map(&%s), "+
+				"or in the body of a one-arg function, such as |row| &%s. Write '_.%s' or 'row.%s' instead",
+				v.Col.Str(), v.Col.Str(), v.Col.Str(), v.Col.Str(), v.Col.Str())
+		}
+		return true
+	})
+}
+
 // replaceConstExprWithLiteral replaces an AST node that refers to a
 // compile-time constant with the constant itself.
 func replaceConstExprWithLiteral(t *astTypes, nptr *ASTNode) {
@@ -546,4 +662,7 @@ func replaceConstExprWithLiteral(t *astTypes, nptr *ASTNode) {
 // REQUIRES: the type info of all the descendant nodes have been added to *t.
 func transformAST(t *astTypes, nptr *ASTNode) {
 	replaceConstExprWithLiteral(t, nptr)
+	if shareSubexprs {
+		shareTableSubexprs(t, nptr)
+	}
 }
@@ -150,6 +150,7 @@ func (t *bamTable) getShards(total int) (bamprovider.Provider, []gbam.Shard, err
 type bamTableScanner struct {
 	ctx      context.Context
 	parent   *bamTable
+	budget   *evalBudget
 	provider bamprovider.Provider
 	shards   []gbam.Shard
 	iter     bamprovider.Iterator
@@ -180,6 +181,7 @@ func (s *bamTableScanner) Scan() bool {
 		s.row.record = s.iter.Record()
 		InitStruct(&s.row)
 		s.nrow++
+		s.budget.addRows(1)
 		if s.nrow%1e7 == 0 {
 			Logf(s.parent.ast, "[%d,%d)/%d: read %d rows", s.start, s.limit, s.total, s.nrow)
 		}
@@ -230,6 +232,7 @@ func (t *bamTable) Scanner(ctx context.Context, start, limit, total int) TableSc
 	s := &bamTableScanner{
 		ctx:      ctx,
 		parent:   t,
+		budget:   evalBudgetFromContext(ctx),
 		provider: provider,
 		shards:   shards[beg:end],
 		start:    start,
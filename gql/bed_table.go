@@ -5,6 +5,7 @@ import (
 
 	"github.com/grailbio/base/file"
 	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
 )
 
 // BEDFileHandler is a FileHandler implementation for BED files.  BED is just a
@@ -29,6 +30,13 @@ func (fh *bedFileHandler) Open(ctx context.Context, path string, ast ASTNode, ha
 
 // Write implements FileHandler.
 func (*bedFileHandler) Write(ctx context.Context, path string, ast ASTNode, table Table, nShard int, overwrite bool) {
+	writeBEDFile(ctx, path, ast, table, overwrite, false)
+}
+
+// writeBEDFile writes the table to a BED file. If the path ends with ".gz", the
+// file is gzip-compressed. If bgzip is true, it is compressed in the BGZF
+// format, so that it can be indexed by tabix.
+func writeBEDFile(ctx context.Context, path string, ast ASTNode, table Table, overwrite, bgzip bool) {
 	if _, err := file.Stat(ctx, path); err == nil {
 		if !overwrite {
 			Logf(ast, "write %v: file already exists and --overwrite-files=false.", path)
@@ -36,9 +44,13 @@ func (*bedFileHandler) Write(ctx context.Context, path string, ast ASTNode, tabl
 		}
 		file.RemoveAll(ctx, path)
 	}
-	WriteTSV(ctx, path, table, false, false)
+	opts := tsvWriterOpts{bgzip: bgzip}
+	gzip := tsvGzipOutput(path, opts)
+	writeTSVHelper(ctx, func(colIDs []symbol.ID) tsvWriter {
+		return newDefaultTSVWriter(ctx, path, colIDs, false, gzip, opts)
+	}, "", table, gzip, nil)
 }
 
 func init() {
-	RegisterFileHandler(singletonBEDFileHandler, `\.bed$`, `\.bed\.count$`)
+	RegisterFileHandler(singletonBEDFileHandler, `\.bed`+OptionalCompression, `\.bed\.count$`)
 }
@@ -0,0 +1,61 @@
+package gql
+
+import (
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/grailbio/testutil/h"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockClosureEscape(t *testing.T) {
+	sess := newSession()
+	doEval(t, "f := { x := 10; |y| x + y }", sess)
+	assert.Equal(t, int64(11), doEval(t, "f(1)", sess).Int(nil))
+	// Shadowing x at the call site doesn't change the captured value.
+	assert.Equal(t, int64(11), doEval(t, "{ x := 100; f(1) }", sess).Int(nil))
+
+	doEval(t, "g := |n| { m := n * 2; |y| m + y }", sess)
+	assert.Equal(t, int64(57), doEval(t, "{ g2 := g(2); g3 := g(3); g2(1) * 10 + g3(1) }", sess).Int(nil))
+	assert.Equal(t, int64(6), doEval(t, "g(3)(0)", sess).Int(nil))
+	assert.Equal(t, int64(4), doEval(t, "{ x := 1; y := { x := x * 2; x + 1 }; x + y }", sess).Int(nil))
+}
+
+func TestConst(t *testing.T) {
+	sess := newSession()
+	doEval(t, "const blockTestK := 10 * 3", sess)
+	assert.Equal(t, int64(31), doEval(t, "blockTestK + 1", sess).Int(nil))
+	assert.Equal(t, int64(34), doEval(t, "{ const k := blockTestK + 2; k + 2 }", sess).Int(nil))
+
+	assert.Equal(t, []string{"31", "32"}, doReadTable(doEval(t, "table(1, 2) | map(_ + blockTestK)", sess)))
+
+	expect.That(t,
+		func() { doEval(t, "{ const k := 1; k := 2; k }", sess) },
+		h.Panics(h.Regexp("cannot rebind const variable 'k'")))
+	expect.That(t,
+		func() { doEval(t, "{ const k := count(read(`foo.tsv`)); k }", sess) },
+		h.Panics(h.Regexp("const k: the value is not a compile-time constant")))
+}
+
+func TestShadowingCheck(t *testing.T) {
+	sess := newSession()
+	old := shadowingCheck
+	defer func() { shadowingCheck = old }()
+
+	doEval(t, "shadowTestX := 10", sess)
+	shadowingCheck = ShadowingAllow
+	assert.Equal(t, int64(101), doEval(t, "{ shadowTestX := shadowTestX * 10; shadowTestX + 1 }", sess).Int(nil))
+	shadowingCheck = ShadowingWarn
+	assert.Equal(t, int64(101), doEval(t, "{ shadowTestX := shadowTestX * 10; shadowTestX + 1 }", sess).Int(nil))
+	shadowingCheck = ShadowingError
+	expect.That(t,
+		func() { doEval(t, "{ shadowTestX := 1; shadowTestX }", sess) },
+		h.Panics(h.Regexp("'shadowTestX := ...' shadows another variable")))
+	expect.That(t,
+		func() { doEval(t, "|shadowTestY| { shadowTestY := 1; shadowTestY }", sess) },
+		h.Panics(h.Regexp("'shadowTestY := ...' shadows another variable")))
+	assert.Equal(t, int64(11), doEval(t, "{ shadowTestZ := shadowTestX + 1; shadowTestZ }", sess).Int(nil))
+
+	_, err := ParseShadowingCheck("bogus")
+	assert.Error(t, err)
+}
@@ -0,0 +1,100 @@
+package gql
+
+// This file implements dictionary encoding of strings in btsv files, and
+// interning of strings decoded by the btsv scanner.
+//
+// When dictionary encoding is enabled, BTSVShardWriter assigns a dense ID to
+// each distinct string, filename, or enum value, and stores the ID in the cell
+// instead of the string. The ID->string mappings are stored in the shard
+// trailer as BinaryTSVIndex.StringDict. A dictionary-encoded cell is marked by
+// btsvDictEncodedTag in its type byte. Files written without dictionary
+// encoding have no such cells, so the reader handles both formats.
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+const (
+	// btsvDictEncodedTag is ORed to the type byte of a dictionary-encoded cell.
+	btsvDictEncodedTag = 0x80
+
+	// maxBTSVStringDictSize is the max number of entries in a shard's string
+	// dictionary. Once the dictionary is full, new strings are stored inline.
+	maxBTSVStringDictSize = 1 << 16
+
+	// maxBTSVDictStringLen is the max length of a string stored in the
+	// dictionary. Longer strings are unlikely to repeat.
+	maxBTSVDictStringLen = 256
+
+	// maxBTSVInternedStrings is the max number of strings interned by one
+	// scanner.
+	maxBTSVInternedStrings = 1 << 14
+
+	// maxBTSVInternedStringLen is the max length of a string interned by the
+	// scanner.
+	maxBTSVInternedStringLen = 64
+)
+
+// btsvStringDict builds the string dictionary of a btsv shard.
+//
+// REQUIRES: the caller must serialize calls.
+type btsvStringDict struct {
+	ids     map[string]int
+	strings []string
+}
+
+func newBTSVStringDict() *btsvStringDict {
+	return &btsvStringDict{ids: map[string]int{}}
+}
+
+// id returns the ID of the given string. It returns false if the string is
+// not in the dictionary and cannot be added.
+func (d *btsvStringDict) id(s string) (int, bool) {
+	if id, ok := d.ids[s]; ok {
+		return id, true
+	}
+	if len(s) > maxBTSVDictStringLen || len(d.strings) >= maxBTSVStringDictSize {
+		return -1, false
+	}
+	id := len(d.strings)
+	d.ids[s] = id
+	d.strings = append(d.strings, s)
+	return id, true
+}
+
+// btsvStringInterner deduplicates strings decoded by a btsv scanner, so that
+// rows of a low-cardinality column share the same string bytes.
+type btsvStringInterner struct {
+	strings map[string]string
+	tmp     []byte
+}
+
+// intern returns a string with the given contents. The arg data is not
+// retained.
+func (in *btsvStringInterner) intern(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	if len(data) > maxBTSVInternedStringLen {
+		return string(data)
+	}
+	if s, ok := in.strings[string(data)]; ok { // This lookup doesn't allocate.
+		return s
+	}
+	s := string(data)
+	if in.strings == nil {
+		in.strings = map[string]string{}
+	}
+	if len(in.strings) < maxBTSVInternedStrings {
+		in.strings[s] = s
+	}
+	return s
+}
+
+// newStringLikeValue creates a value of type typ (StringType, FileNameType, or
+// EnumType) that refers to s.
+func newStringLikeValue(typ ValueType, s string) Value {
+	sh := (*reflect.StringHeader)(unsafe.Pointer(&s))
+	return Value{typ: typ, p: unsafe.Pointer(sh.Data), v: uint64(sh.Len)}
+}
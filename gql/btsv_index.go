@@ -0,0 +1,569 @@
+package gql
+
+// This file implements block-level column statistics for btsv tables.
+//
+// When a btsv table is written with "index:=&col", each shard file
+// NNNNNN-NNNNNN.grail-rio gets a sidecar file NNNNNN-NNNNNN.blockindex.  For
+// every recordio block in the shard, the sidecar records the block location,
+// the number of rows in the block, and for each indexed column, a bloom filter,
+// the min/max of the column values, and whether the column contains NA.
+//
+// filter() and map(filter:=...) consult the sidecars when the filter condition
+// is of form "&col OP constant" (possibly and-ed with other conditions), where
+// OP is a comparison operator (see filter_pushdown.go). Only the blocks that
+// may contain matching rows are read.
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/base/recordio"
+	"github.com/grailbio/base/recordio/recordiozstd"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+const (
+	btsvBlockIndexFileExt = ".blockindex"
+
+	// Size of a bloom filter for one column in one recordio block. A recordio
+	// block contains at most 1000 rows by default, so the false-positive rate is
+	// <0.1%.
+	btsvBloomBits = 16384
+	// Number of hash functions used by the bloom filter.
+	btsvBloomHashes = 6
+)
+
+var (
+	btsvBlockIndexMagic = [8]byte{'b', 't', 's', 'v', 'b', 'i', 'd', '2'}
+	// btsvBlockIndexMagicV1 is the magic of an index that lacks the hasNull
+	// flags.
+	btsvBlockIndexMagicV1 = [8]byte{'b', 't', 's', 'v', 'b', 'i', 'd', '1'}
+)
+
+// btsvBlockIndexPath computes the pathname of the sidecar block index file for
+// the given shard file.
+func btsvBlockIndexPath(shardPath string) string {
+	return strings.TrimSuffix(shardPath, btsvShardFileExt) + btsvBlockIndexFileExt
+}
+
+// hashIndexKey computes the hash of a value used as a key in an index.
+// Values that compare equal using "==" produce the same hash. It returns false
+// for values that cannot be indexed.
+func hashIndexKey(v Value) (hash.Hash, bool) {
+	switch v.Type() {
+	case StringType, FileNameType, EnumType:
+		return hash.String(v.Str(nil)), true
+	case DateType, DateTimeType:
+		return hash.Time(v.DateTime(nil)), true
+	case FloatType:
+		// -0.0 == 0.0, so they must produce the same hash. Value.Hash already
+		// maps all NaNs, which compare equal, to one canonical NaN.
+		if f := v.Float(nil); f == 0 {
+			return NewFloat(0).Hash(), true
+		}
+		return v.Hash(), true
+	case IntType, CharType, BoolType, DurationType:
+		return v.Hash(), true
+	}
+	return hash.Zero, false
+}
+
+// btsvBloomKey computes the hash used to add a value to a bloom filter. It
+// returns false for values that cannot be indexed.
+func btsvBloomKey(v Value) (uint64, uint64, bool) {
+	h, ok := hashIndexKey(v)
+	if !ok {
+		return 0, 0, false
+	}
+	return binary.LittleEndian.Uint64(h[:8]), binary.LittleEndian.Uint64(h[8:16]) | 1, true
+}
+
+// btsvStatsType returns the type class used to compare column values for the
+// min/max statistics. It returns InvalidType if the value can't be used in
+// statistics.
+func btsvStatsType(v Value) ValueType {
+	switch v.Type() {
+	case StringType, FileNameType, EnumType:
+		return StringType
+	case DateType, DateTimeType:
+		return DateTimeType
+	case IntType, FloatType, CharType, DurationType:
+		return v.Type()
+	}
+	return InvalidType
+}
+
+// btsvColumnBlockStats stores the statistics of one column in one block.
+type btsvColumnBlockStats struct {
+	bloom [btsvBloomBits / 8]byte
+	// min and max of the column values. They are valid only if statsType !=
+	// InvalidType.
+	statsType ValueType
+	min, max  Value
+	// mixed is true if the column has values of different types.
+	mixed bool
+	// hasNull is true if the column may contain NA.
+	hasNull bool
+}
+
+func (s *btsvColumnBlockStats) add(v Value) {
+	if v.Null() != NotNull {
+		s.hasNull = true
+		return
+	}
+	if h0, h1, ok := btsvBloomKey(v); ok {
+		for i := uint64(0); i < btsvBloomHashes; i++ {
+			bit := (h0 + i*h1) % btsvBloomBits
+			s.bloom[bit/8] |= 1 << (bit % 8)
+		}
+	} else {
+		// The bloom filter can't be used for this block. Set all the bits.
+		for i := range s.bloom {
+			s.bloom[i] = 0xff
+		}
+	}
+	if s.mixed {
+		return
+	}
+	typ := btsvStatsType(v)
+	switch {
+	case typ == InvalidType || (s.statsType != InvalidType && s.statsType != typ):
+		s.mixed = true
+		s.statsType = InvalidType
+	case s.statsType == InvalidType:
+		s.statsType, s.min, s.max = typ, v, v
+	default:
+		if Compare(astUnknown, v, s.min) < 0 {
+			s.min = v
+		}
+		if Compare(astUnknown, v, s.max) > 0 {
+			s.max = v
+		}
+	}
+}
+
+// mayContain checks if the block may contain a row whose column value equals
+// key.
+func (s *btsvColumnBlockStats) mayContain(key Value) bool {
+	if s.statsType != InvalidType && btsvStatsType(key) == s.statsType {
+		if Compare(astUnknown, key, s.min) < 0 || Compare(astUnknown, key, s.max) > 0 {
+			return false
+		}
+	}
+	h0, h1, ok := btsvBloomKey(key)
+	if !ok {
+		return true
+	}
+	for i := uint64(0); i < btsvBloomHashes; i++ {
+		bit := (h0 + i*h1) % btsvBloomBits
+		if s.bloom[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// mayMatch checks if the block may contain a row whose column value satisfies
+// the predicate.
+func (s *btsvColumnBlockStats) mayMatch(p columnPredicate) bool {
+	switch {
+	case p.op == predEQ:
+		return s.mayContain(p.key)
+	case p.op == predNE, s.hasNull:
+		// NA satisfies "!=", and NA or -NA may satisfy an ordering comparison.
+		return true
+	case s.statsType == InvalidType || btsvStatsType(p.key) != s.statsType:
+		return true
+	}
+	switch p.op {
+	case predLT:
+		return Compare(astUnknown, s.min, p.key) < 0
+	case predLE:
+		return Compare(astUnknown, s.min, p.key) <= 0
+	case predGT:
+		return Compare(astUnknown, s.max, p.key) > 0
+	case predGE:
+		return Compare(astUnknown, s.max, p.key) >= 0
+	}
+	return true
+}
+
+// btsvBlockStats stores the statistics of one recordio block.
+type btsvBlockStats struct {
+	loc   recordio.ItemLocation // location of the first row in the block.
+	nRows int
+	cols  []btsvColumnBlockStats // parallels btsvBlockIndex.cols.
+}
+
+// btsvBlockIndex is the contents of a sidecar block index file.
+type btsvBlockIndex struct {
+	cols   []symbol.ID
+	blocks []*btsvBlockStats // sorted by loc.
+}
+
+// colIndex returns the index of the given column in cols, or -1 if the column
+// isn't indexed.
+func (idx *btsvBlockIndex) colIndex(col symbol.ID) int {
+	for i, c := range idx.cols {
+		if c == col {
+			return i
+		}
+	}
+	return -1
+}
+
+// btsvBlockIndexBuilder collects block statistics while a shard is written.
+type btsvBlockIndexBuilder struct {
+	mu     sync.Mutex
+	idx    btsvBlockIndex
+	blocks map[uint64]*btsvBlockStats
+}
+
+func newBTSVBlockIndexBuilder(cols []symbol.ID) *btsvBlockIndexBuilder {
+	return &btsvBlockIndexBuilder{
+		idx:    btsvBlockIndex{cols: cols},
+		blocks: map[uint64]*btsvBlockStats{},
+	}
+}
+
+// add is called by the recordio writer for every row. It may be called
+// concurrently, and out of order.
+func (b *btsvBlockIndexBuilder) add(loc recordio.ItemLocation, item interface{}) error {
+	row := item.(Value)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	block, ok := b.blocks[loc.Block]
+	if !ok {
+		block = &btsvBlockStats{
+			loc:  recordio.ItemLocation{Block: loc.Block},
+			cols: make([]btsvColumnBlockStats, len(b.idx.cols)),
+		}
+		b.blocks[loc.Block] = block
+	}
+	block.nRows++
+	if row.Type() != StructType {
+		return nil
+	}
+	s := row.Struct(nil)
+	for i, col := range b.idx.cols {
+		if v, ok := s.Value(col); ok {
+			block.cols[i].add(v)
+		}
+	}
+	return nil
+}
+
+// write creates the sidecar file for the given shard.
+func (b *btsvBlockIndexBuilder) write(ctx context.Context, shardPath string) {
+	for _, block := range b.blocks {
+		b.idx.blocks = append(b.idx.blocks, block)
+	}
+	sort.Slice(b.idx.blocks, func(i, j int) bool { return b.idx.blocks[i].loc.Block < b.idx.blocks[j].loc.Block })
+
+	enc := marshal.NewEncoder(nil)
+	enc.PutRawBytes(btsvBlockIndexMagic[:])
+	enc.PutVarint(int64(len(b.idx.cols)))
+	for _, col := range b.idx.cols {
+		enc.PutString(col.Str())
+	}
+	enc.PutVarint(int64(len(b.idx.blocks)))
+	for _, block := range b.idx.blocks {
+		enc.PutUint64(block.loc.Block)
+		enc.PutVarint(int64(block.nRows))
+		for i := range block.cols {
+			s := &block.cols[i]
+			enc.PutRawBytes(s.bloom[:])
+			enc.PutByte(byte(s.statsType))
+			if s.statsType != InvalidType {
+				s.min.Marshal(MarshalContext{}, enc)
+				s.max.Marshal(MarshalContext{}, enc)
+			}
+			enc.PutBool(s.hasNull)
+		}
+	}
+	path := btsvBlockIndexPath(shardPath)
+	if err := file.WriteFile(ctx, path, marshal.ReleaseEncoder(enc)); err != nil {
+		log.Panicf("writebtsv %v: %v", path, err)
+	}
+}
+
+// readBTSVBlockIndex reads the sidecar block index for the given shard.  It
+// returns nil if the index does not exist.
+func readBTSVBlockIndex(ctx context.Context, ast ASTNode, shardPath string) *btsvBlockIndex {
+	path := btsvBlockIndexPath(shardPath)
+	data, err := file.ReadFile(ctx, path)
+	if err != nil {
+		Debugf(ast, "btsv %v: %v", path, err)
+		return nil
+	}
+	dec := marshal.NewDecoder(data)
+	defer marshal.ReleaseDecoder(dec)
+	var magic [8]byte
+	dec.RawBytes(magic[:])
+	if magic != btsvBlockIndexMagic && magic != btsvBlockIndexMagicV1 {
+		Errorf(ast, "btsv %v: corrupt block index", path)
+		return nil
+	}
+	idx := &btsvBlockIndex{}
+	idx.cols = make([]symbol.ID, dec.Varint())
+	for i := range idx.cols {
+		idx.cols[i] = symbol.Intern(dec.String())
+	}
+	idx.blocks = make([]*btsvBlockStats, dec.Varint())
+	for i := range idx.blocks {
+		block := &btsvBlockStats{
+			loc:   recordio.ItemLocation{Block: dec.Uint64()},
+			nRows: int(dec.Varint()),
+			cols:  make([]btsvColumnBlockStats, len(idx.cols)),
+		}
+		for ci := range block.cols {
+			s := &block.cols[ci]
+			dec.RawBytes(s.bloom[:])
+			s.statsType = ValueType(dec.Byte())
+			if s.statsType != InvalidType {
+				s.min.Unmarshal(UnmarshalContext{}, dec)
+				s.max.Unmarshal(UnmarshalContext{}, dec)
+			}
+			// An old index doesn't record NAs, so assume they are present.
+			s.hasNull = true
+			if magic == btsvBlockIndexMagic {
+				s.hasNull = dec.Bool()
+			}
+		}
+		idx.blocks[i] = block
+	}
+	return idx
+}
+
+// btsvIndexColumns extracts the list of columns from the "index:=" arg of
+// write(). The arg must be of form "&col" or "{&col0, &col1, ...}".
+func btsvIndexColumns(ast ASTNode, f *Func) []symbol.ID {
+	if f == nil {
+		return nil
+	}
+	return columnListArg(ast, "index", f)
+}
+
+// columnListArg extracts the list of columns from a function arg of form "&col"
+// or "{&col0, &col1, ...}". Name is the name of the arg, used in error messages.
+func columnListArg(ast ASTNode, name string, f *Func) []symbol.ID {
+	if f.builtin || len(f.formalArgs) != 1 {
+		Panicf(ast, "%s: must be a column (&col) or a struct of columns ({&col0,&col1}), but found %v", name, f)
+	}
+	if lit, ok := f.body.(*ASTStructLiteral); ok {
+		var cols []symbol.ID
+		for _, field := range lit.Fields {
+			col, ok := rowColumnRef(field.Expr, f.formalArgs[0].Name)
+			if !ok {
+				Panicf(ast, "%s: %v is not a column", name, field.Expr)
+			}
+			cols = append(cols, col)
+		}
+		return cols
+	}
+	col, ok := rowColumnRef(f.body, f.formalArgs[0].Name)
+	if !ok {
+		Panicf(ast, "%s: must be a column (&col) or a struct of columns ({&col0,&col1}), but found %v", name, f.body)
+	}
+	return []symbol.ID{col}
+}
+
+// rowColumnRef checks if expr is of form "&col" or "row.col", where row is the
+// argument to the enclosing lambda. If so, it returns the column name.
+func rowColumnRef(expr ASTNode, row symbol.ID) (symbol.ID, bool) {
+	switch n := expr.(type) {
+	case *ASTColumnRef:
+		return n.Col, row == symbol.AnonRow
+	case *ASTStructFieldRef:
+		if v, ok := n.Parent.(*ASTVarRef); ok && v.Var == row {
+			return n.Field, true
+		}
+	}
+	return symbol.Invalid, false
+}
+
+// btsvPrunedTable reads the rows of a btsv table that may satisfy the given
+// predicates. It reads only the blocks that may contain such rows, then drops
+// the rows that fail the predicates. It is used only as a source of
+// mapFilterTable, which post-filters the rows.
+type btsvPrunedTable struct {
+	src   *btsvTable
+	preds []columnPredicate
+
+	once sync.Once
+	// indexed is true if all the shards have a block index for some
+	// predicate. If false, the table falls back to a full scan of src.
+	indexed bool
+	blocks  []btsvBlockRef
+	nRows   int
+}
+
+// btsvBlockRef is a candidate block to be read.
+type btsvBlockRef struct {
+	shard *btsvTableShard
+	loc   recordio.ItemLocation
+	nRows int
+}
+
+func (t *btsvPrunedTable) init(ctx context.Context) {
+	t.once.Do(func() {
+		t.src.init(ctx)
+		var blocks []btsvBlockRef
+		for si := range t.src.shards {
+			shard := &t.src.shards[si]
+			idx := readBTSVBlockIndex(ctx, t.src.ast, shard.path)
+			if idx == nil {
+				return
+			}
+			var cols []int // parallels t.preds
+			indexed := false
+			for _, p := range t.preds {
+				ci := idx.colIndex(p.col)
+				cols = append(cols, ci)
+				indexed = indexed || ci >= 0
+			}
+			if !indexed {
+				return
+			}
+			for _, block := range idx.blocks {
+				match := true
+				for i, p := range t.preds {
+					if cols[i] >= 0 && !block.cols[cols[i]].mayMatch(p) {
+						match = false
+						break
+					}
+				}
+				if match {
+					blocks = append(blocks, btsvBlockRef{shard: shard, loc: block.loc, nRows: block.nRows})
+					t.nRows += block.nRows
+				}
+			}
+		}
+		Logf(t.src.ast, "btsv %s: block index selected %d blocks, %d rows", t.src.dir, len(blocks), t.nRows)
+		t.indexed = true
+		t.blocks = blocks
+	})
+}
+
+// Attrs implements Table.
+func (t *btsvPrunedTable) Attrs(ctx context.Context) TableAttrs { return t.src.Attrs(ctx) }
+
+// Hash implements Table.
+func (t *btsvPrunedTable) Hash() hash.Hash { return hashColumnPredicates(t.src.Hash(), t.preds) }
+
+// Len implements Table.
+func (t *btsvPrunedTable) Len(ctx context.Context, mode CountMode) int {
+	if mode == Exact {
+		return DefaultTableLen(ctx, t)
+	}
+	t.init(ctx)
+	if !t.indexed {
+		return t.src.Len(ctx, mode)
+	}
+	return t.nRows
+}
+
+// Marshal implements Table. This table is usually created anew by the
+// unmarshaler of mapFilterTable. Otherwise, the pruning is only an optimization,
+// so the source table is marshaled in its place.
+func (t *btsvPrunedTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	t.src.Marshal(ctx, enc)
+}
+
+// Prefetch implements Table.
+func (t *btsvPrunedTable) Prefetch(ctx context.Context) { t.src.Prefetch(ctx) }
+
+// Scanner implements Table.
+func (t *btsvPrunedTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	t.init(ctx)
+	if !t.indexed {
+		return &columnPredicateScanner{src: t.src.Scanner(ctx, start, limit, total), preds: t.preds}
+	}
+	startBlock, limitBlock := ScaleShardRange(start, limit, total, len(t.blocks))
+	return &columnPredicateScanner{
+		src: &btsvPrunedTableScanner{
+			ctx:    ctx,
+			parent: t,
+			blocks: t.blocks[startBlock:limitBlock],
+		},
+		preds: t.preds,
+	}
+}
+
+type btsvPrunedTableScanner struct {
+	ctx    context.Context
+	parent *btsvPrunedTable
+	blocks []btsvBlockRef
+	// dec decodes rows read from the current shard.
+	dec *btsvTableScanner
+
+	started   bool // true after blocks[0] is opened.
+	in        file.File
+	inPath    string
+	rio       recordio.Scanner
+	remaining int // # of rows remaining in blocks[0].
+}
+
+// Scan implements TableScanner.
+func (sc *btsvPrunedTableScanner) Scan() bool {
+	CheckCancellation(sc.ctx)
+	for sc.remaining == 0 {
+		if sc.started && len(sc.blocks) > 0 {
+			sc.blocks = sc.blocks[1:]
+		}
+		if len(sc.blocks) == 0 {
+			sc.close()
+			return false
+		}
+		sc.started = true
+		block := sc.blocks[0]
+		if block.shard.path != sc.inPath {
+			sc.close()
+			recordiozstd.Init()
+			var err error
+			if sc.in, err = file.Open(sc.ctx, block.shard.path); err != nil {
+				Panicf(sc.parent.src.ast, "btsv %v: open failed: %v", block.shard.path, err)
+			}
+			sc.inPath = block.shard.path
+			sc.rio = recordio.NewScanner(sc.in.Reader(sc.ctx), recordio.ScannerOpts{})
+			sc.dec = newBTSVShardDecoder(sc.ctx, sc.parent.src, block.shard)
+		}
+		sc.rio.Seek(block.loc)
+		sc.remaining = block.nRows
+	}
+	if !sc.rio.Scan() {
+		if err := sc.rio.Err(); err != nil {
+			log.Panic(err)
+		}
+		Panicf(sc.parent.src.ast, "btsv %v: block %v: premature EOF", sc.inPath, sc.blocks[0].loc)
+	}
+	sc.remaining--
+	sc.dec.decode(sc.rio.Get().([]byte))
+	return true
+}
+
+func (sc *btsvPrunedTableScanner) close() {
+	if sc.rio != nil {
+		if err := sc.rio.Finish(); err != nil {
+			log.Panic(err)
+		}
+		sc.rio = nil
+	}
+	if sc.in != nil {
+		if err := sc.in.Close(sc.ctx); err != nil {
+			log.Panic(err)
+		}
+		sc.in = nil
+	}
+	sc.inPath = ""
+}
+
+// Value implements TableScanner.
+func (sc *btsvPrunedTableScanner) Value() Value { return sc.dec.Value() }
@@ -0,0 +1,450 @@
+package gql
+
+// This file implements build_index() and lookup(), a persistent secondary index
+// on a btsv table. The index is also used by join() to look up the rows that
+// match a small table; see joinIndexLookupNode.
+//
+// The index maps a key (the value of an arbitrary expression computed for each
+// row) to the locations of the rows with that key. Each shard file of the btsv
+// table is divided into parts of approximately btsvKeyIndexPartSize bytes, and
+// a location is a tuple <shard, part, item>, where item is the index of the row
+// among the rows yielded by a recordio.ShardScanner for the part.  A lookup thus
+// reads only a few parts, instead of the entire table.
+//
+// The index also records the size and the modtime of each shard file, so that a
+// stale index is detected. If the key is a column of the row, e.g.,
+// key:=&sample_id, the index records the column name, so that join() can tell
+// whether the index applies to its join condition.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/base/recordio"
+	"github.com/grailbio/base/recordio/recordiozstd"
+	"github.com/grailbio/base/traverse"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+// btsvKeyIndexPartSize is the approximate size of a part of a shard file.
+const btsvKeyIndexPartSize = 1 << 20
+
+var (
+	btsvKeyIndexMagic = [8]byte{'b', 't', 's', 'v', 'k', 'i', 'd', '2'}
+	// btsvKeyIndexMagicV1 is the magic of an index that lacks keyCol.
+	btsvKeyIndexMagicV1 = [8]byte{'b', 't', 's', 'v', 'k', 'i', 'd', '1'}
+)
+
+// btsvKeyIndexLoc is a location of a row.
+type btsvKeyIndexLoc struct {
+	shard, part, item int
+}
+
+// btsvKeyIndexShard describes a shard file at the time the index was built.
+type btsvKeyIndexShard struct {
+	name    string // basename of the shard file.
+	size    int64
+	modTime time.Time
+	nParts  int
+}
+
+// btsvKeyIndex is the contents of an index file.
+type btsvKeyIndex struct {
+	keyExpr string // for display only.
+	// keyCol is the column used as the key, if the key expression is a plain
+	// column reference. Else "".
+	keyCol string
+	shards []btsvKeyIndexShard
+	// Key hash -> locations. Computed by hashIndexKey.
+	locs map[hash.Hash][]btsvKeyIndexLoc
+}
+
+// defaultBTSVKeyIndexPath computes the index pathname used when the path:= arg
+// is omitted.
+func defaultBTSVKeyIndexPath(dir string) string {
+	return dir + ".idx"
+}
+
+func (idx *btsvKeyIndex) marshal() []byte {
+	enc := marshal.NewEncoder(nil)
+	enc.PutRawBytes(btsvKeyIndexMagic[:])
+	enc.PutString(idx.keyExpr)
+	enc.PutString(idx.keyCol)
+	enc.PutVarint(int64(len(idx.shards)))
+	for _, s := range idx.shards {
+		enc.PutString(s.name)
+		enc.PutVarint(s.size)
+		enc.PutVarint(s.modTime.UnixNano())
+		enc.PutVarint(int64(s.nParts))
+	}
+	keys := make([]hash.Hash, 0, len(idx.locs))
+	for k := range idx.locs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	enc.PutVarint(int64(len(keys)))
+	for _, k := range keys {
+		enc.PutHash(k)
+		locs := idx.locs[k]
+		enc.PutVarint(int64(len(locs)))
+		for _, loc := range locs {
+			enc.PutVarint(int64(loc.shard))
+			enc.PutVarint(int64(loc.part))
+			enc.PutVarint(int64(loc.item))
+		}
+	}
+	return marshal.ReleaseEncoder(enc)
+}
+
+func unmarshalBTSVKeyIndex(path string, data []byte) (*btsvKeyIndex, error) {
+	dec := marshal.NewDecoder(data)
+	defer marshal.ReleaseDecoder(dec)
+	var magic [8]byte
+	dec.RawBytes(magic[:])
+	if magic != btsvKeyIndexMagic && magic != btsvKeyIndexMagicV1 {
+		return nil, fmt.Errorf("%s: not an index file", path)
+	}
+	idx := &btsvKeyIndex{keyExpr: dec.String()}
+	if magic == btsvKeyIndexMagic {
+		idx.keyCol = dec.String()
+	}
+	idx.shards = make([]btsvKeyIndexShard, dec.Varint())
+	for i := range idx.shards {
+		idx.shards[i] = btsvKeyIndexShard{
+			name:    dec.String(),
+			size:    dec.Varint(),
+			modTime: time.Unix(0, dec.Varint()),
+			nParts:  int(dec.Varint()),
+		}
+	}
+	n := int(dec.Varint())
+	idx.locs = make(map[hash.Hash][]btsvKeyIndexLoc, n)
+	for i := 0; i < n; i++ {
+		k := dec.Hash()
+		locs := make([]btsvKeyIndexLoc, dec.Varint())
+		for j := range locs {
+			locs[j] = btsvKeyIndexLoc{
+				shard: int(dec.Varint()),
+				part:  int(dec.Varint()),
+				item:  int(dec.Varint()),
+			}
+		}
+		idx.locs[k] = locs
+	}
+	return idx, nil
+}
+
+// check checks that the index was built for the table t, and that t hasn't
+// changed since.
+func (idx *btsvKeyIndex) check(ctx context.Context, t *btsvTable) error {
+	t.init(ctx)
+	if len(idx.shards) != len(t.shards) {
+		return fmt.Errorf("index is for a table with %d shards, but %s has %d shards",
+			len(idx.shards), t.dir, len(t.shards))
+	}
+	for i, s := range idx.shards {
+		ts := &t.shards[i]
+		if s.name != file.Base(ts.path) || s.size != ts.serializedSize || !s.modTime.Equal(ts.modTime) {
+			return fmt.Errorf("index is stale for %s", ts.path)
+		}
+	}
+	return nil
+}
+
+// validate is similar to check, but it panics on error.
+func (idx *btsvKeyIndex) validate(ctx context.Context, ast ASTNode, path string, t *btsvTable) {
+	if err := idx.check(ctx, t); err != nil {
+		Panicf(ast, "lookup %s: %v; rerun build_index", path, err)
+	}
+}
+
+// btsvKeyIndexColumn returns the column read by keyExpr if keyExpr is a plain
+// column reference, such as "&col" or "|r|r.col". Else it returns "".
+func btsvKeyIndexColumn(keyExpr *Func) string {
+	if keyExpr.builtin || len(keyExpr.formalArgs) != 1 {
+		return ""
+	}
+	switch n := keyExpr.body.(type) {
+	case *ASTColumnRef:
+		return n.Col.Str()
+	case *ASTStructFieldRef:
+		if v, ok := n.Parent.(*ASTVarRef); ok && v.Var == keyExpr.formalArgs[0].Name {
+			return n.Field.Str()
+		}
+	}
+	return ""
+}
+
+// btsvPartScanner creates a recordio scanner that reads the given part of a
+// shard file.
+func btsvPartScanner(ctx context.Context, in file.File, part, nParts int) recordio.Scanner {
+	recordiozstd.Init()
+	return recordio.NewShardScanner(in.Reader(ctx), recordio.ScannerOpts{}, part, part+1, nParts)
+}
+
+// buildBTSVKeyIndex builds an index on table t. keyExpr computes the key for
+// each row.
+func buildBTSVKeyIndex(ctx context.Context, ast ASTNode, t *btsvTable, keyExpr *Func) *btsvKeyIndex {
+	t.init(ctx)
+	idx := &btsvKeyIndex{
+		keyExpr: keyExpr.String(),
+		keyCol:  btsvKeyIndexColumn(keyExpr),
+		shards:  make([]btsvKeyIndexShard, len(t.shards)),
+		locs:    map[hash.Hash][]btsvKeyIndexLoc{},
+	}
+	shardLocs := make([]map[hash.Hash][]btsvKeyIndexLoc, len(t.shards))
+	traverse.Parallel.Each(len(t.shards), func(si int) error { // nolint: errcheck
+		shard := &t.shards[si]
+		nParts := int(shard.serializedSize/btsvKeyIndexPartSize) + 1
+		idx.shards[si] = btsvKeyIndexShard{
+			name:    file.Base(shard.path),
+			size:    shard.serializedSize,
+			modTime: shard.modTime,
+			nParts:  nParts,
+		}
+		locs := map[hash.Hash][]btsvKeyIndexLoc{}
+		in, err := file.Open(ctx, shard.path)
+		if err != nil {
+			Panicf(ast, "build_index %v: open: %v", shard.path, err)
+		}
+		defer in.Close(ctx) // nolint: errcheck
+		dec := newBTSVShardDecoder(ctx, t, shard)
+		for part := 0; part < nParts; part++ {
+			rio := btsvPartScanner(ctx, in, part, nParts)
+			for item := 0; rio.Scan(); item++ {
+				key := keyExpr.Eval(ctx, dec.decode(rio.Get().([]byte)))
+				if key.Null() != NotNull {
+					continue
+				}
+				h, ok := hashIndexKey(key)
+				if !ok {
+					Panicf(ast, "build_index %v: key %v (type %v) cannot be indexed", shard.path, key, key.Type())
+				}
+				locs[h] = append(locs[h], btsvKeyIndexLoc{shard: si, part: part, item: item})
+			}
+			if err := rio.Finish(); err != nil {
+				Panicf(ast, "build_index %v: %v", shard.path, err)
+			}
+		}
+		shardLocs[si] = locs
+		return nil
+	})
+	for _, locs := range shardLocs {
+		for h, l := range locs {
+			idx.locs[h] = append(idx.locs[h], l...)
+		}
+	}
+	return idx
+}
+
+// btsvKeyIndexCache caches the index files read by lookup(), so that repeated
+// lookups don't need to read the index.
+var btsvKeyIndexCache = struct {
+	mu sync.Mutex
+	m  map[string]btsvKeyIndexCacheEntry
+}{m: map[string]btsvKeyIndexCacheEntry{}}
+
+type btsvKeyIndexCacheEntry struct {
+	modTime time.Time
+	idx     *btsvKeyIndex
+}
+
+// loadBTSVKeyIndex reads the index file at path. If the file doesn't exist, it
+// returns the error reported by file.Stat.
+func loadBTSVKeyIndex(ctx context.Context, path string) (*btsvKeyIndex, error) {
+	info, err := file.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	btsvKeyIndexCache.mu.Lock()
+	e, ok := btsvKeyIndexCache.m[path]
+	btsvKeyIndexCache.mu.Unlock()
+	if ok && e.modTime.Equal(info.ModTime()) {
+		return e.idx, nil
+	}
+	data, err := file.ReadFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := unmarshalBTSVKeyIndex(path, data)
+	if err != nil {
+		return nil, err
+	}
+	btsvKeyIndexCache.mu.Lock()
+	btsvKeyIndexCache.m[path] = btsvKeyIndexCacheEntry{modTime: info.ModTime(), idx: idx}
+	btsvKeyIndexCache.mu.Unlock()
+	return idx, nil
+}
+
+func readBTSVKeyIndex(ctx context.Context, ast ASTNode, path string) *btsvKeyIndex {
+	idx, err := loadBTSVKeyIndex(ctx, path)
+	if errors.Is(errors.NotExist, err) || os.IsNotExist(err) {
+		Panicf(ast, "lookup %s: %v; run build_index first", path, err)
+	}
+	if err != nil {
+		Panicf(ast, "lookup %s: %v", path, err)
+	}
+	return idx
+}
+
+// findBTSVKeyIndex returns the index on column col of table t, stored in the
+// default location. It returns nil if there is no such index, or if the index
+// is stale.
+func findBTSVKeyIndex(ctx context.Context, t *btsvTable, col symbol.ID) *btsvKeyIndex {
+	path := defaultBTSVKeyIndexPath(t.dir)
+	idx, err := loadBTSVKeyIndex(ctx, path)
+	if err != nil {
+		if !errors.Is(errors.NotExist, err) && !os.IsNotExist(err) {
+			log.Error.Printf("join: read index %s: %v", path, err)
+		}
+		return nil
+	}
+	if idx.keyCol != col.Str() {
+		return nil
+	}
+	if err := idx.check(ctx, t); err != nil {
+		log.Printf("join: not using index %s: %v", path, err)
+		return nil
+	}
+	return idx
+}
+
+// lookupBTSVKeyIndex reads the rows of t whose key equals the given value.
+func lookupBTSVKeyIndex(ctx context.Context, ast ASTNode, t *btsvTable, idx *btsvKeyIndex, key Value) []Value {
+	h, ok := hashIndexKey(key)
+	if !ok {
+		return nil
+	}
+	locs := idx.locs[h]
+	var rows []Value
+	for len(locs) > 0 {
+		// Read the rows in one part.
+		shardIndex, part := locs[0].shard, locs[0].part
+		n := 1
+		for n < len(locs) && locs[n].shard == shardIndex && locs[n].part == part {
+			n++
+		}
+		shard := &t.shards[shardIndex]
+		in, err := file.Open(ctx, shard.path)
+		if err != nil {
+			Panicf(ast, "lookup %v: open: %v", shard.path, err)
+		}
+		dec := newBTSVShardDecoder(ctx, t, shard)
+		rio := btsvPartScanner(ctx, in, part, idx.shards[shardIndex].nParts)
+		for item, i := 0, 0; i < n && rio.Scan(); item++ {
+			if item == locs[i].item {
+				rows = append(rows, dec.decode(rio.Get().([]byte)))
+				i++
+			}
+		}
+		if err := rio.Finish(); err != nil {
+			Panicf(ast, "lookup %v: %v", shard.path, err)
+		}
+		if err := in.Close(ctx); err != nil {
+			log.Error.Printf("lookup %v: close: %v", shard.path, err)
+		}
+		locs = locs[n:]
+	}
+	return rows
+}
+
+func init() {
+	RegisterBuiltinFunc("build_index",
+		`
+    tbl | build_index(key:=keyexpr [, path:="index path"])
+
+Arg types:
+
+- _keyexpr_: one-arg function
+- _path_: string (default: the table's path + ".idx")
+
+Build_index creates a persistent index on a btsv table. The index maps the value
+of _keyexpr_, computed for each row, to the locations of the rows. The index is
+used by [lookup](#lookup).  Build_index returns the path of the index file.
+
+Example:
+
+    read("s3://bucket/ref.btsv") | build_index(key:=&sample_id)
+
+creates index s3://bucket/ref.btsv.idx.
+
+If _keyexpr_ is a column, such as ::&sample_id::, and the index is stored in the
+default path, [join](#join) uses the index to look up the rows that match a
+small table, instead of sorting both tables.
+
+The index becomes stale when the table is rewritten, in which case lookup()
+reports an error, and join() ignores the index. Run build_index again in such
+case.
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			t, ok := args[0].Table().(*btsvTable)
+			if !ok {
+				Panicf(ast, "build_index: %v is not a btsv table", args[0].Expr)
+			}
+			path := args[2].Str()
+			if path == "" {
+				path = defaultBTSVKeyIndexPath(t.dir)
+			}
+			log.Printf("build_index %v: started", path)
+			idx := buildBTSVKeyIndex(ctx, ast, t, args[1].Func())
+			if err := file.WriteFile(ctx, path, idx.marshal()); err != nil {
+				Panicf(ast, "build_index %v: %v", path, err)
+			}
+			log.Printf("build_index %v: finished, %d keys", path, len(idx.locs))
+			return NewString(path)
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIStringType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},              // table
+		FormalArg{Name: symbol.Key, Required: true, Closure: true, ClosureArgs: anonRowFuncArg}, // key:=expr
+		FormalArg{Name: symbol.Path, Types: []ValueType{StringType}, DefaultValue: NewString("")},
+		FormalArg{Name: symbol.Row, Symbol: true, DefaultSymbol: symbol.AnonRow})
+
+	RegisterBuiltinFunc("lookup",
+		`
+    tbl | lookup(key [, index:="index path"])
+
+Arg types:
+
+- _key_: any scalar value
+- _index_: string (default: the table's path + ".idx")
+
+Lookup returns a table consisting of the rows of _tbl_ whose key equals _key_.
+The index must have been created by [build_index](#build_index). Only the parts
+of the table that contain the matching rows are read.
+
+Example:
+
+    ref := read("s3://bucket/ref.btsv")
+    ref | build_index(key:=&sample_id)   // run once
+    ref | lookup("S12345")
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			t, ok := args[0].Table().(*btsvTable)
+			if !ok {
+				Panicf(ast, "lookup: %v is not a btsv table", args[0].Expr)
+			}
+			key := args[1].Value
+			path := args[2].Str()
+			if path == "" {
+				path = defaultBTSVKeyIndexPath(t.dir)
+			}
+			idx := readBTSVKeyIndex(ctx, ast, path)
+			idx.validate(ctx, ast, path, t)
+			rows := lookupBTSVKeyIndex(ctx, ast, t, idx, key)
+			h := t.Hash().Merge(hash.String(fmt.Sprintf("lookup:%s", idx.keyExpr))).Merge(key.Hash())
+			return NewTable(NewSimpleTable(rows, h, TableAttrs{Name: "lookup", Path: t.dir}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}}, // table
+		FormalArg{Positional: true, Required: true},                                // key
+		FormalArg{Name: symbol.Index, Types: []ValueType{StringType}, DefaultValue: NewString("")})
+}
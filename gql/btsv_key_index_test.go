@@ -0,0 +1,84 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/grailbio/gql/symbol"
+	"github.com/grailbio/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBTSVKeyIndexJoin(t *testing.T) {
+	ctx := context.Background()
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	path := filepath.Join(tmpDir, "ref.btsv")
+	sess := newSession()
+
+	const nRows = 5000
+	for shard := 0; shard < 2; shard++ {
+		w := NewBTSVShardWriter(ctx, path, shard, 2, TableAttrs{})
+		for i := shard * nRows / 2; i < (shard+1)*nRows/2; i++ {
+			w.Append(NewStruct(NewSimpleStruct(
+				StructField{Name: symbol.Intern("id"), Value: NewString(fmt.Sprintf("s%05d", i))},
+				StructField{Name: symbol.Intern("n"), Value: NewInt(int64(i % 1000))})))
+		}
+		w.Close(ctx)
+	}
+	doEval(t, fmt.Sprintf("keyIndexRef := read(`%s`)", path), sess)
+	doEval(t, "keyIndexProbe := table({id:`s00012`, x:1}, {id:`s04321`, x:2}, {id:`s00012`, x:3}, {id:`nonexistent`, x:4})", sess)
+	// Each join uses a distinct map:=, so that it doesn't reuse the result of
+	// another join with the same hash.
+	join := func(ref string, tag int) (joinNode, []string) {
+		v := doEval(t, fmt.Sprintf("join({p: keyIndexProbe, r: %s}, p.id == r.id, map:={id:p.id, x:p.x, n:r.n + %d})", ref, tag), sess)
+		return v.Table(nil).(*joinTable).root, doReadTable(v)
+	}
+	want := []string{"{id:s00012,x:1,n:12}", "{id:s04321,x:2,n:321}", "{id:s00012,x:3,n:12}"}
+
+	// Without an index, the tables are sorted and merged.
+	root, got := join("keyIndexRef", 0)
+	_, ok := root.(*joinSortingMergeNode)
+	assert.True(t, ok)
+	assert.ElementsMatch(t, want, got)
+
+	// An index on another column isn't used.
+	doEval(t, "keyIndexRef | build_index(key:=&n)", sess)
+	root, got = join("keyIndexRef", 1000)
+	_, ok = root.(*joinSortingMergeNode)
+	assert.True(t, ok)
+	assert.ElementsMatch(t, []string{"{id:s00012,x:1,n:1012}", "{id:s04321,x:2,n:1321}", "{id:s00012,x:3,n:1012}"}, got)
+
+	// With an index on the join column, the matching rows are looked up in
+	// the probe order.
+	doEval(t, "keyIndexRef | build_index(key:=&id)", sess)
+	root, got = join("keyIndexRef", 2000)
+	node, ok := root.(*joinIndexLookupNode)
+	require.True(t, ok)
+	assert.Equal(t, 1, node.indexed)
+	assert.Equal(t, []string{"{id:s00012,x:1,n:2012}", "{id:s04321,x:2,n:2321}", "{id:s00012,x:3,n:2012}"}, got)
+	// The index is also used when the indexed table comes first.
+	v := doEval(t, "join({r: keyIndexRef, p: keyIndexProbe}, r.id == p.id, map:={id:p.id, x:p.x, n:r.n})", sess)
+	node, ok = v.Table(nil).(*joinTable).root.(*joinIndexLookupNode)
+	require.True(t, ok)
+	assert.Equal(t, 0, node.indexed)
+	assert.Equal(t, want, doReadTable(v))
+	// An outer join doesn't use the index.
+	_, ok = doEval(t, "join({p: keyIndexProbe, r: keyIndexRef}, p.id ==? r.id)", sess).Table(nil).(*joinTable).root.(*joinSortingMergeNode)
+	assert.True(t, ok)
+
+	// A stale index is ignored.
+	w := NewBTSVShardWriter(ctx, path, 0, 2, TableAttrs{})
+	w.Append(NewStruct(NewSimpleStruct(
+		StructField{Name: symbol.Intern("id"), Value: NewString("s00012")},
+		StructField{Name: symbol.Intern("n"), Value: NewInt(-1)})))
+	w.Close(ctx)
+	doEval(t, fmt.Sprintf("keyIndexRef2 := read(`%s`)", path), sess)
+	root, got = join("keyIndexRef2", 3000)
+	_, ok = root.(*joinSortingMergeNode)
+	assert.True(t, ok)
+	assert.ElementsMatch(t, []string{"{id:s00012,x:1,n:2999}", "{id:s04321,x:2,n:3321}", "{id:s00012,x:3,n:2999}"}, got)
+}
@@ -0,0 +1,179 @@
+package gql
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"unsafe"
+
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+// btsvLazyField is a field in btsvLazyStruct.
+type btsvLazyField struct {
+	name symbol.ID
+	// off is the offset of the encoded value in btsvLazyStruct.data. It is -1
+	// if the value is a struct or a table, which is stored in val.
+	off int
+	val Value
+}
+
+// btsvLazyStruct is a Struct backed by an encoded btsv row. It decodes a scalar
+// field each time it is read, so a row that is only partially read, e.g., by
+// filter(&col==...), costs only one allocation for the row data and one for the
+// field list. Decoding a scalar doesn't allocate, and the struct is immutable
+// once created, so it needs no locking. Strings are not copied; they refer to
+// the row data. Nested structs and tables are decoded eagerly by the scanner.
+type btsvLazyStruct struct {
+	StructImpl
+	shard *btsvTableShard
+	ast   ASTNode
+	// data is the encoded row. It is owned by this object.
+	data   []byte
+	fields []btsvLazyField
+}
+
+var _ Struct = &btsvLazyStruct{}
+
+// Len implements Struct.
+func (s *btsvLazyStruct) Len() int { return len(s.fields) }
+
+// Field implements Struct.
+func (s *btsvLazyStruct) Field(i int) StructField {
+	return StructField{Name: s.fields[i].name, Value: s.fieldValue(i)}
+}
+
+// Value implements Struct.
+func (s *btsvLazyStruct) Value(colName symbol.ID) (Value, bool) {
+	for i := range s.fields {
+		if s.fields[i].name == colName {
+			return s.fieldValue(i), true
+		}
+	}
+	return Value{}, false
+}
+
+func (s *btsvLazyStruct) fieldValue(i int) Value {
+	f := &s.fields[i]
+	if f.off < 0 {
+		return f.val
+	}
+	return s.decodeScalar(f.off)
+}
+
+func (s *btsvLazyStruct) varint(off int) (int64, int) {
+	v, n := binary.Varint(s.data[off:])
+	if n <= 0 {
+		Panicf(s.ast, "btsv %s: corrupt varint at offset %d", s.shard.path, off)
+	}
+	return v, off + n
+}
+
+// decodeScalar decodes a non-struct, non-table value stored at the given
+// offset. The encoding is described in BTSVShardWriter.marshalValue.
+func (s *btsvLazyStruct) decodeScalar(off int) Value {
+	tag := s.data[off]
+	off++
+	if tag&btsvDictEncodedTag != 0 {
+		dict := s.shard.index.StringDict
+		id, _ := s.varint(off)
+		if id < 0 || int(id) >= len(dict) {
+			Panicf(s.ast, "btsv %s: string ID %d out of range [0,%d)", s.shard.path, id, len(dict))
+		}
+		return newStringLikeValue(ValueType(tag&^btsvDictEncodedTag), dict[id])
+	}
+	v := Value{typ: ValueType(tag)}
+	switch v.typ {
+	case NullType:
+		switch s.data[off] {
+		case 1:
+			v.v = uint64(PosNull)
+		case byte(0xff):
+			tmp := NegNull
+			v.v = uint64(tmp)
+		default:
+			Panicf(s.ast, "btsv %s: corrupt NA at offset %d", s.shard.path, off)
+		}
+	case BoolType:
+		switch s.data[off] {
+		case 1:
+			v.v = 1
+		case 0:
+			v.v = 0
+		default:
+			Panicf(s.ast, "btsv %s: corrupt bool at offset %d", s.shard.path, off)
+		}
+	case IntType, CharType:
+		n, _ := s.varint(off)
+		v.v = uint64(n)
+	case FloatType:
+		v.v = binary.LittleEndian.Uint64(s.data[off : off+8])
+	case StringType, FileNameType, EnumType:
+		n, off := s.varint(off)
+		if n == 0 {
+			return newStringLikeValue(v.typ, "")
+		}
+		data := s.data[off : off+int(n)]
+		sh := reflect.StringHeader{Data: uintptr(unsafe.Pointer(&data[0])), Len: len(data)}
+		return newStringLikeValue(v.typ, *(*string)(unsafe.Pointer(&sh)))
+	case DateType, DateTimeType:
+		t, off := s.varint(off)
+		loc, _ := s.varint(off)
+		v.v = uint64(t)
+		v.p = unsafe.Pointer(s.shard.LocationFromID(int32(loc)))
+	default:
+		Panicf(s.ast, "btsv %s: invalid value type %v at offset %d", s.shard.path, v.typ, off)
+	}
+	return v
+}
+
+// unmarshalLazyStruct creates a btsvLazyStruct from the encoded row. Data is the
+// encoded row. The struct takes ownership of data. Dec must read data, and it
+// must be positioned just after the StructType byte.
+func (sc *btsvTableScanner) unmarshalLazyStruct(ctx UnmarshalContext, dec *marshal.Decoder, data []byte) Value {
+	nFields := int(dec.Varint())
+	if nFields < 0 || nFields > math.MaxInt32 {
+		Panicf(sc.parent.ast, "btsv %s: corrupt row", sc.shard.path)
+	}
+	s := &btsvLazyStruct{
+		shard:  sc.shard,
+		ast:    sc.parent.ast,
+		data:   data,
+		fields: make([]btsvLazyField, nFields),
+	}
+	InitStruct(s)
+	for i := range s.fields {
+		f := &s.fields[i]
+		f.name = sc.shard.cols[int(dec.Varint())].id
+		f.off = len(data) - dec.Len()
+		tag := data[f.off]
+		if tag&btsvDictEncodedTag != 0 {
+			dec.Byte()
+			dec.Varint()
+			continue
+		}
+		switch ValueType(tag) {
+		case NullType, BoolType:
+			dec.Byte()
+			dec.Byte()
+		case IntType, CharType:
+			dec.Byte()
+			dec.Varint()
+		case FloatType:
+			dec.Byte()
+			dec.Skip(8)
+		case StringType, FileNameType, EnumType:
+			dec.Byte()
+			dec.Skip(int(dec.Varint()))
+		case DateType, DateTimeType:
+			dec.Byte()
+			dec.Varint()
+			dec.Varint()
+		default:
+			f.off = -1
+			f.val = sc.unmarshalValue(ctx, dec)
+		}
+	}
+	return NewStruct(s)
+}
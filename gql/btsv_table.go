@@ -43,7 +43,6 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"reflect"
 	"runtime"
 	"sort"
 	"strings"
@@ -51,6 +50,7 @@ import (
 	"time"
 	"unsafe"
 
+	"github.com/grailbio/base/errors"
 	"github.com/grailbio/base/file"
 	"github.com/grailbio/base/log"
 	"github.com/grailbio/base/recordio"
@@ -191,6 +191,7 @@ func newBTSVTableScanner(ctx context.Context, t *btsvTable, scanStart, scanLimit
 	sc := &btsvTableScanner{
 		ctx:        ctx,
 		parent:     t,
+		budget:     evalBudgetFromContext(ctx),
 		start:      scanStart,
 		limit:      scanLimit,
 		curLimit:   scanStart,
@@ -266,6 +267,9 @@ func listBTSVShardPaths(ctx context.Context, dir string, ast ASTNode) []string {
 	l := file.List(ctx, dir, true)
 	paths := []string{}
 	for l.Scan() {
+		if filepath.Ext(l.Path()) == btsvBlockIndexFileExt {
+			continue
+		}
 		if filepath.Ext(l.Path()) != btsvShardFileExt {
 			Errorf(ast, "btsv %s: ignoring file %s", dir, l.Path())
 			continue
@@ -283,7 +287,7 @@ func listBTSVShardPaths(ctx context.Context, dir string, ast ASTNode) []string {
 func (t *btsvTable) initShard(ctx context.Context, path string) (ts btsvTableShard) {
 	recordiozstd.Init()
 	ts.path = path
-	in, err := file.Open(ctx, path)
+	in, err := openRetryingFile(ctx, path)
 	if err != nil {
 		Panicf(t.ast, "btsv %v: open: %v", path, err)
 	}
@@ -325,6 +329,8 @@ func (t *btsvTable) initShard(ctx context.Context, path string) (ts btsvTableSha
 		Name:        "btsv",
 		Path:        path,
 		Description: strings.Join(ts.index.Description, "\n"),
+		Version:     ts.index.Version,
+		Metadata:    unmarshalBTSVMetadata(ts.index.Metadata),
 	}
 	if ts.index.Name != "" {
 		ts.attrs.Name = ts.index.Name
@@ -332,34 +338,82 @@ func (t *btsvTable) initShard(ctx context.Context, path string) (ts btsvTableSha
 	if ts.index.Path != "" {
 		ts.attrs.Path = ts.index.Path
 	}
+	if ts.index.TableDescription != "" {
+		// Files written before table_description was added store the
+		// description only in ts.index.Description, after the cmdline.
+		ts.attrs.Description = ts.index.TableDescription
+	}
 	for _, col := range ts.index.Column {
 		ts.attrs.Columns = append(ts.attrs.Columns,
 			TSVColumn{
 				Name:        col.Name,
 				Type:        ValueType(col.Typ),
-				Description: col.Description})
+				Description: col.Description,
+				Unit:        col.Unit})
 	}
 	return
 }
 
+// marshalBTSVMetadata converts TableAttrs.Metadata into the list of "key=value"
+// strings stored in BinaryTSVIndex.Metadata, sorted by key.
+func marshalBTSVMetadata(metadata map[string]string) []string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	kvs := make([]string, 0, len(metadata))
+	for key, val := range metadata {
+		kvs = append(kvs, key+"="+val)
+	}
+	sort.Strings(kvs)
+	return kvs
+}
+
+// unmarshalBTSVMetadata is the inverse of marshalBTSVMetadata.
+func unmarshalBTSVMetadata(kvs []string) map[string]string {
+	if len(kvs) == 0 {
+		return nil
+	}
+	metadata := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			metadata[kv] = ""
+			continue
+		}
+		metadata[kv[:i]] = kv[i+1:]
+	}
+	return metadata
+}
+
 type btsvTableScanner struct {
 	ctx          context.Context
 	parent       *btsvTable
+	budget       *evalBudget
 	start, limit int
 	curLimit     int
 
 	shard *btsvTableShard
-	in    file.File
+	in    *retryingFile
 	rio   recordio.Scanner
 	val   Value
 
 	tmpDecoder   *marshal.Decoder
 	tmpPool      btsvTmpPool
 	unmarshalCtx UnmarshalContext
+	interner     btsvStringInterner
 }
 
 func (sc *btsvTableScanner) unmarshalValue(ctx UnmarshalContext, dec *marshal.Decoder) Value {
-	v := Value{typ: ValueType(dec.Byte())}
+	tag := dec.Byte()
+	if tag&btsvDictEncodedTag != 0 {
+		dict := sc.shard.index.StringDict
+		id := int(dec.Varint())
+		if id < 0 || id >= len(dict) {
+			Panicf(sc.parent.ast, "btsv %s: string ID %d out of range [0,%d)", sc.shard.path, id, len(dict))
+		}
+		return newStringLikeValue(ValueType(tag&^btsvDictEncodedTag), dict[id])
+	}
+	v := Value{typ: ValueType(tag)}
 	switch v.typ {
 	case NullType:
 		switch dec.Byte() {
@@ -385,16 +439,16 @@ func (sc *btsvTableScanner) unmarshalValue(ctx UnmarshalContext, dec *marshal.De
 	case FloatType:
 		v.v = dec.Uint64()
 	case StringType, FileNameType, EnumType:
-		s := dec.String()
-		sh := (*reflect.StringHeader)(unsafe.Pointer(&s))
-		v.p = unsafe.Pointer(sh.Data)
-		v.v = uint64(sh.Len)
+		sc.interner.tmp = dec.BytesTo(sc.interner.tmp)
+		v = newStringLikeValue(v.typ, sc.interner.intern(sc.interner.tmp))
 	case DateType, DateTimeType:
 		v.v = uint64(dec.Varint())
 		loc := sc.shard.LocationFromID(int32(dec.Varint()))
 		v.p = unsafe.Pointer(loc)
 	case TableType:
 		v = NewTable(unmarshalTable(ctx, dec))
+	case MatrixType:
+		v = NewMatrix(unmarshalMatrix(dec))
 	case StructType:
 		nFields := int(dec.Varint())
 		tmp := sc.tmpPool.Get()
@@ -427,7 +481,7 @@ func (sc *btsvTableScanner) Scan() bool {
 				sc.parent.dir, sc.start, sc.limit, nextOff, scanLimit, subTableIndex, subTableStart, subTableLimit, len(sc.parent.shards), sc.parent.cumShardLen)
 			sc.shard = &sc.parent.shards[subTableIndex]
 			var err error
-			if sc.in, err = file.Open(sc.ctx, sc.shard.path); err != nil {
+			if sc.in, err = openRetryingFile(sc.ctx, sc.shard.path); err != nil {
 				Panicf(sc.parent.ast, "btsv %v: open failed: %v", sc.shard.path, err)
 			}
 			Debugf(sc.parent.ast, "btsv %s: open shard [%d,%d)/%d", sc.shard.path,
@@ -443,7 +497,7 @@ func (sc *btsvTableScanner) Scan() bool {
 			}
 			sc.curLimit = scanLimit
 			if len(sc.shard.index.MarshaledContext) > 0 {
-				sc.unmarshalCtx = newUnmarshalContext(sc.shard.index.MarshaledContext)
+				sc.unmarshalCtx = newStoredUnmarshalContext(sc.shard.index.MarshaledContext)
 			} else {
 				// Old btsv format. They shouldn't use unmarshalctx at all, so leave it as nil.
 			}
@@ -462,18 +516,44 @@ func (sc *btsvTableScanner) Scan() bool {
 			sc.in = nil
 			continue
 		}
-		bytes := sc.rio.Get().([]byte)
-		sc.tmpDecoder.Reset(bytes)
-		sc.val = sc.unmarshalValue(sc.unmarshalCtx, sc.tmpDecoder)
-		if sc.tmpDecoder.Len() > 0 {
-			Panicf(sc.parent.ast, "btsv.Scan: %dB garbage at the end, value: %v", sc.tmpDecoder.Len(), sc.val)
-		}
+		sc.decode(sc.rio.Get().([]byte))
+		sc.budget.addRows(1)
 		return true
 	}
 }
 
 func (sc *btsvTableScanner) Value() Value { return sc.val }
 
+// newBTSVShardDecoder creates a scanner that is used only to decode records
+// read from the given shard by other means, e.g., after seeking to a block.
+func newBTSVShardDecoder(ctx context.Context, t *btsvTable, shard *btsvTableShard) *btsvTableScanner {
+	sc := newBTSVTableScanner(ctx, t, 0, 0)
+	sc.shard = shard
+	if len(shard.index.MarshaledContext) > 0 {
+		sc.unmarshalCtx = newStoredUnmarshalContext(shard.index.MarshaledContext)
+	}
+	return sc
+}
+
+// decode decodes a recordio record and stores the result in sc.val. A struct
+// row is decoded lazily; see btsvLazyStruct.
+func (sc *btsvTableScanner) decode(data []byte) Value {
+	if len(data) > 0 && data[0] == byte(StructType) {
+		// The recordio scanner reuses the buffer, so copy the row.
+		data = append([]byte(nil), data...)
+		sc.tmpDecoder.Reset(data)
+		sc.tmpDecoder.Byte()
+		sc.val = sc.unmarshalLazyStruct(sc.unmarshalCtx, sc.tmpDecoder, data)
+	} else {
+		sc.tmpDecoder.Reset(data)
+		sc.val = sc.unmarshalValue(sc.unmarshalCtx, sc.tmpDecoder)
+	}
+	if sc.tmpDecoder.Len() > 0 {
+		Panicf(sc.parent.ast, "btsv.Scan: %dB garbage at the end, value: %v", sc.tmpDecoder.Len(), sc.val)
+	}
+	return sc.val
+}
+
 // NewBTSVTable creates a Table implementation for a btsv table stored in
 // directory "path".  path must end with *.btsv. hash is an optional hash of the
 // inputs that derives the btsv table.
@@ -489,9 +569,11 @@ func NewBTSVTable(path string, ast ASTNode, hash hash.Hash) *btsvTable {
 // BTSVShardWriter creates a BTSV shard. Use NewBTSVShardWriter to create this
 // object..
 type BTSVShardWriter struct {
-	out   file.File
-	rio   recordio.Writer
-	attrs TableAttrs
+	out file.File
+	rio recordio.Writer
+	// outBytes counts the bytes written to out.
+	outBytes *countingWriter
+	attrs    TableAttrs
 
 	mu        sync.Mutex
 	colSorter *columnsorter.T
@@ -506,6 +588,28 @@ type BTSVShardWriter struct {
 
 	tmpPool    btsvTmpPool
 	tmpEncoder *marshal.Encoder
+
+	// index, if nonnil, collects the block-level column statistics.
+	index *btsvBlockIndexBuilder
+	// strDict, if nonnil, dictionary-encodes strings. See btsv_dict.go.
+	strDict *btsvStringDict
+}
+
+// btsvWriterOpts is the set of optional parameters for writing a btsv file.
+type btsvWriterOpts struct {
+	// indexCols lists the columns for which block indexes are created. See
+	// btsv_index.go.
+	indexCols []symbol.ID
+	// dictEncode enables dictionary encoding of strings. See btsv_dict.go.
+	dictEncode bool
+	// appendMode causes the rows to be added to the existing table, if any. The
+	// rows are written in new shard files whose names start with shardPrefix.
+	appendMode bool
+	// shardPrefix is prepended to the names of the shard files. Shard files are
+	// read in lexicographic order of their names, so appended shards, prefixed
+	// by "a<timestamp>-", come after the existing ones. See
+	// writeBTSVTable.
+	shardPrefix string
 }
 
 // Close must be called exactly once at the end of writes.
@@ -520,6 +624,9 @@ func (b *BTSVShardWriter) Close(ctx context.Context) {
 		},
 		Name:             b.attrs.Name,
 		Path:             b.attrs.Path,
+		TableDescription: b.attrs.Description,
+		Version:          b.attrs.Version,
+		Metadata:         marshalBTSVMetadata(b.attrs.Metadata),
 		MarshaledContext: b.marshalCtx.marshal(),
 	}
 	if b.attrs.Description != "" {
@@ -532,10 +639,11 @@ func (b *BTSVShardWriter) Close(ctx context.Context) {
 		}
 		col := *b.cols[colID]
 
-		// Copy the description from the attrs given by the caller.
+		// Copy the description and the unit from the attrs given by the caller.
 		for _, c := range b.attrs.Columns {
 			if c.Name == col.Name {
 				col.Description = c.Description
+				col.Unit = c.Unit
 				break
 			}
 		}
@@ -543,6 +651,9 @@ func (b *BTSVShardWriter) Close(ctx context.Context) {
 	}
 	idx.Rows = int64(b.nrows)
 	idx.TimeLocation = b.locs
+	if b.strDict != nil {
+		idx.StringDict = b.strDict.strings
+	}
 	idxData, err := idx.Marshal()
 	if err != nil {
 		log.Panicf("btsv index marshal: %v", err)
@@ -554,6 +665,10 @@ func (b *BTSVShardWriter) Close(ctx context.Context) {
 	if err := b.out.Close(ctx); err != nil {
 		log.Panicf("writebtsv %v: close: %v", b.out.Name(), err)
 	}
+	if b.index != nil {
+		b.index.write(ctx, b.out.Name())
+	}
+	reportFileWrite(b.out.Name(), b.outBytes.n)
 	log.Debug.Printf("btsvwriter: close %s", b.out.Name())
 }
 
@@ -596,6 +711,13 @@ func (b *BTSVShardWriter) getLocationID(t time.Time) int32 {
 
 // MarshalValue encodes the value and appends to "enc".
 func (b *BTSVShardWriter) marshalValue(ctx MarshalContext, enc *marshal.Encoder, v Value) {
+	if b.strDict != nil && (v.typ == StringType || v.typ == FileNameType || v.typ == EnumType) {
+		if id, ok := b.strDict.id(v.Str(nil)); ok {
+			enc.PutByte(byte(v.typ) | btsvDictEncodedTag)
+			enc.PutVarint(int64(id))
+			return
+		}
+	}
 	enc.PutByte(byte(v.typ))
 	switch v.typ {
 	case NullType:
@@ -623,6 +745,8 @@ func (b *BTSVShardWriter) marshalValue(ctx MarshalContext, enc *marshal.Encoder,
 		enc.PutVarint(int64(b.getLocationID(t)))
 	case TableType:
 		v.Table(nil).Marshal(ctx, enc)
+	case MatrixType:
+		marshalMatrix(v.Matrix(nil), enc)
 	case StructType:
 		s := v.Struct(nil)
 		nFields := s.Len()
@@ -655,15 +779,26 @@ func (b *BTSVShardWriter) Append(val Value) {
 // REQUIRES: path ends with ".btsv"
 //
 // Example:
-//  w := NewBTSVShardWriter("/tmp/foo.btsv", 0, 1, attrs)
-//  t := some Table
-//  s := t.Scanner(...)
-//  for s.Scan() {
-//    w.Append(s.Value())
-//  }
-//  w.Close()
+//
+//	w := NewBTSVShardWriter("/tmp/foo.btsv", 0, 1, attrs)
+//	t := some Table
+//	s := t.Scanner(...)
+//	for s.Scan() {
+//	  w.Append(s.Value())
+//	}
+//	w.Close()
 func NewBTSVShardWriter(ctx context.Context, dir string, shard, nshards int, attrs TableAttrs) *BTSVShardWriter {
+	return newBTSVShardWriter(ctx, dir, shard, nshards, attrs, btsvWriterOpts{})
+}
+
+// newBTSVShardWriter creates a BTSVShardWriter object. If opts.indexCols is
+// nonempty, it also creates a block index for the given columns. See
+// btsv_index.go for more details.
+func newBTSVShardWriter(ctx context.Context, dir string, shard, nshards int, attrs TableAttrs, opts btsvWriterOpts) *BTSVShardWriter {
 	path := BTSVShardPath(dir, shard, nshards)
+	if opts.shardPrefix != "" {
+		path = file.Join(dir, opts.shardPrefix+filepath.Base(path))
+	}
 	out, err := file.Create(ctx, path)
 	if err != nil {
 		log.Panicf("writebtsv %v: create: %v", path, err)
@@ -681,7 +816,10 @@ func NewBTSVShardWriter(ctx context.Context, dir string, shard, nshards int, att
 		tmpPool:    btsvTmpPool{New: func() btsvStructTmp { return btsvStructTmp{} }},
 		tmpEncoder: marshal.NewEncoder(nil),
 	}
-	w.rio = recordio.NewWriter(out.Writer(ctx), recordio.WriterOpts{
+	if opts.dictEncode {
+		w.strDict = newBTSVStringDict()
+	}
+	rioOpts := recordio.WriterOpts{
 		Transformers: []string{recordiozstd.Name},
 		Marshal: func(buf []byte, v interface{}) ([]byte, error) {
 			val := v.(Value)
@@ -692,7 +830,13 @@ func NewBTSVShardWriter(ctx context.Context, dir string, shard, nshards int, att
 			data := enc.Bytes()
 			w.mu.Unlock()
 			return data, nil
-		}})
+		}}
+	if len(opts.indexCols) > 0 {
+		w.index = newBTSVBlockIndexBuilder(opts.indexCols)
+		rioOpts.Index = w.index.add
+	}
+	w.outBytes = &countingWriter{w: out.Writer(ctx)}
+	w.rio = recordio.NewWriter(w.outBytes, rioOpts)
 	w.rio.AddHeader(recordio.KeyTrailer, true)
 	return w
 }
@@ -712,21 +856,36 @@ func (*btsvFileHandler) Open(ctx context.Context, path string, ast ASTNode, hash
 
 // Write implements FileHandler.
 func (*btsvFileHandler) Write(ctx context.Context, path string, ast ASTNode, table Table, nShard int, overwrite bool) {
+	writeBTSVTable(ctx, path, ast, table, nShard, overwrite, btsvWriterOpts{})
+}
+
+// writeBTSVTable writes the table in btsv format with the given options.
+func writeBTSVTable(ctx context.Context, path string, ast ASTNode, table Table, nShard int, overwrite bool, opts btsvWriterOpts) {
 	paths := listBTSVShardPaths(ctx, path, ast)
-	if len(paths) > 0 {
+	if opts.appendMode {
+		if len(paths) > 0 {
+			// The timestamp keeps the shard names unique across concurrent
+			// appenders, and orders the batches by time.
+			opts.shardPrefix = fmt.Sprintf("a%019d-", time.Now().UnixNano())
+		}
+	} else if len(paths) > 0 {
 		if !overwrite {
 			log.Printf("write %v: file already exists and --overwrite-files=false.", path)
 			return
 		}
 		err := traverse.Parallel.Each(len(paths), func(i int) error {
-			return file.Remove(ctx, path)
+			// Remove the stale block index, if any.
+			if err := file.Remove(ctx, btsvBlockIndexPath(paths[i])); err != nil && !os.IsNotExist(err) && !errors.Is(errors.NotExist, err) {
+				return err
+			}
+			return file.Remove(ctx, paths[i])
 		})
 		if err != nil {
 			Errorf(ast, "remove %s: %v", path, err)
 		}
 	}
 	traverse.Parallel.Each(nShard, func(shard int) error { // nolint: errcheck
-		w := NewBTSVShardWriter(ctx, path, shard, nShard, table.Attrs(ctx))
+		w := newBTSVShardWriter(ctx, path, shard, nShard, table.Attrs(ctx), opts)
 		sc := table.Scanner(ctx, shard, shard+1, nShard)
 		for sc.Scan() {
 			w.Append(sc.Value())
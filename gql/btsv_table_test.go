@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/grailbio/testutil"
@@ -139,3 +140,144 @@ func TestBTSVShardedScannerForSmallTable(t *testing.T) {
 		require.False(t, sc.Scan())
 	}
 }
+
+func TestBTSVBlockIndex(t *testing.T) {
+	ctx := context.Background()
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	srcPath := filepath.Join(tmpDir, "src.btsv")
+	dstPath := filepath.Join(tmpDir, "dst.btsv")
+	env := gqltest.NewSession()
+
+	const nRows = 10000
+	w := gql.NewBTSVShardWriter(ctx, srcPath, 0, 1, gql.TableAttrs{})
+	for i := 0; i < nRows; i++ {
+		w.Append(gql.NewStruct(gql.NewSimpleStruct(
+			gql.StructField{Name: symbol.Intern("id"), Value: gql.NewString(fmt.Sprintf("s%05d", i))},
+			gql.StructField{Name: symbol.Intern("n"), Value: gql.NewInt(int64(i % 100))})))
+	}
+	w.Close(ctx)
+	gqltest.Eval(t, fmt.Sprintf("read(`%s`) | write(`%s`, shards:=3, index:={&id, &n})", srcPath, dstPath), env)
+
+	assert.Equal(t,
+		[]string{"{id:s01234,n:34}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`) | filter(&id == \"s01234\")", dstPath), env)))
+	assert.Equal(t,
+		[]string{"{id:s01234,n:34}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`) | filter(|r| \"s01234\" == r.id && r.n == 34)", dstPath), env)))
+	assert.Equal(t,
+		[]string{},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`) | filter(&id == \"nonexistent\")", dstPath), env)))
+	assert.Equal(t,
+		int64(100),
+		gqltest.Eval(t, fmt.Sprintf("read(`%s`) | filter(&n == 12) | count()", dstPath), env).Int(nil))
+	assert.Equal(t,
+		int64(100),
+		gqltest.Eval(t, fmt.Sprintf("read(`%s`) | filter(&n == 12, shards:=2) | count()", dstPath), env).Int(nil))
+}
+
+func TestBTSVKeyIndex(t *testing.T) {
+	ctx := context.Background()
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	path := filepath.Join(tmpDir, "ref.btsv")
+	env := gqltest.NewSession()
+
+	const nRows = 10000
+	for shard := 0; shard < 2; shard++ {
+		w := gql.NewBTSVShardWriter(ctx, path, shard, 2, gql.TableAttrs{})
+		for i := shard * nRows / 2; i < (shard+1)*nRows/2; i++ {
+			w.Append(gql.NewStruct(gql.NewSimpleStruct(
+				gql.StructField{Name: symbol.Intern("id"), Value: gql.NewString(fmt.Sprintf("s%05d", i))},
+				gql.StructField{Name: symbol.Intern("n"), Value: gql.NewInt(int64(i % 1000))})))
+		}
+		w.Close(ctx)
+	}
+	assert.Equal(t, path+".idx",
+		gqltest.Eval(t, fmt.Sprintf("read(`%s`) | build_index(key:=&id)", path), env).Str(nil))
+	assert.Equal(t,
+		[]string{"{id:s07777,n:777}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`) | lookup(\"s07777\")", path), env)))
+	assert.Equal(t,
+		[]string{},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`) | lookup(\"nonexistent\")", path), env)))
+
+	idxPath := filepath.Join(tmpDir, "n.idx")
+	gqltest.Eval(t, fmt.Sprintf("read(`%s`) | build_index(key:=&n, path:=`%s`)", path, idxPath), env)
+	assert.Equal(t,
+		[]string{"{id:s00012,n:12}", "{id:s01012,n:12}", "{id:s02012,n:12}", "{id:s03012,n:12}", "{id:s04012,n:12}",
+			"{id:s05012,n:12}", "{id:s06012,n:12}", "{id:s07012,n:12}", "{id:s08012,n:12}", "{id:s09012,n:12}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`) | lookup(12, index:=`%s`)", path, idxPath), env)))
+}
+
+func TestBTSVDictEncode(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	long := strings.Repeat("x", 1000)
+	gqltest.Eval(t, fmt.Sprintf(`T0 := table(
+  {sample:"s0", chrom:"chr1", note:"%s"},
+  {sample:"s1", chrom:"chr1", note:""},
+  {sample:"s0", chrom:"chr2", note:NA},
+  {sample:"s1", chrom:"chr1", note:"%s"})`, long, long), env)
+	want := gqltest.ReadTable(gqltest.Eval(t, "T0", env))
+	for _, nShard := range []int{1, 2} {
+		path := filepath.Join(tmpDir, fmt.Sprintf("dict%d.btsv", nShard))
+		gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`, shards:=%d, dict_encode:=true)", path, nShard), env)
+		got := gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", path), env))
+		assert.Equal(t, want, got)
+		// Filters against the decoded strings must work as usual.
+		assert.Equal(t, []string{"2"},
+			gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("table(read(`%s`) | filter($sample==\"s1\") | count())", path), env)))
+	}
+}
+
+func TestBTSVLazyStruct(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	// data2.tsv has filename, enum, date, and datetime columns.
+	tsvPath := "./testdata/data2.tsv"
+	want := gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", tsvPath), env))
+	path := filepath.Join(tmpDir, "data2.btsv")
+	gqltest.Eval(t, fmt.Sprintf("read(`%s`) | write(`%s`)", tsvPath, path), env)
+	assert.Equal(t, want, gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", path), env)))
+	assert.Equal(t, []string{"{E:x}", "{E:y}", "{E:z}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`) | map({$E})", path), env)))
+
+	path = filepath.Join(tmpDir, "mixed.btsv")
+	gqltest.Eval(t, fmt.Sprintf("table({a:1, b:-NA, c:true, d:'x', e:{f:2.5, g:\"y\"}, h:table({i:3})}) | write(`%s`)", path), env)
+	assert.Equal(t, []string{"{h:[{i:3}],c:true,e:{f:2.5,g:y},a:1}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`) | map({$h, $c, $e, $a})", path), env)))
+	assert.Equal(t, []string{"1"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("table(read(`%s`) | filter(isnull($b) && $d=='x') | count())", path), env)))
+}
+
+func TestBTSVAttrs(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	ctx := context.Background()
+	tsvPath := filepath.Join(tmpDir, "src.tsv")
+	gqltest.Eval(t, fmt.Sprintf("table({id:`a`, conc:1.5}, {id:`b`, conc:2.5}) | write(`%s`, metadata:={min_depth:10})", tsvPath), env)
+	gqltest.Eval(t, fmt.Sprintf(
+		"T0 := read(`%s`) | with_attrs(name:=`qc`, description:=`QC metrics, run 1`, columns:={id:`sample ID`}, units:={conc:`ng/mL`})", tsvPath), env)
+	want := gqltest.Eval(t, "T0", env).Table(nil).Attrs(ctx)
+	require.Equal(t, "{min_depth:10}", want.Metadata["params"])
+
+	path := filepath.Join(tmpDir, "attrs.btsv")
+	gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`)", path), env)
+	got := gqltest.Eval(t, fmt.Sprintf("read(`%s`)", path), env).Table(nil).Attrs(ctx)
+	assert.Equal(t, "qc", got.Name)
+	assert.Equal(t, tsvPath, got.Path)
+	assert.Equal(t, "QC metrics, run 1", got.Description)
+	assert.Equal(t, want.Metadata, got.Metadata)
+	require.Len(t, got.Columns, 2)
+	assert.Equal(t, gql.TSVColumn{Name: "id", Type: gql.StringType, Description: "sample ID"}, got.Columns[0])
+	assert.Equal(t, gql.TSVColumn{Name: "conc", Type: gql.FloatType, Unit: "ng/mL"}, got.Columns[1])
+
+	// The attributes survive another round trip.
+	path2 := filepath.Join(tmpDir, "attrs-copy.btsv")
+	gqltest.Eval(t, fmt.Sprintf("read(`%s`) | write(`%s`)", path, path2), env)
+	assert.Equal(t, got, gqltest.Eval(t, fmt.Sprintf("read(`%s`)", path2), env).Table(nil).Attrs(ctx))
+}
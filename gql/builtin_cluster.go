@@ -0,0 +1,500 @@
+package gql
+
+// This file implements kmeans and hclust.
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+)
+
+const kmeansMaxIterations = 100
+
+// maxHClustPoints is the max number of points hclust accepts. Hclust keeps the
+// n*(n-1)/2 pairwise distances in memory.
+var maxHClustPoints = 10000
+
+var (
+	clusterClustersSymbolID = symbol.Intern("clusters")
+	clusterCentersSymbolID  = symbol.Intern("centers")
+	clusterMergesSymbolID   = symbol.Intern("merges")
+	clusterClusterSymbolID  = symbol.Intern("cluster")
+	clusterSizeSymbolID     = symbol.Intern("size")
+	clusterStepSymbolID     = symbol.Intern("step")
+	clusterHeightSymbolID   = symbol.Intern("height")
+)
+
+// clusterPoints is the input to a clustering algorithm.
+type clusterPoints struct {
+	// ids[i] are the fields copied to the i'th row of the result.
+	ids [][]StructField
+	// cols are the names of the coordinates.
+	cols []symbol.ID
+	// data stores the coordinates of the points, row-major.
+	data []float64
+	hash hash.Hash
+}
+
+func (pts *clusterPoints) n() int { return len(pts.ids) }
+
+// point returns the coordinates of the i'th point.
+func (pts *clusterPoints) point(i int) []float64 {
+	p := len(pts.cols)
+	return pts.data[i*p : (i+1)*p]
+}
+
+// readClusterPoints reads the points to cluster. Arg v is either a matrix, in
+// which case each row is a point, or a table. For a table, cols lists the
+// coordinate columns; if it is empty, the int and float columns of the first
+// row are used. The other columns are copied to the result.
+func readClusterPoints(ctx context.Context, ast ASTNode, name string, v Value, cols []symbol.ID) *clusterPoints {
+	if v.Type() == MatrixType {
+		if len(cols) > 0 {
+			Panicf(ast, "%s: columns cannot be specified for a matrix", name)
+		}
+		m := v.Matrix(ast)
+		pts := &clusterPoints{data: m.Data, hash: v.Hash()}
+		for _, col := range m.ColNames {
+			pts.cols = append(pts.cols, symbol.Intern(col))
+		}
+		for _, row := range m.RowNames {
+			pts.ids = append(pts.ids, []StructField{{Name: symbol.Row, Value: NewString(row)}})
+		}
+		for _, x := range m.Data {
+			if math.IsNaN(x) {
+				Panicf(ast, "%s: the matrix has an NA cell", name)
+			}
+		}
+		return pts
+	}
+	t := v.Table(ast)
+	pts := &clusterPoints{cols: cols, hash: t.Hash()}
+	colIndex := map[symbol.ID]bool{}
+	sc := t.Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		row := sc.Value().Struct(ast)
+		if len(pts.cols) == 0 {
+			for fi := 0; fi < row.Len(); fi++ {
+				if f := row.Field(fi); f.Value.Type() == IntType || f.Value.Type() == FloatType {
+					pts.cols = append(pts.cols, f.Name)
+				}
+			}
+			if len(pts.cols) == 0 {
+				Panicf(ast, "%s: no numeric column found in %v", name, sc.Value())
+			}
+		}
+		if len(colIndex) == 0 {
+			for _, col := range pts.cols {
+				colIndex[col] = true
+			}
+		}
+		for _, col := range pts.cols {
+			v, ok := row.Value(col)
+			if !ok {
+				Panicf(ast, "%s: column '%s' not found%s", name, col.Str(), columnNotFoundHint(col, row))
+			}
+			switch v.Type() {
+			case IntType:
+				pts.data = append(pts.data, float64(v.Int(ast)))
+			case FloatType:
+				pts.data = append(pts.data, v.Float(ast))
+			default:
+				Panicf(ast, "%s: column '%s' must be a non-NA number, but found %v", name, col.Str(), v)
+			}
+		}
+		var ids []StructField
+		for fi := 0; fi < row.Len(); fi++ {
+			if f := row.Field(fi); !colIndex[f.Name] {
+				ids = append(ids, f)
+			}
+		}
+		pts.ids = append(pts.ids, ids)
+	}
+	for _, col := range pts.cols {
+		pts.hash = pts.hash.Merge(col.Hash())
+	}
+	return pts
+}
+
+// clusterAssignmentTable creates a table that lists the cluster of each point.
+func clusterAssignmentTable(pts *clusterPoints, clusters []int, h hash.Hash) Value {
+	rows := make([]Value, pts.n())
+	for i, ids := range pts.ids {
+		fields := make([]StructField, 0, len(ids)+1)
+		fields = append(fields, ids...)
+		fields = append(fields, StructField{Name: clusterClusterSymbolID, Value: NewInt(int64(clusters[i]))})
+		rows[i] = NewStruct(NewSimpleStruct(fields...))
+	}
+	return NewTable(NewSimpleTable(rows, h.Merge(hash.String("clusters")), TableAttrs{Name: "clusters"}))
+}
+
+// kmeans runs Lloyd's algorithm with k-means++ initialization. It returns the
+// cluster of each point (0-based) and the centers (k*p, row-major).
+func kmeans(pts *clusterPoints, k int, seed int64) ([]int, []float64) {
+	n, p := pts.n(), len(pts.cols)
+	r := rand.New(rand.NewSource(seed))
+	centers := make([]float64, k*p)
+	center := func(c int) []float64 { return centers[c*p : (c+1)*p] }
+
+	// K-means++: pick each center with probability proportional to the squared
+	// distance to the nearest center picked so far.
+	dist := make([]float64, n)
+	copy(center(0), pts.point(r.Intn(n)))
+	for i := range dist {
+		dist[i] = squaredDistance(pts.point(i), center(0))
+	}
+	for c := 1; c < k; c++ {
+		total := 0.0
+		for _, d := range dist {
+			total += d
+		}
+		next := r.Intn(n)
+		if total > 0 {
+			x := r.Float64() * total
+			for i, d := range dist {
+				if x -= d; x <= 0 {
+					next = i
+					break
+				}
+			}
+		}
+		copy(center(c), pts.point(next))
+		for i := range dist {
+			dist[i] = math.Min(dist[i], squaredDistance(pts.point(i), center(c)))
+		}
+	}
+
+	clusters := make([]int, n)
+	counts := make([]int, k)
+	for it := 0; it < kmeansMaxIterations; it++ {
+		changed := false
+		for i := 0; i < n; i++ {
+			best, bestDist := 0, math.Inf(1)
+			for c := 0; c < k; c++ {
+				if d := squaredDistance(pts.point(i), center(c)); d < bestDist {
+					best, bestDist = c, d
+				}
+			}
+			if it == 0 || clusters[i] != best {
+				clusters[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		// Recompute the centers. An empty cluster keeps its old center.
+		for c := range counts {
+			counts[c] = 0
+		}
+		sums := make([]float64, k*p)
+		for i, c := range clusters {
+			counts[c]++
+			for j, x := range pts.point(i) {
+				sums[c*p+j] += x
+			}
+		}
+		for c := 0; c < k; c++ {
+			if counts[c] == 0 {
+				continue
+			}
+			for j := 0; j < p; j++ {
+				centers[c*p+j] = sums[c*p+j] / float64(counts[c])
+			}
+		}
+	}
+	return clusters, centers
+}
+
+// hclustMerge is a merge step of the hierarchical clustering. Points a and b
+// belong to the two clusters being merged.
+type hclustMerge struct {
+	a, b   int
+	height float64
+	size   int
+}
+
+// hclust runs agglomerative hierarchical clustering using the nearest-neighbor
+// chain algorithm. It returns the merges sorted by height.
+func hclust(pts *clusterPoints, linkage string) []hclustMerge {
+	n := pts.n()
+	// dist stores the distances between active clusters i>j at i*(i-1)/2+j. A
+	// cluster is identified by one of its points.
+	dist := make([]float64, n*(n-1)/2)
+	d := func(i, j int) *float64 {
+		if i < j {
+			i, j = j, i
+		}
+		return &dist[i*(i-1)/2+j]
+	}
+	for i := 1; i < n; i++ {
+		for j := 0; j < i; j++ {
+			*d(i, j) = math.Sqrt(squaredDistance(pts.point(i), pts.point(j)))
+		}
+	}
+	size := make([]int, n)
+	active := make([]bool, n)
+	for i := range size {
+		size[i] = 1
+		active[i] = true
+	}
+	var (
+		merges []hclustMerge
+		chain  []int
+	)
+	for nActive := n; nActive > 1; {
+		if len(chain) == 0 {
+			for i := range active {
+				if active[i] {
+					chain = append(chain, i)
+					break
+				}
+			}
+		}
+		a := chain[len(chain)-1]
+		prev := -1
+		if len(chain) >= 2 {
+			prev = chain[len(chain)-2]
+		}
+		// Find the nearest neighbor of a, preferring prev on a tie.
+		b, bDist := prev, math.Inf(1)
+		if prev >= 0 {
+			bDist = *d(a, prev)
+		}
+		for i := range active {
+			if i == a || !active[i] {
+				continue
+			}
+			if di := *d(a, i); di < bDist {
+				b, bDist = i, di
+			}
+		}
+		if b != prev {
+			chain = append(chain, b)
+			continue
+		}
+		// a and b are reciprocal nearest neighbors. Merge b into a.
+		chain = chain[:len(chain)-2]
+		for k := range active {
+			if k == a || k == b || !active[k] {
+				continue
+			}
+			dka, dkb := *d(k, a), *d(k, b)
+			var v float64
+			switch linkage {
+			case "single":
+				v = math.Min(dka, dkb)
+			case "complete":
+				v = math.Max(dka, dkb)
+			default: // average
+				v = (float64(size[a])*dka + float64(size[b])*dkb) / float64(size[a]+size[b])
+			}
+			*d(k, a) = v
+		}
+		size[a] += size[b]
+		active[b] = false
+		nActive--
+		merges = append(merges, hclustMerge{a: a, b: b, height: bDist, size: size[a]})
+	}
+	sort.SliceStable(merges, func(i, j int) bool { return merges[i].height < merges[j].height })
+	return merges
+}
+
+// cutHClust assigns clusters by applying the first nMerges merges. Clusters are
+// numbered from 0 in order of their first point.
+func cutHClust(n int, merges []hclustMerge, nMerges int) []int {
+	parent := make([]int, n)
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for _, m := range merges[:nMerges] {
+		parent[find(m.b)] = find(m.a)
+	}
+	ids := map[int]int{}
+	clusters := make([]int, n)
+	for i := range clusters {
+		root := find(i)
+		id, ok := ids[root]
+		if !ok {
+			id = len(ids)
+			ids[root] = id
+		}
+		clusters[i] = id
+	}
+	return clusters
+}
+
+// clusterCols extracts the column names from the variadic args.
+func clusterCols(args []ActualArg) []symbol.ID {
+	cols := make([]symbol.ID, 0, len(args))
+	for _, arg := range args {
+		cols = append(cols, symbol.Intern(arg.Str()))
+	}
+	return cols
+}
+
+func init() {
+	RegisterBuiltinFunc("kmeans",
+		`
+    x | kmeans([col..., k:=k, seed:=seed])
+
+Arg types:
+
+- _x_: table or matrix
+- _col_: string
+- _k_: int (default 2)
+- _seed_: int (default 0)
+
+Kmeans partitions the points into _k_ clusters using the k-means algorithm with
+k-means++ initialization. If _x_ is a table, each row is a point, and the
+columns _col_ are its coordinates. If no _col_ is given, the int and float
+columns of the first row are used. If _x_ is a matrix, each matrix row is a
+point. NA values are not allowed. _Seed_ seeds the random number generator, so
+the result is deterministic for a given seed.
+
+Kmeans returns a struct with two tables:
+
+- "clusters": one row per point. It has the non-coordinate columns of the row
+  (the row name for a matrix), followed by column "cluster", the 0-based cluster
+  index.
+
+- "centers": one row per cluster. It has columns "cluster", "size" (the number
+  of points), and the coordinates of the center.
+
+Example:
+
+    km := read("betas.tsv") | kmeans(k:=3, seed:=1)
+    km.clusters | filter($cluster == 0)
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			n := len(args)
+			k, seed := int(args[n-2].Int()), args[n-1].Int()
+			pts := readClusterPoints(ctx, ast, "kmeans", args[0].Value, clusterCols(args[1:n-2]))
+			if k <= 0 || k > pts.n() {
+				Panicf(ast, "kmeans: k must be in [1, %d], but found %d", pts.n(), k)
+			}
+			clusters, centers := kmeans(pts, k, seed)
+			h := hash.String("kmeans").Merge(pts.hash).Merge(hash.Int(int64(k))).Merge(hash.Int(seed))
+			p := len(pts.cols)
+			sizes := make([]int, k)
+			for _, c := range clusters {
+				sizes[c]++
+			}
+			centerRows := make([]Value, k)
+			for c := range centerRows {
+				fields := []StructField{
+					{Name: clusterClusterSymbolID, Value: NewInt(int64(c))},
+					{Name: clusterSizeSymbolID, Value: NewInt(int64(sizes[c]))},
+				}
+				for j, col := range pts.cols {
+					fields = append(fields, StructField{Name: col, Value: NewFloat(centers[c*p+j])})
+				}
+				centerRows[c] = NewStruct(NewSimpleStruct(fields...))
+			}
+			return NewStruct(NewSimpleStruct(
+				StructField{Name: clusterClustersSymbolID, Value: clusterAssignmentTable(pts, clusters, h)},
+				StructField{Name: clusterCentersSymbolID, Value: NewTable(NewSimpleTable(centerRows, h.Merge(hash.String("centers")), TableAttrs{Name: "centers"}))}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIStructType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType, MatrixType}},
+		FormalArg{Positional: true, Variadic: true, DefaultValue: Null, Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.K, DefaultValue: NewInt(2), Types: []ValueType{IntType}},
+		FormalArg{Name: symbol.Seed, DefaultValue: NewInt(0), Types: []ValueType{IntType}})
+
+	RegisterBuiltinFunc("hclust",
+		`
+    x | hclust([col..., cut:=height, k:=k, linkage:=linkage])
+
+Arg types:
+
+- _x_: table or matrix
+- _col_: string
+- _height_: float or int
+- _k_: int
+- _linkage_: string, one of "average" (default), "complete", or "single"
+
+Hclust runs agglomerative hierarchical clustering of the points using the
+Euclidean distance. The points are read as in kmeans. Exactly one of _cut_ and
+_k_ must be given. If _cut_ is given, the tree is cut at the given height,
+i.e., two clusters are merged iff their distance is at most _height_. If _k_ is
+given, the tree is cut so that there are _k_ clusters.
+
+Hclust returns a struct with two tables:
+
+- "clusters": one row per point, as in kmeans. The clusters are numbered from
+  0 in order of their first point.
+
+- "merges": one row per merge step, in increasing order of height. It has
+  columns "step", "height" (the distance between the merged clusters), and
+  "size" (the size of the merged cluster). It helps choosing the cut height.
+
+Hclust keeps all the pairwise distances in memory, so it accepts at most 10000
+points.
+
+Example:
+
+    read("betas.tsv") | hclust(cut:=0.5, linkage:="complete")
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			n := len(args)
+			cut, k, linkage := -1.0, int(args[n-2].Int()), args[n-1].Str()
+			switch cutArg := args[n-3]; cutArg.Value.Type() {
+			case IntType:
+				cut = float64(cutArg.Int())
+			case FloatType:
+				cut = cutArg.Float()
+			}
+			switch linkage {
+			case "average", "complete", "single":
+			default:
+				Panicf(ast, "hclust: linkage must be one of \"average\", \"complete\", or \"single\", but found \"%s\"", linkage)
+			}
+			if (cut >= 0) == (k > 0) {
+				Panicf(ast, "hclust: exactly one of cut:= and k:= must be set")
+			}
+			pts := readClusterPoints(ctx, ast, "hclust", args[0].Value, clusterCols(args[1:n-3]))
+			if pts.n() == 0 || pts.n() > maxHClustPoints {
+				Panicf(ast, "hclust: the number of points must be in [1, %d], but found %d", maxHClustPoints, pts.n())
+			}
+			merges := hclust(pts, linkage)
+			nMerges := 0
+			if k > 0 {
+				if k > pts.n() {
+					Panicf(ast, "hclust: k must be in [1, %d], but found %d", pts.n(), k)
+				}
+				nMerges = pts.n() - k
+			} else {
+				for nMerges < len(merges) && merges[nMerges].height <= cut {
+					nMerges++
+				}
+			}
+			h := hash.String("hclust").Merge(pts.hash).Merge(hash.Float(cut)).Merge(hash.Int(int64(k))).Merge(hash.String(linkage))
+			mergeRows := make([]Value, len(merges))
+			for i, m := range merges {
+				mergeRows[i] = NewStruct(NewSimpleStruct(
+					StructField{Name: clusterStepSymbolID, Value: NewInt(int64(i))},
+					StructField{Name: clusterHeightSymbolID, Value: NewFloat(m.height)},
+					StructField{Name: clusterSizeSymbolID, Value: NewInt(int64(m.size))}))
+			}
+			return NewStruct(NewSimpleStruct(
+				StructField{Name: clusterClustersSymbolID, Value: clusterAssignmentTable(pts, cutHClust(pts.n(), merges, nMerges), h)},
+				StructField{Name: clusterMergesSymbolID, Value: NewTable(NewSimpleTable(mergeRows, h.Merge(hash.String("merges")), TableAttrs{Name: "merges"}))}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIStructType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType, MatrixType}},
+		FormalArg{Positional: true, Variadic: true, DefaultValue: Null, Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.Cut, DefaultValue: NewFloat(-1), Types: []ValueType{FloatType, IntType}},
+		FormalArg{Name: symbol.K, DefaultValue: NewInt(0), Types: []ValueType{IntType}},
+		FormalArg{Name: symbol.Linkage, DefaultValue: NewString("average"), Types: []ValueType{StringType}})
+}
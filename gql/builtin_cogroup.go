@@ -19,7 +19,7 @@ var parallelCogroupFunc = bigslice.Func(func(
 	marshaledSrcTable []byte,
 	nshards int) (slice bigslice.Slice) {
 	ctx := newUnmarshalContext(marshaledConfig)
-	ast, srcTable, keyExpr, mapExpr := unmarshalCogroupArgs(ctx, marshaledSrcTable)
+	ast, srcTable, keyExpr, mapExpr, opts := unmarshalCogroupArgs(ctx, marshaledSrcTable)
 	type shardState struct {
 		scanner          TableScanner
 		nrows            int
@@ -50,9 +50,10 @@ var parallelCogroupFunc = bigslice.Func(func(
 					Logf(ast, "read %+v: shard %d/%d: %d rows", srcTable.Attrs(ctx.ctx), shard, nshards, (*scanner).nrows)
 				}
 				row := (*scanner).scanner.Value()
-				key := (*scanner).keyExpr.Eval(ctx.ctx, row)
+				index := (*scanner).nrows - 1
+				key := evalRow(ctx.ctx, (*scanner).keyExpr, srcTable, index, row)
 				if (*scanner).mapExpr != nil {
-					row = (*scanner).mapExpr.Eval(ctx.ctx, row)
+					row = evalRow(ctx.ctx, (*scanner).mapExpr, srcTable, index, row)
 				}
 				rows[i] = row
 				keys[i] = key
@@ -70,17 +71,9 @@ var parallelCogroupFunc = bigslice.Func(func(
 		)
 		nrows := 0
 		for scan.Scan(ctx.ctx, &key, &values) {
-			subTableHash := hash.Hash{
-				0x6a, 0x59, 0xe5, 0x5a, 0x29, 0x53, 0x9d, 0xdb,
-				0x00, 0x65, 0x25, 0x16, 0xb5, 0x43, 0xf5, 0x62,
-				0x88, 0x87, 0x63, 0x76, 0x1a, 0xc5, 0xf1, 0xf4,
-				0x67, 0x9d, 0xf5, 0x4e, 0x24, 0xa0, 0x43, 0x8c}
-			subTableHash = subTableHash.Merge(tableHash).Merge(key.Hash())
-			subTable := NewTable(NewSimpleTable(values, subTableHash, TableAttrs{}))
-			row := NewStruct(NewSimpleStruct(
-				StructField{Name: symbol.Key, Value: key},
-				StructField{Name: symbol.Value, Value: subTable}))
-			w.Append(row)
+			if row, ok := cogroupRow(ctx.ctx, ast, tableHash, key, values, opts); ok {
+				w.Append(row)
+			}
 		}
 		if err := scan.Err(); err != nil {
 			Panicf(ast, "scan: %v", err)
@@ -92,6 +85,66 @@ var parallelCogroupFunc = bigslice.Func(func(
 	return
 })
 
+// cogroupOpts are the optional args of cogroup that shape the output rows.
+type cogroupOpts struct {
+	// keyColumns causes the fields of a struct key to be emitted as top-level
+	// columns, in place of the "key" column.
+	keyColumns bool
+	// count adds column "count", the number of rows in the group.
+	count bool
+	// having, if non-nil, is evaluated on each output row. The groups for which
+	// it is false or NA are dropped.
+	having *Func
+}
+
+func (o cogroupOpts) marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	enc.PutBool(o.keyColumns)
+	enc.PutBool(o.count)
+	o.having.Marshal(ctx, enc)
+}
+
+func unmarshalCogroupOpts(ctx UnmarshalContext, dec *marshal.Decoder) (o cogroupOpts) {
+	o.keyColumns = dec.Bool()
+	o.count = dec.Bool()
+	o.having = unmarshalFunc(ctx, dec)
+	return
+}
+
+// cogroupRow creates an output row of cogroup, {key, value}, where value is
+// the table of the rows with the given key. The row is reshaped as specified by
+// opts. It returns false if opts.having drops the group.
+func cogroupRow(ctx context.Context, ast ASTNode, tableHash hash.Hash, key Value, values []Value, opts cogroupOpts) (Value, bool) {
+	subTableHash := hash.Hash{
+		0x6a, 0x59, 0xe5, 0x5a, 0x29, 0x53, 0x9d, 0xdb,
+		0x00, 0x65, 0x25, 0x16, 0xb5, 0x43, 0xf5, 0x62,
+		0x88, 0x87, 0x63, 0x76, 0x1a, 0xc5, 0xf1, 0xf4,
+		0x67, 0x9d, 0xf5, 0x4e, 0x24, 0xa0, 0x43, 0x8c}
+	subTableHash = subTableHash.Merge(tableHash).Merge(key.Hash())
+	subTable := NewTable(NewSimpleTable(values, subTableHash, TableAttrs{}))
+	var fields []StructField
+	if opts.keyColumns && key.Type() == StructType {
+		ks := key.Struct(ast)
+		for fi := 0; fi < ks.Len(); fi++ {
+			f := ks.Field(fi)
+			if f.Name == symbol.Value || (opts.count && f.Name == symbol.Count) {
+				Panicf(ast, "cogroup: key field '%s' collides with the column of the same name; rename the field in the key expression", f.Name.Str())
+			}
+			fields = append(fields, f)
+		}
+	} else {
+		fields = append(fields, StructField{Name: symbol.Key, Value: key})
+	}
+	if opts.count {
+		fields = append(fields, StructField{Name: symbol.Count, Value: NewInt(int64(len(values)))})
+	}
+	fields = append(fields, StructField{Name: symbol.Value, Value: subTable})
+	row := NewStruct(NewSimpleStruct(fields...))
+	if opts.having != nil && !evalPredicate(ctx, ast, opts.having, row) {
+		return row, false
+	}
+	return row, true
+}
+
 // parallelCogroupTable implements a table that does filter, then map.
 type parallelCogroupTable struct {
 	hashOnce sync.Once
@@ -102,6 +155,7 @@ type parallelCogroupTable struct {
 	// Bindings for keyExpr and redcueExpr
 	keyExpr *Func
 	mapExpr *Func
+	opts    cogroupOpts
 
 	// # of bigslice shards to run.
 	nshards int
@@ -115,11 +169,12 @@ type parallelCogroupTable struct {
 	len     int
 }
 
-func marshalCogroupArgs(ctx MarshalContext, enc *marshal.Encoder, ast ASTNode, src Table, keyExpr, mapExpr *Func) {
+func marshalCogroupArgs(ctx MarshalContext, enc *marshal.Encoder, ast ASTNode, src Table, keyExpr, mapExpr *Func, opts cogroupOpts) {
 	enc.PutGOB(&ast)
 	src.Marshal(ctx, enc)
 	keyExpr.Marshal(ctx, enc)
 	mapExpr.Marshal(ctx, enc)
+	opts.marshal(ctx, enc)
 }
 
 func (t *parallelCogroupTable) init(ctx context.Context) {
@@ -130,11 +185,19 @@ func (t *parallelCogroupTable) init(ctx context.Context) {
 		if found {
 			Logf(t.ast, "cache hit: %s", btsvPath)
 		} else {
+			defer abandonCacheEntry(ctx, btsvPath)
 			Logf(t.ast, "start bigslice for table %v", btsvPath)
-			if _, err := bsSession.Run(ctx, parallelCogroupFunc, t.marshalledEnv, tableHash, btsvPath, t.marshalledTable, t.nshards); err != nil {
-				log.Panic(err)
-			}
+			runJob(ctx, t.ast, "cogroup", t.nshards, func(ctx context.Context) {
+				groups := groupLocally(ctx, t.ast, t.src, t.nshards, t.keyExpr, t.mapExpr, nil)
+				writeLocalGroups(ctx, btsvPath, groups, func(g localGroup) (Value, bool) {
+					return cogroupRow(ctx, t.ast, tableHash, g.key, g.values, t.opts)
+				})
+			}, func() error {
+				_, err := bsSession.Run(ctx, parallelCogroupFunc, t.marshalledEnv, tableHash, btsvPath, t.marshalledTable, t.nshards)
+				return err
+			})
 			ActivateCache(ctx, cacheName, btsvPath)
+			reportTableMaterialized(tableHash, btsvPath, -1)
 			Logf(t.ast, "finished bigslice for table %v", btsvPath)
 		}
 		t.btsvTable = NewBTSVTable(btsvPath, t.ast, tableHash)
@@ -162,7 +225,7 @@ func (t *parallelCogroupTable) Prefetch(ctx context.Context) { t.init(ctx) }
 func (t *parallelCogroupTable) Hash() hash.Hash {
 	t.hashOnce.Do(func() {
 		if t.hash == hash.Zero { // hash != Zero if it is unmarshalled on a remote machine.
-			t.hash = hashCogroupCall(t.src, t.keyExpr, t.mapExpr)
+			t.hash = hashCogroupCall(t.src, t.keyExpr, t.mapExpr, t.opts)
 		}
 	})
 	return t.hash
@@ -173,12 +236,68 @@ func (t *parallelCogroupTable) Scanner(ctx context.Context, start, limit, nshard
 	return t.btsvTable.Scanner(ctx, start, limit, nshards)
 }
 
-func unmarshalCogroupArgs(ctx UnmarshalContext, data []byte) (ast ASTNode, src Table, keyExpr, mapExpr *Func) {
+// localCogroupTable implements cogroup with shards<=0. It groups the rows in
+// memory, in one scan of the source table. The groups are sorted by key, as in
+// the output of bigslice with shards:=1.
+type localCogroupTable struct {
+	hash     hash.Hash
+	ast      ASTNode
+	srcTable Table
+	keyExpr  *Func
+	mapExpr  *Func
+	opts     cogroupOpts
+
+	once  sync.Once
+	table Table // the grouped rows, set in init.
+
+	lenOnce sync.Once
+	len     int
+}
+
+func (t *localCogroupTable) init(ctx context.Context) {
+	t.once.Do(func() {
+		groups := groupLocally(ctx, t.ast, t.srcTable, 1, t.keyExpr, t.mapExpr, nil)
+		rows := make([]Value, 0, len(groups))
+		for _, g := range groups {
+			if row, ok := cogroupRow(ctx, t.ast, t.hash, g.key, g.values, t.opts); ok {
+				rows = append(rows, row)
+			}
+		}
+		t.table = NewSimpleTable(rows, t.hash, TableAttrs{Name: "cogroup"})
+	})
+}
+
+func (t *localCogroupTable) Len(ctx context.Context, mode CountMode) int {
+	if mode == Approx {
+		return 10000
+	}
+	t.lenOnce.Do(func() { t.len = DefaultTableLen(ctx, t) })
+	return t.len
+}
+
+func (t *localCogroupTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	MarshalTableOutline(ctx, enc, t)
+}
+
+func (t *localCogroupTable) Attrs(ctx context.Context) TableAttrs {
+	return TableAttrs{Name: "cogroup", Path: t.srcTable.Attrs(ctx).Path}
+}
+
+func (t *localCogroupTable) Hash() hash.Hash              { return t.hash }
+func (t *localCogroupTable) Prefetch(ctx context.Context) { go Recover(func() { t.init(ctx) }) }
+
+func (t *localCogroupTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	t.init(ctx)
+	return t.table.Scanner(ctx, start, limit, total)
+}
+
+func unmarshalCogroupArgs(ctx UnmarshalContext, data []byte) (ast ASTNode, src Table, keyExpr, mapExpr *Func, opts cogroupOpts) {
 	dec := marshal.NewDecoder(data)
 	dec.GOB(&ast)
 	src = unmarshalTable(ctx, dec)
 	keyExpr = unmarshalFunc(ctx, dec)
 	mapExpr = unmarshalFunc(ctx, dec)
+	opts = unmarshalCogroupOpts(ctx, dec)
 	marshal.ReleaseDecoder(dec)
 	return
 }
@@ -188,25 +307,47 @@ func builtinCogroup(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 	keyExpr := args[1].Func()
 	mapExpr := args[2].Func()
 	shards := int(args[3].Int())
+	opts := cogroupOpts{
+		keyColumns: args[5].Bool(),
+		count:      args[6].Bool(),
+		having:     args[7].Func(),
+	}
+	return NewTable(newCogroupTable(ctx, ast, srcTable, keyExpr, mapExpr, opts, shards))
+}
+
+// newCogroupTable creates a table that groups the rows of srcTable by keyExpr.
+// If shards>0, it uses bigslice; else it groups the rows in this process.
+// MapExpr may be nil. It is shared by cogroup and write_matrix.
+func newCogroupTable(ctx context.Context, ast ASTNode, srcTable Table, keyExpr, mapExpr *Func, opts cogroupOpts, shards int) Table {
 	if shards <= 0 {
-		Panicf(ast, "cogroup: shards must be >0, but found %d", shards)
+		return &localCogroupTable{
+			hash:     hashCogroupCall(srcTable, keyExpr, mapExpr, opts),
+			ast:      ast,
+			srcTable: srcTable,
+			keyExpr:  keyExpr,
+			mapExpr:  mapExpr,
+			opts:     opts,
+		}
 	}
-	var tableBuf marshal.Encoder
-	mctx := newMarshalContext(ctx)
-	marshalCogroupArgs(mctx, &tableBuf, ast, srcTable, keyExpr, mapExpr)
+	noteLocalExecution(ast, "cogroup", shards)
+	srcTable = shardableTable(ctx, ast, srcTable, shards)
+	marshalledEnv, marshalledTable := marshalRemoteArgs(ctx, ast, func(mctx MarshalContext, enc *marshal.Encoder) {
+		marshalCogroupArgs(mctx, enc, ast, srcTable, keyExpr, mapExpr, opts)
+	})
 	t := &parallelCogroupTable{
 		ast:             ast,
 		src:             srcTable,
 		keyExpr:         keyExpr,
 		mapExpr:         mapExpr,
+		opts:            opts,
 		nshards:         shards,
-		marshalledEnv:   mctx.marshal(),
-		marshalledTable: tableBuf.Bytes(),
+		marshalledEnv:   marshalledEnv,
+		marshalledTable: marshalledTable,
 	}
-	return NewTable(t)
+	return t
 }
 
-func hashCogroupCall(table Table, keyExpr, mapExpr *Func) hash.Hash {
+func hashCogroupCall(table Table, keyExpr, mapExpr *Func, opts cogroupOpts) hash.Hash {
 	h := hash.Hash{
 		0xfb, 0xff, 0x03, 0x4e, 0x20, 0x97, 0xf5, 0xd2,
 		0x1a, 0xa2, 0xbc, 0xac, 0xe1, 0xc0, 0xfe, 0xae,
@@ -217,19 +358,31 @@ func hashCogroupCall(table Table, keyExpr, mapExpr *Func) hash.Hash {
 	if mapExpr != nil {
 		h = h.Merge(mapExpr.Hash())
 	}
+	if opts.keyColumns {
+		h = h.Merge(hash.String("cogroup:key_columns"))
+	}
+	if opts.count {
+		h = h.Merge(hash.String("cogroup:count"))
+	}
+	if opts.having != nil {
+		h = h.Merge(opts.having.Hash())
+	}
 	return h
 }
 
 func init() {
 	RegisterBuiltinFunc("cogroup",
 		`
-    tbl | cogroup(keyexpr [,mapexpr=mapexpr] [,shards=nshards])
+    tbl | cogroup(keyexpr [,mapexpr=mapexpr] [,shards=nshards] [,key_columns:=keycols] [,count:=count] [,having:=pred])
 
 Arg types:
 
 - _keyexpr_: one-arg function
 - _mapexpr_: one-arg function (default: ::|row|row::)
 - _nshards_: int (default: 1)
+- _keycols_: bool (default: false)
+- _count_: bool (default: false)
+- _pred_: one-arg function returning bool (default: none)
 
 Cogroup groups rows by their _keyexpr_ value.  It is the same as Apache Pig's
 reduce function. It achieves an effect similar to SQL's "GROUP BY" statement.
@@ -295,14 +448,36 @@ Similarly, table tmp2 looks like below.
         │  4  │
         │  8  │
 
-The cogroup function always uses bigslice for execution.  The _shards_ parameter
-defines parallelism. See the "distributed execution" section for more details.
+If _keycols_ is true and _keyexpr_ yields a struct, the fields of the struct
+become the leading columns of the output rows, in place of the _key_ column. If
+_count_ is true, column _count_ stores the number of rows in each group. For
+example, ::t0 | cogroup({&col0, &col1}, key_columns:=true, count:=true)::
+creates rows of form
+
+    {col0: Bat, col1: 3, count: 1, value: tmp5}
+
+If _pred_ is set, it is evaluated on each output row, and the groups for which
+it is false or NA are dropped before they are written out. For example,
+::t0 | cogroup(&col0, count:=true, having:=&count >= 3):: keeps only the group
+for Bat.
+
+If _nshards_ > 0, cogroup uses bigslice for execution, and _nshards_ defines
+the parallelism. If _nshards_ <= 0, the rows are grouped in memory, in the
+local process. See the "distributed execution" section for more details.
 `,
 		builtinCogroup,
-		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		func(ast ASTNode, args []AIArg) AIType {
+			if args[7].Expr != nil {
+				checkPredicateArg(ast, "cogroup", args[7])
+			}
+			return AITableType
+		},
 		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},                          // table
 		FormalArg{Positional: true, Required: true, Closure: true, ClosureArgs: anonRowFuncArg},             // keyexpr
 		FormalArg{Name: symbol.Map, Closure: true, ClosureArgs: anonRowFuncArg, DefaultValue: NewFunc(nil)}, // mapexpr
 		FormalArg{Name: symbol.Shards, DefaultValue: NewInt(1)},                                             // shards:=nnn
-		FormalArg{Name: symbol.Row, Symbol: true, DefaultSymbol: symbol.AnonRow})
+		FormalArg{Name: symbol.Row, Symbol: true, DefaultSymbol: symbol.AnonRow},
+		FormalArg{Name: symbol.KeyColumns, DefaultValue: False, Types: []ValueType{BoolType}},
+		FormalArg{Name: symbol.Count, DefaultValue: False, Types: []ValueType{BoolType}},
+		FormalArg{Name: symbol.Having, Closure: true, ClosureArgs: anonRowFuncArg, DefaultValue: NewFunc(nil)})
 }
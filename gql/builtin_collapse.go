@@ -40,6 +40,9 @@ func (t *collapseTable) Attrs(ctx context.Context) TableAttrs {
 	return TableAttrs{Name: "collapse", Path: t.src.Attrs(ctx).Path}
 }
 
+// Parallelizable implements ParallelizableTable.
+func (t *collapseTable) Parallelizable(ctx context.Context) bool { return false }
+
 func (t *collapseTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
 	if start > 0 {
 		// collapse cannot be sharded.
@@ -3,16 +3,95 @@ package gql
 import (
 	"context"
 	"strings"
+	"sync"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
 )
 
+// concatSchema computes the union and the intersection of the columns of the
+// rows of the table. The columns are listed in the order of first appearance.
+func concatSchema(ctx context.Context, ast ASTNode, t Table) (union, intersect []symbol.ID) {
+	counts := map[symbol.ID]int{}
+	nRows := 0
+	sc := t.Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		st := sc.Value().Struct(ast)
+		for fi := 0; fi < st.Len(); fi++ {
+			name := st.Field(fi).Name
+			if counts[name] == 0 {
+				union = append(union, name)
+			}
+			counts[name]++
+		}
+		nRows++
+	}
+	for _, col := range union {
+		if counts[col] == nRows {
+			intersect = append(intersect, col)
+		}
+	}
+	return
+}
+
+// alignConcatTable reconciles the columns of the rows of t according to the
+// mode ("union", "intersect", or "error"). See the concat doc for details.
+func alignConcatTable(ast ASTNode, t Table, mode string) Table {
+	var (
+		once sync.Once
+		cols []symbol.ID
+	)
+	return &rowTransformTable{
+		src:  t,
+		hash: t.Hash().Merge(hash.String("concat:align:" + mode)),
+		fn: func(ctx context.Context, row Value) Value {
+			once.Do(func() {
+				union, intersect := concatSchema(ctx, ast, t)
+				switch mode {
+				case "union":
+					cols = union
+				case "intersect":
+					cols = intersect
+				default:
+					if len(union) != len(intersect) {
+						Panicf(ast, "concat: tables have differing columns; all the columns: %s, common columns: %s",
+							joinSymbols(union), joinSymbols(intersect))
+					}
+					cols = union
+				}
+			})
+			st := row.Struct(ast)
+			fields := make([]StructField, len(cols))
+			for i, col := range cols {
+				val, ok := st.Value(col)
+				if !ok {
+					val = Null
+				}
+				fields[i] = StructField{Name: col, Value: val}
+			}
+			return NewStruct(NewSimpleStruct(fields...))
+		},
+	}
+}
+
+// joinSymbols is similar to strings.Join(..., ","), but for symbols.
+func joinSymbols(syms []symbol.ID) string {
+	names := make([]string, len(syms))
+	for i, sym := range syms {
+		names[i] = sym.Str()
+	}
+	return strings.Join(names, ",")
+}
+
 func init() {
 	RegisterBuiltinFunc("concat",
 		`
-    concat(tbl...)
+    concat(tbl... [, align:=mode])
 
 Arg types:
 
 - _tbl_: table
+- _mode_: string, one of "", "union", "intersect", or "error"
 
 ::concat(tbl1, tbl2, ..., tblN):: concatenates the rows of tables _tbl1_, ..., _tblN_
 into a new table. Concat differs from flatten in that it attempts to maintain
@@ -20,8 +99,34 @@ simple tables simple: that is, tables that are backed by (in-memory) values
 are retained as in-memory values; thus concat is designed to build up small(er)
 table values, e.g., in a map or reduce operation.
 
+By default, the rows are copied unchanged, so if the tables have differing
+columns, so do the rows of the result. Writing such a table to a TSV file is
+slow. Parameter _align_ reconciles the columns of the rows:
+
+- "union": each row has the union of the columns of all the rows. Missing
+  columns are filled with NA.
+
+- "intersect": each row has only the columns that appear in all the rows.
+
+- "error": raises an error if the rows have differing columns.
+
+In all three modes, the columns are ordered by their first appearance. The
+columns are computed by scanning the result once before the first row is
+produced.
+
+Example:
+
+    concat(table({a:1, b:2}), table({b:3, c:4}), align:="union") == table({a:1, b:2, c:NA}, {a:NA, b:3, c:4})
+    concat(table({a:1, b:2}), table({b:3, c:4}), align:="intersect") == table({b:2}, {b:3})
 `,
 		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			n := len(args)
+			align, args := args[n-1].Str(), args[:n-1]
+			switch align {
+			case "", "union", "intersect", "error":
+			default:
+				Panicf(ast, "concat: align must be one of \"union\", \"intersect\", or \"error\", but found \"%s\"", align)
+			}
 			tables := make([]Table, len(args))
 			simple := true
 			for i, arg := range args {
@@ -32,27 +137,34 @@ table values, e.g., in a map or reduce operation.
 					simple = false
 				}
 			}
+			var result Table
 			if simple {
 				t := tables[0].(*simpleTable)
 				for i := 1; i < len(tables); i++ {
 					t = appendSimpleTable(t, tables[i].(*simpleTable).rows...)
 				}
-				return NewTable(t)
-			}
-			// Fall back to a flatten table.
-			var b strings.Builder
-			b.WriteString("concat")
-			for _, t := range tables {
-				b.WriteString("_")
-				b.WriteString(t.Attrs(ctx).Name)
+				result = t
+			} else {
+				// Fall back to a flatten table.
+				var b strings.Builder
+				b.WriteString("concat")
+				for _, t := range tables {
+					b.WriteString("_")
+					b.WriteString(t.Attrs(ctx).Name)
+				}
+				rows := make([]Value, len(args))
+				for i := range rows {
+					rows[i] = args[i].Value
+				}
+				result = NewFlatTable(ast, []Table{newBuiltinTable(b.String(), rows)}, false)
 			}
-			rows := make([]Value, len(args))
-			for i := range rows {
-				rows[i] = args[i].Value
+			if align != "" {
+				result = alignConcatTable(ast, result, align)
 			}
-			return NewTable(NewFlatTable(ast, []Table{newBuiltinTable(b.String(), rows)}, false))
+			return NewTable(result)
 		},
 		func(ast ASTNode, args []AIArg) AIType { return AITableType },
 		FormalArg{Positional: true, Required: true, Variadic: true, Types: []ValueType{TableType}},
+		FormalArg{Name: symbol.Align, Types: []ValueType{StringType}, DefaultValue: NewString("")},
 	)
 }
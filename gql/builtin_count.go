@@ -1,6 +1,70 @@
 package gql
 
-import "context"
+import (
+	"context"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+)
+
+// checkPredicateArg checks that the arg is a boolean function.
+func checkPredicateArg(ast ASTNode, name string, arg AIArg) {
+	if exprType := arg.Type.FuncReturnType(ast); !exprType.Is(BoolType) {
+		Panicf(ast, "%s: arg '%s' is not bool function (%v)", name, arg.Expr, exprType)
+	}
+}
+
+// evalPredicate evaluates pred on the row. NA is treated as false.
+func evalPredicate(ctx context.Context, ast ASTNode, pred *Func, row Value) bool {
+	v := pred.Eval(ctx, row)
+	return v.Type() != NullType && v.Bool(ast)
+}
+
+// sumIf computes the sum of expr over the rows of t that satisfy pred. NA
+// values are skipped. The sum is an int if all the values are ints.
+func sumIf(ctx context.Context, ast ASTNode, t Table, expr, pred *Func) Value {
+	var (
+		isum     int64
+		fsum     float64
+		hasFloat bool
+	)
+	sc := t.Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		row := sc.Value()
+		if !evalPredicate(ctx, ast, pred, row) {
+			continue
+		}
+		switch v := expr.Eval(ctx, row); v.Type() {
+		case NullType:
+		case IntType:
+			isum += v.Int(ast)
+		case FloatType:
+			fsum += v.Float(ast)
+			hasFloat = true
+		default:
+			Panicf(ast, "sum_if: value must be a number, but found %v", v)
+		}
+	}
+	if hasFloat {
+		return NewFloat(fsum + float64(isum))
+	}
+	return NewInt(isum)
+}
+
+// countDistinct computes the number of distinct non-NA values of expr over the
+// rows of t. Values are compared by their hashes.
+func countDistinct(ctx context.Context, t Table, expr *Func) int64 {
+	seen := map[hash.Hash]struct{}{}
+	sc := t.Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		v := expr.Eval(ctx, sc.Value())
+		if v.Null() != NotNull {
+			continue
+		}
+		seen[v.Hash()] = struct{}{}
+	}
+	return int64(len(seen))
+}
 
 func init() {
 	RegisterBuiltinFunc("count",
@@ -24,4 +88,113 @@ Example: imagine table t0:
 		},
 		func(ast ASTNode, args []AIArg) AIType { return AIIntType },
 		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}})
+
+	RegisterBuiltinFunc("count_if",
+		`
+    tbl | count_if(pred)
+
+Arg types:
+
+- _pred_: one-arg boolean function
+
+Count_if counts the rows in the table that satisfy _pred_. A row for which
+_pred_ is NA is not counted. ::tbl | count_if(pred):: is the same as
+::tbl | filter(pred) | count()::.
+
+Example: imagine table t0:
+
+        ║ col1║
+        ├─────┤
+        │  3  │
+        │  4  │
+        │  8  │
+
+::t0 | count_if(&col1 > 3):: will produce 2.
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			pred := args[1].Func()
+			var n int64
+			sc := args[0].Table().Scanner(ctx, 0, 1, 1)
+			for sc.Scan() {
+				if evalPredicate(ctx, ast, pred, sc.Value()) {
+					n++
+				}
+			}
+			return NewInt(n)
+		},
+		func(ast ASTNode, args []AIArg) AIType {
+			checkPredicateArg(ast, "count_if", args[1])
+			return AIIntType
+		},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},              // table
+		FormalArg{Positional: true, Required: true, Closure: true, ClosureArgs: anonRowFuncArg}, // pred
+		FormalArg{Name: symbol.Row, Symbol: true, DefaultSymbol: symbol.AnonRow})
+
+	RegisterBuiltinFunc("sum_if",
+		`
+    tbl | sum_if(expr, pred)
+
+Arg types:
+
+- _expr_: one-arg function returning a number
+- _pred_: one-arg boolean function
+
+Sum_if computes the sum of _expr_ over the rows that satisfy _pred_. Rows for
+which _pred_ is NA, and NA values of _expr_, are skipped. The result is an int
+if all the values are ints, and a float otherwise. It is 0 if no row matches.
+
+Example: imagine table t0:
+
+        ║ col1║
+        ├─────┤
+        │  3  │
+        │  4  │
+        │  8  │
+
+::t0 | sum_if(&col1, &col1 > 3):: will produce 12.
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return sumIf(ctx, ast, args[0].Table(), args[1].Func(), args[2].Func())
+		},
+		func(ast ASTNode, args []AIArg) AIType {
+			checkPredicateArg(ast, "sum_if", args[2])
+			if exprType := args[1].Type.FuncReturnType(ast); exprType.Is(IntType) || exprType.Is(FloatType) {
+				return exprType
+			}
+			return AIAnyType
+		},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},              // table
+		FormalArg{Positional: true, Required: true, Closure: true, ClosureArgs: anonRowFuncArg}, // expr
+		FormalArg{Positional: true, Required: true, Closure: true, ClosureArgs: anonRowFuncArg}, // pred
+		FormalArg{Name: symbol.Row, Symbol: true, DefaultSymbol: symbol.AnonRow})
+
+	RegisterBuiltinFunc("count_distinct",
+		`
+    tbl | count_distinct(expr)
+
+Arg types:
+
+- _expr_: one-arg function
+
+Count_distinct computes the exact number of distinct values of _expr_ over the
+rows of the table. NA values are not counted. The values are kept in memory, so
+the function is not suitable for a column with billions of distinct values.
+
+Example: imagine table t0:
+
+        ║ col1║
+        ├─────┤
+        │  3  │
+        │  4  │
+        │  3  │
+
+::t0 | count_distinct(&col1):: will produce 2.
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return NewInt(countDistinct(ctx, args[0].Table(), args[1].Func()))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIIntType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},              // table
+		FormalArg{Positional: true, Required: true, Closure: true, ClosureArgs: anonRowFuncArg}, // expr
+		FormalArg{Name: symbol.Row, Symbol: true, DefaultSymbol: symbol.AnonRow})
 }
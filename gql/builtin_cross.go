@@ -0,0 +1,222 @@
+package gql
+
+import (
+	"context"
+	"sync"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+// DefaultCrossMaxRows is the default value of the max_rows arg of cross().
+const DefaultCrossMaxRows = 10000000
+
+// crossTable implements cross(). The first source table is streamed, and the
+// rest are read into memory.
+type crossTable struct {
+	hash hash.Hash
+	ast  ASTNode
+	srcs []Table
+	// names[i] is the name of the column that stores the rows of srcs[i].
+	names []symbol.ID
+	// maxRows is the max number of rows produced by a scanner. If <= 0, the
+	// table is unbounded.
+	maxRows int64
+
+	innerOnce sync.Once
+	// inner[i] are the rows of srcs[i+1].
+	inner [][]Value
+}
+
+// innerRows reads the second and later source tables into memory.
+func (t *crossTable) innerRows(ctx context.Context) [][]Value {
+	t.innerOnce.Do(func() {
+		t.inner = make([][]Value, len(t.srcs)-1)
+		for i, src := range t.srcs[1:] {
+			sc := src.Scanner(ctx, 0, 1, 1)
+			for sc.Scan() {
+				t.inner[i] = append(t.inner[i], sc.Value())
+			}
+		}
+	})
+	return t.inner
+}
+
+// innerLen computes the number of combinations of the rows of the second and
+// later tables.
+func (t *crossTable) innerLen(ctx context.Context) int {
+	n := 1
+	for _, rows := range t.innerRows(ctx) {
+		n *= len(rows)
+	}
+	return n
+}
+
+func (t *crossTable) Len(ctx context.Context, mode CountMode) int {
+	return t.srcs[0].Len(ctx, mode) * t.innerLen(ctx)
+}
+
+func (t *crossTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	MarshalTableOutline(ctx, enc, t)
+}
+func (t *crossTable) Prefetch(ctx context.Context) {}
+func (t *crossTable) Hash() hash.Hash              { return t.hash }
+func (t *crossTable) Attrs(ctx context.Context) TableAttrs {
+	return TableAttrs{Name: "cross"}
+}
+
+// Parallelizable implements ParallelizableTable.
+func (t *crossTable) Parallelizable(ctx context.Context) bool {
+	return IsParallelizable(ctx, t.srcs[0])
+}
+
+func (t *crossTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	inner := t.innerRows(ctx)
+	if t.maxRows > 0 {
+		// Fail early if the result is known to be too large.
+		if n := int64(t.srcs[0].Len(ctx, Approx)) * int64(t.innerLen(ctx)); n > t.maxRows {
+			Panicf(t.ast, "cross: the result would have about %d rows, which exceeds max_rows:=%d", n, t.maxRows)
+		}
+	}
+	sc := &crossTableScanner{
+		parent: t,
+		budget: evalBudgetFromContext(ctx),
+		outer:  t.srcs[0].Scanner(ctx, start, limit, total),
+		inner:  inner,
+		pos:    make([]int, len(inner)),
+	}
+	for _, rows := range inner {
+		if len(rows) == 0 {
+			sc.empty = true
+		}
+	}
+	return sc
+}
+
+type crossTableScanner struct {
+	parent *crossTable
+	budget *evalBudget
+	outer  TableScanner
+	inner  [][]Value
+	// pos[i] is the index of the current row in inner[i]. The last one advances
+	// the fastest.
+	pos []int
+	// empty is true if one of the inner tables is empty.
+	empty bool
+	// outerRow is the current row of the outer table. hasOuter becomes true once
+	// the first outer row is read.
+	outerRow Value
+	hasOuter bool
+	// nRows is the number of rows produced so far.
+	nRows int64
+	row   Value
+}
+
+func (sc *crossTableScanner) Value() Value { return sc.row }
+
+// advance moves sc.pos to the next combination of the inner rows. It returns
+// false if the combinations are exhausted.
+func (sc *crossTableScanner) advance() bool {
+	for i := len(sc.pos) - 1; i >= 0; i-- {
+		sc.pos[i]++
+		if sc.pos[i] < len(sc.inner[i]) {
+			return true
+		}
+		sc.pos[i] = 0
+	}
+	return false
+}
+
+func (sc *crossTableScanner) Scan() bool {
+	if sc.empty {
+		return false
+	}
+	if !sc.hasOuter || !sc.advance() {
+		if !sc.outer.Scan() {
+			return false
+		}
+		sc.outerRow = sc.outer.Value()
+		sc.hasOuter = true
+	}
+	t := sc.parent
+	if sc.nRows++; t.maxRows > 0 && sc.nRows > t.maxRows {
+		Panicf(t.ast, "cross: the result has more than max_rows:=%d rows", t.maxRows)
+	}
+	sc.budget.addRows(1)
+	fields := make([]StructField, len(t.srcs))
+	fields[0] = StructField{Name: t.names[0], Value: sc.outerRow}
+	for i, rows := range sc.inner {
+		fields[i+1] = StructField{Name: t.names[i+1], Value: rows[sc.pos[i]]}
+	}
+	sc.row = NewStruct(NewSimpleStruct(fields...))
+	return true
+}
+
+func init() {
+	RegisterBuiltinFunc("cross",
+		`
+    cross(tbl0, tbl1... [, max_rows:=n])
+
+Arg types:
+
+- _tbl0_, _tbl1_: table
+- _n_: int (default: 10000000)
+
+::cross(tbl0, tbl1, ..., tblN):: computes the cartesian product of the tables.
+Each row of the result is a struct that stores one row from each table. The
+columns are named in the same way as [zip](#zip): after the variables that store
+the tables, or "tK" for the Kth arg if the arg is not a variable.
+
+The rows are ordered by the rows of _tbl0_, then by the rows of _tbl1_, and so
+on. Table _tbl0_ is streamed, so it can be large. The other tables are read into
+memory, so they should be small.
+
+Cross raises an error if the result would have more than _n_ rows. The size is
+estimated before the first row is produced, and it is checked again as the
+rows are produced. If _n_ <= 0, the size is not limited.
+
+Use cross instead of a join with a constant-true condition, which is much
+slower.
+
+Example: a parameter grid.
+
+    alpha := table(0.1, 0.5);
+    k := table(10, 20, 30);
+    cross(alpha, k) | map({alpha:alpha, k:k})
+
+produces
+
+        ║alpha║  k║
+        ├─────┼───┤
+        │  0.1│ 10│
+        │  0.1│ 20│
+        │  0.1│ 30│
+        │  0.5│ 10│
+        │  0.5│ 20│
+        │  0.5│ 30│`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			n := len(args)
+			maxRows, args := args[n-1].Int(), args[:n-1]
+			if len(args) < 2 {
+				Panicf(ast, "cross: at least two tables are required, but found %d", len(args))
+			}
+			t := &crossTable{
+				ast:     ast,
+				srcs:    make([]Table, len(args)),
+				names:   tableArgColumnNames(args),
+				maxRows: maxRows,
+			}
+			h := hash.String("cross").Merge(hash.Int(maxRows))
+			for i := range args {
+				t.srcs[i] = args[i].Table()
+				h = h.Merge(t.srcs[i].Hash()).Merge(hash.String(t.names[i].Str()))
+			}
+			t.hash = h
+			return NewTable(t)
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Variadic: true, Types: []ValueType{TableType}},
+		FormalArg{Name: symbol.MaxRows, Types: []ValueType{IntType}, DefaultValue: NewInt(DefaultCrossMaxRows)},
+	)
+}
@@ -0,0 +1,175 @@
+package gql
+
+import (
+	"context"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+// enumerateTable implements enumerate(). Each row is {index:i, value:row}.
+type enumerateTable struct {
+	hash hash.Hash
+	src  Table
+}
+
+func (t *enumerateTable) Len(ctx context.Context, mode CountMode) int { return t.src.Len(ctx, mode) }
+func (t *enumerateTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	MarshalTableOutline(ctx, enc, t)
+}
+func (t *enumerateTable) Prefetch(ctx context.Context) { t.src.Prefetch(ctx) }
+func (t *enumerateTable) Hash() hash.Hash              { return t.hash }
+func (t *enumerateTable) Attrs(ctx context.Context) TableAttrs {
+	return TableAttrs{Name: "enumerate", Path: t.src.Attrs(ctx).Path}
+}
+
+// Parallelizable implements ParallelizableTable.
+func (t *enumerateTable) Parallelizable(ctx context.Context) bool { return false }
+
+func (t *enumerateTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	if start > 0 {
+		// The index is the position of the row in the whole table, so
+		// enumerate cannot be sharded.
+		return &NullTableScanner{}
+	}
+	return &enumerateTableScanner{src: NewPrefetchingTableScanner(ctx, t.src.Scanner(ctx, 0, 1, 1), -1)}
+}
+
+type enumerateTableScanner struct {
+	src   TableScanner
+	index int64
+	row   Value
+}
+
+func (sc *enumerateTableScanner) Value() Value { return sc.row }
+
+func (sc *enumerateTableScanner) Scan() bool {
+	if !sc.src.Scan() {
+		return false
+	}
+	sc.row = NewStruct(NewSimpleStruct(
+		StructField{Name: symbol.Index, Value: NewInt(sc.index)},
+		StructField{Name: symbol.Value, Value: sc.src.Value()}))
+	sc.index++
+	return true
+}
+
+// batchTable implements batch(). Each row is a table of n consecutive rows of
+// the source table.
+type batchTable struct {
+	hash hash.Hash
+	src  Table
+	n    int
+}
+
+func (t *batchTable) Len(ctx context.Context, mode CountMode) int {
+	return (t.src.Len(ctx, mode) + t.n - 1) / t.n
+}
+func (t *batchTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	MarshalTableOutline(ctx, enc, t)
+}
+func (t *batchTable) Prefetch(ctx context.Context) { t.src.Prefetch(ctx) }
+func (t *batchTable) Hash() hash.Hash              { return t.hash }
+func (t *batchTable) Attrs(ctx context.Context) TableAttrs {
+	return TableAttrs{Name: "batch", Path: t.src.Attrs(ctx).Path}
+}
+
+// Parallelizable implements ParallelizableTable.
+func (t *batchTable) Parallelizable(ctx context.Context) bool { return false }
+
+func (t *batchTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	if start > 0 {
+		// A batch must consist of consecutive rows, so batch cannot be sharded.
+		return &NullTableScanner{}
+	}
+	return &batchTableScanner{parent: t, src: NewPrefetchingTableScanner(ctx, t.src.Scanner(ctx, 0, 1, 1), -1)}
+}
+
+type batchTableScanner struct {
+	parent *batchTable
+	src    TableScanner
+	// index is the number of batches produced so far.
+	index int64
+	row   Value
+}
+
+func (sc *batchTableScanner) Value() Value { return sc.row }
+
+func (sc *batchTableScanner) Scan() bool {
+	n := sc.parent.n
+	rows := make([]Value, 0, n)
+	for len(rows) < n && sc.src.Scan() {
+		rows = append(rows, sc.src.Value())
+	}
+	if len(rows) == 0 {
+		return false
+	}
+	h := sc.parent.hash.Merge(hash.Int(sc.index))
+	sc.row = NewTable(NewSimpleTable(rows, h, TableAttrs{Name: "batch"}))
+	sc.index++
+	return true
+}
+
+func init() {
+	RegisterBuiltinFunc("enumerate",
+		`
+    tbl | enumerate()
+
+Arg types:
+
+- _tbl_: table
+
+Enumerate pairs each row of the table with its 0-based position. The ith row
+of the result is ::{index:i, value:row}::, where _row_ is the ith row of _tbl_.
+The rows are produced in order as _tbl_ is read.
+
+Example:
+
+    table({a:"x"}, {a:"y"}) | enumerate() == table({index:0, value:{a:"x"}}, {index:1, value:{a:"y"}})`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			src := args[0].Table()
+			return NewTable(&enumerateTable{
+				hash: hash.String("enumerate").Merge(src.Hash()),
+				src:  src,
+			})
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}})
+
+	RegisterBuiltinFunc("batch",
+		`
+    tbl | batch(n)
+
+Arg types:
+
+- _tbl_: table
+- _n_: int
+
+Batch groups the rows of the table into chunks of _n_ consecutive rows. Each
+row of the result is a table of _n_ rows, except for the last one, which may
+have fewer rows. The batches are produced in order as _tbl_ is read, so batch
+can process a large table without reading the whole table first.
+
+Batch is handy for calling an external service for a chunk of rows at a time,
+and for paging through a table.
+
+Example:
+
+    table(1, 2, 3, 4, 5) | batch(2) | map(count(_)) == table(2, 2, 1)`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			src := args[0].Table()
+			n := args[1].Int()
+			if n <= 0 {
+				Panicf(ast, "batch: n must be positive, but found %d", n)
+			}
+			return NewTable(&batchTable{
+				hash: hash.String("batch").Merge(src.Hash()).Merge(hash.Int(n)),
+				src:  src,
+				n:    int(n),
+			})
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{IntType}})
+}
@@ -22,12 +22,15 @@ type firstNTable struct {
 	len     int
 }
 
+// Parallelizable implements ParallelizableTable.
+func (t *firstNTable) Parallelizable(ctx context.Context) bool { return false }
+
 // Scanner implements the Table interface.
 func (t *firstNTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
 	if start > 0 {
 		return &NullTableScanner{}
 	}
-	sc := &firstNTableScanner{sc: t.src.Scanner(ctx, 0, 1, 1), remaining: t.n}
+	sc := &firstNTableScanner{sc: limitTable(ctx, t.src, t.n).Scanner(ctx, 0, 1, 1), remaining: t.n}
 	return sc
 }
 
@@ -90,6 +93,58 @@ func (t *firstNTable) Attrs(ctx context.Context) TableAttrs {
 	return attrs
 }
 
+// shardLimitTable yields up to n rows from each shard of src. It is used to
+// push firstn down to the shards of a distributed map: the first n rows of the
+// concatenation of the shards are the first n rows of src.
+type shardLimitTable struct {
+	hash hash.Hash
+	ast  ASTNode
+	src  Table
+	n    int
+}
+
+var shardLimitMagic = UnmarshalMagic{0x5c, 0x2e}
+
+// Scanner implements the Table interface.
+func (t *shardLimitTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	return &firstNTableScanner{sc: t.src.Scanner(ctx, start, limit, total), remaining: t.n}
+}
+
+// Len implements the Table interface.
+func (t *shardLimitTable) Len(ctx context.Context, mode CountMode) int {
+	if mode == Approx {
+		return t.src.Len(ctx, Approx)
+	}
+	return DefaultTableLen(ctx, t)
+}
+
+// Prefetch implements the Table interface.
+func (t *shardLimitTable) Prefetch(ctx context.Context) { t.src.Prefetch(ctx) }
+
+// Hash implements the Table interface.
+func (t *shardLimitTable) Hash() hash.Hash { return t.hash }
+
+// Attrs implements the Table interface.
+func (t *shardLimitTable) Attrs(ctx context.Context) TableAttrs { return t.src.Attrs(ctx) }
+
+// Marshal implements the Table interface.
+func (t *shardLimitTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	enc.PutRawBytes(shardLimitMagic[:])
+	enc.PutHash(t.hash)
+	enc.PutGOB(&t.ast)
+	t.src.Marshal(ctx, enc)
+	enc.PutVarint(int64(t.n))
+}
+
+// unmarshalShardLimitTable reconstructs the table serialized by Marshal.
+func unmarshalShardLimitTable(ctx UnmarshalContext, hash hash.Hash, dec *marshal.Decoder) Table {
+	t := &shardLimitTable{hash: hash}
+	dec.GOB(&t.ast)
+	t.src = unmarshalTable(ctx, dec)
+	t.n = int(dec.Varint())
+	return t
+}
+
 // firstNTableScanner is a TableScanner implementation for firstNTable.
 type firstNTableScanner struct {
 	sc        TableScanner
@@ -110,6 +165,7 @@ func (t *firstNTableScanner) Value() Value { return t.sc.Value() }
 
 func init() {
 	RegisterTableUnmarshaler(firstNMagic, unmarshalFirstNTable)
+	RegisterTableUnmarshaler(shardLimitMagic, unmarshalShardLimitTable)
 	RegisterBuiltinFunc("firstn",
 		`
     tbl | firstn(n)
@@ -119,6 +175,9 @@ Arg types:
 - _n_: int
 
 Firstn produces a table that contains the first _n_ rows of the input table.
+Reading stops once _n_ rows are produced. If the input is computed by a
+distributed map, e.g., ::read(...) | map(..., shards:=100) | firstn(10)::, each
+shard stops after computing _n_ rows, unless the whole map is already cached.
 `,
 		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 			table := args[0].Table()
@@ -22,6 +22,9 @@ type largeFlatTable struct {
 	ast       ASTNode // location in the source code. Only for error reporting.
 	srcTables []Table // input tables.
 	attrs     TableAttrs
+	// limit, if positive, is the max number of rows read from each subtable.
+	// Cf. Limit.
+	limit int
 
 	once sync.Once
 	// cumApproxSrcTableLen[i] is the sum of Len(Approx) of srcTables[0..i].  Used
@@ -36,10 +39,10 @@ type largeFlatTable struct {
 type largeFlatTableScanner struct {
 	ctx          context.Context
 	parent       *largeFlatTable
-	start, limit int          // the scanner range, relative to [0,parent.Len(approx)].
-	curLimit     int          // the limit of srcSc. start < curLimit <= limit.
-	srcSc        TableScanner // iterates parent.srcTables
-	cur          TableScanner // iterates table read from srcSc
+	start, limit int              // the scanner range, relative to [0,parent.Len(approx)].
+	curLimit     int              // the limit of srcSc. start < curLimit <= limit.
+	srcSc        *tablePrefetcher // iterates the subtables of parent.srcTables
+	cur          TableScanner     // iterates table read from srcSc
 }
 
 // limitedWorkerGroup is similar to errgroup.Group, but with limited concurrency of NumCPU*2.
@@ -93,6 +96,10 @@ func (t *largeFlatTable) Scanner(ctx context.Context, start, limit, total int) T
 // Len implements the Table interface.
 func (t *largeFlatTable) Len(ctx context.Context, mode CountMode) int {
 	t.init(ctx)
+	if mode == Exact && t.limit > 0 {
+		t.onceExactLen.Do(func() { t.exactLen = int64(DefaultTableLen(ctx, t)) })
+		return int(t.exactLen)
+	}
 	if mode == Approx {
 		n := len(t.srcTables)
 		if n == 0 {
@@ -151,6 +158,7 @@ func (t *largeFlatTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
 	for i := range t.srcTables {
 		t.srcTables[i].Marshal(ctx, enc)
 	}
+	enc.PutVarint(int64(t.limit))
 }
 
 func unmarshalLargeFlatTable(ctx UnmarshalContext, h hash.Hash, dec *marshal.Decoder) Table {
@@ -165,9 +173,24 @@ func unmarshalLargeFlatTable(ctx UnmarshalContext, h hash.Hash, dec *marshal.Dec
 	for i := 0; i < nSrcTables; i++ {
 		t.srcTables = append(t.srcTables, unmarshalTable(ctx, dec))
 	}
+	t.limit = int(dec.Varint())
 	return t
 }
 
+// Limit implements LimitableTable. The limit is pushed down to each subtable.
+func (t *largeFlatTable) Limit(ctx context.Context, n int) Table {
+	if t.limit > 0 && t.limit <= n {
+		return t
+	}
+	return &largeFlatTable{
+		hash:      t.hash.Merge(hash.String("limit")).Merge(hash.Int(int64(n))),
+		ast:       t.ast,
+		srcTables: t.srcTables,
+		attrs:     t.attrs,
+		limit:     n,
+	}
+}
+
 // Attrs implements the Table interface.
 func (t *largeFlatTable) Attrs(ctx context.Context) TableAttrs { return t.attrs }
 
@@ -181,25 +204,18 @@ func (sc *largeFlatTableScanner) Scan() bool {
 				if srcTableIndex < 0 {
 					return false
 				}
-				sc.srcSc = sc.parent.srcTables[srcTableIndex].Scanner(
+				sc.srcSc = newTablePrefetcher(sc.ctx, sc.parent.srcTables[srcTableIndex].Scanner(
 					sc.ctx,
 					nextOff-srcTableStart, scanLimit-srcTableStart,
-					srcTableLimit-srcTableStart)
+					srcTableLimit-srcTableStart), sc.subTable)
 				sc.curLimit = scanLimit
 			}
-			if !sc.srcSc.Scan() {
+			cur, ok := sc.srcSc.Next()
+			if !ok {
 				sc.srcSc = nil
 				continue
 			}
-			val := sc.srcSc.Value()
-			if val.Type() == StructType {
-				st := val.Struct(sc.parent.ast)
-				if st.Len() != 1 {
-					Panicf(sc.parent.ast, "flatten: subtable must contain exactly one column, but found %v", val)
-				}
-				val = st.Field(0).Value
-			}
-			sc.cur = val.Table(sc.parent.ast).Scanner(sc.ctx, 0, 1, 1)
+			sc.cur = cur
 		}
 		if sc.cur.Scan() {
 			return true
@@ -208,6 +224,22 @@ func (sc *largeFlatTableScanner) Scan() bool {
 	}
 }
 
+// subTable extracts the table to be flattened from a row of a srcTable.
+func (sc *largeFlatTableScanner) subTable(val Value) Table {
+	t := sc.parent
+	if val.Type() == StructType {
+		st := val.Struct(t.ast)
+		if st.Len() != 1 {
+			Panicf(t.ast, "flatten: subtable must contain exactly one column, but found %v", val)
+		}
+		val = st.Field(0).Value
+	}
+	if t.limit > 0 {
+		return limitTable(sc.ctx, val.Table(t.ast), t.limit)
+	}
+	return val.Table(t.ast)
+}
+
 // Value implements the TableScanner interface.
 func (sc *largeFlatTableScanner) Value() Value {
 	return sc.cur.Value()
@@ -1,23 +1,193 @@
 package gql
 
-import "context"
+import (
+	"context"
+
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+// forcedTable is a table materialized by force(). Its contents are stored in a
+// file, so it is cheap to rescan, and it is marshaled as a pathname.
+type forcedTable struct {
+	// Table reads the file.
+	Table
+	fh   FileHandler
+	path string
+}
+
+var _ Table = &forcedTable{}
+
+// Marshal implements the Table interface.
+func (t *forcedTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	MarshalTablePath(enc, t.path, t.fh, t.Hash())
+}
+
+// isMaterialized checks if the table contents are stored in a file. Such a
+// table is cheap to rescan, and it need not be materialized again.
+func isMaterialized(t Table) bool {
+	switch t.(type) {
+	case *btsvTable, *forcedTable:
+		return true
+	}
+	return false
+}
+
+// writtenFileHash computes a hash of the file written by the handler from the
+// pathname, the size, and the modtime of the file. A btsv file is a directory of
+// shards, so the hash covers all the shards. It returns false if the file
+// doesn't exist.
+func writtenFileHash(ctx context.Context, ast ASTNode, path string, fh FileHandler) (hash.Hash, bool) {
+	if fh == singletonBTSVFileHandler {
+		shards := listBTSVShardPaths(ctx, path, ast)
+		if len(shards) == 0 {
+			return hash.Zero, false
+		}
+		h := hash.String(path)
+		for _, shard := range shards {
+			h = h.Merge(FileHash(ctx, shard, ast))
+		}
+		return h, true
+	}
+	if _, err := file.Stat(ctx, path); err != nil {
+		return hash.Zero, false
+	}
+	return FileHash(ctx, path, ast), true
+}
+
+// forcedFileCacheName computes the name of the cache entry that records that
+// the file with the given hash was produced by force. Arg cacheName is the name
+// of the cache entry for the force expression.
+func forcedFileCacheName(cacheName string, fileHash hash.Hash) string {
+	return hash.String(cacheName).Merge(fileHash).String() + ".forced"
+}
+
+// forceTable writes the contents of src in the given format and returns a table
+// that reads the file. The file is registered in the cache, so forcing the same
+// table again reuses the file. If path is empty, the file is created in the
+// cache directory. Otherwise, the file is reused only if it hasn't been modified
+// since force wrote it.
+//
+// A TSV file doesn't record the column types, so they may change when the file
+// is read back. The resulting table therefore gets a hash different from src
+// unless the format is btsv.
+func forceTable(ctx context.Context, ast ASTNode, src Table, format, path string, nShard int) Table {
+	if t, ok := src.(*forcedTable); ok && t.fh.Name() == format && path == "" {
+		return t
+	}
+	fh := GetFileHandlerByName(format)
+	h := src.Hash()
+	// materializeTable reuses the cache entry written with the default settings.
+	cacheName := h.String()
+	if format != "btsv" || nShard != 1 || path != "" {
+		cacheName = h.Merge(hash.String(format)).Merge(hash.Int(int64(nShard))).Merge(hash.String(path)).String()
+	}
+	cacheName += "." + format
+	cachePath, found := LookupCache(ctx, cacheName)
+	// LookupCache takes the lease on cacheName iff the entry isn't found. The
+	// lease is released once the entry is activated, or when the write fails.
+	holdsLease := !found
+	if holdsLease {
+		defer abandonCacheEntry(ctx, cachePath)
+	}
+	userPath := path != ""
+	if !userPath {
+		path = cachePath
+	} else if found {
+		fileHash, ok := writtenFileHash(ctx, ast, path, fh)
+		found = ok && cacheEntryExists(ctx, forcedFileCacheName(cacheName, fileHash))
+		if !found {
+			Logf(ast, "force: %s has been modified since it was written", path)
+		}
+	}
+	if !found {
+		Logf(ast, "force: writing %s", path)
+		fh.Write(ctx, path, ast, src, nShard, true)
+		if holdsLease {
+			ActivateCache(ctx, cacheName, path)
+		} else {
+			writeCacheLink(ctx, cacheName, path)
+		}
+		if userPath {
+			fileHash, ok := writtenFileHash(ctx, ast, path, fh)
+			if !ok {
+				Panicf(ast, "force: %s not found after writing it", path)
+			}
+			writeCacheLink(ctx, forcedFileCacheName(cacheName, fileHash), path)
+		}
+		reportTableMaterialized(h, path, -1)
+	}
+	if format != "btsv" {
+		h = h.Merge(hash.String("force:" + format))
+	}
+	return &forcedTable{Table: fh.Open(ctx, path, ast, h), fh: fh, path: path}
+}
 
 func init() {
 	RegisterBuiltinFunc("force",
 		`
-    tbl | force()
+    tbl | force([format:="btsv", path:=path, shards:=nshards])
+
+Arg types:
+
+- _path_: string
+- _nshards_: int
+
+Force materializes _tbl_. It writes the contents of _tbl_ in a file, and returns
+a table that reads the file. With the btsv format, the result has the same
+contents and the same hash as _tbl_, so force is logically a no-op.
+
+The file is registered in the cache. When the same force expression is evaluated
+again, e.g., in another session, force reuses the file instead of running _tbl_
+again. Operators that need to materialize their input, such as join and
+distributed map, use a forced table as is.
+
+- _format_ is either "btsv" (default) or "tsv". A TSV file cannot store nested
+  tables, but it can be read by other tools. A TSV file doesn't record the
+  column types, so they are guessed when the file is read back, e.g., a string
+  column "0123" becomes an int column 123. For this reason, the result of a
+  TSV force has a hash different from _tbl_.
+
+- _path_ specifies the pathname of the file. By default, the file is created in
+  the cache directory. When _path_ is set, the file is created there, and it is
+  reused only if it was created by force on the same _tbl_ and it hasn't been
+  modified since.
+
+- _nshards_ is the number of btsv shards. The shards are written in parallel.
+  The default is 1.
+
+Example:
+
+    t := read("s3://bucket/large.tsv") | filter($score > 10) | force()
+    t | count()
+    t | firstn(10)
 
-Force is a performance hint. It is logically a no-op; it just
-produces the contents of the source table unchanged.  Underneath, this function
-writes the source-table contents in a file. When this expression is evaluated
-repeatedly, force will read contents from the file instead of
-running _tbl_ repeatedly.
+The first two lines compute the filter once.
 `,
 
 		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
-			srcTable := args[0].Table()
-			return NewTable(materializeTable(ctx, srcTable, nil))
+			format := args[1].Str()
+			path := args[2].Str()
+			nShard := int(args[3].Int())
+			switch format {
+			case "btsv":
+			case "tsv":
+				if nShard != 1 {
+					Panicf(ast, "force: shards:= is supported only for btsv format")
+				}
+			default:
+				Panicf(ast, "force: format must be either \"btsv\" or \"tsv\", but found \"%s\"", format)
+			}
+			if nShard < 1 {
+				Panicf(ast, "force: shards must be positive, but found %d", nShard)
+			}
+			return NewTable(forceTable(ctx, ast, args[0].Table(), format, path, nShard))
 		},
 		func(ast ASTNode, args []AIArg) AIType { return AITableType },
-		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}})
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Name: symbol.Format, DefaultValue: NewString("btsv"), Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.Path, DefaultValue: NewString(""), Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.Shards, DefaultValue: NewInt(1), Types: []ValueType{IntType}})
 }
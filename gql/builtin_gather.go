@@ -2,6 +2,7 @@ package gql
 
 import (
 	"context"
+	"strings"
 	"sync"
 
 	"github.com/grailbio/gql/hash"
@@ -12,16 +13,79 @@ import (
 
 // spreadGatherTable is used to implement spread and gather.
 type spreadGatherTable struct {
-	hash         hash.Hash
-	ast          ASTNode // source-code location
-	spread       bool    // true for spread, false for gather
-	key, val     symbol.ID
-	cols         []symbol.ID // nil for spread
+	hash   hash.Hash
+	ast    ASTNode // source-code location
+	spread bool    // true for spread, false for gather
+	key    symbol.ID
+	// Value column names. If there are more than one, the gathered (or spread)
+	// columns are named "<val><sep><key>".
+	vals         []symbol.ID
+	sep          string
+	cols         []symbol.ID   // nil for spread
+	groups       []gatherGroup // computed from cols; nil for spread
 	src          Table
 	exactLen     int
 	exactLenOnce sync.Once
 }
 
+// gatherGroup is the set of columns that gather turns into one output row per
+// input row.
+type gatherGroup struct {
+	key string // value of the key column.
+	// cols[i] is the column copied to the i'th value column. It is
+	// symbol.Invalid if the input has no such column, in which case the value
+	// is NA.
+	cols []symbol.ID
+}
+
+// newGatherGroups computes the groups of the columns gathered into the given
+// value columns. With a single value column, each column forms a group keyed by
+// its name. With multiple value columns, column "<val><sep><key>" is placed in
+// the group for <key>, e.g., "depth_s1" and "count_s1" form group "s1" for
+// value columns "depth" and "count".
+func newGatherGroups(ast ASTNode, cols, vals []symbol.ID, sep string) []gatherGroup {
+	seen := make(map[symbol.ID]bool, len(cols))
+	for _, col := range cols {
+		if seen[col] {
+			Panicf(ast, "gather: column '%s' is listed more than once", col.Str())
+		}
+		seen[col] = true
+	}
+	if len(vals) == 1 {
+		groups := make([]gatherGroup, len(cols))
+		for i, col := range cols {
+			groups[i] = gatherGroup{key: col.Str(), cols: []symbol.ID{col}}
+		}
+		return groups
+	}
+	var groups []gatherGroup
+	groupIndex := map[string]int{}
+	for _, col := range cols {
+		name, vi, key := col.Str(), -1, ""
+		for i, val := range vals {
+			prefix := val.Str() + sep
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			if vi >= 0 {
+				Panicf(ast, "gather: column '%s' matches both value columns '%s' and '%s'", name, vals[vi].Str(), val.Str())
+			}
+			vi, key = i, name[len(prefix):]
+		}
+		if vi < 0 {
+			Panicf(ast, "gather: column '%s' must be named \"<value>%s<key>\", where <value> is one of the value columns %v", name, sep, symbolNames(vals))
+		}
+		gi, ok := groupIndex[key]
+		if !ok {
+			gi = len(groups)
+			groupIndex[key] = gi
+			groups = append(groups, gatherGroup{key: key, cols: make([]symbol.ID, len(vals))})
+		}
+		groups[gi].cols[vi] = col
+	}
+	return groups
+}
+
 func (t *spreadGatherTable) Len(ctx context.Context, mode CountMode) int {
 	if t.spread {
 		// Spread does not change the # of rows.
@@ -34,10 +98,10 @@ func (t *spreadGatherTable) Len(ctx context.Context, mode CountMode) int {
 		})
 		return t.exactLen
 	}
-	// Gather creates new rows in propoportion to the number of columns
+	// Gather creates new rows in propoportion to the number of column groups
 	// being gathered. This should also be the exact count, but it's not
 	// clear how to handle corner cases such as missing fields for values etc.
-	return len(t.cols) * t.src.Len(ctx, mode)
+	return len(t.groups) * t.src.Len(ctx, mode)
 }
 
 func (t *spreadGatherTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
@@ -56,7 +120,7 @@ func (t *spreadGatherTable) Scanner(ctx context.Context, start, limit, total int
 			ctx:    ctx,
 			parent: t,
 			key:    t.key,
-			val:    t.val,
+			vals:   t.vals,
 			src:    NewPrefetchingTableScanner(ctx, t.src.Scanner(ctx, start, limit, total), -1),
 			// Keep a pointer to the buf used for PrintArgs.Out so as to
 			// be able to call .Reset on it.
@@ -77,24 +141,35 @@ func (t *spreadGatherTable) Scanner(ctx context.Context, start, limit, total int
 	return &gatherTableScanner{
 		parent: t,
 		key:    t.key,
-		val:    t.val,
+		vals:   t.vals,
 		gather: gm,
-		cols:   t.cols,
+		groups: t.groups,
 		src:    NewPrefetchingTableScanner(ctx, t.src.Scanner(ctx, start, limit, total), -1),
-		rows:   make([]Value, len(t.cols)),
+		rows:   make([]Value, len(t.groups)),
 		next:   -1,
 	}
 }
 
 type gatherTableScanner struct {
-	parent   *spreadGatherTable
-	src      TableScanner
-	key, val symbol.ID
-	cols     []symbol.ID
-	gather   map[symbol.ID]bool
-	fields   []StructField
-	rows     []Value
-	next     int
+	parent *spreadGatherTable
+	src    TableScanner
+	key    symbol.ID
+	vals   []symbol.ID
+	groups []gatherGroup
+	gather map[symbol.ID]bool
+	fields []StructField
+	rows   []Value
+	next   int
+}
+
+// symbolIndex returns the index of id in ids, or -1 if not found.
+func symbolIndex(ids []symbol.ID, id symbol.ID) int {
+	for i, v := range ids {
+		if v == id {
+			return i
+		}
+	}
+	return -1
 }
 
 func (sc *gatherTableScanner) Value() Value {
@@ -113,37 +188,46 @@ func (sc *gatherTableScanner) Scan() bool {
 	}
 	row := sc.src.Value().Struct(sc.parent.ast)
 	nf := row.Len()
-	cnf := nf + 2 - len(sc.gather)
-	if cap(sc.fields) < nf {
-		sc.fields = make([]StructField, cnf)
-	}
-	common := sc.fields[0:cnf]
-	next := 0
+	sc.fields = sc.fields[:0]
+	nGathered := 0
 	for i := 0; i < nf; i++ {
-		common[next] = row.Field(i)
-		if !sc.gather[common[next].Name] {
-			next++
+		f := row.Field(i)
+		if sc.gather[f.Name] {
+			nGathered++
+			continue
+		}
+		if f.Name == sc.key || symbolIndex(sc.vals, f.Name) >= 0 {
+			Panicf(sc.parent.ast, "gather: column '%s' already exists in %v; pick another name for key:= or value:=", f.Name.Str(), sc.src.Value())
 		}
+		sc.fields = append(sc.fields, f)
 	}
-	if next != len(common)-2 {
-		Panicf(sc.parent.ast, "failed to find the specified key and/or value column in %v", sc.src.Value())
+	if nGathered != len(sc.gather) {
+		for col := range sc.gather {
+			if _, ok := row.Value(col); !ok {
+				Panicf(sc.parent.ast, "gather: column '%s' not found%s", col.Str(), columnNotFoundHint(col, row))
+			}
+		}
 	}
-	ki, vi := next, next+1
-	for i, g := range sc.cols {
+	ki := len(sc.fields)
+	common := append(sc.fields, make([]StructField, 1+len(sc.vals))...)
+	for i, g := range sc.groups {
 		common[ki] = StructField{
 			Name:  sc.key,
-			Value: NewString(g.Str()),
-		}
-		v, ok := row.Value(g)
-		if !ok {
-			Panicf(sc.parent.ast, "struct does not have field %v: %v", g.Str(), sc.src.Value())
+			Value: NewString(g.key),
 		}
-		common[vi] = StructField{
-			Name:  sc.val,
-			Value: v,
+		for vi, col := range g.cols {
+			v := NewNull(PosNull)
+			if col != symbol.Invalid {
+				v, _ = row.Value(col)
+			}
+			common[ki+1+vi] = StructField{
+				Name:  sc.vals[vi],
+				Value: v,
+			}
 		}
 		sc.rows[i] = NewStruct(NewSimpleStruct(common...))
 	}
+	sc.fields = common[:ki]
 	sc.next = 0
 	return true
 }
@@ -152,7 +236,8 @@ type spreadTableScanner struct {
 	ctx       context.Context
 	parent    *spreadGatherTable
 	src       TableScanner
-	key, val  symbol.ID
+	key       symbol.ID
+	vals      []symbol.ID
 	row       Value
 	buf       *termutil.BufferPrinter
 	fields    []StructField
@@ -170,74 +255,94 @@ func (sc *spreadTableScanner) Scan() bool {
 	}
 	row := sc.src.Value().Struct(sc.parent.ast)
 	nf := row.Len()
-	if cap(sc.fields) < nf {
-		sc.fields = make([]StructField, nf-1)
-	}
-	common := sc.fields[0 : nf-1]
-	next := 0
-	ki, vi := -1, -1
+	sc.fields = sc.fields[:0]
+	var kv Value
+	vvs := make([]Value, len(sc.vals))
+	nFound := 0
 	for i := 0; i < nf; i++ {
 		fl := row.Field(i)
-		switch {
-		case fl.Name == sc.key:
-			ki = i
-		case fl.Name == sc.val:
-			vi = i
-		default:
-			// Avoid indexing past the end of the slice if the key or
-			// value are not found.
-			if next < len(common) {
-				common[next] = fl
-				next++
-			}
+		if fl.Name == sc.key {
+			kv = fl.Value
+			nFound++
+		} else if vi := symbolIndex(sc.vals, fl.Name); vi >= 0 {
+			vvs[vi] = fl.Value
+			nFound++
+		} else {
+			sc.fields = append(sc.fields, fl)
 		}
 	}
-
-	if ki == -1 || vi == -1 {
-		Panicf(sc.parent.ast, "one or both of the key/value columns are missing from: %v", sc.src.Value())
+	if nFound != 1+len(sc.vals) {
+		Panicf(sc.parent.ast, "one or more of the key/value columns are missing from: %v", sc.src.Value())
 	}
 
-	if next != nf-2 {
-		Panicf(sc.parent.ast, "too many non key/value columns")
-	}
-
-	kv := row.Field(ki)
-	vv := row.Field(vi)
 	// Print the value of kv to buffer stored in sc.printArgs so that
 	// it can be used as a field name.
 	sc.buf.Reset()
-	kv.Value.printRec(sc.ctx, sc.printArgs, 0)
+	kv.printRec(sc.ctx, sc.printArgs, 0)
 	colname := sc.buf.String()
-	colid, ok := sc.symbols[colname]
-	if !ok {
-		colid = symbol.Intern(colname)
-		sc.symbols[colname] = colid
-
-	}
-	common[nf-2] = StructField{
-		Name:  colid,
-		Value: vv.Value,
+	common := sc.fields
+	for vi, vv := range vvs {
+		name := colname
+		if len(sc.vals) > 1 {
+			name = sc.vals[vi].Str() + sc.parent.sep + colname
+		}
+		colid, ok := sc.symbols[name]
+		if !ok {
+			colid = symbol.Intern(name)
+			sc.symbols[name] = colid
+		}
+		for _, f := range common {
+			if f.Name == colid {
+				Panicf(sc.parent.ast, "spread: new column '%s' collides with an existing column in %v", name, sc.src.Value())
+			}
+		}
+		common = append(common, StructField{
+			Name:  colid,
+			Value: vv,
+		})
 	}
 	sc.row = NewStruct(NewSimpleStruct(common...))
+	sc.fields = common[:len(sc.fields)]
 	return true
 }
 
-func builtinSpreadGather(table Table, spread bool, ast ASTNode, key, val symbol.ID, cols []symbol.ID) Value {
-	h := sgHashOp(table, key, val, cols)
+func builtinSpreadGather(table Table, spread bool, ast ASTNode, key symbol.ID, vals []symbol.ID, sep string, cols []symbol.ID) Value {
+	h := sgHashOp(table, key, vals, sep, cols)
 	sgt := &spreadGatherTable{
 		hash:   h,
 		ast:    ast,
 		src:    table,
 		spread: spread,
 		key:    key,
-		val:    val,
+		vals:   vals,
+		sep:    sep,
 		cols:   cols,
 	}
+	if !spread {
+		sgt.groups = newGatherGroups(ast, cols, vals, sep)
+	}
 	nt := NewTable(sgt)
 	return nt
 }
 
-func sgHashOp(table Table, key, val symbol.ID, cols []symbol.ID) hash.Hash {
+// parseSpreadGatherValues parses the value:= arg of spread and gather, a
+// comma-separated list of column names.
+func parseSpreadGatherValues(ast ASTNode, fn, key, vals string) []symbol.ID {
+	var ids []symbol.ID
+	for _, val := range strings.Split(vals, ",") {
+		if val = strings.TrimSpace(val); val == "" {
+			Panicf(ast, "%s: value '%s': empty column name", fn, vals)
+		}
+		id := symbol.Intern(val)
+		if val == key || symbolIndex(ids, id) >= 0 {
+			Panicf(ast, "%s: column '%s' is used more than once in key:= and value:=", fn, val)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func sgHashOp(table Table, key symbol.ID, vals []symbol.ID, sep string, cols []symbol.ID) hash.Hash {
 	h := hash.Hash{
 		0x56, 0x17, 0xf7, 0x05, 0x4f, 0xd8, 0x1c, 0x40,
 		0xd3, 0x38, 0x4f, 0xe3, 0x0c, 0x3c, 0xb9, 0x46,
@@ -246,7 +351,12 @@ func sgHashOp(table Table, key, val symbol.ID, cols []symbol.ID) hash.Hash {
 	}
 	h = h.Merge(table.Hash())
 	h = h.Merge(key.Hash())
-	h = h.Merge(val.Hash())
+	for _, val := range vals {
+		h = h.Merge(val.Hash())
+	}
+	if len(vals) > 1 {
+		h = h.Merge(hash.String(sep))
+	}
 	for _, s := range cols {
 		h = h.Merge(s.Hash())
 	}
@@ -256,15 +366,19 @@ func sgHashOp(table Table, key, val symbol.ID, cols []symbol.ID) hash.Hash {
 func init() {
 	RegisterBuiltinFunc("gather",
 		`
-    tbl | gather(colname..., key:=keycol, value:=valuecol)
+    tbl | gather(colname..., key:=keycol, value:=valuecol [, sep:=separator])
 
 Arg types:
 
 - _colname_: string
 - _keycol_: string
 - _valuecol_: string
+- _separator_: string (default: "_")
 
 Gather collapses multiple columns into key-value pairs, duplicating all other columns as needed. gather is based on the R tidyr::gather() function.
+The values keep their original types. The key column stores the names of the
+gathered columns as strings. It is an error if a column that isn't gathered has
+the same name as _keycol_ or _valuecol_.
 
 Example: Imagine table t0 with following contents:
 
@@ -281,12 +395,29 @@ Example: Imagine table t0 with following contents:
 │  Cat│ col2│    31│
 │  Dog│ col1│    40│
 │  Dog│ col2│    41│
+
+_valuecol_ may list multiple comma-separated column names to gather several
+values at once, similar to tidyr::pivot_longer(). Then each _colname_ must be
+of form "<valuecol><separator><key>", and the columns with the same key are
+gathered into one row. A value missing for a key becomes NA. For example, if
+table t1 is:
+
+        ║col0 ║depth_s1║count_s1║depth_s2║count_s2║
+        ├─────┼────────┼────────┼────────┼────────┤
+        │Cat  │    1.5 │     30 │    2.5 │     31 │
+
+::t1 | gather("depth_s1", "count_s1", "depth_s2", "count_s2", key:="sample", value:="depth,count"):: will produce:
+
+║ col0║ sample║ depth║ count║
+├─────┼───────┼──────┼──────┤
+│  Cat│     s1│   1.5│    30│
+│  Cat│     s2│   2.5│    31│
 		`,
 		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 			n := len(args)
-			key, val := args[n-2], args[n-1]
-			gather := make([]symbol.ID, 0, n-3)
-			for _, arg := range args[1 : n-2] {
+			key, val, sep := args[n-3], args[n-2], args[n-1]
+			gather := make([]symbol.ID, 0, n-4)
+			for _, arg := range args[1 : n-3] {
 				gather = append(gather, symbol.Intern(arg.Str()))
 			}
 			return builtinSpreadGather(
@@ -294,26 +425,29 @@ Example: Imagine table t0 with following contents:
 				false,
 				ast,
 				symbol.Intern(key.Str()),
-				symbol.Intern(val.Str()),
+				parseSpreadGatherValues(ast, "gather", key.Str(), val.Str()),
+				sep.Str(),
 				gather)
 		},
 		func(ast ASTNode, args []AIArg) AIType { return AITableType },
 		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},                  // table
 		FormalArg{Positional: true, Required: true, Variadic: true, Types: []ValueType{StringType}}, // column names
 		FormalArg{Name: symbol.Key, Required: true, Types: []ValueType{StringType}},                 // key colname
-		FormalArg{Name: symbol.Value, Required: true, Types: []ValueType{StringType}},               // value colname
+		FormalArg{Name: symbol.Value, Required: true, Types: []ValueType{StringType}},               // value colname(s)
+		FormalArg{Name: symbol.Sep, DefaultValue: NewString("_"), Types: []ValueType{StringType}},   // separator
 	)
 }
 
 func init() {
 	RegisterBuiltinFunc("spread",
 		`
-    tbl | spread(keycol, valuecol)
+    tbl | spread(keycol, valuecol [, sep:=separator])
 
 Arg types:
 
 - _keycol_: string
 - _valuecol_: string
+- _separator_: string (default: "_")
 
 Spread expands rows across two columns as key-value pairs, duplicating all other columns as needed. spread is based on the R tidyr::spread() function.
 
@@ -333,20 +467,32 @@ Example: Imagine table t0 with following contents:
 
 Note the blank cell values, which may require the use the function to contains to
 test for the existence of a field in a row struct in subsequent manipulations.
+
+The values keep their original types. It is an error if a new column has the
+same name as one of the other columns in the row.
+
+_valuecol_ may list multiple comma-separated column names to spread several
+values at once, similar to tidyr::pivot_wider(). Then the new columns are
+named "<valuecol><separator><key>". For example, ::t0 | spread(key:="sample",
+value:="depth,count")::, where t0 has columns col0, sample, depth, and count,
+produces columns col0, depth_s1, count_s1, and so on. It is the inverse of the
+multi-column gather.
 `,
 		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
-			key, val := args[1], args[2]
+			key, val, sep := args[1], args[2], args[3]
 			return builtinSpreadGather(
 				args[0].Table(),
 				true,
 				ast,
 				symbol.Intern(key.Str()),
-				symbol.Intern(val.Str()),
+				parseSpreadGatherValues(ast, "spread", key.Str(), val.Str()),
+				sep.Str(),
 				nil)
 		},
 		func(ast ASTNode, args []AIArg) AIType { return AITableType },
 		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},                                      // table
 		FormalArg{Name: symbol.Key, Required: true, Types: []ValueType{StringType}, DefaultValue: NewString("key")},     // key colname
-		FormalArg{Name: symbol.Value, Required: true, Types: []ValueType{StringType}, DefaultValue: NewString("value")}, // value colname
+		FormalArg{Name: symbol.Value, Required: true, Types: []ValueType{StringType}, DefaultValue: NewString("value")}, // value colname(s)
+		FormalArg{Name: symbol.Sep, DefaultValue: NewString("_"), Types: []ValueType{StringType}},                       // separator
 	)
 }
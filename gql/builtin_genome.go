@@ -0,0 +1,170 @@
+package gql
+
+// This file implements genome() and normalize_chrom(), which provide reference
+// genome metadata and reconcile the chromosome naming conventions.
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+)
+
+// chromSize is the length of a chromosome.
+type chromSize struct {
+	chrom  string
+	length int64
+}
+
+// builtinGenomes lists the chromosome sizes of the primary assemblies known to
+// genome_bins(). Only the primary chromosomes are listed.
+var builtinGenomes = map[string][]chromSize{
+	"hg38": {
+		{"chr1", 248956422}, {"chr2", 242193529}, {"chr3", 198295559},
+		{"chr4", 190214555}, {"chr5", 181538259}, {"chr6", 170805979},
+		{"chr7", 159345973}, {"chr8", 145138636}, {"chr9", 138394717},
+		{"chr10", 133797422}, {"chr11", 135086622}, {"chr12", 133275309},
+		{"chr13", 114364328}, {"chr14", 107043718}, {"chr15", 101991189},
+		{"chr16", 90338345}, {"chr17", 83257441}, {"chr18", 80373285},
+		{"chr19", 58617616}, {"chr20", 64444167}, {"chr21", 46709983},
+		{"chr22", 50818468}, {"chrX", 156040895}, {"chrY", 57227415},
+		{"chrM", 16569},
+	},
+	"hg19": {
+		{"chr1", 249250621}, {"chr2", 243199373}, {"chr3", 198022430},
+		{"chr4", 191154276}, {"chr5", 180915260}, {"chr6", 171115067},
+		{"chr7", 159138663}, {"chr8", 146364022}, {"chr9", 141213431},
+		{"chr10", 135534747}, {"chr11", 135006516}, {"chr12", 133851895},
+		{"chr13", 115169878}, {"chr14", 107349540}, {"chr15", 102531392},
+		{"chr16", 90354753}, {"chr17", 81195210}, {"chr18", 78077248},
+		{"chr19", 59128983}, {"chr20", 63025520}, {"chr21", 48129895},
+		{"chr22", 51304566}, {"chrX", 155270560}, {"chrY", 59373566},
+		{"chrM", 16571},
+	},
+}
+
+// lookupGenome finds a builtin genome. Arg fn is the name of the calling
+// function, used in an error message.
+func lookupGenome(ast ASTNode, fn, name string) []chromSize {
+	sizes, ok := builtinGenomes[name]
+	if !ok {
+		var names []string
+		for name := range builtinGenomes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		Panicf(ast, "%s: unknown genome '%s'; it must be one of %s", fn, name, strings.Join(names, ", "))
+	}
+	return sizes
+}
+
+// Chromosome naming styles accepted by normalize_chrom.
+const (
+	// chromStyleUCSC names chromosomes "chr1", ..., "chrX", "chrY", "chrM".
+	chromStyleUCSC = "ucsc"
+	// chromStyleEnsembl names chromosomes "1", ..., "X", "Y", "MT".
+	chromStyleEnsembl = "ensembl"
+)
+
+// normalizeChrom converts a chromosome name to the given style. Names of
+// unplaced contigs, e.g., "chrUn_KI270302v1", only have the "chr" prefix added
+// or removed.
+func normalizeChrom(chrom, style string) string {
+	base := chrom
+	if len(base) > 3 && strings.EqualFold(base[:3], "chr") {
+		base = base[3:]
+	}
+	switch strings.ToUpper(base) {
+	case "M", "MT":
+		if style == chromStyleUCSC {
+			return "chrM"
+		}
+		return "MT"
+	case "X", "Y":
+		base = strings.ToUpper(base)
+	}
+	if style == chromStyleUCSC {
+		return "chr" + base
+	}
+	return base
+}
+
+func init() {
+	RegisterBuiltinFunc("genome",
+		`
+    genome(name)
+
+Arg types:
+
+- _name_: string, either "hg38" or "hg19"
+
+Genome creates a table that lists the primary chromosomes of a reference
+genome in the canonical order, chr1-22, chrX, chrY, and chrM. Each row has the
+following columns:
+
+ - Column 'chrom' is the UCSC-style name of the chromosome, e.g., "chr1".
+ - Column 'ensembl' is the Ensembl-style name of the chromosome, e.g., "1".
+ - Column 'length' is the length of the chromosome.
+ - Column 'index' is the 0-based position of the chromosome in the canonical
+   order. Sort by this column to order chromosomes naturally, rather than
+   lexicographically.
+
+The table can be passed to [genome_bins](#genome_bins).
+
+Example:
+
+    genome("hg38") | firstn(1) == table({chrom:"chr1", ensembl:"1", length:248956422, index:0})`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			name := args[0].Str()
+			sizes := lookupGenome(ast, "genome", name)
+			rows := make([]Value, len(sizes))
+			for i, cs := range sizes {
+				rows[i] = NewStruct(NewSimpleStruct(
+					StructField{Name: symbol.Chrom, Value: NewString(cs.chrom)},
+					StructField{Name: symbol.Ensembl, Value: NewString(normalizeChrom(cs.chrom, chromStyleEnsembl))},
+					StructField{Name: symbol.Length, Value: NewInt(cs.length)},
+					StructField{Name: symbol.Index, Value: NewInt(int64(i))}))
+			}
+			return NewTable(NewSimpleTable(rows, hash.String("genome:"+name), TableAttrs{Name: name}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{StringType}})
+
+	RegisterBuiltinFunc("normalize_chrom",
+		`
+    normalize_chrom(chrom [, style:=style])
+
+Arg types:
+
+- _chrom_: string
+- _style_: string, either "ucsc" or "ensembl" (default: "ucsc")
+
+Normalize_chrom converts a chromosome name to the given naming convention. The
+"ucsc" style names chromosomes "chr1", ..., "chrX", "chrY", and "chrM". The
+"ensembl" style names them "1", ..., "X", "Y", and "MT". The "chr" prefix is
+matched case-insensitively. If _chrom_ is NA, it returns NA.
+
+Use it to join tables that come from sources with different conventions.
+
+Example:
+
+    normalize_chrom("1") == "chr1"
+    normalize_chrom("MT") == "chrM"
+    normalize_chrom("chrM", style:="ensembl") == "MT"
+    bed1 | map({chrom: normalize_chrom(&chrom), &start, &end})`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			style := args[1].Str()
+			if style != chromStyleUCSC && style != chromStyleEnsembl {
+				Panicf(ast, "normalize_chrom: style must be \"%s\" or \"%s\", but found \"%s\"", chromStyleUCSC, chromStyleEnsembl, style)
+			}
+			if args[0].Value.Type() != StringType {
+				return args[0].Value
+			}
+			return NewString(normalizeChrom(args[0].Str(), style))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIStringType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.Style, Types: []ValueType{StringType}, DefaultValue: NewString(chromStyleUCSC)})
+}
@@ -0,0 +1,174 @@
+package gql
+
+// This file implements genome_bins() and bin_assign(), which split a genome
+// into fixed-size bins.
+
+import (
+	"context"
+	"sort"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+)
+
+// readChromSizes reads a table of chromosome sizes. Each row must have columns
+// "chrom" and "length". Otherwise, the first two columns are used.
+func readChromSizes(ctx context.Context, ast ASTNode, t Table) []chromSize {
+	var sizes []chromSize
+	sc := t.Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		row := sc.Value().Struct(ast)
+		chrom, ok := row.Value(symbol.Chrom)
+		length, ok2 := row.Value(symbol.Length)
+		if !ok || !ok2 {
+			if row.Len() < 2 {
+				Panicf(ast, "genome_bins: a row of the genome table must have columns chrom and length, but found %v", sc.Value())
+			}
+			chrom, length = row.Field(0).Value, row.Field(1).Value
+		}
+		sizes = append(sizes, chromSize{chrom: chrom.Str(ast), length: length.Int(ast)})
+	}
+	return sizes
+}
+
+// newGenomeBinsTable creates a table of {chrom, start, end} rows that covers
+// the chromosomes with bins of the given size.
+func newGenomeBinsTable(ast ASTNode, h hash.Hash, sizes []chromSize, size int64) Table {
+	// offsets[i] is the number of bins in sizes[:i].
+	offsets := make([]int64, len(sizes)+1)
+	for i, cs := range sizes {
+		offsets[i+1] = offsets[i] + (cs.length+size-1)/size
+	}
+	return newGeneratorTable(ast, "genome_bins", h, offsets[len(sizes)], func(i int) Value {
+		ci := sort.Search(len(sizes), func(ci int) bool { return offsets[ci+1] > int64(i) })
+		start := (int64(i) - offsets[ci]) * size
+		end := start + size
+		if end > sizes[ci].length {
+			end = sizes[ci].length
+		}
+		return NewStruct(NewSimpleStruct(
+			StructField{Name: symbol.Chrom, Value: NewString(sizes[ci].chrom)},
+			StructField{Name: symbol.Start, Value: NewInt(start)},
+			StructField{Name: symbol.End, Value: NewInt(end)}))
+	})
+}
+
+func init() {
+	RegisterBuiltinFunc("genome_bins",
+		`
+    genome_bins([genome:=name] [, size:=n])
+
+Arg types:
+
+- _name_: string or table (default: "hg38")
+- _n_: int (default: 100000)
+
+Genome_bins creates a table of fixed-size bins that cover the genome. Each row
+has columns {chrom, start, end}, where [start, end) is a zero-based, half-open
+range, as in a BED file. The last bin of a chromosome is truncated at the end
+of the chromosome.
+
+The _genome_ arg is either the name of a builtin genome, "hg38" or "hg19", or
+a table of chromosome sizes, such as the one created by [genome](#genome). Each
+row of the table must have columns "chrom" and "length". If these columns are
+not found, the first two columns are used. The builtin genomes contain only the
+primary chromosomes, chr1-22, chrX, chrY, and chrM.
+
+Example:
+
+    genome_bins(size:=1000000) | filter(&chrom == "chr1") | firstn(2) == table({chrom:"chr1", start:0, end:1000000}, {chrom:"chr1", start:1000000, end:2000000})
+    genome_bins(genome:=table({chrom:"c1", length:25}), size:=10) == table({chrom:"c1", start:0, end:10}, {chrom:"c1", start:10, end:20}, {chrom:"c1", start:20, end:25})`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			size := args[1].Int()
+			if size <= 0 {
+				Panicf(ast, "genome_bins: size must be positive, but found %d", size)
+			}
+			h := hash.String("genome_bins").Merge(hash.Int(size))
+			var sizes []chromSize
+			if args[0].Value.Type() == TableType {
+				t := args[0].Table()
+				h = h.Merge(t.Hash())
+				sizes = readChromSizes(ctx, ast, t)
+			} else {
+				name := args[0].Str()
+				sizes = lookupGenome(ast, "genome_bins", name)
+				h = h.Merge(hash.String(name))
+			}
+			return NewTable(newGenomeBinsTable(ast, h, sizes, size))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Name: symbol.Genome, Types: []ValueType{StringType, TableType}, DefaultValue: NewString("hg38")},
+		FormalArg{Name: symbol.Size, Types: []ValueType{IntType}, DefaultValue: NewInt(100000)})
+
+	RegisterBuiltinFunc("bin_assign",
+		`
+    tbl | bin_assign(size:=n [, start:=startexpr] [, col:=colname])
+
+Arg types:
+
+- _n_: int
+- _startexpr_: one-arg function (default: ::|row|row.start::)
+- _colname_: string (default: "bin")
+
+Bin_assign adds a column to each row of the table. The column stores the
+start of the bin of size _n_ that contains the position given by
+_startexpr_, i.e., ::floor(startexpr / n) * n::. The bins are aligned with the
+ones created by [genome_bins](#genome_bins) with the same size, so the result
+can be joined with the bin table on columns chrom and start.
+
+If the row already has a column named _colname_, its value is replaced.
+
+Example:
+
+    table({chrom:"chr1", start:12345}) | bin_assign(size:=1000) == table({chrom:"chr1", start:12345, bin:12000})
+    reads | bin_assign(size:=100000, start:=&pos, col:="bin_start")`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			src := args[0].Table()
+			size := args[1].Int()
+			if size <= 0 {
+				Panicf(ast, "bin_assign: size must be positive, but found %d", size)
+			}
+			start := getBEDTargetField(args[2], symbol.Start)
+			col := symbol.Intern(args[3].Str())
+			h := hash.String("bin_assign").Merge(src.Hash()).Merge(hash.Int(size)).
+				Merge(start.Hash()).Merge(col.Hash())
+			return NewTable(&rowTransformTable{
+				src:  src,
+				hash: h,
+				fn: func(ctx context.Context, row Value) Value {
+					st := row.Struct(ast)
+					pos := evalBEDTargetField(ctx, ast, st, start)
+					bin := Null
+					if pos.Null() == NotNull {
+						p := pos.Int(ast)
+						// Round towards negative infinity.
+						b := p / size
+						if p < 0 && p%size != 0 {
+							b--
+						}
+						bin = NewInt(b * size)
+					}
+					fields := make([]StructField, 0, st.Len()+1)
+					found := false
+					for i := 0; i < st.Len(); i++ {
+						f := st.Field(i)
+						if f.Name == col {
+							f.Value = bin
+							found = true
+						}
+						fields = append(fields, f)
+					}
+					if !found {
+						fields = append(fields, StructField{Name: col, Value: bin})
+					}
+					return NewStruct(NewSimpleStruct(fields...))
+				},
+			})
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Name: symbol.Size, Required: true, Types: []ValueType{IntType}},
+		FormalArg{Name: symbol.Start, Closure: true, ClosureArgs: anonRowFuncArg, DefaultValue: NewFunc(nil)},
+		FormalArg{Name: symbol.Col, Types: []ValueType{StringType}, DefaultValue: NewString("bin")},
+		FormalArg{Name: symbol.Row, Symbol: true, DefaultSymbol: symbol.AnonRow})
+}
@@ -0,0 +1,111 @@
+package gql
+
+import (
+	"context"
+	"sync"
+
+	"github.com/grailbio/gql/hash"
+)
+
+// maxIsinSets is the max number of tables whose contents are cached by isin().
+const maxIsinSets = 64
+
+var (
+	isinSetsMu sync.Mutex
+	// isinSets caches the set of values in the tables passed to isin(), keyed by
+	// the table hash.
+	isinSets = map[hash.Hash]map[hash.Hash]struct{}{}
+)
+
+// isinKey computes the key used to look up a value in an isin() set. It
+// returns false if the value is NA.
+func isinKey(v Value) (hash.Hash, bool) {
+	if h, ok := hashIndexKey(v); ok {
+		return h, true
+	}
+	if v.Null() != NotNull {
+		return hash.Zero, false
+	}
+	return v.Hash(), true
+}
+
+// isinRowValue extracts the value to be matched from a row of the table passed
+// to isin(). If the row is a single-column struct, the column value is used.
+func isinRowValue(ast ASTNode, row Value) Value {
+	if row.Type() == StructType {
+		if s := row.Struct(ast); s.Len() == 1 {
+			return s.Field(0).Value
+		}
+	}
+	return row
+}
+
+// getIsinSet reads the table into a set.
+func getIsinSet(ctx context.Context, ast ASTNode, t Table) map[hash.Hash]struct{} {
+	h := t.Hash()
+	isinSetsMu.Lock()
+	set, ok := isinSets[h]
+	isinSetsMu.Unlock()
+	if ok {
+		return set
+	}
+	set = map[hash.Hash]struct{}{}
+	sc := t.Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		if key, ok := isinKey(isinRowValue(ast, sc.Value())); ok {
+			set[key] = struct{}{}
+		}
+	}
+	isinSetsMu.Lock()
+	if len(isinSets) >= maxIsinSets {
+		isinSets = map[hash.Hash]map[hash.Hash]struct{}{}
+	}
+	isinSets[h] = set
+	isinSetsMu.Unlock()
+	return set
+}
+
+func builtinIsin(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+	key, ok := isinKey(args[0].Value)
+	if !ok {
+		return False
+	}
+	if len(args) == 2 && args[1].Value.Type() == TableType {
+		_, found := getIsinSet(ctx, ast, args[1].Table())[key]
+		return NewBool(found)
+	}
+	for _, arg := range args[1:] {
+		if k, ok := isinKey(arg.Value); ok && k == key {
+			return True
+		}
+	}
+	return False
+}
+
+func init() {
+	RegisterBuiltinFunc("isin",
+		`
+    isin(expr, value0, value1, ...)
+    isin(expr, tbl)
+
+Isin returns true if _expr_ equals one of the given values. It is a shorthand
+for "expr==value0 || expr==value1 || ...".
+
+In the second form, isin returns true if _expr_ equals one of the rows of _tbl_.
+If the rows are single-column structs, _expr_ is compared with the column
+values. The table contents are read into a hash set once, so the second form
+is efficient even when the table is large.
+
+If _expr_ is NA, isin returns false.
+
+Example:
+
+    read("foo.tsv") | filter(isin(&chrom, "chr1", "chr2", "chrX"))
+    allowlist := read("samples.tsv") | map(&sample_id)
+    read("foo.tsv") | filter(isin(&sample_id, allowlist))
+`,
+		builtinIsin,
+		func(ast ASTNode, _ []AIArg) AIType { return AIBoolType },
+		FormalArg{Positional: true, Required: true},
+		FormalArg{Positional: true, Required: true, Variadic: true})
+}
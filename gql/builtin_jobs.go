@@ -0,0 +1,188 @@
+package gql
+
+// This file implements jobs() and job_logs(), which show the parallel stages
+// recorded in jobRegistry.
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+var (
+	jobIDSymbolID         = symbol.Intern("id")
+	jobLocationSymbolID   = symbol.Intern("location")
+	jobStateSymbolID      = symbol.Intern("state")
+	jobShardsDoneSymbolID = symbol.Intern("shards_done")
+	jobFailuresSymbolID   = symbol.Intern("failures")
+	jobDurationSymbolID   = symbol.Intern("duration")
+	jobTimeSymbolID       = symbol.Intern("time")
+	jobShardSymbolID      = symbol.Intern("shard")
+)
+
+// snapshotSeq makes the hash of each snapshotTable unique.
+var snapshotSeq int64
+
+// snapshotTable is a table whose rows are computed when it is scanned. A
+// call to a builtin such as jobs() is evaluated during analysis, since its args
+// are constants, so the rows must be computed lazily to show the state of the
+// process at the time the table is read. It is also used by
+// missing_columns().
+type snapshotTable struct {
+	hash  hash.Hash
+	ast   ASTNode
+	attrs TableAttrs
+	rows  func() []Value
+}
+
+func newSnapshotTable(ast ASTNode, name string, rows func() []Value) Table {
+	h := hash.String(name).Merge(hash.Int(time.Now().UnixNano())).Merge(hash.Int(atomic.AddInt64(&snapshotSeq, 1)))
+	return &snapshotTable{hash: h, ast: ast, attrs: TableAttrs{Name: name}, rows: rows}
+}
+
+// Len implements Table.
+func (t *snapshotTable) Len(ctx context.Context, mode CountMode) int { return len(t.rows()) }
+
+// Marshal implements Table.
+func (t *snapshotTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	MarshalTableOutline(ctx, enc, t)
+}
+
+// Prefetch implements Table.
+func (t *snapshotTable) Prefetch(ctx context.Context) {}
+
+// Hash implements Table.
+func (t *snapshotTable) Hash() hash.Hash { return t.hash }
+
+// Attrs implements Table.
+func (t *snapshotTable) Attrs(ctx context.Context) TableAttrs { return t.attrs }
+
+// Parallelizable implements ParallelizableTable. Each scanner takes its own
+// snapshot, so the shards would be inconsistent.
+func (t *snapshotTable) Parallelizable(ctx context.Context) bool { return false }
+
+// Scanner implements Table.
+func (t *snapshotTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	if start > 0 {
+		return &NullTableScanner{}
+	}
+	return NewSimpleTable(t.rows(), t.hash, t.attrs).Scanner(ctx, 0, 1, 1)
+}
+
+// jobRow creates a row of jobs().
+func jobRow(j *job) Value {
+	state := j.state()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	end := j.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return NewStruct(NewSimpleStruct(
+		StructField{Name: jobIDSymbolID, Value: NewInt(int64(j.id))},
+		StructField{Name: symbol.Name, Value: NewString(j.name)},
+		StructField{Name: jobLocationSymbolID, Value: NewString(j.location)},
+		StructField{Name: jobStateSymbolID, Value: NewString(state)},
+		StructField{Name: symbol.Shards, Value: NewInt(int64(j.nshards))},
+		StructField{Name: jobShardsDoneSymbolID, Value: NewInt(int64(j.shardsDone))},
+		StructField{Name: jobFailuresSymbolID, Value: NewInt(int64(j.failures))},
+		StructField{Name: symbol.Start, Value: NewDateTime(j.start)},
+		StructField{Name: jobDurationSymbolID, Value: NewDuration(end.Sub(j.start))}))
+}
+
+// jobLogRows creates the rows of job_logs(id).
+func jobLogRows(ast ASTNode, id int) []Value {
+	j := findJob(id)
+	if j == nil {
+		Panicf(ast, "job_logs: job %d not found; see jobs()", id)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rows := make([]Value, len(j.logs))
+	for i, e := range j.logs {
+		shard := Null
+		if e.shard >= 0 {
+			shard = NewInt(int64(e.shard))
+		}
+		rows[i] = NewStruct(NewSimpleStruct(
+			StructField{Name: jobTimeSymbolID, Value: NewDateTime(e.time)},
+			StructField{Name: jobShardSymbolID, Value: shard},
+			StructField{Name: symbol.Message, Value: NewString(e.message)}))
+	}
+	return rows
+}
+
+func init() {
+	RegisterBuiltinFunc("jobs",
+		`
+    jobs()
+
+Jobs returns a table of the parallel stages, or jobs, started in this process,
+oldest first. A job is started by map, filter, reduce, cogroup, sort, minn,
+bootstrap, or permute_test with _shards_ > 0. Each row has the following
+columns:
+
+- id: the job ID, passed to job_logs.
+- name: the name of the function, e.g., "map".
+- location: the source location of the function call.
+- state: "running", "done", or "failed".
+- shards: the number of shards.
+- shards_done: the number of shards finished.
+- failures: the number of shards failed.
+- start: the start time.
+- duration: the running time so far, or the total running time if the job has ended.
+
+The table is computed when it is read, so it shows the jobs at that time. When
+the job runs on a bigslice cluster, _shards_done_ is updated only when the whole
+job finishes. See the [distributed execution](#distributed-execution) section
+for more details.
+
+Example:
+
+    jobs() | filter(&state == "failed")
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return NewTable(newSnapshotTable(ast, "jobs", func() []Value {
+				jobs := listJobs()
+				rows := make([]Value, len(jobs))
+				for i, j := range jobs {
+					rows[i] = jobRow(j)
+				}
+				return rows
+			}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType })
+
+	RegisterBuiltinFunc("job_logs",
+		`
+    job_logs(id)
+
+Arg types:
+
+- _id_: int
+
+Job_logs returns the log of the job with the given ID, as listed by jobs(). Each
+row has columns {time, shard, message}. The _shard_ column is NA for a message
+about the whole job, such as its start and end. The log contains the error of
+each failed shard. For a job run on a bigslice cluster, it contains the error
+reported by bigslice, which names the failed task; the full worker logs are
+kept by bigslice.
+
+Example:
+
+    job_logs(3) | filter(!isnull(&shard))
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			id := int(args[0].Int())
+			return NewTable(newSnapshotTable(ast, fmt.Sprintf("job_logs(%d)", id), func() []Value {
+				return jobLogRows(ast, id)
+			}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{IntType}}) // id
+}
@@ -178,13 +178,19 @@ func (t *joinLeafNode) Len(ctx context.Context, mode CountMode) int {
 	return t.table.table.Len(ctx, mode)
 }
 
-// Marshal implements Table, but it shall never be called.
-// Marshaling of a join table is done always at the root level.
-func (t *joinLeafNode) Marshal(ctx MarshalContext, enc *marshal.Encoder) { panic("Not implemented") }
+// Marshal implements Table. Marshaling of a join table is usually done at the
+// root level, but a node may still be captured by itself, e.g., by a
+// distributed lambda, so it is shipped as a materialized btsv table.
+func (t *joinLeafNode) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	MarshalTableOutline(ctx, enc, t)
+}
 
 // Prefetch implements Table.
 func (t *joinLeafNode) Prefetch(ctx context.Context) {}
 
+// Parallelizable implements ParallelizableTable.
+func (t *joinLeafNode) Parallelizable(ctx context.Context) bool { return false }
+
 // isSorted implements joinNode.
 func (t *joinLeafNode) isSorted(c joinColumn) bool { return false }
 
@@ -252,7 +258,7 @@ func newJoinSortingNode(ctx context.Context, table joinNode, sortCol joinColumn)
 		sortCol: sortCol,
 		attrs:   TableAttrs{Name: fmt.Sprintf("join:sort(%s/%s)", table.Attrs(ctx).Name, sortCol.col.Str())},
 	}
-	n.sorted = NewMinNTable(ctx, astUnknown /*TODO:fix*/, TableAttrs{Name: "join"}, n.table, sortCol.keyExpr, -1, 0)
+	n.sorted = NewMinNTable(ctx, astUnknown /*TODO:fix*/, TableAttrs{Name: "join"}, n.table, sortCol.keyExpr, naOrder, -1, 0, false, nil)
 	return n
 }
 
@@ -276,13 +282,19 @@ func (t *joinSortingNode) Len(ctx context.Context, mode CountMode) int {
 	return t.table.Len(ctx, mode)
 }
 
-// Marshal implements Table, but it shall never be called.
-// Marshaling of a join table is done always at the root level.
-func (t *joinSortingNode) Marshal(ctx MarshalContext, enc *marshal.Encoder) { panic("Not implemented") }
+// Marshal implements Table. Marshaling of a join table is usually done at the
+// root level, but a node may still be captured by itself, e.g., by a
+// distributed lambda, so it is shipped as a materialized btsv table.
+func (t *joinSortingNode) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	MarshalTableOutline(ctx, enc, t)
+}
 
 // Prefetch implements Table.
 func (t *joinSortingNode) Prefetch(ctx context.Context) {}
 
+// Parallelizable implements ParallelizableTable.
+func (t *joinSortingNode) Parallelizable(ctx context.Context) bool { return false }
+
 // isSorted implements joinNode.
 func (t *joinSortingNode) isSorted(c joinColumn) bool { return t.sortCol.equals(c) }
 
@@ -364,7 +376,7 @@ func (t *joinSortingMergeNode) Hash() hash.Hash {
 // Len implements Table.
 func (t *joinSortingMergeNode) Len(ctx context.Context, mode CountMode) int {
 	if mode == Exact {
-		panic("not implemented")
+		return DefaultTableLen(ctx, t)
 	}
 	l0 := t.child[0].Len(ctx, mode)
 	if l1 := t.child[0].Len(ctx, mode); l1 < l0 {
@@ -373,14 +385,17 @@ func (t *joinSortingMergeNode) Len(ctx context.Context, mode CountMode) int {
 	return l0
 }
 
-// Marshal implements Table.
+// Marshal implements Table. The node is shipped as a materialized btsv table.
 func (t *joinSortingMergeNode) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
-	panic("Not implemented")
+	MarshalTableOutline(ctx, enc, t)
 }
 
 // Prefetch implements Table.
 func (t *joinSortingMergeNode) Prefetch(ctx context.Context) {}
 
+// Parallelizable implements ParallelizableTable.
+func (t *joinSortingMergeNode) Parallelizable(ctx context.Context) bool { return false }
+
 // isSorted implements joinNode.
 func (t *joinSortingMergeNode) isSorted(c joinColumn) bool {
 	if t.constraint.op != eqeqSymbolID {
@@ -484,7 +499,7 @@ func (t *joinSortingMergeScanner) readNextRows(c *joinSortingMergeChild) {
 		// Find all values with same key as c.key and combine them into c.values.
 		c.nextRow = c.sc.Value()
 		curKey := c.keyExpr.Eval(t.ctx, c.nextRow)
-		cmp := Compare(t.parent.ast, curKey, c.key)
+		cmp := CompareNA(t.parent.ast, curKey, c.key, naOrder)
 		if cmp < 0 {
 			log.Panicf("%s: Unsorted keys: %v < %v", t.label, curKey, c.key)
 		}
@@ -511,7 +526,7 @@ func (t *joinSortingMergeScanner) readNext() *joinCartesianProduct {
 			if c.key.Type() == InvalidType {
 				log.Panicf("expr %v, val %v", c.keyExpr, PrintValueList(c.values))
 			}
-			if minIdx < 0 || Compare(t.parent.ast, c.key, minKey) < 0 {
+			if minIdx < 0 || CompareNA(t.parent.ast, c.key, minKey, naOrder) < 0 {
 				minIdx = i
 				minKey = c.key
 			}
@@ -526,7 +541,7 @@ func (t *joinSortingMergeScanner) readNext() *joinCartesianProduct {
 			if c.eof {
 				continue
 			}
-			if c := Compare(t.parent.ast, c.key, minKey); c != 0 {
+			if c := CompareNA(t.parent.ast, c.key, minKey, naOrder); c != 0 {
 				if c <= 0 {
 					log.Panicf("CompareValues: %v %v", t, minKey)
 				}
@@ -612,20 +627,23 @@ func (t *joinCrossMergeNode) Hash() hash.Hash {
 // Len implements Table.
 func (t *joinCrossMergeNode) Len(ctx context.Context, mode CountMode) int {
 	if mode == Exact {
-		panic("not implemented")
+		return DefaultTableLen(ctx, t)
 	}
 	t.init(ctx)
 	return t.child[0].Len(ctx, mode) * len(t.child1Rows) // TODO(saito) fix
 }
 
-// Marshal implements Table.
+// Marshal implements Table. The node is shipped as a materialized btsv table.
 func (t *joinCrossMergeNode) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
-	panic("Not implemented")
+	MarshalTableOutline(ctx, enc, t)
 }
 
 // Prefetch implements Table.
 func (t *joinCrossMergeNode) Prefetch(ctx context.Context) {}
 
+// Parallelizable implements ParallelizableTable.
+func (t *joinCrossMergeNode) Parallelizable(ctx context.Context) bool { return false }
+
 // isSorted implements joinNode.
 func (t *joinCrossMergeNode) isSorted(c joinColumn) bool {
 	// Cross-merge iterates the child[0] in order.
@@ -695,6 +713,191 @@ func (t *joinCrossMergeScanner) Value() Value {
 	return t.rowCP.value()
 }
 
+// joinIndexMaxProbeRows is the max number of rows of a table whose matching
+// rows are looked up using a key index by joinIndexLookupNode. Each lookup reads
+// a part of the indexed table, so the index is worth using only when the other
+// table is small.
+const joinIndexMaxProbeRows = 1000
+
+// JoinIndexLookupNode joins a small table (the probe) with a btsv table that
+// has a key index on the join column (see build_index). For each row of the
+// probe, it reads only the matching rows of the indexed table, instead of
+// sorting both tables. It yields rows in the probe order. It is used only for
+// inner joins.
+type joinIndexLookupNode struct {
+	parent *joinTable
+	attrs  TableAttrs
+	// Child are the two tables to join. child[indexed] is a joinLeafNode for
+	// btsv, and child[1-indexed] is the probe.
+	child   [2]joinNode
+	indexed int
+	btsv    *btsvTable
+	idx     *btsvKeyIndex
+	// Constraint defines the natural-join condition, as in joinSortingMergeNode.
+	constraint joinConstraint
+}
+
+// newJoinIndexLookupNode creates a joinIndexLookupNode if one of the children
+// is a btsv table with a usable key index on the join column, and the other is
+// small. Else it returns nil.
+func newJoinIndexLookupNode(ctx context.Context, parent *joinTable, child0, child1 joinNode, constraint joinConstraint) joinNode {
+	if constraint.op != eqeqSymbolID {
+		return nil
+	}
+	child := [2]joinNode{child0, child1}
+	for indexed := 1; indexed >= 0; indexed-- {
+		leaf, ok := child[indexed].(*joinLeafNode)
+		if !ok || leaf.table != constraint.tables[indexed].table {
+			continue
+		}
+		btsv, ok := leaf.table.table.(*btsvTable)
+		if !ok {
+			continue
+		}
+		probeLen := child[1-indexed].Len(ctx, Approx)
+		if probeLen > joinIndexMaxProbeRows || probeLen >= btsv.Len(ctx, Approx) {
+			continue
+		}
+		idx := findBTSVKeyIndex(ctx, btsv, constraint.tables[indexed].col)
+		if idx == nil {
+			continue
+		}
+		return &joinIndexLookupNode{
+			parent:     parent,
+			attrs:      TableAttrs{Name: fmt.Sprintf("join:indexlookup(probe:=%s,index:=%s,cond=%+v)", child[1-indexed].Attrs(ctx).Name, child[indexed].Attrs(ctx).Name, constraint)},
+			child:      child,
+			indexed:    indexed,
+			btsv:       btsv,
+			idx:        idx,
+			constraint: constraint,
+		}
+	}
+	return nil
+}
+
+// newJoinMergeNode creates a node that joins child0 and child1 under the given
+// constraint. It uses a key index if possible, and sorts both children
+// otherwise.
+func newJoinMergeNode(ctx context.Context, parent *joinTable, child0, child1 joinNode, constraint joinConstraint) joinNode {
+	if n := newJoinIndexLookupNode(ctx, parent, child0, child1, constraint); n != nil {
+		return n
+	}
+	return newJoinSortingMergeNode(ctx, parent, child0, child1, constraint)
+}
+
+// Attrs implements Table.
+func (t *joinIndexLookupNode) Attrs(ctx context.Context) TableAttrs { return t.attrs }
+
+// Hash implements Table.
+func (t *joinIndexLookupNode) Hash() hash.Hash {
+	h := hash.Hash{
+		0x5e, 0x0b, 0x91, 0x7a, 0xc4, 0x2d, 0x63, 0xf8,
+		0x19, 0xa7, 0x3e, 0xd2, 0x86, 0x4f, 0xb0, 0x15,
+		0xe9, 0x72, 0x0c, 0x5b, 0xa3, 0xd8, 0x41, 0x9f,
+		0x27, 0xc6, 0x8e, 0x34, 0xfa, 0x50, 0x6d, 0xb1}
+	h = h.Merge(t.parent.hash)
+	h = h.Merge(t.child[0].Hash())
+	h = h.Merge(t.child[1].Hash())
+	h = h.Merge(t.constraint.filterExpr.Hash())
+	return h
+}
+
+// Len implements Table.
+func (t *joinIndexLookupNode) Len(ctx context.Context, mode CountMode) int {
+	if mode == Exact {
+		return DefaultTableLen(ctx, t)
+	}
+	return t.child[1-t.indexed].Len(ctx, mode)
+}
+
+// Marshal implements Table. The node is shipped as a materialized btsv table.
+func (t *joinIndexLookupNode) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	MarshalTableOutline(ctx, enc, t)
+}
+
+// Prefetch implements Table.
+func (t *joinIndexLookupNode) Prefetch(ctx context.Context) {}
+
+// Parallelizable implements ParallelizableTable.
+func (t *joinIndexLookupNode) Parallelizable(ctx context.Context) bool { return false }
+
+// isSorted implements joinNode.
+func (t *joinIndexLookupNode) isSorted(c joinColumn) bool {
+	// The rows are yielded in the probe order.
+	return t.child[1-t.indexed].isSorted(c)
+}
+
+// subTables implements joinNode.
+func (t *joinIndexLookupNode) subTables() *joinSubTableList {
+	subTables := &joinSubTableList{}
+	for _, child := range t.child {
+		subTables.merge(child.subTables())
+	}
+	return subTables
+}
+
+// Scanner implements Table.
+func (t *joinIndexLookupNode) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	if start > 0 {
+		return &NullTableScanner{}
+	}
+	return &joinIndexLookupScanner{
+		ctx:       ctx,
+		node:      t,
+		subTables: t.subTables(),
+		probe:     t.child[1-t.indexed].Scanner(ctx, 0, 1, 1),
+		label:     t.Attrs(ctx).Name,
+	}
+}
+
+// JoinIndexLookupScanner implements TableScanner for joinIndexLookupNode.
+type joinIndexLookupScanner struct {
+	ctx       context.Context
+	node      *joinIndexLookupNode
+	label     string // For debugging only
+	subTables *joinSubTableList
+	probe     TableScanner
+	// For enumerating the rows that match the current probe row.
+	rowCP *joinCartesianProduct
+}
+
+// Scan implements TableScanner.
+func (t *joinIndexLookupScanner) Scan() bool {
+	n := t.node
+	indexedName := n.constraint.tables[n.indexed].table.name
+	for {
+		if t.rowCP != nil && t.rowCP.scan() {
+			return true
+		}
+		if !t.probe.Scan() {
+			return false
+		}
+		probeRow := t.probe.Value()
+		key := n.constraint.tables[1-n.indexed].keyExpr.Eval(t.ctx, probeRow)
+		if key.Null() != NotNull {
+			// Build_index doesn't index NA keys.
+			continue
+		}
+		rows := lookupBTSVKeyIndex(t.ctx, n.parent.ast, n.btsv, n.idx, key)
+		if len(rows) == 0 {
+			continue
+		}
+		var values [2][]Value
+		values[1-n.indexed] = []Value{probeRow}
+		for _, row := range rows {
+			values[n.indexed] = append(values[n.indexed], NewStruct(NewSimpleStruct(StructField{Name: indexedName, Value: row})))
+		}
+		// The filter removes the rows whose keys have the same hash as, but
+		// differ from, the probe key.
+		t.rowCP = newJoinCartesianProduct(t.ctx, n.parent, t.subTables, n.constraint.filterExpr, values, t.label)
+	}
+}
+
+// Value implements TableScanner.
+func (t *joinIndexLookupScanner) Value() Value {
+	return t.rowCP.value()
+}
+
 // joinTable is a Table implementation for join().
 type joinTable struct {
 	hash      hash.Hash
@@ -702,6 +905,10 @@ type joinTable struct {
 	subTables *joinSubTableList
 	joinExpr  *Func
 	mapExpr   *Func // maybe null.
+	// suffixes maps a table name to the suffix appended to its column names in
+	// the output, as set by suffixes:=. If nil, the column names are prefixed by
+	// the table name instead. Used only when mapExpr is nil.
+	suffixes  map[symbol.ID]string
 	root      joinNode // tree of joinNodes.
 	approxLen int
 
@@ -811,13 +1018,15 @@ func (t *joinTableScanner) Scan() bool {
 						nFields := sv.Len()
 						for i := 0; i < nFields; i++ {
 							v := sv.Field(i)
-							colName := symbol.Intern(t.parent.subTables.getByIndex(ti).name.Str() + "_" + v.Name.Str())
-							rowVals = append(rowVals, StructField{Name: colName, Value: v.Value})
+							rowVals = append(rowVals, StructField{Name: t.parent.outputColumn(ti, v.Name), Value: v.Value})
 						}
 					default:
 						rowVals = append(rowVals, StructField{Name: t.parent.subTables.getByIndex(ti).name, Value: val})
 					}
 				}
+				if t.parent.suffixes != nil {
+					t.parent.checkDuplicateColumns(rowVals)
+				}
 				t.value = NewStruct(NewSimpleStruct(rowVals...))
 			}
 		}
@@ -825,6 +1034,34 @@ func (t *joinTableScanner) Scan() bool {
 	}
 }
 
+// outputColumn computes the name of column col of the ti'th table in the
+// output of a join without map:=. The column is renamed as "table_col", or
+// "col<suffix>" if suffixes:= is set.
+func (t *joinTable) outputColumn(ti int, col symbol.ID) symbol.ID {
+	tableName := t.subTables.getByIndex(ti).name
+	if t.suffixes == nil {
+		return symbol.Intern(tableName.Str() + "_" + col.Str())
+	}
+	suffix := t.suffixes[tableName]
+	if suffix == "" {
+		return col
+	}
+	return symbol.Intern(col.Str() + suffix)
+}
+
+// checkDuplicateColumns panics if two columns of the output row have the same
+// name, which may happen when suffixes:= is set.
+func (t *joinTable) checkDuplicateColumns(row []StructField) {
+	for i := range row {
+		for j := i + 1; j < len(row); j++ {
+			if row[i].Name == row[j].Name {
+				Panicf(t.ast, "join: two tables produce the output column '%s'; set distinct suffixes:= for them, or name the output columns using map:=",
+					row[i].Name.Str())
+			}
+		}
+	}
+}
+
 // Scanner implements Table.
 func (t *joinTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
 	t.init(ctx)
@@ -1051,7 +1288,7 @@ DoneConstraint:
 				if nodeSubtables.getByIndex(c.tables[0].table.index) != nil &&
 					nodes[c.tables[1].table.index] != nil {
 					// c.tables[0] appears in the node, and c.tables[1] is a new table.
-					node = newJoinSortingMergeNode(ctx, t, node, nodes[c.tables[1].table.index], c)
+					node = newJoinMergeNode(ctx, t, node, nodes[c.tables[1].table.index], c)
 					nodes[c.tables[1].table.index] = nil
 					removeConstraint(ci)
 					continue DoneConstraint
@@ -1059,7 +1296,7 @@ DoneConstraint:
 				if nodeSubtables.getByIndex(c.tables[1].table.index) != nil &&
 					nodes[c.tables[0].table.index] != nil {
 					// c.tables[1] appears in the node, and c.tables[0] is a new table.
-					node = newJoinSortingMergeNode(ctx, t, nodes[c.tables[0].table.index], node, c)
+					node = newJoinMergeNode(ctx, t, nodes[c.tables[0].table.index], node, c)
 					nodes[c.tables[0].table.index] = nil
 					removeConstraint(ci)
 					continue DoneConstraint
@@ -1089,12 +1326,12 @@ DoneConstraint:
 			child[i], nodes[st.index] = nodes[st.index], nil
 		}
 		if node == nil { // First constraint
-			node = newJoinSortingMergeNode(ctx, t, child[0], child[1], c)
+			node = newJoinMergeNode(ctx, t, child[0], child[1], c)
 			continue DoneConstraint
 		}
 		// Unusual case: a join expression looks like A.x==B.y && C.z==D.w We just
 		// do bruteforce merging.
-		node = newJoinCrossMergeNode(ctx, t, node, newJoinSortingMergeNode(ctx, t, child[0], child[1], c))
+		node = newJoinCrossMergeNode(ctx, t, node, newJoinMergeNode(ctx, t, child[0], child[1], c))
 	}
 
 	// Add the remaining tables and do a brute-force crossjoin.
@@ -1129,6 +1366,28 @@ func builtinJoin(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 
 	// TODO(saito) Enable caching
 	t.hash = hashJoinCall(tables, joinExpr, mapExpr)
+	if suffixes := args[3].Value; suffixes.Type() == StructType {
+		if mapExpr != nil {
+			Panicf(ast, "join: suffixes and map cannot be set together")
+		}
+		t.suffixes = map[symbol.ID]string{}
+		st := suffixes.Struct(ast)
+		for fi := 0; fi < st.Len(); fi++ {
+			f := st.Field(fi)
+			found := false
+			for _, name := range tableNames {
+				found = found || name == f.Name
+			}
+			if !found {
+				Panicf(ast, "join: suffixes: table '%s' not found in the 1st arg", f.Name.Str())
+			}
+			if f.Value.Type() != StringType {
+				Panicf(ast, "join: suffix for table '%s' must be a string, but found %v", f.Name.Str(), f.Value)
+			}
+			t.suffixes[f.Name] = f.Value.Str(ast)
+			t.hash = t.hash.Merge(f.Name.Hash()).Merge(hash.String("suffix:" + f.Value.Str(ast)))
+		}
+	}
 	t.subTables = tables
 	t.joinExpr = joinExpr
 	t.mapExpr = mapExpr
@@ -1156,16 +1415,26 @@ func hashJoinCall(tables *joinSubTableList, joinExpr, mapExpr *Func) hash.Hash {
 func init() {
 	RegisterBuiltinFunc("join",
 		`
-    join({t0:tbl0,t1:tbl1,t2:tbl2}, t0.colA==t1.colB && t1.colB == t2.colC [, map:={colx:t0.colA, coly:t2.colC}])
+    join({t0:tbl0,t1:tbl1,t2:tbl2}, t0.colA==t1.colB && t1.colB == t2.colC [, map:={colx:t0.colA, coly:t2.colC}] [, suffixes:={t0:suffix0, t1:suffix1, ...}])
 
 Arg types:
 
 - _tbl0_, _tbl1_, ..: table
+- _suffix0_, _suffix1_, ..: string
 
 Join function joins multiple tables into one. The first argument lists the table
 name and its mnemonic in a struct form. The 2nd arg is the join condition.
 The ::map:: arg specifies the format of the output rows.
 
+If ::map:: is omitted, the output row contains all the columns of the joined
+rows. By default, each column is renamed as "<mnemonic>_<column>", e.g.,
+"t0_colA". If ::suffixes:: is set, each column of table _t0_ is instead renamed
+as "<column><suffix0>", e.g., ::suffixes:={t0:"_a", t1:"_b"}:: produces
+columns "colA_a" and "colA_b". The columns of a table not listed in
+::suffixes::, or listed with suffix "", keep their names. If two tables produce
+the same output column name, join raises an error. ::suffixes:: and ::map::
+cannot be set together.
+
 Imagine the following tables:
 
 table0:
@@ -1209,6 +1478,12 @@ The join condition doesn't need to be just "=="s connected by "&&"s. It can be
 any expression, although join provides a special fast-execution path for flat,
 conjunctive "=="s, so use them as much as possible.
 
+If one table is a btsv table indexed on its join column by
+[build_index](#build_index), e.g., ::ref | build_index(key:=&colA)::, and the
+other table is small, an inner join reads only the rows of the btsv table that
+match, using the index, instead of sorting both tables. The output rows then
+follow the order of the small table.
+
 Caution: join currently is very slow on large tables. Talk to ysaito if you see
 any problem.
 
@@ -1219,5 +1494,6 @@ TODO: describe cross joins (set non-equality join conditions, such as t0.colA >=
 		func(ast ASTNode, args []AIArg) AIType { return AITableType },
 		FormalArg{Positional: true, Required: true},                                // tables
 		FormalArg{Positional: true, Required: true, JoinClosure: true},             // join expr
-		FormalArg{Name: symbol.Map, JoinClosure: true, DefaultValue: NewFunc(nil)}) // map:=expr
+		FormalArg{Name: symbol.Map, JoinClosure: true, DefaultValue: NewFunc(nil)}, // map:=expr
+		FormalArg{Name: symbol.Suffixes, DefaultValue: Null, Types: []ValueType{StructType}})
 }
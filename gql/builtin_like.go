@@ -0,0 +1,208 @@
+package gql
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/grailbio/gql/symbol"
+)
+
+// likeToken is one element of a compiled like() pattern.
+type likeToken struct {
+	// anyOne is true for '_', which matches any single character.
+	anyOne bool
+	// anyMany is true for '%', which matches any sequence of characters.
+	anyMany bool
+	// ch is the literal character to match. Set iff !anyOne && !anyMany.
+	ch rune
+}
+
+type likeMatcherKind int
+
+const (
+	likeGeneral  likeMatcherKind = iota
+	likeExact                    // "abc"
+	likePrefix                   // "abc%"
+	likeSuffix                   // "%abc"
+	likeContains                 // "%abc%"
+)
+
+// likeMatcher is a compiled like() pattern.
+type likeMatcher struct {
+	kind likeMatcherKind
+	// lit is the literal for kinds other than likeGeneral.
+	lit string
+	// tokens is the pattern for likeGeneral.
+	tokens []likeToken
+}
+
+// maxLikeMatchers is the max number of compiled patterns cached.
+const maxLikeMatchers = 1024
+
+var (
+	likeMatchersMu sync.Mutex
+	// likeMatchers caches compiled patterns, keyed by escape char + pattern.
+	likeMatchers = map[string]*likeMatcher{}
+)
+
+// compileLikePattern parses a like() pattern. If escape is nonzero, the
+// character following escape is matched literally.
+func compileLikePattern(ast ASTNode, pattern string, escape rune) *likeMatcher {
+	var tokens []likeToken
+	for i := 0; i < len(pattern); {
+		ch, n := utf8.DecodeRuneInString(pattern[i:])
+		i += n
+		switch {
+		case escape != 0 && ch == escape:
+			if i >= len(pattern) {
+				Panicf(ast, "like: pattern '%s' ends with the escape character", pattern)
+			}
+			ch, n = utf8.DecodeRuneInString(pattern[i:])
+			i += n
+			tokens = append(tokens, likeToken{ch: ch})
+		case ch == '%':
+			if len(tokens) > 0 && tokens[len(tokens)-1].anyMany {
+				continue // "%%" is the same as "%".
+			}
+			tokens = append(tokens, likeToken{anyMany: true})
+		case ch == '_':
+			tokens = append(tokens, likeToken{anyOne: true})
+		default:
+			tokens = append(tokens, likeToken{ch: ch})
+		}
+	}
+
+	// Detect the common patterns that can be matched without backtracking.
+	m := &likeMatcher{kind: likeGeneral, tokens: tokens}
+	start, limit := 0, len(tokens)
+	leading := limit > 0 && tokens[0].anyMany
+	if leading {
+		start++
+	}
+	trailing := limit > start && tokens[limit-1].anyMany
+	if trailing {
+		limit--
+	}
+	lit := strings.Builder{}
+	for _, tok := range tokens[start:limit] {
+		if tok.anyOne || tok.anyMany {
+			return m
+		}
+		lit.WriteRune(tok.ch)
+	}
+	m.lit = lit.String()
+	switch {
+	case leading && trailing:
+		m.kind = likeContains
+	case leading:
+		m.kind = likeSuffix
+	case trailing:
+		m.kind = likePrefix
+	default:
+		m.kind = likeExact
+	}
+	return m
+}
+
+// getLikeMatcher returns a compiled pattern, using the cache if possible.
+func getLikeMatcher(ast ASTNode, pattern string, escape rune) *likeMatcher {
+	key := string(escape) + pattern
+	likeMatchersMu.Lock()
+	m, ok := likeMatchers[key]
+	likeMatchersMu.Unlock()
+	if ok {
+		return m
+	}
+	m = compileLikePattern(ast, pattern, escape)
+	likeMatchersMu.Lock()
+	if len(likeMatchers) >= maxLikeMatchers {
+		likeMatchers = map[string]*likeMatcher{}
+	}
+	likeMatchers[key] = m
+	likeMatchersMu.Unlock()
+	return m
+}
+
+// match checks if the whole string s matches the pattern.
+func (m *likeMatcher) match(s string) bool {
+	switch m.kind {
+	case likeExact:
+		return s == m.lit
+	case likePrefix:
+		return strings.HasPrefix(s, m.lit)
+	case likeSuffix:
+		return strings.HasSuffix(s, m.lit)
+	case likeContains:
+		return strings.Contains(s, m.lit)
+	}
+	// Wildcard matching with backtracking to the last '%'.
+	str := []rune(s)
+	tokens := m.tokens
+	ti, si := 0, 0
+	starTi, starSi := -1, 0
+	for si < len(str) {
+		switch {
+		case ti < len(tokens) && tokens[ti].anyMany:
+			starTi, starSi = ti, si
+			ti++
+		case ti < len(tokens) && (tokens[ti].anyOne || tokens[ti].ch == str[si]):
+			ti++
+			si++
+		case starTi >= 0:
+			// Let the last '%' absorb one more character.
+			starSi++
+			ti, si = starTi+1, starSi
+		default:
+			return false
+		}
+	}
+	for ti < len(tokens) && tokens[ti].anyMany {
+		ti++
+	}
+	return ti == len(tokens)
+}
+
+func init() {
+	RegisterBuiltinFunc("like",
+		`
+    like(str, pattern [, escape:=ch])
+
+Arg types:
+
+- _str_: string
+- _pattern_: string
+- _ch_: string (default: backslash)
+
+Like checks if the whole str matches a SQL-style pattern. In the pattern, '%'
+matches any sequence of characters (including an empty sequence), and '_'
+matches any single character. Other characters match themselves. To match a
+literal '%' or '_', precede it with the escape character. Setting escape:=""
+disables escaping.
+
+The pattern is compiled once, and simple patterns, such as "abc%", "%abc", and
+"%abc%", are matched without scanning the string character by character.
+
+Example:
+
+    like("P0012_cfDNA", "P00%_cfDNA") == true
+    like("P0012_cfDNA", "P00_") == false
+    like("100%", "100!%", escape:="!") == true
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			var escape rune
+			switch e := args[2].Str(); utf8.RuneCountInString(e) {
+			case 0:
+			case 1:
+				escape, _ = utf8.DecodeRuneInString(e)
+			default:
+				Panicf(ast, "like: escape must be a single character, but found '%s'", e)
+			}
+			return NewBool(getLikeMatcher(ast, args[1].Str(), escape).match(args[0].Str()))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIBoolType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{StringType, FileNameType, EnumType}},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.Escape, Types: []ValueType{StringType}, DefaultValue: NewString(`\`)})
+}
@@ -101,4 +101,64 @@ will produce the following table
 		FormalArg{Name: symbol.Filter, Closure: true, ClosureArgs: anonRowFuncArg, DefaultValue: NewFunc(nil)},                              // filter expr
 		FormalArg{Name: symbol.Shards, DefaultValue: NewInt(0)},                                                                             // shards:=NNN
 		FormalArg{Name: symbol.Row, Symbol: true, DefaultSymbol: symbol.AnonRow})
+
+	RegisterBuiltinFunc("flatmap",
+		`
+    _tbl | flatmap(expr [, filter:=filterexpr] [, shards:=nshards])
+
+Arg types:
+
+- _expr_: one-arg function that returns a table
+- _filterexpr_: one-arg boolean function (default: ::|_|true::)
+_ _nshards_: int (default: 0)
+
+Flatmap picks rows that match _filterexpr_ from _tbl_, then applies _expr_ to
+each matched row. Unlike map, _expr_ yields a table, and the rows of the table
+are spliced into the output, so each row in _tbl_ can produce any number of
+rows, including none. ::tbl | flatmap(expr):: is the same as
+::tbl | map(expr) | flatten()::.
+
+If _nshards_ > 0, _expr_ is applied using distributed execution.
+See the [distributed execution](#distributed-execution) section for more details.
+
+Example: Imagine table ⟪t0⟫ with following contents:
+
+        ║id  ║ tokens        ║
+        ├────┼───────────────┤
+        │1   │ [a, b]        │
+        │2   │ [c]           │
+
+    t0 | flatmap(|r| r.tokens | map({id: r.id, token: _}))
+
+will produce the following table
+
+        ║id  ║ token║
+        ├────┼──────┤
+        │1   │ a    │
+        │1   │ b    │
+        │2   │ c    │
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			mapExpr := args[1].Func()
+			filterExpr := args[2].Func()
+			shards := int(args[3].Int())
+			mapped := NewMapFilterTable(ctx, ast, args[0].Table(), filterExpr, []*Func{mapExpr}, shards).Table(ast)
+			return NewTable(NewFlatTable(ast, []Table{mapped}, false))
+		},
+		func(ast ASTNode, args []AIArg) AIType {
+			if exprType := args[1].Type.FuncReturnType(ast); !exprType.Is(TableType) {
+				Panicf(ast, "flatmap: expr '%s' must yield a table, but it yields %v", args[1].Expr, exprType)
+			}
+			if filter := args[2]; filter.Expr != nil {
+				if exprType := filter.Type.FuncReturnType(ast); !exprType.Is(BoolType) {
+					Panicf(ast, "filter '%s' is not bool (%v)", filter.Expr, exprType)
+				}
+			}
+			return AITableType
+		},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Positional: true, Required: true, Closure: true, ClosureArgs: anonRowFuncArg},                // map expr
+		FormalArg{Name: symbol.Filter, Closure: true, ClosureArgs: anonRowFuncArg, DefaultValue: NewFunc(nil)}, // filter expr
+		FormalArg{Name: symbol.Shards, DefaultValue: NewInt(0)},                                                // shards:=NNN
+		FormalArg{Name: symbol.Row, Symbol: true, DefaultSymbol: symbol.AnonRow})
 }
@@ -0,0 +1,227 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+// MaskMode specifies how mask() and MaskColumns replace column values.
+type MaskMode string
+
+const (
+	// MaskHash replaces a value with a salted hash of the value. Equal values
+	// produce equal hashes, so the masked column can still be used as a join
+	// or grouping key.
+	MaskHash MaskMode = "hash"
+	// MaskNull replaces a value with NA.
+	MaskNull MaskMode = "null"
+)
+
+// RowTransformer transforms the rows of the tables read from files. It is
+// registered through Opts.RowTransformers.
+type RowTransformer struct {
+	// Name identifies the transformer. It is mixed into the hash of the
+	// transformed tables, so it should change whenever Transform changes.
+	Name string
+	// PathRE selects the files to be transformed.
+	PathRE *regexp.Regexp
+	// Transform is called for every row read from a file that matches PathRE.
+	Transform func(ctx context.Context, path string, row Value) Value
+}
+
+var (
+	// rowTransformers is copied from Opts.RowTransformers.
+	rowTransformers []RowTransformer
+	// maskSalt is copied from Opts.MaskSalt.
+	maskSalt string
+)
+
+// maskValue masks one column value.
+func maskValue(v Value, how MaskMode) Value {
+	if v.Null() != NotNull {
+		return v
+	}
+	switch how {
+	case MaskNull:
+		return Null
+	case MaskHash:
+		h, ok := hashIndexKey(v)
+		if !ok {
+			h = v.Hash()
+		}
+		h = hash.String(maskSalt).Merge(h)
+		return NewString(h.String()[:16])
+	}
+	log.Panicf("mask: invalid mode '%s'", how)
+	return Value{}
+}
+
+// maskRow masks the given columns of a row.
+func maskRow(ast ASTNode, row Value, cols []symbol.ID, how MaskMode) Value {
+	if row.Type() != StructType {
+		return row
+	}
+	s := row.Struct(ast)
+	n := s.Len()
+	fields := make([]StructField, n)
+	for i := 0; i < n; i++ {
+		fields[i] = s.Field(i)
+		for _, col := range cols {
+			if fields[i].Name == col {
+				fields[i].Value = maskValue(fields[i].Value, how)
+				break
+			}
+		}
+	}
+	return NewStruct(NewSimpleStruct(fields...))
+}
+
+// MaskColumns returns a RowTransformer.Transform function that masks the
+// given columns.
+func MaskColumns(cols []string, how MaskMode) func(ctx context.Context, path string, row Value) Value {
+	if how != MaskHash && how != MaskNull {
+		log.Panicf("MaskColumns: invalid mode '%s'", how)
+	}
+	ids := make([]symbol.ID, len(cols))
+	for i, col := range cols {
+		ids[i] = symbol.Intern(col)
+	}
+	return func(ctx context.Context, path string, row Value) Value {
+		return maskRow(astUnknown, row, ids, how)
+	}
+}
+
+// applyRowTransformers wraps the table read from the given path with the
+// RowTransformers that match the path.
+func applyRowTransformers(path string, t Table) Table {
+	for _, tr := range rowTransformers {
+		if !tr.PathRE.MatchString(path) {
+			continue
+		}
+		tr := tr
+		h := hash.String("rowtransformer:" + tr.Name).Merge(t.Hash())
+		t = &rowTransformTable{
+			src:  t,
+			hash: h,
+			fn: func(ctx context.Context, row Value) Value {
+				return tr.Transform(ctx, path, row)
+			},
+		}
+	}
+	return t
+}
+
+// rowTransformTable applies a function to every row of the source table.
+type rowTransformTable struct {
+	src  Table
+	hash hash.Hash
+	fn   func(ctx context.Context, row Value) Value
+}
+
+var _ Table = &rowTransformTable{}
+
+// Attrs implements the Table interface.
+func (t *rowTransformTable) Attrs(ctx context.Context) TableAttrs { return t.src.Attrs(ctx) }
+
+// Prefetch implements the Table interface.
+func (t *rowTransformTable) Prefetch(ctx context.Context) { t.src.Prefetch(ctx) }
+
+// Len implements the Table interface.
+func (t *rowTransformTable) Len(ctx context.Context, mode CountMode) int {
+	return t.src.Len(ctx, mode)
+}
+
+// Hash implements the Table interface.
+func (t *rowTransformTable) Hash() hash.Hash { return t.hash }
+
+// Marshal implements the Table interface. The table is materialized, so that
+// the untransformed rows never leave this process.
+func (t *rowTransformTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	MarshalTableOutline(ctx, enc, t)
+}
+
+// Parallelizable implements ParallelizableTable.
+func (t *rowTransformTable) Parallelizable(ctx context.Context) bool {
+	return IsParallelizable(ctx, t.src)
+}
+
+// Scanner implements the Table interface.
+func (t *rowTransformTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	return &rowTransformTableScanner{ctx: ctx, parent: t, sc: t.src.Scanner(ctx, start, limit, total)}
+}
+
+type rowTransformTableScanner struct {
+	ctx    context.Context
+	parent *rowTransformTable
+	sc     TableScanner
+	value  Value
+}
+
+// Scan implements the TableScanner interface.
+func (sc *rowTransformTableScanner) Scan() bool {
+	if !sc.sc.Scan() {
+		return false
+	}
+	sc.value = sc.parent.fn(sc.ctx, sc.sc.Value())
+	return true
+}
+
+// Value implements the TableScanner interface.
+func (sc *rowTransformTableScanner) Value() Value { return sc.value }
+
+func init() {
+	RegisterBuiltinFunc("mask",
+		`
+    tbl | mask(cols [, how:=mode])
+
+Arg types:
+
+- _cols_: a column (&col) or a struct of columns ({&col0, &col1, ...})
+- _mode_: string, either "hash" (default) or "null"
+
+Mask replaces the values of the given columns. If mode is "hash", each value is
+replaced by a salted hash of the value. Equal values produce equal hashes, so the
+masked columns can still be used as join keys. If mode is "null", the values
+are replaced by NA.
+
+Example:
+
+    read("patients.tsv") | mask({&patient_id, &name})
+    read("patients.tsv") | mask(&dob, how:="null")
+
+Masking can also be enforced on every table read from a given set of files
+through gql.Opts.RowTransformers.
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			src := args[0].Table()
+			cols := columnListArg(ast, "mask", args[1].Func())
+			how := MaskMode(args[2].Str())
+			if how != MaskHash && how != MaskNull {
+				Panicf(ast, "mask: how must be either \"hash\" or \"null\", but found \"%s\"", how)
+			}
+			h := hash.String(fmt.Sprintf("mask:%s", how)).Merge(src.Hash())
+			for _, col := range cols {
+				h = h.Merge(col.Hash())
+			}
+			if how == MaskHash {
+				h = h.Merge(hash.String(maskSalt))
+			}
+			return NewTable(&rowTransformTable{
+				src:  src,
+				hash: h,
+				fn: func(ctx context.Context, row Value) Value {
+					return maskRow(ast, row, cols, how)
+				},
+			})
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Positional: true, Required: true, Closure: true, ClosureArgs: anonRowFuncArg},
+		FormalArg{Name: symbol.How, Types: []ValueType{StringType}, DefaultValue: NewString(string(MaskHash))})
+}
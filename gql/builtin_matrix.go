@@ -0,0 +1,463 @@
+package gql
+
+// This file implements the builtin functions on matrices. See matrix.go for
+// the matrix type.
+
+import (
+	"context"
+	"math"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+)
+
+// matrixLabel converts the value of a row- or column-name cell to a string.
+func matrixLabel(ast ASTNode, v Value) string {
+	if v.Type().LikeString() {
+		return v.Str(ast)
+	}
+	return v.String()
+}
+
+// toMatrix builds a matrix from a table in the long format. The rows and the
+// columns are ordered by their first appearance in the table.
+func toMatrix(ctx context.Context, ast ASTNode, t Table, rowCol, colCol, valCol symbol.ID) *Matrix {
+	type cell struct {
+		row, col int
+		val      float64
+	}
+	var (
+		m      = &Matrix{}
+		rowIdx = map[string]int{}
+		colIdx = map[string]int{}
+		cells  []cell
+	)
+	index := func(names *[]string, idx map[string]int, name string) int {
+		i, ok := idx[name]
+		if !ok {
+			i = len(*names)
+			idx[name] = i
+			*names = append(*names, name)
+		}
+		return i
+	}
+	lookup := func(st Struct, col symbol.ID) Value {
+		v, ok := st.Value(col)
+		if !ok {
+			Panicf(ast, "to_matrix: column '%s' not found%s", col.Str(), columnNotFoundHint(col, st))
+		}
+		return v
+	}
+	sc := t.Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		st := sc.Value().Struct(ast)
+		c := cell{
+			row: index(&m.RowNames, rowIdx, matrixLabel(ast, lookup(st, rowCol))),
+			col: index(&m.ColNames, colIdx, matrixLabel(ast, lookup(st, colCol))),
+		}
+		switch v := lookup(st, valCol); v.Type() {
+		case NullType:
+			c.val = math.NaN()
+		case IntType:
+			c.val = float64(v.Int(ast))
+		case FloatType:
+			c.val = v.Float(ast)
+		default:
+			Panicf(ast, "to_matrix: value must be a number, but found %v in %v", v, NewStruct(st))
+		}
+		cells = append(cells, c)
+	}
+	m.Data = make([]float64, len(m.RowNames)*len(m.ColNames))
+	for i := range m.Data {
+		m.Data[i] = math.NaN()
+	}
+	seen := make([]bool, len(m.Data))
+	for _, c := range cells {
+		i := c.row*len(m.ColNames) + c.col
+		if seen[i] {
+			Panicf(ast, "to_matrix: duplicate cell (%s, %s)", m.RowNames[c.row], m.ColNames[c.col])
+		}
+		seen[i] = true
+		m.Data[i] = c.val
+	}
+	return m
+}
+
+// transposeMatrix computes the transpose of m.
+func transposeMatrix(m *Matrix) *Matrix {
+	nRow, nCol := m.NRow(), m.NCol()
+	r := &Matrix{RowNames: m.ColNames, ColNames: m.RowNames, Data: make([]float64, len(m.Data))}
+	for i := 0; i < nRow; i++ {
+		for j := 0; j < nCol; j++ {
+			r.Data[j*nRow+i] = m.Data[i*nCol+j]
+		}
+	}
+	return r
+}
+
+// matmul computes the matrix product a*b.
+func matmul(ast ASTNode, a, b *Matrix) *Matrix {
+	if a.NCol() != b.NRow() {
+		Panicf(ast, "matmul: incompatible shapes %dx%d and %dx%d", a.NRow(), a.NCol(), b.NRow(), b.NCol())
+	}
+	n, k, p := a.NRow(), a.NCol(), b.NCol()
+	r := &Matrix{RowNames: a.RowNames, ColNames: b.ColNames, Data: make([]float64, n*p)}
+	for i := 0; i < n; i++ {
+		out := r.Data[i*p : (i+1)*p]
+		for l := 0; l < k; l++ {
+			x := a.Data[i*k+l]
+			row := b.Data[l*p : (l+1)*p]
+			for j := range out {
+				out[j] += x * row[j]
+			}
+		}
+	}
+	return r
+}
+
+// matrixElementwise applies op to each pair of cells of x and y. Each of x and
+// y is either a matrix or a number. If both are matrices, they must have the
+// same shape and the same row and column names.
+func matrixElementwise(ast ASTNode, name string, x, y Value, op func(a, b float64) float64) Value {
+	var (
+		shape        *Matrix
+		xData, yData []float64
+		xNum, yNum   float64
+	)
+	operand := func(v Value) ([]float64, float64) {
+		switch v.Type() {
+		case MatrixType:
+			m := v.Matrix(ast)
+			if shape == nil {
+				shape = m
+			} else if !sameMatrixShape(shape, m) {
+				Panicf(ast, "%s: matrices have different shapes or names: %dx%d and %dx%d", name, shape.NRow(), shape.NCol(), m.NRow(), m.NCol())
+			}
+			return m.Data, 0
+		case IntType:
+			return nil, float64(v.Int(ast))
+		case FloatType:
+			return nil, v.Float(ast)
+		}
+		Panicf(ast, "%s: arg must be a matrix or a number, but found %v", name, v)
+		return nil, 0
+	}
+	xData, xNum = operand(x)
+	yData, yNum = operand(y)
+	if shape == nil {
+		Panicf(ast, "%s: at least one arg must be a matrix", name)
+	}
+	r := &Matrix{RowNames: shape.RowNames, ColNames: shape.ColNames, Data: make([]float64, len(shape.Data))}
+	for i := range r.Data {
+		a, b := xNum, yNum
+		if xData != nil {
+			a = xData[i]
+		}
+		if yData != nil {
+			b = yData[i]
+		}
+		r.Data[i] = op(a, b)
+	}
+	return NewMatrix(r)
+}
+
+func sameMatrixShape(m0, m1 *Matrix) bool {
+	if m0.NRow() != m1.NRow() || m0.NCol() != m1.NCol() {
+		return false
+	}
+	for i := range m0.RowNames {
+		if m0.RowNames[i] != m1.RowNames[i] {
+			return false
+		}
+	}
+	for i := range m0.ColNames {
+		if m0.ColNames[i] != m1.ColNames[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// matrixAggregate computes op over the non-NaN values. It returns NaN if there
+// are no such values, except for "count" and "sum".
+func matrixAggregate(ast ASTNode, op string, vals []float64) float64 {
+	var (
+		n        int
+		sum, sq  float64
+		min, max = math.Inf(1), math.Inf(-1)
+	)
+	for _, v := range vals {
+		if math.IsNaN(v) {
+			continue
+		}
+		n++
+		sum += v
+		sq += v * v
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+	}
+	switch op {
+	case "count":
+		return float64(n)
+	case "sum":
+		return sum
+	}
+	if n == 0 {
+		return math.NaN()
+	}
+	switch op {
+	case "mean":
+		return sum / float64(n)
+	case "min":
+		return min
+	case "max":
+		return max
+	case "sd":
+		if n < 2 {
+			return math.NaN()
+		}
+		mean := sum / float64(n)
+		return math.Sqrt(math.Max(0, (sq-float64(n)*mean*mean)/float64(n-1)))
+	}
+	Panicf(ast, "invalid aggregation '%s'; must be one of mean, sum, min, max, sd, or count", op)
+	return 0
+}
+
+// aggregateMatrix aggregates each row of m (or each column if byCol) and
+// returns a table with columns "row" (or "col") and "value".
+func aggregateMatrix(ast ASTNode, m *Matrix, op string, byCol bool) Table {
+	h := hash.String("aggregate_matrix").Merge(m.hash).Merge(hash.String(op)).Merge(hash.Bool(byCol))
+	nameCol := symbol.Row
+	names := m.RowNames
+	if byCol {
+		m = transposeMatrix(m)
+		nameCol = symbol.Intern("col")
+		names = m.RowNames
+	}
+	// Validate op even if the matrix is empty.
+	matrixAggregate(ast, op, nil)
+	rows := make([]Value, len(names))
+	nCol := m.NCol()
+	for i, name := range names {
+		rows[i] = NewStruct(NewSimpleStruct(
+			StructField{Name: nameCol, Value: NewString(name)},
+			StructField{Name: symbol.Value, Value: matrixCellValue(matrixAggregate(ast, op, m.Data[i*nCol:(i+1)*nCol]))}))
+	}
+	return NewSimpleTable(rows, h, TableAttrs{Name: "matrix_agg"})
+}
+
+// scaleMatrix centers each column of m by its mean, then divides it by its
+// standard deviation. NaN cells are ignored when computing the statistics.
+func scaleMatrix(ast ASTNode, m *Matrix, center, scale bool) *Matrix {
+	t := transposeMatrix(m)
+	nCol := t.NCol()
+	for i := 0; i < t.NRow(); i++ {
+		col := t.Data[i*nCol : (i+1)*nCol]
+		mean, sd := 0.0, 1.0
+		if center {
+			mean = matrixAggregate(ast, "mean", col)
+		}
+		if scale {
+			if center {
+				sd = matrixAggregate(ast, "sd", col)
+			} else {
+				// Same as R's scale(): use the root-mean-square.
+				var (
+					sq float64
+					n  int
+				)
+				for _, v := range col {
+					if !math.IsNaN(v) {
+						sq += v * v
+						n++
+					}
+				}
+				sd = math.Sqrt(sq / float64(n-1))
+			}
+		}
+		for j := range col {
+			col[j] = (col[j] - mean) / sd
+		}
+	}
+	return transposeMatrix(t)
+}
+
+func init() {
+	RegisterBuiltinFunc("to_matrix",
+		`
+    tbl | to_matrix([rows:=rowcol, cols:=colcol, values:=valcol])
+
+Arg types:
+
+- _rowcol_: string (default "row")
+- _colcol_: string (default "col")
+- _valcol_: string (default "value")
+
+To_matrix creates a matrix from a table in the long format, e.g., the output of
+to_long. For each row of _tbl_, column _rowcol_ is the name of the matrix row,
+column _colcol_ is the name of the matrix column, and column _valcol_ is the
+value of the cell. The value must be a number or NA. The rows and the columns
+of the matrix are ordered by their first appearance in _tbl_. Missing cells are
+NA.
+
+A matrix stores float64 values in memory, so it is meant for modest-size
+numeric data, such as a methylation beta matrix. The following functions
+operate on matrices: madd, msub, mmul, mdiv, matmul, mtranspose, mscale,
+mrowagg, mcolagg, and matrix_table. A matrix is printed as a table; see
+matrix_table.
+
+Example:
+
+    m := read("betas.tsv") | to_long("sample") | to_matrix(rows:="sample", cols:="name")
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			rowCol := symbol.Intern(args[1].Str())
+			colCol := symbol.Intern(args[2].Str())
+			valCol := symbol.Intern(args[3].Str())
+			return NewMatrix(toMatrix(ctx, ast, args[0].Table(), rowCol, colCol, valCol))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIMatrixType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Name: symbol.Rows, DefaultValue: NewString("row"), Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.Cols, DefaultValue: NewString("col"), Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.Values, DefaultValue: NewString("value"), Types: []ValueType{StringType}})
+
+	RegisterBuiltinFunc("matrix_table",
+		`
+    m | matrix_table()
+
+Matrix_table converts matrix _m_ to a table. The table has one row per matrix
+row. Each row has column "row", which stores the row name, followed by one
+column per matrix column. NaN cells are converted to NA.
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return NewTable(args[0].Matrix().Table())
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{MatrixType}})
+
+	RegisterBuiltinFunc("mtranspose",
+		`
+    m | mtranspose()
+
+Mtranspose computes the transpose of matrix _m_.
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return NewMatrix(transposeMatrix(args[0].Matrix()))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIMatrixType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{MatrixType}})
+
+	RegisterBuiltinFunc("matmul",
+		`
+    matmul(m1, m2)
+
+Matmul computes the matrix product of _m1_ and _m2_. The number of columns of
+_m1_ must be the same as the number of rows of _m2_. The result has the row
+names of _m1_ and the column names of _m2_. A NaN cell makes the cells that
+depend on it NaN.
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return NewMatrix(matmul(ast, args[0].Matrix(), args[1].Matrix()))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIMatrixType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{MatrixType}},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{MatrixType}})
+
+	elementwiseOps := []struct {
+		name, verb string
+		op         func(a, b float64) float64
+	}{
+		{"madd", "adds", func(a, b float64) float64 { return a + b }},
+		{"msub", "subtracts", func(a, b float64) float64 { return a - b }},
+		{"mmul", "multiplies", func(a, b float64) float64 { return a * b }},
+		{"mdiv", "divides", func(a, b float64) float64 { return a / b }},
+	}
+	for _, e := range elementwiseOps {
+		e := e
+		RegisterBuiltinFunc(e.name,
+			`
+    `+e.name+`(x, y)
+
+Arg types:
+
+- _x_, _y_: matrix, int, or float
+
+`+e.name+` elementwise `+e.verb+` _x_ and _y_. At least one of them must be a
+matrix. If both are matrices, they must have the same shape, and the same row
+and column names. A number is applied to every cell; for example,
+::`+e.name+`(m, 2.0):: `+e.verb+` every cell of _m_ and 2.0.
+`,
+			func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+				return matrixElementwise(ast, e.name, args[0].Value, args[1].Value, e.op)
+			},
+			func(ast ASTNode, args []AIArg) AIType { return AIMatrixType },
+			FormalArg{Positional: true, Required: true, Types: []ValueType{MatrixType, IntType, FloatType}},
+			FormalArg{Positional: true, Required: true, Types: []ValueType{MatrixType, IntType, FloatType}})
+	}
+
+	RegisterBuiltinFunc("mscale",
+		`
+    m | mscale([center:=center, scale:=scale])
+
+Arg types:
+
+- _center_: bool (default true)
+- _scale_: bool (default true)
+
+Mscale standardizes the columns of matrix _m_, like R's scale() function. If
+_center_ is true, the column mean is subtracted from each cell. If _scale_ is
+true, each cell is then divided by the column standard deviation (or the
+root-mean-square if _center_ is false). NaN cells are ignored when computing
+the statistics, and they remain NaN.
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return NewMatrix(scaleMatrix(ast, args[0].Matrix(), args[1].Bool(), args[2].Bool()))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIMatrixType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{MatrixType}},
+		FormalArg{Name: symbol.Center, DefaultValue: True, Types: []ValueType{BoolType}},
+		FormalArg{Name: symbol.Scale, DefaultValue: True, Types: []ValueType{BoolType}})
+
+	RegisterBuiltinFunc("mrowagg",
+		`
+    m | mrowagg(op)
+
+Arg types:
+
+- _op_: string, one of "mean", "sum", "min", "max", "sd", or "count"
+
+Mrowagg aggregates each row of matrix _m_. It returns a table with columns
+"row", the row name, and "value", the aggregated value. NaN cells are ignored.
+"count" is the number of non-NaN cells. The value is NA if the row has no
+non-NaN cells, except for "sum" and "count".
+
+Example:
+
+    m | mrowagg("mean")
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return NewTable(aggregateMatrix(ast, args[0].Matrix(), args[1].Str(), false))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{MatrixType}},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{StringType}})
+
+	RegisterBuiltinFunc("mcolagg",
+		`
+    m | mcolagg(op)
+
+Arg types:
+
+- _op_: string, one of "mean", "sum", "min", "max", "sd", or "count"
+
+Mcolagg is similar to mrowagg, but it aggregates each column of matrix _m_. The
+resulting table has columns "col" and "value".
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return NewTable(aggregateMatrix(ast, args[0].Matrix(), args[1].Str(), true))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{MatrixType}},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{StringType}})
+}
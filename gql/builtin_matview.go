@@ -0,0 +1,150 @@
+package gql
+
+import (
+	"context"
+	"sync"
+	"text/scanner"
+	"time"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+// matviewCheckInterval is the minimum interval between two checks of the
+// inputs of a materialized view. Accesses within the interval reuse the result
+// of the last check.
+var matviewCheckInterval = 5 * time.Second
+
+// matviewTable implements a materialized view. It evaluates the view's
+// expression on access, and stores the result in the cache directory keyed by
+// the hash of the resulting table. The hash of a file-based table is computed
+// from the file's pathname, size and modtime (or just the pathname for files
+// matching Opts.ImmutableFilesRE), so a change to any input file produces a new
+// hash and causes the view to be recomputed.
+//
+// The hash of the view is the hash of its current contents, so it changes when
+// the view is refreshed. The caches keyed by the hash of a table derived from
+// the view are thus invalidated too.
+type matviewTable struct {
+	ast  ASTNode
+	name string
+	// expr is a zero-arg function that computes the contents of the view.
+	expr *Func
+
+	mu        sync.Mutex
+	lastCheck time.Time
+	cur       *btsvTable
+}
+
+var _ Table = &matviewTable{}
+
+// refresh re-evaluates the view's expression if the last check is older than
+// matviewCheckInterval, and materializes the result if its hash has changed.
+func (t *matviewTable) refresh(ctx context.Context) *btsvTable {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cur != nil && time.Since(t.lastCheck) < matviewCheckInterval {
+		return t.cur
+	}
+	src := t.expr.Eval(ctx).Table(t.ast)
+	if t.cur == nil || src.Hash() != t.cur.Hash() {
+		if t.cur != nil {
+			log.Printf("matview %s: inputs changed; refreshing", t.name)
+		}
+		t.cur = materializeTable(ctx, src, nil)
+	}
+	t.lastCheck = time.Now()
+	return t.cur
+}
+
+// Attrs implements the Table interface.
+func (t *matviewTable) Attrs(ctx context.Context) TableAttrs {
+	attrs := t.refresh(ctx).Attrs(ctx)
+	attrs.Name = "matview"
+	if t.name != "" {
+		attrs.Name += ":" + t.name
+	}
+	return attrs
+}
+
+// Prefetch implements the Table interface.
+func (t *matviewTable) Prefetch(ctx context.Context) {
+	go Recover(func() { t.refresh(ctx).Prefetch(ctx) })
+}
+
+// Scanner implements the Table interface.
+func (t *matviewTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	return t.refresh(ctx).Scanner(ctx, start, limit, total)
+}
+
+// Len implements the Table interface.
+func (t *matviewTable) Len(ctx context.Context, mode CountMode) int {
+	return t.refresh(ctx).Len(ctx, mode)
+}
+
+// Hash implements the Table interface. It is the hash of the current contents
+// of the view, so it refreshes the view if needed.
+func (t *matviewTable) Hash() hash.Hash { return t.refresh(BackgroundContext).Hash() }
+
+// Marshal implements the Table interface. The contents are already in the
+// cache directory, so the remote side just reads the btsv file.
+func (t *matviewTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	t.refresh(ctx.ctx).Marshal(ctx, enc)
+}
+
+// builtinMatviewValue is the matview builtin function. It is used by
+// NewASTMatview.
+var builtinMatviewValue Value
+
+// NewASTMatview creates the AST for statement "matview name := expr". It is
+// translated into "name := matview(expr, name:="name")".
+func NewASTMatview(pos scanner.Position, name string, expr ASTNode) ASTStatement {
+	n := NewASTBuiltinFuncall(pos, builtinMatviewValue,
+		expr,
+		&ASTLiteral{Pos: pos, Literal: NewString(name)})
+	// The view body is a complete expression. Exempt it from '&' expansion, so
+	// that "&col" inside the body binds to the innermost function call.
+	n.Raw[0].PipeSource = true
+	n.Raw[1].Name = symbol.Name
+	return NewASTStatement(pos, name, n)
+}
+
+func init() {
+	builtinMatviewValue = RegisterBuiltinFunc("matview",
+		`
+    matview(expr [, name:=name])
+    matview name := expr
+
+Matview creates a materialized view, a table whose contents are those of
+_expr_.
+
+The contents of the view are computed by evaluating _expr_, and are stored in
+the cache directory keyed by the hash of the inputs of _expr_. Every access to
+the view rechecks the inputs (at most once every few seconds). If any input file
+has changed since the last computation, _expr_ is recomputed. Otherwise the
+stored contents are reused, even across processes that share the cache
+directory.
+
+The hash of the view (e.g., as seen by force()) is the hash of its current
+contents, so it changes when the view is refreshed. The optional _name_ is
+shown in table_attrs(view).name.
+
+Statement "matview name := expr" is a shorthand for
+"name := matview(expr, name:="name")".
+
+Example:
+
+    matview passed := read("s3://bucket/samples.tsv") | filter(&qc=="pass")
+    passed | pick(&id=="S123")
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			expr := args[0].Func()
+			name := args[1].Str()
+			return NewTable(&matviewTable{ast: ast, name: name, expr: expr})
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Closure: true}, // expr
+		FormalArg{Name: symbol.Name, DefaultValue: NewString(""), Types: []ValueType{StringType}})
+}
@@ -9,22 +9,48 @@ import (
 func init() {
 	RegisterBuiltinFunc("minn",
 		`
-    tbl | minn(n, keyexpr [, shards:=nshards])
+    tbl | minn(n, keyexpr [, shards:=nshards] [, na:=order] [, ties:=ties] [, by:=byexpr])
 
 Arg types:
 
 - _n_: int
 - _keyexpr_: one-arg function
 - _nshards_: int (default: 0)
+- _order_: string, "first", "last", or "default"
+- _ties_: string, "first" or "all" (default: "first")
+- _byexpr_: one-arg function
 
 Minn picks _n_ rows that stores the _n_ smallest _keyexpr_ values. If _n_<0, minn sorts
-the entire input table.  Keys are compared lexicographically.
+the entire input table.  Keys are compared lexicographically. Rows with equal
+keys appear in the order of the input table, so ::minn(n, keyexpr):: yields the
+same rows as ::sort(keyexpr) | firstn(n)::, regardless of _nshards_.
 Note that we also have a
 ::sort(keyexpr, shards:=nshards):: function that's equivalent to ::minn(-1, keyexpr, shards:=nshards)::
 
+If _ties_ is "all", the rows whose keys equal the key of the _n_'th row are also
+picked, so the result may contain more than _n_ rows. If _ties_ is "first", the
+result contains at most _n_ rows; of the rows with equal keys, the ones that
+appear first in the input table are picked.
+
+If _byexpr_ is set, minn picks the _n_ smallest rows for each distinct value of
+_byexpr_. The result is sorted by the _byexpr_ value, then by _keyexpr_. For
+example, ::t0 | minn(1, -&col1, by:=&col0):: picks the row with the largest
+col1 value for each col0 value.
+
+Minn sorts the rows in files, so it can sort tables that don't fit in memory.
+Only when _n_ is small (less than about a million), the _n_ smallest rows of
+each shard are kept in memory instead.
+
 The _nshards_ arg enables distributed execution.
 See the [distributed execution](#distributed-execution) section for more details.
 
+By default, NA is larger than any other value, and -NA is smaller than any other
+value, so rows with NA keys come last in an ascending sort such as
+::minn(-1, &col1)::, but first in a descending sort such as ::minn(-1, -&col1)::.
+If _order_ is "first" (or "last"), NA and -NA in keys are placed before (or after)
+all the other values regardless of the sort direction. If _order_ is omitted, the
+default is set by the -na-order flag.
+
 Example: Imagine table t0:
 
         ║col0 ║ col1║ col2║
@@ -66,14 +92,41 @@ tie.
 			minn := args[1].Int()
 			keyExpr := args[2].Func()
 			shards := int(args[4].Int())
+			order := naOrderArg(ast, args[5])
+			var keepTies bool
+			switch ties := args[6].Str(); ties {
+			case "first":
+			case "all":
+				keepTies = true
+			default:
+				Panicf(ast, "minn: ties must be \"first\" or \"all\", but found \"%s\"", ties)
+			}
+			byExpr := args[7].Func()
 			return NewTable(NewMinNTable(
 				ctx, ast, TableAttrs{Name: "minn", Path: srcTable.Attrs(ctx).Path},
-				srcTable, keyExpr, minn, shards))
+				srcTable, keyExpr, order, minn, shards, keepTies, byExpr))
 		},
 		func(ast ASTNode, args []AIArg) AIType { return AITableType },
-		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},              // table
-		FormalArg{Positional: true, Required: true, Types: []ValueType{IntType}},                // n
-		FormalArg{Positional: true, Required: true, Closure: true, ClosureArgs: anonRowFuncArg}, // sortkey
-		FormalArg{Name: symbol.Row, Symbol: true, DefaultSymbol: symbol.AnonRow},                // row:=varname
-		FormalArg{Name: symbol.Shards, DefaultValue: NewInt(0)})                                 // shards:=nnn
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},                         // table
+		FormalArg{Positional: true, Required: true, Types: []ValueType{IntType}},                           // n
+		FormalArg{Positional: true, Required: true, Closure: true, ClosureArgs: anonRowFuncArg},            // sortkey
+		FormalArg{Name: symbol.Row, Symbol: true, DefaultSymbol: symbol.AnonRow},                           // row:=varname
+		FormalArg{Name: symbol.Shards, DefaultValue: NewInt(0)},                                            // shards:=nnn
+		FormalArg{Name: symbol.NA, DefaultValue: NewString("")},                                            // na:="first"|"last"
+		FormalArg{Name: symbol.Ties, DefaultValue: NewString("first")},                                     // ties:="first"|"all"
+		FormalArg{Name: symbol.By, Closure: true, ClosureArgs: anonRowFuncArg, DefaultValue: NewFunc(nil)}) // by:=expr
+}
+
+// naOrderArg parses the na:= arg of minn() and sort(). It returns the session
+// default, Opts.NAOrder, if the arg is empty.
+func naOrderArg(ast ASTNode, arg ActualArg) NAOrder {
+	s := arg.Str()
+	if s == "" {
+		return naOrder
+	}
+	order, err := ParseNAOrder(s)
+	if err != nil {
+		Panicf(ast, "na: %v", err)
+	}
+	return order
 }
@@ -0,0 +1,314 @@
+package gql
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/symbol"
+)
+
+// maxNotifyAttachmentRows is the max number of rows attached to a notification.
+const maxNotifyAttachmentRows = 1000
+
+// Notification is a message sent by the notify() builtin.
+type Notification struct {
+	// Target is the destination of the message, e.g., a Slack channel or a
+	// comma-separated list of email addresses. If empty, the notifier should
+	// use its default destination.
+	Target string
+	// Message is the text of the message.
+	Message string
+	// AttachmentName is the filename of the attachment, e.g., "attach.tsv".
+	AttachmentName string
+	// Attachment is the TSV contents of the attached table. It is nil if no table
+	// is attached.
+	Attachment []byte
+}
+
+// Notifier sends notifications through a channel, such as Slack or email.
+// Notifiers are registered in Opts.Notifiers.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// notifiers are the notification channels, keyed by name. Set by Init.
+var notifiers map[string]Notifier
+
+// SlackNotifier posts notifications to a Slack incoming webhook. The
+// attachment is included in the message as a code block.
+type SlackNotifier struct {
+	// WebhookURL is the URL of the incoming webhook. Required.
+	WebhookURL string
+	// Client sends the requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Notify implements Notifier.
+func (n *SlackNotifier) Notify(ctx context.Context, msg Notification) error {
+	text := msg.Message
+	if msg.Attachment != nil {
+		text += "\n*" + msg.AttachmentName + "*\n```" + string(msg.Attachment) + "```"
+	}
+	payload := map[string]string{"text": text}
+	if msg.Target != "" {
+		payload["channel"] = msg.Target
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, n.WebhookURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack: %s", resp.Status)
+	}
+	return nil
+}
+
+// EmailNotifier sends notifications by email. The first line of the message
+// becomes the subject. The attachment is sent as a TSV file. The connection to
+// the server is aborted when the context passed to Notify is canceled.
+type EmailNotifier struct {
+	// Addr is the SMTP server address, in form "host:port". Required.
+	Addr string
+	// Auth is used to authenticate to the server. It may be nil.
+	Auth smtp.Auth
+	// From is the sender address. Required.
+	From string
+	// DefaultTo is the comma-separated list of recipients used when
+	// Notification.Target is empty.
+	DefaultTo string
+}
+
+// Notify implements Notifier.
+func (n *EmailNotifier) Notify(ctx context.Context, msg Notification) error {
+	target := msg.Target
+	if target == "" {
+		target = n.DefaultTo
+	}
+	var to []string
+	for _, addr := range strings.Split(target, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			if strings.ContainsAny(addr, "\r\n") {
+				return fmt.Errorf("email: invalid recipient %q", addr)
+			}
+			to = append(to, addr)
+		}
+	}
+	if len(to) == 0 {
+		return fmt.Errorf("email: no recipient")
+	}
+	buf := bytes.Buffer{}
+	mw := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\n", n.From, strings.Join(to, ", "), emailSubject(msg.Message))
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write([]byte(msg.Message)); err != nil {
+		return err
+	}
+	if msg.Attachment != nil {
+		part, err = mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":        {"text/tab-separated-values"},
+			"Content-Disposition": {fmt.Sprintf("attachment; filename=%q", msg.AttachmentName)},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := part.Write(msg.Attachment); err != nil {
+			return err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	err = n.send(ctx, to, buf.Bytes())
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// emailSubject extracts the subject line from the message. Control characters
+// are replaced by spaces, so that the subject can't inject headers, and
+// non-ASCII text is encoded as specified in RFC 2047.
+func emailSubject(message string) string {
+	if i := strings.IndexByte(message, '\n'); i >= 0 {
+		message = message[:i]
+	}
+	message = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, message)
+	return mime.QEncoding.Encode("utf-8", message)
+}
+
+// send is similar to smtp.SendMail, but it closes the connection when ctx is
+// canceled.
+func (n *EmailNotifier) send(ctx context.Context, to []string, body []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", n.Addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close() // nolint: errcheck
+			return err
+		}
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close() // nolint: errcheck
+		case <-done:
+		}
+	}()
+	host, _, err := net.SplitHostPort(n.Addr)
+	if err != nil {
+		conn.Close() // nolint: errcheck
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close() // nolint: errcheck
+		return err
+	}
+	defer c.Close() // nolint: errcheck
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if n.Auth != nil {
+		if err := c.Auth(n.Auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.From); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// notifyAttachment converts the first maxNotifyAttachmentRows rows of the table
+// into TSV.
+func notifyAttachment(ctx context.Context, ast ASTNode, t Table) []byte {
+	path := newTempPath(ctx, "notify.tsv")
+	WriteTSV(ctx, path, &firstNTable{ast: ast, src: t, n: maxNotifyAttachmentRows}, true, false)
+	data, err := file.ReadFile(ctx, path)
+	if err != nil {
+		Panicf(ast, "notify: read %s: %v", path, err)
+	}
+	if err := file.Remove(ctx, path); err != nil {
+		log.Error.Printf("notify: remove %s: %v", path, err)
+	}
+	return data
+}
+
+func builtinNotify(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+	msg := Notification{
+		Message: args[0].Str(),
+		Target:  args[2].Str(),
+	}
+	channel := args[1].Str()
+	n, ok := notifiers[channel]
+	if !ok {
+		log.Printf("notify: channel '%s' is not configured; message: %s", channel, msg.Message)
+		return False
+	}
+	if t := args[3].Value; t.Type() == TableType {
+		msg.AttachmentName = "attach.tsv"
+		if name := t.Table(ast).Attrs(ctx).Name; name != "" {
+			msg.AttachmentName = name + ".tsv"
+		}
+		msg.Attachment = notifyAttachment(ctx, ast, t.Table(ast))
+	}
+	if err := n.Notify(ctx, msg); err != nil {
+		log.Error.Printf("notify %s: %v", channel, err)
+		return False
+	}
+	return True
+}
+
+func init() {
+	RegisterBuiltinFunc("notify",
+		`
+    notify(message:=msg [, channel:=ch, target:=dest, attach:=tbl])
+
+Arg types:
+
+- _msg_: string
+- _ch_: string (default "slack")
+- _dest_: string (default "")
+- _tbl_: table (default: none)
+
+Notify sends a message through a channel, such as Slack or email. It is
+typically used at the end of a long-running script to report completion or a
+QC failure. If _tbl_ is given, its first 1000 rows are attached in TSV format.
+_Dest_ is the channel-specific destination, e.g., a Slack channel name or a
+comma-separated list of email addresses. If empty, the default destination of
+the channel is used.
+
+The channels are configured by the application through gql.Opts.Notifiers. No
+channel is configured by default, in which case notify just logs the message.
+Notify returns true if the message was sent. It returns false, without
+stopping the script, if the message could not be sent.
+
+Example:
+
+    failed := read("qc.tsv") | filter(&status != "pass")
+    notify(message:=sprintf("QC done: %d failures", count(failed)), target:="#pipeline", attach:=failed)
+`,
+		builtinNotify,
+		func(ast ASTNode, args []AIArg) AIType { return AIBoolType },
+		FormalArg{Name: symbol.Message, Required: true, Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.Channel, DefaultValue: NewString("slack"), Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.Target, DefaultValue: NewString(""), Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.Attach, DefaultValue: Null, Types: []ValueType{TableType}})
+}
@@ -3,13 +3,20 @@ package gql
 import (
 	"context"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"math"
+	"math/bits"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/grailbio/base/file"
 	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/hash"
 	"github.com/grailbio/gql/symbol"
 	"github.com/grailbio/gql/termutil"
 )
@@ -61,7 +68,7 @@ func builtinInternalEqual(ctx context.Context, ast ASTNode, args []ActualArg) Va
 	case StringType, EnumType, FileNameType:
 		return NewBool(args[0].Str() == args[1].Str())
 	case FloatType:
-		return NewBool(args[0].Float() == args[1].Float())
+		return NewBool(compareFloat(args[0].Float(), args[1].Float()) == 0)
 	case CharType:
 		return NewBool(args[0].Char() == args[1].Char())
 	case DateTimeType, DateType:
@@ -70,6 +77,11 @@ func builtinInternalEqual(ctx context.Context, ast ASTNode, args []ActualArg) Va
 		return NewBool(args[0].Duration() == args[1].Duration())
 	case BoolType:
 		return NewBool(args[0].Bool() == args[1].Bool())
+	case StructType, TableType, MatrixType:
+		if args[1].Value.Type() != args[0].Value.Type() {
+			break
+		}
+		return NewBool(compareNA(ctx, ast, args[0].Value, args[1].Value, NAOrderDefault) == 0)
 	}
 	return builtinInvalidArgsError(ast, args[0].Value, args[1].Value)
 }
@@ -116,11 +128,22 @@ func builtinEQOrBothNull(ctx context.Context, ast ASTNode, args []ActualArg) Val
 	return builtinInternalEqual(ctx, ast, args)
 }
 
+// X >=? Y and X >? Y. Unlike the plain comparisons, under which NA sorts after
+// all other values, they are false if either X or Y is NA.
+func builtinCompareNotNull(ctx context.Context, ast ASTNode, args []ActualArg, orEqual bool) Value {
+	x, y := args[0].Value, args[1].Value
+	if x.Null() != NotNull || y.Null() != NotNull {
+		return False
+	}
+	c := compareNA(ctx, ast, x, y, NAOrderDefault)
+	return NewBool(c > 0 || (orEqual && c == 0))
+}
+
 // builtinMax computes the maximum of the args.
 func builtinMax(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 	v := args[0].Value
 	for _, arg := range args[1:] {
-		if compareScalar(ast, v, arg.Value) < 0 {
+		if compareScalarNA(ast, v, arg.Value, naOrder) < 0 {
 			v = arg.Value
 		}
 	}
@@ -131,13 +154,102 @@ func builtinMax(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 func builtinMin(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 	v := args[0].Value
 	for _, arg := range args[1:] {
-		if compareScalar(ast, v, arg.Value) > 0 {
+		if compareScalarNA(ast, v, arg.Value, naOrder) > 0 {
 			v = arg.Value
 		}
 	}
 	return v
 }
 
+// builtinBetween checks if lo <= x <= hi. The "inclusive" arg controls whether
+// each of the bounds is inclusive.
+func builtinBetween(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+	x, lo, hi := args[0].Value, args[1].Value, args[2].Value
+	if x.Null() != NotNull {
+		return False
+	}
+	var loInclusive, hiInclusive bool
+	switch mode := args[3].Str(); mode {
+	case "both":
+		loInclusive, hiInclusive = true, true
+	case "left":
+		loInclusive = true
+	case "right":
+		hiInclusive = true
+	case "neither":
+	default:
+		Panicf(ast, "between: inclusive must be one of \"both\", \"left\", \"right\", or \"neither\", but found \"%s\"", mode)
+	}
+	if c := compareScalar(ast, x, lo); c < 0 || (c == 0 && !loInclusive) {
+		return False
+	}
+	if c := compareScalar(ast, x, hi); c > 0 || (c == 0 && !hiInclusive) {
+		return False
+	}
+	return True
+}
+
+// builtinClamp computes min(max(x, lo), hi).
+func builtinClamp(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+	x, lo, hi := args[0].Value, args[1].Value, args[2].Value
+	if compareScalar(ast, lo, hi) > 0 {
+		Panicf(ast, "clamp: lower bound %v is larger than the upper bound %v", lo, hi)
+	}
+	if x.Null() != NotNull {
+		return x
+	}
+	if compareScalar(ast, x, lo) < 0 {
+		return lo
+	}
+	if compareScalar(ast, x, hi) > 0 {
+		return hi
+	}
+	return x
+}
+
+// parseIntString parses a string for int() and try_int(). It accepts the Go
+// syntax of integer literals, e.g., "0x1f" and "1_000_000", surrounded by
+// optional whitespace. A float, e.g., "12.7", is truncated toward zero, as in
+// TSV files.
+func parseIntString(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	v, err := strconv.ParseInt(s, 0, 64)
+	if err == nil {
+		return v, nil
+	}
+	if f, ferr := strconv.ParseFloat(s, 64); ferr == nil && f <= math.MaxInt64 && f >= math.MinInt64 {
+		return int64(math.Trunc(f)), nil
+	}
+	return 0, err
+}
+
+// parseFloatString parses a string for float(). It accepts the Go syntax of
+// float and integer literals, e.g., "1.5e3", "0x1f", and "1_000.5", surrounded
+// by optional whitespace. If percent is true, a number followed by "%" is
+// divided by 100.
+func parseFloatString(s string, percent bool) (float64, error) {
+	s = strings.TrimSpace(s)
+	hasPercent := strings.HasSuffix(s, "%")
+	if percent && hasPercent {
+		s = strings.TrimSpace(s[:len(s)-1])
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		i, ierr := strconv.ParseInt(s, 0, 64)
+		if ierr != nil {
+			if hasPercent && !percent {
+				err = fmt.Errorf("%v (use percent:=true to parse percentages)", err)
+			}
+			return 0, err
+		}
+		v = float64(i)
+	}
+	if percent && hasPercent {
+		v /= 100
+	}
+	return v, nil
+}
+
 // builtinInt converts an arg to an integer.
 func builtinInt(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 	x := args[0].Value
@@ -155,9 +267,8 @@ func builtinInt(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 		}
 		return NewInt(v)
 	case StringType, FileNameType, EnumType:
-		var v int64
-		n, err := fmt.Sscanf(args[0].Str(), "%v", &v)
-		if n != 1 || err != nil {
+		v, err := parseIntString(args[0].Str())
+		if err != nil {
 			Panicf(ast, "failed to parse '%v' as int: %v", x, err)
 		}
 		return NewInt(v)
@@ -171,6 +282,44 @@ func builtinInt(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 	return builtinInvalidArgsError(ast, x)
 }
 
+// newComputedFloat creates a float value computed by an operator. If
+// Opts.NaNAsNull is set, NaN is converted to NA.
+func newComputedFloat(v float64) Value {
+	if nanAsNull && math.IsNaN(v) {
+		return Null
+	}
+	return NewFloat(v)
+}
+
+// builtinIsNaN checks if the arg is a float NaN.
+func builtinIsNaN(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+	x := args[0].Value
+	return NewBool(x.Type() == FloatType && math.IsNaN(x.Float(ast)))
+}
+
+// builtinIsInf checks if the arg is a float +Inf or -Inf.
+func builtinIsInf(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+	x := args[0].Value
+	return NewBool(x.Type() == FloatType && math.IsInf(x.Float(ast), 0))
+}
+
+// builtinTryInt is similar to builtinInt, but it returns NA if the arg is NA or
+// a string that cannot be parsed as an integer.
+func builtinTryInt(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+	x := args[0].Value
+	switch x.Type() {
+	case NullType:
+		return Null
+	case StringType, FileNameType, EnumType:
+		v, err := parseIntString(args[0].Str())
+		if err != nil {
+			return Null
+		}
+		return NewInt(v)
+	}
+	return builtinInt(ctx, ast, args)
+}
+
 // builtinFloat converts an arg to a float64.
 func builtinFloat(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 	x := args[0].Value
@@ -188,12 +337,11 @@ func builtinFloat(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 		}
 		return NewFloat(v)
 	case StringType, EnumType, FileNameType:
-		var v float64
-		n, err := fmt.Sscanf(args[0].Str(), "%v", &v)
-		if n != 1 || err != nil {
+		v, err := parseFloatString(args[0].Str(), args[1].Bool())
+		if err != nil {
 			Panicf(ast, "failed to parse '%v' as float: %v", x, err)
 		}
-		return NewFloat(v)
+		return newComputedFloat(v)
 	case DateTimeType, DateType:
 		return NewFloat(float64(args[0].DateTime().UnixNano()) / float64(1000000000))
 	case DurationType:
@@ -229,7 +377,7 @@ func builtinPlus(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 	case IntType:
 		return NewInt(args[0].Int() + args[1].Int())
 	case FloatType:
-		return NewFloat(args[0].Float() + args[1].Float())
+		return newComputedFloat(args[0].Float() + args[1].Float())
 	case StringType, EnumType, FileNameType:
 		return NewString(args[0].Str() + args[1].Str())
 	case DurationType:
@@ -245,7 +393,7 @@ func builtinMinus(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 	case IntType:
 		return NewInt(args[0].Int() - args[1].Int())
 	case FloatType:
-		return NewFloat(args[0].Float() - args[1].Float())
+		return newComputedFloat(args[0].Float() - args[1].Float())
 	case DurationType:
 		return NewDuration(args[0].Duration() - args[1].Duration())
 	default:
@@ -259,7 +407,7 @@ func builtinMultiply(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 	case IntType:
 		return NewInt(args[0].Int() * args[1].Int())
 	case FloatType:
-		return NewFloat(args[0].Float() * args[1].Float())
+		return newComputedFloat(args[0].Float() * args[1].Float())
 	default:
 		return builtinInvalidArgsError(ast, x, y)
 	}
@@ -272,7 +420,7 @@ func builtinDivide(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 	case IntType:
 		return NewInt(args[0].Int() / args[1].Int())
 	case FloatType:
-		return NewFloat(args[0].Float() / args[1].Float())
+		return newComputedFloat(args[0].Float() / args[1].Float())
 	default:
 		return builtinInvalidArgsError(ast, x, y)
 	}
@@ -336,16 +484,56 @@ func builtinStringReplace(ctx context.Context, ast ASTNode, args []ActualArg) Va
 	return NewString(s)
 }
 
+// sprintfDuration is the representation of a duration passed to sprintf. It
+// prints as a human-readable string, e.g., "1h30m0s", for %v, %s, and %q; as the
+// number of nanoseconds for %d; and as the number of seconds for the float
+// verbs, so that "%.2f" works as expected.
+type sprintfDuration time.Duration
+
+// Format implements fmt.Formatter.
+func (d sprintfDuration) Format(f fmt.State, verb rune) {
+	directive := sprintfDirective(f, verb)
+	switch verb {
+	case 'd', 'x', 'X', 'o', 'b':
+		fmt.Fprintf(f, directive, int64(d))
+	case 'f', 'F', 'g', 'G', 'e', 'E':
+		fmt.Fprintf(f, directive, time.Duration(d).Seconds())
+	default:
+		fmt.Fprintf(f, directive, time.Duration(d).String())
+	}
+}
+
+// sprintfDirective reconstructs the formatting directive, e.g., "%-10.2f", from
+// the state passed to fmt.Formatter.Format.
+func sprintfDirective(f fmt.State, verb rune) string {
+	buf := strings.Builder{}
+	buf.WriteByte('%')
+	for _, flag := range "+-# 0" {
+		if f.Flag(int(flag)) {
+			buf.WriteRune(flag)
+		}
+	}
+	if w, ok := f.Width(); ok {
+		buf.WriteString(strconv.Itoa(w))
+	}
+	if p, ok := f.Precision(); ok {
+		buf.WriteByte('.')
+		buf.WriteString(strconv.Itoa(p))
+	}
+	buf.WriteRune(verb)
+	return buf.String()
+}
+
 func builtinSprintf(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 	fmtStr := args[0].Str()
 	l := make([]interface{}, len(args)-1)
 	for i, arg := range args[1:] {
 		val := arg.Value
 		switch arg.Value.Type() {
-		case IntType, DurationType:
-			// TODO(saito) Perhaps we should print duration as a human-readable
-			// string.
+		case IntType:
 			l[i] = arg.Int()
+		case DurationType:
+			l[i] = sprintfDuration(val.Duration(ast))
 		case BoolType:
 			l[i] = arg.Bool()
 		case FloatType:
@@ -357,12 +545,160 @@ func builtinSprintf(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 		case CharType:
 			l[i] = arg.Char()
 		default:
-			Panicf(ast, "'%v' (type %s) is not scalar", val, DescribeValue(val))
+			// Structs, tables, etc. are printed in the same way as in the
+			// REPL's compact mode.
+			out := termutil.NewBufferPrinter()
+			val.Print(ctx, PrintArgs{Out: out, Mode: PrintCompact})
+			l[i] = out.String()
 		}
 	}
 	return NewString(fmt.Sprintf(fmtStr, l...))
 }
 
+// hash64Value computes hash64(v), or hash64_seed(v, seed) if seed is nonzero.
+// The result is a positive int64 derived from the first 8 bytes of the hash of
+// v. It must not change across gql versions, since scripts use it to sample
+// rows reproducibly.
+func hash64Value(v Value, seed int64) uint64 {
+	h := v.Hash()
+	if seed != 0 {
+		h = h.Merge(hash.Int(seed))
+	}
+	return binary.LittleEndian.Uint64(h[:]) & 0x7fffffffffffffff
+}
+
+// uniformBucket maps a hash64 value to [0, n). The buckets partition the range
+// of hash64 values into n intervals of (almost) the same length.
+func uniformBucket(h uint64, n int64) int64 {
+	hi, _ := bits.Mul64(h<<1, uint64(n))
+	return int64(hi)
+}
+
+// jumpBucket maps a hash64 value to [0, n) using the jump consistent hash of
+// Lamping and Veach, "A Fast, Minimal Memory, Consistent Hash Algorithm"
+// (2014). When n grows to n+1, only 1/(n+1) of the values move, all to bucket
+// n.
+func jumpBucket(h uint64, n int64) int64 {
+	var b, j int64 = -1, 0
+	for j < n {
+		b = j
+		h = h*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((h>>33)+1)))
+	}
+	return b
+}
+
+// builtinBucket implements bucket and consistent_bucket.
+func builtinBucket(ast ASTNode, args []ActualArg, fn func(h uint64, n int64) int64) Value {
+	n := args[1].Int()
+	if n <= 0 {
+		Panicf(ast, "the number of buckets must be positive, but found %d", n)
+	}
+	return NewInt(fn(hash64Value(args[0].Value, args[2].Int()), n))
+}
+
+// sprintfVerbs lists the verbs that can print a value of the given type. It
+// reflects the conversions done by builtinSprintf.
+func sprintfVerbs(typ ValueType) string {
+	switch typ {
+	case IntType:
+		return "bcdoOqxXUv"
+	case DurationType:
+		return "bdoxXeEfFgGsqv"
+	case BoolType:
+		return "tv"
+	case FloatType:
+		return "beEfFgGxXv"
+	case CharType:
+		return "bcdoOqxXUv"
+	}
+	return "sqxXv"
+}
+
+// sprintfFormatVerbs extracts the verbs of a printf-style format string, in the
+// order in which they consume args. A '*' width or precision is reported as
+// verb '*'. It returns false if the format uses explicit arg indexes, e.g.,
+// "%[1]d", in which case the args cannot be matched statically.
+func sprintfFormatVerbs(format string) ([]rune, bool) {
+	var verbs []rune
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		for i < len(format) && strings.IndexByte("+-# 0", format[i]) >= 0 {
+			i++
+		}
+		// Width, then precision.
+		for part := 0; part < 2; part++ {
+			if part == 1 {
+				if i >= len(format) || format[i] != '.' {
+					break
+				}
+				i++
+			}
+			if i < len(format) && format[i] == '[' {
+				return nil, false
+			}
+			if i < len(format) && format[i] == '*' {
+				verbs = append(verbs, '*')
+				i++
+				continue
+			}
+			for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+				i++
+			}
+		}
+		if i >= len(format) {
+			// Go prints "%!(NOVERB)".
+			break
+		}
+		if format[i] == '[' {
+			return nil, false
+		}
+		if format[i] == '%' {
+			continue
+		}
+		verb, size := utf8.DecodeRuneInString(format[i:])
+		verbs = append(verbs, verb)
+		i += size - 1
+	}
+	return verbs, true
+}
+
+// sprintfTypeCB checks that the args of sprintf match the verbs of the format
+// string, if the format string is a literal.
+func sprintfTypeCB(ast ASTNode, args []AIArg) AIType {
+	lit := args[0].Type.Literal
+	if lit == nil || !lit.Type().LikeString() {
+		return AIStringType
+	}
+	format := lit.Str(ast)
+	verbs, ok := sprintfFormatVerbs(format)
+	if !ok {
+		return AIStringType
+	}
+	if len(verbs) != len(args)-1 {
+		Panicf(ast, "sprintf: format %q consumes %d args, but %d args are given", format, len(verbs), len(args)-1)
+	}
+	for i, verb := range verbs {
+		arg := args[i+1]
+		if arg.Type.Any || arg.Type.Type == NullType {
+			continue
+		}
+		if verb == '*' {
+			if arg.Type.Type != IntType {
+				Panicf(ast, "sprintf: arg #%d (%v) must be an int, since it is used as a width or precision in format %q", i+1, arg.Expr, format)
+			}
+			continue
+		}
+		if !strings.ContainsRune(sprintfVerbs(arg.Type.Type), verb) {
+			Panicf(ast, "sprintf: verb %%%c in format %q cannot print arg #%d (%v), which is of type %v", verb, format, i+1, arg.Expr, arg.Type.Type)
+		}
+	}
+	return AIStringType
+}
+
 var (
 	// They are used by yacc to parse infix / prefix ops.
 	builtinNotValue          Value
@@ -373,6 +709,8 @@ var (
 	builtinNEValue           Value
 	builtinGEValue           Value
 	builtinGTValue           Value
+	builtinGENotNullValue    Value
+	builtinGTNotNullValue    Value
 	builtinPlusValue         Value
 	builtinMinusValue        Value
 	builtinMultiplyValue     Value
@@ -439,17 +777,27 @@ func init() {
 		positionalArg, positionalArg)
 	builtinNEValue = RegisterBuiltinFunc("infix:!=", "TODO",
 		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
-			return NewBool(compareScalar(ast, args[0].Value, args[1].Value) != 0)
+			return NewBool(compareNA(ctx, ast, args[0].Value, args[1].Value, NAOrderDefault) != 0)
 		},
 		boolFuncType, positionalArg, positionalArg)
 	builtinGEValue = RegisterBuiltinFunc("infix:>=", "TODO",
 		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
-			return NewBool(compareScalar(ast, args[0].Value, args[1].Value) >= 0)
+			return NewBool(compareNA(ctx, ast, args[0].Value, args[1].Value, NAOrderDefault) >= 0)
 		},
 		boolFuncType, positionalArg, positionalArg)
 	builtinGTValue = RegisterBuiltinFunc("infix:>", "TODO",
 		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
-			return NewBool(compareScalar(ast, args[0].Value, args[1].Value) > 0)
+			return NewBool(compareNA(ctx, ast, args[0].Value, args[1].Value, NAOrderDefault) > 0)
+		},
+		boolFuncType, positionalArg, positionalArg)
+	builtinGENotNullValue = RegisterBuiltinFunc("infix:>=?", "TODO",
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return builtinCompareNotNull(ctx, ast, args, true)
+		},
+		boolFuncType, positionalArg, positionalArg)
+	builtinGTNotNullValue = RegisterBuiltinFunc("infix:>?", "TODO",
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return builtinCompareNotNull(ctx, ast, args, false)
 		},
 		boolFuncType, positionalArg, positionalArg)
 	RegisterBuiltinFunc("max", "TODO",
@@ -459,6 +807,50 @@ func init() {
 		builtinMin,
 		func(ast ASTNode, args []AIArg) AIType { return minMaxFuncType(ast, args) },
 		FormalArg{Positional: true, Required: true, Variadic: true, Types: scalarTypes})
+	rangeTypes := []ValueType{NullType, IntType, FloatType, DateTimeType, DateType, DurationType}
+	RegisterBuiltinFunc("between",
+		`
+    between(x, lo, hi [, inclusive:=mode])
+
+Arg types:
+
+- _x_, _lo_, _hi_: int, float, date, or duration
+- _mode_: one of "both" (default), "left", "right", or "neither"
+
+Between returns true if x is in range [lo, hi]. The inclusive arg controls
+whether the bounds are included in the range: "left" checks lo <= x < hi, "right"
+checks lo < x <= hi, and "neither" checks lo < x < hi. If x is NA, between
+returns false.
+
+Example:
+
+    read("foo.bed") | filter(between(&start, 1000, 2000, inclusive:="left"))
+    read("qc.tsv") | filter(between(&date, 2020-01-01, 2020-12-31))
+`,
+		builtinBetween, boolFuncType,
+		FormalArg{Positional: true, Required: true, Types: rangeTypes},
+		FormalArg{Positional: true, Required: true, Types: rangeTypes},
+		FormalArg{Positional: true, Required: true, Types: rangeTypes},
+		FormalArg{Name: symbol.Inclusive, Types: []ValueType{StringType}, DefaultValue: NewString("both")})
+	RegisterBuiltinFunc("clamp",
+		`
+    clamp(x, lo, hi)
+
+Arg types:
+
+- _x_, _lo_, _hi_: int, float, date, or duration
+
+Clamp returns lo if x < lo, hi if x > hi, and x otherwise. If x is NA, clamp
+returns NA.
+
+Example:
+
+    read("qc.tsv") | map({&sample, coverage: clamp(&coverage, 0.0, 100.0)})
+`,
+		builtinClamp, minMaxFuncType,
+		FormalArg{Positional: true, Required: true, Types: rangeTypes},
+		FormalArg{Positional: true, Required: true, Types: rangeTypes},
+		FormalArg{Positional: true, Required: true, Types: rangeTypes})
 	builtinPlusValue = RegisterBuiltinFunc("infix:+", "TODO", builtinPlus,
 		func(ast ASTNode, args []AIArg) AIType { return combineArgTypes(ast, args) },
 		FormalArg{Positional: true, Required: true, Types: []ValueType{IntType, FloatType, StringType, DurationType}},
@@ -488,6 +880,26 @@ isnull returns true if expr is NA (or -NA). Else it returns false.`,
 		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 			return NewBool(args[0].Value.Type() == NullType)
 		}, boolFuncType, positionalArg)
+	RegisterBuiltinFunc("is_nan",
+		`
+    is_nan(expr)
+
+is_nan returns true if expr is a float NaN. Else it returns false.
+
+NaN is produced by operations such as 0.0/0.0. Unlike NA, NaN is an ordinary
+float value: NaN==NaN is true, and NaN is larger than any other float,
+including +Inf, in comparisons, sort, min, and max. If the session is started
+with -nan-as-null, NaNs produced by arithmetic operators and float() are
+converted to NA. A "NaN" cell in a TSV file is always read as NA.`,
+		builtinIsNaN, boolFuncType, positionalArg)
+	RegisterBuiltinFunc("is_inf",
+		`
+    is_inf(expr)
+
+is_inf returns true if expr is a float +Inf or -Inf. Else it returns false.
++Inf and -Inf are printed as "+Inf" and "-Inf", and these strings are read
+back as infinities from TSV files.`,
+		builtinIsInf, boolFuncType, positionalArg)
 	RegisterBuiltinFunc("contains",
 		`
     contains(struct, field)
@@ -537,28 +949,58 @@ Istable returns true if expr is a table.`,
 Int converts any scalar expression into an integer.
 Examples:
     int("123") == 123
+    int(" 0x1f ") == 31
+    int("1_000_000") == 1000000
     int(1234.0) == 1234
     int(NA) == 0
 
 NA is translated into 0.
+A string is parsed using the syntax of integer literals in Go: it may have a
+"0x", "0o", or "0b" prefix, and the digits may be separated by underscores.
+Surrounding whitespace is ignored. A float string, e.g., "12.7", is truncated
+toward zero. Any other string causes an error. See also try_int.
 If expr is a date, int(expr) computes the number of seconds since the epoch (1970-01-01).
 If expr is a duration, int(expr) returns the number of nanoseconds.
 `,
 		builtinInt, intFuncType, positionalArg)
+	RegisterBuiltinFunc("try_int",
+		`
+    try_int(expr)
+
+Try_int is similar to int(expr), but it returns NA if expr is NA or a string
+that cannot be parsed as an integer.
+Examples:
+    try_int("123") == 123
+    try_int("n/a") == NA
+    read("samples.tsv") | filter(!isnull(try_int(&age)))
+`,
+		builtinTryInt, intFuncType, positionalArg)
 	RegisterBuiltinFunc("float",
 		`
-    float(expr)
+    float(expr [, percent:=percent])
+
+Arg types:
+
+- _expr_: any scalar
+- _percent_: bool
 
 The float function converts any scalar expression into an float.
 Examples:
     float("123") == 123.0
+    float(" 1_000.5 ") == 1000.5
+    float("12.5%", percent:=true) == 0.125
     float(1234) == 1234.0
     float(NA) == 0.0
 
 NA is translated into 0.0.
+A string is parsed using the syntax of float or integer literals in Go, e.g.,
+"1.5e3" or "0x1f". The digits may be separated by underscores, and surrounding
+whitespace is ignored. If percent is true, a number followed by "%" is divided
+by 100. Any other string causes an error.
 If expr is a date, float(expr) computes the number of seconds since the epoch (1970-01-01).
 If expr is a duration, float(expr) returns the number of seconds.
-`, builtinFloat, floatFuncType, positionalArg)
+`, builtinFloat, floatFuncType, positionalArg,
+		FormalArg{Name: symbol.Percent, Types: []ValueType{BoolType}, DefaultValue: NewBool(false)})
 	RegisterBuiltinFunc("string",
 		`
     string(expr)
@@ -584,9 +1026,10 @@ NA is translated into an empty string.
 
 	_ = RegisterBuiltinFunc("print",
 		`
-    print(expr... [,depth:=N] [,mode:="mode"])
+    print(expr... [,depth:=N] [,mode:="mode"] [,to:="path"] [,full:=bool])
 
-Print the list of expressions to stdout.  The depth parameters controls how
+Print the list of expressions to stdout, or to the file given by the "to"
+parameter.  The depth parameters controls how
 nested tables are printed.  If depth=0, nested tables are printed as
 "[omitted]".  If depth > 0, nested tables are expanded up to that level.  If the
 depth argument is omitted, print fully expands nested tables to the infinite
@@ -600,13 +1043,44 @@ The mode argument controls the print format. Valid values are the following:
   themselves.
 
 The default value of mode is "default".
+
+The "to" parameter is useful for dumping an intermediate result of a pipeline.
+The file is overwritten. For example,
+
+    read("foo.tsv") | filter($depth > 10) | print(to:="/tmp/debug.txt")
+
+When a table is printed in the default mode, a cell wider than the limit set by
+the -max-cell-width flag is truncated and ends with "…". Widths are counted in
+terminal columns, so cells with East Asian characters stay aligned. If the
+"full" parameter is true, cells are not truncated, and nested tables are always
+printed inline. For example,
+
+    read("notes.tsv") | print(full:=true)
+
+See also tee().
 `,
 		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 			nArg := len(args)
-			mode := args[nArg-1].Str()
+			mode := args[nArg-3].Str()
 			printArgs := PrintArgs{
 				Out: termutil.NewBatchPrinter(os.Stdout),
 			}
+			if args[nArg-1].Bool() {
+				printArgs.MaxCellWidth = -1
+				printArgs.MaxInlinedTableLen = math.MaxInt64
+			}
+			if path := args[nArg-2].Str(); path != "" {
+				out, err := file.Create(ctx, path)
+				if err != nil {
+					Panicf(ast, "print: create %s: %v", path, err)
+				}
+				defer func() {
+					if err := out.Close(ctx); err != nil {
+						Panicf(ast, "print: close %s: %v", path, err)
+					}
+				}()
+				printArgs.Out = termutil.NewBatchPrinter(out.Writer(ctx))
+			}
 			switch mode {
 			case "compact":
 				printArgs.Mode = PrintCompact
@@ -617,7 +1091,7 @@ The default value of mode is "default".
 			default:
 				Panicf(ast, "illegal mode `%s`", mode)
 			}
-			for _, arg := range args {
+			for _, arg := range args[:nArg-4] {
 				arg.Value.Print(ctx, printArgs)
 				printArgs.Out.WriteString("\n")
 			}
@@ -625,7 +1099,9 @@ The default value of mode is "default".
 		}, boolFuncType,
 		FormalArg{Positional: true, Required: true, Variadic: true},
 		FormalArg{Name: symbol.Depth, DefaultValue: NewInt(math.MaxInt32), Types: []ValueType{IntType}},
-		FormalArg{Name: symbol.Mode, DefaultValue: NewString("default"), Types: []ValueType{StringType}})
+		FormalArg{Name: symbol.Mode, DefaultValue: NewString("default"), Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.To, DefaultValue: NewString(""), Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.Full, DefaultValue: False, Types: []ValueType{BoolType}})
 	RegisterBuiltinFunc("regexp_replace",
 		`
     regexp_replace(str, re, replacement)
@@ -827,10 +1303,20 @@ Arg types:
 
 Example:
     sprintf("hello %s %d", "world", 10) == "hello world 10"
-
-Builds a string from the format string. It is implemented using Go's fmt.Sprintf
-The args cannot be structs or tables.`,
-		builtinSprintf, stringFuncType,
+    sprintf("%q", "world") == "\"world\""
+    sprintf("%v", {a:1, b:"x"}) == "{a:1,b:x}"
+    sprintf("%v / %.1f", 90s, 1500ms) == "1m30s / 1.5"
+
+Builds a string from the format string. It is implemented using Go's fmt.Sprintf.
+Strings can be quoted using %q. A struct, table, or other non-scalar value is
+printed in the compact form used by the REPL, so it should be formatted using %v
+or %s. A duration is printed as a human-readable string, e.g., "1h30m0s", by
+%v and %s, as the number of nanoseconds by %d, and as the number of seconds by
+%f, %g, and %e.
+
+If fmt is a string literal, the number and the types of the args are checked
+against the format when the script is analyzed, before it starts running.`,
+		builtinSprintf, sprintfTypeCB,
 		positionalArg,
 		FormalArg{Positional: true, Variadic: true, DefaultValue: Null})
 
@@ -849,12 +1335,116 @@ Example:
 Compute the hash of the arg. Arg can be of any type, including a table or a row.
 The hash is a positive int64 value.`,
 		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
-			h := args[0].Value.Hash()
-			v := binary.LittleEndian.Uint64(h[:]) & 0x7fffffffffffffff
-			return NewInt(int64(v))
+			return NewInt(int64(hash64Value(args[0].Value, 0)))
 		}, intFuncType,
 		positionalArg)
 
+	RegisterBuiltinFunc("hash64_seed",
+		`
+    hash64_seed(arg, seed)
+
+Arg types:
+
+- _arg_: any
+- _seed_: int
+
+Example:
+    hash64_seed("foohah", 12345)
+
+Compute the hash of the arg, mixed with the seed. Different seeds yield
+independent hashes of the same arg, e.g., for drawing independent samples of a
+table. The hash is a positive int64 value. hash64_seed(arg, 0) is the same as
+hash64(arg).
+
+The values of hash64, hash64_seed, bucket, and consistent_bucket depend only on
+the contents of the args. They are the same across runs, machines, and gql
+versions, so a sample or a split defined using them stays reproducible.`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return NewInt(int64(hash64Value(args[0].Value, args[1].Int())))
+		}, intFuncType,
+		positionalArg,
+		FormalArg{Positional: true, Required: true, Types: []ValueType{IntType}})
+
+	RegisterBuiltinFunc("bucket",
+		`
+    bucket(arg, n [, seed:=seed])
+
+Arg types:
+
+- _arg_: any
+- _n_: int
+- _seed_: int
+
+Example:
+    read("samples.tsv") | filter(bucket($sample_id, 100) < 5)
+    read("samples.tsv") | map({$sample_id, arm: bucket($sample_id, 2, seed:=2024)})
+
+Bucket maps the arg to an integer in [0, n), uniformly. The range of
+hash64_seed(arg, seed) is split into n intervals of equal length, and bucket
+returns the index of the interval that contains the hash. Unlike
+"hash64(arg) % n", the buckets are equally likely for any n. Seed defaults to 0.
+
+The value depends only on the contents of arg, n, and seed, and it doesn't
+change across gql versions. Changing n reassigns most args to different
+buckets. Use consistent_bucket if n may grow over time.`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return builtinBucket(ast, args, uniformBucket)
+		}, intFuncType,
+		positionalArg,
+		FormalArg{Positional: true, Required: true, Types: []ValueType{IntType}},
+		FormalArg{Name: symbol.Seed, Types: []ValueType{IntType}, DefaultValue: NewInt(0)})
+
+	RegisterBuiltinFunc("consistent_bucket",
+		`
+    consistent_bucket(arg, n [, seed:=seed])
+
+Arg types:
+
+- _arg_: any
+- _n_: int
+- _seed_: int
+
+Example:
+    read("samples.tsv") | map({$sample_id, shard: consistent_bucket($sample_id, 16)})
+
+Consistent_bucket maps the arg to an integer in [0, n), uniformly, using the
+jump consistent hash of hash64_seed(arg, seed). When n grows to n+1, only
+1/(n+1) of the args move to another bucket, and all of them move to bucket n.
+Thus, the assignment of existing args to buckets mostly survives adding a
+bucket. Seed defaults to 0.
+
+The value depends only on the contents of arg, n, and seed, and it doesn't
+change across gql versions.`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return builtinBucket(ast, args, jumpBucket)
+		}, intFuncType,
+		positionalArg,
+		FormalArg{Positional: true, Required: true, Types: []ValueType{IntType}},
+		FormalArg{Name: symbol.Seed, Types: []ValueType{IntType}, DefaultValue: NewInt(0)})
+
+	RegisterBuiltinFunc("row_hash",
+		`
+    row_hash(arg)
+
+Arg types:
+
+- _arg_: any
+
+Example:
+    row_hash({a:1, b:"x"})
+
+Compute the hash of the arg as a 64-character hex string. It is the same
+hash that gql uses internally to identify values, e.g., to cache tables.
+Values with the same content, including rows, tables, and their column names,
+have the same hash across runs. row_hash(arg) is thus suitable for
+deduplication keys and for matching rows across runs. hash64(arg) is derived
+from the same hash.`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			h := args[0].Value.Hash()
+			return NewString(hex.EncodeToString(h[:]))
+		}, stringFuncType,
+		positionalArg)
+
 	RegisterBuiltinFunc("land",
 		`
     land(x, y)
@@ -2,10 +2,43 @@ package gql
 
 import (
 	"context"
+	"strings"
 
 	"github.com/grailbio/gql/symbol"
 )
 
+// withDefaults fills the fields of row that are missing with the values in
+// defaults. The result lists the fields in the order of defaults, followed by
+// the fields that appear only in row. If strict, it panics if row has a field
+// not in defaults.
+func withDefaults(ast ASTNode, row, defaults Struct, strict bool) Value {
+	nDefault := defaults.Len()
+	fields := make([]StructField, 0, nDefault+row.Len())
+	for i := 0; i < nDefault; i++ {
+		f := defaults.Field(i)
+		if val, ok := row.Value(f.Name); ok {
+			f.Value = val
+		}
+		fields = append(fields, f)
+	}
+	var extra []string
+	for i := 0; i < row.Len(); i++ {
+		f := row.Field(i)
+		if _, ok := defaults.Value(f.Name); ok {
+			continue
+		}
+		if strict {
+			extra = append(extra, f.Name.Str())
+			continue
+		}
+		fields = append(fields, f)
+	}
+	if len(extra) > 0 {
+		Panicf(ast, "with_defaults: unexpected fields %s in %v", strings.Join(extra, ","), NewStruct(row))
+	}
+	return NewStruct(NewSimpleStruct(fields...))
+}
+
 func init() {
 	RegisterBuiltinFunc("optionalfield",
 		`Usage: optional_field(struct, field [, default:=defaultvalue])
@@ -32,4 +65,36 @@ Example:
 		FormalArg{Positional: true, Required: true, Symbol: true},
 		FormalArg{Name: symbol.Default, DefaultValue: Null},
 	)
+
+	RegisterBuiltinFunc("with_defaults",
+		`
+    with_defaults(row, defaults [, strict:=false])
+
+Arg types:
+
+- _row_, _defaults_: struct
+
+With_defaults fills the fields of _row_ that are missing with the values in
+_defaults_. It is a bulk version of optionalfield. The fields of the result are
+ordered as in _defaults_, followed by the fields that appear only in _row_, so
+it can be used to give the rows of a table a uniform layout, e.g., after
+concat()ing tables with differing columns.
+
+If strict:=true, with_defaults raises an error if _row_ has a field that is not
+in _defaults_.
+
+Example:
+
+    with_defaults({a:10}, {a:0, qc:"unknown"}) == {a:10, qc:"unknown"}
+    with_defaults({qc:"pass", b:1}, {a:0, qc:"unknown"}) == {a:0, qc:"pass", b:1}
+    concat(t0, t1) | map(with_defaults(_, {depth:0, qc:"unknown"}, strict:=true))
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return withDefaults(ast, args[0].Struct(), args[1].Struct(), args[2].Bool())
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIStructType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{StructType}},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{StructType}},
+		FormalArg{Name: symbol.Strict, DefaultValue: False, Types: []ValueType{BoolType}},
+	)
 }
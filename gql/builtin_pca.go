@@ -0,0 +1,412 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+)
+
+// maxExactPCACols is the max number of columns for which pca computes the
+// covariance matrix and its exact eigendecomposition. Wider inputs use a
+// randomized SVD.
+var maxExactPCACols = 200
+
+const (
+	// pcaOversample is the number of extra dimensions sampled by the randomized
+	// SVD.
+	pcaOversample = 10
+	// pcaPowerIterations is the number of power iterations run by the randomized
+	// SVD. It improves the accuracy when the singular values decay slowly.
+	pcaPowerIterations = 2
+)
+
+var (
+	pcaScoresSymbolID   = symbol.Intern("scores")
+	pcaLoadingsSymbolID = symbol.Intern("loadings")
+	pcaVarianceSymbolID = symbol.Intern("variance")
+	pcaPCSymbolID       = symbol.Intern("pc")
+	pcaSdevSymbolID     = symbol.Intern("sdev")
+	pcaFractionSymbolID = symbol.Intern("fraction")
+	pcaColSymbolID      = symbol.Intern("col")
+)
+
+// pcaInput reads the numeric columns of a table. The table is rescanned for
+// each pass, so only O(#rows + #columns) values are kept in memory per
+// component.
+type pcaInput struct {
+	ctx  context.Context
+	ast  ASTNode
+	t    Table
+	cols []symbol.ID
+	// colIndex maps a column name to its index in cols.
+	colIndex map[symbol.ID]int
+	// mean and sd are used to standardize the values.
+	mean, sd []float64
+	nRows    int
+}
+
+// cell extracts a numeric value. It returns false if the value is NA.
+func (in *pcaInput) cell(v Value, col symbol.ID) (float64, bool) {
+	switch v.Type() {
+	case NullType:
+		return 0, false
+	case IntType:
+		return float64(v.Int(in.ast)), true
+	case FloatType:
+		return v.Float(in.ast), true
+	}
+	Panicf(in.ast, "pca: column '%s' must be numeric, but found %v", col.Str(), v)
+	return 0, false
+}
+
+// init computes the column statistics. If in.cols is empty, it uses the int
+// and float columns of the first row.
+func (in *pcaInput) init(scale bool) {
+	var sum, sumSq []float64
+	var count []int
+	sc := in.t.Scanner(in.ctx, 0, 1, 1)
+	for sc.Scan() {
+		row := sc.Value().Struct(in.ast)
+		if in.nRows == 0 {
+			if len(in.cols) == 0 {
+				for fi := 0; fi < row.Len(); fi++ {
+					if f := row.Field(fi); f.Value.Type() == IntType || f.Value.Type() == FloatType {
+						in.cols = append(in.cols, f.Name)
+					}
+				}
+				if len(in.cols) == 0 {
+					Panicf(in.ast, "pca: no numeric column found in %v", sc.Value())
+				}
+			}
+			in.colIndex = make(map[symbol.ID]int, len(in.cols))
+			for i, col := range in.cols {
+				in.colIndex[col] = i
+			}
+			sum = make([]float64, len(in.cols))
+			sumSq = make([]float64, len(in.cols))
+			count = make([]int, len(in.cols))
+		}
+		for i, col := range in.cols {
+			v, ok := row.Value(col)
+			if !ok {
+				Panicf(in.ast, "pca: column '%s' not found%s", col.Str(), columnNotFoundHint(col, row))
+			}
+			if x, ok := in.cell(v, col); ok {
+				sum[i] += x
+				sumSq[i] += x * x
+				count[i]++
+			}
+		}
+		in.nRows++
+	}
+	if in.nRows < 2 {
+		Panicf(in.ast, "pca: at least two rows are needed, but found %d", in.nRows)
+	}
+	in.mean = make([]float64, len(in.cols))
+	in.sd = make([]float64, len(in.cols))
+	for i := range in.cols {
+		in.sd[i] = 1
+		if count[i] == 0 {
+			continue
+		}
+		in.mean[i] = sum[i] / float64(count[i])
+		if scale && count[i] > 1 {
+			v := (sumSq[i] - float64(count[i])*in.mean[i]*in.mean[i]) / float64(count[i]-1)
+			if v > 0 {
+				in.sd[i] = math.Sqrt(v)
+			}
+		}
+	}
+}
+
+// scan calls fn for each row. Arg z is the standardized values of the columns.
+// An NA is replaced by the column mean, i.e., zero. Fn must not retain z.
+func (in *pcaInput) scan(fn func(i int, row Struct, z []float64)) {
+	z := make([]float64, len(in.cols))
+	sc := in.t.Scanner(in.ctx, 0, 1, 1)
+	i := 0
+	for sc.Scan() {
+		row := sc.Value().Struct(in.ast)
+		for j, col := range in.cols {
+			v, _ := row.Value(col)
+			if x, ok := in.cell(v, col); ok {
+				z[j] = (x - in.mean[j]) / in.sd[j]
+			} else {
+				z[j] = 0
+			}
+		}
+		fn(i, row, z)
+		i++
+	}
+	if i != in.nRows {
+		Panicf(in.ast, "pca: the table changed while being scanned (%d rows, expect %d)", i, in.nRows)
+	}
+}
+
+// exactComponents computes the top k principal components from the covariance
+// matrix. It returns the loadings (p*k, row-major) and the eigenvalues.
+func (in *pcaInput) exactComponents(k int) ([]float64, []float64) {
+	p := len(in.cols)
+	cov := make([]float64, p*p)
+	in.scan(func(_ int, _ Struct, z []float64) {
+		for a := 0; a < p; a++ {
+			if z[a] == 0 {
+				continue
+			}
+			row := cov[a*p : (a+1)*p]
+			for b := range row {
+				row[b] += z[a] * z[b]
+			}
+		}
+	})
+	vals, vecs := symmetricEigen(cov, p)
+	loadings := make([]float64, p*k)
+	for a := 0; a < p; a++ {
+		copy(loadings[a*k:(a+1)*k], vecs[a*p:a*p+k])
+	}
+	return loadings, vals[:k]
+}
+
+// randomizedComponents computes the top k principal components using the
+// randomized SVD of Halko et al. Each pass over the table computes either Z*W
+// or Z'*Q, where Z is the standardized data.
+func (in *pcaInput) randomizedComponents(k int) ([]float64, []float64) {
+	n, p := in.nRows, len(in.cols)
+	l := k + pcaOversample
+	if l > p {
+		l = p
+	}
+	// project computes Z*w, where w is p*l.
+	project := func(w []float64) []float64 {
+		y := make([]float64, n*l)
+		in.scan(func(i int, _ Struct, z []float64) {
+			out := y[i*l : (i+1)*l]
+			for a, za := range z {
+				if za == 0 {
+					continue
+				}
+				for b := range out {
+					out[b] += za * w[a*l+b]
+				}
+			}
+		})
+		return y
+	}
+	// backProject computes Z'*q, where q is n*l.
+	backProject := func(q []float64) []float64 {
+		w := make([]float64, p*l)
+		in.scan(func(i int, _ Struct, z []float64) {
+			qi := q[i*l : (i+1)*l]
+			for a, za := range z {
+				if za == 0 {
+					continue
+				}
+				out := w[a*l : (a+1)*l]
+				for b := range out {
+					out[b] += za * qi[b]
+				}
+			}
+		})
+		return w
+	}
+	r := rand.New(rand.NewSource(0))
+	omega := make([]float64, p*l)
+	for i := range omega {
+		omega[i] = r.NormFloat64()
+	}
+	q := project(omega)
+	orthonormalizeColumns(q, n, l)
+	for it := 0; it < pcaPowerIterations; it++ {
+		w := backProject(q)
+		orthonormalizeColumns(w, p, l)
+		q = project(w)
+		orthonormalizeColumns(q, n, l)
+	}
+	// bt = Z'*Q is the transpose of B = Q'*Z. The right singular vectors of B
+	// approximate those of Z.
+	bt := backProject(q)
+	bbt := make([]float64, l*l)
+	for a := 0; a < p; a++ {
+		row := bt[a*l : (a+1)*l]
+		for i := 0; i < l; i++ {
+			for j := 0; j < l; j++ {
+				bbt[i*l+j] += row[i] * row[j]
+			}
+		}
+	}
+	vals, ub := symmetricEigen(bbt, l)
+	loadings := make([]float64, p*k)
+	for c := 0; c < k; c++ {
+		s := math.Sqrt(math.Max(vals[c], 0))
+		if s == 0 {
+			continue
+		}
+		for a := 0; a < p; a++ {
+			v := 0.0
+			for i := 0; i < l; i++ {
+				v += bt[a*l+i] * ub[i*l+c]
+			}
+			loadings[a*k+c] = v / s
+		}
+	}
+	return loadings, vals[:k]
+}
+
+// pca computes the principal components of the table. See the pca doc for the
+// result format.
+func pca(ctx context.Context, ast ASTNode, t Table, cols []symbol.ID, k int, scale bool) Value {
+	in := &pcaInput{ctx: ctx, ast: ast, t: t, cols: cols}
+	in.init(scale)
+	p := len(in.cols)
+	if k > p {
+		k = p
+	}
+	if k > in.nRows {
+		k = in.nRows
+	}
+	var loadings, eigenvals []float64
+	if p <= maxExactPCACols {
+		loadings, eigenvals = in.exactComponents(k)
+	} else {
+		loadings, eigenvals = in.randomizedComponents(k)
+	}
+	// Flip the sign of each component so that its largest loading is positive.
+	// This makes the result deterministic.
+	for c := 0; c < k; c++ {
+		maxAbs, sign := 0.0, 1.0
+		for a := 0; a < p; a++ {
+			if v := loadings[a*k+c]; math.Abs(v) > maxAbs {
+				maxAbs, sign = math.Abs(v), math.Copysign(1, v)
+			}
+		}
+		for a := 0; a < p; a++ {
+			loadings[a*k+c] *= sign
+		}
+	}
+
+	pcNames := make([]symbol.ID, k)
+	for c := range pcNames {
+		pcNames[c] = symbol.Intern(fmt.Sprintf("PC%d", c+1))
+	}
+	h := hash.String("pca").Merge(t.Hash()).Merge(hash.Int(int64(k))).Merge(hash.Bool(scale))
+	for _, col := range in.cols {
+		h = h.Merge(col.Hash())
+	}
+
+	// Compute the scores. The non-PCA columns of the row are copied.
+	var (
+		scores   = make([]Value, 0, in.nRows)
+		totalVar float64
+	)
+	in.scan(func(i int, row Struct, z []float64) {
+		fields := make([]StructField, 0, row.Len()-p+k)
+		for fi := 0; fi < row.Len(); fi++ {
+			f := row.Field(fi)
+			if _, ok := in.colIndex[f.Name]; !ok {
+				fields = append(fields, f)
+			}
+		}
+		for c := 0; c < k; c++ {
+			s := 0.0
+			for a, za := range z {
+				s += za * loadings[a*k+c]
+			}
+			fields = append(fields, StructField{Name: pcNames[c], Value: NewFloat(s)})
+		}
+		for _, za := range z {
+			totalVar += za * za
+		}
+		scores = append(scores, NewStruct(NewSimpleStruct(fields...)))
+	})
+
+	loadingRows := make([]Value, p)
+	for a, col := range in.cols {
+		fields := make([]StructField, 0, k+1)
+		fields = append(fields, StructField{Name: pcaColSymbolID, Value: NewString(col.Str())})
+		for c := 0; c < k; c++ {
+			fields = append(fields, StructField{Name: pcNames[c], Value: NewFloat(loadings[a*k+c])})
+		}
+		loadingRows[a] = NewStruct(NewSimpleStruct(fields...))
+	}
+
+	varianceRows := make([]Value, k)
+	for c := 0; c < k; c++ {
+		ev := math.Max(eigenvals[c], 0)
+		fraction := 0.0
+		if totalVar > 0 {
+			fraction = ev / totalVar
+		}
+		varianceRows[c] = NewStruct(NewSimpleStruct(
+			StructField{Name: pcaPCSymbolID, Value: NewString(pcNames[c].Str())},
+			StructField{Name: pcaSdevSymbolID, Value: NewFloat(math.Sqrt(ev / float64(in.nRows-1)))},
+			StructField{Name: pcaFractionSymbolID, Value: NewFloat(fraction)}))
+	}
+	return NewStruct(NewSimpleStruct(
+		StructField{Name: pcaScoresSymbolID, Value: NewTable(NewSimpleTable(scores, h.Merge(hash.String("scores")), TableAttrs{Name: "pca_scores"}))},
+		StructField{Name: pcaLoadingsSymbolID, Value: NewTable(NewSimpleTable(loadingRows, h.Merge(hash.String("loadings")), TableAttrs{Name: "pca_loadings"}))},
+		StructField{Name: pcaVarianceSymbolID, Value: NewTable(NewSimpleTable(varianceRows, h.Merge(hash.String("variance")), TableAttrs{Name: "pca_variance"}))}))
+}
+
+func init() {
+	RegisterBuiltinFunc("pca",
+		`
+    tbl | pca([col..., k:=k, scale:=scale])
+
+Arg types:
+
+- _col_: string
+- _k_: int (default 10)
+- _scale_: bool (default false)
+
+Pca computes the top _k_ principal components of the numeric columns _col_ of
+_tbl_. If no _col_ is given, all the int and float columns of the first row
+are used. The columns are centered, and if _scale_ is true, they are also
+divided by their standard deviations. NA values are replaced by the column
+mean.
+
+Pca returns a struct with three tables:
+
+- "scores": one row per row of _tbl_. It has the non-PCA columns of the row,
+  followed by the scores, "PC1", "PC2", ..., "PCk".
+
+- "loadings": one row per column _col_. It has column "col", the column name,
+  followed by the loadings "PC1", ..., "PCk".
+
+- "variance": one row per component. It has columns "pc" (the component name),
+  "sdev" (the standard deviation of the scores), and "fraction" (the fraction
+  of the total variance explained by the component).
+
+The sign of each component is chosen so that its largest loading is positive.
+
+The table is scanned a few times, and only O(#rows + #columns) values per
+component are kept in memory. If there are at most 200 columns, pca computes
+the exact eigendecomposition of the covariance matrix. Otherwise, it uses a
+randomized SVD, which is accurate for the top components.
+
+Example:
+
+    p := read("qc_metrics.tsv") | pca("gc_bias", "dup_rate", "insert_size", k:=2, scale:=true)
+    p.scores | map({$sample_id, $PC1, $PC2})
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			n := len(args)
+			k := int(args[n-2].Int())
+			if k <= 0 {
+				Panicf(ast, "pca: k must be positive, but found %d", k)
+			}
+			cols := make([]symbol.ID, 0, n-3)
+			for _, arg := range args[1 : n-2] {
+				cols = append(cols, symbol.Intern(arg.Str()))
+			}
+			return pca(ctx, ast, args[0].Table(), cols, k, args[n-1].Bool())
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIStructType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Positional: true, Variadic: true, DefaultValue: Null, Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.K, DefaultValue: NewInt(10), Types: []ValueType{IntType}},
+		FormalArg{Name: symbol.Scale, DefaultValue: False, Types: []ValueType{BoolType}})
+}
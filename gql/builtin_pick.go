@@ -6,6 +6,37 @@ import (
 	"github.com/grailbio/gql/symbol"
 )
 
+// pickProbeRows is the number of rows pick() reads in its first attempt. Each
+// following attempt reads pickProbeGrowth times more rows.
+const (
+	pickProbeRows   = 1024
+	pickProbeGrowth = 16
+)
+
+// pickRow returns the first row of table that satisfies pickExpr, or Null if
+// there is no such row. If the table can cheaply produce a prefix of its rows
+// (e.g., a distributed map), pickRow probes prefixes of growing sizes, so a
+// match near the beginning doesn't require computing the whole table.
+func pickRow(ctx context.Context, ast ASTNode, table Table, pickExpr *Func) Value {
+	for n := pickProbeRows; ; n *= pickProbeGrowth {
+		src := limitTable(ctx, table, n)
+		if src == table {
+			n = -1
+		}
+		nrows := 0
+		sc := src.Scanner(ctx, 0, 1, 1)
+		for (n < 0 || nrows < n) && sc.Scan() {
+			nrows++
+			if pickExpr.Eval(ctx, sc.Value()).Bool(ast) {
+				return sc.Value()
+			}
+		}
+		if n < 0 || nrows < n {
+			return Null
+		}
+	}
+}
+
 func init() {
 	RegisterBuiltinFunc("pick",
 		`
@@ -16,7 +47,9 @@ Arg types:
 - _expr_: one-arg boolean function
 
 Pick picks the first row in the table that satisfies _expr_.  If no such row is
-found, it returns NA.
+found, it returns NA. Reading stops at the first match. If the table is computed
+by a distributed map, pick first computes a prefix of the table, and computes
+larger prefixes only if the match is not found there.
 
 Imagine table t0:
 
@@ -29,16 +62,7 @@ Imagine table t0:
 ::t0 | pick(|row|row.col1>=20):: is the same thing.
 `,
 		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
-			table := args[0].Table()
-			pickExpr := args[1].Func()
-			scanner := table.Scanner(ctx, 0, 1, 1)
-			for scanner.Scan() {
-				expr := pickExpr.Eval(ctx, scanner.Value())
-				if expr.Bool(ast) {
-					return scanner.Value()
-				}
-			}
-			return Null
+			return pickRow(ctx, ast, args[0].Table(), args[1].Func())
 		},
 		func(ast ASTNode, args []AIArg) AIType {
 			if exprType := args[1].Type.FuncReturnType(ast); !exprType.Is(BoolType) {
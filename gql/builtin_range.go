@@ -0,0 +1,285 @@
+package gql
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+// generatorTable is a table whose ith row is computed by a function. It is
+// used by range(), repeat(), and dates().
+type generatorTable struct {
+	name string
+	hash hash.Hash
+	n    int
+	row  func(i int) Value
+}
+
+func (t *generatorTable) Len(ctx context.Context, mode CountMode) int { return t.n }
+func (t *generatorTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	MarshalTableOutline(ctx, enc, t)
+}
+func (t *generatorTable) Prefetch(ctx context.Context) {}
+func (t *generatorTable) Hash() hash.Hash              { return t.hash }
+func (t *generatorTable) Attrs(ctx context.Context) TableAttrs {
+	return TableAttrs{Name: t.name}
+}
+
+// Scanner implements the Table interface. The rows are range-sharded.
+func (t *generatorTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	return &generatorTableScanner{
+		parent: t,
+		budget: evalBudgetFromContext(ctx),
+		next:   int(int64(t.n) * int64(start) / int64(total)),
+		limit:  int(int64(t.n) * int64(limit) / int64(total)),
+	}
+}
+
+type generatorTableScanner struct {
+	parent      *generatorTable
+	budget      *evalBudget
+	next, limit int
+	row         Value
+}
+
+func (sc *generatorTableScanner) Value() Value { return sc.row }
+
+func (sc *generatorTableScanner) Scan() bool {
+	if sc.next >= sc.limit {
+		return false
+	}
+	sc.budget.addRows(1)
+	sc.row = sc.parent.row(sc.next)
+	sc.next++
+	return true
+}
+
+// newGeneratorTable creates a generatorTable. It panics if n is too large.
+func newGeneratorTable(ast ASTNode, name string, h hash.Hash, n int64, row func(i int) Value) Table {
+	if n < 0 {
+		n = 0
+	}
+	if n > math.MaxInt32 {
+		Panicf(ast, "%s: the table would have %d rows, which is too many", name, n)
+	}
+	return &generatorTable{name: name, hash: h, n: int(n), row: row}
+}
+
+// calendarStepRE matches a step of dates() in days, weeks, months, or years.
+var calendarStepRE = regexp.MustCompile(`^(\d+)(d|w|mo|y)$`)
+
+// dateStep is the step of dates(). Exactly one of the two forms is set.
+type dateStep struct {
+	// years, months, days are used for calendar steps, e.g., "1mo".
+	years, months, days int
+	// d is used for a fixed duration, e.g., "6h".
+	d time.Duration
+}
+
+// parseDateStep parses the by:= arg of dates().
+func parseDateStep(ast ASTNode, v Value) dateStep {
+	if v.Type() == DurationType {
+		return dateStep{d: v.Duration(ast)}
+	}
+	str := v.Str(ast)
+	if m := calendarStepRE.FindStringSubmatch(str); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		switch m[2] {
+		case "d":
+			return dateStep{days: n}
+		case "w":
+			return dateStep{days: 7 * n}
+		case "mo":
+			return dateStep{months: n}
+		default:
+			return dateStep{years: n}
+		}
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		Panicf(ast, "dates: invalid by:=\"%s\"; it must be of form \"Nd\", \"Nw\", \"Nmo\", \"Ny\", or a duration such as \"6h\"", str)
+	}
+	return dateStep{d: d}
+}
+
+// at computes the ith date starting from t. Monthly and yearly steps clamp the
+// day to the end of the month, so that, e.g., monthly steps from Jan 31
+// produce Feb 29 and Mar 31.
+func (s dateStep) at(t time.Time, i int) time.Time {
+	if s.d != 0 {
+		return t.Add(time.Duration(i) * s.d)
+	}
+	if s.days != 0 {
+		return t.AddDate(0, 0, i*s.days)
+	}
+	y, m, d := t.Date()
+	first := time.Date(y, m+time.Month(i*(12*s.years+s.months)), 1,
+		t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); d > last {
+		d = last
+	}
+	return first.AddDate(0, 0, d-1)
+}
+
+// dateArg extracts a date or datetime arg of dates(). A string is parsed as an
+// ISO8601 date.
+func dateArg(arg ActualArg) Value {
+	if arg.Value.Type() == StringType {
+		return ParseDateTime(arg.Str())
+	}
+	return arg.Value
+}
+
+// rangeFloatArg extracts an int or float arg of range() as a float.
+func rangeFloatArg(arg ActualArg) float64 {
+	if arg.Value.Type() == IntType {
+		return float64(arg.Int())
+	}
+	return arg.Float()
+}
+
+func init() {
+	RegisterBuiltinFunc("range",
+		`
+    range(start, stop [, step])
+
+Arg types:
+
+- _start_, _stop_, _step_: int or float (default step: 1)
+
+Range creates a table of numbers ::start::, ::start+step::, ::start+2*step::,
+..., up to but excluding _stop_. If _step_ is negative, the numbers decrease
+down to but excluding _stop_. The rows are ints if all the args are ints, and
+floats otherwise. The table is computed lazily, so it can be large.
+
+Example:
+
+    range(0, 5) == table(0, 1, 2, 3, 4)
+    range(10, 0, -3) == table(10, 7, 4, 1)
+    range(0, 3000, 1000) | map({start:_, end:_+1000}) == table({start:0, end:1000}, {start:1000, end:2000}, {start:2000, end:3000})`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			h := hash.String("range")
+			isFloat := false
+			for _, arg := range args {
+				h = h.Merge(arg.Value.Hash())
+				if arg.Value.Type() == FloatType {
+					isFloat = true
+				}
+			}
+			if isFloat {
+				start, stop, step := rangeFloatArg(args[0]), rangeFloatArg(args[1]), rangeFloatArg(args[2])
+				if step == 0 {
+					Panicf(ast, "range: step must not be zero")
+				}
+				n := int64(math.Ceil((stop - start) / step))
+				return NewTable(newGeneratorTable(ast, "range", h, n, func(i int) Value {
+					return NewFloat(start + float64(i)*step)
+				}))
+			}
+			start, stop, step := args[0].Int(), args[1].Int(), args[2].Int()
+			if step == 0 {
+				Panicf(ast, "range: step must not be zero")
+			}
+			var n int64
+			if step > 0 {
+				n = (stop - start + step - 1) / step
+			} else {
+				n = (start - stop - step - 1) / -step
+			}
+			return NewTable(newGeneratorTable(ast, "range", h, n, func(i int) Value {
+				return NewInt(start + int64(i)*step)
+			}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{IntType, FloatType}},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{IntType, FloatType}},
+		FormalArg{Positional: true, Types: []ValueType{IntType, FloatType}, DefaultValue: NewInt(1)})
+
+	RegisterBuiltinFunc("repeat",
+		`
+    repeat(value, n)
+
+Arg types:
+
+- _value_: any
+- _n_: int
+
+Repeat creates a table of _n_ rows, each of which is _value_.
+
+Example:
+
+    repeat({a:1}, 3) == table({a:1}, {a:1}, {a:1})`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			val, n := args[0].Value, args[1].Int()
+			h := hash.String("repeat").Merge(val.Hash()).Merge(hash.Int(n))
+			return NewTable(newGeneratorTable(ast, "repeat", h, n, func(int) Value { return val }))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{IntType}})
+
+	RegisterBuiltinFunc("dates",
+		`
+    dates(start, end [, by:=step])
+
+Arg types:
+
+- _start_, _end_: date, datetime, or string in ISO8601 format
+- _step_: string or duration (default: "1d")
+
+Dates creates a table of dates from _start_ to _end_, inclusive. It is handy
+for creating a date spine that other tables are joined to. The _step_ is one
+of:
+
+- "Nd", "Nw", "Nmo", "Ny": N days, weeks, months, or years. For example,
+  "1mo" steps by one calendar month.
+
+- A duration, such as "6h" or 6h.
+
+The rows are dates if _start_ is a date and the step is in days, weeks, months,
+or years. Otherwise, the rows are datetimes.
+
+Example:
+
+    dates(2020-01-30, 2020-02-02) == table(2020-01-30, 2020-01-31, 2020-02-01, 2020-02-02)
+    dates("2020-01-31", "2020-04-30", by:="1mo") == table(2020-01-31, 2020-02-29, 2020-03-31, 2020-04-30)`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			startVal, endVal := dateArg(args[0]), dateArg(args[1])
+			step := parseDateStep(ast, args[2].Value)
+			start, end := startVal.DateTime(ast), endVal.DateTime(ast)
+			if step.d <= 0 && step.years+step.months+step.days <= 0 {
+				Panicf(ast, "dates: step must be positive")
+			}
+			asDate := startVal.Type() == DateType && step.d == 0
+			var n int64
+			if step.d != 0 {
+				if !end.Before(start) {
+					n = int64(end.Sub(start)/step.d) + 1
+				}
+			} else {
+				for !step.at(start, int(n)).After(end) {
+					if n++; n > math.MaxInt32 {
+						break
+					}
+				}
+			}
+			h := hash.String("dates").Merge(startVal.Hash()).Merge(endVal.Hash()).Merge(args[2].Value.Hash())
+			return NewTable(newGeneratorTable(ast, "dates", h, n, func(i int) Value {
+				t := step.at(start, i)
+				if asDate {
+					return NewDate(t)
+				}
+				return NewDateTime(t)
+			}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{DateType, DateTimeType, StringType}},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{DateType, DateTimeType, StringType}},
+		FormalArg{Name: symbol.By, Types: []ValueType{StringType, DurationType}, DefaultValue: NewString("1d")})
+}
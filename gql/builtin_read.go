@@ -2,6 +2,7 @@ package gql
 
 import (
 	"context"
+	"time"
 
 	"github.com/grailbio/gql/hash"
 	"github.com/grailbio/gql/symbol"
@@ -17,7 +18,8 @@ func newTableFromFileWithHash(ctx context.Context, path string, ast ASTNode, fil
 			Panicf(ast, "open %s: unknown file type", path)
 		}
 	}
-	return fileHandler.Open(ctx, path, ast, hash)
+	recordInputFile(ctx, path)
+	return applyRowTransformers(path, fileHandler.Open(ctx, path, ast, hash))
 }
 
 // NewTableFromFile creates a Table object that reads from the given
@@ -32,19 +34,30 @@ func init() {
 	RegisterBuiltinFunc("read",
 		`Usage:
 
-    read(path [, type:=filetype])
+    read(path [, type:=filetype] [, version_id:=id] [, as_of:=time] [, dict:=dictpath] [, escape:=mode] [, columns:=cols] [, on_missing:=action] [, thousands:=sep] [, decimal:=sep] [, duplicate_columns:=dup] [, strict:=strict] [, delimiter:=delim])
 
 Arg types:
 
 - _path_: string
 - _filetype_: string
-
+- _id_: string
+- _time_: datetime or date
+- _dictpath_: string
+- _mode_: string
+- _cols_: string
+- _action_: string, "error" (default) or "na"
+- _sep_: string
+- _dup_: string, "error" (default), "suffix", or "keep_first"
+- _strict_: bool
+- _delim_: string
 
 Read table contents to a file. The optional argument 'type' specifies the file format.
 If the type is unspecified, the file format is auto-detected from the file extension.
 
 - Extension ".tsv" or ".bed" loads a tsv file.
 
+- Extension ".csv" loads a csv file.
+
 - Extension ".prio" loads a fragment file.
 
 - Extension ".btsv" loads a btsv file.
@@ -53,23 +66,160 @@ If the type is unspecified, the file format is auto-detected from the file exten
 
 - Extension ".pam" loads a PAM file.
 
+- Extension ".json", ".jsonl", or ".ndjson" loads a JSON file. Each JSON value
+  in the file becomes a row. If the file is a JSON array, each element becomes a
+  row. An object becomes a struct, and an array becomes a table. A number
+  becomes an int if it is integral, and a float otherwise. A null becomes NA.
 
-If the type is specified, it must be one of the following strings: "tsv", "bed",
-"btsv", "fragment", "bam", "pam". The type arg overrides file-type autodetection
+If the type is specified, it must be one of the following strings: "tsv", "csv",
+"bed", "btsv", "fragment", "bam", "pam", "json". The type arg overrides file-type autodetection
 based on path extension.
 
+The optional arguments 'version_id' and 'as_of' read an older version of an
+object in a versioned S3 bucket. 'version_id' specifies the S3 version ID.
+'as_of' reads the version that was current at the given time. The resolved
+version ID is reported by table_attrs(), and the table hash is computed from
+the version ID, so caches distinguish the object versions.
+
+The optional argument 'dict' specifies the tidy data dictionary of a TSV file.
+A data dictionary is a TSV file with columns "column_name", "type", and
+"description". The column types are taken from the dictionary instead of being
+guessed from the file contents, and the column descriptions are reported by
+print(mode:="description"). A value that does not conform to the type listed in
+the dictionary causes an error. If 'dict' is "auto", read() looks for the
+dictionary next to the file. For example, for "foo.tsv", it looks for
+"foo_data_dictionary.tsv" and "foo_dict.tsv". A file so found that is not a
+valid dictionary is ignored. See also check_dict.
+
+The optional argument 'escape' specifies how tabs, newlines, and other special
+characters in the cells of a TSV file are encoded. It must match the 'escape'
+argument given to write() when the file was created. "c" decodes C-style
+escapes such as "\t", "\n", and "\\". "none" (default) and "quote" take the
+cells verbatim, except that a cell enclosed in double quotes is unquoted as
+specified in RFC4180.
+
+The optional argument 'columns' lists the columns that every row must have, as a
+comma-separated string, e.g., "sample,depth". By default, a row that lacks one
+of them causes an error that names the file. If 'on_missing' is "na", the
+missing columns are added with NA values instead, so that one malformed file
+among many read by flatten() doesn't abort the whole pipeline. The files and
+the columns so filled are logged and listed by missing_columns().
+
+The optional arguments 'thousands' and 'decimal' specify the thousands and
+decimal separators of the numbers in a TSV file. By default, 'thousands' is ""
+(none) and 'decimal' is ".". For example, with thousands:="," a cell
+"1,234.5" is read as 1234.5, and with thousands:="." and decimal:=","
+a cell "1.234,5" is read as 1234.5. The separators are applied before the
+column types are guessed, so a column of such numbers is read as a float or
+int column instead of a string column. Cells that are not numbers in the given
+format, e.g., "a,b", are read as usual.
+
+The optional argument 'duplicate_columns' specifies how a TSV file whose header
+lists the same column name more than once is read. By default, such a file
+causes an error. "suffix" renames the second and later occurrences of a name
+"col" to "col_2", "col_3", and so on. "keep_first" drops them. The renamed or
+dropped columns are logged.
+
+The optional argument 'strict' specifies how a TSV cell that can't be converted
+to the column type without loss is read, e.g., "1.5" in a column whose type is
+int. By default, the value is truncated, and the number of such cells in each
+column is logged and reported in table_attrs(...).coercions. If 'strict' is
+true, such a cell causes an error. The -strict-tsv flag sets the default for all
+TSV files.
+
+A csv file is read in the same way as a TSV file, except that the cells are
+separated by commas. The first line is the header row, and a cell enclosed in
+double quotes may contain commas, newlines, and doubled double quotes, as
+specified in RFC4180. The options above for TSV files also apply to csv files.
+The optional argument 'delimiter' specifies the character that separates the
+cells of a TSV or csv file, e.g., ";" or "|".
+
 Example:
   read("blahblah", type:=tsv)
+  read("s3://bucket/samples.tsv", as_of:=2024-01-01T00:00:00Z)
+  read("notes.tsv", escape:="c")
+  read("run1/metrics.tsv", columns:="sample,depth", on_missing:="na")
+  read("collaborator.tsv", thousands:=".", decimal:=",")
+  read("regions.tsv", strict:=true)
+  read("export.csv", delimiter:=";", decimal:=",")
 .`, func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 			path := args[0].Str()
-			var fh FileHandler
-			if t := args[1].Str(); t != "" {
-				fh = GetFileHandlerByName(t)
+			t := builtinReadTable(ctx, ast, args)
+			if cols := args[6].Str(); cols != "" {
+				onMissing := args[7].Str()
+				if onMissing != "error" && onMissing != "na" {
+					Panicf(ast, "read %s: on_missing must be \"error\" or \"na\", but found \"%s\"", path, onMissing)
+				}
+				t = requireColumns(ast, path, t, cols, onMissing)
 			}
-			return NewTable(NewTableFromFile(ctx, path, ast, fh))
+			return NewTable(t)
 		},
 		func(ast ASTNode, args []AIArg) AIType { return AITableType },
 		FormalArg{Positional: true, Required: true, Types: []ValueType{StringType}},
 		FormalArg{Name: symbol.Type, Types: []ValueType{StringType}, DefaultValue: NewString("")},
+		FormalArg{Name: symbol.VersionID, Types: []ValueType{StringType}, DefaultValue: NewString("")},
+		FormalArg{Name: symbol.AsOf, Types: []ValueType{DateTimeType, DateType}, DefaultValue: Null},
+		FormalArg{Name: symbol.Dict, Types: []ValueType{StringType}, DefaultValue: NewString("")},
+		FormalArg{Name: symbol.Escape, Types: []ValueType{StringType}, DefaultValue: NewString("")},
+		FormalArg{Name: symbol.Columns, Types: []ValueType{StringType}, DefaultValue: NewString("")},
+		FormalArg{Name: symbol.OnMissing, Types: []ValueType{StringType}, DefaultValue: NewString("error")},
+		FormalArg{Name: symbol.Thousands, Types: []ValueType{StringType}, DefaultValue: NewString("")},
+		FormalArg{Name: symbol.Decimal, Types: []ValueType{StringType}, DefaultValue: NewString(".")},
+		FormalArg{Name: symbol.DuplicateColumns, Types: []ValueType{StringType}, DefaultValue: NewString("error")},
+		FormalArg{Name: symbol.Strict, Types: []ValueType{BoolType}, DefaultValue: NewBool(false)},
+		FormalArg{Name: symbol.Delimiter, Types: []ValueType{StringType}, DefaultValue: NewString("")},
 	)
 }
+
+// builtinReadTable opens the table read by read(), before applying the columns
+// arg.
+func builtinReadTable(ctx context.Context, ast ASTNode, args []ActualArg) Table {
+	path := args[0].Str()
+	var fh FileHandler
+	if t := args[1].Str(); t != "" {
+		fh = GetFileHandlerByName(t)
+	}
+	versionID := args[2].Str()
+	dict := args[4].Str()
+	opts := tsvReadOpts{
+		dictPath:  dict,
+		delimiter: parseTSVDelimiter(ast, args[12].Str()),
+		escape:    parseTSVEscapeMode(ast, args[5].Str()),
+		numFormat: parseTSVNumberFormat(ast, args[8].Str(), args[9].Str()),
+		strict:    args[11].Bool(),
+	}
+	switch dup := args[10].Str(); dup {
+	case "error":
+	case "suffix", "keep_first":
+		opts.duplicateColumns = dup
+	default:
+		Panicf(ast, "read %s: duplicate_columns must be \"error\", \"suffix\", or \"keep_first\", but found \"%s\"", path, dup)
+	}
+	if !opts.isDefault() {
+		dictFH := fh
+		if dictFH == nil {
+			dictFH = GetFileHandlerByPath(path)
+		}
+		if dictFH != TSVFileHandler() && dictFH != CSVFileHandler() {
+			Panicf(ast, "read %s: dict, delimiter, escape, thousands, decimal, duplicate_columns, and strict are supported only for tsv and csv files", path)
+		}
+		if versionID != "" || args[3].Value.Null() == NotNull {
+			Panicf(ast, "read %s: dict, delimiter, escape, thousands, decimal, duplicate_columns, and strict cannot be set together with version_id or as_of", path)
+		}
+		recordInputFile(ctx, path)
+		if dict != "" {
+			recordInputFile(ctx, dict)
+		}
+		return applyRowTransformers(path, newTSVTableWithOpts(path, ast, hash.Zero, dictFH, opts))
+	}
+	if asOf := args[3].Value; asOf.Null() == NotNull {
+		if versionID != "" {
+			Panicf(ast, "read %s: version_id and as_of cannot be set together", path)
+		}
+		return newVersionedS3Table(ctx, ast, path, fh, "", asOf.DateTime(ast))
+	}
+	if versionID != "" {
+		return newVersionedS3Table(ctx, ast, path, fh, versionID, time.Time{})
+	}
+	return NewTableFromFile(ctx, path, ast, fh)
+}
@@ -2,6 +2,7 @@ package gql
 
 import (
 	"context"
+	"math"
 	"sync"
 
 	"github.com/grailbio/gql/hash"
@@ -9,6 +10,194 @@ import (
 	"github.com/grailbio/gql/symbol"
 )
 
+// reduceCombiner is a builtin reduce function, selected by the combiner:= arg
+// of reduce.
+type reduceCombiner int
+
+const (
+	// combinerExpr uses the reduceexpr arg.
+	combinerExpr reduceCombiner = iota
+	combinerSum
+	combinerMin
+	combinerMax
+	combinerConcat
+	combinerKahanSum
+)
+
+var reduceCombinerNames = []string{"default", "sum", "min", "max", "concat", "kahan_sum"}
+
+func (c reduceCombiner) String() string { return reduceCombinerNames[c] }
+
+// parseReduceCombiner parses the value of the combiner:= arg.
+func parseReduceCombiner(ast ASTNode, sym symbol.ID) reduceCombiner {
+	for i, name := range reduceCombinerNames {
+		if name == sym.Str() {
+			return reduceCombiner(i)
+		}
+	}
+	Panicf(ast, "reduce: unknown combiner '%s'; must be one of %v", sym.Str(), reduceCombinerNames[1:])
+	return combinerExpr
+}
+
+var (
+	kahanSumSymbolID          = symbol.Intern("sum")
+	kahanCompensationSymbolID = symbol.Intern("compensation")
+)
+
+// reducer combines the values with the same key. The values are combined in an
+// unspecified order, possibly on different machines, so the combination must be
+// associative and commutative. The builtin combiners are. For reduceexpr, this
+// is checked at runtime if check:=true.
+type reducer struct {
+	ast      ASTNode
+	combiner reduceCombiner
+	expr     *Func // reduceexpr. Set iff combiner==combinerExpr.
+	check    bool
+}
+
+// Combine combines two values. Each arg is either a source value, or a value
+// produced by an earlier call to Combine. For kahan_sum, the latter is a
+// {sum,compensation} struct.
+func (r *reducer) Combine(ctx context.Context, acc, v Value) Value {
+	switch r.combiner {
+	case combinerExpr:
+		if r.check {
+			return r.checkedEval(ctx, acc, v)
+		}
+		return r.expr.Eval(ctx, acc, v)
+	case combinerKahanSum:
+		s0, c0 := r.kahanState(acc)
+		s1, c1 := r.kahanState(v)
+		s := s0 + s1
+		// Neumaier's variant of the Kahan summation. It tracks the low-order bits
+		// lost in the addition of either operand.
+		var c float64
+		if math.Abs(s0) >= math.Abs(s1) {
+			c = (s0 - s) + s1
+		} else {
+			c = (s1 - s) + s0
+		}
+		return NewStruct(NewSimpleStruct(
+			StructField{Name: kahanSumSymbolID, Value: NewFloat(s)},
+			StructField{Name: kahanCompensationSymbolID, Value: NewFloat(c0 + c1 + c)}))
+	}
+	// The remaining combiners ignore NAs.
+	if acc.Type() == NullType {
+		return v
+	}
+	if v.Type() == NullType {
+		return acc
+	}
+	switch r.combiner {
+	case combinerSum:
+		if acc.Type() == IntType && v.Type() == IntType {
+			return NewInt(acc.Int(r.ast) + v.Int(r.ast))
+		}
+		return NewFloat(r.number(acc) + r.number(v))
+	case combinerMin:
+		if Compare(r.ast, v, acc) < 0 {
+			return v
+		}
+		return acc
+	case combinerMax:
+		if Compare(r.ast, v, acc) > 0 {
+			return v
+		}
+		return acc
+	case combinerConcat:
+		return NewString(acc.Str(r.ast) + "," + v.Str(r.ast))
+	}
+	Panicf(r.ast, "reduce: invalid combiner %v", r.combiner)
+	return Value{}
+}
+
+// Finish converts the result of Combine to the value stored in the table.
+func (r *reducer) Finish(acc Value) Value {
+	if r.combiner != combinerKahanSum || acc.Type() == NullType {
+		return acc
+	}
+	s, c := r.kahanState(acc)
+	return NewFloat(s + c)
+}
+
+// number extracts a float from an int or a float value.
+func (r *reducer) number(v Value) float64 {
+	switch v.Type() {
+	case IntType:
+		return float64(v.Int(r.ast))
+	case FloatType:
+		return v.Float(r.ast)
+	}
+	Panicf(r.ast, "reduce: combiner %v: value %v must be a number", r.combiner, v)
+	return 0
+}
+
+// kahanState extracts the sum and the compensation from the kahan_sum
+// accumulator. A number is treated as a sum with no compensation. NA is zero.
+func (r *reducer) kahanState(v Value) (sum, comp float64) {
+	switch v.Type() {
+	case NullType:
+		return 0, 0
+	case StructType:
+		st := v.Struct(r.ast)
+		s, ok0 := st.Value(kahanSumSymbolID)
+		c, ok1 := st.Value(kahanCompensationSymbolID)
+		if !ok0 || !ok1 {
+			Panicf(r.ast, "reduce: combiner %v: value %v must be a number", r.combiner, v)
+		}
+		return s.Float(r.ast), c.Float(r.ast)
+	}
+	return r.number(v), 0
+}
+
+// checkedEval evaluates reduceexpr on the two values, and verifies that the
+// result has the same type as the args, and that reduceexpr is commutative and
+// associative for these values. Float results are compared with a small
+// tolerance, since the floating-point addition is not exactly associative.
+func (r *reducer) checkedEval(ctx context.Context, acc, v Value) Value {
+	result := r.expr.Eval(ctx, acc, v)
+	if result.Type() != acc.Type() && acc.Type() != NullType && result.Type() != NullType {
+		Panicf(r.ast, "reduce: reduceexpr(%v, %v) yields %v; its type must be the same as the args (%v)",
+			acc, v, result, acc.Type())
+	}
+	if swapped := r.expr.Eval(ctx, v, acc); !reduceResultsEqual(r.ast, result, swapped) {
+		Panicf(r.ast, "reduce: reduceexpr is not commutative: reduceexpr(%v, %v)=%v, but reduceexpr(%v, %v)=%v",
+			acc, v, result, v, acc, swapped)
+	}
+	// Check associativity for the triple (acc, v, v).
+	left := r.expr.Eval(ctx, result, v)
+	right := r.expr.Eval(ctx, acc, r.expr.Eval(ctx, v, v))
+	if !reduceResultsEqual(r.ast, left, right) {
+		Panicf(r.ast, "reduce: reduceexpr is not associative: reduceexpr(reduceexpr(%v, %v), %v)=%v, but reduceexpr(%v, reduceexpr(%v, %v))=%v",
+			acc, v, v, left, acc, v, v, right)
+	}
+	return result
+}
+
+// reduceResultsEqual checks if the two values are equal. Floats are compared
+// with a relative tolerance of 1e-9.
+func reduceResultsEqual(ast ASTNode, v0, v1 Value) bool {
+	if v0.Type() == FloatType && v1.Type() == FloatType {
+		f0, f1 := v0.Float(ast), v1.Float(ast)
+		return f0 == f1 || math.Abs(f0-f1) <= 1e-9*math.Max(math.Abs(f0), math.Abs(f1))
+	}
+	return Compare(ast, v0, v1) == 0
+}
+
+func (r *reducer) marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	enc.PutVarint(int64(r.combiner))
+	enc.PutBool(r.check)
+	r.expr.Marshal(ctx, enc)
+}
+
+func unmarshalReducer(ctx UnmarshalContext, ast ASTNode, dec *marshal.Decoder) *reducer {
+	r := &reducer{ast: ast}
+	r.combiner = reduceCombiner(dec.Varint())
+	r.check = dec.Bool()
+	r.expr = unmarshalFunc(ctx, dec)
+	return r
+}
+
 func (t *reduceTable) Len(ctx context.Context, mode CountMode) int {
 	if mode == Approx {
 		return 10000
@@ -27,12 +216,12 @@ type reduceKeyHash struct {
 }
 
 type reduceTable struct {
-	hash       hash.Hash
-	ast        ASTNode
-	srcTable   Table
-	keyExpr    *Func
-	reduceExpr *Func
-	mapExpr    *Func
+	hash     hash.Hash
+	ast      ASTNode
+	srcTable Table
+	keyExpr  *Func
+	reducer  *reducer
+	mapExpr  *Func
 
 	once    sync.Once
 	rowMap  map[hash.Hash]Value
@@ -67,12 +256,15 @@ func (sc *reduceTableScanner) Value() Value {
 	}
 	return NewStruct(NewSimpleStruct(
 		StructField{Name: symbol.Key, Value: k.key},
-		StructField{Name: symbol.Value, Value: val}))
+		StructField{Name: symbol.Value, Value: sc.parent.reducer.Finish(val)}))
 }
 
 func (t *reduceTable) Hash() hash.Hash              { return t.hash }
 func (t *reduceTable) Prefetch(ctx context.Context) { go Recover(func() { t.init(ctx) }) }
 
+// Parallelizable implements ParallelizableTable.
+func (t *reduceTable) Parallelizable(ctx context.Context) bool { return false }
+
 func (t *reduceTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
 	if start > 0 {
 		return &NullTableScanner{}
@@ -88,12 +280,12 @@ func (t *reduceTable) init(ctx context.Context) {
 	t.once.Do(func() {
 		t.rowMap = map[hash.Hash]Value{}
 		srcScanner := t.srcTable.Scanner(ctx, 0, 1, 1)
-		for srcScanner.Scan() {
+		for index := 0; srcScanner.Scan(); index++ {
 			srcRow := srcScanner.Value()
-			key := t.keyExpr.Eval(ctx, srcRow)
+			key := evalRow(ctx, t.keyExpr, t.srcTable, index, srcRow)
 			keyHash := key.Hash()
 			if t.mapExpr != nil {
-				srcRow = t.mapExpr.Eval(ctx, srcRow)
+				srcRow = evalRow(ctx, t.mapExpr, t.srcTable, index, srcRow)
 			}
 			accVal := t.rowMap[keyHash]
 			if !accVal.Valid() {
@@ -102,7 +294,7 @@ func (t *reduceTable) init(ctx context.Context) {
 				t.rowMap[keyHash] = srcRow
 				continue
 			}
-			t.rowMap[keyHash] = t.reduceExpr.Eval(ctx, accVal, srcRow)
+			t.rowMap[keyHash] = t.reducer.Combine(ctx, accVal, srcRow)
 		}
 	})
 }
@@ -110,40 +302,47 @@ func (t *reduceTable) init(ctx context.Context) {
 func builtinNewReduce(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 	srcTable := args[0].Table()
 	keyExpr := args[1].Func()
-	reduceExpr := args[2].Func()
 	mapExpr := args[3].Func()
 	shards := int(args[4].Int())
-	h := hashNewReduceCall(srcTable, keyExpr, reduceExpr, mapExpr)
+	r := &reducer{
+		ast:      ast,
+		combiner: parseReduceCombiner(ast, args[6].Symbol),
+		expr:     args[2].Func(),
+		check:    args[7].Bool(),
+	}
+	h := hashNewReduceCall(srcTable, keyExpr, r, mapExpr)
 	if shards <= 0 {
 		t := &reduceTable{
-			hash:       h,
-			ast:        ast,
-			srcTable:   srcTable,
-			keyExpr:    keyExpr,
-			reduceExpr: reduceExpr,
-			mapExpr:    mapExpr,
+			hash:     h,
+			ast:      ast,
+			srcTable: srcTable,
+			keyExpr:  keyExpr,
+			reducer:  r,
+			mapExpr:  mapExpr,
 		}
 		return NewTable(t)
 	}
 
-	var tableBuf marshal.Encoder
-	mctx := newMarshalContext(ctx)
-	marshalParallelReduceTable(mctx, &tableBuf, h, ast, srcTable, keyExpr, reduceExpr, mapExpr)
+	noteLocalExecution(ast, "reduce", shards)
+	srcTable = shardableTable(ctx, ast, srcTable, shards)
+	marshalledEnv, marshalledTable := marshalRemoteArgs(ctx, ast, func(mctx MarshalContext, enc *marshal.Encoder) {
+		marshalParallelReduceTable(mctx, enc, h, ast, srcTable, keyExpr, r, mapExpr)
+	})
 	t := &parallelReduceTable{
 		hash:            h,
 		ast:             ast,
 		src:             srcTable,
 		keyExpr:         keyExpr,
-		reduceExpr:      reduceExpr,
+		reducer:         r,
 		mapExpr:         mapExpr,
 		nshards:         shards,
-		marshalledEnv:   mctx.marshal(),
-		marshalledTable: tableBuf.Bytes(),
+		marshalledEnv:   marshalledEnv,
+		marshalledTable: marshalledTable,
 	}
 	return NewTable(t)
 }
 
-func hashNewReduceCall(table Table, keyExpr, reduceExpr, mapExpr *Func) hash.Hash {
+func hashNewReduceCall(table Table, keyExpr *Func, r *reducer, mapExpr *Func) hash.Hash {
 	h := hash.Hash{
 		0xa1, 0xfc, 0xb5, 0xd8, 0xf6, 0x6a, 0xe9, 0xa4,
 		0x26, 0x45, 0xff, 0x9f, 0xb8, 0x27, 0xea, 0x3e,
@@ -151,7 +350,12 @@ func hashNewReduceCall(table Table, keyExpr, reduceExpr, mapExpr *Func) hash.Has
 		0x2f, 0x32, 0xdd, 0xf0, 0xb1, 0x15, 0x00, 0x0b}
 	h = h.Merge(table.Hash())
 	h = h.Merge(keyExpr.Hash())
-	h = h.Merge(reduceExpr.Hash())
+	if r.expr != nil {
+		h = h.Merge(r.expr.Hash())
+	}
+	if r.combiner != combinerExpr {
+		h = h.Merge(hash.String(r.combiner.String()))
+	}
 	if mapExpr != nil {
 		h = h.Merge(mapExpr.Hash())
 	}
@@ -161,17 +365,21 @@ func hashNewReduceCall(table Table, keyExpr, reduceExpr, mapExpr *Func) hash.Has
 func init() {
 	RegisterBuiltinFunc("reduce",
 		`
-    tbl | reduce(keyexpr, reduceexpr [,map:=mapexpr] [,shards:=nshards])
+    tbl | reduce(keyexpr, reduceexpr [,map:=mapexpr] [,shards:=nshards] [,check:=check])
+    tbl | reduce(keyexpr, combiner:=name [,map:=mapexpr] [,shards:=nshards])
 
 Arg types:
 
 - _keyexpr_: one-arg function
 - _reduceexpr_: two-arg function
+- _name_: one of sum, min, max, concat, or kahan_sum
 - _mapexpr_: one-arg function (default: ::|row|row::)
 - _nshards_: int (default: 0)
+- _check_: bool (default: false)
 
-Reduce groups rows by their _keyexpr_ value. It then invokes _reduceexpr_ for
-rows with the same key.
+Reduce groups rows by their _keyexpr_ value. It then invokes _reduceexpr_, or
+the builtin combiner _name_, for rows with the same key. Exactly one of
+_reduceexpr_ and _name_ must be set.
 
 Argument _reduceexpr_ is invoked repeatedly to combine rows or values with the same key.
 
@@ -190,6 +398,29 @@ Argument _reduceexpr_ is invoked repeatedly to combine rows or values with the s
     _reduceexpr_ is not invoked. The 'value' column of the resulting table will
     the row itself, or the value of the _mapexpr_, if the 'map' arg is set.
 
+  - If _check_ is true, each invocation of _reduceexpr_ also verifies that the
+    result has the same type as the args, and that _reduceexpr_ is commutative
+    and associative for the args. It reports an error otherwise. The check
+    evaluates _reduceexpr_ several times per invocation, so it is meant for
+    testing a _reduceexpr_ on a small table.
+
+The builtin combiners are commutative and associative, so they produce the same
+result regardless of the order in which the values are combined, and of the
+number of shards. They ignore NA values, unless all the values are NA.
+
+  - sum: the sum of numbers. The result is an int if all the values are ints,
+    and a float otherwise.
+
+  - kahan_sum: the sum of numbers, computed with the compensated (Kahan-Neumaier)
+    summation. The result is a float. It is much less prone to accumulating
+    rounding errors than ::|a,b|a+b:: when adding many floats.
+
+  - min, max: the smallest or the largest value.
+
+  - concat: the string values joined with ','. The values appear in the order
+    of the source rows if _nshards_ is 0. They appear in an unspecified order
+    otherwise.
+
 If _nshards_ >0, it enables distributed execution.
 See the [distributed execution](#distributed-execution) section for more details.
 
@@ -210,6 +441,8 @@ Example: Imagine table ::t0:::
         │Bat  │ 8    │
         │Cat  │ 12   │
 
+::t0 | reduce(&col0, combiner:=sum, map:=&col1):: produces the same table.
+
 ::t0 | reduce(&col0, |a,b|a+b, map:=1):: will count the occurrences of col0 values:
 
         ║key  ║ value║
@@ -232,12 +465,20 @@ _reduceexpr_ must be of the same type as the inputs. For this reason, you
 should always specify a _mapexpr_.
 `,
 		builtinNewReduce,
-		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		func(ast ASTNode, args []AIArg) AIType {
+			combiner := parseReduceCombiner(ast, args[6].Symbol)
+			if hasExpr := args[2].Expr != nil; hasExpr == (combiner != combinerExpr) {
+				Panicf(ast, "reduce: exactly one of reduceexpr and combiner:= must be set")
+			}
+			return AITableType
+		},
 		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},              // table
 		FormalArg{Positional: true, Required: true, Closure: true, ClosureArgs: anonRowFuncArg}, // keyexpr
-		FormalArg{Positional: true, Required: true, Closure: true,
+		FormalArg{Positional: true, Closure: true, DefaultValue: NewFunc(nil),
 			ClosureArgs: []ClosureFormalArg{{symbol.AnonAcc, symbol.Invalid}, {symbol.AnonVal, symbol.Invalid}}}, // reduceexpr
 		FormalArg{Name: symbol.Map, Closure: true, ClosureArgs: anonRowFuncArg, DefaultValue: NewFunc(nil)}, // mapexpr
 		FormalArg{Name: symbol.Shards, DefaultValue: NewInt(0)},                                             // shards:=nnn
-		FormalArg{Name: symbol.Row, Symbol: true, DefaultSymbol: symbol.AnonRow})
+		FormalArg{Name: symbol.Row, Symbol: true, DefaultSymbol: symbol.AnonRow},                            // row:=varname
+		FormalArg{Name: symbol.Combiner, Symbol: true, DefaultSymbol: symbol.Default},                       // combiner:=name
+		FormalArg{Name: symbol.Check, DefaultValue: False, Types: []ValueType{BoolType}})                    // check:=bool
 }
@@ -0,0 +1,105 @@
+package gql
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/grailbio/gql/symbol"
+)
+
+var symRequiresVersion = symbol.Intern("requires_version")
+
+// parseVersion parses a version string of form "v1.2.3" or "1.2". It returns
+// false if the string is not a version, e.g., "devel".
+func parseVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(v, "v")
+	// Ignore suffixes such as "-rc1".
+	if i := strings.IndexAny(v, "-+ "); i >= 0 {
+		v = v[:i]
+	}
+	var parts []int
+	for _, s := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+	return parts, true
+}
+
+// versionAtLeast checks if version v is the same as or newer than version
+// "min". Missing components are treated as zeros.
+func versionAtLeast(v, min []int) bool {
+	for i := 0; i < len(v) || i < len(min); i++ {
+		var a, b int
+		if i < len(v) {
+			a = v[i]
+		}
+		if i < len(min) {
+			b = min[i]
+		}
+		if a != b {
+			return a > b
+		}
+	}
+	return true
+}
+
+// checkRequiredVersion panics if the binary's Version is older than the given
+// version. A binary whose version isn't a release version, e.g., "devel",
+// satisfies any requirement.
+func checkRequiredVersion(ast ASTNode, required string) {
+	min, ok := parseVersion(required)
+	if !ok {
+		Panicf(ast, "requires_version: invalid version '%s'; it must be of form \"2.1\" or \"v2.1.3\"", required)
+	}
+	v, ok := parseVersion(Version)
+	if !ok {
+		return
+	}
+	if !versionAtLeast(v, min) {
+		Panicf(ast, "this script requires gql version %s or newer, but this binary is version %s. Please upgrade gql", required, Version)
+	}
+}
+
+// checkRequiredVersions checks the toplevel "requires_version(...)" calls in
+// the statements, before any of the statements are analyzed or evaluated, so
+// that a script fails before it reaches a construct that the binary doesn't
+// support.
+func checkRequiredVersions(statements []ASTStatementOrLoad) {
+	for _, st := range statements {
+		call, ok := st.Expr.(*ASTFuncall)
+		if !ok || len(call.Raw) != 1 {
+			continue
+		}
+		if ref, ok := call.Function.(*ASTVarRef); !ok || ref.Var != symRequiresVersion {
+			continue
+		}
+		if lit, ok := call.Raw[0].Expr.(*ASTLiteral); ok && lit.Literal.Type() == StringType {
+			checkRequiredVersion(call, lit.Literal.Str(call))
+		}
+	}
+}
+
+func init() {
+	RegisterBuiltinFunc("requires_version",
+		`
+    requires_version("2.1")
+
+Requires_version causes the script to fail if the gql binary is older than the
+given version. When it appears at the toplevel of a script, it is checked
+before any statement in the script runs, including load statements. So it is
+customarily placed at the beginning of a script that uses newer builtin
+functions. It returns the version of the binary.
+
+A binary built without a release version, e.g., one built from a development
+tree, satisfies any requirement.`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			checkRequiredVersion(ast, args[0].Str())
+			return NewString(Version)
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIStringType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{StringType}})
+}
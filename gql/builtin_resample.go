@@ -0,0 +1,423 @@
+package gql
+
+// This file implements bootstrap and permute_test. Each replicate resamples the
+// source table with its own random number generator, so the replicates are
+// reproducible regardless of how they are distributed across shards.
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/bigslice"
+	"github.com/grailbio/bigslice/sliceio"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+type resampleMode int
+
+const (
+	// resampleBootstrap draws rows with replacement.
+	resampleBootstrap resampleMode = iota
+	// resamplePermute shuffles the values of the group column across rows.
+	resamplePermute
+)
+
+var (
+	resampleReplicateSymbolID = symbol.Intern("replicate")
+	resampleObservedSymbolID  = symbol.Intern("observed")
+	resampleMeanSymbolID      = symbol.Intern("mean")
+	resampleSESymbolID        = symbol.Intern("se")
+	resampleLoSymbolID        = symbol.Intern("lo")
+	resampleHiSymbolID        = symbol.Intern("hi")
+	resampleRepsSymbolID      = symbol.Intern("replicates")
+)
+
+// resampleArgs is the set of args of a bootstrap or permute_test call.
+type resampleArgs struct {
+	ast      ASTNode
+	mode     resampleMode
+	src      Table
+	statExpr *Func
+	// groupCol is the column to permute. Set only for resamplePermute.
+	groupCol symbol.ID
+	n        int
+	seed     int64
+}
+
+func (a *resampleArgs) marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	enc.PutGOB(&a.ast)
+	enc.PutVarint(int64(a.mode))
+	a.src.Marshal(ctx, enc)
+	a.statExpr.Marshal(ctx, enc)
+	enc.PutSymbol(a.groupCol.Str())
+	enc.PutVarint(int64(a.n))
+	enc.PutVarint(a.seed)
+}
+
+func unmarshalResampleArgs(ctx UnmarshalContext, data []byte) *resampleArgs {
+	dec := marshal.NewDecoder(data)
+	a := &resampleArgs{}
+	dec.GOB(&a.ast)
+	a.mode = resampleMode(dec.Varint())
+	a.src = unmarshalTable(ctx, dec)
+	a.statExpr = unmarshalFunc(ctx, dec)
+	a.groupCol = symbol.Intern(dec.Symbol())
+	a.n = int(dec.Varint())
+	a.seed = dec.Varint()
+	marshal.ReleaseDecoder(dec)
+	return a
+}
+
+// hash computes the hash of the replicates table.
+func (a *resampleArgs) hash() hash.Hash {
+	h := hash.Hash{
+		0x3c, 0x8a, 0x61, 0x0e, 0x95, 0xd2, 0x47, 0x1b,
+		0xa4, 0x5f, 0x08, 0xc7, 0x72, 0x3e, 0xe9, 0x16,
+		0x5b, 0x90, 0x2d, 0xf4, 0x81, 0x6a, 0x37, 0xcc,
+		0x0d, 0xb8, 0x49, 0xe3, 0x26, 0x7f, 0x94, 0x51}
+	h = h.Merge(hash.Int(int64(a.mode)))
+	h = h.Merge(a.src.Hash())
+	h = h.Merge(a.statExpr.Hash())
+	h = h.Merge(hash.String(a.groupCol.Str()))
+	h = h.Merge(hash.Int(int64(a.n)))
+	return h.Merge(hash.Int(a.seed))
+}
+
+// readRows reads the source table into memory.
+func (a *resampleArgs) readRows(ctx context.Context) []Value {
+	var rows []Value
+	sc := a.src.Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		rows = append(rows, sc.Value())
+	}
+	if len(rows) == 0 {
+		Panicf(a.ast, "resample: table %v is empty", a.src.Attrs(ctx).Name)
+	}
+	return rows
+}
+
+// eval computes the statistic of the given replicate. Arg rows is the contents
+// of the source table.
+func (a *resampleArgs) eval(ctx context.Context, rows []Value, replicate int) Value {
+	// Mix the replicate index into the seed so that each replicate gets an
+	// independent stream.
+	r := rand.New(rand.NewSource(int64(uint64(a.seed)*0x9e3779b97f4a7c15 + uint64(replicate))))
+	sample := make([]Value, len(rows))
+	switch a.mode {
+	case resampleBootstrap:
+		for i := range sample {
+			sample[i] = rows[r.Intn(len(rows))]
+		}
+	case resamplePermute:
+		perm := r.Perm(len(rows))
+		for i, row := range rows {
+			st := row.Struct(a.ast)
+			group, ok := rows[perm[i]].Struct(a.ast).Value(a.groupCol)
+			if !ok {
+				Panicf(a.ast, "permute_test: column %s not found in %v", a.groupCol.Str(), rows[perm[i]])
+			}
+			fields := make([]StructField, st.Len())
+			for fi := range fields {
+				fields[fi] = st.Field(fi)
+				if fields[fi].Name == a.groupCol {
+					fields[fi].Value = group
+				}
+			}
+			sample[i] = NewStruct(NewSimpleStruct(fields...))
+		}
+	default:
+		log.Panicf("invalid resample mode %d", a.mode)
+	}
+	h := a.hash().Merge(hash.Int(int64(replicate)))
+	return a.statExpr.Eval(ctx, NewTable(NewSimpleTable(sample, h, TableAttrs{Name: "resample"})))
+}
+
+// replicateRow creates a row of the replicates table.
+func replicateRow(replicate int, stat Value) Value {
+	return NewStruct(NewSimpleStruct(
+		StructField{Name: resampleReplicateSymbolID, Value: NewInt(int64(replicate))},
+		StructField{Name: statStatisticSymbolID, Value: stat}))
+}
+
+var parallelResampleFunc = bigslice.Func(func(
+	marshaledEnv []byte,
+	tableHash hash.Hash,
+	outBTSVPath string,
+	marshaledArgs []byte,
+	nshards int) (slice bigslice.Slice) {
+	ctx := newUnmarshalContext(marshaledEnv)
+	args := unmarshalResampleArgs(ctx, marshaledArgs)
+	ast := args.ast
+	type shardState struct {
+		rows      []Value
+		replicate int
+	}
+	slice = bigslice.ReaderFunc(nshards,
+		func(shard int, state **shardState, out []Value) (n int, err error) {
+			if *state == nil {
+				Logf(ast, "start resample shard %d/%d", shard, nshards)
+				*state = &shardState{rows: args.readRows(ctx.ctx), replicate: shard}
+			}
+			for i := range out {
+				if (*state).replicate >= args.n {
+					return i, sliceio.EOF
+				}
+				r := (*state).replicate
+				out[i] = replicateRow(r, args.eval(ctx.ctx, (*state).rows, r))
+				(*state).replicate += nshards
+			}
+			return len(out), nil
+		})
+	slice = bigslice.Scan(slice, func(shard int, scan *sliceio.Scanner) error {
+		w := NewBTSVShardWriter(ctx.ctx, outBTSVPath, shard, nshards, TableAttrs{})
+		var v Value
+		for scan.Scan(ctx.ctx, &v) {
+			w.Append(v)
+		}
+		if err := scan.Err(); err != nil {
+			Panicf(ast, "%s: %v", outBTSVPath, err)
+		}
+		w.Close(ctx.ctx)
+		return nil
+	})
+	return
+})
+
+// runResampleLocally computes the replicates using the local executor. Like
+// parallelResampleFunc, shard i computes replicates i, i+nshards, i+2*nshards,
+// and so on.
+func runResampleLocally(ctx context.Context, args *resampleArgs, btsvPath string, nshards int) {
+	rows := args.readRows(ctx)
+	runLocalShards(ctx, args.ast, nshards, func(shard int) {
+		w := NewBTSVShardWriter(ctx, btsvPath, shard, nshards, TableAttrs{})
+		for r := shard; r < args.n; r += nshards {
+			w.Append(replicateRow(r, args.eval(ctx, rows, r)))
+		}
+		w.Close(ctx)
+	})
+}
+
+// runResample computes the statistic for the original table and for each
+// replicate. If nshards > 0, the replicates are computed in parallel, using
+// bigslice or the local executor.
+func runResample(ctx context.Context, args *resampleArgs, nshards int) (observed Value, replicates Table) {
+	observed = args.statExpr.Eval(ctx, NewTable(args.src))
+	h := args.hash()
+	if nshards <= 0 {
+		rows := args.readRows(ctx)
+		out := make([]Value, args.n)
+		for r := range out {
+			out[r] = replicateRow(r, args.eval(ctx, rows, r))
+		}
+		return observed, NewSimpleTable(out, h, TableAttrs{Name: "replicates"})
+	}
+	cacheName := h.String() + ".btsv"
+	btsvPath, found := LookupCache(ctx, cacheName)
+	if found {
+		Logf(args.ast, "cache hit: %s", btsvPath)
+	} else {
+		defer abandonCacheEntry(ctx, btsvPath)
+		noteLocalExecution(args.ast, "resample", nshards)
+		marshaledEnv, marshaledArgs := marshalRemoteArgs(ctx, args.ast, args.marshal)
+		Logf(args.ast, "start bigslice for %d replicates, shards=%d", args.n, nshards)
+		runJob(ctx, args.ast, "resample", nshards, func(ctx context.Context) {
+			runResampleLocally(ctx, args, btsvPath, nshards)
+		}, func() error {
+			_, err := bsSession.Run(ctx, parallelResampleFunc, marshaledEnv, h, btsvPath, marshaledArgs, nshards)
+			return err
+		})
+		ActivateCache(ctx, cacheName, btsvPath)
+		reportTableMaterialized(h, btsvPath, -1)
+	}
+	return observed, NewBTSVTable(btsvPath, args.ast, h)
+}
+
+// replicateStats reads the statistic column of the replicates table. NA values
+// are dropped.
+func replicateStats(ctx context.Context, ast ASTNode, replicates Table) []float64 {
+	var vals []float64
+	sc := replicates.Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		if v := statValueFloat(ast, MustStructValue(sc.Value().Struct(ast), statStatisticSymbolID)); !math.IsNaN(v) {
+			vals = append(vals, v)
+		}
+	}
+	return vals
+}
+
+// statValueFloat converts a statistic to a float. NA is converted to NaN.
+func statValueFloat(ast ASTNode, v Value) float64 {
+	switch v.Type() {
+	case NullType:
+		return math.NaN()
+	case IntType:
+		return float64(v.Int(ast))
+	case FloatType:
+		return v.Float(ast)
+	}
+	Panicf(ast, "resample: the statistic must be a number, but found %v", v)
+	return 0
+}
+
+// quantile computes the q'th quantile of the sorted values, interpolating
+// linearly between the closest ranks.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	if lo+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	frac := pos - float64(lo)
+	return sorted[lo]*(1-frac) + sorted[lo+1]*frac
+}
+
+func init() {
+	RegisterBuiltinFunc("bootstrap",
+		`
+    tbl | bootstrap(statfunc [, n:=nreplicates] [, seed:=seed] [, shards:=nshards])
+
+Arg types:
+
+- _statfunc_: one-arg function that takes a table and returns a number
+- _nreplicates_: int (default: 1000)
+- _seed_: int (default: 0)
+- _nshards_: int (default: 0)
+
+Bootstrap estimates the sampling distribution of the statistic computed by
+_statfunc_. It creates _nreplicates_ tables, each of which has the same number
+of rows as _tbl_, drawn from _tbl_ with replacement, and it calls _statfunc_ on
+each of them. The whole _tbl_ is read into memory.
+
+Bootstrap returns a struct with the following fields:
+
+- observed: the result of _statfunc_ on _tbl_ itself.
+- mean, se: the mean and the standard deviation of the replicate statistics.
+- lo, hi: the 2.5 and 97.5 percentiles of the replicate statistics, i.e., the 95% percentile confidence interval.
+- replicates: a table with columns "replicate" and "statistic".
+
+Replicates whose statistic is NA are ignored in the summary. The replicates
+depend only on _seed_, not on _nshards_. If _nshards_ > 0, the replicates are
+computed in parallel. See the [distributed execution](#distributed-execution)
+section for more details.
+
+Example:
+
+    read("samples.tsv") | bootstrap(func(t) { t | map(&cfdna_ng) | mean() }, n:=10000, shards:=100)
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			rargs := &resampleArgs{
+				ast:      ast,
+				mode:     resampleBootstrap,
+				src:      args[0].Table(),
+				statExpr: args[1].Func(),
+				n:        int(args[2].Int()),
+				seed:     args[3].Int(),
+			}
+			if rargs.n <= 0 {
+				Panicf(ast, "bootstrap: n must be >0, but found %d", rargs.n)
+			}
+			observed, replicates := runResample(ctx, rargs, int(args[4].Int()))
+			stats := replicateStats(ctx, ast, replicates)
+			sort.Float64s(stats)
+			mean, se := math.NaN(), math.NaN()
+			if len(stats) > 1 {
+				mean, se = meanVar(stats)
+				se = math.Sqrt(se)
+			}
+			return NewStruct(NewSimpleStruct(
+				StructField{Name: resampleObservedSymbolID, Value: observed},
+				StructField{Name: resampleMeanSymbolID, Value: statFloat(mean)},
+				StructField{Name: resampleSESymbolID, Value: statFloat(se)},
+				StructField{Name: resampleLoSymbolID, Value: statFloat(quantile(stats, 0.025))},
+				StructField{Name: resampleHiSymbolID, Value: statFloat(quantile(stats, 0.975))},
+				StructField{Name: resampleRepsSymbolID, Value: NewTable(replicates)}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIStructType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{FuncType}},
+		FormalArg{Name: symbol.N, DefaultValue: NewInt(1000), Types: []ValueType{IntType}},
+		FormalArg{Name: symbol.Seed, DefaultValue: NewInt(0), Types: []ValueType{IntType}},
+		FormalArg{Name: symbol.Shards, DefaultValue: NewInt(0), Types: []ValueType{IntType}})
+
+	RegisterBuiltinFunc("permute_test",
+		`
+    tbl | permute_test(statfunc, group:=colname [, n:=nreplicates] [, seed:=seed] [, shards:=nshards])
+
+Arg types:
+
+- _statfunc_: one-arg function that takes a table and returns a number
+- _colname_: string
+- _nreplicates_: int (default: 1000)
+- _seed_: int (default: 0)
+- _nshards_: int (default: 0)
+
+Permute_test runs a permutation test. It creates _nreplicates_ tables, each of
+which is _tbl_ with the values of column _colname_ randomly shuffled across
+rows, and it calls _statfunc_ on each of them. The whole _tbl_ is read into
+memory.
+
+Permute_test returns a struct with the following fields:
+
+- observed: the result of _statfunc_ on _tbl_ itself.
+- pvalue: the two-sided empirical p-value, (1 + number of replicates where |statistic| >= |observed|) / (1 + number of replicates).
+- replicates: a table with columns "replicate" and "statistic".
+
+Replicates whose statistic is NA are ignored when computing the p-value. The
+replicates depend only on _seed_, not on _nshards_. If _nshards_ > 0, the
+replicates are computed in parallel. See the [distributed
+execution](#distributed-execution) section for more details.
+
+Example:
+
+    read("samples.tsv") | permute_test(func(t) { (t | t_test(value:=&cfdna_ng, group:=&is_case)).statistic }, group:="is_case")
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			rargs := &resampleArgs{
+				ast:      ast,
+				mode:     resamplePermute,
+				src:      args[0].Table(),
+				statExpr: args[1].Func(),
+				groupCol: symbol.Intern(args[2].Str()),
+				n:        int(args[3].Int()),
+				seed:     args[4].Int(),
+			}
+			if rargs.n <= 0 {
+				Panicf(ast, "permute_test: n must be >0, but found %d", rargs.n)
+			}
+			if args[2].Str() == "" {
+				Panicf(ast, "permute_test: group:= must be set")
+			}
+			observed, replicates := runResample(ctx, rargs, int(args[5].Int()))
+			obs := math.Abs(statValueFloat(ast, observed))
+			stats := replicateStats(ctx, ast, replicates)
+			nExtreme := 0
+			for _, v := range stats {
+				if math.Abs(v) >= obs {
+					nExtreme++
+				}
+			}
+			p := math.NaN()
+			if !math.IsNaN(obs) {
+				p = float64(1+nExtreme) / float64(1+len(stats))
+			}
+			return NewStruct(NewSimpleStruct(
+				StructField{Name: resampleObservedSymbolID, Value: observed},
+				StructField{Name: statPValueSymbolID, Value: statFloat(p)},
+				StructField{Name: resampleRepsSymbolID, Value: NewTable(replicates)}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIStructType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{FuncType}},
+		FormalArg{Name: symbol.Group, DefaultValue: NewString(""), Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.N, DefaultValue: NewInt(1000), Types: []ValueType{IntType}},
+		FormalArg{Name: symbol.Seed, DefaultValue: NewInt(0), Types: []ValueType{IntType}},
+		FormalArg{Name: symbol.Shards, DefaultValue: NewInt(0), Types: []ValueType{IntType}})
+}
@@ -0,0 +1,306 @@
+package gql
+
+// This file implements union, intersect, and except. They identify a row by the
+// hash of the row itself, or of the key:= expression. Inputs too large to fit
+// in memory are partitioned by the hash into temporary btsv files, and the
+// partitions are processed one at a time.
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/base/traverse"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+// SetOpMaxRowsPerPartition is the max number of input rows that union,
+// intersect, and except process in memory at a time. It is really a private
+// const, but is exposed for testing.
+var SetOpMaxRowsPerPartition = 1 << 20
+
+type setOp int
+
+const (
+	// setOpUnion yields the distinct rows of the two tables.
+	setOpUnion setOp = iota
+	// setOpIntersect yields the distinct rows of the first table that appear in
+	// the second.
+	setOpIntersect
+	// setOpExcept yields the distinct rows of the first table that don't appear
+	// in the second.
+	setOpExcept
+)
+
+func (op setOp) String() string {
+	switch op {
+	case setOpUnion:
+		return "union"
+	case setOpIntersect:
+		return "intersect"
+	case setOpExcept:
+		return "except"
+	}
+	return fmt.Sprintf("setop%d", int(op))
+}
+
+// setOpTable is a Table implementation for union, intersect, and except. It
+// materializes the result in a btsv file on the first scan.
+type setOpTable struct {
+	hash    hash.Hash
+	ast     ASTNode // source-code location
+	op      setOp
+	src     [2]Table
+	keyExpr *Func // computes the key of a row. If nil, the row itself is the key.
+
+	once      sync.Once
+	btsvTable Table
+
+	exactLenOnce sync.Once
+	exactLen     int
+}
+
+// Hash implements the Table interface.
+func (t *setOpTable) Hash() hash.Hash { return t.hash }
+
+// Attrs implements the Table interface.
+func (t *setOpTable) Attrs(ctx context.Context) TableAttrs {
+	return TableAttrs{Name: t.op.String(), Path: t.src[0].Attrs(ctx).Path}
+}
+
+// Len implements the Table interface.
+func (t *setOpTable) Len(ctx context.Context, mode CountMode) int {
+	if mode == Approx {
+		n := t.src[0].Len(ctx, Approx)
+		if t.op == setOpUnion {
+			n += t.src[1].Len(ctx, Approx)
+		}
+		return n
+	}
+	t.exactLenOnce.Do(func() {
+		t.exactLen = DefaultTableLen(ctx, t)
+	})
+	return t.exactLen
+}
+
+// Prefetch implements the Table interface.
+func (t *setOpTable) Prefetch(ctx context.Context) { t.init(ctx) }
+
+// Marshal implements the Table interface.
+func (t *setOpTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	t.init(ctx.ctx)
+	t.btsvTable.Marshal(ctx, enc)
+}
+
+// Scanner implements the Table interface.
+func (t *setOpTable) Scanner(ctx context.Context, start, limit, nshards int) TableScanner {
+	t.init(ctx)
+	return t.btsvTable.Scanner(ctx, start, limit, nshards)
+}
+
+func (t *setOpTable) init(ctx context.Context) {
+	t.once.Do(func() {
+		cacheName := t.hash.String() + ".btsv"
+		btsvPath, found := LookupCache(ctx, cacheName)
+		if found {
+			Logf(t.ast, "cache hit: %s", btsvPath)
+			t.btsvTable = NewBTSVTable(btsvPath, t.ast, t.hash)
+			return
+		}
+		defer abandonCacheEntry(ctx, btsvPath)
+		nParts := (t.src[0].Len(ctx, Approx)+t.src[1].Len(ctx, Approx))/SetOpMaxRowsPerPartition + 1
+		w := NewBTSVShardWriter(ctx, btsvPath, 0, 1, TableAttrs{})
+		if nParts == 1 {
+			t.evalPartition(ctx, t.src, w)
+		} else {
+			Logf(t.ast, "%v: spilling the inputs to %d partitions", t.op, nParts)
+			paths := t.partition(ctx, nParts)
+			for p := 0; p < nParts; p++ {
+				var tables [2]Table
+				for i := range tables {
+					tables[i] = NewBTSVTable(paths[i][p], t.ast, t.hash.Merge(hash.String(paths[i][p])))
+				}
+				t.evalPartition(ctx, tables, w)
+			}
+			for i := range paths {
+				traverse.Each(len(paths[i]), func(p int) error { // nolint:errcheck
+					if err := file.RemoveAll(ctx, paths[i][p]); err != nil {
+						Errorf(t.ast, "remove %s: %v", paths[i][p], err)
+					}
+					return nil
+				})
+			}
+		}
+		w.Close(ctx)
+		ActivateCache(ctx, cacheName, btsvPath)
+		reportTableMaterialized(t.hash, btsvPath, w.nrows)
+		t.btsvTable = NewBTSVTable(btsvPath, t.ast, t.hash)
+	})
+}
+
+// key computes the hash that identifies the row.
+func (t *setOpTable) key(ctx context.Context, row Value) hash.Hash {
+	if t.keyExpr == nil {
+		return row.Hash()
+	}
+	return t.keyExpr.Eval(ctx, row).Hash()
+}
+
+// partition splits each source table into nParts btsv files by the key
+// hash. It returns the pathnames of the files, indexed by the source table,
+// then by the partition.
+func (t *setOpTable) partition(ctx context.Context, nParts int) (paths [2][]string) {
+	for i, src := range t.src {
+		writers := make([]*BTSVShardWriter, nParts)
+		paths[i] = make([]string, nParts)
+		for p := range writers {
+			paths[i][p] = newTempPath(ctx, fmt.Sprintf("%s-%v-tmp-%d-%06d.btsv", t.hash, t.op, i, p))
+			writers[p] = NewBTSVShardWriter(ctx, paths[i][p], 0, 1, TableAttrs{})
+		}
+		sc := src.Scanner(ctx, 0, 1, 1)
+		for sc.Scan() {
+			row := sc.Value()
+			key := t.key(ctx, row)
+			writers[binary.LittleEndian.Uint32(key[:])%uint32(nParts)].Append(row)
+		}
+		for _, w := range writers {
+			w.Close(ctx)
+		}
+	}
+	return paths
+}
+
+// evalPartition computes the set operation on the given pair of tables, and
+// appends the result to w. The first occurrence of each key is kept, so the
+// rows of tables[0] precede those of tables[1].
+func (t *setOpTable) evalPartition(ctx context.Context, tables [2]Table, w *BTSVShardWriter) {
+	var others map[hash.Hash]struct{} // keys of the rows in tables[1].
+	if t.op != setOpUnion {
+		others = map[hash.Hash]struct{}{}
+		sc := tables[1].Scanner(ctx, 0, 1, 1)
+		for sc.Scan() {
+			others[t.key(ctx, sc.Value())] = struct{}{}
+		}
+	}
+	emitted := map[hash.Hash]struct{}{}
+	emit := func(row Value, key hash.Hash) {
+		if _, ok := emitted[key]; ok {
+			return
+		}
+		emitted[key] = struct{}{}
+		w.Append(row)
+	}
+	sc := tables[0].Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		row := sc.Value()
+		key := t.key(ctx, row)
+		_, inOthers := others[key]
+		switch t.op {
+		case setOpUnion:
+			emit(row, key)
+		case setOpIntersect:
+			if inOthers {
+				emit(row, key)
+			}
+		case setOpExcept:
+			if !inOthers {
+				emit(row, key)
+			}
+		}
+	}
+	if t.op == setOpUnion {
+		sc := tables[1].Scanner(ctx, 0, 1, 1)
+		for sc.Scan() {
+			row := sc.Value()
+			emit(row, t.key(ctx, row))
+		}
+	}
+}
+
+// newSetOpTable creates a table that computes "op" on t0 and t1.
+func newSetOpTable(ast ASTNode, op setOp, t0, t1 Table, keyExpr *Func) Table {
+	h := hash.Hash{
+		0x4e, 0x1a, 0x93, 0xd7, 0x62, 0x05, 0xbc, 0x38,
+		0xf1, 0x8d, 0x27, 0x6b, 0xa0, 0x59, 0xe4, 0x13,
+		0x7c, 0x36, 0xd8, 0x4f, 0x95, 0x0a, 0x61, 0xbe,
+		0x23, 0xc9, 0x70, 0x1e, 0x8b, 0xf5, 0x42, 0xad}
+	h = h.Merge(hash.String(op.String()))
+	h = h.Merge(t0.Hash())
+	h = h.Merge(t1.Hash())
+	if keyExpr != nil {
+		h = h.Merge(keyExpr.Hash())
+	}
+	return &setOpTable{hash: h, ast: ast, op: op, src: [2]Table{t0, t1}, keyExpr: keyExpr}
+}
+
+func init() {
+	registerSetOp := func(op setOp, doc string) {
+		RegisterBuiltinFunc(op.String(), doc,
+			func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+				return NewTable(newSetOpTable(ast, op, args[0].Table(), args[1].Table(), args[2].Func()))
+			},
+			func(ast ASTNode, args []AIArg) AIType { return AITableType },
+			FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+			FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+			FormalArg{Name: symbol.Key, Closure: true, ClosureArgs: anonRowFuncArg, DefaultValue: NewFunc(nil)})
+	}
+
+	registerSetOp(setOpUnion, `
+    union(tbl1, tbl2 [, key:=keyexpr])
+
+Arg types:
+
+- _tbl1_, _tbl2_: table
+- _keyexpr_: one-arg function (default: the row itself)
+
+Union yields the distinct rows of _tbl1_ and _tbl2_. Two rows are considered
+the same if they are equal, or, if _keyexpr_ is set, if _keyexpr_ yields equal
+values for them. Of the rows with the same key, only the first one is kept, and
+the rows of _tbl1_ come before those of _tbl2_.
+
+Union, intersect, and except identify rows by hashing them. Up to about a
+million rows, the result rows are in the order of the inputs. Larger inputs are
+partitioned by the hash into temporary files, and the result is the
+concatenation of the results of the partitions.
+
+Example:
+
+    union(read("batch1.tsv"), read("batch2.tsv"), key:=&sample_id)
+`)
+
+	registerSetOp(setOpIntersect, `
+    intersect(tbl1, tbl2 [, key:=keyexpr])
+
+Arg types:
+
+- _tbl1_, _tbl2_: table
+- _keyexpr_: one-arg function (default: the row itself)
+
+Intersect yields the distinct rows of _tbl1_ that also appear in _tbl2_. Two
+rows are considered the same as in union.
+
+Example:
+
+    intersect(read("batch1.tsv"), read("qc_passed.tsv"), key:=&sample_id)
+`)
+
+	registerSetOp(setOpExcept, `
+    except(tbl1, tbl2 [, key:=keyexpr])
+
+Arg types:
+
+- _tbl1_, _tbl2_: table
+- _keyexpr_: one-arg function (default: the row itself)
+
+Except yields the distinct rows of _tbl1_ that don't appear in _tbl2_. Two rows
+are considered the same as in union.
+
+Example:
+
+    except(read("batch1.tsv"), read("excluded.tsv"), key:=&sample_id)
+`)
+}
@@ -0,0 +1,254 @@
+package gql
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+)
+
+// readAllRows reads the table into memory.
+func readAllRows(ctx context.Context, t Table) []Value {
+	var rows []Value
+	sc := t.Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		rows = append(rows, sc.Value())
+	}
+	return rows
+}
+
+// splitTable randomly assigns the rows to len(fracs) parts. If stratifyExpr is
+// nonnil, the rows are partitioned by the value of stratifyExpr, and each
+// stratum is split separately, so that every part has (close to) the same
+// proportion of each stratum. It returns the part index for each row.
+func splitTable(ctx context.Context, rows []Value, fracs []float64, stratifyExpr *Func, seed int64) []int {
+	total := 0.0
+	for _, f := range fracs {
+		total += f
+	}
+	var (
+		strata      [][]int
+		stratumByID = map[hash.Hash]int{}
+	)
+	for i, row := range rows {
+		key := hash.Zero
+		if stratifyExpr != nil {
+			key = stratifyExpr.Eval(ctx, row).Hash()
+		}
+		si, ok := stratumByID[key]
+		if !ok {
+			si = len(strata)
+			stratumByID[key] = si
+			strata = append(strata, nil)
+		}
+		strata[si] = append(strata[si], i)
+	}
+	r := rand.New(rand.NewSource(seed))
+	parts := make([]int, len(rows))
+	for _, stratum := range strata {
+		r.Shuffle(len(stratum), func(i, j int) { stratum[i], stratum[j] = stratum[j], stratum[i] })
+		start, cum := 0, 0.0
+		for pi, f := range fracs {
+			cum += f
+			limit := int(math.Round(float64(len(stratum)) * cum / total))
+			if pi == len(fracs)-1 {
+				limit = len(stratum)
+			}
+			for _, ri := range stratum[start:limit] {
+				parts[ri] = pi
+			}
+			start = limit
+		}
+	}
+	return parts
+}
+
+// sampleByGroup draws up to n rows from each group of t in one pass, keeping a
+// reservoir of n rows per group. The groups are keyed by the value of
+// groupExpr. The sampled rows are returned in their order in t.
+func sampleByGroup(ctx context.Context, t Table, groupExpr *Func, n int, seed int64) []Value {
+	type sampledRow struct {
+		index int // position in t.
+		row   Value
+	}
+	type reservoir struct {
+		nSeen int
+		rows  []sampledRow
+	}
+	var (
+		r          = rand.New(rand.NewSource(seed))
+		reservoirs = map[hash.Hash]*reservoir{}
+	)
+	sc := t.Scanner(ctx, 0, 1, 1)
+	for i := 0; sc.Scan(); i++ {
+		row := sc.Value()
+		key := groupExpr.Eval(ctx, row).Hash()
+		res, ok := reservoirs[key]
+		if !ok {
+			res = &reservoir{}
+			reservoirs[key] = res
+		}
+		res.nSeen++
+		if len(res.rows) < n {
+			res.rows = append(res.rows, sampledRow{i, row})
+			continue
+		}
+		// Keep the row with probability n/nSeen, replacing a random one.
+		if j := r.Intn(res.nSeen); j < n {
+			res.rows[j] = sampledRow{i, row}
+		}
+	}
+	var sampled []sampledRow
+	for _, res := range reservoirs {
+		sampled = append(sampled, res.rows...)
+	}
+	sort.Slice(sampled, func(i, j int) bool { return sampled[i].index < sampled[j].index })
+	rows := make([]Value, len(sampled))
+	for i, s := range sampled {
+		rows[i] = s.row
+	}
+	return rows
+}
+
+func init() {
+	RegisterBuiltinFunc("shuffle",
+		`
+    tbl | shuffle([seed:=seed])
+
+Arg types:
+
+- _seed_: int (default: 0)
+
+Shuffle returns the rows of _tbl_ in a random order. The order depends only on
+_seed_ and the contents of _tbl_. The whole _tbl_ is read into memory.
+
+Example:
+
+    read("features.tsv") | shuffle(seed:=42)
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			src, seed := args[0].Table(), args[1].Int()
+			rows := readAllRows(ctx, src)
+			r := rand.New(rand.NewSource(seed))
+			r.Shuffle(len(rows), func(i, j int) { rows[i], rows[j] = rows[j], rows[i] })
+			h := hash.String("shuffle").Merge(src.Hash()).Merge(hash.Int(seed))
+			return NewTable(NewSimpleTable(rows, h, TableAttrs{Name: "shuffle"}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Name: symbol.Seed, DefaultValue: NewInt(0), Types: []ValueType{IntType}})
+
+	RegisterBuiltinFunc("split",
+		`
+    tbl | split(fracs [, stratify:=stratifyexpr] [, seed:=seed])
+
+Arg types:
+
+- _fracs_: struct of numbers
+- _stratifyexpr_: one-arg function (default: none)
+- _seed_: int (default: 0)
+
+Split randomly splits the rows of _tbl_ into disjoint tables. For each field of
+_fracs_, the result has a field of the same name, which is a table holding
+(approximately) the given fraction of the rows. The fractions are normalized to
+sum to one. Within each result table, the rows keep their order in _tbl_.
+
+If _stratifyexpr_ is set, the rows are grouped by its value, and each group is
+split separately, so that every result table has the same proportion of each
+group. The split depends only on _seed_ and the contents of _tbl_. The whole
+_tbl_ is read into memory.
+
+Example:
+
+    s := read("features.tsv") | split({train:0.8, valid:0.2}, stratify:=&label, seed:=1);
+    s.train | write("train.tsv");
+    s.valid | write("valid.tsv")
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			src, fracStruct, stratifyExpr, seed := args[0].Table(), args[1].Struct(), args[2].Func(), args[3].Int()
+			nParts := fracStruct.Len()
+			if nParts == 0 {
+				Panicf(ast, "split: fracs must have at least one field")
+			}
+			fracs := make([]float64, nParts)
+			for i := range fracs {
+				f := fracStruct.Field(i)
+				switch f.Value.Type() {
+				case IntType:
+					fracs[i] = float64(f.Value.Int(ast))
+				case FloatType:
+					fracs[i] = f.Value.Float(ast)
+				default:
+					Panicf(ast, "split: fraction %s must be a number, but found %v", f.Name.Str(), f.Value)
+				}
+				if fracs[i] < 0 || math.IsNaN(fracs[i]) {
+					Panicf(ast, "split: fraction %s must be nonnegative, but found %v", f.Name.Str(), f.Value)
+				}
+			}
+			rows := readAllRows(ctx, src)
+			parts := splitTable(ctx, rows, fracs, stratifyExpr, seed)
+			partRows := make([][]Value, nParts)
+			for i, row := range rows {
+				partRows[parts[i]] = append(partRows[parts[i]], row)
+			}
+			h := hash.String("split").Merge(src.Hash()).Merge(NewStruct(fracStruct).Hash()).Merge(hash.Int(seed))
+			if stratifyExpr != nil {
+				h = h.Merge(stratifyExpr.Hash())
+			}
+			fields := make([]StructField, nParts)
+			for i := range fields {
+				name := fracStruct.Field(i).Name
+				fields[i] = StructField{
+					Name:  name,
+					Value: NewTable(NewSimpleTable(partRows[i], h.Merge(hash.String(name.Str())), TableAttrs{Name: "split:" + name.Str()})),
+				}
+			}
+			return NewStruct(NewSimpleStruct(fields...))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIStructType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{StructType}},
+		FormalArg{Name: symbol.Stratify, Closure: true, ClosureArgs: anonRowFuncArg, DefaultValue: NewFunc(nil)},
+		FormalArg{Name: symbol.Seed, DefaultValue: NewInt(0), Types: []ValueType{IntType}})
+
+	RegisterBuiltinFunc("sample_by",
+		`
+    tbl | sample_by(group:=groupexpr, n:=nrows [, seed:=seed])
+
+Arg types:
+
+- _groupexpr_: one-arg function
+- _nrows_: int
+- _seed_: int (default: 0)
+
+Sample_by draws up to _nrows_ random rows from each group of rows of _tbl_,
+where the rows are grouped by the value of _groupexpr_. A group with fewer than
+_nrows_ rows is kept in full. Rows whose _groupexpr_ is NA form one group. The
+sampled rows keep their order in _tbl_.
+
+Sample_by reads _tbl_ once, keeping only _nrows_ rows per group in memory, so
+it is much cheaper than cogrouping _tbl_ when the groups are large. The sample
+depends only on _seed_ and the contents of _tbl_.
+
+Example:
+
+    read("fragments.btsv") | sample_by(group:=&sample, n:=100, seed:=1)
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			src, groupExpr, n, seed := args[0].Table(), args[1].Func(), args[2].Int(), args[3].Int()
+			if n <= 0 {
+				Panicf(ast, "sample_by: n must be >0, but found %d", n)
+			}
+			rows := sampleByGroup(ctx, src, groupExpr, int(n), seed)
+			h := hash.String("sample_by").Merge(src.Hash()).Merge(groupExpr.Hash()).Merge(hash.Int(n)).Merge(hash.Int(seed))
+			return NewTable(NewSimpleTable(rows, h, TableAttrs{Name: "sample_by"}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Name: symbol.Group, Required: true, Closure: true, ClosureArgs: anonRowFuncArg},
+		FormalArg{Name: symbol.N, Required: true, Types: []ValueType{IntType}},
+		FormalArg{Name: symbol.Seed, DefaultValue: NewInt(0), Types: []ValueType{IntType}})
+}
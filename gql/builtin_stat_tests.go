@@ -0,0 +1,420 @@
+package gql
+
+// This file implements the statistical test builtins. See stats.go for the
+// distributions.
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+)
+
+var (
+	statStatisticSymbolID = symbol.Intern("statistic")
+	statPValueSymbolID    = symbol.Intern("pvalue")
+	statDFSymbolID        = symbol.Intern("df")
+	statGroup1SymbolID    = symbol.Intern("group1")
+	statGroup2SymbolID    = symbol.Intern("group2")
+	statN1SymbolID        = symbol.Intern("n1")
+	statN2SymbolID        = symbol.Intern("n2")
+	statMean1SymbolID     = symbol.Intern("mean1")
+	statMean2SymbolID     = symbol.Intern("mean2")
+	statOddsRatioSymbolID = symbol.Intern("odds_ratio")
+	statPAdjSymbolID      = symbol.Intern("padj")
+)
+
+// readTwoSamples splits the values computed by valueExpr into two samples by
+// the value of groupExpr. The groups are ordered by their first appearance. NA
+// values are skipped.
+func readTwoSamples(ctx context.Context, ast ASTNode, name string, t Table, valueExpr, groupExpr *Func) (groups [2]Value, samples [2][]float64) {
+	var (
+		nGroups int
+		hashes  [2]hash.Hash
+	)
+	sc := t.Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		row := sc.Value()
+		g := groupExpr.Eval(ctx, row)
+		if g.Null() != NotNull {
+			continue
+		}
+		gh := g.Hash()
+		gi := -1
+		for i := 0; i < nGroups; i++ {
+			if hashes[i] == gh {
+				gi = i
+			}
+		}
+		if gi < 0 {
+			if nGroups == 2 {
+				Panicf(ast, "%s: expect two groups, but found a third group %v (others: %v, %v)", name, g, groups[0], groups[1])
+			}
+			gi = nGroups
+			groups[gi], hashes[gi] = g, gh
+			nGroups++
+		}
+		v := valueExpr.Eval(ctx, row)
+		switch v.Type() {
+		case NullType:
+		case IntType:
+			samples[gi] = append(samples[gi], float64(v.Int(ast)))
+		case FloatType:
+			samples[gi] = append(samples[gi], v.Float(ast))
+		default:
+			Panicf(ast, "%s: value must be a number, but found %v", name, v)
+		}
+	}
+	if nGroups != 2 {
+		Panicf(ast, "%s: expect two groups, but found %d", name, nGroups)
+	}
+	return
+}
+
+// meanVar computes the mean and the unbiased variance of the values.
+func meanVar(vals []float64) (mean, variance float64) {
+	for _, v := range vals {
+		mean += v
+	}
+	mean /= float64(len(vals))
+	for _, v := range vals {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(vals) - 1)
+	return
+}
+
+// welchTTest computes Welch's t statistic, its degrees of freedom, and the
+// two-sided p-value.
+func welchTTest(x, y []float64) (t, df, p float64) {
+	m1, v1 := meanVar(x)
+	m2, v2 := meanVar(y)
+	n1, n2 := float64(len(x)), float64(len(y))
+	s1, s2 := v1/n1, v2/n2
+	t = (m1 - m2) / math.Sqrt(s1+s2)
+	df = (s1 + s2) * (s1 + s2) / (s1*s1/(n1-1) + s2*s2/(n2-1))
+	return t, df, studentTTwoSidedP(t, df)
+}
+
+// mannWhitneyU computes the Mann-Whitney U statistic of x, and the two-sided
+// p-value using the normal approximation with tie and continuity corrections.
+func mannWhitneyU(x, y []float64) (u, p float64) {
+	type obs struct {
+		v     float64
+		first bool
+	}
+	all := make([]obs, 0, len(x)+len(y))
+	for _, v := range x {
+		all = append(all, obs{v, true})
+	}
+	for _, v := range y {
+		all = append(all, obs{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+	var rankSum, tieTerm float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2 // Average of ranks i+1, ..., j.
+		for k := i; k < j; k++ {
+			if all[k].first {
+				rankSum += rank
+			}
+		}
+		nt := float64(j - i)
+		tieTerm += nt*nt*nt - nt
+		i = j
+	}
+	n1, n2 := float64(len(x)), float64(len(y))
+	n := n1 + n2
+	u = rankSum - n1*(n1+1)/2
+	mu := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - tieTerm/(n*(n-1))))
+	if sigma == 0 {
+		return u, 1
+	}
+	z := (math.Abs(u-mu) - 0.5) / sigma
+	if z < 0 {
+		z = 0
+	}
+	return u, math.Min(1, 2*normalSF(z))
+}
+
+// chiSquareTest computes Pearson's chi-square statistic of the contingency
+// table of x and y, its degrees of freedom, and the p-value.
+func chiSquareTest(ctx context.Context, ast ASTNode, t Table, xExpr, yExpr *Func) (stat, df, p float64) {
+	var (
+		xIndex, yIndex = map[hash.Hash]int{}, map[hash.Hash]int{}
+		counts         = map[[2]int]float64{}
+		xCounts        []float64
+		yCounts        []float64
+		n              float64
+	)
+	index := func(m map[hash.Hash]int, counts *[]float64, v Value) int {
+		h := v.Hash()
+		i, ok := m[h]
+		if !ok {
+			i = len(m)
+			m[h] = i
+			*counts = append(*counts, 0)
+		}
+		(*counts)[i]++
+		return i
+	}
+	sc := t.Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		row := sc.Value()
+		x, y := xExpr.Eval(ctx, row), yExpr.Eval(ctx, row)
+		if x.Null() != NotNull || y.Null() != NotNull {
+			continue
+		}
+		counts[[2]int{index(xIndex, &xCounts, x), index(yIndex, &yCounts, y)}]++
+		n++
+	}
+	if len(xCounts) < 2 || len(yCounts) < 2 {
+		Panicf(ast, "chisq: each variable must have at least two distinct values, but found %d and %d", len(xCounts), len(yCounts))
+	}
+	for i, xc := range xCounts {
+		for j, yc := range yCounts {
+			expected := xc * yc / n
+			diff := counts[[2]int{i, j}] - expected
+			stat += diff * diff / expected
+		}
+	}
+	df = float64((len(xCounts) - 1) * (len(yCounts) - 1))
+	return stat, df, chiSquareSF(stat, df)
+}
+
+// statFloat converts a statistic to a value. NaN is converted to NA.
+func statFloat(v float64) Value {
+	if math.IsNaN(v) {
+		return Null
+	}
+	return NewFloat(v)
+}
+
+func init() {
+	RegisterBuiltinFunc("t_test",
+		`
+    tbl | t_test(value:=valueexpr, group:=groupexpr)
+
+Arg types:
+
+- _valueexpr_: one-arg function returning a number
+- _groupexpr_: one-arg function
+
+T_test runs Welch's two-sample t-test, which does not assume equal variances.
+For each row of _tbl_, _valueexpr_ computes the value, and _groupexpr_ computes
+the group. There must be exactly two groups. Rows whose value or group is NA
+are skipped.
+
+T_test returns a struct with fields "statistic" (the t statistic of group1 -
+group2), "df" (the degrees of freedom), "pvalue" (the two-sided p-value),
+"group1" and "group2" (the groups, in order of first appearance), "n1" and
+"n2" (the sample sizes), and "mean1" and "mean2" (the sample means).
+
+Example:
+
+    read("samples.tsv") | t_test(value:=&cfdna_ng, group:=&is_case)
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			groups, samples := readTwoSamples(ctx, ast, "t_test", args[0].Table(), args[1].Func(), args[2].Func())
+			for i, s := range samples {
+				if len(s) < 2 {
+					Panicf(ast, "t_test: group %v has %d values; at least two are needed", groups[i], len(s))
+				}
+			}
+			t, df, p := welchTTest(samples[0], samples[1])
+			m1, _ := meanVar(samples[0])
+			m2, _ := meanVar(samples[1])
+			return NewStruct(NewSimpleStruct(
+				StructField{Name: statStatisticSymbolID, Value: statFloat(t)},
+				StructField{Name: statDFSymbolID, Value: statFloat(df)},
+				StructField{Name: statPValueSymbolID, Value: statFloat(p)},
+				StructField{Name: statGroup1SymbolID, Value: groups[0]},
+				StructField{Name: statGroup2SymbolID, Value: groups[1]},
+				StructField{Name: statN1SymbolID, Value: NewInt(int64(len(samples[0])))},
+				StructField{Name: statN2SymbolID, Value: NewInt(int64(len(samples[1])))},
+				StructField{Name: statMean1SymbolID, Value: NewFloat(m1)},
+				StructField{Name: statMean2SymbolID, Value: NewFloat(m2)}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIStructType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Name: symbol.Value, Required: true, Closure: true, ClosureArgs: anonRowFuncArg},
+		FormalArg{Name: symbol.Group, Required: true, Closure: true, ClosureArgs: anonRowFuncArg})
+
+	RegisterBuiltinFunc("wilcoxon",
+		`
+    tbl | wilcoxon(value:=valueexpr, group:=groupexpr)
+
+Arg types:
+
+- _valueexpr_: one-arg function returning a number
+- _groupexpr_: one-arg function
+
+Wilcoxon runs the Wilcoxon rank-sum (Mann-Whitney U) test. The args are the
+same as t_test. The p-value is two-sided, and it is computed using the normal
+approximation with the tie and the continuity corrections, so it is
+inaccurate for very small samples.
+
+Wilcoxon returns a struct with fields "statistic" (the U statistic of group1),
+"pvalue", "group1", "group2", "n1", and "n2".
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			groups, samples := readTwoSamples(ctx, ast, "wilcoxon", args[0].Table(), args[1].Func(), args[2].Func())
+			for i, s := range samples {
+				if len(s) == 0 {
+					Panicf(ast, "wilcoxon: group %v has no values", groups[i])
+				}
+			}
+			u, p := mannWhitneyU(samples[0], samples[1])
+			return NewStruct(NewSimpleStruct(
+				StructField{Name: statStatisticSymbolID, Value: NewFloat(u)},
+				StructField{Name: statPValueSymbolID, Value: statFloat(p)},
+				StructField{Name: statGroup1SymbolID, Value: groups[0]},
+				StructField{Name: statGroup2SymbolID, Value: groups[1]},
+				StructField{Name: statN1SymbolID, Value: NewInt(int64(len(samples[0])))},
+				StructField{Name: statN2SymbolID, Value: NewInt(int64(len(samples[1])))}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIStructType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Name: symbol.Value, Required: true, Closure: true, ClosureArgs: anonRowFuncArg},
+		FormalArg{Name: symbol.Group, Required: true, Closure: true, ClosureArgs: anonRowFuncArg})
+
+	RegisterBuiltinFunc("fisher_exact",
+		`
+    fisher_exact(a, b, c, d)
+
+Arg types:
+
+- _a_, _b_, _c_, _d_: int
+
+Fisher_exact runs Fisher's exact test on the 2x2 contingency table
+
+        │ a │ b │
+        │ c │ d │
+
+It returns a struct with fields "odds_ratio" (a*d/(b*c)) and "pvalue" (the
+two-sided p-value).
+
+Example:
+
+    fisher_exact(3, 1, 1, 3) == {odds_ratio:9.0, pvalue:0.4857142857142857}
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			var cells [4]int
+			for i := range cells {
+				cells[i] = int(args[i].Int())
+				if cells[i] < 0 {
+					Panicf(ast, "fisher_exact: counts must be nonnegative, but found %d", cells[i])
+				}
+			}
+			a, b, c, d := cells[0], cells[1], cells[2], cells[3]
+			odds := math.NaN()
+			switch {
+			case b*c != 0:
+				odds = float64(a*d) / float64(b*c)
+			case a*d != 0:
+				odds = math.Inf(1)
+			}
+			return NewStruct(NewSimpleStruct(
+				StructField{Name: statOddsRatioSymbolID, Value: statFloat(odds)},
+				StructField{Name: statPValueSymbolID, Value: NewFloat(fisherExactTest(a, b, c, d))}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIStructType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{IntType}},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{IntType}},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{IntType}},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{IntType}})
+
+	RegisterBuiltinFunc("chisq",
+		`
+    tbl | chisq(xexpr, yexpr)
+
+Arg types:
+
+- _xexpr_, _yexpr_: one-arg function
+
+Chisq runs Pearson's chi-square test of independence between the values of
+_xexpr_ and _yexpr_, computed for each row of _tbl_. Rows where either value is
+NA are skipped. No continuity correction is applied.
+
+Chisq returns a struct with fields "statistic", "df", and "pvalue".
+
+Example:
+
+    read("variants.tsv") | chisq(&is_case, &carrier)
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			stat, df, p := chiSquareTest(ctx, ast, args[0].Table(), args[1].Func(), args[2].Func())
+			return NewStruct(NewSimpleStruct(
+				StructField{Name: statStatisticSymbolID, Value: statFloat(stat)},
+				StructField{Name: statDFSymbolID, Value: NewFloat(df)},
+				StructField{Name: statPValueSymbolID, Value: statFloat(p)}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIStructType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Positional: true, Required: true, Closure: true, ClosureArgs: anonRowFuncArg},
+		FormalArg{Positional: true, Required: true, Closure: true, ClosureArgs: anonRowFuncArg})
+
+	RegisterBuiltinFunc("bh_adjust",
+		`
+    tbl | bh_adjust(pexpr)
+
+Arg types:
+
+- _pexpr_: one-arg function returning a float
+
+Bh_adjust adjusts p-values for multiple testing using the Benjamini-Hochberg
+procedure. For each row of _tbl_, _pexpr_ computes the p-value. The result has
+the rows of _tbl_, each with an additional column "padj" that stores the
+adjusted p-value. Rows whose p-value is NA get NA, and they are not counted as
+tests. The whole table is read into memory.
+
+Example:
+
+    results | bh_adjust(&pvalue) | filter($padj < 0.05)
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			t, pExpr := args[0].Table(), args[1].Func()
+			var (
+				rows  []Value
+				pvals []float64
+			)
+			sc := t.Scanner(ctx, 0, 1, 1)
+			for sc.Scan() {
+				row := sc.Value()
+				p := math.NaN()
+				switch v := pExpr.Eval(ctx, row); v.Type() {
+				case NullType:
+				case IntType:
+					p = float64(v.Int(ast))
+				case FloatType:
+					p = v.Float(ast)
+				default:
+					Panicf(ast, "bh_adjust: p-value must be a number, but found %v", v)
+				}
+				rows = append(rows, row)
+				pvals = append(pvals, p)
+			}
+			adj := benjaminiHochberg(pvals)
+			for i, row := range rows {
+				st := row.Struct(ast)
+				fields := make([]StructField, 0, st.Len()+1)
+				for fi := 0; fi < st.Len(); fi++ {
+					fields = append(fields, st.Field(fi))
+				}
+				fields = append(fields, StructField{Name: statPAdjSymbolID, Value: statFloat(adj[i])})
+				rows[i] = NewStruct(NewSimpleStruct(fields...))
+			}
+			h := hash.String("bh_adjust").Merge(t.Hash()).Merge(pExpr.Hash())
+			return NewTable(NewSimpleTable(rows, h, TableAttrs{Name: "bh_adjust"}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Positional: true, Required: true, Closure: true, ClosureArgs: anonRowFuncArg})
+}
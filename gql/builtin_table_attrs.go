@@ -2,6 +2,7 @@ package gql
 
 import (
 	"context"
+	"sort"
 
 	"github.com/grailbio/gql/symbol"
 )
@@ -16,19 +17,54 @@ Example:
      t := read("foo.tsv")
      table_attrs(t).path  (=="foo.tsv")
 
-Table_attrs returns table attributes as a struct with three fields:
+Table_attrs returns table attributes as a struct with the following fields:
 
  - Field 'type' is the table type, e.g., "tsv", "mapfilter"
  - Field 'name' is the name of the table. It is some random string.
  - Field 'path' is name of the file the table is read from.
    "path" is nonempty only for tables created directly by read(),
-   tables that are result of applying map or filter to table created by read().`,
+   tables that are result of applying map or filter to table created by read().
+ - Field 'version' is the S3 object version the table is read from. It is
+   nonempty only for tables created by read() with version_id or as_of.
+ - Field 'description' is the description of the table, e.g., one set by
+   with_attrs().
+ - Field 'metadata' is a struct of the "##key=value" lines at the beginning
+   of a TSV file, e.g., ones written by write(..., metadata:=true). The values
+   are strings. A btsv file keeps the metadata of the table it was written
+   from.
+ - Field 'coercions' is a struct that maps each column of a TSV file to the
+   number of cells whose values were changed when converted to the column
+   type, e.g., "1.5" read as 1 in an int column. Only the columns with such
+   cells are listed. The counts cover only the rows read so far. See
+   read(..., strict:=true).`,
 		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 			table := args[0].Table()
 			attrs := table.Attrs(ctx)
+			keys := make([]string, 0, len(attrs.Metadata))
+			for key := range attrs.Metadata {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			metadata := make([]StructField, len(keys))
+			for i, key := range keys {
+				metadata[i] = StructField{symbol.Intern(key), NewString(attrs.Metadata[key])}
+			}
+			keys = keys[:0]
+			for key := range attrs.Coercions {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			coercions := make([]StructField, len(keys))
+			for i, key := range keys {
+				coercions[i] = StructField{symbol.Intern(key), NewInt(int64(attrs.Coercions[key]))}
+			}
 			return NewStruct(NewSimpleStruct(
 				StructField{symbol.Name, NewString(attrs.Name)},
-				StructField{symbol.Path, NewString(attrs.Path)}))
+				StructField{symbol.Path, NewString(attrs.Path)},
+				StructField{symbol.Version, NewString(attrs.Version)},
+				StructField{symbol.Description, NewString(attrs.Description)},
+				StructField{symbol.Metadata, NewStruct(NewSimpleStruct(metadata...))},
+				StructField{symbol.Coercions, NewStruct(NewSimpleStruct(coercions...))}))
 		},
 		func(ast ASTNode, _ []AIArg) AIType { return AIStructType },
 		FormalArg{Positional: true, Required: true})
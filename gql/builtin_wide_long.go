@@ -0,0 +1,423 @@
+package gql
+
+// This file implements to_long and to_wide. They convert between the wide
+// (e.g., one column per sample) and the long (one row per cell) layouts of a
+// matrix. Unlike gather and spread, they do not need the list of the matrix
+// columns, and they stream the rows, so they work on matrices with tens of
+// thousands of columns.
+
+import (
+	"context"
+	"sync"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+// wideLongTable implements both to_long and to_wide.
+type wideLongTable struct {
+	hash   hash.Hash
+	ast    ASTNode
+	src    Table
+	toWide bool // true for to_wide, false for to_long.
+	idCols []symbol.ID
+	// name and value are the names of the columns in the long table that store
+	// the wide column name and the cell value, respectively.
+	name, value symbol.ID
+	// sparse is true if NA cells are omitted.
+	sparse bool
+
+	exactLen     int
+	exactLenOnce sync.Once
+
+	// wideCols lists the columns of a dense to_wide result, excluding the ID
+	// columns, in order of first appearance. Computed lazily.
+	wideCols     []symbol.ID
+	wideColsOnce sync.Once
+}
+
+func (t *wideLongTable) Len(ctx context.Context, mode CountMode) int {
+	if t.toWide || mode == Exact {
+		t.exactLenOnce.Do(func() {
+			t.exactLen = DefaultTableLen(ctx, t)
+		})
+		return t.exactLen
+	}
+	return t.src.Len(ctx, mode)
+}
+
+func (t *wideLongTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	MarshalTableOutline(ctx, enc, t)
+}
+func (t *wideLongTable) Prefetch(ctx context.Context) {}
+func (t *wideLongTable) Hash() hash.Hash              { return t.hash }
+func (t *wideLongTable) Attrs(ctx context.Context) TableAttrs {
+	name := "to_long"
+	if t.toWide {
+		name = "to_wide"
+	}
+	return TableAttrs{Name: name, Path: t.src.Attrs(ctx).Path}
+}
+
+// Parallelizable implements ParallelizableTable. to_wide cannot be sharded,
+// since a row of the wide table may span shards of the source.
+func (t *wideLongTable) Parallelizable(ctx context.Context) bool {
+	return !t.toWide && IsParallelizable(ctx, t.src)
+}
+
+func (t *wideLongTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	if !t.toWide {
+		return &longTableScanner{
+			parent: t,
+			src:    NewPrefetchingTableScanner(ctx, t.src.Scanner(ctx, start, limit, total), -1),
+			fields: make([]StructField, len(t.idCols)+2),
+		}
+	}
+	if start > 0 {
+		// A row of the wide table may span shards of the source.
+		return &NullTableScanner{}
+	}
+	sc := &wideTableScanner{
+		parent:   t,
+		src:      NewPrefetchingTableScanner(ctx, t.src.Scanner(ctx, 0, 1, 1), -1),
+		idVals:   make([]Value, len(t.idCols)),
+		doneKeys: map[hash.Hash]bool{},
+		symbols:  map[string]symbol.ID{},
+	}
+	if !t.sparse {
+		t.wideColsOnce.Do(func() { t.wideCols = t.computeWideCols(ctx) })
+		sc.colIndex = make(map[symbol.ID]int, len(t.wideCols))
+		for i, col := range t.wideCols {
+			sc.colIndex[col] = i
+		}
+	}
+	return sc
+}
+
+// computeWideCols lists the distinct values of the name column. Only the names
+// are kept in memory.
+func (t *wideLongTable) computeWideCols(ctx context.Context) []symbol.ID {
+	var (
+		cols    []symbol.ID
+		seen    = map[symbol.ID]bool{}
+		symbols = map[string]symbol.ID{}
+		sc      = t.src.Scanner(ctx, 0, 1, 1)
+	)
+	for sc.Scan() {
+		row := sc.Value().Struct(t.ast)
+		nameVal, ok := row.Value(t.name)
+		if !ok {
+			Panicf(t.ast, "to_wide: column '%s' not found%s", t.name.Str(), columnNotFoundHint(t.name, row))
+		}
+		col := wideColName(t.ast, nameVal, symbols)
+		if !seen[col] {
+			seen[col] = true
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// wideColName computes the column name in the wide table for the given value of
+// the name column. Arg symbols caches the interned names.
+func wideColName(ast ASTNode, v Value, symbols map[string]symbol.ID) symbol.ID {
+	var name string
+	switch v.Type() {
+	case StringType, FileNameType, EnumType:
+		name = v.Str(ast)
+	default:
+		name = v.String()
+	}
+	col, ok := symbols[name]
+	if !ok {
+		col = symbol.Intern(name)
+		symbols[name] = col
+	}
+	return col
+}
+
+// longTableScanner produces one row for each non-ID cell of the source rows.
+type longTableScanner struct {
+	parent *wideLongTable
+	src    TableScanner
+	// cur is the current source row, and next is the index of the field in cur
+	// to produce next.
+	cur    Struct
+	next   int
+	fields []StructField
+	row    Value
+}
+
+func (sc *longTableScanner) Value() Value { return sc.row }
+
+func (sc *longTableScanner) Scan() bool {
+	t := sc.parent
+	nID := len(t.idCols)
+	for {
+		if sc.cur == nil {
+			if !sc.src.Scan() {
+				return false
+			}
+			sc.cur = sc.src.Value().Struct(t.ast)
+			sc.next = 0
+			for i, col := range t.idCols {
+				v, ok := sc.cur.Value(col)
+				if !ok {
+					Panicf(t.ast, "to_long: ID column '%s' not found%s", col.Str(), columnNotFoundHint(col, sc.cur))
+				}
+				sc.fields[i] = StructField{Name: col, Value: v}
+			}
+		}
+		for sc.next < sc.cur.Len() {
+			f := sc.cur.Field(sc.next)
+			sc.next++
+			if sc.isIDCol(f.Name) || (t.sparse && f.Value.Type() == NullType) {
+				continue
+			}
+			sc.fields[nID] = StructField{Name: t.name, Value: NewString(f.Name.Str())}
+			sc.fields[nID+1] = StructField{Name: t.value, Value: f.Value}
+			sc.row = NewStruct(NewSimpleStruct(sc.fields...))
+			return true
+		}
+		sc.cur = nil
+	}
+}
+
+func (sc *longTableScanner) isIDCol(col symbol.ID) bool {
+	for _, id := range sc.parent.idCols {
+		if id == col {
+			return true
+		}
+	}
+	return false
+}
+
+// wideTableScanner merges consecutive source rows with the same ID columns into
+// one row.
+type wideTableScanner struct {
+	parent *wideLongTable
+	src    TableScanner
+	row    Value
+
+	// colIndex maps a column name to its index in parent.wideCols. Nil if sparse.
+	colIndex map[symbol.ID]int
+	symbols  map[string]symbol.ID
+
+	// The group being built. idVals are the values of the ID columns, and key is
+	// their hash.
+	idVals []Value
+	key    hash.Hash
+	cells  []StructField
+	// pending is the first row of the next group, if any.
+	pending    Struct
+	pendingKey hash.Hash
+	// doneKeys is the set of IDs of the groups produced so far. It is used to
+	// detect an unsorted source.
+	doneKeys map[hash.Hash]bool
+}
+
+func (sc *wideTableScanner) Value() Value { return sc.row }
+
+// idKey computes the values of the ID columns in the row and their hash.
+func (sc *wideTableScanner) idKey(row Struct, vals []Value) hash.Hash {
+	t := sc.parent
+	h := hash.Hash{}
+	for i, col := range t.idCols {
+		v, ok := row.Value(col)
+		if !ok {
+			Panicf(t.ast, "to_wide: ID column '%s' not found%s", col.Str(), columnNotFoundHint(col, row))
+		}
+		if vals != nil {
+			vals[i] = v
+		}
+		h = h.Merge(v.Hash())
+	}
+	return h
+}
+
+func (sc *wideTableScanner) Scan() bool {
+	t := sc.parent
+	var first Struct
+	if sc.pending != nil {
+		first, sc.key = sc.pending, sc.pendingKey
+		sc.pending = nil
+	} else {
+		if !sc.src.Scan() {
+			return false
+		}
+		first = sc.src.Value().Struct(t.ast)
+		sc.key = sc.idKey(first, nil)
+	}
+	if sc.doneKeys[sc.key] {
+		Panicf(t.ast, "to_wide: rows with the same ID columns must be adjacent; sort the table by the ID columns first (row %v)", NewStruct(first))
+	}
+	sc.idKey(first, sc.idVals)
+	sc.cells = sc.cells[:0]
+	if sc.colIndex != nil {
+		for _, col := range t.wideCols {
+			sc.cells = append(sc.cells, StructField{Name: col, Value: Null})
+		}
+	}
+	seen := map[symbol.ID]bool{}
+	sc.addCell(first, seen)
+	for sc.src.Scan() {
+		row := sc.src.Value().Struct(t.ast)
+		if key := sc.idKey(row, nil); key != sc.key {
+			sc.pending, sc.pendingKey = row, key
+			break
+		}
+		sc.addCell(row, seen)
+	}
+	sc.doneKeys[sc.key] = true
+
+	fields := make([]StructField, 0, len(t.idCols)+len(sc.cells))
+	for i, col := range t.idCols {
+		fields = append(fields, StructField{Name: col, Value: sc.idVals[i]})
+	}
+	fields = append(fields, sc.cells...)
+	sc.row = NewStruct(NewSimpleStruct(fields...))
+	return true
+}
+
+// addCell adds the cell stored in the long row to the current group.
+func (sc *wideTableScanner) addCell(row Struct, seen map[symbol.ID]bool) {
+	t := sc.parent
+	nameVal, ok := row.Value(t.name)
+	if !ok {
+		Panicf(t.ast, "to_wide: column '%s' not found%s", t.name.Str(), columnNotFoundHint(t.name, row))
+	}
+	val, ok := row.Value(t.value)
+	if !ok {
+		Panicf(t.ast, "to_wide: column '%s' not found%s", t.value.Str(), columnNotFoundHint(t.value, row))
+	}
+	col := wideColName(t.ast, nameVal, sc.symbols)
+	if seen[col] {
+		Panicf(t.ast, "to_wide: duplicate column '%s' for row %v", col.Str(), NewStruct(row))
+	}
+	seen[col] = true
+	if sc.colIndex != nil {
+		sc.cells[sc.colIndex[col]].Value = val
+		return
+	}
+	if val.Type() == NullType {
+		return
+	}
+	sc.cells = append(sc.cells, StructField{Name: col, Value: val})
+}
+
+func newWideLongTable(ast ASTNode, args []ActualArg, toWide bool) Value {
+	n := len(args)
+	t := &wideLongTable{
+		ast:    ast,
+		src:    args[0].Table(),
+		toWide: toWide,
+		name:   symbol.Intern(args[n-3].Str()),
+		value:  symbol.Intern(args[n-2].Str()),
+		sparse: args[n-1].Bool(),
+	}
+	h := hash.String("to_long")
+	if toWide {
+		h = hash.String("to_wide")
+	}
+	h = h.Merge(t.src.Hash()).Merge(t.name.Hash()).Merge(t.value.Hash()).Merge(hash.Bool(t.sparse))
+	for _, arg := range args[1 : n-3] {
+		col := symbol.Intern(arg.Str())
+		t.idCols = append(t.idCols, col)
+		h = h.Merge(col.Hash())
+	}
+	if t.name == t.value {
+		Panicf(ast, "name_name and value_name must differ, but both are '%s'", t.name.Str())
+	}
+	t.hash = h
+	return NewTable(t)
+}
+
+func init() {
+	RegisterBuiltinFunc("to_long",
+		`
+    tbl | to_long(idcol... [, name_name:=namecol, value_name:=valuecol, sparse:=sparse])
+
+Arg types:
+
+- _idcol_: string
+- _namecol_: string (default "name")
+- _valuecol_: string (default "value")
+- _sparse_: bool (default false)
+
+To_long converts a wide matrix into the long format. For each row of _tbl_, it
+produces one row per column other than the _idcol_ columns. Each output row
+has the _idcol_ columns, the column _namecol_ that stores the name of the
+source column, and the column _valuecol_ that stores the cell value.
+
+Unlike gather, to_long does not need the list of the columns to convert, and
+the source rows need not have the same set of columns. The rows are streamed,
+so to_long works on matrices with tens of thousands of columns. If _sparse_ is
+true, NA cells are omitted.
+
+Example: Imagine table t0 with following contents:
+
+        ║bin ║ s1║ s2║
+        ├────┼───┼───┤
+        │b1  │ 30│ NA│
+        │b2  │ 40│ 41│
+
+::t0 | to_long("bin", sparse:=true):: will produce the following table:
+
+        ║bin ║ name║ value║
+        ├────┼─────┼──────┤
+        │b1  │ s1  │    30│
+        │b2  │ s1  │    40│
+        │b2  │ s2  │    41│
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return newWideLongTable(ast, args, false)
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Positional: true, Required: true, Variadic: true, Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.NameName, DefaultValue: NewString("name"), Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.ValueName, DefaultValue: NewString("value"), Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.Sparse, DefaultValue: False, Types: []ValueType{BoolType}})
+
+	RegisterBuiltinFunc("to_wide",
+		`
+    tbl | to_wide(idcol... [, name_name:=namecol, value_name:=valuecol, sparse:=sparse])
+
+Arg types:
+
+- _idcol_: string
+- _namecol_: string (default "name")
+- _valuecol_: string (default "value")
+- _sparse_: bool (default false)
+
+To_wide is the inverse of to_long. It merges the rows of _tbl_ that have the
+same values in the _idcol_ columns into one row. The row has the _idcol_
+columns, plus one column for each merged row; the column is named after the
+value of column _namecol_, and its value is the value of column _valuecol_.
+
+The rows with the same _idcol_ values must be adjacent in _tbl_, e.g., _tbl_ is
+sorted by the _idcol_ columns. Only one row is built at a time, so to_wide does
+not keep the whole matrix in memory.
+
+If _sparse_ is false, every row has the same columns, ordered by their first
+appearance in _tbl_; missing cells are filled with NA. This requires an extra
+scan of _tbl_ to find the column names. If _sparse_ is true, a row has only the
+columns that appear in its group, and the NA cells are omitted.
+
+The result cannot be sharded; it is computed by one scanner.
+
+Example:
+
+    t0 | to_long("bin") | to_wide("bin") == t0
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return newWideLongTable(ast, args, true)
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Positional: true, Required: true, Variadic: true, Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.NameName, DefaultValue: NewString("name"), Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.ValueName, DefaultValue: NewString("value"), Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.Sparse, DefaultValue: False, Types: []ValueType{BoolType}})
+}
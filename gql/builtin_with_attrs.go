@@ -0,0 +1,168 @@
+package gql
+
+import (
+	"context"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+// withAttrsTable overrides the attributes of the source table. The rows are
+// unchanged.
+type withAttrsTable struct {
+	src         Table
+	hash        hash.Hash
+	name        string
+	description string
+	// columns lists the column descriptions and units to set. Empty fields are
+	// left unchanged.
+	columns []TSVColumn
+}
+
+var _ Table = &withAttrsTable{}
+
+// Attrs implements the Table interface.
+func (t *withAttrsTable) Attrs(ctx context.Context) TableAttrs {
+	attrs := t.src.Attrs(ctx)
+	if t.name != "" {
+		attrs.Name = t.name
+	}
+	if t.description != "" {
+		attrs.Description = t.description
+	}
+	if len(t.columns) > 0 {
+		// Copy the columns, since attrs.Columns may be shared with the source.
+		cols := append([]TSVColumn(nil), attrs.Columns...)
+	nextCol:
+		for _, newCol := range t.columns {
+			for i := range cols {
+				if cols[i].Name == newCol.Name {
+					if newCol.Description != "" {
+						cols[i].Description = newCol.Description
+					}
+					if newCol.Unit != "" {
+						cols[i].Unit = newCol.Unit
+					}
+					continue nextCol
+				}
+			}
+			cols = append(cols, newCol)
+		}
+		attrs.Columns = cols
+	}
+	return attrs
+}
+
+// Prefetch implements the Table interface.
+func (t *withAttrsTable) Prefetch(ctx context.Context) { t.src.Prefetch(ctx) }
+
+// Len implements the Table interface.
+func (t *withAttrsTable) Len(ctx context.Context, mode CountMode) int {
+	return t.src.Len(ctx, mode)
+}
+
+// Hash implements the Table interface.
+func (t *withAttrsTable) Hash() hash.Hash { return t.hash }
+
+// Marshal implements the Table interface. The attributes are stored in the
+// materialized btsv file.
+func (t *withAttrsTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	MarshalTableOutline(ctx, enc, t)
+}
+
+// Scanner implements the Table interface.
+func (t *withAttrsTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	return t.src.Scanner(ctx, start, limit, total)
+}
+
+func builtinWithAttrs(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+	src := args[0].Table()
+	t := &withAttrsTable{
+		src:         src,
+		name:        args[1].Str(),
+		description: args[2].Str(),
+	}
+	h := src.Hash().Merge(hash.String("with_attrs")).
+		Merge(hash.String(t.name)).Merge(hash.String(t.description))
+	// column finds or adds the entry for the given column in t.columns.
+	column := func(name symbol.ID) *TSVColumn {
+		for i := range t.columns {
+			if t.columns[i].Name == name.Str() {
+				return &t.columns[i]
+			}
+		}
+		t.columns = append(t.columns, TSVColumn{Name: name.Str()})
+		return &t.columns[len(t.columns)-1]
+	}
+	if cols := args[3].Value; cols.Type() == StructType {
+		st := cols.Struct(ast)
+		for fi := 0; fi < st.Len(); fi++ {
+			f := st.Field(fi)
+			if f.Value.Type() != StringType {
+				Panicf(ast, "with_attrs: description of column '%s' must be a string, but found %v", f.Name.Str(), f.Value)
+			}
+			column(f.Name).Description = f.Value.Str(ast)
+		}
+	}
+	if units := args[4].Value; units.Type() == StructType {
+		st := units.Struct(ast)
+		for fi := 0; fi < st.Len(); fi++ {
+			f := st.Field(fi)
+			if f.Value.Type() != StringType || f.Value.Str(ast) == "" {
+				Panicf(ast, "with_attrs: unit of column '%s' must be a nonempty string, but found %v", f.Name.Str(), f.Value)
+			}
+			column(f.Name).Unit = f.Value.Str(ast)
+		}
+	}
+	for _, col := range t.columns {
+		h = h.Merge(hash.String(col.Name)).Merge(hash.String(col.Description))
+		if col.Unit != "" {
+			h = h.Merge(hash.String("unit:" + col.Unit))
+		}
+	}
+	t.hash = h
+	return NewTable(t)
+}
+
+func init() {
+	RegisterBuiltinFunc("with_attrs",
+		`
+    tbl | with_attrs([name:=tblname, description:=desc, columns:={col0:coldesc0, ...}, units:={col0:unit0, ...}])
+
+Arg types:
+
+- _tblname_: string
+- _desc_: string
+- _coldesc0_, ...: string
+- _unit0_, ...: string
+
+With_attrs attaches metadata to the table. _Tblname_ and _desc_ replace the
+table name and description, respectively. Each field in _columns_ sets the
+description of the column of the same name. Each field in _units_ sets the unit
+of the column of the same name, e.g., "x", "bp", "s", or "ng/mL". The rows of
+the table are unchanged.
+
+The metadata is reported by table_attrs() and print(mode:="description"), and
+write() stores it in the btsv index and in the data dictionary file that
+accompanies a tidy TSV file. The units are stored only in the data dictionary.
+
+The units are used by convert_unit, and map and filter log a warning when an
+expression adds, subtracts, or compares two columns of different units. See
+convert_unit for the list of known units.
+
+Example:
+
+    read("coverage.tsv") | with_attrs(
+        description:="Per-sample coverage",
+        columns:={depth: "mean coverage", sample_id: "sample barcode"},
+        units:={depth: "x"})
+`,
+		builtinWithAttrs,
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Name: symbol.Name, DefaultValue: NewString(""), Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.Description, DefaultValue: NewString(""), Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.Columns, DefaultValue: Null, Types: []ValueType{StructType}},
+		FormalArg{Name: symbol.Units, DefaultValue: Null, Types: []ValueType{StructType}})
+}
@@ -4,17 +4,20 @@ import (
 	"context"
 
 	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/hash"
 	"github.com/grailbio/gql/symbol"
 )
 
 func init() {
 	RegisterBuiltinFunc("write",
-		`Usage: write(table, "path" [,shards:=nnn] [,type:="format"])
+		`Usage: write(table, "path" [,shards:=nnn] [,type:="format"] [,index:=&col] [,dict_encode:=bool] [,column_order:=table("col0","col1",...)] [,float_format:="fmt"] [,trim_float_zero:=bool] [,escape:="mode"] [,metadata:=true|{key:value,...}] [,mode:="append"] [,nested_tables:="mode"] [,delimiter:="delim"] [,if_changed:=bool] [,bgzip:=bool])
 
 Write table contents to a file. The optional argument "type" specifies the file
-format. The value should be either "tsv", "btsv", or "bed".  If type argument is
-omitted, the file format is auto-detected from the extension of the "path" -
-".tsv" for the TSV format, ".btsv" for the BTSV format, ".bed" for the BED format.
+format. The value should be either "tsv", "csv", "btsv", "bed", or "json".  If
+type argument is omitted, the file format is auto-detected from the extension of
+the "path" - ".tsv" for the TSV format, ".csv" for the CSV format, ".btsv" for
+the BTSV format, ".bed" for the BED format, ".json", ".jsonl", or ".ndjson" for
+the JSON format.
 
 - When writing a btsv file, the write function accepts the "shards"
   parameter. It sets the number of rangeshards. For example,
@@ -25,6 +28,151 @@ omitted, the file format is auto-detected from the extension of the "path" -
   foo.tsv. bar.btsv is actually a directory, and shard files are created
   underneath the directory.
 
+- When writing a btsv file, the write function also accepts the "index"
+  parameter. It lists the columns for which block-level statistics and bloom
+  filters are created. For example,
+
+    read("foo.tsv") | write("bar.btsv", index:=&sample_id)
+    read("foo.tsv") | write("bar.btsv", index:={&sample_id, &chrom})
+
+  will create an index on column sample_id (and chrom). Filters of form
+  "filter(&sample_id == "X")" on bar.btsv then read only the blocks that may
+  contain the matching rows. Comparisons using <, <=, >, or >= against a
+  constant use the min and max values of the column in each block.
+
+- When writing a btsv file, the write function also accepts the "dict_encode"
+  parameter. If true, repeated string, filename, and enum values are stored
+  once per shard in a dictionary, and cells refer to the dictionary entries.
+  This reduces the file size and the memory usage of the reader when the table
+  has low-cardinality string columns, such as sample IDs or chromosome names.
+  For example,
+
+    read("foo.tsv") | write("bar.btsv", dict_encode:=true)
+
+- A btsv file records the attributes of the table, i.e., its name, the path
+  and the version of the file it was read from, its description, the
+  descriptions and the units of its columns, and its metadata. They are
+  reported by table_attrs() after the file is read back. For example,
+
+    read("foo.tsv") | with_attrs(description:="QC metrics") | write("bar.btsv")
+    table_attrs(read("bar.btsv")).description  // "QC metrics"
+
+- When writing a tsv file, the write function accepts the "column_order"
+  parameter. It is a table of column names that are written first, in the
+  given order. The list may contain "..." once, which stands for the rest of
+  the columns in their original order. For example,
+
+    read("foo.tsv") | write("bar.tsv", column_order:=table("chrom", "start", "end", "..."))
+    read("foo.tsv") | write("bar.tsv", column_order:=table("...", "score"))
+
+  write columns chrom, start, and end first, and column score last,
+  respectively. It is an error if a listed column does not exist in the table.
+  If column_order is omitted, the columns are written in the order of the
+  first row, or in the order guessed from all the rows if the rows have
+  different sets of columns.
+
+- When writing a tsv file, floats are written in the shortest form that reads
+  back as the same value, and floats with integral values are written with
+  ".0", e.g., "3.0", so that read(write(tbl)) yields the same column types and
+  values. The "float_format" parameter sets the Go fmt format for floats
+  instead, and "trim_float_zero:=true" drops the ".0" of integral values. For
+  example,
+
+    read("foo.tsv") | write("bar.tsv", float_format:="%.3f")
+
+- The "escape" parameter specifies how tabs, newlines, and other special
+  characters in the cells of a tsv file are encoded. It is one of:
+
+  - "none" (default): tabs, newlines, and carriage returns are replaced with
+    spaces.
+
+  - "c": they are written as "\t", "\n", and "\r", and a backslash is written as
+    "\\". The file must be read with read(..., escape:="c").
+
+  - "quote": a cell that contains them or a double quote is enclosed in double
+    quotes, and the double quotes in the cell are doubled, as specified in
+    RFC4180. The file can be read with read() without any option.
+
+  For example,
+
+    read("notes.tsv") | write("notes2.tsv", escape:="quote")
+
+- The "metadata" parameter causes comment lines of form "##key=value" to be
+  written before the header line of a tsv file. They record the gql version
+  ("##gql_version"), the creation time ("##created"), and the hash of the table
+  ("##source_hash"). If the parameter is a struct, it is also recorded in
+  "##params". Read() skips these lines, and table_attrs(tbl).metadata reports
+  them. For example,
+
+    tbl | write("out.tsv", metadata:={min_depth: 10, panel: "v2"})
+
+- The "mode" parameter, if "append", adds the rows to the existing file
+  instead of replacing it. It is supported for tsv and btsv files. For a tsv
+  file, the header line is not written again, and the columns of the table
+  must match the ones in the header. For a btsv file, the rows are written in
+  new shard files in the directory, so processes may append to the same table
+  concurrently. If the file doesn't exist, it is created as usual. For example,
+
+    batch_qc | write("s3://bucket/qc.tsv", mode:="append")
+
+- The "nested_tables" parameter specifies how the cells of a tsv file that
+  contain tables are written. It is one of:
+
+  - "inline" (default): a small table is printed in the cell, and a large one
+    is abbreviated. The cell cannot be read back as a table.
+
+  - "file": each table is written as a btsv file in directory "<base>_tables"
+    next to the tsv file, e.g., "out_tables" for "out.tsv", and the cell
+    stores the path of the btsv file relative to the directory of the tsv
+    file. The column type is recorded as "table" in the data dictionary, so
+    read(..., dict:="auto") turns the cells back into tables. The btsv files are opened only
+    when the nested tables are accessed. A directory holding the tsv file, the
+    dictionary, and the "_tables" directory can be copied elsewhere as a
+    unit. For example,
+
+    read("samples.tsv") | map({$sample, reads: read($bam_path)}) | write("out.tsv", nested_tables:="file")
+    read("out.tsv", dict:="auto") | map({$sample, n: count($reads)})
+
+- A csv file is written in the same way as a tsv file, except that the cells
+  are separated by commas, and a cell that contains a comma, a newline, or a
+  double quote is quoted as specified in RFC4180. The parameters above for tsv
+  files also apply to csv files, except that "escape" must be "quote" or
+  omitted. The "delimiter" parameter sets the character that separates the
+  cells of a tsv or csv file instead. For example,
+
+    read("foo.tsv") | write("foo.csv")
+    read("foo.tsv") | write("foo.txt", type:="csv", delimiter:=";")
+
+- The "if_changed" parameter, if true, skips the write if the file exists and
+  was written by an earlier write(..., if_changed:=true) from the same table
+  with the same parameters, and it hasn't been modified since. The hash of the
+  table and the parameters is recorded in a file next to the written file,
+  e.g., "out.tsv.gqlhash" for "out.tsv", along with the size and the modtime
+  of the written file. A write without if_changed:= removes the hash file. The
+  file is thus left untouched, with the same timestamp, if its
+  contents would not change, so that the systems that watch the file are not
+  triggered needlessly. It cannot be combined with mode:="append". For example,
+
+    read("samples.tsv") | filter($qc_pass) | write("s3://bucket/passed.tsv", if_changed:=true)
+
+- A json file stores one row per line, each of which is a JSON value. If the
+  path ends with ".json", the rows are written as a JSON array instead. A
+  struct becomes an object, a table becomes an array, and NA becomes null. A
+  date, a datetime, a duration, and a char become strings. For example,
+
+    read("foo.tsv") | write("foo.jsonl")
+
+- A tsv, csv, bed, or json file whose path ends with ".gz" is
+  gzip-compressed. The "bgzip" parameter, if true, compresses a tsv, csv, or
+  bed file in the BGZF format instead, so that the file can be indexed by
+  tabix. The rows must then be sorted by the
+  chromosome and the start position. Other compression suffixes, e.g., ".zst",
+  are not supported for writing. For example,
+
+    read("peaks.bed") | sort({&chrom, &start}) | write("peaks.bed.gz", bgzip:=true)
+
+Write returns false if the write was skipped because of "if_changed", and true
+otherwise.
 .`, func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
 			table := args[0].Table()
 			path := args[1].Str()
@@ -35,16 +183,105 @@ omitted, the file format is auto-detected from the extension of the "path" -
 			} else {
 				fh = GetFileHandlerByPath(path)
 			}
+			appendMode := false
+			switch mode := args[12].Str(); mode {
+			case "":
+			case "append":
+				appendMode = true
+			default:
+				Panicf(ast, "write %v: mode '%s': must be \"append\"", path, mode)
+			}
+			btsvOpts := btsvWriterOpts{
+				indexCols:  btsvIndexColumns(ast, args[4].Func()),
+				dictEncode: args[7].Bool(),
+				appendMode: appendMode,
+			}
+			tsvOpts := tsvWriterOpts{
+				colOrder:      parseTSVColumnOrder(ctx, ast, args[6].Value),
+				floatFormat:   args[8].Str(),
+				trimFloatZero: args[9].Bool(),
+				escape:        parseTSVEscapeMode(ast, args[10].Str()),
+				delimiter:     parseTSVDelimiter(ast, args[14].Str()),
+				appendMode:    appendMode,
+				bgzip:         args[16].Bool(),
+			}
+			isCSV := fh == singletonCSVFileHandler
+			if isCSV {
+				if tsvOpts.escape == tsvEscapeC {
+					Panicf(ast, "write %v: escape:=\"c\" is not supported for csv files", path)
+				}
+				tsvOpts = csvWriterOpts(tsvOpts)
+			}
+			switch mode := args[13].Str(); mode {
+			case "", "inline":
+			case "file":
+				tsvOpts.nestedTables = true
+			default:
+				Panicf(ast, "write %v: nested_tables '%s': must be \"inline\" or \"file\"", path, mode)
+			}
+			if md := args[11].Value; md.Type() == StructType || (md.Type() == BoolType && md.Bool(ast)) {
+				tsvOpts.metadata = tsvMetadataLines(table, md)
+			}
+			validateTSVFloatFormat(ast, tsvOpts.floatFormat)
+			var (
+				ifChanged = args[15].Bool()
+				writeHash hash.Hash
+				existed   bool
+			)
+			if ifChanged {
+				if appendMode {
+					Panicf(ast, "write %v: if_changed:= cannot be combined with mode:=\"append\"", path)
+				}
+				writeHash = computeWriteHash(table, fh, describeWriteOpts(nShard, tsvOpts, btsvOpts, args[11].Value))
+				if unchangedSinceLastWrite(ctx, ast, path, fh, writeHash) {
+					log.Printf("write %v (%v): unchanged since the last write, skipped", path, fh)
+					return False
+				}
+				existed = writtenFileExists(ctx, ast, path, fh)
+			}
 			log.Printf("write %v (%v): started", path, fh)
-			fh.Write(ctx, path, ast, table, nShard, overwriteFiles)
+			if tsvOpts.bgzip && fh == singletonBEDFileHandler {
+				writeBEDFile(ctx, path, ast, table, overwriteFiles, true)
+			} else if len(tsvOpts.colOrder) > 0 || tsvOpts.floatFormat != "" || tsvOpts.trimFloatZero || tsvOpts.escape != tsvEscapeNone || len(tsvOpts.metadata) > 0 || tsvOpts.nestedTables ||
+				tsvOpts.delimiter != 0 || tsvOpts.bgzip || (appendMode && fh == singletonTSVFileHandler) {
+				if fh != singletonTSVFileHandler && !isCSV {
+					Panicf(ast, "write %v: column_order:=, float_format:=, trim_float_zero:=, escape:=, metadata:=, nested_tables:=, delimiter:=, and bgzip:= are supported only for tsv and csv files, except that bgzip:= is also supported for bed files", path)
+				}
+				writeTSVFile(ctx, path, table, overwriteFiles, tsvOpts)
+			} else if len(btsvOpts.indexCols) > 0 || btsvOpts.dictEncode || appendMode {
+				if fh != singletonBTSVFileHandler {
+					Panicf(ast, "write %v: index:=, dict_encode:=, and mode:= are supported only for btsv files", path)
+				}
+				writeBTSVTable(ctx, path, ast, table, nShard, overwriteFiles, btsvOpts)
+			} else {
+				fh.Write(ctx, path, ast, table, nShard, overwriteFiles)
+			}
+			if !ifChanged {
+				removeWriteHash(ctx, ast, path)
+			} else if overwriteFiles || !existed {
+				recordWriteHash(ctx, ast, path, fh, writeHash)
+			}
 			log.Printf("write %v (%v): finished", path, fh)
 			return True
 		},
 		func(ast ASTNode, args []AIArg) AIType { return AIBoolType },
-		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},                // table
-		FormalArg{Positional: true, Required: true, Types: []ValueType{StringType}},               // path
-		FormalArg{Name: symbol.Shards, Types: []ValueType{IntType}, DefaultValue: NewInt(1)},      // shards:=nnn
-		FormalArg{Name: symbol.Type, Types: []ValueType{StringType}, DefaultValue: NewString("")}, // type:="btsv"
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},                            // table
+		FormalArg{Positional: true, Required: true, Types: []ValueType{StringType}},                           // path
+		FormalArg{Name: symbol.Shards, Types: []ValueType{IntType}, DefaultValue: NewInt(1)},                  // shards:=nnn
+		FormalArg{Name: symbol.Type, Types: []ValueType{StringType}, DefaultValue: NewString("")},             // type:="btsv"
+		FormalArg{Name: symbol.Index, Closure: true, ClosureArgs: anonRowFuncArg, DefaultValue: NewFunc(nil)}, // index:=&col
+		FormalArg{Name: symbol.Row, Symbol: true, DefaultSymbol: symbol.AnonRow},
+		FormalArg{Name: symbol.ColumnOrder, Types: []ValueType{TableType}, DefaultValue: Null},            // column_order:=table("a","b",...)
+		FormalArg{Name: symbol.DictEncode, Types: []ValueType{BoolType}, DefaultValue: False},             // dict_encode:=true
+		FormalArg{Name: symbol.FloatFormat, Types: []ValueType{StringType}, DefaultValue: NewString("")},  // float_format:="%.17g"
+		FormalArg{Name: symbol.TrimFloatZero, Types: []ValueType{BoolType}, DefaultValue: False},          // trim_float_zero:=true
+		FormalArg{Name: symbol.Escape, Types: []ValueType{StringType}, DefaultValue: NewString("")},       // escape:="c"
+		FormalArg{Name: symbol.Metadata, Types: []ValueType{BoolType, StructType}, DefaultValue: False},   // metadata:=true
+		FormalArg{Name: symbol.Mode, Types: []ValueType{StringType}, DefaultValue: NewString("")},         // mode:="append"
+		FormalArg{Name: symbol.NestedTables, Types: []ValueType{StringType}, DefaultValue: NewString("")}, // nested_tables:="file"
+		FormalArg{Name: symbol.Delimiter, Types: []ValueType{StringType}, DefaultValue: NewString("")},    // delimiter:=";"
+		FormalArg{Name: symbol.IfChanged, Types: []ValueType{BoolType}, DefaultValue: False},              // if_changed:=true
+		FormalArg{Name: symbol.Bgzip, Types: []ValueType{BoolType}, DefaultValue: False},                  // bgzip:=true
 	)
 }
 
@@ -76,4 +313,37 @@ as true.
 		FormalArg{Positional: true, Required: true, Types: []ValueType{StringType}},              // template
 		FormalArg{Name: symbol.GZIP, Types: []ValueType{BoolType}, DefaultValue: False},          // gzip:=true
 	)
+
+	RegisterBuiltinFunc("tee",
+		`Usage: tee(table, "path" [,shards:=nnn] [,type:="format"])
+
+Tee writes the table to the file, and returns the table unchanged, so that the
+pipeline can continue. It is useful for saving an intermediate result. The
+arguments are the same as write(). For example,
+
+    read("foo.tsv") | filter($depth > 10) | tee("/tmp/filtered.tsv") | count()
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			table := args[0].Table()
+			path := args[1].Str()
+			var fh FileHandler
+			if t := args[3].Str(); t != "" {
+				fh = GetFileHandlerByName(t)
+			} else {
+				fh = GetFileHandlerByPath(path)
+			}
+			if fh == nil {
+				Panicf(ast, "tee %v: unknown file type", path)
+			}
+			log.Printf("tee %v (%v): started", path, fh)
+			fh.Write(ctx, path, ast, table, int(args[2].Int()), overwriteFiles)
+			log.Printf("tee %v (%v): finished", path, fh)
+			return args[0].Value
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},                // table
+		FormalArg{Positional: true, Required: true, Types: []ValueType{StringType}},               // path
+		FormalArg{Name: symbol.Shards, Types: []ValueType{IntType}, DefaultValue: NewInt(1)},      // shards:=nnn
+		FormalArg{Name: symbol.Type, Types: []ValueType{StringType}, DefaultValue: NewString("")}, // type:="btsv"
+	)
 }
@@ -0,0 +1,192 @@
+package gql
+
+// This file implements write_matrix, which writes a long-format table as a
+// wide feature x sample matrix.
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+)
+
+// matrixManifestPath computes the default path of the samples manifest of
+// write_matrix. For "foo.tsv", it is "foo.samples.tsv".
+func matrixManifestPath(path string) string {
+	for _, ext := range []string{".tsv.gz", ".tsv", ".btsv"} {
+		if strings.HasSuffix(path, ext) {
+			return strings.TrimSuffix(path, ext) + ".samples.tsv"
+		}
+	}
+	return path + ".samples.tsv"
+}
+
+// matrixSamples lists the distinct sample names in the grouped table, in
+// sorted order. Each row of grouped is {key, value}, where value is the table
+// of the long-format rows of the feature.
+func matrixSamples(ctx context.Context, ast ASTNode, grouped Table, sampleExpr *Func) []string {
+	seen := map[string]bool{}
+	sc := grouped.Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		rows, _ := sc.Value().Struct(ast).Value(symbol.Value)
+		rsc := rows.Table(ast).Scanner(ctx, 0, 1, 1)
+		for rsc.Scan() {
+			seen[matrixSampleName(ctx, ast, sampleExpr, rsc.Value())] = true
+		}
+	}
+	samples := make([]string, 0, len(seen))
+	for s := range seen {
+		samples = append(samples, s)
+	}
+	sort.Strings(samples)
+	return samples
+}
+
+// matrixSampleName computes the sample name of a long-format row.
+func matrixSampleName(ctx context.Context, ast ASTNode, sampleExpr *Func, row Value) string {
+	v := sampleExpr.Eval(ctx, row)
+	if v.Type() == NullType {
+		Panicf(ast, "write_matrix: sample is NA in %v", row)
+	}
+	return matrixLabel(ast, v)
+}
+
+// newMatrixWideTable creates a table with one row per feature. Each row has
+// column "feature", followed by one column per sample, in the order of
+// samples. Missing cells are NA.
+func newMatrixWideTable(ast ASTNode, grouped Table, samples []string, sampleExpr, valueExpr *Func) Table {
+	sampleIndex := make(map[string]int, len(samples))
+	sampleCols := make([]symbol.ID, len(samples))
+	h := hash.String("write_matrix").Merge(grouped.Hash()).Merge(sampleExpr.Hash()).Merge(valueExpr.Hash())
+	for i, s := range samples {
+		sampleIndex[s] = i
+		sampleCols[i] = symbol.Intern(s)
+		if sampleCols[i] == symbol.Feature {
+			Panicf(ast, "write_matrix: sample name '%s' conflicts with the feature column", s)
+		}
+		h = h.Merge(hash.String(s))
+	}
+	return &rowTransformTable{
+		src:  grouped,
+		hash: h,
+		fn: func(ctx context.Context, row Value) Value {
+			st := row.Struct(ast)
+			feature, _ := st.Value(symbol.Key)
+			rows, _ := st.Value(symbol.Value)
+			fields := make([]StructField, len(samples)+1)
+			fields[0] = StructField{Name: symbol.Feature, Value: feature}
+			for i, col := range sampleCols {
+				fields[i+1] = StructField{Name: col, Value: Null}
+			}
+			seen := make([]bool, len(samples))
+			sc := rows.Table(ast).Scanner(ctx, 0, 1, 1)
+			for sc.Scan() {
+				sample := matrixSampleName(ctx, ast, sampleExpr, sc.Value())
+				i, ok := sampleIndex[sample]
+				if !ok {
+					Panicf(ast, "write_matrix: sample '%s' not found in the manifest", sample)
+				}
+				if seen[i] {
+					Panicf(ast, "write_matrix: duplicate cell (%v, %s)", feature, sample)
+				}
+				seen[i] = true
+				fields[i+1].Value = valueExpr.Eval(ctx, sc.Value())
+			}
+			return NewStruct(NewSimpleStruct(fields...))
+		},
+	}
+}
+
+// writeMatrixManifest writes the list of samples, one row per matrix column.
+func writeMatrixManifest(ctx context.Context, ast ASTNode, path string, samples []string) {
+	rows := make([]Value, len(samples))
+	h := hash.String("write_matrix_manifest")
+	for i, s := range samples {
+		rows[i] = NewStruct(NewSimpleStruct(
+			StructField{Name: symbol.Index, Value: NewInt(int64(i))},
+			StructField{Name: symbol.Sample, Value: NewString(s)}))
+		h = h.Merge(hash.String(s))
+	}
+	singletonTSVFileHandler.Write(ctx, path, ast, NewSimpleTable(rows, h, TableAttrs{Name: "samples"}), 1, overwriteFiles)
+}
+
+func init() {
+	RegisterBuiltinFunc("write_matrix",
+		`
+    tbl | write_matrix(path, sample:=sampleexpr, feature:=featureexpr, value:=valueexpr [, shards:=n] [, type:=format] [, manifest:=manifestpath])
+
+Arg types:
+
+- _path_: string
+- _sampleexpr_, _featureexpr_, _valueexpr_: one-arg function
+- _n_: int (default: 1)
+- _format_: string (default: "")
+- _manifestpath_: string (default: "")
+
+Write_matrix writes a long-format table, with one row per (sample, feature)
+cell, as a wide feature x sample matrix, as expected by tools such as MultiQC
+and limma. Each row of the output has column "feature", followed by one column
+per sample. A missing cell is NA. It is an error if the table has two rows for
+the same cell.
+
+The rows are grouped by feature in one distributed pass, in the same way as
+[cogroup](#cogroup), so only one feature is held in memory at a time. The
+_shards_ arg sets the parallelism of the grouping and the number of shards of a
+btsv output. The sample columns are sorted by name, so the output is the same
+regardless of the order of the rows of _tbl_.
+
+The _format_ arg is "tsv" or "btsv". If it is omitted, the format is detected
+from the extension of _path_, as in [write](#write).
+
+Write_matrix also writes a samples manifest, a TSV file with columns {index,
+sample} that lists the matrix columns in order. By default, the manifest is
+written next to _path_: for "foo.tsv", it is "foo.samples.tsv".
+
+Example:
+
+    read("counts_long.tsv") | write_matrix("counts.tsv", sample:=&sample_id, feature:=&gene, value:=&count, shards:=64)
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			src := args[0].Table()
+			path := args[1].Str()
+			sampleExpr, featureExpr, valueExpr := args[2].Func(), args[3].Func(), args[4].Func()
+			shards := int(args[5].Int())
+			if shards <= 0 {
+				Panicf(ast, "write_matrix: shards must be >0, but found %d", shards)
+			}
+			var fh FileHandler
+			if t := args[6].Str(); t != "" {
+				fh = GetFileHandlerByName(t)
+			} else {
+				fh = GetFileHandlerByPath(path)
+			}
+			if fh != singletonTSVFileHandler && fh != singletonBTSVFileHandler {
+				Panicf(ast, "write_matrix %v: the file type must be tsv or btsv", path)
+			}
+			manifestPath := args[7].Str()
+			if manifestPath == "" {
+				manifestPath = matrixManifestPath(path)
+			}
+			log.Printf("write_matrix %v (%v): started", path, fh)
+			grouped := newCogroupTable(ctx, ast, src, featureExpr, nil, cogroupOpts{}, shards)
+			samples := matrixSamples(ctx, ast, grouped, sampleExpr)
+			wide := newMatrixWideTable(ast, grouped, samples, sampleExpr, valueExpr)
+			fh.Write(ctx, path, ast, wide, shards, overwriteFiles)
+			writeMatrixManifest(ctx, ast, manifestPath, samples)
+			log.Printf("write_matrix %v (%v): finished, %d samples", path, fh, len(samples))
+			return True
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AIBoolType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},                    // table
+		FormalArg{Positional: true, Required: true, Types: []ValueType{StringType}},                   // path
+		FormalArg{Name: symbol.Sample, Required: true, Closure: true, ClosureArgs: anonRowFuncArg},    // sample:=expr
+		FormalArg{Name: symbol.Feature, Required: true, Closure: true, ClosureArgs: anonRowFuncArg},   // feature:=expr
+		FormalArg{Name: symbol.Value, Required: true, Closure: true, ClosureArgs: anonRowFuncArg},     // value:=expr
+		FormalArg{Name: symbol.Shards, Types: []ValueType{IntType}, DefaultValue: NewInt(1)},          // shards:=nnn
+		FormalArg{Name: symbol.Type, Types: []ValueType{StringType}, DefaultValue: NewString("")},     // type:="btsv"
+		FormalArg{Name: symbol.Manifest, Types: []ValueType{StringType}, DefaultValue: NewString("")}, // manifest:="path"
+		FormalArg{Name: symbol.Row, Symbol: true, DefaultSymbol: symbol.AnonRow})
+}
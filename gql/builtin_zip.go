@@ -0,0 +1,197 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+// zipTable pairs the rows of the source tables positionally.
+type zipTable struct {
+	hash hash.Hash
+	ast  ASTNode
+	srcs []Table
+	// names[i] is the name of the column that stores the rows of srcs[i].
+	names []symbol.ID
+	// fill is true if the shorter tables are padded with NAs.
+	fill bool
+}
+
+func (t *zipTable) Len(ctx context.Context, mode CountMode) int {
+	n := 0
+	for _, src := range t.srcs {
+		if l := src.Len(ctx, mode); l > n {
+			n = l
+		}
+	}
+	return n
+}
+
+func (t *zipTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	MarshalTableOutline(ctx, enc, t)
+}
+func (t *zipTable) Prefetch(ctx context.Context) {}
+func (t *zipTable) Hash() hash.Hash              { return t.hash }
+func (t *zipTable) Attrs(ctx context.Context) TableAttrs {
+	return TableAttrs{Name: "zip"}
+}
+
+// Parallelizable implements ParallelizableTable.
+func (t *zipTable) Parallelizable(ctx context.Context) bool { return false }
+
+func (t *zipTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	if start > 0 {
+		// The shards of the source tables need not be aligned, so zip cannot be
+		// sharded.
+		return &NullTableScanner{}
+	}
+	sc := &zipTableScanner{
+		parent: t,
+		srcs:   make([]TableScanner, len(t.srcs)),
+		done:   make([]bool, len(t.srcs)),
+	}
+	for i, src := range t.srcs {
+		sc.srcs[i] = NewPrefetchingTableScanner(ctx, src.Scanner(ctx, 0, 1, 1), -1)
+	}
+	return sc
+}
+
+type zipTableScanner struct {
+	parent *zipTable
+	srcs   []TableScanner
+	// done[i] becomes true once srcs[i] reaches EOF.
+	done []bool
+	// nRows is the number of rows produced so far.
+	nRows int
+	row   Value
+}
+
+func (sc *zipTableScanner) Value() Value { return sc.row }
+
+func (sc *zipTableScanner) Scan() bool {
+	t := sc.parent
+	fields := make([]StructField, len(sc.srcs))
+	nDone := 0
+	for i, src := range sc.srcs {
+		fields[i] = StructField{Name: t.names[i], Value: Null}
+		if !sc.done[i] && src.Scan() {
+			fields[i].Value = src.Value()
+			continue
+		}
+		sc.done[i] = true
+		nDone++
+	}
+	if nDone == len(sc.srcs) {
+		return false
+	}
+	if nDone > 0 && !t.fill {
+		var short, long symbol.ID
+		for i, done := range sc.done {
+			if done {
+				short = t.names[i]
+			} else {
+				long = t.names[i]
+			}
+		}
+		Panicf(t.ast, "zip: table '%s' has %d rows, but table '%s' has more rows. Pass fill:=true to pad the shorter tables with NA",
+			short.Str(), sc.nRows, long.Str())
+	}
+	sc.nRows++
+	sc.row = NewStruct(NewSimpleStruct(fields...))
+	return true
+}
+
+// tableArgColumnNames computes the names of the columns that store the rows of
+// the tables given to zip or cross. The column for a table is named after the
+// variable if the arg is a variable reference, and "t<index>" otherwise. If two
+// args would produce the same name, the latter is named "t<index>".
+func tableArgColumnNames(args []ActualArg) []symbol.ID {
+	names := make([]symbol.ID, len(args))
+	seen := map[symbol.ID]bool{}
+	for i, arg := range args {
+		var name symbol.ID
+		if ref, ok := arg.Expr.(*ASTVarRef); ok && !seen[ref.Var] {
+			name = ref.Var
+		} else {
+			name = symbol.Intern(fmt.Sprintf("t%d", i))
+		}
+		seen[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+func init() {
+	RegisterBuiltinFunc("zip",
+		`
+    zip(tbl... [, fill:=bool])
+
+Arg types:
+
+- _tbl_: table
+- _bool_: bool (default false)
+
+::zip(tbl0, tbl1, ..., tblN):: pairs the rows of the tables positionally. The
+ith row of the result is a struct that stores the ith rows of the tables. The
+column for a table is named after the variable that stores the table. For a
+table given by another kind of expression, the column is named "tK", where K is
+the 0-based position of the arg. If two args would produce the same column
+name, the latter is also named "tK".
+
+Zip is useful for combining tables that are known to be row-aligned, e.g.,
+per-bin coverage and GC tracks, without constructing keys and joining them.
+
+By default, zip raises an error if the tables have different numbers of rows.
+If fill:=true, the rows of the shorter tables are filled with NA.
+
+Example: Assume table cov is:
+
+        ║bin║ depth║
+        ├───┼──────┤
+        │0  │ 10   │
+        │1  │ 12   │
+
+and table gc is:
+
+        ║bin║ gc  ║
+        ├───┼─────┤
+        │0  │ 0.4 │
+        │1  │ 0.6 │
+
+Then
+
+    zip(cov, gc) | map({bin:cov.bin, depth:cov.depth, gc:gc.gc})
+
+produces
+
+        ║bin║ depth║ gc  ║
+        ├───┼──────┼─────┤
+        │0  │ 10   │ 0.4 │
+        │1  │ 12   │ 0.6 │
+
+Zip reads the tables sequentially, so it does not run in parallel.`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			n := len(args)
+			fill, args := args[n-1].Bool(), args[:n-1]
+			t := &zipTable{
+				ast:   ast,
+				srcs:  make([]Table, len(args)),
+				names: tableArgColumnNames(args),
+				fill:  fill,
+			}
+			h := hash.String("zip").Merge(hash.Bool(fill))
+			for i := range args {
+				t.srcs[i] = args[i].Table()
+				h = h.Merge(t.srcs[i].Hash()).Merge(hash.String(t.names[i].Str()))
+			}
+			t.hash = h
+			return NewTable(t)
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Variadic: true, Types: []ValueType{TableType}},
+		FormalArg{Name: symbol.Fill, Types: []ValueType{BoolType}, DefaultValue: False},
+	)
+}
@@ -3,9 +3,12 @@ package gql
 import (
 	"context"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/grailbio/base/errors"
@@ -21,9 +24,24 @@ import (
 // caller should produce cache contents in the given file, then call activateCache
 // once done to activate the cache entry.
 //
+// Until ActivateCache is called, the new path is treated as a temp file: it is
+// removed by Session.Close or CleanupTempFiles. The caller should also defer
+// abandonCacheEntry, so that if it fails or panics before activating the entry,
+// the lease is released right away instead of when the session is closed.
+//
+// The cache may be shared by multiple processes, e.g., when cacheRoot is an S3
+// prefix. When the entry is not found, LookupCache takes a lease on the name so
+// that other processes looking up the same name wait for this process to call
+// ActivateCache, instead of computing the same table concurrently. A lease
+// expires after Opts.CacheLeaseTimeout.
+//
+// If Opts.LocalCacheDir is set, entries found in cacheRoot are copied to the
+// local directory on first access, and subsequent lookups are served locally.
+//
 // Example:
 //   path, found := lookupCache("foo.btsv")
 //   if !found {
+//     defer abandonCacheEntry(ctx, path)
 //     w := NewBTSVShardWriter(path, 0, 1, TableAttrs{})
 //     .. fill w ...
 //     w.Close()
@@ -32,7 +50,55 @@ import (
 //   r := NewBTSVTable(path, ...)
 //   ... use r ...
 func LookupCache(ctx context.Context, name string) (string, bool) {
-	absPath := fmt.Sprintf("%s/%s.link", cacheRoot, name)
+	if root := localLookupRoot(); root != "" {
+		if path, found := readCacheLink(ctx, root, name); found {
+			return path, true
+		}
+	}
+	for {
+		if path, found := readCacheLink(ctx, cacheRoot, name); found {
+			if readThroughCacheEnabled() {
+				path = copyToLocalCache(ctx, name, path)
+			}
+			return path, true
+		}
+		if readOnlyCache || acquireCacheLease(ctx, name) {
+			path := generateUniqueCachePath(name)
+			addPendingCacheEntry(ctx, name, path)
+			return path, false
+		}
+		// Someone else is producing the cache entry. Wait for them to finish,
+		// or for the lease to expire.
+		log.Printf("lookupCache %s: waiting for another process to fill the cache", name)
+		if err := sleepCtx(ctx, cacheLeasePollInterval); err != nil {
+			log.Panicf("lookupCache %s: %v", name, err)
+		}
+	}
+}
+
+// findCacheEntry looks up "name" in the cache. If found, it returns the
+// abspathname of the data and true. Unlike LookupCache, it doesn't take a lease
+// on a missing entry.
+func findCacheEntry(ctx context.Context, name string) (string, bool) {
+	if root := localLookupRoot(); root != "" {
+		if path, found := readCacheLink(ctx, root, name); found {
+			return path, true
+		}
+	}
+	return readCacheLink(ctx, cacheRoot, name)
+}
+
+// cacheEntryExists checks if the named cache entry exists. Unlike LookupCache,
+// it doesn't take a lease on a missing entry.
+func cacheEntryExists(ctx context.Context, name string) bool {
+	_, found := findCacheEntry(ctx, name)
+	return found
+}
+
+// readCacheLink reads root/name.link. It returns the contents of the file and
+// true on success.
+func readCacheLink(ctx context.Context, root, name string) (string, bool) {
+	absPath := fmt.Sprintf("%s/%s.link", root, name)
 	backoff := retry.Backoff(500*time.Millisecond, time.Minute, 1.2)
 	var (
 		data []byte
@@ -53,7 +119,7 @@ func LookupCache(ctx context.Context, name string) (string, bool) {
 	if err == nil {
 		return string(data), true
 	}
-	return generateUniqueCachePath(name), false
+	return "", false
 }
 
 // GenerateUniqueCachePath generates a unique path using "name" as a template.
@@ -64,7 +130,7 @@ func generateUniqueCachePath(name string) string {
 	ext := filepath.Ext(name)
 	prefix := name[:len(name)-len(ext)]
 	return fmt.Sprintf("%s/%s-%016x-%x-%x%s",
-		cacheRoot, prefix, time.Now().UnixNano(), rand.Uint64(), rand.Uint64(), ext)
+		cacheWriteRoot(), prefix, time.Now().UnixNano(), rand.Uint64(), rand.Uint64(), ext)
 }
 
 // GenerateStableCachePath generates a stable path using "name" as a template.
@@ -72,21 +138,229 @@ func generateUniqueCachePath(name string) string {
 // of the form cacheRoot/name but this may change in
 // future implementations.
 func GenerateStableCachePath(name string) string {
-	return file.Join(cacheRoot, name)
+	return file.Join(cacheWriteRoot(), name)
 }
 
 // activateCache arranges so that future calls to lookupCache(name) will return
 // uniquePath.  This function is implemented by creating a symlink-like file
 // that stores the uniquePath as the contents.
+//
+// If the cache is read-only, the entry is activated only in the local cache
+// directory. Otherwise, the lease acquired by LookupCache is released.
 func ActivateCache(ctx context.Context, name, uniquePath string) {
-	absPath := fmt.Sprintf("%s/%s.link", cacheRoot, name)
-	err := file.WriteFile(ctx, absPath, []byte(uniquePath))
-	if err != nil {
+	writeCacheLink(ctx, name, uniquePath)
+	promotePendingCacheEntries(ctx, name)
+	if !readOnlyCache {
+		releaseCacheLease(ctx, name)
+	}
+}
+
+// writeCacheLink arranges so that future calls to lookupCache(name) will return
+// uniquePath. Unlike ActivateCache, it leaves the lease on name and the pending
+// entries for name alone, so it is used when the caller didn't take the lease
+// by LookupCache.
+func writeCacheLink(ctx context.Context, name, uniquePath string) {
+	absPath := fmt.Sprintf("%s/%s.link", cacheWriteRoot(), name)
+	if err := file.WriteFile(ctx, absPath, []byte(uniquePath)); err != nil {
 		log.Panicf("activateCache %s <- %s: %v", absPath, uniquePath, err)
 	}
 }
 
+// readOnlyCacheRoot is the directory under which new cache entries are created
+// when the cache is read-only and Opts.LocalCacheDir is unset. Variable for
+// unittests.
+var readOnlyCacheRoot = DefaultLocalCacheRoot
+
+// cacheWriteRoot returns the directory under which new cache entries are
+// created.
+func cacheWriteRoot() string {
+	if readOnlyCache {
+		if localCacheRoot != "" {
+			return localCacheRoot
+		}
+		return readOnlyCacheRoot
+	}
+	return cacheRoot
+}
+
+// localLookupRoot returns the directory that LookupCache checks before
+// cacheRoot, or "" if there is none. It holds the entries copied by the
+// read-through cache, as well as the entries created while the cache is
+// read-only.
+func localLookupRoot() string {
+	if readThroughCacheEnabled() {
+		return localCacheRoot
+	}
+	if root := cacheWriteRoot(); root != cacheRoot {
+		return root
+	}
+	return ""
+}
+
+// readThroughCacheEnabled checks if cache entries found in cacheRoot should be
+// copied to localCacheRoot.
+func readThroughCacheEnabled() bool {
+	return localCacheRoot != "" && localCacheRoot != cacheRoot
+}
+
+// copyToLocalCache copies the cache entry at remotePath to localCacheRoot and
+// activates it there. It returns the local pathname. Errors are logged, and in
+// such case remotePath is returned.
+func copyToLocalCache(ctx context.Context, name, remotePath string) string {
+	ext := filepath.Ext(name)
+	localPath := fmt.Sprintf("%s/%s-%016x-%x%s",
+		localCacheRoot, name[:len(name)-len(ext)], time.Now().UnixNano(), rand.Uint64(), ext)
+	if err := copyCacheTree(ctx, remotePath, localPath); err != nil {
+		log.Error.Printf("lookupCache %s: copy %s to %s: %v", name, remotePath, localPath, err)
+		file.RemoveAll(ctx, localPath) // nolint: errcheck
+		return remotePath
+	}
+	absPath := fmt.Sprintf("%s/%s.link", localCacheRoot, name)
+	if err := file.WriteFile(ctx, absPath, []byte(localPath)); err != nil {
+		log.Error.Printf("lookupCache %s: %v", absPath, err)
+	}
+	log.Debug.Printf("lookupCache %s: copied %s to %s", name, remotePath, localPath)
+	return localPath
+}
+
+// copyCacheTree copies src to dst. Src may be a file or a directory, as in a
+// btsv table.
+func copyCacheTree(ctx context.Context, src, dst string) error {
+	if _, err := file.Stat(ctx, src); err == nil {
+		return copyCacheFile(ctx, src, dst)
+	}
+	l := file.List(ctx, src, true)
+	for l.Scan() {
+		if l.IsDir() {
+			continue
+		}
+		if err := copyCacheFile(ctx, l.Path(), dst+strings.TrimPrefix(l.Path(), src)); err != nil {
+			return err
+		}
+	}
+	return l.Err()
+}
+
+func copyCacheFile(ctx context.Context, src, dst string) (err error) {
+	in, err := file.Open(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer in.Close(ctx) // nolint: errcheck
+	out, err := file.Create(ctx, dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out.Writer(ctx), in.Reader(ctx)); err != nil {
+		out.Close(ctx) // nolint: errcheck
+		return err
+	}
+	return out.Close(ctx)
+}
+
+// Parameters of the cache lease protocol. Variables for unittests.
+var (
+	// cacheLeasePollInterval is the interval at which a process waiting for
+	// another process's lease rechecks the cache.
+	cacheLeasePollInterval = 10 * time.Second
+	// cacheLeaseSettleTime is the time to wait after writing a lease file before
+	// reading it back to check that we won the race. It is needed only for
+	// remote stores, such as S3, that lack an atomic create-if-absent operation.
+	cacheLeaseSettleTime = 2 * time.Second
+)
+
+var (
+	cacheLeaseOwnerOnce sync.Once
+	cacheLeaseOwner     string
+)
+
+// getCacheLeaseOwner returns a string that uniquely identifies this process.
+func getCacheLeaseOwner() string {
+	cacheLeaseOwnerOnce.Do(func() {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "unknown"
+		}
+		cacheLeaseOwner = fmt.Sprintf("%s:%d:%x", host, os.Getpid(), rand.Uint64())
+	})
+	return cacheLeaseOwner
+}
+
+// cacheLease is stored in file cacheRoot/name.lease while a process is
+// producing the cache entry for name. It is encoded as "owner expiration",
+// where expiration is in unix nanoseconds.
+type cacheLease struct {
+	owner   string
+	expires time.Time
+}
+
+func cacheLeasePath(name string) string {
+	return fmt.Sprintf("%s/%s.lease", cacheRoot, name)
+}
+
+func readCacheLease(ctx context.Context, name string) (cacheLease, bool) {
+	data, err := file.ReadFile(ctx, cacheLeasePath(name))
+	if err != nil {
+		return cacheLease{}, false
+	}
+	var (
+		lease   cacheLease
+		expires int64
+	)
+	if _, err := fmt.Sscanf(string(data), "%s %d", &lease.owner, &expires); err != nil {
+		log.Error.Printf("lookupCache %s: corrupt lease %q: %v", cacheLeasePath(name), data, err)
+		return cacheLease{}, false
+	}
+	lease.expires = time.Unix(0, expires)
+	return lease, true
+}
+
+// acquireCacheLease tries to become the (sole) producer of the cache entry for
+// name. It returns false if another process holds an unexpired lease.
+func acquireCacheLease(ctx context.Context, name string) bool {
+	owner := getCacheLeaseOwner()
+	if lease, ok := readCacheLease(ctx, name); ok && lease.owner != owner && time.Now().Before(lease.expires) {
+		return false
+	}
+	data := fmt.Sprintf("%s %d", owner, time.Now().Add(cacheLeaseTimeout).UnixNano())
+	if err := file.WriteFile(ctx, cacheLeasePath(name), []byte(data)); err != nil {
+		// Failure to take a lease isn't fatal. At worst, we produce the same
+		// cache entry twice.
+		log.Error.Printf("lookupCache %s: write lease: %v", cacheLeasePath(name), err)
+		return true
+	}
+	if strings.HasPrefix(cacheRoot, "s3://") {
+		if err := sleepCtx(ctx, cacheLeaseSettleTime); err != nil {
+			log.Panicf("lookupCache %s: %v", name, err)
+		}
+	}
+	lease, ok := readCacheLease(ctx, name)
+	return !ok || lease.owner == owner
+}
+
+// releaseCacheLease removes the lease file for name, if we own it.
+func releaseCacheLease(ctx context.Context, name string) {
+	if lease, ok := readCacheLease(ctx, name); !ok || lease.owner != getCacheLeaseOwner() {
+		return
+	}
+	if err := file.Remove(ctx, cacheLeasePath(name)); err != nil {
+		log.Error.Printf("activateCache %s: remove lease: %v", cacheLeasePath(name), err)
+	}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
 // TestClearCache deletes all the files in cacheRoot. For unittests only.
 func TestClearCache() {
 	os.RemoveAll(cacheRoot) // nolint: errcheck
+	if localCacheRoot != "" {
+		os.RemoveAll(localCacheRoot) // nolint: errcheck
+	}
 }
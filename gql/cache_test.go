@@ -2,8 +2,12 @@ package gql
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/grailbio/testutil"
 	"github.com/grailbio/testutil/expect"
@@ -27,3 +31,146 @@ func TestCache(t *testing.T) {
 	expect.True(t, found)
 	expect.EQ(t, path2, path)
 }
+
+func TestCacheReadThrough(t *testing.T) {
+	remoteDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	localDir, cleanup2 := testutil.TempDir(t, "", "")
+	defer cleanup2()
+	ctx := context.Background()
+
+	cacheRoot, localCacheRoot = remoteDir, localDir
+	defer func() { localCacheRoot = "" }()
+	name := "testcache.txt"
+	path, found := LookupCache(ctx, name)
+	expect.False(t, found)
+	expect.That(t, path, h.HasPrefix(remoteDir))
+	expect.NoError(t, ioutil.WriteFile(path, []byte("blah"), 0600))
+	ActivateCache(ctx, name, path)
+
+	path2, found := LookupCache(ctx, name)
+	expect.True(t, found)
+	expect.That(t, path2, h.HasPrefix(localDir))
+	data, err := ioutil.ReadFile(path2)
+	expect.NoError(t, err)
+	expect.EQ(t, string(data), "blah")
+
+	// The second lookup is served from the local cache.
+	path3, found := LookupCache(ctx, name)
+	expect.True(t, found)
+	expect.EQ(t, path3, path2)
+}
+
+func TestCacheReadOnly(t *testing.T) {
+	remoteDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	localDir, cleanup2 := testutil.TempDir(t, "", "")
+	defer cleanup2()
+	ctx := context.Background()
+
+	cacheRoot, localCacheRoot, readOnlyCache = remoteDir, localDir, true
+	defer func() { localCacheRoot, readOnlyCache = "", false }()
+	name := "testcache.txt"
+	path, found := LookupCache(ctx, name)
+	expect.False(t, found)
+	expect.That(t, path, h.HasPrefix(localDir))
+	expect.NoError(t, ioutil.WriteFile(path, []byte("blah"), 0600))
+	ActivateCache(ctx, name, path)
+
+	_, err := os.Stat(filepath.Join(remoteDir, name+".link"))
+	expect.True(t, os.IsNotExist(err))
+	path2, found := LookupCache(ctx, name)
+	expect.True(t, found)
+	expect.EQ(t, path2, path)
+}
+
+func TestCacheReadOnlyDefaultLocalDir(t *testing.T) {
+	remoteDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	localDir, cleanup2 := testutil.TempDir(t, "", "")
+	defer cleanup2()
+	ctx := context.Background()
+
+	// Without Opts.LocalCacheDir, new entries are created under
+	// readOnlyCacheRoot, and the lookups must find them there.
+	oldRoot := readOnlyCacheRoot
+	cacheRoot, readOnlyCacheRoot, readOnlyCache = remoteDir, localDir, true
+	defer func() { readOnlyCacheRoot, readOnlyCache = oldRoot, false }()
+	name := "testcache.txt"
+	path, found := LookupCache(ctx, name)
+	expect.False(t, found)
+	expect.That(t, path, h.HasPrefix(localDir))
+	expect.False(t, cacheEntryExists(ctx, name))
+	expect.NoError(t, ioutil.WriteFile(path, []byte("blah"), 0600))
+	ActivateCache(ctx, name, path)
+
+	_, err := os.Stat(filepath.Join(remoteDir, name+".link"))
+	expect.True(t, os.IsNotExist(err))
+	expect.True(t, cacheEntryExists(ctx, name))
+	path2, found := LookupCache(ctx, name)
+	expect.True(t, found)
+	expect.EQ(t, path2, path)
+}
+
+func TestCacheLease(t *testing.T) {
+	tempDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+
+	cacheRoot = tempDir
+	oldInterval := cacheLeasePollInterval
+	cacheLeasePollInterval = 10 * time.Millisecond
+	defer func() { cacheLeasePollInterval = oldInterval }()
+
+	// Another process holds an unexpired lease. LookupCache should wait until the
+	// other process activates the cache entry.
+	name := "testcache.txt"
+	expect.NoError(t, ioutil.WriteFile(cacheLeasePath(name),
+		[]byte(fmt.Sprintf("otherhost:1:1 %d", time.Now().Add(time.Hour).UnixNano())), 0600))
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		expect.NoError(t, ioutil.WriteFile(filepath.Join(tempDir, "other.txt"), []byte("blah"), 0600))
+		expect.NoError(t, ioutil.WriteFile(filepath.Join(tempDir, name+".link"), []byte(filepath.Join(tempDir, "other.txt")), 0600))
+	}()
+	path, found := LookupCache(ctx, name)
+	expect.True(t, found)
+	expect.EQ(t, path, filepath.Join(tempDir, "other.txt"))
+
+	// An expired lease is taken over.
+	name = "testcache2.txt"
+	expect.NoError(t, ioutil.WriteFile(cacheLeasePath(name),
+		[]byte(fmt.Sprintf("otherhost:1:1 %d", time.Now().Add(-time.Second).UnixNano())), 0600))
+	path, found = LookupCache(ctx, name)
+	expect.False(t, found)
+	lease, ok := readCacheLease(ctx, name)
+	expect.True(t, ok)
+	expect.EQ(t, lease.owner, getCacheLeaseOwner())
+	expect.NoError(t, ioutil.WriteFile(path, []byte("blah"), 0600))
+	ActivateCache(ctx, name, path)
+	_, err := os.Stat(cacheLeasePath(name))
+	expect.True(t, os.IsNotExist(err))
+}
+
+func TestCacheLeaseReleasedOnPanic(t *testing.T) {
+	tempDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+
+	cacheRoot = tempDir
+	name := "testcache.txt"
+	produce := func() {
+		path, found := LookupCache(ctx, name)
+		expect.False(t, found)
+		defer abandonCacheEntry(ctx, path)
+		expect.NoError(t, ioutil.WriteFile(path, []byte("partial"), 0600))
+		panic("producer failed")
+	}
+	expect.That(t, produce, h.Panics(h.EQ("producer failed")))
+	// The lease is released without closing the session, so that another
+	// process can produce the entry.
+	_, err := os.Stat(cacheLeasePath(name))
+	expect.True(t, os.IsNotExist(err))
+	path, found := LookupCache(ctx, name)
+	expect.False(t, found)
+	abandonCacheEntry(ctx, path)
+}
@@ -0,0 +1,62 @@
+package gql_test
+
+import (
+	"testing"
+
+	"github.com/grailbio/gql/gqltest"
+	"github.com/grailbio/testutil/expect"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKMeans(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `pts := table(
+		{id:"a", x:0, y:0.0},
+		{id:"b", x:1, y:0.0},
+		{id:"c", x:10, y:10.0},
+		{id:"d", x:11, y:10.0},
+		{id:"e", x:0, y:1.0})`, env)
+
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `km := pts | kmeans(k:=2, seed:=1); km.clusters | map({$id, same:$cluster == pick(km.clusters, $id=="a").cluster})`, env)),
+		[]string{"{id:a,same:true}", "{id:b,same:true}", "{id:c,same:false}", "{id:d,same:false}", "{id:e,same:true}"})
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `km.centers | sort($size) | map({$size, $x, $y})`, env)),
+		[]string{"{size:2,x:10.5,y:10}", "{size:3,x:0.3333333333333333,y:0.3333333333333333}"})
+	// A column can be selected explicitly.
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `(pts | kmeans("x", k:=2)).centers | sort($size) | map({$size, $x})`, env)),
+		[]string{"{size:2,x:10.5}", "{size:3,x:0.3333333333333333}"})
+	assert.Panics(t, func() { gqltest.Eval(t, `pts | kmeans(k:=10)`, env) })
+}
+
+func TestHClust(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `pts := table(
+		{id:"a", x:0.0},
+		{id:"b", x:1.0},
+		{id:"c", x:10.0},
+		{id:"d", x:12.0},
+		{id:"e", x:30.0})`, env)
+
+	for _, linkage := range []string{"single", "complete", "average"} {
+		expect.EQ(t,
+			gqltest.ReadTable(gqltest.Eval(t, `(pts | hclust(cut:=2.5, linkage:="`+linkage+`")).clusters`, env)),
+			[]string{"{id:a,cluster:0}", "{id:b,cluster:0}", "{id:c,cluster:1}", "{id:d,cluster:1}", "{id:e,cluster:2}"})
+		expect.EQ(t,
+			gqltest.ReadTable(gqltest.Eval(t, `(pts | hclust(k:=5, linkage:="`+linkage+`")).clusters | map($cluster)`, env)),
+			[]string{"0", "1", "2", "3", "4"})
+	}
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `(pts | hclust(k:=2, linkage:="single")).merges`, env)),
+		[]string{
+			"{step:0,height:1,size:2}",
+			"{step:1,height:2,size:2}",
+			"{step:2,height:9,size:4}",
+			"{step:3,height:18,size:5}",
+		})
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `(pts | hclust(k:=2, linkage:="single")).clusters | map($cluster)`, env)),
+		[]string{"0", "0", "0", "0", "1"})
+	assert.Panics(t, func() { gqltest.Eval(t, `pts | hclust()`, env) })
+}
@@ -60,6 +60,62 @@ func testSmallCogroup(t *testing.T, parallel bool) {
 func TestSmallCogroup(t *testing.T)         { testSmallCogroup(t, false) }
 func TestSmallCogroupParallel(t *testing.T) { testSmallCogroup(t, true) }
 
+func testCogroupKeyColumns(t *testing.T, parallel bool) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `t0 := table(
+{i:0, j:"a", s:1},
+{i:3, j:"b", s:2},
+{i:0, j:"a", s:3},
+{i:3, j:"c", s:4},
+{i:1, j:"a", s:5})`, env)
+	expect.EQ(t,
+		evalCogroup(t, `cogroup(t0, {&i, &j}, map:=&s, key_columns:=true, count:=true)`, parallel, env),
+		[]string{
+			"{i:0,j:a,count:2,value:[1,3]}",
+			"{i:1,j:a,count:1,value:[5]}",
+			"{i:3,j:b,count:1,value:[2]}",
+			"{i:3,j:c,count:1,value:[4]}",
+		})
+	// A scalar key stays in the "key" column.
+	expect.EQ(t,
+		evalCogroup(t, `cogroup(t0, &i, map:=&s, key_columns:=true, count:=true, having:=&count >= 2)`, parallel, env),
+		[]string{
+			"{key:0,count:2,value:[1,3]}",
+			"{key:3,count:2,value:[2,4]}",
+		})
+	expect.EQ(t,
+		evalCogroup(t, `cogroup(t0, &i, map:=&s, having:=&key != 0 && count(&value) < 5)`, parallel, env),
+		[]string{
+			"{key:1,value:[5]}",
+			"{key:3,value:[2,4]}",
+		})
+	if !parallel {
+		expect.That(t,
+			func() { evalCogroup(t, `cogroup(t0, {value:&i}, key_columns:=true)`, parallel, env) },
+			h.Panics(h.Regexp("key field 'value' collides")))
+	}
+}
+
+func TestCogroupKeyColumns(t *testing.T)         { testCogroupKeyColumns(t, false) }
+func TestCogroupKeyColumnsParallel(t *testing.T) { testCogroupKeyColumns(t, true) }
+
+func TestSmallCogroupLocal(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `t0 := table({i:0, s:1}, {i:3, s:2}, {i:0, s:3}, {i:3, s:4}, {i:1, s:5})`, env)
+	// With shards:=0, the rows are grouped in process, in the same order as
+	// with shards:=1.
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `t0 | cogroup($i, map:=$s, shards:=0)`, env)),
+		[]string{
+			"{key:0,value:[1,3]}",
+			"{key:1,value:[5]}",
+			"{key:3,value:[2,4]}",
+		})
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `t0 | cogroup($i, shards:=0) | map({$key, n:count($value)})`, env)),
+		gqltest.ReadTable(gqltest.Eval(t, `t0 | cogroup($i, shards:=1) | map({$key, n:count($value)})`, env)))
+}
+
 func testNestedCogroup1(t *testing.T, parallel bool) {
 	env := gqltest.NewSession()
 	gqltest.Eval(t, `t0 := table(
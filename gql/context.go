@@ -5,6 +5,10 @@ import "context"
 // CheckCancellation checks if ctx has been cancelled and panics if so.
 func CheckCancellation(ctx context.Context) {
 	if err := ctx.Err(); err != nil {
+		if b := evalBudgetFromContext(ctx); b != nil && err == context.DeadlineExceeded {
+			// Report the exceeded limit instead of the bare context error.
+			b.checkDuration()
+		}
 		panic("Cancelled: " + err.Error())
 	}
 }
@@ -0,0 +1,46 @@
+package gql
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountLargeTSV(t *testing.T) {
+	tempDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	defer func(old int) { MaxTSVRowsInMemory = old }(MaxTSVRowsInMemory)
+	MaxTSVRowsInMemory = 10
+	sess := newSession()
+
+	var data strings.Builder
+	data.WriteString("a\tb\n")
+	for i := 0; i < 25; i++ {
+		if i == 12 {
+			data.WriteString("# comment\n")
+		}
+		fmt.Fprintf(&data, "%d\tx%d\n", i, i)
+	}
+	path := filepath.Join(tempDir, "large.tsv")
+	require.NoError(t, ioutil.WriteFile(path, []byte(data.String()), 0600))
+	doEval(t, fmt.Sprintf("t0 := read(`%s`)", path), sess)
+
+	for _, test := range []struct {
+		expr string
+		want int64
+	}{
+		{"t0 | count()", 25},
+		{"t0 | map({c:$a*2}) | count()", 25},
+		{"flatten(table(t0, t0)) | count()", 50},
+		{"t0 | filter($a < 5) | count()", 5},
+		{"t0 | map({c:$a*2}, filter:=$a>=20) | count()", 5},
+	} {
+		assert.Equal(t, test.want, doEval(t, test.expr, sess).Int(nil), test.expr)
+	}
+}
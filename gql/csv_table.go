@@ -0,0 +1,58 @@
+package gql
+
+// This file implements the handler for CSV files. A CSV file is read and
+// written by the TSV code, with ',' as the delimiter. Cells are quoted as
+// specified in RFC4180.
+
+import (
+	"context"
+
+	"github.com/grailbio/gql/hash"
+)
+
+// csvFileHandler is a FileHandler implementation for CSV files.
+type csvFileHandler struct{}
+
+var singletonCSVFileHandler = &csvFileHandler{}
+
+// CSVFileHandler returns the FileHandler for CSV files.
+func CSVFileHandler() FileHandler {
+	return singletonCSVFileHandler
+}
+
+// Name implements FileHandler.
+func (*csvFileHandler) Name() string { return "csv" }
+
+// Open implements FileHandler.
+func (fh *csvFileHandler) Open(ctx context.Context, path string, ast ASTNode, hash hash.Hash) Table {
+	return newTSVTableWithOpts(path, ast, hash, fh, tsvReadOpts{})
+}
+
+// Write implements FileHandler.
+func (*csvFileHandler) Write(ctx context.Context, path string, ast ASTNode, table Table, nShard int, overwrite bool) {
+	writeTSVFile(ctx, path, table, overwrite, csvWriterOpts(tsvWriterOpts{}))
+}
+
+// csvWriterOpts adjusts the options for writing a TSV file to write a CSV file
+// instead. The cells are always quoted as needed, since a CSV cell often
+// contains a comma.
+func csvWriterOpts(opts tsvWriterOpts) tsvWriterOpts {
+	if opts.delimiter == 0 {
+		opts.delimiter = ','
+	}
+	opts.escape = tsvEscapeQuote
+	return opts
+}
+
+// tsvDefaultDelimiter returns the delimiter of the cells of a file read by the
+// given handler.
+func tsvDefaultDelimiter(fh FileHandler) rune {
+	if fh == singletonCSVFileHandler {
+		return ','
+	}
+	return '\t'
+}
+
+func init() {
+	RegisterFileHandler(singletonCSVFileHandler, `\.csv`+OptionalCompression)
+}
@@ -0,0 +1,50 @@
+package gql
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grailbio/gql/symbol"
+	"github.com/grailbio/testutil/expect"
+	"github.com/grailbio/testutil/h"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLongPipeline(t *testing.T) {
+	sess := newSession()
+	expr := "table({a:0})" + strings.Repeat(" | map({a:$a+1})", 300)
+	assert.Equal(t, []string{"{a:300}"}, doReadTable(doEval(t, expr, sess)))
+
+	expr = "table({a:0}, {a:1})" + strings.Repeat(" | filter($a >= 0) | map({a:$a})", 100)
+	assert.Equal(t, []string{"{a:0}", "{a:1}"}, doReadTable(doEval(t, expr, sess)))
+
+	old := maxExprDepth
+	defer func() { maxExprDepth = old }()
+	maxExprDepth = 50
+	expect.That(t,
+		func() { doEval(t, "table({a:0})"+strings.Repeat(" | map({a:$a+1})", 100), sess) },
+		h.Panics(h.Regexp("expression is nested more than 50 levels deep")))
+	// The session remains usable after the error.
+	assert.Equal(t, []string{"{a:3}"}, doReadTable(doEval(t, "table({a:0})"+strings.Repeat(" | map({a:$a+1})", 3), sess)))
+}
+
+func TestPrintDeepStruct(t *testing.T) {
+	newSession()
+	v := NewInt(1)
+	for i := 0; i < 3; i++ {
+		v = NewStruct(NewSimpleStruct(StructField{Name: symbol.Intern("x"), Value: v}, StructField{Name: symbol.Intern("y"), Value: NewInt(int64(i))}))
+	}
+	assert.Equal(t, "{x:{x:{x:1,y:0},y:1},y:2}", v.String())
+
+	old := maxExprDepth
+	defer func() { maxExprDepth = old }()
+	maxExprDepth = 2
+	assert.Equal(t, "{x:{x:{...},y:1},y:2}", v.String())
+
+	maxExprDepth = 100
+	v = NewInt(1)
+	for i := 0; i < 200; i++ {
+		v = NewStruct(NewSimpleStruct(StructField{Name: symbol.Intern("x"), Value: v}))
+	}
+	assert.Equal(t, strings.Repeat("{x:", 100)+"{...}"+strings.Repeat("}", 100), v.String())
+}
@@ -0,0 +1,191 @@
+package gql
+
+// This file detects and rewrites deprecated GQL syntax:
+//
+// - "$col" column references. They should be written as "&col".
+//
+// - Functions of form "func(args) {...}". They should be written as
+//   "|args| ...". A toplevel "f := func(args) {...}" should be written as
+//   "func f(args) ...".
+
+import (
+	"fmt"
+	"sort"
+	"text/scanner"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/symbol"
+)
+
+// legacyFunctionBody is the body of a deprecated "func(args) {...}" function.
+// It is created by the parser.
+type legacyFunctionBody struct {
+	statements []ASTStatement
+	// bare is true if the body is of form "{ expr }", which is not a valid
+	// expression by itself. open and close are the positions of the braces.
+	bare        bool
+	open, close scanner.Position
+	// lparen and rparen are the positions of the parens around the args.
+	lparen, rparen scanner.Position
+}
+
+// newLegacyASTLambda creates an ASTLambda for "func(args) {...}".
+func newLegacyASTLambda(pos, lparen scanner.Position, params []string, rparen scanner.Position, body legacyFunctionBody) *ASTLambda {
+	n := NewASTLambda(pos, params, &ASTBlock{Pos: pos, Statements: body.statements})
+	body.lparen, body.rparen = lparen, rparen
+	n.legacy = &body
+	return n
+}
+
+// Deprecation describes a use of deprecated syntax.
+type Deprecation struct {
+	// Pos is the location of the deprecated construct.
+	Pos scanner.Position
+	// Message explains the problem and the replacement.
+	Message string
+	// edits rewrite the construct into the new syntax.
+	edits []textEdit
+}
+
+// textEdit replaces text[offset:offset+n] with "text". If trimSemicolon is
+// set, a ';' that precedes the range, possibly followed by spaces, is also
+// removed.
+type textEdit struct {
+	offset, n     int
+	text          string
+	trimSemicolon bool
+}
+
+// findDeprecations lists the uses of deprecated syntax in the statements. It
+// must be called before the statements are analyzed.
+func findDeprecations(statements []ASTStatementOrLoad) []Deprecation {
+	var deps []Deprecation
+	for i := range statements {
+		st := &statements[i]
+		if st.LoadPath != "" {
+			continue
+		}
+		var assigned *ASTLambda
+		if st.LHS != symbol.Invalid {
+			assigned, _ = st.Expr.(*ASTLambda)
+		}
+		visitRawASTTree(&st.Expr, func(nptr *ASTNode) bool {
+			switch n := (*nptr).(type) {
+			case *ASTColumnRef:
+				if n.Deprecated {
+					deps = append(deps, Deprecation{
+						Pos:     n.Pos,
+						Message: fmt.Sprintf("$-syntax is deprecated; write &%s instead. See https://phabricator.grailbio.com/w/docs/gql/#8-expansion for more details", n.Col.Str()),
+						edits:   []textEdit{{offset: n.Pos.Offset, n: 1, text: "&"}},
+					})
+				}
+			case *ASTLambda:
+				if n.legacy != nil {
+					deps = append(deps, legacyLambdaDeprecation(&st.ASTStatement, n, n == assigned))
+				}
+			}
+			return true
+		})
+	}
+	return deps
+}
+
+// legacyLambdaDeprecation creates a Deprecation for "func(args) {...}". If
+// assigned is true, the function is the value of toplevel statement st.
+func legacyLambdaDeprecation(st *ASTStatement, n *ASTLambda, assigned bool) Deprecation {
+	l := n.legacy
+	d := Deprecation{Pos: n.Pos}
+	if assigned {
+		d.Message = fmt.Sprintf("'%s := func(...) {...}' is deprecated; write 'func %s(...) ...' instead", st.LHS.Str(), st.LHS.Str())
+		// "f := func(" -> "func f("
+		d.edits = append(d.edits, textEdit{
+			offset: st.Pos.Offset,
+			n:      n.Pos.Offset + len("func") - st.Pos.Offset,
+			text:   "func " + st.LHS.Str()})
+	} else {
+		d.Message = "'func(...) {...}' is deprecated; write '|...| ...' instead"
+		// "func(args)" -> "|args|"
+		d.edits = append(d.edits,
+			textEdit{offset: n.Pos.Offset, n: l.lparen.Offset + 1 - n.Pos.Offset, text: "|"},
+			textEdit{offset: l.rparen.Offset, n: 1, text: "|"})
+	}
+	if l.bare {
+		// "{ expr }" is a struct literal, so turn it into "( expr )".
+		d.edits = append(d.edits,
+			textEdit{offset: l.open.Offset, n: 1, text: "("},
+			textEdit{offset: l.close.Offset, n: 1, text: ")", trimSemicolon: true})
+	}
+	return d
+}
+
+// FixDeprecatedSyntax parses a script and rewrites deprecated constructs in it
+// into the current syntax. It returns the new script and the list of
+// constructs rewritten. The files loaded by the script are not modified.
+func FixDeprecatedSyntax(filename string, text []byte) ([]byte, []Deprecation, error) {
+	statements, err := parse(filename, text)
+	if err != nil {
+		return nil, nil, err
+	}
+	deps := findDeprecations(statements)
+	var edits []textEdit
+	for _, d := range deps {
+		edits = append(edits, d.edits...)
+	}
+	sort.Slice(edits, func(i, j int) bool { return edits[i].offset > edits[j].offset })
+	out := append([]byte{}, text...)
+	end := len(out)
+	for _, e := range edits {
+		if e.offset+e.n > end {
+			return nil, nil, fmt.Errorf("%s: overlapping edits at offset %d", filename, e.offset)
+		}
+		start := e.offset
+		if e.trimSemicolon {
+			i := start - 1
+			for i >= 0 && (out[i] == ' ' || out[i] == '\t' || out[i] == '\n' || out[i] == '\r') {
+				i--
+			}
+			if i >= 0 && out[i] == ';' {
+				start = i
+			}
+		}
+		out = append(out[:start], append([]byte(e.text), out[e.offset+e.n:]...)...)
+		end = start
+	}
+	return out, deps, nil
+}
+
+// reportDeprecations reports uses of deprecated syntax in the statements, once
+// per source location in the session. If Opts.DenyDeprecated is set, it panics
+// instead.
+//
+// REQUIRES: s.mu is locked.
+func (s *Session) reportDeprecations(statements []ASTStatementOrLoad) {
+	for _, d := range findDeprecations(statements) {
+		if denyDeprecated {
+			log.Panicf("%v: %s (-deny-deprecated is set; run gql -fix to update the script)", d.Pos, d.Message)
+		}
+		key := d.Pos.String()
+		if s.reportedDeprecations[key] {
+			continue
+		}
+		if s.reportedDeprecations == nil {
+			s.reportedDeprecations = map[string]bool{}
+		}
+		s.reportedDeprecations[key] = true
+		if s.warningHandler != nil {
+			s.warningHandler(d.Pos, d.Message)
+			continue
+		}
+		log.Error.Printf("%v: warning: %s", d.Pos, d.Message)
+	}
+}
+
+// SetWarningHandler sets the function called for each warning about the
+// scripts evaluated in the session, e.g., uses of deprecated syntax. Each
+// warning is reported once per source location. By default, warnings are
+// logged.
+func (s *Session) SetWarningHandler(h func(pos scanner.Position, msg string)) {
+	s.mu.Lock()
+	s.warningHandler = h
+	s.mu.Unlock()
+}
@@ -0,0 +1,60 @@
+package gql
+
+import (
+	"testing"
+	"text/scanner"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/grailbio/testutil/h"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixDeprecatedSyntax(t *testing.T) {
+	newSession()
+	src := `t0 := table({a:1, b:2});
+f := func(x) { x.a + 1 };
+g := func(x, y) { z := x + y; z * 2 };
+t0 | map({c: $a, d: f(_)}) | filter(func(r) { r.c > 0; }) | map({e: g($c, $d)})
+`
+	out, deps, err := FixDeprecatedSyntax("test.gql", []byte(src))
+	require.NoError(t, err)
+	assert.Equal(t, `t0 := table({a:1, b:2});
+func f(x) ( x.a + 1 );
+func g(x, y) { z := x + y; z * 2 };
+t0 | map({c: &a, d: f(_)}) | filter(|r| ( r.c > 0)) | map({e: g(&c, &d)})
+`, string(out))
+	require.Len(t, deps, 6)
+	assert.Equal(t, 2, deps[0].Pos.Line)
+	assert.Regexp(t, "'f := func.*deprecated", deps[0].Message)
+	assert.Regexp(t, `write &a instead`, deps[2].Message)
+
+	// The rewritten script evaluates to the same value.
+	sess := newSession()
+	expected := doReadTable(doEval(t, src, sess))
+	assert.Equal(t, []string{"{e:6}"}, expected)
+	assert.Equal(t, expected, doReadTable(doEval(t, string(out), newSession())))
+
+	out, deps, err = FixDeprecatedSyntax("test.gql", out)
+	require.NoError(t, err)
+	assert.Len(t, deps, 0)
+}
+
+func TestReportDeprecations(t *testing.T) {
+	sess := newSession()
+	var warnings []string
+	sess.SetWarningHandler(func(pos scanner.Position, msg string) {
+		warnings = append(warnings, pos.String()+": "+msg)
+	})
+	doEval(t, "table({a:1}) | map({b:$a})", sess)
+	doEval(t, "table({a:1}) | map({b:$a})", sess)
+	require.Len(t, warnings, 1)
+	assert.Regexp(t, `test:1:23: \$-syntax is deprecated`, warnings[0])
+
+	old := denyDeprecated
+	defer func() { denyDeprecated = old }()
+	denyDeprecated = true
+	expect.That(t,
+		func() { doEval(t, "table({a:1}) | map({b:$a + 1})", sess) },
+		h.Panics(h.Regexp(`test:1:23: \$-syntax is deprecated.*-deny-deprecated`)))
+}
@@ -33,6 +33,56 @@ func TestArgError(t *testing.T) {
 		h.Panics(h.Regexp(`\(input\):1:1.*too many arguments to function.*123`)))
 }
 
+func TestSprintfFormatError(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `t0 := table({a:1, b:"x"})`, env)
+	expect.That(t,
+		func() { gqltest.Eval(t, `f := |x| sprintf("%d-%s", x)`, env) },
+		h.Panics(h.Regexp(`\(input\):1:10.*format "%d-%s" consumes 2 args, but 1 args are given`)))
+	expect.That(t,
+		func() { gqltest.Eval(t, `sprintf("%d", "abc")`, env) },
+		h.Panics(h.Regexp(`\(input\):1:1.*verb %d in format "%d" cannot print arg #1 \(abc\), which is of type string`)))
+	expect.That(t,
+		func() { gqltest.Eval(t, `sprintf("%*d", 1.5, 10)`, env) },
+		h.Panics(h.Regexp(`arg #1 \(1.5\) must be an int`)))
+	// The table is not scanned, so the error is raised by the analysis.
+	expect.That(t,
+		func() { gqltest.Eval(t, `t0 | map(sprintf("%d%%", 1.5))`, env) },
+		h.Panics(h.Regexp(`verb %d in format "%d%%" cannot print arg #1`)))
+
+	expect.EQ(t, gqltest.Eval(t, `sprintf("%5.1f%% %-3d|%v|%*d", 1.25, 7, 1s, 3, 4)`, env).Str(nil), "  1.2% 7  |1s|  4")
+	expect.EQ(t, gqltest.Eval(t, `sprintf("%[2]s-%[1]d", 1, "a")`, env).Str(nil), "a-1")
+	expect.EQ(t, gqltest.Eval(t, `t0 | map(sprintf("%s%d", &b, &a)) | pick(true)`, env).Str(nil), "x1")
+}
+
+func TestImplicitColumnRefError(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `t0 := table({a:1, b:10})`, env)
+	expect.That(t,
+		func() { gqltest.Eval(t, "x := &a + 1", env) },
+		h.Panics(h.Regexp(`\(input\):1:6.*'&a' can appear only in an argument to a function call.*Write '_.a' or 'row.a' instead`)))
+	expect.That(t,
+		func() { gqltest.Eval(t, "&a", env) },
+		h.Panics(h.Regexp(`'&a' can appear only in an argument to a function call`)))
+	expect.That(t,
+		func() { gqltest.Eval(t, "f := |x, y| &a + y", env) },
+		h.Panics(h.Regexp(`'&a' is ambiguous in a function with 2 args`)))
+}
+
+func TestNotFoundSuggestion(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `my_var := table({start:1, end:10})`, env)
+	expect.That(t,
+		func() { gqltest.Eval(t, "my_vra", env) },
+		h.Panics(h.Regexp(`variable 'my_vra' not found; did you mean 'my_var'\?`)))
+	expect.That(t,
+		func() { gqltest.ReadTable(gqltest.Eval(t, "my_var | map($strat)", env)) },
+		h.Panics(h.Regexp(`column 'strat' not found; did you mean 'start'\?`)))
+	expect.That(t,
+		func() { gqltest.ReadTable(gqltest.Eval(t, "my_var | map($chrom)", env)) },
+		h.Panics(h.Regexp(`column 'chrom' not found; columns are: end, start`)))
+}
+
 func TestTableError(t *testing.T) {
 	env := gqltest.NewSession()
 	expect.That(t,
@@ -83,3 +133,23 @@ func TestMinMaxError(t *testing.T) {
 		func() { gqltest.Eval(t, `min("a", 10)`, env) },
 		h.Panics(h.Regexp(`invalid arg types`)))
 }
+
+func TestZipError(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `long := table(1, 2, 3)`, env)
+	gqltest.Eval(t, `short := table(1, 2)`, env)
+	expect.That(t,
+		func() { gqltest.ReadTable(gqltest.Eval(t, `zip(long, short)`, env)) },
+		h.Panics(h.Regexp(`zip: table 'short' has 2 rows, but table 'long' has more rows.*fill:=true`)))
+}
+
+func TestCrossError(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `t0 := table(1, 2, 3)`, env)
+	expect.That(t,
+		func() { gqltest.ReadTable(gqltest.Eval(t, `cross(t0, t0, max_rows:=5)`, env)) },
+		h.Panics(h.Regexp(`cross: the result would have about 9 rows, which exceeds max_rows:=5`)))
+	expect.That(t,
+		func() { gqltest.Eval(t, `cross(t0)`, env) },
+		h.Panics(h.Regexp(`cross: at least two tables are required`)))
+}
@@ -124,6 +124,20 @@ func (f *callFrame) list() (syms []symbol.ID, vals []Value) {
 	return
 }
 
+// Unset removes the binding for the given symbol, if any.
+func (f *callFrame) unset(sym symbol.ID) {
+	switch {
+	case sym == symbol.Invalid:
+	case sym == f.sym0:
+		f.sym0, f.val0 = f.sym1, f.val1
+		f.sym1, f.val1 = symbol.Invalid, Value{}
+	case sym == f.sym1:
+		f.sym1, f.val1 = symbol.Invalid, Value{}
+	default:
+		delete(f.vars, sym)
+	}
+}
+
 // Set adds a new binding. It crashes if the symbol already exists.
 func (f *callFrame) set(sym symbol.ID, v Value) {
 	if f.sym1 != symbol.Invalid {
@@ -181,17 +195,17 @@ func (f *callFrame) marshal(ctx MarshalContext, enc *marshal.Encoder) {
 	enc.PutVarint(int64(nVar))
 	if f.sym0 != symbol.Invalid {
 		f.sym0.Marshal(enc)
-		f.val0.Marshal(ctx, enc)
+		marshalCapturedValue(ctx, enc, f.sym0, f.val0)
 		nVar--
 	}
 	if f.sym1 != symbol.Invalid {
 		f.sym1.Marshal(enc)
-		f.val1.Marshal(ctx, enc)
+		marshalCapturedValue(ctx, enc, f.sym1, f.val1)
 		nVar--
 	}
 	for sym, val := range f.vars {
 		sym.Marshal(enc)
-		val.Marshal(ctx, enc)
+		marshalCapturedValue(ctx, enc, sym, val)
 		nVar--
 	}
 	if nVar != 0 {
@@ -199,6 +213,49 @@ func (f *callFrame) marshal(ctx MarshalContext, enc *marshal.Encoder) {
 	}
 }
 
+// maxCapturedValueSize is the marshaled size above which a variable in a call
+// frame is considered large. Call frames are shipped to every bigslice worker
+// that runs a closure, so a closure that accidentally captures a large table
+// can be very expensive. A large table is therefore shipped as a reference to
+// a btsv file in the cache directory. Other large values are shipped as is.
+// In both cases, a warning is logged.
+var maxCapturedValueSize = 8 << 20
+
+// marshalCapturedValue marshals the value of variable sym in a call frame.
+func marshalCapturedValue(ctx MarshalContext, enc *marshal.Encoder, sym symbol.ID, val Value) {
+	switch val.Type() {
+	case StringType, FileNameType, EnumType:
+		if n := len(val.Str(nil)); n > maxCapturedValueSize {
+			log.Printf("warning: a closure captures variable '%s' of %d bytes", sym.Str(), n)
+		}
+	case StructType:
+		start := enc.Len()
+		val.Marshal(ctx, enc)
+		if n := enc.Len() - start; n > maxCapturedValueSize {
+			log.Printf("warning: a closure captures variable '%s' of %d bytes", sym.Str(), n)
+		}
+		return
+	case TableType:
+		t := val.Table(nil)
+		if isMaterialized(t) {
+			break // The table is marshaled as a pathname.
+		}
+		mark := enc.Mark()
+		frames := ctx.frameHashes()
+		val.Marshal(ctx, enc)
+		if n := enc.Len() - mark.Len(); n > maxCapturedValueSize {
+			log.Printf("warning: a closure captures table '%s' of %d bytes; "+
+				"shipping it as a reference to a btsv file in the cache directory", sym.Str(), n)
+			enc.Rewind(mark)
+			ctx.dropFramesExcept(frames)
+			enc.PutByte(byte(TableType))
+			MarshalTableOutline(ctx, enc, t)
+		}
+		return
+	}
+	val.Marshal(ctx, enc)
+}
+
 // unmarshalCallFrame deserializes a callFrame.
 func unmarshalCallFrame(ctx UnmarshalContext, dec *marshal.Decoder) *callFrame {
 	f := &callFrame{}
@@ -322,6 +379,16 @@ func (b *bindings) Describe() string {
 	return buf.String()
 }
 
+// names returns the names of all the variables visible in the bindings,
+// including the builtin functions and consts.
+func (b *bindings) names() (vars []symbol.ID) {
+	for _, frame := range b.frames {
+		syms, _ := frame.list()
+		vars = append(vars, syms...)
+	}
+	return vars
+}
+
 // GlobalVars returns the names of global consts and variables.  Names are
 // returned in no particular order.
 func (b *bindings) GlobalVars() (vars []symbol.ID) {
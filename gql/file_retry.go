@@ -0,0 +1,416 @@
+package gql
+
+// This file implements retryingFile, a read-only file that survives transient
+// errors. Multi-hour scans of large S3 files occasionally see a connection
+// reset or a truncated response; instead of panicking the whole query, the
+// table readers reopen the file and resume reading where they left off. Only
+// network errors and S3 server errors are retried, and a reopened file must
+// have the same contents as the file first opened.
+
+import (
+	"context"
+	goerrors "errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/base/retry"
+)
+
+// Default values of the file-reading options in Opts.
+const (
+	DefaultS3MaxRetries   = 10
+	DefaultS3RetryBackoff = time.Second
+)
+
+var (
+	// s3MaxRetries is the max number of consecutive retries of a failed read.
+	// Copied from Opts.S3MaxRetries.
+	s3MaxRetries = DefaultS3MaxRetries
+	// s3RetryBackoff is the initial wait before retrying a failed read. It
+	// grows exponentially up to a minute. Copied from Opts.S3RetryBackoff.
+	s3RetryBackoff = DefaultS3RetryBackoff
+	// s3ReadConcurrency is the max number of read-ahead chunks fetched in
+	// parallel per S3 file. Copied from Opts.S3ReadConcurrency.
+	s3ReadConcurrency = 1
+	// s3ReadAhead is the size of a read-ahead chunk. If zero, S3 files are read
+	// synchronously. Copied from Opts.S3ReadAhead.
+	s3ReadAhead = 0
+)
+
+// isTransientReadError checks if a read that failed with err may succeed if
+// retried. Only network errors, truncated responses, and S3 server (5xx) errors
+// are transient. Errors reading a local file are never retried.
+func isTransientReadError(ctx context.Context, err error) bool {
+	if err == nil || err == io.EOF || ctx.Err() != nil {
+		return false
+	}
+	if err == io.ErrUnexpectedEOF {
+		return true
+	}
+	for e := err; e != nil; {
+		switch t := e.(type) {
+		case awserr.RequestFailure:
+			return t.StatusCode() >= 500
+		case awserr.Error:
+			e = t.OrigErr()
+		case net.Error:
+			return true
+		case *errors.Error:
+			if t.Kind == errors.Net || t.Kind == errors.Timeout || t.Kind == errors.Unavailable {
+				return true
+			}
+			e = t.Err
+		default:
+			e = goerrors.Unwrap(e)
+		}
+	}
+	return false
+}
+
+// waitForReadRetry is called after the retries'th consecutive failure to read
+// path. It logs the error and waits for the backoff period. It returns false
+// if the read should not be retried.
+func waitForReadRetry(ctx context.Context, path string, err error, retries int) bool {
+	if !isTransientReadError(ctx, err) || retries >= s3MaxRetries {
+		return false
+	}
+	log.Printf("read %s: %v; retrying (%d/%d)", path, err, retries+1, s3MaxRetries)
+	return retry.Wait(ctx, retry.Backoff(s3RetryBackoff, time.Minute, 1.2), retries) == nil
+}
+
+// readAheadChunk is a byte range of a file fetched in the background.
+type readAheadChunk struct {
+	off  int64
+	data []byte
+	err  error
+	done chan struct{} // closed once data or err is set.
+}
+
+// fileContentsID identifies the contents of a file being read.
+type fileContentsID struct {
+	etag    string // set only for S3 objects.
+	size    int64
+	modTime int64 // in UnixNano.
+}
+
+// statFileContentsID computes the fileContentsID of the given file.
+func statFileContentsID(ctx context.Context, path string) (fileContentsID, error) {
+	if bucket, key, ok := parseS3Path(path); ok {
+		client, err := getS3Client(ctx, bucket)
+		if err != nil {
+			return fileContentsID{}, err
+		}
+		out, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fileContentsID{}, err
+		}
+		return fileContentsID{
+			etag:    aws.StringValue(out.ETag),
+			size:    aws.Int64Value(out.ContentLength),
+			modTime: aws.TimeValue(out.LastModified).UnixNano(),
+		}, nil
+	}
+	info, err := file.Stat(ctx, path)
+	if err != nil {
+		return fileContentsID{}, err
+	}
+	return fileContentsID{size: info.Size(), modTime: info.ModTime().UnixNano()}, nil
+}
+
+// retryingFile is a read-only file. Its reader transparently reopens the file
+// and resumes at the current offset when a read fails with a transient error.
+// The reopened file must have the same ETag (or modtime) and size as the file
+// first opened; otherwise the read fails with errors.Precondition.
+//
+// If read-ahead is enabled, the reader fetches the following chunks of the
+// file in parallel, each through a separate file handle.
+//
+// A retryingFile is not thread safe.
+type retryingFile struct {
+	path string
+	id   fileContentsID  // ID of the file when it was first opened.
+	ctx  context.Context // the context passed to the last Reader call.
+	in   file.File       // nil after a failed read, until reopened.
+	r    io.ReadSeeker   // in.Reader(ctx).
+	off  int64           // current read offset.
+	// nRead is the total number of bytes read, reported by Close.
+	nRead int64
+
+	// Read-ahead state. handles is nil if read-ahead is disabled.
+	size    int64
+	nextOff int64 // offset of the next chunk to fetch.
+	pending []*readAheadChunk
+	cur     []byte // unread part of the last chunk.
+	handles chan file.File
+	wg      sync.WaitGroup
+}
+
+// openRetryingFile opens the given file for reading, retrying on transient
+// errors.
+func openRetryingFile(ctx context.Context, path string) (*retryingFile, error) {
+	var (
+		in  file.File
+		err error
+	)
+	for retries := 0; ; retries++ {
+		if in, err = file.Open(ctx, path); err == nil || !waitForReadRetry(ctx, path, err, retries) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	readAhead := s3ReadAhead > 0 && strings.HasPrefix(path, "s3://")
+	return newRetryingFile(ctx, path, in, readAhead)
+}
+
+// newRetryingFile creates a retryingFile that reads from in, which must be
+// opened on path.
+func newRetryingFile(ctx context.Context, path string, in file.File, readAhead bool) (*retryingFile, error) {
+	// The ID is read after the file is opened, so a concurrent update causes a
+	// spurious failure of a later reopen, rather than a silent mix of two
+	// versions.
+	id, err := statFileContentsID(ctx, path)
+	if err != nil {
+		in.Close(ctx) // nolint: errcheck
+		return nil, err
+	}
+	f := &retryingFile{path: path, id: id, ctx: ctx, in: in, r: in.Reader(ctx)}
+	if readAhead {
+		f.size = id.size
+		f.handles = make(chan file.File, s3ReadConcurrency)
+	}
+	return f, nil
+}
+
+// Name returns the pathname of the file.
+func (f *retryingFile) Name() string { return f.path }
+
+// Stat returns the attributes of the file.
+func (f *retryingFile) Stat(ctx context.Context) (file.Info, error) {
+	return file.Stat(ctx, f.path)
+}
+
+// Reader returns the reader for the file. The reader is f itself, so all the
+// readers returned by this method share the same offset.
+func (f *retryingFile) Reader(ctx context.Context) io.ReadSeeker {
+	f.ctx = ctx
+	return f
+}
+
+// openUnchanged opens the file again. It fails if the file has changed since
+// it was first opened.
+func (f *retryingFile) openUnchanged(ctx context.Context) (file.File, error) {
+	in, err := file.Open(ctx, f.path)
+	if err != nil {
+		return nil, err
+	}
+	id, err := statFileContentsID(ctx, f.path)
+	if err == nil && id != f.id {
+		err = errors.E(errors.Precondition, "read", f.path, "the file changed while being read")
+	}
+	if err != nil {
+		in.Close(ctx) // nolint: errcheck
+		return nil, err
+	}
+	return in, nil
+}
+
+// reopen reopens the file and seeks to f.off, after a failed read.
+func (f *retryingFile) reopen() error {
+	in, err := f.openUnchanged(f.ctx)
+	if err != nil {
+		return err
+	}
+	r := in.Reader(f.ctx)
+	if _, err := r.Seek(f.off, io.SeekStart); err != nil {
+		in.Close(f.ctx) // nolint: errcheck
+		return err
+	}
+	f.in, f.r = in, r
+	return nil
+}
+
+// Read implements io.Reader.
+func (f *retryingFile) Read(p []byte) (int, error) {
+	if f.handles != nil {
+		n, err := f.readAhead(p)
+		f.nRead += int64(n)
+		evalBudgetFromContext(f.ctx).addBytes(int64(n))
+		return n, err
+	}
+	for retries := 0; ; retries++ {
+		var (
+			n   int
+			err error
+		)
+		if f.in == nil {
+			err = f.reopen()
+		}
+		if err == nil {
+			n, err = f.r.Read(p)
+			f.off += int64(n)
+			f.nRead += int64(n)
+			evalBudgetFromContext(f.ctx).addBytes(int64(n))
+			if n > 0 && isTransientReadError(f.ctx, err) {
+				// Report the bytes read so far. The next call will retry.
+				err = nil
+			}
+			if n > 0 || !isTransientReadError(f.ctx, err) {
+				return n, err
+			}
+		}
+		if !waitForReadRetry(f.ctx, f.path, err, retries) {
+			return 0, err
+		}
+		if f.in != nil {
+			f.in.Close(f.ctx) // nolint: errcheck
+			f.in, f.r = nil, nil
+		}
+	}
+}
+
+// Seek implements io.Seeker.
+func (f *retryingFile) Seek(offset int64, whence int) (int64, error) {
+	if f.handles == nil {
+		if f.in == nil {
+			if err := f.reopen(); err != nil {
+				return 0, err
+			}
+		}
+		off, err := f.r.Seek(offset, whence)
+		if err == nil {
+			f.off = off
+		}
+		return off, err
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, errors.E(errors.Invalid, "seek", f.path, "negative offset")
+	}
+	if offset != f.off {
+		// Drop the chunks fetched so far. Their goroutines finish in the
+		// background.
+		f.off, f.nextOff, f.pending, f.cur = offset, offset, nil, nil
+	}
+	return f.off, nil
+}
+
+// readAhead implements Read when read-ahead is enabled.
+func (f *retryingFile) readAhead(p []byte) (int, error) {
+	for len(f.cur) == 0 {
+		if f.off >= f.size {
+			return 0, io.EOF
+		}
+		f.fillReadAhead()
+		c := f.pending[0]
+		f.pending = f.pending[1:]
+		f.fillReadAhead()
+		<-c.done
+		if c.err != nil {
+			return 0, c.err
+		}
+		f.cur = c.data
+	}
+	n := copy(p, f.cur)
+	f.cur = f.cur[n:]
+	f.off += int64(n)
+	return n, nil
+}
+
+// fillReadAhead starts fetching chunks until s3ReadConcurrency chunks are in
+// flight or the end of file is reached.
+func (f *retryingFile) fillReadAhead() {
+	for len(f.pending) < cap(f.handles) && f.nextOff < f.size {
+		n := int64(s3ReadAhead)
+		if f.nextOff+n > f.size {
+			n = f.size - f.nextOff
+		}
+		c := &readAheadChunk{off: f.nextOff, data: make([]byte, n), done: make(chan struct{})}
+		f.nextOff += n
+		f.pending = append(f.pending, c)
+		f.wg.Add(1)
+		go f.fetchChunk(f.ctx, c)
+	}
+}
+
+// fetchChunk reads the range of c from the file, retrying on transient errors.
+func (f *retryingFile) fetchChunk(ctx context.Context, c *readAheadChunk) {
+	defer f.wg.Done()
+	defer close(c.done)
+	var in file.File
+	select {
+	case in = <-f.handles:
+	default:
+	}
+	for retries := 0; ; retries++ {
+		var err error
+		if in == nil {
+			in, err = f.openUnchanged(ctx)
+		}
+		if err == nil {
+			r := in.Reader(ctx)
+			if _, err = r.Seek(c.off, io.SeekStart); err == nil {
+				_, err = io.ReadFull(r, c.data)
+			}
+		}
+		if err == nil {
+			select {
+			case f.handles <- in:
+			default:
+				in.Close(ctx) // nolint: errcheck
+			}
+			return
+		}
+		if in != nil {
+			in.Close(ctx) // nolint: errcheck
+			in = nil
+		}
+		if !waitForReadRetry(ctx, f.path, err, retries) {
+			c.err = err
+			return
+		}
+	}
+}
+
+// Close closes the file. It waits for the outstanding read-ahead requests.
+func (f *retryingFile) Close(ctx context.Context) error {
+	if f.nRead > 0 {
+		reportFileRead(f.path, f.nRead)
+		f.nRead = 0
+	}
+	if f.handles != nil {
+		f.wg.Wait()
+		for done := false; !done; {
+			select {
+			case in := <-f.handles:
+				in.Close(ctx) // nolint: errcheck
+			default:
+				done = true
+			}
+		}
+	}
+	if f.in == nil {
+		return nil
+	}
+	err := f.in.Close(ctx)
+	f.in, f.r = nil, nil
+	return err
+}
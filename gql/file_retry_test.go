@@ -0,0 +1,146 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/testutil"
+	"github.com/grailbio/testutil/expect"
+)
+
+// flakyFile is a file whose reader fails after reading limit bytes.
+type flakyFile struct {
+	file.File
+	limit int
+}
+
+type flakyReader struct {
+	io.ReadSeeker
+	remaining int
+}
+
+func (f *flakyFile) Reader(ctx context.Context) io.ReadSeeker {
+	return &flakyReader{ReadSeeker: f.File.Reader(ctx), remaining: f.limit}
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	}
+	if len(p) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.ReadSeeker.Read(p)
+	r.remaining -= n
+	return n, err
+}
+
+func writeRetryTestFile(t *testing.T, dir string) (string, string) {
+	var data strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&data, "line%d\n", i)
+	}
+	path := filepath.Join(dir, "data.txt")
+	expect.NoError(t, ioutil.WriteFile(path, []byte(data.String()), 0600))
+	return path, data.String()
+}
+
+func TestRetryingFile(t *testing.T) {
+	tempDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	path, data := writeRetryTestFile(t, tempDir)
+	defer func(old time.Duration) { s3RetryBackoff = old }(s3RetryBackoff)
+	s3RetryBackoff = time.Millisecond
+
+	in, err := file.Open(ctx, path)
+	expect.NoError(t, err)
+	f, err := newRetryingFile(ctx, path, &flakyFile{File: in, limit: 100}, false)
+	expect.NoError(t, err)
+	got, err := ioutil.ReadAll(f.Reader(ctx))
+	expect.NoError(t, err)
+	expect.EQ(t, string(got), data)
+	expect.NoError(t, f.Close(ctx))
+
+	// Non-transient errors are not retried.
+	_, err = openRetryingFile(ctx, filepath.Join(tempDir, "nonexistent"))
+	expect.True(t, err != nil)
+
+	// A file that changed while being read is not reopened.
+	in, err = file.Open(ctx, path)
+	expect.NoError(t, err)
+	f, err = newRetryingFile(ctx, path, &flakyFile{File: in, limit: 100}, false)
+	expect.NoError(t, err)
+	buf := make([]byte, 100)
+	_, err = io.ReadFull(f.Reader(ctx), buf)
+	expect.NoError(t, err)
+	expect.NoError(t, ioutil.WriteFile(path, []byte(data+"extra\n"), 0600))
+	_, err = f.Reader(ctx).Read(buf)
+	expect.True(t, errors.Is(errors.Precondition, err), err)
+	expect.NoError(t, f.Close(ctx))
+}
+
+func TestIsTransientReadError(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true},
+		{errors.E("read", "s3://b/k", &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}), true},
+		{io.ErrUnexpectedEOF, true},
+		{awserr.NewRequestFailure(awserr.New("InternalError", "internal error", nil), 500, "req"), true},
+		{awserr.NewRequestFailure(awserr.New("NoSuchKey", "not found", nil), 404, "req"), false},
+		{awserr.New("RequestError", "send request failed", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}), true},
+		{&os.PathError{Op: "read", Path: "/tmp/x", Err: syscall.EIO}, false},
+		{fmt.Errorf("connection reset by peer"), false},
+		{io.EOF, false},
+	} {
+		expect.EQ(t, isTransientReadError(ctx, test.err), test.want, test.err)
+	}
+}
+
+func TestRetryingFileReadAhead(t *testing.T) {
+	tempDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	path, data := writeRetryTestFile(t, tempDir)
+	defer func(ra, conc int) { s3ReadAhead, s3ReadConcurrency = ra, conc }(s3ReadAhead, s3ReadConcurrency)
+	s3ReadAhead, s3ReadConcurrency = 100, 3
+
+	in, err := file.Open(ctx, path)
+	expect.NoError(t, err)
+	f, err := newRetryingFile(ctx, path, in, true)
+	expect.NoError(t, err)
+	r := f.Reader(ctx)
+	got, err := ioutil.ReadAll(r)
+	expect.NoError(t, err)
+	expect.EQ(t, string(got), data)
+
+	off, err := r.Seek(-15, io.SeekEnd)
+	expect.NoError(t, err)
+	expect.EQ(t, off, int64(len(data)-15))
+	got, err = ioutil.ReadAll(r)
+	expect.NoError(t, err)
+	expect.EQ(t, string(got), data[len(data)-15:])
+
+	_, err = r.Seek(250, io.SeekStart)
+	expect.NoError(t, err)
+	buf := make([]byte, 10)
+	_, err = io.ReadFull(r, buf)
+	expect.NoError(t, err)
+	expect.EQ(t, string(buf), data[250:260])
+	expect.NoError(t, f.Close(ctx))
+}
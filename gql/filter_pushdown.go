@@ -0,0 +1,262 @@
+package gql
+
+// This file implements the pushdown of filter conditions into file readers.
+//
+// filter() and map(filter:=...) extract the conditions of form "&col OP
+// constant" (possibly and-ed with other conditions), where OP is one of ==, !=,
+// <, <=, >, >=. A row must satisfy all of them to satisfy the filter.
+//
+// - A TSV scanner parses only the cells of the columns used in the conditions
+//   before constructing the row. The rows that fail a condition are dropped.
+//
+// - A btsv scanner consults the block index (see btsv_index.go) to skip the
+//   blocks that can't contain a matching row. The rows of the remaining blocks
+//   are checked by decoding only the columns used in the conditions.
+//
+// The pushdown is only an optimization. mapFilterTable evaluates the full filter
+// on the rows that pass the conditions. The conditions thus keep a row when in
+// doubt, e.g., if the column value is NA or its type differs from the
+// constant.
+
+import (
+	"context"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+// columnPredicateOp is the comparison operator in columnPredicate.
+type columnPredicateOp int
+
+const (
+	predEQ columnPredicateOp = iota // col == key
+	predNE                          // col != key
+	predLT                          // col < key
+	predLE                          // col <= key
+	predGT                          // col > key
+	predGE                          // col >= key
+)
+
+var (
+	neSymbolID = symbol.Intern("infix:!=")
+	gtSymbolID = symbol.Intern("infix:>")
+	geSymbolID = symbol.Intern("infix:>=")
+)
+
+// columnPredicate is a condition "col OP key" extracted from a filter
+// expression.
+type columnPredicate struct {
+	col symbol.ID
+	op  columnPredicateOp
+	key Value
+}
+
+// match checks if v, the value of column p.col, may satisfy the condition. It
+// returns true unless the condition is known to be false.
+func (p columnPredicate) match(v Value) bool {
+	if v.Null() != NotNull {
+		// NA == key is false, but NA sorts after (or, for -NA, before) any
+		// other value.
+		return p.op != predEQ
+	}
+	if typ := btsvStatsType(v); typ == InvalidType || typ != btsvStatsType(p.key) {
+		if p.op == predEQ && v.Type() == p.key.Type() {
+			return Compare(astUnknown, v, p.key) == 0
+		}
+		return true
+	}
+	c := Compare(astUnknown, v, p.key)
+	switch p.op {
+	case predEQ:
+		return c == 0
+	case predNE:
+		return c != 0
+	case predLT:
+		return c < 0
+	case predLE:
+		return c <= 0
+	case predGT:
+		return c > 0
+	case predGE:
+		return c >= 0
+	}
+	return true
+}
+
+// matchColumnPredicates checks if the row may satisfy all the predicates.
+func matchColumnPredicates(row Value, preds []columnPredicate) bool {
+	if row.Type() != StructType {
+		return true
+	}
+	st := row.Struct(astUnknown)
+	for _, p := range preds {
+		if v, ok := st.Value(p.col); ok && !p.match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// hashColumnPredicates merges the predicates into h.
+func hashColumnPredicates(h hash.Hash, preds []columnPredicate) hash.Hash {
+	for _, p := range preds {
+		h = h.Merge(p.col.Hash()).Merge(hash.Int(int64(p.op))).Merge(p.key.Hash())
+	}
+	return h
+}
+
+// comparisonOp checks if n is a literal referring to "==", "!=", ">", or ">=".
+// Expressions "a < b" and "a <= b" are parsed as "b > a" and "b >= a",
+// respectively.
+func comparisonOp(n ASTNode) (columnPredicateOp, bool) {
+	lit, ok := n.(*ASTLiteral)
+	if !ok || lit.Literal.Type() != FuncType {
+		return 0, false
+	}
+	switch lit.Literal.Func(n).name {
+	case eqeqSymbolID:
+		return predEQ, true
+	case neSymbolID:
+		return predNE, true
+	case gtSymbolID:
+		return predGT, true
+	case geSymbolID:
+		return predGE, true
+	}
+	return 0, false
+}
+
+// flipOp converts "key OP col" to "col OP' key".
+func flipOp(op columnPredicateOp) columnPredicateOp {
+	switch op {
+	case predLT:
+		return predGT
+	case predLE:
+		return predGE
+	case predGT:
+		return predLT
+	case predGE:
+		return predLE
+	}
+	return op
+}
+
+// findColumnPredicates extracts conditions of form "col OP constant" from the
+// body of a filter function. The row must satisfy all the returned predicates
+// to satisfy the filter.
+func findColumnPredicates(expr ASTNode, row symbol.ID) (preds []columnPredicate) {
+	if andand, ok := expr.(*ASTLogicalOp); ok && andand.AndAnd {
+		preds = append(preds, findColumnPredicates(andand.LHS, row)...)
+		preds = append(preds, findColumnPredicates(andand.RHS, row)...)
+		return
+	}
+	funcallExpr, ok := expr.(*ASTFuncall)
+	if !ok || len(funcallExpr.Raw) != 2 {
+		return
+	}
+	op, ok := comparisonOp(funcallExpr.Function)
+	if !ok {
+		return
+	}
+	for i := 0; i < 2; i++ {
+		col, ok := rowColumnRef(funcallExpr.Raw[i].Expr, row)
+		if !ok {
+			continue
+		}
+		lit, ok := funcallExpr.Raw[1-i].Expr.(*ASTLiteral)
+		if !ok || lit.Literal.Null() != NotNull {
+			continue
+		}
+		if op == predEQ {
+			if _, _, ok := btsvBloomKey(lit.Literal); !ok {
+				continue
+			}
+		} else if btsvStatsType(lit.Literal) == InvalidType {
+			continue
+		}
+		p := columnPredicate{col: col, op: op, key: lit.Literal}
+		if i == 1 {
+			p.op = flipOp(op)
+		}
+		preds = append(preds, p)
+		break
+	}
+	return
+}
+
+// pruneTableForFilter returns a table that yields a subset of rows of src
+// that may satisfy filterExpr. It returns nil if no such table can be
+// constructed, in which case the caller should scan src.
+func pruneTableForFilter(src Table, filterExpr *Func) Table {
+	if filterExpr == nil || filterExpr.builtin || len(filterExpr.formalArgs) != 1 {
+		return nil
+	}
+	preds := findColumnPredicates(filterExpr.body, filterExpr.formalArgs[0].Name)
+	if len(preds) == 0 {
+		return nil
+	}
+	switch t := src.(type) {
+	case *btsvTable:
+		return &btsvPrunedTable{src: t, preds: preds}
+	case *TSVTable:
+		return &tsvPrunedTable{src: t, preds: preds}
+	}
+	return nil
+}
+
+// columnPredicateScanner yields the rows of src that may satisfy the
+// predicates.
+type columnPredicateScanner struct {
+	src   TableScanner
+	preds []columnPredicate
+}
+
+// Scan implements TableScanner.
+func (sc *columnPredicateScanner) Scan() bool {
+	for sc.src.Scan() {
+		if matchColumnPredicates(sc.src.Value(), sc.preds) {
+			return true
+		}
+	}
+	return false
+}
+
+// Value implements TableScanner.
+func (sc *columnPredicateScanner) Value() Value { return sc.src.Value() }
+
+// tsvPrunedTable reads the rows of a TSV table that may satisfy the given
+// predicates. Like btsvPrunedTable, it is used only as a source of
+// mapFilterTable.
+type tsvPrunedTable struct {
+	src   *TSVTable
+	preds []columnPredicate
+}
+
+// Attrs implements Table.
+func (t *tsvPrunedTable) Attrs(ctx context.Context) TableAttrs { return t.src.Attrs(ctx) }
+
+// Hash implements Table.
+func (t *tsvPrunedTable) Hash() hash.Hash { return hashColumnPredicates(t.src.Hash(), t.preds) }
+
+// Len implements Table.
+func (t *tsvPrunedTable) Len(ctx context.Context, mode CountMode) int {
+	if mode == Approx {
+		return t.src.Len(ctx, Approx)
+	}
+	return DefaultTableLen(ctx, t)
+}
+
+// Marshal implements Table. The pruning is only an optimization, so the source
+// table is marshaled in its place.
+func (t *tsvPrunedTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	t.src.Marshal(ctx, enc)
+}
+
+// Prefetch implements Table.
+func (t *tsvPrunedTable) Prefetch(ctx context.Context) { t.src.Prefetch(ctx) }
+
+// Scanner implements Table.
+func (t *tsvPrunedTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	return t.src.scanner(ctx, start, limit, total, t.preds)
+}
@@ -0,0 +1,102 @@
+package gql
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+	"github.com/grailbio/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindColumnPredicates(t *testing.T) {
+	sess := newSession()
+	f := doEval(t, `|r| r.a < 3 && "x" == r.b && r.c > r.d && 10 <= r.e && r.f != NA`, sess).Func(nil)
+	preds := findColumnPredicates(f.body, f.formalArgs[0].Name)
+	var got []string
+	for _, p := range preds {
+		got = append(got, fmt.Sprintf("%s %d %v", p.col.Str(), p.op, p.key))
+	}
+	assert.Equal(t, []string{
+		fmt.Sprintf("a %d 3", predLT),
+		fmt.Sprintf("b %d x", predEQ),
+		fmt.Sprintf("e %d 10", predGE),
+	}, got)
+}
+
+func TestBTSVBlockStatsMayMatch(t *testing.T) {
+	var s btsvColumnBlockStats
+	for i := 10; i < 20; i++ {
+		s.add(NewInt(int64(i)))
+	}
+	col := symbol.Intern("a")
+	for _, test := range []struct {
+		op   columnPredicateOp
+		key  int64
+		want bool
+	}{
+		{predLT, 10, false},
+		{predLE, 10, true},
+		{predGT, 19, false},
+		{predGE, 19, true},
+		{predNE, 15, true},
+		{predEQ, 25, false},
+	} {
+		assert.Equal(t, test.want, s.mayMatch(columnPredicate{col: col, op: test.op, key: NewInt(test.key)}), "%+v", test)
+	}
+	// NA may satisfy an ordering comparison.
+	s.add(Null)
+	assert.True(t, s.mayMatch(columnPredicate{col: col, op: predLT, key: NewInt(10)}))
+	assert.False(t, s.mayMatch(columnPredicate{col: col, op: predEQ, key: NewInt(25)}))
+}
+
+func TestHashIndexKeyFloat(t *testing.T) {
+	hashOf := func(f float64) hash.Hash {
+		h, ok := hashIndexKey(NewFloat(f))
+		require.True(t, ok)
+		return h
+	}
+	assert.Equal(t, hashOf(0), hashOf(math.Copysign(0, -1)))
+	assert.Equal(t, hashOf(math.NaN()), hashOf(math.Float64frombits(0x7ff8000000000123)))
+	assert.NotEqual(t, hashOf(0), hashOf(1))
+
+	// A block that only contains -0.0 may match "== 0.0".
+	var s btsvColumnBlockStats
+	s.add(NewFloat(math.Copysign(0, -1)))
+	s.add(NewFloat(math.NaN()))
+	col := symbol.Intern("a")
+	assert.True(t, s.mayMatch(columnPredicate{col: col, op: predEQ, key: NewFloat(0)}))
+	assert.True(t, s.mayMatch(columnPredicate{col: col, op: predEQ, key: NewFloat(math.Float64frombits(0x7ff8000000000123))}))
+	assert.False(t, s.mayMatch(columnPredicate{col: col, op: predEQ, key: NewFloat(1)}))
+}
+
+func TestFilterPushdownTables(t *testing.T) {
+	tempDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	defer func(old int) { MaxTSVRowsInMemory = old }(MaxTSVRowsInMemory)
+	MaxTSVRowsInMemory = 10
+	sess := newSession()
+
+	var data strings.Builder
+	data.WriteString("a\tb\n")
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&data, "%d\tx%d\n", i, i%3)
+	}
+	tsvPath := filepath.Join(tempDir, "large.tsv")
+	require.NoError(t, ioutil.WriteFile(tsvPath, []byte(data.String()), 0600))
+	btsvPath := filepath.Join(tempDir, "large.btsv")
+	doEval(t, fmt.Sprintf("t0 := read(`%s`)", tsvPath), sess)
+	doEval(t, fmt.Sprintf("t0 | write(`%s`, shards:=2, index:={&a, &b})", btsvPath), sess)
+	doEval(t, fmt.Sprintf("t1 := read(`%s`)", btsvPath), sess)
+
+	_, ok := doEval(t, "t0 | filter(&a > 20)", sess).Table(nil).(*mapFilterTable).prunedSrc.(*tsvPrunedTable)
+	assert.True(t, ok)
+	_, ok = doEval(t, "t1 | filter(&a > 20)", sess).Table(nil).(*mapFilterTable).prunedSrc.(*btsvPrunedTable)
+	assert.True(t, ok)
+}
@@ -0,0 +1,97 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingTable is a table whose scan fails.
+type failingTable struct {
+	Table
+}
+
+func (t *failingTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	panic("scan failed")
+}
+
+func TestForce(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	sess := TestNewSession()
+	eval := func(expr string) Value {
+		statements, err := sess.Parse("(input)", []byte(expr))
+		require.NoError(t, err)
+		return sess.EvalStatements(ctx, statements)
+	}
+	src := eval("forceSrc := table({a:1, b:`x`}, {a:2, b:`y`}, {a:3, b:`z`}) | filter($a > 1); forceSrc")
+	want := doReadTable(src)
+
+	v := eval("forceSrc | force()")
+	ft, ok := v.Table(nil).(*forcedTable)
+	require.True(t, ok)
+	_, ok = ft.Table.(*btsvTable)
+	assert.True(t, ok)
+	assert.Equal(t, src.Table(nil).Hash(), ft.Hash())
+	assert.Equal(t, want, doReadTable(v))
+	assert.True(t, isMaterialized(ft))
+	assert.Equal(t, ft.Table, materializeTable(ctx, ft, nil))
+	// Forcing a forced table is a no-op.
+	ft2, ok := eval("forceSrc | force() | force()").Table(nil).(*forcedTable)
+	require.True(t, ok)
+	assert.Equal(t, ft.path, ft2.path)
+
+	v = eval("forceSrc | force(shards:=3)")
+	assert.Equal(t, want, doReadTable(v))
+	assert.Len(t, listBTSVShardPaths(ctx, v.Table(nil).(*forcedTable).path, astUnknown), 3)
+
+	path := filepath.Join(tmpDir, "forced.tsv")
+	v = eval("forceSrc | force(format:=`tsv`, path:=`" + path + "`)")
+	assert.Equal(t, want, doReadTable(v))
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "a\tb\n2\ty\n3\tz\n", string(data))
+	assert.NotEqual(t, src.Table(nil).Hash(), v.Table(nil).Hash())
+	// The second force reuses the file.
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+	v = eval("forceSrc | force(format:=`tsv`, path:=`" + path + "`)")
+	assert.Equal(t, want, doReadTable(v))
+	stat2, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, stat.ModTime(), stat2.ModTime())
+	// A modified file is written again. The cache entry was found, so force
+	// doesn't release the lease held by another producer in this process.
+	require.NoError(t, ioutil.WriteFile(path, []byte("a\tb\n4\tw\n"), 0600))
+	cacheName := src.Table(nil).Hash().Merge(hash.String("tsv")).Merge(hash.Int(1)).Merge(hash.String(path)).String() + ".tsv"
+	require.NoError(t, ioutil.WriteFile(cacheLeasePath(cacheName),
+		[]byte(fmt.Sprintf("%s %d", getCacheLeaseOwner(), time.Now().Add(time.Hour).UnixNano())), 0600))
+	v = eval("forceSrc | force(format:=`tsv`, path:=`" + path + "`)")
+	assert.Equal(t, want, doReadTable(v))
+	data, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "a\tb\n2\ty\n3\tz\n", string(data))
+	_, ok = readCacheLease(ctx, cacheName)
+	assert.True(t, ok)
+	require.NoError(t, os.Remove(cacheLeasePath(cacheName)))
+
+	// A failed write releases the lease.
+	failing := &failingTable{Table: NewSimpleTable(nil, hash.String("TestForceFailure"), TableAttrs{})}
+	assert.Panics(t, func() { forceTable(ctx, astUnknown, failing, "btsv", "", 1) })
+	_, ok = readCacheLease(ctx, failing.Hash().String()+".btsv")
+	assert.False(t, ok)
+
+	assert.Panics(t, func() { eval("forceSrc | force(format:=`csv`)") })
+	assert.Panics(t, func() { eval("forceSrc | force(format:=`tsv`, shards:=2)") })
+	assert.Panics(t, func() { eval("forceSrc | force(shards:=0)") })
+}
@@ -52,7 +52,8 @@ type FormalArg struct {
 
 	// Closure is true if the argument is treated as a lambda body with the args
 	// specified in ClosureArgs. A Closure argument is translated into a
-	// user-defined function during the analysis phase.
+	// user-defined function during the analysis phase. ClosureArgs may be empty,
+	// in which case the function takes no arg.
 	Closure     bool
 	ClosureArgs []ClosureFormalArg
 
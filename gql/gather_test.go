@@ -3,8 +3,11 @@ package gql_test
 import (
 	"testing"
 
-	"github.com/grailbio/testutil/expect"
+	"github.com/grailbio/gql/gql"
 	"github.com/grailbio/gql/gqltest"
+	"github.com/grailbio/gql/symbol"
+	"github.com/grailbio/testutil/expect"
+	"github.com/grailbio/testutil/h"
 )
 
 func TestSpreadGather(t *testing.T) {
@@ -49,3 +52,61 @@ func TestSpreadGather(t *testing.T) {
 		t1)
 
 }
+
+func TestSpreadGatherMultipleValues(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `t0 := table(
+		{id:"a", depth_s1:1.5, count_s1:10, depth_s2:2.5, count_s2:20},
+		{id:"b", depth_s1:3.5, count_s1:30, depth_s2:4.5, count_s2:40})`, env)
+	long := []string{
+		"{id:a,sample:s1,depth:1.5,count:10}",
+		"{id:a,sample:s2,depth:2.5,count:20}",
+		"{id:b,sample:s1,depth:3.5,count:30}",
+		"{id:b,sample:s2,depth:4.5,count:40}",
+	}
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `t1 := t0 | gather("depth_s1", "count_s1", "depth_s2", "count_s2", key:="sample", value:="depth,count")`, env)),
+		long)
+	// The values keep their types.
+	row := gqltest.Eval(t, `t1 | pick(true)`, env).Struct(nil)
+	depth, _ := row.Value(symbol.Intern("depth"))
+	count, _ := row.Value(symbol.Intern("count"))
+	expect.EQ(t, depth.Type(), gql.FloatType)
+	expect.EQ(t, count.Type(), gql.IntType)
+
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `t1 | spread(key:="sample", value:="depth,count") | collapse("depth_s1", "count_s1", "depth_s2", "count_s2")`, env)),
+		[]string{
+			"{id:a,depth_s1:1.5,count_s1:10,depth_s2:2.5,count_s2:20}",
+			"{id:b,depth_s1:3.5,count_s1:30,depth_s2:4.5,count_s2:40}",
+		})
+
+	// A value missing for a key becomes NA.
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `t0 | gather("depth_s1", "count_s1", "depth_s2", key:="sample", value:="depth,count", sep:="_") | filter(&id=="a")`, env)),
+		[]string{
+			"{id:a,count_s2:20,sample:s1,depth:1.5,count:10}",
+			"{id:a,count_s2:20,sample:s2,depth:2.5,count:NA}",
+		})
+}
+
+func TestSpreadGatherCollisions(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `t0 := table({id:"a", x:1, y:2}, {id:"b", x:3, y:4})`, env)
+	expect.That(t,
+		func() { gqltest.ReadTable(gqltest.Eval(t, `t0 | gather("x", key:="id", value:="v")`, env)) },
+		h.Panics(h.Regexp("gather: column 'id' already exists")))
+	expect.That(t,
+		func() { gqltest.ReadTable(gqltest.Eval(t, `t0 | gather("x", "z", key:="k", value:="v")`, env)) },
+		h.Panics(h.Regexp("gather: column 'z' not found")))
+	expect.That(t,
+		func() { gqltest.Eval(t, `t0 | gather("x", "y", key:="k", value:="a,b")`, env) },
+		h.Panics(h.Regexp(`gather: column 'x' must be named "<value>_<key>"`)))
+	expect.That(t,
+		func() { gqltest.Eval(t, `t0 | gather("x", key:="k", value:="k")`, env) },
+		h.Panics(h.Regexp("gather: column 'k' is used more than once")))
+	gqltest.Eval(t, `t1 := table({id:"a", k:"id", v:1})`, env)
+	expect.That(t,
+		func() { gqltest.ReadTable(gqltest.Eval(t, `t1 | spread(key:="k", value:="v")`, env)) },
+		h.Panics(h.Regexp("spread: new column 'id' collides with an existing column")))
+}
@@ -6,8 +6,10 @@ import (
 	"encoding/gob"
 	"fmt"
 	"math"
+	"math/rand"
 	"testing"
 
+	"github.com/grailbio/gql/hash"
 	"github.com/grailbio/gql/marshal"
 	"github.com/grailbio/gql/symbol"
 	"github.com/grailbio/gql/termutil"
@@ -128,6 +130,7 @@ func TestMarshalTable(t *testing.T) {
 		fmt.Sprintf("read(`%s`) | firstn(10)", path),
 		fmt.Sprintf("read(`%s`) | map({$A,$B}, filter:=$A==2)", path),
 		fmt.Sprintf("flatten(table(read(`%s`), read(`%s`)))", path, path2),
+		fmt.Sprintf("join({a:read(`%s`), b:read(`%s`)}, $a.A==$b.A)", path, path),
 	} {
 		t.Logf("GobTable: test %s", testExpr)
 		v := doEval(t, testExpr, sess)
@@ -138,6 +141,32 @@ func TestMarshalTable(t *testing.T) {
 	}
 }
 
+func TestMarshalJoinNodes(t *testing.T) {
+	ctx := context.Background()
+	sess := newSession()
+	sub := &joinSubTable{index: 0, total: 1, name: symbol.Intern("t0"), table: doEval(t, "table({x:2}, {x:1})", sess).Table(nil)}
+	leaf := newJoinLeafNode(sub)
+	col := joinColumn{
+		table:   sub,
+		col:     symbol.Intern("x"),
+		keyExpr: newJoinKeyClosure(newJoinKeyAST(sub.name, symbol.Intern("x")))}
+	for _, node := range []joinNode{leaf, newJoinSortingNode(ctx, leaf, col)} {
+		ctxData, data := TestMarshalValue(t, NewTable(node))
+		assert.Equal(t, doReadTable(NewTable(node)), doReadTable(TestUnmarshalValue(t, ctxData, data)))
+	}
+}
+
+func TestMarshalRemoteArgsError(t *testing.T) {
+	var msg string
+	func() {
+		defer func() { msg = fmt.Sprint(recover()) }()
+		marshalRemoteArgs(context.Background(), &ASTColumnRef{Col: symbol.Intern("foo")}, func(ctx MarshalContext, enc *marshal.Encoder) {
+			panic("unmarshalable")
+		})
+	}()
+	assert.Regexp(t, "foo.*distributed execution.*unmarshalable", msg)
+}
+
 func printValueLong(v Value) string {
 	out := termutil.NewBufferPrinter()
 	args := PrintArgs{
@@ -185,3 +214,89 @@ func TestGobEnv(t *testing.T) {
 	v, _ = rt2.Lookup(symbol.Intern("bar"))
 	assert.Equal(t, "blah", v.Str(nil))
 }
+
+func TestUnmarshalFormatHeader(t *testing.T) {
+	ctxData, valData := TestMarshalValue(t, NewInt(10))
+	assert.Equal(t, int64(10), TestUnmarshalValue(t, ctxData, valData).Int(nil))
+
+	hdrLen := len(marshalFormatMagic) + 1 + len(hash.Zero)
+	// Unsupported version.
+	bad := append([]byte(nil), ctxData...)
+	bad[len(marshalFormatMagic)] = 2 * (marshalFormatVersion + 1) // zigzag varint
+	assert.Panics(t, func() { TestUnmarshalValue(t, bad, valData) })
+	assert.Panics(t, func() { newStoredUnmarshalContext(bad) })
+	// Different builtin symbols.
+	bad = append([]byte(nil), ctxData...)
+	bad[len(marshalFormatMagic)+1] ^= 1
+	assert.Panics(t, func() { TestUnmarshalValue(t, bad, valData) })
+	newStoredUnmarshalContext(bad) // OK, since the context has no frame.
+	// No header.
+	assert.Panics(t, func() { TestUnmarshalValue(t, ctxData[hdrLen:], valData) })
+	newStoredUnmarshalContext(ctxData[hdrLen:])
+	// Unknown value type.
+	assert.Panics(t, func() { TestUnmarshalValue(t, ctxData, []byte{0x7f}) })
+}
+
+// TestUnmarshalCorruptData checks that the decoder fails with a panic on
+// corrupt data, rather than crashing the process or hanging.
+func TestUnmarshalCorruptData(t *testing.T) {
+	sess := newSession()
+	values := []Value{
+		doEval(t, "{a:1, b:2.0, c:`abc`, d:NA, e:-NA, f:true, g:'x', h:2017-12-22T03:05:32-0700}", sess),
+		doEval(t, "{a:{b:{c:`def`, d:2018-01-02}, e:`ghi`}, f:1}", sess),
+		doEval(t, "table({row:`r1`, col:`c1`, value:1.5}, {row:`r2`, col:`c2`, value:2}) | to_matrix()", sess),
+	}
+	r := rand.New(rand.NewSource(0))
+	corrupt := func(data []byte) []byte {
+		data = append([]byte(nil), data...)
+		switch r.Intn(3) {
+		case 0:
+			data = data[:r.Intn(len(data))]
+		case 1:
+			data[r.Intn(len(data))] = byte(r.Intn(256))
+		default:
+			data[r.Intn(len(data))] ^= 1 << uint(r.Intn(8))
+		}
+		return data
+	}
+	for _, v := range values {
+		ctxData, valData := TestMarshalValue(t, v)
+		assert.Equal(t, printValueLong(v), printValueLong(TestUnmarshalValue(t, ctxData, valData)))
+		for i := 0; i < 2000; i++ {
+			cd, vd := ctxData, valData
+			if r.Intn(4) == 0 {
+				cd = corrupt(cd)
+			} else {
+				vd = corrupt(vd)
+			}
+			func() {
+				defer func() { _ = recover() }()
+				TestUnmarshalValue(t, cd, vd)
+			}()
+		}
+	}
+}
+
+func TestMarshalLargeCapturedTable(t *testing.T) {
+	sess := newSession()
+	defer func(old int) { maxCapturedValueSize = old }(maxCapturedValueSize)
+	maxCapturedValueSize = 64
+
+	doEval(t, "capturedSmall := table({a:1})", sess)
+	doEval(t, "capturedLarge := table({a:1, b:`xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx`}, {a:2, b:`yyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyyy`})", sess)
+	f := doEval(t, "func(x) { x + count(capturedSmall) + count(capturedLarge) }", sess)
+	ctx, data := TestMarshalValue(t, f)
+	f2 := TestUnmarshalValue(t, ctx, data).Func(nil)
+
+	v, ok := f2.env.Lookup(symbol.Intern("capturedLarge"))
+	require.True(t, ok)
+	_, isBTSV := v.Table(nil).(*btsvTable)
+	assert.True(t, isBTSV)
+	assert.Equal(t, doReadTable(doEval(t, "capturedLarge", sess)), doReadTable(v))
+
+	v, ok = f2.env.Lookup(symbol.Intern("capturedSmall"))
+	require.True(t, ok)
+	_, isBTSV = v.Table(nil).(*btsvTable)
+	assert.False(t, isBTSV)
+	assert.Equal(t, []string{"{a:1}"}, doReadTable(v))
+}
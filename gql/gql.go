@@ -8,11 +8,12 @@ import (
 	"os"
 	"regexp"
 	"sync"
+	"text/scanner"
+	"time"
 
-	"github.com/grailbio/base/file"
 	"github.com/grailbio/base/log"
-	"github.com/grailbio/base/status"
 	"github.com/grailbio/bigslice/exec"
+	"github.com/grailbio/gql/hash"
 	"github.com/grailbio/gql/symbol"
 	"github.com/pkg/errors"
 )
@@ -23,6 +24,17 @@ const (
 	DefaultLocalCacheRoot = "/tmp/grail-query/cache4"
 )
 
+// Version is the gql version recorded in the header of TSV files written by
+// write(..., metadata:=...). It is set at link time, e.g.,
+// -ldflags "-X github.com/grailbio/gql/gql.Version=v1.2.3".
+var Version = "devel"
+
+// DefaultCacheLeaseTimeout is the default value of Opts.CacheLeaseTimeout.
+const DefaultCacheLeaseTimeout = time.Hour
+
+// DefaultMaxExprDepth is the default value of Opts.MaxExprDepth.
+const DefaultMaxExprDepth = 1000
+
 var (
 	// Variables in this block are copied from Opts in Init.
 
@@ -30,10 +42,39 @@ var (
 	// explicitly pass a context. Copied from Opts.BackgroundContext in Init.
 	BackgroundContext context.Context
 	cacheRoot         string
-	// Bigslice session to be used for distributed operations.
+	// localCacheRoot, if nonempty, is a local directory that caches the contents
+	// of cacheRoot.
+	localCacheRoot string
+	// readOnlyCache disables creation of new entries in cacheRoot.
+	readOnlyCache bool
+	// cacheLeaseTimeout is the max time a process may take to fill a cache
+	// entry before other processes take over.
+	cacheLeaseTimeout time.Duration
+	// Bigslice session to be used for distributed operations. If nil, they run
+	// in this process. See local_exec.go.
 	bsSession *exec.Session
 	// overwriteFiles controls whether write() overwrites existing files.
 	overwriteFiles bool
+	// shadowingCheck is copied from Opts.Shadowing.
+	shadowingCheck ShadowingCheck
+	// maxExprDepth is copied from Opts.MaxExprDepth.
+	maxExprDepth = DefaultMaxExprDepth
+	// denyDeprecated is copied from Opts.DenyDeprecated.
+	denyDeprecated bool
+	// loadPath is copied from Opts.LoadPath.
+	loadPath []string
+	// nanAsNull is copied from Opts.NaNAsNull.
+	nanAsNull bool
+	// maxCellWidth is copied from Opts.MaxCellWidth.
+	maxCellWidth int
+	// verboseErrors is copied from Opts.VerboseErrors.
+	verboseErrors bool
+	// strictTSV is copied from Opts.StrictTSV.
+	strictTSV bool
+	// naOrder is copied from Opts.NAOrder.
+	naOrder NAOrder
+	// shareSubexprs is copied from Opts.ShareTableSubexprs.
+	shareSubexprs bool
 	// Path RE of files assumed to be immutable. Immutable files are hashed
 	// quickly by just using their pathnames.
 	immutableFilesRE []*regexp.Regexp
@@ -57,17 +98,147 @@ type Opts struct {
 	// unset, gql.DefaultCacheRoot is used when bsSession != nil. Else
 	// gql.DefaultLocalCacheRoot is used.
 	CacheDir string
+	// LocalCacheDir, if set, is a local directory used as a read-through cache
+	// of CacheDir. It is useful when CacheDir is an S3 prefix shared by multiple
+	// users.
+	LocalCacheDir string
+	// ReadOnlyCache disables writes to CacheDir. Tables materialized during the
+	// session are instead stored in LocalCacheDir, or
+	// gql.DefaultLocalCacheRoot if LocalCacheDir is unset. It is typically set
+	// for interactive sessions that share CacheDir with batch jobs.
+	ReadOnlyCache bool
+	// CacheLeaseTimeout is the max duration a process may take to fill a
+	// cache entry. Until then, other processes looking up the same entry wait for
+	// it. If zero, gql.DefaultCacheLeaseTimeout is used.
+	CacheLeaseTimeout time.Duration
+	// RemoteScratchDir, if set, is a directory (typically an S3 prefix) that
+	// stores temporary files, such as intermediate sort runs, when the local disk
+	// that stores the cache has less than LocalScratchMinFree bytes available.
+	RemoteScratchDir string
+	// LocalScratchMinFree is the min number of free bytes on the local disk
+	// below which temporary files are spilled to RemoteScratchDir. If zero,
+	// gql.DefaultLocalScratchMinFree is used.
+	LocalScratchMinFree int64
+	// S3MaxRetries is the max number of times a failed read of a table file is
+	// retried before the query fails. The file is reopened and read from where
+	// the previous attempt left off. Despite the name, it applies to files of
+	// any type, but in practice only S3 reads fail transiently. If zero,
+	// gql.DefaultS3MaxRetries is used. If negative, reads are not retried.
+	S3MaxRetries int
+	// S3RetryBackoff is the initial wait before retrying a failed read. The
+	// wait grows exponentially up to a minute. If zero,
+	// gql.DefaultS3RetryBackoff is used.
+	S3RetryBackoff time.Duration
+	// S3ReadAhead, if positive, causes S3 files to be read in chunks of this
+	// many bytes ahead of the consumer. If zero, S3 files are read
+	// synchronously.
+	S3ReadAhead int
+	// S3ReadConcurrency is the max number of S3ReadAhead-sized chunks of a file
+	// fetched in parallel. If zero, one chunk is fetched at a time.
+	S3ReadConcurrency int
+	// PrefetchTables is the max number of tables that flatten() reads ahead
+	// of the consumer, e.g., when flattening readdir(...) | map(read(_)). If
+	// zero, gql.DefaultPrefetchTables is used. If negative, tables are read
+	// only when the consumer gets to them.
+	PrefetchTables int
+	// PrefetchMemory is the max total size, in bytes, of the rows read ahead
+	// by flatten() in the process. A table that doesn't fit is read partially
+	// ahead of time. If zero, gql.DefaultPrefetchMemory is used.
+	PrefetchMemory int64
 	// OverwriteFiles controls whether write() function overwrites existing files.
 	OverwriteFiles bool
-	// BigsliceSession is an initialized bigslice session. If unset, a local
-	// bigslice executor will be created.
+	// Shadowing specifies how a variable binding in a block that shadows
+	// another variable is reported. The default is ShadowingAllow.
+	Shadowing ShadowingCheck
+	// MaxExprDepth is the max nesting depth of an expression. A pipeline of N
+	// stages counts as N levels. Analysis of a deeper expression fails. Values
+	// nested deeper than this are printed as "...". If <= 0,
+	// DefaultMaxExprDepth is used.
+	MaxExprDepth int
+	// DenyDeprecated causes evaluation of a script that uses deprecated syntax,
+	// such as "$col", to fail. By default, such uses are reported as warnings.
+	DenyDeprecated bool
+	// LoadPath lists the directories searched for the scripts named in load
+	// statements, after the directory of the loading script and the current
+	// directory. The directories may be S3 prefixes.
+	LoadPath []string
+	// BigsliceSession is an initialized bigslice session. If unset, the
+	// builtins invoked with shards>0 run their shards as goroutines in this
+	// process, without bigslice.
 	BigsliceSession *exec.Session
+	// LocalParallelism is the max number of shards run at a time in this
+	// process. It is used only when BigsliceSession is unset. If <= 0,
+	// runtime.NumCPU() is used.
+	LocalParallelism int
 	// ImmutableFilesRE x lists regexps of paths of files that can be assumed to
 	// be immutable.  Immutable files can be hashed quickly using just their
 	// pathnames, so they improve performance of gql.
 	//
 	// If nil, "^s3://grail-clinical.*" and "^s3://grail-results.*" are used.
 	ImmutableFilesRE []*regexp.Regexp
+	// RowTransformers are applied to the rows of the tables read from files,
+	// before the rows become visible to the session. They can be used to enforce
+	// de-identification, e.g., by setting Transform to MaskColumns.
+	RowTransformers []RowTransformer
+	// NaNAsNull causes NaNs computed by arithmetic operators and float() to be
+	// converted to NA.
+	NaNAsNull bool
+	// MaxCellWidth is the max width, in terminal columns, of a table cell
+	// printed in the table format. A wider cell is truncated and ends with "…".
+	// It can be overridden by print(..., full:=true). If <= 0, cells are not
+	// truncated.
+	MaxCellWidth int
+	// VerboseErrors causes the errors for unknown variables and columns to
+	// include all the variable bindings or the contents of the row. By default,
+	// they show only the names similar to the unknown one.
+	VerboseErrors bool
+	// StrictTSV causes read() of a TSV file to fail when a cell can't be
+	// converted to the column type without loss, e.g., "1.5" in an int column.
+	// By default, such a cell is truncated, and the truncations are counted in
+	// table_attrs(...).coercions. It can also be set per file by read(...,
+	// strict:=true).
+	StrictTSV bool
+	// NAOrder specifies where sort(), minn(), min(), max(), and merge joins
+	// place NA values. sort() and minn() can override it with the na:= arg. The
+	// default is NAOrderDefault.
+	NAOrder NAOrder
+	// ShareTableSubexprs causes a table-valued subexpression that appears more
+	// than once in a statement to be evaluated once. The table is materialized
+	// in the cache when it is scanned the first time.
+	ShareTableSubexprs bool
+	// MaskSalt is mixed into the hashes computed by mask(how:="hash") and
+	// MaskColumns(..., MaskHash), so that the masked values can't be recovered
+	// by hashing candidate values.
+	MaskSalt string
+	// Notifiers are the channels used by the notify() builtin, keyed by the
+	// channel name, e.g., "slack" or "email". If nil, notify() only logs the
+	// messages.
+	Notifiers map[string]Notifier
+
+	// The following hooks report the progress of evaluation to an application
+	// that embeds gql. Each may be nil. They may be called concurrently, so they
+	// must be thread safe. Only the events in this process are reported; the
+	// files read and written by remote bigslice workers are not.
+
+	// OnStatementStart is called before evaluating each toplevel statement.
+	OnStatementStart func(stmt ASTStatement)
+	// OnStatementEnd is called after evaluating each toplevel statement. Arg err
+	// is non-nil if the evaluation failed.
+	OnStatementEnd func(stmt ASTStatement, d time.Duration, err error)
+	// OnTableMaterialized is called when a table is written to the cache
+	// directory, e.g., by force() or a distributed operation. Arg rows is -1 if
+	// the number of rows is not known without reading the table.
+	OnTableMaterialized func(h hash.Hash, path string, rows int)
+	// OnFileRead is called when a table file opened for reading is closed. Arg
+	// bytes is the number of bytes read from the file.
+	OnFileRead func(path string, bytes int64)
+	// OnFileWrite is called when a TSV or BTSV file (or a BTSV shard file) is
+	// closed after writing. Arg bytes is the size of the file.
+	OnFileWrite func(path string, bytes int64)
+	// Limits are the default resource limits of each evaluation in a session.
+	// They can be overridden by Session.SetLimits. By default, there is no
+	// limit.
+	Limits Limits
 }
 
 var initMu sync.Mutex
@@ -89,6 +260,19 @@ type Session struct {
 
 	// Stores inferred types of AST nodes.
 	types *astTypes
+
+	// Temp files created while evaluating expressions in this session.
+	temps *tempNamespace
+
+	// Source locations of deprecated syntax already reported. Guarded by mu.
+	reportedDeprecations map[string]bool
+	// Names of the libraries registered by RegisterStdlib that have been
+	// evaluated in this session. Guarded by mu.
+	loadedStdlibs map[string]bool
+	// Set by SetWarningHandler. Guarded by mu.
+	warningHandler func(pos scanner.Position, msg string)
+	// Resource limits of each evaluation. Set by SetLimits. Guarded by mu.
+	limits Limits
 }
 
 // Bindings returrs the bindings for the global symbols.
@@ -105,7 +289,11 @@ func (s *Session) aiBindings() aiBindings { return s.aiEnv }
 // EvalFile reads a script and evaluates it. Returns the value computed by the
 // last expression.
 func (s *Session) EvalFile(ctx context.Context, path string) Value {
-	text, err := file.ReadFile(ctx, path)
+	ctx, cancel := withEvalBudget(ctx, s.Limits())
+	defer cancel()
+	ctx = withTempNamespace(ctx, s.temps)
+	recordInputFile(ctx, path)
+	text, err := readScript(ctx, path)
 	if err != nil {
 		log.Panicf("open %v: %v", path, err)
 	}
@@ -139,6 +327,10 @@ func (p *parserState) Error(s string) {
 // returns io.EOF if the text is incomplete. If the text is unparsable for other
 // reasons, it returns non-nil errors other than io.EOF.
 func (s *Session) Parse(filename string, text []byte) ([]ASTStatementOrLoad, error) {
+	return parse(filename, text)
+}
+
+func parse(filename string, text []byte) ([]ASTStatementOrLoad, error) {
 	p := parserState{lex: newLexer(filename, bytes.NewReader(text))}
 	yyParse(&p)
 	if p.err != nil {
@@ -176,10 +368,31 @@ func (s *Session) SetGlobals(vars map[string]Value) {
 	s.env = newEnv
 }
 
+// UnsetGlobals removes the given global variables. Names that are not defined
+// are ignored.
+func (s *Session) UnsetGlobals(names []string) {
+	if len(names) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	newEnv := s.env.clone()
+	for _, name := range names {
+		sym := symbol.Intern(name)
+		newEnv.frames[1].unset(sym)
+		delete(s.aiEnv.Frames[1], sym)
+	}
+	s.env = newEnv
+}
+
 // EvalStatements evaluates the statement and returns the value of the expression
 // within. If st is of form "var := expr", binds var to the result of the
 // expression so that subsequent Eval calls can refer to the variable.
 func (s *Session) EvalStatements(ctx context.Context, statements []ASTStatementOrLoad) Value {
+	checkRequiredVersions(statements)
+	ctx, cancel := withEvalBudget(ctx, s.Limits())
+	defer cancel()
+	ctx = withTempNamespace(ctx, s.temps)
 	var loads, others []ASTStatementOrLoad
 	for _, st := range statements {
 		if st.LoadPath != "" {
@@ -197,11 +410,13 @@ func (s *Session) EvalStatements(ctx context.Context, statements []ASTStatementO
 	// Process loads first.
 	var val Value
 	for _, st := range loads {
-		data, err := file.ReadFile(ctx, st.LoadPath)
+		path := resolveLoadPath(ctx, st.LoadPath, st.Pos.Filename)
+		recordInputFile(ctx, path)
+		data, err := readScript(ctx, path)
 		if err != nil {
 			log.Panicf("load %s: %v", st.LoadPath, err)
 		}
-		subStatements, err := s.Parse(st.LoadPath, data)
+		subStatements, err := s.Parse(path, data)
 		if err != nil {
 			log.Panicf("load %s: %v", st.LoadPath, err)
 		}
@@ -211,14 +426,22 @@ func (s *Session) EvalStatements(ctx context.Context, statements []ASTStatementO
 		val = s.EvalStatements(ctx, subStatements)
 	}
 
+	s.loadStdlibs(ctx, others)
+
 	analyze := func() {
 		s.mu.Lock()
 		defer s.mu.Unlock()
+		s.reportDeprecations(others)
 		for _, st := range others {
 			s.types.add(st.Expr, &s.aiEnv)
 			transformAST(s.types, &st.Expr)
 			if st.LHS != symbol.Invalid {
-				s.aiEnv.setGlobal(st.LHS, s.types.getType(st.Expr))
+				checkBinding(&st.ASTStatement, s.aiEnv, false)
+				typ := s.types.getType(st.Expr)
+				if st.Const {
+					typ = constType(&st.ASTStatement, typ)
+				}
+				s.aiEnv.setGlobal(st.LHS, typ)
 			}
 		}
 	}
@@ -233,7 +456,9 @@ func (s *Session) EvalStatements(ctx context.Context, statements []ASTStatementO
 
 	analyze()
 	for _, st := range others {
-		val = st.Expr.eval(ctx, s.Bindings())
+		val = evalStatement(st.ASTStatement, func() Value {
+			return st.Expr.eval(withSharedTables(ctx), s.Bindings())
+		})
 		if st.LHS != symbol.Invalid {
 			setGlobal(st.LHS, val)
 		}
@@ -243,7 +468,41 @@ func (s *Session) EvalStatements(ctx context.Context, statements []ASTStatementO
 
 // Eval evaluates an expression.
 func (s *Session) Eval(ctx context.Context, expr ASTNode) Value {
-	return expr.eval(ctx, s.Bindings())
+	ctx, cancel := withEvalBudget(ctx, s.Limits())
+	defer cancel()
+	return expr.eval(withSharedTables(withTempNamespace(ctx, s.temps)), s.Bindings())
+}
+
+// SetLimits sets the resource limits of the subsequent evaluations in the
+// session. It overrides Opts.Limits.
+func (s *Session) SetLimits(limits Limits) {
+	s.mu.Lock()
+	s.limits = limits
+	s.mu.Unlock()
+}
+
+// Limits returns the resource limits of the evaluations in the session.
+func (s *Session) Limits() Limits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limits
+}
+
+// WithLimits returns a context that applies the resource limits of the session
+// to the work done under it. The Eval methods apply the limits by themselves,
+// but a table that they return is read lazily, so an application should also
+// read the result, e.g., print it, under this context. The caller must call the
+// cancel func when done.
+func (s *Session) WithLimits(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withEvalBudget(ctx, s.Limits())
+}
+
+// Close removes the temp files created by the session, and the cache entries
+// that the session started, but failed to produce. Cache entries that were
+// produced successfully are kept. Tables that refer to the temp files must not
+// be read after Close. The session must not be used after Close.
+func (s *Session) Close(ctx context.Context) {
+	s.temps.cleanup(ctx)
 }
 
 // NewSession creates a new empty session.
@@ -262,7 +521,9 @@ func NewSession() *Session {
 				aiGlobalConsts,
 				aiFrame{},
 			}},
-		types: newASTTypes(),
+		types:  newASTTypes(),
+		temps:  newTempNamespace(),
+		limits: defaultLimits,
 	}
 	return s
 }
@@ -283,6 +544,13 @@ func Init(opts Opts) {
 	initMu.Unlock()
 
 	overwriteFiles = opts.OverwriteFiles
+	shadowingCheck = opts.Shadowing
+	maxExprDepth = opts.MaxExprDepth
+	denyDeprecated = opts.DenyDeprecated
+	loadPath = opts.LoadPath
+	if maxExprDepth <= 0 {
+		maxExprDepth = DefaultMaxExprDepth
+	}
 	immutableFilesRE = opts.ImmutableFilesRE
 	if immutableFilesRE == nil {
 		immutableFilesRE = []*regexp.Regexp{
@@ -291,19 +559,72 @@ func Init(opts Opts) {
 			regexp.MustCompile("^s3://grail-tidy-datasets.*"),
 		}
 	}
+	rowTransformers = opts.RowTransformers
+	maskSalt = opts.MaskSalt
+	nanAsNull = opts.NaNAsNull
+	maxCellWidth = opts.MaxCellWidth
+	verboseErrors = opts.VerboseErrors
+	strictTSV = opts.StrictTSV
+	naOrder = opts.NAOrder
+	shareSubexprs = opts.ShareTableSubexprs
+	notifiers = opts.Notifiers
+	onStatementStart = opts.OnStatementStart
+	onStatementEnd = opts.OnStatementEnd
+	onTableMaterialized = opts.OnTableMaterialized
+	onFileRead = opts.OnFileRead
+	onFileWrite = opts.OnFileWrite
+	defaultLimits = opts.Limits
 	symbol.MarkPreInternedSymbols()
 	bsSession = opts.BigsliceSession
 	cacheRoot = opts.CacheDir
 
+	distributed = bsSession != nil
+	if opts.LocalParallelism > 0 {
+		localParallelism = opts.LocalParallelism
+	}
 	if bsSession == nil {
-		bsSession = exec.Start(exec.Local, exec.Status(new(status.Status)))
 		if cacheRoot == "" {
 			cacheRoot = DefaultLocalCacheRoot
 		}
 	} else if cacheRoot == "" {
 		cacheRoot = DefaultCacheRoot
 	}
-	log.Debug.Printf("gql: using cachedir %s", cacheRoot)
+	localCacheRoot = opts.LocalCacheDir
+	readOnlyCache = opts.ReadOnlyCache
+	cacheLeaseTimeout = opts.CacheLeaseTimeout
+	if cacheLeaseTimeout == 0 {
+		cacheLeaseTimeout = DefaultCacheLeaseTimeout
+	}
+	log.Debug.Printf("gql: using cachedir %s (local: %s, readonly: %v)", cacheRoot, localCacheRoot, readOnlyCache)
+	remoteScratchRoot = opts.RemoteScratchDir
+	if opts.LocalScratchMinFree > 0 {
+		localScratchMinFree = opts.LocalScratchMinFree
+	}
+	if opts.S3MaxRetries != 0 {
+		s3MaxRetries = opts.S3MaxRetries
+	}
+	if opts.S3RetryBackoff > 0 {
+		s3RetryBackoff = opts.S3RetryBackoff
+	}
+	if opts.S3ReadConcurrency > 0 {
+		s3ReadConcurrency = opts.S3ReadConcurrency
+	}
+	s3ReadAhead = opts.S3ReadAhead
+	if opts.PrefetchTables != 0 {
+		prefetchTables = opts.PrefetchTables
+	}
+	if opts.PrefetchMemory > 0 {
+		prefetchMemory = opts.PrefetchMemory
+	}
+	go func() {
+		sweepOrphanedTempDirs(BackgroundContext, cacheWriteRoot())
+		if root := pendingCacheManifestRoot(); root != cacheWriteRoot() {
+			sweepOrphanedTempDirs(BackgroundContext, root)
+		}
+		if remoteScratchRoot != "" {
+			sweepOrphanedTempDirs(BackgroundContext, remoteScratchRoot)
+		}
+	}()
 	if os.Getenv("BIGMACHINE_MODE") != "" {
 		panic("bigmachine slave")
 	}
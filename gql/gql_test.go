@@ -1,9 +1,13 @@
 package gql_test
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"math/rand"
 	"os"
@@ -220,6 +224,24 @@ func TestBlock(t *testing.T) {
 	assert.Equal(t, int64(103), gqltest.Eval(t, "f1(3)", env).Int(nil))
 }
 
+func TestImplicitColumnRefInLambda(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `t0 := table({a:1, b:10}, {a:2, b:20})`, env)
+	// '&' in the body of a one-arg function refers to the function's arg.
+	assert.Equal(t, []string{"{c:12}", "{c:23}"},
+		gqltest.ReadTable(gqltest.Eval(t, `t0 | map(|r| {c: &a + &b + 1})`, env)))
+	gqltest.Eval(t, `func total(row) &a + &b`, env)
+	assert.Equal(t, []string{"11", "22"}, gqltest.ReadTable(gqltest.Eval(t, `t0 | map(total(_))`, env)))
+	gqltest.Eval(t, `f2 := |row| { x := &a * 100; x + &b }`, env)
+	assert.Equal(t, []string{"110", "220"}, gqltest.ReadTable(gqltest.Eval(t, `t0 | map(f2(_))`, env)))
+	// '&' in a function-call arg inside a function is expanded relative to that
+	// arg, as before.
+	assert.Equal(t, []string{"{a:2,n:1}"},
+		gqltest.ReadTable(gqltest.Eval(t, `t0 | filter(|r| count(t0 | filter(&a > r.a)) == 0) | map({a:&a, n:1})`, env)))
+	assert.Equal(t, []string{"{a:1,n:1}", "{a:2,n:0}"},
+		gqltest.ReadTable(gqltest.Eval(t, `t0 | map({a:&a, n:count(t0 | filter(|r| r.a > &a))})`, env)))
+}
+
 func TestTranspose(t *testing.T) {
 	env := gqltest.NewSession()
 	gqltest.Eval(t, `T0 := table(
@@ -381,6 +403,147 @@ func TestConcat(t *testing.T) {
 	assert.Equal(t,
 		[]string{"{fi:0,fs:ab2}", "{fi:3,fs:ab0}", "{fj:1,fs:cd2}", "{fj:4,fs:ab0}", "{fj:1,fk:11}", "{fj:4,fj:12}"},
 		gqltest.ReadTable(gqltest.Eval(t, "concat(flatten(table(T0, T1)), T2)", env)))
+
+	assert.Equal(t,
+		[]string{"{fi:0,fs:ab2,fj:NA}", "{fi:3,fs:ab0,fj:NA}", "{fi:NA,fs:cd2,fj:1}", "{fi:NA,fs:ab0,fj:4}"},
+		gqltest.ReadTable(gqltest.Eval(t, `concat(T0, T1, align:="union")`, env)))
+	assert.Equal(t,
+		[]string{"{fs:ab2}", "{fs:ab0}", "{fs:cd2}", "{fs:ab0}"},
+		gqltest.ReadTable(gqltest.Eval(t, `concat(flatten(table(T0)), T1, align:="intersect")`, env)))
+	assert.Equal(t,
+		[]string{"{fi:0,fs:ab2}", "{fi:3,fs:ab0}", "{fi:0,fs:ab2}", "{fi:3,fs:ab0}"},
+		gqltest.ReadTable(gqltest.Eval(t, `concat(T0, T0, align:="error")`, env)))
+	assert.Panics(t, func() {
+		gqltest.ReadTable(gqltest.Eval(t, `concat(T0, T1, align:="error")`, env))
+	})
+}
+
+func TestZip(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `cov := table({bin:0, depth:10}, {bin:1, depth:12})`, env)
+	gqltest.Eval(t, `gc := table({bin:0, gc:0.4}, {bin:1, gc:0.6})`, env)
+	gqltest.Eval(t, `short := table({bin:0, gc:0.5})`, env)
+
+	assert.Equal(t,
+		[]string{"{cov:{bin:0,depth:10},gc:{bin:0,gc:0.4}}", "{cov:{bin:1,depth:12},gc:{bin:1,gc:0.6}}"},
+		gqltest.ReadTable(gqltest.Eval(t, "zip(cov, gc)", env)))
+	assert.Equal(t,
+		[]string{"{bin:0,depth:10,gc:0.4}", "{bin:1,depth:12,gc:0.6}"},
+		gqltest.ReadTable(gqltest.Eval(t, "cov | zip(gc) | map({bin:cov.bin, depth:cov.depth, gc:gc.gc})", env)))
+	assert.Equal(t,
+		[]string{"{cov:{bin:0,depth:10},t1:{bin:0,depth:10},t2:{bin:0}}", "{cov:{bin:1,depth:12},t1:{bin:1,depth:12},t2:{bin:1}}"},
+		gqltest.ReadTable(gqltest.Eval(t, "zip(cov, cov, cov | map({&bin}))", env)))
+	assert.Equal(t,
+		[]string{"{cov:{bin:0,depth:10},short:{bin:0,gc:0.5}}", "{cov:{bin:1,depth:12},short:NA}"},
+		gqltest.ReadTable(gqltest.Eval(t, "zip(cov, short, fill:=true)", env)))
+	assert.Panics(t, func() { gqltest.ReadTable(gqltest.Eval(t, "zip(cov, short)", env)) })
+}
+
+func TestEnumerateBatch(t *testing.T) {
+	env := gqltest.NewSession()
+	assert.Equal(t,
+		[]string{"{index:0,value:{a:x}}", "{index:1,value:{a:y}}"},
+		gqltest.ReadTable(gqltest.Eval(t, `table({a:"x"}, {a:"y"}) | enumerate()`, env)))
+	assert.Equal(t,
+		[]string{"2", "2", "1"},
+		gqltest.ReadTable(gqltest.Eval(t, `table(1, 2, 3, 4, 5) | batch(2) | map(count(_))`, env)))
+	assert.Equal(t,
+		[]string{"1", "2", "3", "4", "5"},
+		gqltest.ReadTable(gqltest.Eval(t, `flatten(table(1, 2, 3, 4, 5) | batch(2))`, env)))
+	assert.Equal(t,
+		[]string{"{index:0,value:3}", "{index:1,value:1}"},
+		gqltest.ReadTable(gqltest.Eval(t, `table(1, 2, 3, 4) | batch(3) | map(count(_)) | enumerate()`, env)))
+	assert.Panics(t, func() { gqltest.Eval(t, `table(1, 2) | batch(0)`, env) })
+}
+
+func TestCross(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `alpha := table(0.1, 0.5)`, env)
+	gqltest.Eval(t, `k := table(10, 20, 30)`, env)
+	assert.Equal(t,
+		[]string{"{alpha:0.1,k:10}", "{alpha:0.1,k:20}", "{alpha:0.1,k:30}", "{alpha:0.5,k:10}", "{alpha:0.5,k:20}", "{alpha:0.5,k:30}"},
+		gqltest.ReadTable(gqltest.Eval(t, `cross(alpha, k)`, env)))
+	assert.Equal(t,
+		[]string{"{alpha:0.1,t1:x,k:10}", "{alpha:0.1,t1:x,k:20}", "{alpha:0.1,t1:x,k:30}", "{alpha:0.5,t1:x,k:10}", "{alpha:0.5,t1:x,k:20}", "{alpha:0.5,t1:x,k:30}"},
+		gqltest.ReadTable(gqltest.Eval(t, `cross(alpha, table("x"), k)`, env)))
+	assert.Equal(t, int64(6), gqltest.Eval(t, `cross(alpha, k) | count()`, env).Int(nil))
+	assert.Equal(t, []string{}, gqltest.ReadTable(gqltest.Eval(t, `cross(alpha, k | filter(_ > 100))`, env)))
+	assert.Equal(t, 6, len(gqltest.ReadTable(gqltest.Eval(t, `cross(alpha, k, max_rows:=6)`, env))))
+}
+
+func TestRangeGenerators(t *testing.T) {
+	env := gqltest.NewSession()
+	assert.Equal(t, []string{"0", "1", "2", "3", "4"}, gqltest.ReadTable(gqltest.Eval(t, `range(0, 5)`, env)))
+	assert.Equal(t, []string{"10", "7", "4", "1"}, gqltest.ReadTable(gqltest.Eval(t, `range(10, 0, -3)`, env)))
+	assert.Equal(t, []string{}, gqltest.ReadTable(gqltest.Eval(t, `range(5, 0)`, env)))
+	assert.Equal(t, []string{"0", "0.25", "0.5", "0.75"}, gqltest.ReadTable(gqltest.Eval(t, `range(0, 1, 0.25)`, env)))
+	assert.Equal(t,
+		[]string{"{start:0,end:1000}", "{start:1000,end:2000}", "{start:2000,end:3000}"},
+		gqltest.ReadTable(gqltest.Eval(t, `range(0, 3000, 1000) | map({start:_, end:_+1000})`, env)))
+	assert.Equal(t, int64(1000000), gqltest.Eval(t, `range(0, 1000000) | count()`, env).Int(nil))
+	assert.Equal(t, []string{"{a:1}", "{a:1}", "{a:1}"}, gqltest.ReadTable(gqltest.Eval(t, `repeat({a:1}, 3)`, env)))
+	assert.Equal(t,
+		[]string{"2020-01-30", "2020-01-31", "2020-02-01", "2020-02-02"},
+		gqltest.ReadTable(gqltest.Eval(t, `dates(2020-01-30, 2020-02-02)`, env)))
+	assert.Equal(t,
+		[]string{"2020-01-31", "2020-02-29", "2020-03-31", "2020-04-30"},
+		gqltest.ReadTable(gqltest.Eval(t, `dates("2020-01-31", "2020-04-30", by:="1mo")`, env)))
+	assert.Equal(t, int64(5), gqltest.Eval(t, `dates(2020-01-01T00:00:00Z, 2020-01-02T00:00:00Z, by:=6h) | count()`, env).Int(nil))
+	assert.Panics(t, func() { gqltest.Eval(t, `range(0, 10, 0)`, env) })
+	assert.Panics(t, func() { gqltest.Eval(t, `dates(2020-01-01, 2020-02-01, by:="1q")`, env) })
+}
+
+func TestGenomeBins(t *testing.T) {
+	env := gqltest.NewSession()
+	assert.Equal(t,
+		[]string{"{chrom:chr1,start:0,end:1000000}", "{chrom:chr1,start:1000000,end:2000000}"},
+		gqltest.ReadTable(gqltest.Eval(t, `genome_bins(size:=1000000) | firstn(2)`, env)))
+	// chr1 of hg38 has 248956422 bases.
+	assert.Equal(t,
+		[]string{"{chrom:chr1,start:248000000,end:248956422}", "{chrom:chr2,start:0,end:1000000}"},
+		gqltest.ReadTable(gqltest.Eval(t, `genome_bins(size:=1000000) | filter(&start == 248000000 || (&chrom == "chr2" && &start == 0))`, env)))
+	assert.Equal(t, int64(3113), gqltest.Eval(t, `genome_bins(genome:="hg19", size:=1000000) | filter(&chrom != "chrM") | count()`, env).Int(nil))
+	assert.Equal(t,
+		[]string{"{chrom:c1,start:0,end:10}", "{chrom:c1,start:10,end:20}", "{chrom:c1,start:20,end:25}", "{chrom:c2,start:0,end:5}"},
+		gqltest.ReadTable(gqltest.Eval(t, `genome_bins(genome:=table({chrom:"c1", length:25}, {chrom:"c2", length:5}), size:=10)`, env)))
+	assert.Equal(t,
+		[]string{"{chrom:chr1,start:12345,bin:12000}", "{chrom:chr1,start:999,bin:0}"},
+		gqltest.ReadTable(gqltest.Eval(t, `table({chrom:"chr1", start:12345}, {chrom:"chr1", start:999}) | bin_assign(size:=1000)`, env)))
+	assert.Equal(t,
+		[]string{"{pos:150,b:100}"},
+		gqltest.ReadTable(gqltest.Eval(t, `table({pos:150, b:-1}) | bin_assign(size:=100, start:=&pos, col:="b")`, env)))
+	assert.Panics(t, func() { gqltest.Eval(t, `genome_bins(genome:="mm10")`, env) })
+}
+
+func TestGenomeMetadata(t *testing.T) {
+	env := gqltest.NewSession()
+	assert.Equal(t,
+		[]string{"{chrom:chr1,ensembl:1,length:248956422,index:0}"},
+		gqltest.ReadTable(gqltest.Eval(t, `genome("hg38") | firstn(1)`, env)))
+	assert.Equal(t,
+		[]string{"{chrom:chrM,ensembl:MT,length:16571,index:24}"},
+		gqltest.ReadTable(gqltest.Eval(t, `genome("hg19") | filter(&chrom == "chrM")`, env)))
+	assert.Equal(t, int64(25), gqltest.Eval(t, `genome_bins(genome:=genome("hg38"), size:=1000000000) | count()`, env).Int(nil))
+
+	for _, c := range []struct{ in, ucsc, ensembl string }{
+		{"1", "chr1", "1"},
+		{"chr1", "chr1", "1"},
+		{"Chr22", "chr22", "22"},
+		{"x", "chrX", "X"},
+		{"MT", "chrM", "MT"},
+		{"chrM", "chrM", "MT"},
+		{"chrUn_KI270302v1", "chrUn_KI270302v1", "Un_KI270302v1"},
+	} {
+		assert.Equal(t, c.ucsc, gqltest.Eval(t, fmt.Sprintf(`normalize_chrom("%s")`, c.in), env).Str(nil), c.in)
+		assert.Equal(t, c.ensembl, gqltest.Eval(t, fmt.Sprintf(`normalize_chrom("%s", style:="ensembl")`, c.in), env).Str(nil), c.in)
+	}
+	assert.Equal(t,
+		[]string{"{chrom:chr1,start:10,name:a}"},
+		gqltest.ReadTable(gqltest.Eval(t, `
+bed1 := table({chrom:"chr1", start:10});
+bed2 := table({chrom:"1", start:10, name:"a"}) | map({chrom:normalize_chrom(&chrom), &start, &name});
+join({b1:bed1, b2:bed2}, b1.chrom==b2.chrom && b1.start==b2.start, map:={chrom:b1.chrom, start:b1.start, name:b2.name})`, env)))
+	assert.Panics(t, func() { gqltest.Eval(t, `normalize_chrom("1", style:="ncbi")`, env) })
 }
 
 func TestParallelMap1(t *testing.T) {
@@ -451,6 +614,29 @@ func testMapNestedTables(t *testing.T, parallel bool) {
 func TestMapNestedTables(t *testing.T)         { testMapNestedTables(t, false) }
 func TestMapNestedTablesParallel(t *testing.T) { testMapNestedTables(t, true) }
 
+func testFlatMap(t *testing.T, parallel bool) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `T0 := table(
+{id:1, tokens:table("a", "b")},
+{id:2, tokens:table()},
+{id:3, tokens:table("c")})`, env)
+
+	shards := 0
+	if parallel {
+		shards = 1
+	}
+	assert.Equal(t,
+		[]string{"{id:1,token:a}", "{id:1,token:b}", "{id:3,token:c}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf(`T0 | flatmap(|r|(r.tokens | map({id:r.id, token:_})), shards:=%d)`, shards), env)))
+	assert.Equal(t,
+		[]string{"{id:3,token:c}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf(`T0 | flatmap(|r|(r.tokens | map({id:r.id, token:_})), filter:=&id>1, shards:=%d)`, shards), env)))
+	assert.Panics(t, func() { gqltest.Eval(t, `T0 | flatmap(&id)`, env) })
+}
+
+func TestFlatMap(t *testing.T)         { testFlatMap(t, false) }
+func TestFlatMapParallel(t *testing.T) { testFlatMap(t, true) }
+
 func Test2ndOrderLambda(t *testing.T) {
 	env := gqltest.NewSession()
 	if false {
@@ -502,6 +688,10 @@ func TestIntOps(t *testing.T) {
 		{"land(0x3, 0x1)", 1},
 		{"lor(0x3, 0x1)", 3},
 		{"int(1s)", int(time.Second)},
+		{`int(" 0x1f ")`, 31},
+		{`int("1_000_000")`, 1000000},
+		{`int("-12.7")`, -12},
+		{`try_int("\t42\n")`, 42},
 	} {
 		t.Run(test.expr, func(t *testing.T) {
 			env := gqltest.NewSession()
@@ -513,6 +703,16 @@ func TestIntOps(t *testing.T) {
 	}
 }
 
+func TestNumberParseErrors(t *testing.T) {
+	env := gqltest.NewSession()
+	for _, expr := range []string{`int("12abc")`, `int("")`, `float("1.5x")`, `float("12.5%")`} {
+		assert.Panics(t, func() { gqltest.Eval(t, expr, env) }, expr)
+	}
+	assert.True(t, gqltest.Eval(t, `isnull(try_int("12abc"))`, env).Bool(nil))
+	assert.True(t, gqltest.Eval(t, `isnull(try_int(NA))`, env).Bool(nil))
+	assert.Equal(t, int64(98), gqltest.Eval(t, `try_int('a')`, env).Int(nil))
+}
+
 func TestFloatOps(t *testing.T) {
 	for _, test := range []struct {
 		expr     string
@@ -529,6 +729,10 @@ func TestFloatOps(t *testing.T) {
 		{"1.0 / 4.0", 0.25},
 		{"1.0 / float(5)", 0.2},
 		{"float(1s)", 1.0},
+		{`float(" 1_000.5 ")`, 1000.5},
+		{`float("0x10")`, 16.0},
+		{`float("12.5%", percent:=true)`, 0.125},
+		{`float("2.5", percent:=true)`, 2.5},
 	} {
 		t.Run(test.expr, func(t *testing.T) {
 			env := gqltest.NewSession()
@@ -568,6 +772,118 @@ func TestMinMax(t *testing.T) {
 	require.Equal(t, gqltest.Eval(t, `min("a","ab","abc")`, env).Str(nil), "a")
 }
 
+func TestCompareNotNull(t *testing.T) {
+	env := gqltest.NewSession()
+	for _, test := range []struct {
+		expr string
+		want bool
+	}{
+		{"2 >? 1", true},
+		{"1 >? 1", false},
+		{"1 >=? 1", true},
+		{"1 <? 2", true},
+		{"2 <=? 2", true},
+		{"3 <=? 2", false},
+		{"NA >? 1", false},
+		{"1 <? NA", false},
+		{"NA >=? NA", false},
+		{"-NA <=? 1", false},
+		{`"b" >? "a"`, true},
+	} {
+		require.Equal(t, test.want, gqltest.Eval(t, test.expr, env).Bool(nil), test.expr)
+	}
+	gqltest.Eval(t, `t0 := table({d:50}, {d:NA}, {d:150})`, env)
+	require.Equal(t, []string{"{d:NA}", "{d:150}"}, gqltest.ReadTable(gqltest.Eval(t, "t0 | filter(&d > 100)", env)))
+	require.Equal(t, []string{"{d:150}"}, gqltest.ReadTable(gqltest.Eval(t, "t0 | filter(&d >? 100)", env)))
+}
+
+func TestBetweenClamp(t *testing.T) {
+	env := gqltest.NewSession()
+	require.True(t, gqltest.Eval(t, "between(5, 1, 10)", env).Bool(nil))
+	require.True(t, gqltest.Eval(t, "between(10, 1, 10)", env).Bool(nil))
+	require.False(t, gqltest.Eval(t, `between(10, 1, 10, inclusive:="left")`, env).Bool(nil))
+	require.False(t, gqltest.Eval(t, `between(1, 1, 10, inclusive:="right")`, env).Bool(nil))
+	require.False(t, gqltest.Eval(t, `between(1, 1, 10, inclusive:="neither")`, env).Bool(nil))
+	require.False(t, gqltest.Eval(t, "between(NA, 1, 10)", env).Bool(nil))
+	require.True(t, gqltest.Eval(t, "between(2.5, 1.0, 3.0)", env).Bool(nil))
+	require.True(t, gqltest.Eval(t, "between(2020-06-01, 2020-01-01, 2020-12-31)", env).Bool(nil))
+	require.True(t, gqltest.Eval(t, "between(90m, 1h, 2h)", env).Bool(nil))
+
+	require.Equal(t, int64(1), gqltest.Eval(t, "clamp(-5, 1, 10)", env).Int(nil))
+	require.Equal(t, int64(10), gqltest.Eval(t, "clamp(15, 1, 10)", env).Int(nil))
+	require.Equal(t, int64(5), gqltest.Eval(t, "clamp(5, 1, 10)", env).Int(nil))
+	require.Equal(t, 0.5, gqltest.Eval(t, "clamp(0.5, 0.0, 1.0)", env).Float(nil))
+	require.Panics(t, func() { gqltest.Eval(t, "clamp(5, 10, 1)", env) })
+}
+
+func TestLike(t *testing.T) {
+	env := gqltest.NewSession()
+	require.True(t, gqltest.Eval(t, `like("P0012_cfDNA", "P00%_cfDNA")`, env).Bool(nil))
+	require.False(t, gqltest.Eval(t, `like("P0012_cfDNA", "P00_")`, env).Bool(nil))
+	require.True(t, gqltest.Eval(t, `like("P001", "P00_")`, env).Bool(nil))
+	require.True(t, gqltest.Eval(t, `like("abc", "abc")`, env).Bool(nil))
+	require.True(t, gqltest.Eval(t, `like("abcdef", "abc%")`, env).Bool(nil))
+	require.True(t, gqltest.Eval(t, `like("abcdef", "%def")`, env).Bool(nil))
+	require.True(t, gqltest.Eval(t, `like("abcdef", "%cd%")`, env).Bool(nil))
+	require.False(t, gqltest.Eval(t, `like("abcdef", "%xy%")`, env).Bool(nil))
+	require.True(t, gqltest.Eval(t, `like("aXbXbc", "a%b_")`, env).Bool(nil))
+	require.False(t, gqltest.Eval(t, `like("aXbXbcd", "a%b_")`, env).Bool(nil))
+	require.True(t, gqltest.Eval(t, "like(`100%`, `100\\%`)", env).Bool(nil))
+	require.False(t, gqltest.Eval(t, "like(`1000`, `100\\%`)", env).Bool(nil))
+	require.True(t, gqltest.Eval(t, `like("a_b", "a!_b", escape:="!")`, env).Bool(nil))
+	require.False(t, gqltest.Eval(t, `like("axb", "a!_b", escape:="!")`, env).Bool(nil))
+	require.True(t, gqltest.Eval(t, "like(`a\\b`, `a\\b`, escape:=\"\")", env).Bool(nil))
+	require.Panics(t, func() { gqltest.Eval(t, `like("abc", "abc!", escape:="!")`, env) })
+}
+
+func TestNaNInf(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, "nan := 0.0/0.0; inf := 1.0/0.0", env)
+	require.True(t, gqltest.Eval(t, "is_nan(nan)", env).Bool(nil))
+	require.False(t, gqltest.Eval(t, "is_nan(inf)", env).Bool(nil))
+	require.False(t, gqltest.Eval(t, "is_nan(NA)", env).Bool(nil))
+	require.True(t, gqltest.Eval(t, "is_inf(inf)", env).Bool(nil))
+	require.True(t, gqltest.Eval(t, "is_inf(-inf)", env).Bool(nil))
+	require.False(t, gqltest.Eval(t, "is_inf(1.0)", env).Bool(nil))
+
+	require.True(t, gqltest.Eval(t, "nan == nan", env).Bool(nil))
+	require.False(t, gqltest.Eval(t, "nan != nan", env).Bool(nil))
+	require.True(t, gqltest.Eval(t, "nan > inf", env).Bool(nil))
+	require.True(t, gqltest.Eval(t, "-inf < 1.0", env).Bool(nil))
+	require.True(t, gqltest.Eval(t, "is_nan(max(1.0, nan, inf))", env).Bool(nil))
+	require.Equal(t, "-Inf", printValueLong(gqltest.Eval(t, "min(1.0, nan, -inf)", env)))
+	require.Equal(t, []string{"{x:-Inf}", "{x:1}", "{x:+Inf}", "{x:NaN}"},
+		gqltest.ReadTable(gqltest.Eval(t, "table({x:nan}, {x:1.0}, {x:inf}, {x:-inf}) | sort(&x)", env)))
+}
+
+func TestIsin(t *testing.T) {
+	env := gqltest.NewSession()
+	require.True(t, gqltest.Eval(t, `isin("b", "a", "b", "c")`, env).Bool(nil))
+	require.False(t, gqltest.Eval(t, `isin("d", "a", "b", "c")`, env).Bool(nil))
+	require.False(t, gqltest.Eval(t, `isin(NA, "a", NA)`, env).Bool(nil))
+	require.True(t, gqltest.Eval(t, `isin(2, table(1, 2, 3))`, env).Bool(nil))
+	require.False(t, gqltest.Eval(t, `isin(4, table(1, 2, 3))`, env).Bool(nil))
+	require.Equal(t, []string{"{A:11,B:ab1,C:cd1}"},
+		gqltest.ReadTable(gqltest.Eval(t,
+			"allow := table({x:\"ab1\"}, {x:\"ab9\"}); read(`testdata/file0.tsv`) | filter(isin(&B, allow))", env)))
+}
+
+func TestStructTableEquality(t *testing.T) {
+	env := gqltest.NewSession()
+	require.True(t, gqltest.Eval(t, "{a:1} == {a:1}", env).Bool(nil))
+	require.False(t, gqltest.Eval(t, "{a:1, b:2} == {a:1, b:3}", env).Bool(nil))
+	require.True(t, gqltest.Eval(t, "{a:1, b:2} != {a:1, b:3}", env).Bool(nil))
+	require.True(t, gqltest.Eval(t, "{a:1, b:2} < {a:1, b:3}", env).Bool(nil))
+	require.True(t, gqltest.Eval(t, `{a:1, b:{c:"x"}} == {a:1, b:{c:"x"}}`, env).Bool(nil))
+	require.False(t, gqltest.Eval(t, "{a:1} == NA", env).Bool(nil))
+	require.Panics(t, func() { gqltest.Eval(t, "{a:1} == {b:1}", env) })
+
+	require.True(t, gqltest.Eval(t, "table({a:1}, {a:2}) == table({a:1}, {a:2})", env).Bool(nil))
+	require.True(t, gqltest.Eval(t, "table({a:1}, {a:2}) == (table({a:1}, {a:2}, {a:3}) | filter(&a < 3))", env).Bool(nil))
+	require.False(t, gqltest.Eval(t, "table({a:1}, {a:2}) == table({a:1})", env).Bool(nil))
+	require.True(t, gqltest.Eval(t, "table({a:1}) < table({a:1}, {a:2})", env).Bool(nil))
+}
+
 func TestUnionRow(t *testing.T) {
 	for _, test := range []struct {
 		expr     string
@@ -588,6 +904,13 @@ func TestSprintf(t *testing.T) {
 	require.Equal(t, "foo10-bar", gqltest.Eval(t, `sprintf("foo%d-%s", 10, "bar")`, env).Str(nil))
 	require.Equal(t, "foo10", gqltest.Eval(t, `sprintf("foo%d", 10)`, env).Str(nil))
 	require.Equal(t, "foo10-bar-5.5", gqltest.Eval(t, `sprintf("foo%d-%s-%.1f", 10, "bar", 5.5)`, env).Str(nil))
+	require.Equal(t, `"a\tb"`, gqltest.Eval(t, `sprintf("%q", "a\tb")`, env).Str(nil))
+	require.Equal(t, "{a:1,b:x}", gqltest.Eval(t, `sprintf("%v", {a:1, b:"x"})`, env).Str(nil))
+	require.Contains(t, gqltest.Eval(t, `sprintf("%v", table({a:123}))`, env).Str(nil), "123")
+	require.Equal(t, "1m30s", gqltest.Eval(t, `sprintf("%v", 90s)`, env).Str(nil))
+	require.Equal(t, "   1m30s", gqltest.Eval(t, `sprintf("%8s", 90s)`, env).Str(nil))
+	require.Equal(t, "1.50", gqltest.Eval(t, `sprintf("%.2f", 1500ms)`, env).Str(nil))
+	require.Equal(t, "1000", gqltest.Eval(t, `sprintf("%d", 1us)`, env).Str(nil))
 }
 
 func TestStringOps(t *testing.T) {
@@ -849,6 +1172,96 @@ func TestReadTidyTSV(t *testing.T) {
 		printValueLong(gqltest.Eval(t, "table_attrs(T0).path", env)))
 }
 
+func TestReadWithDict(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	dataPath := filepath.Join(tmpDir, "data.tsv")
+	dictPath := filepath.Join(tmpDir, "mydict.tsv")
+	assert.NoError(t, ioutil.WriteFile(dataPath, []byte("A\tB\n1\t2\n3\t4.5\n"), 0600))
+	assert.NoError(t, ioutil.WriteFile(dictPath, []byte("column_name\ttype\tdescription\nA\tstring\tsample\nB\tfloat\tdepth\nC\tint\tmissing\n"), 0600))
+	env := gqltest.NewSession()
+
+	// Without a dictionary, the types are guessed.
+	assert.Equal(t, []string{"{A:1,B:2}", "{A:3,B:4.5}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", dataPath), env)))
+	table := gqltest.Eval(t, fmt.Sprintf("T0 := read(`%s`, dict:=`%s`)", dataPath, dictPath), env)
+	attrs := table.Table(nil).Attrs(ctx)
+	assert.Equal(t, gql.StringType, attrs.Columns[0].Type)
+	assert.Equal(t, "depth", attrs.Columns[1].Description)
+	assert.Equal(t, "1", printValueLong(gqltest.Eval(t, `T0 | filter($A=="1") | count()`, env)))
+
+	assert.Equal(t,
+		[]string{"{column:C,row:-1,issue:column missing in table}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("T0 | check_dict(`%s`)", dictPath), env)))
+	assert.Equal(t,
+		[]string{
+			"{column:A,row:0,issue:expect type StringType, but found IntType (value 1)}",
+			"{column:C,row:-1,issue:column missing in table}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("table({A:1, B:2.5}) | check_dict(`%s`)", dictPath), env)))
+
+	// The dictionary next to the file is used only with dict:="auto".
+	autoPath := filepath.Join(tmpDir, "auto.tsv")
+	assert.NoError(t, ioutil.WriteFile(autoPath, []byte("A\tB\n1\t2\n"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "auto_dict.tsv"), []byte("column_name\ttype\tdescription\nA\tstring\tsample\n"), 0600))
+	attrs = gqltest.Eval(t, fmt.Sprintf("read(`%s`)", autoPath), env).Table(nil).Attrs(ctx)
+	assert.Equal(t, gql.IntType, attrs.Columns[0].Type)
+	attrs = gqltest.Eval(t, fmt.Sprintf("read(`%s`, dict:=\"auto\")", autoPath), env).Table(nil).Attrs(ctx)
+	assert.Equal(t, gql.StringType, attrs.Columns[0].Type)
+
+	// A file that happens to have the name of a dictionary is ignored.
+	badPath := filepath.Join(tmpDir, "bad.tsv")
+	assert.NoError(t, ioutil.WriteFile(badPath, []byte("A\tB\n1\t2\n"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "bad_dict.tsv"), []byte("x\ty\n1\t2\n"), 0600))
+	assert.Equal(t, []string{"{A:1,B:2}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`, dict:=\"auto\")", badPath), env)))
+}
+
+func TestWithAttrs(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `T0 := table({A:1, B:"x"}, {A:2, B:"y"}) | with_attrs(name:="t0", description:="test table", columns:={A:"an int"})`, env)
+	assert.Equal(t, "t0", printValueLong(gqltest.Eval(t, "table_attrs(T0).name", env)))
+	assert.Equal(t, "test table", printValueLong(gqltest.Eval(t, "table_attrs(T0).description", env)))
+	assert.Equal(t, []string{"{A:1,B:x}", "{A:2,B:y}"}, gqltest.ReadTable(gqltest.Eval(t, "T0", env)))
+
+	tmpPath := filepath.Join(tmpDir, "attrs.tsv")
+	gqltest.Eval(t, fmt.Sprintf("write(T0, `%s`)", tmpPath), env)
+	data, err := file.ReadFile(context.Background(), filepath.Join(tmpDir, "attrs_data_dictionary.tsv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "column_name\ttype\tdescription\nA\tint\tan int\nB\tstring\tUnknown\n", string(data))
+
+	tmpPath = filepath.Join(tmpDir, "attrs.btsv")
+	gqltest.Eval(t, fmt.Sprintf("write(T0, `%s`)", tmpPath), env)
+	attrs := gqltest.Eval(t, fmt.Sprintf("read(`%s`)", tmpPath), env).Table(nil).Attrs(context.Background())
+	assert.Equal(t, "an int", attrs.Columns[0].Description)
+}
+
+func TestWithAttrsUnits(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `T0 := table({id:"a", conc:1.5}, {id:"b", conc:NA}) | with_attrs(units:={conc:"ng/mL"})`, env)
+	tmpPath := filepath.Join(tmpDir, "units.tsv")
+	gqltest.Eval(t, fmt.Sprintf("write(T0, `%s`)", tmpPath), env)
+	data, err := file.ReadFile(context.Background(), filepath.Join(tmpDir, "units_data_dictionary.tsv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "column_name\ttype\tdescription\tunit\nid\tstring\tUnknown\t\nconc\tfloat\tUnknown\tng/mL\n", string(data))
+
+	// The unit is read back from the dictionary, and used by convert_unit.
+	gqltest.Eval(t, fmt.Sprintf("T1 := read(`%s`, dict:=\"auto\") | convert_unit(`pg/mL`, columns:=`conc`)", tmpPath), env)
+	assert.Equal(t, []string{"{id:a,conc:1500}", "{id:b,conc:NA}"}, gqltest.ReadTable(gqltest.Eval(t, "T1", env)))
+	attrs := gqltest.Eval(t, "T1", env).Table(nil).Attrs(context.Background())
+	assert.Equal(t, "pg/mL", attrs.Columns[1].Unit)
+
+	assert.Equal(t, "2500", printValueLong(gqltest.Eval(t, `convert_unit(2.5, "pg/mL", from:="ng/mL")`, env)))
+	assert.Equal(t, "1.5", printValueLong(gqltest.Eval(t, `convert_unit(1500ms, "s")`, env)))
+	assert.Panics(t, func() { gqltest.Eval(t, `convert_unit(1.0, "s", from:="bp")`, env) })
+	assert.Panics(t, func() { gqltest.Eval(t, `convert_unit(1.0, "s")`, env) })
+	assert.Panics(t, func() { gqltest.Eval(t, "T0 | convert_unit(`s`, columns:=`id`)", env) })
+}
+
 func TestReadEmptyTSV1(t *testing.T) {
 	dataPath := "./testdata/conta.tsv"
 	env := gqltest.NewSession()
@@ -951,6 +1364,457 @@ func TestWriteTSV(t *testing.T) {
 		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", tmpPath), env)))
 }
 
+func TestWriteTSVColumnOrder(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	ctx := context.Background()
+	gqltest.Eval(t, `T0 := table({score:1.5, end:20, name:"a", start:10, chrom:"chr1"})`, env)
+
+	tmpPath := filepath.Join(tmpDir, "order0.tsv")
+	gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`, column_order:=table(\"chrom\", \"start\", \"end\", \"...\"))", tmpPath), env)
+	data, err := file.ReadFile(ctx, tmpPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "chrom\tstart\tend\tscore\tname\nchr1\t10\t20\t1.5\ta\n", string(data))
+
+	tmpPath = filepath.Join(tmpDir, "order1.tsv")
+	gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`, column_order:=table(\"chrom\", \"...\", \"score\"))", tmpPath), env)
+	data, err = file.ReadFile(ctx, tmpPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "chrom\tend\tname\tstart\tscore\nchr1\t20\ta\t10\t1.5\n", string(data))
+
+	tmpPath = filepath.Join(tmpDir, "order2.tsv")
+	assert.Panics(t, func() {
+		gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`, column_order:=table(\"chrom\", \"pos\"))", tmpPath), env)
+	})
+}
+
+func TestWriteTSVRoundTrip(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	ctx := context.Background()
+	gqltest.Eval(t, `T0 := table(
+{i:1, f:2.0, g:0.1, s:"abc", b:true},
+{i:-3, f:1e300, g:1.0/3.0, s:"x y", b:false},
+{i:NA, f:-0.000123456789012345, g:NA, s:"z", b:NA})`, env)
+
+	tmpPath := filepath.Join(tmpDir, "roundtrip.tsv")
+	gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`)", tmpPath), env)
+	data, err := file.ReadFile(ctx, tmpPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "i\tf\tg\ts\tb\n"+
+		"1\t2.0\t0.1\tabc\ttrue\n"+
+		"-3\t1e+300\t0.3333333333333333\tx y\tfalse\n"+
+		"NA\t-0.000123456789012345\tNA\tz\tNA\n", string(data))
+	gqltest.Eval(t, fmt.Sprintf("T1 := read(`%s`)", tmpPath), env)
+	assert.Equal(t, gqltest.ReadTable(gqltest.Eval(t, "T0", env)), gqltest.ReadTable(gqltest.Eval(t, "T1", env)))
+	// The float columns are read back as floats, with the same values.
+	assert.Equal(t, "true", gqltest.Eval(t, "pick(T1, $i==1).f/4 == 0.5", env).String())
+	assert.Equal(t, "true", gqltest.Eval(t, "pick(T1, $i==-3).g == 1.0/3.0", env).String())
+
+	tmpPath = filepath.Join(tmpDir, "format.tsv")
+	gqltest.Eval(t, fmt.Sprintf("T0 | map({i:$i, g:$g}) | write(`%s`, float_format:=\"%%.3f\")", tmpPath), env)
+	data, err = file.ReadFile(ctx, tmpPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "i\tg\n1\t0.100\n-3\t0.333\nNA\tNA\n", string(data))
+
+	tmpPath = filepath.Join(tmpDir, "trim.tsv")
+	gqltest.Eval(t, fmt.Sprintf("T0 | map({f:$f}) | firstn(1) | write(`%s`, trim_float_zero:=true)", tmpPath), env)
+	data, err = file.ReadFile(ctx, tmpPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "f\n2\n", string(data))
+
+	assert.Panics(t, func() {
+		gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`, float_format:=\"%%d %%d\")", tmpPath), env)
+	})
+	assert.Panics(t, func() {
+		gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`, float_format:=\"%%.3f\")", filepath.Join(tmpDir, "x.btsv")), env)
+	})
+}
+
+func TestWriteMatrix(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	ctx := context.Background()
+	gqltest.Eval(t, `T0 := table(
+{s:"s2", g:"BRCA1", n:5},
+{s:"s1", g:"TP53", n:3},
+{s:"s1", g:"BRCA1", n:1},
+{s:"s3", g:"TP53", n:7})`, env)
+
+	tmpPath := filepath.Join(tmpDir, "counts.tsv")
+	gqltest.Eval(t, fmt.Sprintf("T0 | write_matrix(`%s`, sample:=&s, feature:=&g, value:=&n)", tmpPath), env)
+	assert.Equal(t,
+		[]string{
+			"{feature:BRCA1,s1:1,s2:5,s3:NA}",
+			"{feature:TP53,s1:3,s2:NA,s3:7}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`) | sort(&feature)", tmpPath), env)))
+	data, err := file.ReadFile(ctx, filepath.Join(tmpDir, "counts.samples.tsv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "index\tsample\n0\ts1\n1\ts2\n2\ts3\n", string(data))
+
+	// BTSV output with an explicit manifest path.
+	tmpPath = filepath.Join(tmpDir, "counts.btsv")
+	manifestPath := filepath.Join(tmpDir, "manifest.tsv")
+	gqltest.Eval(t, fmt.Sprintf("T0 | write_matrix(`%s`, sample:=&s, feature:=&g, value:=&n*2, shards:=2, manifest:=`%s`)", tmpPath, manifestPath), env)
+	assert.Equal(t,
+		[]string{
+			"{feature:BRCA1,s1:2,s2:10,s3:NA}",
+			"{feature:TP53,s1:6,s2:NA,s3:14}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`) | sort(&feature)", tmpPath), env)))
+	assert.Equal(t, []string{"{index:0,sample:s1}", "{index:1,sample:s2}", "{index:2,sample:s3}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", manifestPath), env)))
+
+	assert.Panics(t, func() {
+		gqltest.Eval(t, fmt.Sprintf("table({s:\"s1\", g:\"A\", n:1}, {s:\"s1\", g:\"A\", n:2}) | write_matrix(`%s`, sample:=&s, feature:=&g, value:=&n)",
+			filepath.Join(tmpDir, "dup.tsv")), env)
+	})
+}
+
+func TestReadOnMissing(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	ctx := context.Background()
+	path1 := filepath.Join(tmpDir, "run1.tsv")
+	path2 := filepath.Join(tmpDir, "run2.tsv")
+	assert.NoError(t, file.WriteFile(ctx, path1, []byte("sample\tdepth\ns1\t10\n")))
+	assert.NoError(t, file.WriteFile(ctx, path2, []byte("sample\ns2\n")))
+
+	expr := fmt.Sprintf("flatten(read(`%s`, columns:=\"sample,depth\", on_missing:=%%s), read(`%s`, columns:=\"sample,depth\", on_missing:=%%s))", path1, path2)
+	assert.Equal(t,
+		[]string{"{sample:s1,depth:10}", "{sample:s2,depth:NA}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf(expr, `"na"`, `"na"`), env)))
+	assert.Equal(t,
+		[]string{fmt.Sprintf("{path:%s,column:depth}", path2)},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("missing_columns() | filter(&path == `%s`)", path2), env)))
+	assert.Panics(t, func() { gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf(expr, `"error"`, `"error"`), env)) })
+}
+
+func TestReadNumberFormat(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	ctx := context.Background()
+	usPath := filepath.Join(tmpDir, "us.tsv")
+	euPath := filepath.Join(tmpDir, "eu.tsv")
+	assert.NoError(t, file.WriteFile(ctx, usPath, []byte("id\tamount\tcode\na\t1,234.5\tx,y\nb\t2.25\tz\n")))
+	assert.NoError(t, file.WriteFile(ctx, euPath, []byte("id\tamount\tcode\na\t1.234,5\tx,y\nb\t2,25\tz\n")))
+
+	want := []string{"{id:a,amount:2469,code:x,y}", "{id:b,amount:4.5,code:z}"}
+	assert.Equal(t, want, gqltest.ReadTable(gqltest.Eval(t,
+		fmt.Sprintf("read(`%s`, thousands:=\",\") | map({&id, amount:&amount*2.0, &code})", usPath), env)))
+	assert.Equal(t, want, gqltest.ReadTable(gqltest.Eval(t,
+		fmt.Sprintf("read(`%s`, thousands:=\".\", decimal:=\",\") | map({&id, amount:&amount*2.0, &code})", euPath), env)))
+	// Without the options, the column is read as strings.
+	assert.Equal(t, []string{"{id:a,amount:1,234.5,code:x,y}", "{id:b,amount:2.25,code:z}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", usPath), env)))
+	assert.Panics(t, func() { gqltest.Eval(t, fmt.Sprintf("read(`%s`, thousands:=\",\", decimal:=\",\")", usPath), env) })
+}
+
+func TestReadDuplicateColumns(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	ctx := context.Background()
+	path := filepath.Join(tmpDir, "dup.tsv")
+	assert.NoError(t, file.WriteFile(ctx, path, []byte("a\tb\ta\ta_2\n1\t2\t3\t4\n")))
+
+	assert.Panics(t, func() { gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", path), env)) })
+	assert.Equal(t, []string{"{a:1,b:2,a_3:3,a_2:4}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`, duplicate_columns:=\"suffix\")", path), env)))
+	assert.Equal(t, []string{"{a:1,b:2,a_2:4}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`, duplicate_columns:=\"keep_first\")", path), env)))
+}
+
+func TestReadStrictTSV(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	ctx := context.Background()
+	path := filepath.Join(tmpDir, "coords.tsv")
+	assert.NoError(t, file.WriteFile(ctx, path, []byte("chrom\tstart\nchr1\t100\nchr1\t150.5\nchr2\t2e2\n")))
+	assert.NoError(t, file.WriteFile(ctx, filepath.Join(tmpDir, "coords_data_dictionary.tsv"),
+		[]byte("column_name\ttype\tdescription\nchrom\tstring\tchromosome\nstart\tint\tstart position\n")))
+
+	// By default, 150.5 is truncated, and the truncation is counted. 2e2 is
+	// converted without loss.
+	gqltest.Eval(t, fmt.Sprintf("t0 := read(`%s`, dict:=\"auto\")", path), env)
+	assert.Equal(t, []string{"{chrom:chr1,start:100}", "{chrom:chr1,start:150}", "{chrom:chr2,start:200}"},
+		gqltest.ReadTable(gqltest.Eval(t, "t0", env)))
+	assert.Equal(t, "{start:1}", gqltest.Eval(t, "table_attrs(t0).coercions", env).String())
+
+	assert.Panics(t, func() {
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`, dict:=\"auto\", strict:=true)", path), env))
+	})
+}
+
+func TestWriteTSVEscape(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	ctx := context.Background()
+	gqltest.Eval(t, "T0 := table({s:\"#a\tb\"}, {s:`c\nd`}, {s:`e\\f \"g\"`}, {s:\"h\"})", env)
+	want := gqltest.ReadTable(gqltest.Eval(t, "T0", env))
+
+	for _, test := range []struct {
+		escape   string
+		readOpts string
+		data     string
+	}{
+		{"none", "", "s\n#a b\nc d\ne\\f \"g\"\nh\n"},
+		{"c", `, escape:="c"`, "s\n\\#a\\tb\nc\\nd\ne\\\\f \\\"g\\\"\nh\n"},
+		{"quote", "", "s\n\"#a\tb\"\n\"c\nd\"\n\"e\\f \"\"g\"\"\"\nh\n"},
+	} {
+		tmpPath := filepath.Join(tmpDir, test.escape+".tsv")
+		gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`, escape:=%q)", tmpPath, test.escape), env)
+		data, err := file.ReadFile(ctx, tmpPath)
+		assert.NoError(t, err)
+		assert.Equal(t, test.data, string(data), test.escape)
+		if test.escape != "none" {
+			got := gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`%s)", tmpPath, test.readOpts), env))
+			assert.Equal(t, want, got, test.escape)
+		}
+	}
+	assert.Panics(t, func() {
+		gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`, escape:=\"xml\")", filepath.Join(tmpDir, "x.tsv")), env)
+	})
+}
+
+func TestCSV(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	ctx := context.Background()
+	want := []string{"{name:alice,n:1,note:hello, world}", `{name:bob,n:2,note:say "hi"}`}
+
+	path := filepath.Join(tmpDir, "in.csv")
+	assert.NoError(t, file.WriteFile(ctx, path, []byte("name,n,note\nalice,1,\"hello, world\"\nbob,2,\"say \"\"hi\"\"\"\n")))
+	assert.Equal(t, want, gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", path), env)))
+
+	path = filepath.Join(tmpDir, "semicolon.csv")
+	assert.NoError(t, file.WriteFile(ctx, path, []byte("name;n;note\nalice;1;hello, world\nbob;2;say \"hi\"\n")))
+	assert.Equal(t, want, gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`, delimiter:=\";\")", path), env)))
+
+	gqltest.Eval(t, `T0 := table({name:"alice", n:1, note:"hello, world"}, {name:"bob", n:2, note:"say \"hi\""})`, env)
+	for _, test := range []struct {
+		path, opts, data string
+	}{
+		{"out.csv", "", "name,n,note\nalice,1,\"hello, world\"\nbob,2,\"say \"\"hi\"\"\"\n"},
+		{"out.txt", `, type:="csv", delimiter:="|"`, "name|n|note\nalice|1|hello, world\nbob|2|\"say \"\"hi\"\"\"\n"},
+	} {
+		path := filepath.Join(tmpDir, test.path)
+		gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`%s)", path, test.opts), env)
+		data, err := file.ReadFile(ctx, path)
+		assert.NoError(t, err)
+		assert.Equal(t, test.data, string(data), test.path)
+	}
+	assert.Equal(t, want, gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", filepath.Join(tmpDir, "out.csv")), env)))
+	assert.Equal(t, want, gqltest.ReadTable(gqltest.Eval(t,
+		fmt.Sprintf("read(`%s`, type:=\"csv\", delimiter:=\"|\")", filepath.Join(tmpDir, "out.txt")), env)))
+
+	assert.Panics(t, func() {
+		gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`, escape:=\"c\")", filepath.Join(tmpDir, "x.csv")), env)
+	})
+	assert.Panics(t, func() {
+		gqltest.Eval(t, fmt.Sprintf("read(`%s`, delimiter:=\"ab\")", path), env)
+	})
+}
+
+func TestJSON(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	ctx := context.Background()
+	want := []string{"{name:alice,n:1,x:1.5,ok:true,c:10,ntags:2}", "{name:bob,n:NA,x:2,ok:false,c:NA,ntags:0}"}
+	summary := "read(`%s`) | map({$name, $n, $x, $ok, c: $info.c, ntags: count($tags)})"
+
+	path := filepath.Join(tmpDir, "in.jsonl")
+	assert.NoError(t, file.WriteFile(ctx, path, []byte(`{"name": "alice", "n": 1, "x": 1.5, "ok": true, "info": {"c": 10}, "tags": ["a", "b"]}
+{"name": "bob", "n": null, "x": 2.0, "ok": false, "info": {"c": null}, "tags": []}
+`)))
+	assert.Equal(t, want, gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf(summary, path), env)))
+	assert.Equal(t, []string{"a", "b"}, gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("pick(read(`%s`), $name==\"alice\").tags", path), env)))
+
+	// A JSON array.
+	path = filepath.Join(tmpDir, "in.json")
+	assert.NoError(t, file.WriteFile(ctx, path, []byte(`[{"a": 1}, {"a": 2}]`)))
+	assert.Equal(t, []string{"{a:1}", "{a:2}"}, gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", path), env)))
+
+	gqltest.Eval(t, fmt.Sprintf("T0 := read(`%s`)", filepath.Join(tmpDir, "in.jsonl")), env)
+	for _, test := range []struct {
+		path, data string
+	}{
+		{"out.jsonl", `{"name":"alice","n":1,"x":1.5,"ok":true,"info":{"c":10},"tags":["a","b"]}
+{"name":"bob","n":null,"x":2.0,"ok":false,"info":{"c":null},"tags":[]}
+`},
+		{"out.json", `[
+{"name":"alice","n":1,"x":1.5,"ok":true,"info":{"c":10},"tags":["a","b"]},
+{"name":"bob","n":null,"x":2.0,"ok":false,"info":{"c":null},"tags":[]}
+]
+`},
+	} {
+		path := filepath.Join(tmpDir, test.path)
+		gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`)", path), env)
+		data, err := file.ReadFile(ctx, path)
+		assert.NoError(t, err)
+		assert.Equal(t, test.data, string(data), test.path)
+		assert.Equal(t, want, gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf(summary, path), env)), test.path)
+	}
+
+	path = filepath.Join(tmpDir, "out.jsonl.gz")
+	gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`)", path), env)
+	assert.Equal(t, want, gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf(summary, path), env)))
+
+	path = filepath.Join(tmpDir, "bad.jsonl")
+	assert.NoError(t, file.WriteFile(ctx, path, []byte("{\"a\": 1}\n{\"a\": \n")))
+	assert.Panics(t, func() {
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", path), env))
+	})
+}
+
+func TestWriteIfChanged(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	ctx := context.Background()
+	for _, name := range []string{"out.tsv", "out.btsv"} {
+		path := filepath.Join(tmpDir, name)
+		write := func(expr, opts string) bool {
+			return gqltest.Eval(t, fmt.Sprintf("%s | write(`%s`, if_changed:=true%s)", expr, path, opts), env).Bool(nil)
+		}
+		assert.True(t, write("table({a:1}, {a:2})", ""), name)
+		_, err := file.Stat(ctx, path+".gqlhash")
+		assert.NoError(t, err, name)
+		assert.False(t, write("table({a:1}, {a:2})", ""), name)
+		assert.Equal(t, []string{"{a:1}", "{a:2}"}, gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", path), env)))
+
+		// A change to the table or to the parameters causes a write.
+		assert.True(t, write("table({a:1}, {a:3})", ""), name)
+		assert.Equal(t, []string{"{a:1}", "{a:3}"}, gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", path), env)))
+		assert.False(t, write("table({a:1}, {a:3})", ""), name)
+		assert.True(t, write("table({a:1}, {a:3})", ", shards:=2"), name)
+
+		// A write without if_changed:= invalidates the recorded hash.
+		assert.False(t, write("table({a:1}, {a:3})", ", shards:=2"), name)
+		gqltest.Eval(t, fmt.Sprintf("table({a:4}) | write(`%s`, shards:=2)", path), env)
+		_, err = file.Stat(ctx, path+".gqlhash")
+		assert.Error(t, err, name)
+		assert.True(t, write("table({a:1}, {a:3})", ", shards:=2"), name)
+		assert.Equal(t, []string{"{a:1}", "{a:3}"}, gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", path), env)))
+
+		// So does a modification by another program.
+		assert.False(t, write("table({a:1}, {a:3})", ", shards:=2"), name)
+		if name == "out.tsv" {
+			require.NoError(t, ioutil.WriteFile(path, []byte("a\n5\n"), 0600))
+			assert.True(t, write("table({a:1}, {a:3})", ", shards:=2"), name)
+			assert.Equal(t, []string{"{a:1}", "{a:3}"}, gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", path), env)))
+		}
+	}
+	assert.Panics(t, func() {
+		gqltest.Eval(t, fmt.Sprintf("table({a:1}) | write(`%s`, if_changed:=true, mode:=\"append\")", filepath.Join(tmpDir, "x.tsv")), env)
+	})
+}
+
+func TestWriteTSVNestedTables(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	ctx := context.Background()
+	gqltest.Eval(t, `T0 := table({k:1, t:table({x:1}, {x:2})}, {k:2, t:table({x:3})})`, env)
+
+	tmpPath := filepath.Join(tmpDir, "nt.tsv")
+	gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`, nested_tables:=\"file\")", tmpPath), env)
+	data, err := file.ReadFile(ctx, tmpPath)
+	assert.NoError(t, err)
+	assert.Regexp(t, "^k\tt\n1\tnt_tables/[^/\t]+\\.btsv\n2\tnt_tables/[^/\t]+\\.btsv\n$", string(data))
+	dict, err := file.ReadFile(ctx, filepath.Join(tmpDir, "nt_data_dictionary.tsv"))
+	assert.NoError(t, err)
+	assert.Regexp(t, "\nt\ttable\t", string(dict))
+
+	got := gqltest.ReadTable(gqltest.Eval(t,
+		fmt.Sprintf("read(`%s`, dict:=\"auto\") | map({$k, n: count($t)})", tmpPath), env))
+	assert.Equal(t, []string{"{k:1,n:2}", "{k:2,n:1}"}, got)
+
+	assert.Panics(t, func() {
+		gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`, nested_tables:=\"xml\")", filepath.Join(tmpDir, "x.tsv")), env)
+	})
+}
+
+func TestWriteTSVMetadata(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	ctx := context.Background()
+	t0 := gqltest.Eval(t, `T0 := table({a:1, b:"x"}, {a:2, b:"y"})`, env)
+
+	tmpPath := filepath.Join(tmpDir, "md.tsv")
+	gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`, metadata:={min_depth:10})", tmpPath), env)
+	data, err := file.ReadFile(ctx, tmpPath)
+	assert.NoError(t, err)
+	lines := strings.Split(string(data), "\n")
+	assert.Equal(t, "##gql_version="+gql.Version, lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "##created="), lines[1])
+	assert.Equal(t, "##source_hash="+t0.Table(nil).Hash().String(), lines[2])
+	assert.Equal(t, "##params={min_depth:10}", lines[3])
+	assert.Equal(t, "a\tb", lines[4])
+
+	gqltest.Eval(t, fmt.Sprintf("T1 := read(`%s`)", tmpPath), env)
+	assert.Equal(t, gqltest.ReadTable(t0), gqltest.ReadTable(gqltest.Eval(t, "T1", env)))
+	assert.Equal(t, t0.Table(nil).Hash().String(), gqltest.Eval(t, "table_attrs(T1).metadata.source_hash", env).Str(nil))
+	assert.Equal(t, "{min_depth:10}", gqltest.Eval(t, "table_attrs(T1).metadata.params", env).Str(nil))
+	assert.Equal(t, "{}", gqltest.Eval(t, "table_attrs(T0).metadata", env).String())
+}
+
+func TestWriteAppend(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	ctx := context.Background()
+	gqltest.Eval(t, `T0 := table({a:1, b:"x"}, {a:2, b:"y"})`, env)
+	gqltest.Eval(t, `T1 := table({b:"z", a:3})`, env)
+
+	tmpPath := filepath.Join(tmpDir, "append.tsv")
+	gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`, mode:=\"append\")", tmpPath), env)
+	gqltest.Eval(t, fmt.Sprintf("T1 | write(`%s`, mode:=\"append\")", tmpPath), env)
+	data, err := file.ReadFile(ctx, tmpPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "a\tb\n1\tx\n2\ty\n3\tz\n", string(data))
+	assert.Panics(t, func() {
+		gqltest.Eval(t, fmt.Sprintf("table({a:4}) | write(`%s`, mode:=\"append\")", tmpPath), env)
+	})
+
+	tmpPath = filepath.Join(tmpDir, "append.btsv")
+	gqltest.Eval(t, fmt.Sprintf("T0 | write(`%s`, shards:=2, mode:=\"append\")", tmpPath), env)
+	gqltest.Eval(t, fmt.Sprintf("T1 | write(`%s`, mode:=\"append\")", tmpPath), env)
+	gqltest.Eval(t, fmt.Sprintf("T1 | write(`%s`, mode:=\"append\")", tmpPath), env)
+	assert.Equal(t, []string{"{a:1,b:x}", "{a:2,b:y}", "{b:z,a:3}", "{b:z,a:3}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", tmpPath), env)))
+}
+
+func TestTee(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	env := gqltest.NewSession()
+	ctx := context.Background()
+	gqltest.Eval(t, `T0 := table({a:1, b:"x"}, {a:2, b:"y"}, {a:3, b:"z"})`, env)
+
+	tmpPath := filepath.Join(tmpDir, "tee.tsv")
+	assert.Equal(t, []string{"{a:2,b:y}", "{a:3,b:z}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("T0 | tee(`%s`) | filter($a > 1)", tmpPath), env)))
+	data, err := file.ReadFile(ctx, tmpPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "a\tb\n1\tx\n2\ty\n3\tz\n", string(data))
+
+	tmpPath = filepath.Join(tmpDir, "print.txt")
+	gqltest.Eval(t, fmt.Sprintf("print(1, 2, to:=`%s`)", tmpPath), env)
+	data, err = file.ReadFile(ctx, tmpPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "1\n2\n", string(data))
+}
+
 func TestWriteBED(t *testing.T) {
 	ctx := context.Background()
 	tmpDir, cleanup := testutil.TempDir(t, "", "")
@@ -975,6 +1839,34 @@ chr2	11	21
 	assert.Equal(t, string(data), `chr1	10	20	xx
 chr2	11	21	yy
 `)
+
+	// Compressed BED. A BGZF file is also a gzip file, with the "BC" extra
+	// field in each block header.
+	for _, test := range []struct {
+		name, opts string
+	}{
+		{"gzip.bed.gz", ""},
+		{"bgzip.bed.gz", ", bgzip:=true"},
+	} {
+		tmpPath = filepath.Join(tmpDir, test.name)
+		gqltest.Eval(t, fmt.Sprintf("table({chrom:`chr1`,start:10,end:20},{chrom:`chr2`,start:11,end:21}) | write(`%s`%s)", tmpPath, test.opts), env)
+		data, err = file.ReadFile(ctx, tmpPath)
+		assert.NoError(t, err)
+		assert.Equal(t, test.opts != "", len(data) > 13 && string(data[12:14]) == "BC", test.name)
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		assert.NoError(t, err)
+		data, err = ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, "chr1\t10\t20\nchr2\t11\t21\n", string(data), test.name)
+		assert.Equal(t, []string{"{chrom:chr1,start:10,end:20,featname:NA}", "{chrom:chr2,start:11,end:21,featname:NA}"},
+			gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("read(`%s`)", tmpPath), env)), test.name)
+	}
+	assert.Panics(t, func() {
+		gqltest.Eval(t, fmt.Sprintf("table({chrom:`chr1`,start:10,end:20}) | write(`%s`)", filepath.Join(tmpDir, "x.bed.zst")), env)
+	})
+	assert.Panics(t, func() {
+		gqltest.Eval(t, fmt.Sprintf("table({chrom:`chr1`,start:10,end:20}) | write(`%s`, bgzip:=true)", filepath.Join(tmpDir, "x.bed")), env)
+	})
 }
 
 func TestWriteFormat(t *testing.T) {
@@ -1114,6 +2006,25 @@ func TestCountFlattenedTable1(t *testing.T) {
 	assert.Equal(t, v.Int(nil), int64(4))
 }
 
+func TestCountIfSumIf(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `t0 := table({a:1, b:2.5, ok:true}, {a:2, b:NA, ok:false}, {a:3, b:1.0, ok:NA}, {a:4, b:0.5, ok:true})`, env)
+	assert.Equal(t, int64(2), gqltest.Eval(t, "t0 | count_if(&ok)", env).Int(nil))
+	assert.Equal(t, int64(2), gqltest.Eval(t, "t0 | count_if(&a % 2 == 0)", env).Int(nil))
+	assert.Equal(t, int64(5), gqltest.Eval(t, "t0 | sum_if(&a, &ok)", env).Int(nil))
+	assert.Equal(t, 3.0, gqltest.Eval(t, "t0 | sum_if(&b, &ok)", env).Float(nil))
+	assert.Equal(t, 0.5, gqltest.Eval(t, "t0 | sum_if(&b, &a % 2 == 0)", env).Float(nil))
+	assert.Equal(t, int64(0), gqltest.Eval(t, "t0 | sum_if(&a, &a > 10)", env).Int(nil))
+}
+
+func TestCountDistinct(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `t0 := table({s:"x", n:1}, {s:"y", n:1}, {s:"x", n:NA}, {s:"z", n:2})`, env)
+	assert.Equal(t, int64(3), gqltest.Eval(t, "t0 | count_distinct(&s)", env).Int(nil))
+	assert.Equal(t, int64(2), gqltest.Eval(t, "t0 | count_distinct(&n)", env).Int(nil))
+	assert.Equal(t, int64(4), gqltest.Eval(t, "t0 | count_distinct({&s, &n})", env).Int(nil))
+}
+
 func TestPick(t *testing.T) {
 	env := gqltest.NewSession()
 	val := gqltest.Eval(t, "pick(table(10,11,15,30,32), _%5==1)", env)
@@ -1257,6 +2168,55 @@ func TestString(t *testing.T) {
 func TestHash(t *testing.T) {
 	env := gqltest.NewSession()
 	assert.Equal(t, int64(7067957609529580592), gqltest.Eval(t, `hash64("foo")`, env).Int(nil))
+
+	h := gql.NewString("foo").Hash()
+	assert.Equal(t, hex.EncodeToString(h[:]), gqltest.Eval(t, `row_hash("foo")`, env).Str(nil))
+	assert.Equal(t,
+		gqltest.Eval(t, `row_hash({a:1, b:"x"})`, env).Str(nil),
+		gqltest.Eval(t, `row_hash({a:1, b:"x"})`, env).Str(nil))
+	assert.NotEqual(t,
+		gqltest.Eval(t, `row_hash({a:1, b:"x"})`, env).Str(nil),
+		gqltest.Eval(t, `row_hash({a:1, c:"x"})`, env).Str(nil))
+	assert.Equal(t, int64(2),
+		gqltest.Eval(t, `table({a:1,b:"x"}, {a:2,b:"y"}, {a:1,b:"x"}) | reduce(row_hash(_), |a, b| a, map:=_) | count()`, env).Int(nil))
+}
+
+func TestHashBucket(t *testing.T) {
+	env := gqltest.NewSession()
+	// These values must not change across gql versions.
+	for _, test := range []struct {
+		expr string
+		want int64
+	}{
+		{`hash64_seed("foo", 0)`, 7067957609529580592},
+		{`bucket("foo", 2)`, 1},
+		{`bucket("foo", 10)`, 7},
+		{`bucket("foo", 1000)`, 766},
+		{`consistent_bucket("foo", 1)`, 0},
+		{`consistent_bucket("foo", 10)`, 1},
+		{`consistent_bucket("foo", 100)`, 45},
+		{`consistent_bucket("foo", 1000)`, 132},
+	} {
+		assert.Equal(t, test.want, gqltest.Eval(t, test.expr, env).Int(nil), test.expr)
+	}
+	assert.NotEqual(t,
+		gqltest.Eval(t, `hash64_seed("foo", 1)`, env).Int(nil),
+		gqltest.Eval(t, `hash64_seed("foo", 2)`, env).Int(nil))
+	assert.Equal(t,
+		gqltest.Eval(t, `bucket({a:1}, 7, seed:=3)`, env).Int(nil),
+		gqltest.Eval(t, `bucket({a:1}, 7, seed:=3)`, env).Int(nil))
+
+	// The buckets are about equally likely.
+	for i := int64(0); i < 4; i++ {
+		n := gqltest.Eval(t, fmt.Sprintf("range(0, 4000) | filter(bucket(_, 4, seed:=7) == %d) | count()", i), env).Int(nil)
+		assert.Truef(t, n >= 900 && n <= 1100, "bucket %d: n=%d", i, n)
+	}
+	// Growing the number of buckets from 10 to 11 moves only the rows that go to
+	// the new bucket.
+	assert.Equal(t, int64(0), gqltest.Eval(t,
+		"range(0, 2000) | filter(consistent_bucket(_, 10) != consistent_bucket(_, 11) && consistent_bucket(_, 11) != 10) | count()", env).Int(nil))
+
+	assert.Panics(t, func() { gqltest.Eval(t, `bucket("foo", 0)`, env) })
 }
 
 func TestRandomSamplingUsingHash(t *testing.T) {
@@ -1285,6 +2245,16 @@ func TestOptionalField(t *testing.T) {
 	assert.Equal(t, gql.NullType, gqltest.Eval(t, `optionalfield({a:10,b:11}, c)`, env).Type())
 }
 
+func TestWithDefaults(t *testing.T) {
+	env := gqltest.NewSession()
+	assert.Equal(t, "{a:10,qc:unknown}", gqltest.Eval(t, `with_defaults({a:10}, {a:0, qc:"unknown"})`, env).String())
+	assert.Equal(t, "{a:0,qc:pass,b:1}", gqltest.Eval(t, `with_defaults({qc:"pass", b:1}, {a:0, qc:"unknown"})`, env).String())
+	assert.Equal(t,
+		[]string{"{a:1,b:NA}", "{a:0,b:2}"},
+		gqltest.ReadTable(gqltest.Eval(t, `table({a:1}, {b:2}) | map(with_defaults(_, {a:0, b:NA}, strict:=true))`, env)))
+	assert.Panics(t, func() { gqltest.Eval(t, `with_defaults({a:1, c:2}, {a:0}, strict:=true)`, env) })
+}
+
 func TestLoad(t *testing.T) {
 	ctx := context.Background()
 	tmpDir, cleanup := testutil.TempDir(t, "", "")
@@ -1401,6 +2371,23 @@ func BenchmarkReadSmallBTSV(b *testing.B) {
 	}
 }
 
+// BenchmarkReadSmallBTSVOneColumn is similar to BenchmarkReadSmallBTSV, but it
+// reads only one column of each row.
+func BenchmarkReadSmallBTSVOneColumn(b *testing.B) {
+	b.StopTimer()
+	tmpDir, cleanup := testutil.TempDir(b, "", "")
+	defer cleanup()
+	path := filepath.Join(tmpDir, "test.btsv")
+	r := rand.New(rand.NewSource(0))
+	generateTestTSV(path, 10000, 4, r)
+	env := gqltest.NewSession()
+	expr := fmt.Sprintf("read(`%s`) | filter($f0 %% 3 == 0) | pick(false)", path)
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		gqltest.Eval(b, expr, env)
+	}
+}
+
 var btsvPathFlag = flag.String("btsv-path", "", "BTSV file for benchmarking")
 
 func BenchmarkReadLargeBTSV(b *testing.B) {
@@ -1425,7 +2412,6 @@ var (
 // On ubuntu02.mpk, on 2018-10-22:
 //
 // BenchmarkFilterFragments-56    	       1	238402522041 ns/op
-//
 func BenchmarkFilterFragments(b *testing.B) {
 	if *prioPathFlag == "" {
 		b.Skip("skipped")
@@ -1590,3 +2576,44 @@ func TestMain(m *testing.M) {
 	shutdown()
 	os.Exit(status)
 }
+
+func TestFilterPushdown(t *testing.T) {
+	tempDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	defer func(old int) { gql.MaxTSVRowsInMemory = old }(gql.MaxTSVRowsInMemory)
+	gql.MaxTSVRowsInMemory = 10
+	env := gqltest.NewSession()
+
+	var data strings.Builder
+	data.WriteString("a\tb\n")
+	for i := 0; i < 100; i++ {
+		if i%7 == 0 {
+			fmt.Fprintf(&data, "NA\tx%d\n", i%3)
+			continue
+		}
+		fmt.Fprintf(&data, "%d\tx%d\n", i, i%3)
+	}
+	tsvPath := filepath.Join(tempDir, "large.tsv")
+	require.NoError(t, ioutil.WriteFile(tsvPath, []byte(data.String()), 0600))
+	btsvPath := filepath.Join(tempDir, "large.btsv")
+	gqltest.Eval(t, fmt.Sprintf("pushdownT0 := read(`%s`)", tsvPath), env)
+	gqltest.Eval(t, fmt.Sprintf("pushdownT0 | write(`%s`, shards:=2, index:={&a, &b})", btsvPath), env)
+	gqltest.Eval(t, fmt.Sprintf("pushdownT1 := read(`%s`)", btsvPath), env)
+
+	// The conditions are pushed down in the first expression, but not in the
+	// second.
+	for _, test := range []struct{ pushed, full string }{
+		{"&a > 20", "&a > 20 || false"},
+		{"&a >= 20 && &a < 50", "(&a >= 20 && &a < 50) || false"},
+		{"50 > &a", "50 > &a || false"},
+		{"&a != 30", "&a != 30 || false"},
+		{"&a == 30", "&a == 30 || false"},
+		{`&b == "x1" && &a <= 40`, `(&b == "x1" && &a <= 40) || false`},
+	} {
+		for _, tbl := range []string{"pushdownT0", "pushdownT1"} {
+			pushed := gqltest.Eval(t, fmt.Sprintf("%s | filter(%s)", tbl, test.pushed), env)
+			full := gqltest.Eval(t, fmt.Sprintf("%s | filter(%s)", tbl, test.full), env)
+			assert.Equal(t, gqltest.ReadTable(full), gqltest.ReadTable(pushed), "%s: %s", tbl, test.pushed)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package gql
+
+// This file implements the event hooks set in Opts. They let an application
+// that embeds gql, such as a notebook service, track the progress and the cost
+// of evaluation without parsing the logs.
+
+import (
+	"io"
+	"time"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/pkg/errors"
+)
+
+// The hooks are copied from the Opts fields of the same names in Init. Each
+// may be nil.
+var (
+	onStatementStart    func(stmt ASTStatement)
+	onStatementEnd      func(stmt ASTStatement, d time.Duration, err error)
+	onTableMaterialized func(h hash.Hash, path string, rows int)
+	onFileRead          func(path string, bytes int64)
+	onFileWrite         func(path string, bytes int64)
+)
+
+// evalStatement evaluates a toplevel statement, calling onStatementStart and
+// onStatementEnd around it. If the evaluation panics, onStatementEnd is called
+// with the error, and the panic is propagated.
+func evalStatement(st ASTStatement, eval func() Value) Value {
+	if onStatementStart == nil && onStatementEnd == nil {
+		return eval()
+	}
+	if onStatementStart != nil {
+		onStatementStart(st)
+	}
+	start := time.Now()
+	if onStatementEnd != nil {
+		defer func() {
+			e := recover()
+			var err error
+			if e != nil {
+				err = errors.Errorf("%v", e)
+			}
+			onStatementEnd(st, time.Since(start), err)
+			if e != nil {
+				panic(e)
+			}
+		}()
+	}
+	return eval()
+}
+
+// reportTableMaterialized calls onTableMaterialized, if set. Arg rows is -1 if
+// the number of rows is not known without reading the table.
+func reportTableMaterialized(h hash.Hash, path string, rows int) {
+	if onTableMaterialized != nil {
+		onTableMaterialized(h, path, rows)
+	}
+}
+
+// reportFileRead calls onFileRead, if set.
+func reportFileRead(path string, bytes int64) {
+	if onFileRead != nil {
+		onFileRead(path, bytes)
+	}
+}
+
+// reportFileWrite calls onFileWrite, if set.
+func reportFileWrite(path string, bytes int64) {
+	if onFileWrite != nil {
+		onFileWrite(path, bytes)
+	}
+}
+
+// countingWriter counts the bytes written to the underlying writer, so that
+// they can be reported by reportFileWrite. It is not thread safe.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer.
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,77 @@
+package gql
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/testutil"
+	"github.com/grailbio/testutil/assert"
+)
+
+func TestHooks(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	sess := newSession()
+
+	var (
+		mu           sync.Mutex
+		started      []string
+		ended        []string
+		errs         []error
+		materialized []int
+		read         = map[string]int64{}
+		written      = map[string]int64{}
+	)
+	defer func() {
+		onStatementStart, onStatementEnd, onTableMaterialized, onFileRead, onFileWrite = nil, nil, nil, nil, nil
+	}()
+	onStatementStart = func(stmt ASTStatement) {
+		mu.Lock()
+		started = append(started, stmt.Expr.String())
+		mu.Unlock()
+	}
+	onStatementEnd = func(stmt ASTStatement, d time.Duration, err error) {
+		mu.Lock()
+		ended = append(ended, stmt.Expr.String())
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+	onTableMaterialized = func(h hash.Hash, path string, rows int) {
+		mu.Lock()
+		materialized = append(materialized, rows)
+		mu.Unlock()
+	}
+	onFileRead = func(path string, bytes int64) {
+		mu.Lock()
+		read[path] += bytes
+		mu.Unlock()
+	}
+	onFileWrite = func(path string, bytes int64) {
+		mu.Lock()
+		written[path] += bytes
+		mu.Unlock()
+	}
+
+	path := filepath.Join(tmpDir, "hooks.tsv")
+	doEval(t, fmt.Sprintf("x := 10; table({a:x}) | write(`%s`)", path), sess)
+	assert.EQ(t, len(started), 2)
+	assert.EQ(t, len(ended), 2)
+	assert.EQ(t, errs, []error{nil, nil})
+	assert.EQ(t, written[path], int64(len("a\n10\n")))
+
+	nMaterialized := len(materialized)
+	assert.EQ(t, doEval(t, fmt.Sprintf("read(`%s`) | force() | count()", path), sess).Int(nil), int64(1))
+	assert.True(t, read[path] >= int64(len("a\n10\n")))
+	assert.EQ(t, len(materialized), nMaterialized+1)
+
+	// The statement is not a compile-time constant, so it fails during
+	// evaluation.
+	assert.Panics(t, func() { doEval(t, `table(1) | filter(_ > 0) | batch(0)`, sess) })
+	assert.EQ(t, len(ended), 4)
+	assert.NotNil(t, errs[3])
+	assert.Regexp(t, "n must be positive", errs[3].Error())
+}
@@ -0,0 +1,194 @@
+package gql
+
+// This file keeps track of the parallel stages, or jobs, run by map, reduce,
+// cogroup, sort, minn, and the resampling functions with shards>0. The jobs
+// are listed by the jobs() and job_logs() builtins.
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/log"
+)
+
+// maxJobs is the max number of jobs kept in jobRegistry. When exceeded, the
+// oldest finished jobs are dropped.
+const maxJobs = 1000
+
+// maxJobLogs is the max number of log entries kept per job.
+const maxJobLogs = 1000
+
+// jobLogEntry is a message logged by a job.
+type jobLogEntry struct {
+	time time.Time
+	// shard is the shard that logged the message, or -1 if the message is
+	// about the whole job.
+	shard   int
+	message string
+}
+
+// job is a parallel stage, run by bigslice or by the local executor.
+type job struct {
+	id       int
+	name     string // name of the builtin, e.g., "map".
+	location string // source location of the builtin call.
+	nshards  int
+	start    time.Time
+
+	mu         sync.Mutex
+	end        time.Time // zero while running.
+	failed     bool
+	shardsDone int
+	failures   int
+	logs       []jobLogEntry
+}
+
+var jobRegistry struct {
+	mu     sync.Mutex
+	jobs   []*job
+	nextID int
+}
+
+type jobKey struct{}
+
+// jobFromContext returns the job that runs in ctx, or nil.
+func jobFromContext(ctx context.Context) *job {
+	j, _ := ctx.Value(jobKey{}).(*job)
+	return j
+}
+
+// startJob registers a new job.
+func startJob(ast ASTNode, name string, nshards int) *job {
+	jobRegistry.mu.Lock()
+	defer jobRegistry.mu.Unlock()
+	jobRegistry.nextID++
+	j := &job{
+		id:       jobRegistry.nextID,
+		name:     name,
+		location: ast.pos().String(),
+		nshards:  nshards,
+		start:    time.Now(),
+	}
+	if len(jobRegistry.jobs) >= maxJobs {
+		for i, old := range jobRegistry.jobs {
+			if old.state() != "running" {
+				jobRegistry.jobs = append(jobRegistry.jobs[:i], jobRegistry.jobs[i+1:]...)
+				break
+			}
+		}
+	}
+	jobRegistry.jobs = append(jobRegistry.jobs, j)
+	j.logf(-1, "started %d shards", nshards)
+	return j
+}
+
+// listJobs returns the registered jobs, oldest first.
+func listJobs() []*job {
+	jobRegistry.mu.Lock()
+	defer jobRegistry.mu.Unlock()
+	return append([]*job(nil), jobRegistry.jobs...)
+}
+
+// findJob returns the job with the given ID, or nil.
+func findJob(id int) *job {
+	for _, j := range listJobs() {
+		if j.id == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// logf records a message in the job log. Arg shard is -1 for a message about
+// the whole job. It is a noop if j is nil.
+func (j *job) logf(shard int, format string, args ...interface{}) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	if len(j.logs) < maxJobLogs {
+		j.logs = append(j.logs, jobLogEntry{time: time.Now(), shard: shard, message: fmt.Sprintf(format, args...)})
+	}
+	j.mu.Unlock()
+}
+
+// shardDone records that a shard has finished. It is a noop if j is nil.
+func (j *job) shardDone(shard int) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.shardsDone++
+	j.mu.Unlock()
+}
+
+// shardFailed records that a shard has panicked with value e. It is a noop if
+// j is nil.
+func (j *job) shardFailed(shard int, e interface{}) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.failures++
+	j.mu.Unlock()
+	j.logf(shard, "failed: %v", e)
+}
+
+// finish records that the job has ended. Arg e is the panic value if the job
+// failed, or nil.
+func (j *job) finish(e interface{}) {
+	if e != nil {
+		j.logf(-1, "failed: %v", e)
+	} else {
+		j.logf(-1, "finished")
+	}
+	j.mu.Lock()
+	j.end = time.Now()
+	j.failed = e != nil
+	if e == nil {
+		// A cluster job doesn't report the progress of the individual shards.
+		j.shardsDone = j.nshards
+	}
+	j.mu.Unlock()
+}
+
+// state returns one of "running", "done", or "failed".
+func (j *job) state() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch {
+	case j.end.IsZero():
+		return "running"
+	case j.failed:
+		return "failed"
+	}
+	return "done"
+}
+
+// runJob runs a parallel stage of nshards shards and records it as a job. If
+// a bigslice cluster is configured, it calls remote, which runs the stage
+// using bsSession. Else, it calls local, which runs the shards using
+// runLocalShards with the given context. If a remote shard fails with a
+// RowError, runJob panics with the RowError.
+func runJob(ctx context.Context, ast ASTNode, name string, nshards int, local func(ctx context.Context), remote func() error) {
+	j := startJob(ast, name, nshards)
+	defer func() {
+		e := recover()
+		j.finish(e)
+		if e != nil {
+			panic(e)
+		}
+	}()
+	if bsSession == nil {
+		local(context.WithValue(ctx, jobKey{}, j))
+		return
+	}
+	if err := remote(); err != nil {
+		if rowErr := findRowError(err); rowErr != nil {
+			panic(rowErr)
+		}
+		log.Panic(err)
+	}
+}
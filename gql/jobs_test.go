@@ -0,0 +1,41 @@
+package gql
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/grailbio/testutil/h"
+)
+
+func TestJobs(t *testing.T) {
+	sess := newSession()
+	nJobs := len(listJobs())
+	// Make the expression unique, so that the result isn't in the cache.
+	expr := fmt.Sprintf("range(0, 20) | map(_+%d, shards:=4)", time.Now().UnixNano())
+	expect.EQ(t, len(doReadTable(doEval(t, expr, sess))), 20)
+	jobs := listJobs()
+	expect.EQ(t, len(jobs), nJobs+1)
+	j := jobs[len(jobs)-1]
+	expect.EQ(t, j.name, "map")
+	expect.EQ(t, j.state(), "done")
+	expect.EQ(t, j.shardsDone, 4)
+	expect.EQ(t, doReadTable(doEval(t, fmt.Sprintf("jobs() | filter(&id == %d) | map({&state, &shards_done})", j.id), sess)),
+		[]string{"{state:done,shards_done:4}"})
+
+	expect.That(t, func() {
+		doReadTable(doEval(t, "range(0, 20) | map(if _ == 7 { int(\"x\") } else { _ }, shards:=3)", sess))
+	}, h.Panics(h.Regexp("failed to parse 'x' as int")))
+	jobs = listJobs()
+	j = jobs[len(jobs)-1]
+	expect.EQ(t, j.state(), "failed")
+	expect.EQ(t, j.failures, 1)
+	expect.EQ(t, j.shardsDone, 2)
+	logs := doReadTable(doEval(t, fmt.Sprintf("job_logs(%d) | filter(!isnull(&shard)) | map(&message)", j.id), sess))
+	expect.EQ(t, len(logs), 1)
+	expect.That(t, logs[0], h.Regexp("failed: .*failed to parse 'x' as int"))
+
+	expect.That(t, func() { doReadTable(doEval(t, "job_logs(1000000)", sess)) },
+		h.Panics(h.Regexp("job 1000000 not found")))
+}
@@ -378,3 +378,22 @@ func BenchmarkLargeRNAJoin(b *testing.B) {
     joined | write("/tmp/joined.btsv");`, env)
 	}
 }
+
+func TestJoinSuffixes(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `T0 := table({id:1, v:"a"}, {id:2, v:"b"})`, env)
+	gqltest.Eval(t, `T1 := table({id:2, v:"c"}, {id:3, v:"d"})`, env)
+	assert.Equal(t,
+		[]string{`{id_a:2,v_a:b,id_b:2,v_b:c}`},
+		gqltest.ReadTable(gqltest.Eval(t, `join({t0:T0,t1:T1}, t0.id==t1.id, suffixes:={t0:"_a", t1:"_b"})`, env)))
+	// The columns of a table listed with "" keep their names.
+	assert.Equal(t,
+		[]string{`{id:2,v:b,id_r:2,v_r:c}`},
+		gqltest.ReadTable(gqltest.Eval(t, `join({t0:T0,t1:T1}, t0.id==t1.id, suffixes:={t0:"", t1:"_r"})`, env)))
+	assert.Panics(t, func() {
+		gqltest.ReadTable(gqltest.Eval(t, `join({t0:T0,t1:T1}, t0.id==t1.id, suffixes:={t0:"_x", t1:"_x"})`, env))
+	})
+	assert.Panics(t, func() {
+		gqltest.Eval(t, `join({t0:T0,t1:T1}, t0.id==t1.id, suffixes:={t2:"_x"})`, env)
+	})
+}
@@ -0,0 +1,379 @@
+package gql
+
+// This file implements the handler for JSON files. A ".jsonl" or ".ndjson" file
+// stores one JSON value per line, and a ".json" file stores either a sequence
+// of JSON values or a JSON array. Each value becomes a row. An object becomes a
+// struct, with fields in the same order as the keys, and an array becomes a
+// table.
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/grailbio/base/compress"
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+	"github.com/grailbio/gql/termutil"
+)
+
+// jsonTable is a table backed by a JSON file. It reads the file each time a
+// scanner is created. The file can't be sharded, so the table is read
+// sequentially.
+type jsonTable struct {
+	hashOnce sync.Once
+	hash     hash.Hash
+	ast      ASTNode // source-code location
+	path     string
+
+	exactLenOnce sync.Once
+	exactLen     int
+}
+
+// NewJSONTable returns a new table backed by the JSON or JSON-Lines file at the
+// provided path.
+func NewJSONTable(path string, ast ASTNode, hash hash.Hash) Table {
+	return &jsonTable{hash: hash, ast: ast, path: path}
+}
+
+func (t *jsonTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	MarshalTablePath(enc, t.path, singletonJSONFileHandler, t.Hash())
+}
+
+func (t *jsonTable) Hash() hash.Hash {
+	t.hashOnce.Do(func() {
+		if t.hash == hash.Zero {
+			t.hash = FileHash(BackgroundContext, t.path, t.ast)
+		}
+	})
+	return t.hash
+}
+
+func (t *jsonTable) Attrs(ctx context.Context) TableAttrs {
+	return TableAttrs{Name: "json", Path: t.path}
+}
+
+func (t *jsonTable) Prefetch(ctx context.Context) {}
+
+func (t *jsonTable) Len(ctx context.Context, mode CountMode) int {
+	if mode == Approx {
+		// Return a large value, so that the table isn't printed inline. Same
+		// as TSVTable.
+		return 100000
+	}
+	t.exactLenOnce.Do(func() {
+		t.exactLen = DefaultTableLen(ctx, t)
+	})
+	return t.exactLen
+}
+
+func (t *jsonTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	if start > 0 {
+		// A JSON file can't be split at row boundaries without parsing it, so
+		// the first shard reads the whole file.
+		return &NullTableScanner{}
+	}
+	in, err := openRetryingFile(ctx, t.path)
+	if err != nil {
+		Panicf(t.ast, "read %s: open: %v", t.path, err)
+	}
+	compressr, _ := compress.NewReader(in.Reader(ctx))
+	dec := json.NewDecoder(bufio.NewReader(compressr))
+	dec.UseNumber()
+	return &jsonTableScanner{ctx: ctx, parent: t, in: in, dec: dec}
+}
+
+type jsonTableScanner struct {
+	ctx    context.Context
+	parent *jsonTable
+	in     *retryingFile
+	dec    *json.Decoder
+
+	started bool // true after the first Scan call.
+	inArray bool // true if the file is a JSON array.
+	value   Value
+}
+
+func (sc *jsonTableScanner) Scan() bool {
+	if sc.in == nil {
+		return false
+	}
+	if !sc.started {
+		sc.started = true
+		if !sc.dec.More() {
+			sc.close()
+			return false
+		}
+		tok := sc.token()
+		if delim, ok := tok.(json.Delim); ok && delim == '[' {
+			sc.inArray = true
+		} else {
+			sc.value = sc.decodeValue(tok)
+			return true
+		}
+	}
+	if !sc.dec.More() {
+		if sc.inArray {
+			sc.token() // the closing ']'.
+			if sc.dec.More() {
+				Panicf(sc.parent.ast, "read %s: unexpected data after the toplevel array", sc.parent.path)
+			}
+		}
+		sc.close()
+		return false
+	}
+	sc.value = sc.decodeValue(sc.token())
+	return true
+}
+
+func (sc *jsonTableScanner) Value() Value { return sc.value }
+
+func (sc *jsonTableScanner) close() {
+	if err := sc.in.Close(sc.ctx); err != nil {
+		Panicf(sc.parent.ast, "read %s: close: %v", sc.parent.path, err)
+	}
+	sc.in = nil
+}
+
+// token reads the next JSON token.
+func (sc *jsonTableScanner) token() json.Token {
+	tok, err := sc.dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		Panicf(sc.parent.ast, "read %s: offset %d: %v", sc.parent.path, sc.dec.InputOffset(), err)
+	}
+	return tok
+}
+
+// decodeValue converts the JSON value that starts with the given token into a
+// Value. A null becomes NA, a number becomes an int if it is integral and a
+// float otherwise, an object becomes a struct, and an array becomes a table.
+func (sc *jsonTableScanner) decodeValue(tok json.Token) Value {
+	switch v := tok.(type) {
+	case nil:
+		return Null
+	case bool:
+		return NewBool(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return NewInt(i)
+		}
+		f, err := v.Float64()
+		if err != nil {
+			Panicf(sc.parent.ast, "read %s: offset %d: %v cannot be parsed as a number: %v", sc.parent.path, sc.dec.InputOffset(), v, err)
+		}
+		return NewFloat(f)
+	case string:
+		return NewString(v)
+	case json.Delim:
+		switch v {
+		case '{':
+			var fields []StructField
+			for sc.dec.More() {
+				key := sc.token().(string)
+				name := symbol.Intern(key)
+				for _, f := range fields {
+					if f.Name == name {
+						Panicf(sc.parent.ast, "read %s: offset %d: duplicate key '%s'", sc.parent.path, sc.dec.InputOffset(), key)
+					}
+				}
+				fields = append(fields, StructField{Name: name, Value: sc.decodeValue(sc.token())})
+			}
+			sc.token() // the closing '}'.
+			return NewStruct(NewSimpleStruct(fields...))
+		case '[':
+			var elems []Value
+			for sc.dec.More() {
+				elems = append(elems, sc.decodeValue(sc.token()))
+			}
+			sc.token() // the closing ']'.
+			return NewTable(NewSimpleTable(elems, hashValues(elems), TableAttrs{Name: "json"}))
+		}
+	}
+	Panicf(sc.parent.ast, "read %s: offset %d: unexpected token %v", sc.parent.path, sc.dec.InputOffset(), tok)
+	return Value{}
+}
+
+// jsonArrayOutput checks if write() should produce a JSON array, as opposed to
+// one value per line.
+func jsonArrayOutput(path string) bool {
+	return strings.HasSuffix(compressionSuffixRE.ReplaceAllString(path, ""), ".json")
+}
+
+// writeJSONFile writes the table to a JSON file. If the path ends with ".json"
+// (optionally followed by ".gz"), the rows are written as a JSON array.
+// Otherwise, each row is written as a JSON value in its own line.
+func writeJSONFile(ctx context.Context, path string, table Table, overwrite bool) {
+	if _, err := file.Stat(ctx, path); err == nil && !overwrite {
+		log.Printf("write %v: file already exists and --overwrite-files=false.", path)
+		return
+	}
+	gzipFile := tsvGzipOutput(path, tsvWriterOpts{})
+	out, err := file.Create(ctx, path)
+	if err != nil {
+		log.Panicf("write %v: %v", path, err)
+	}
+	var (
+		w   io.Writer = out.Writer(ctx)
+		gwr *gzip.Writer
+	)
+	if gzipFile {
+		gwr = gzip.NewWriter(w)
+		w = gwr
+	}
+	bw := bufio.NewWriter(w)
+	jw := jsonWriter{ctx: ctx, path: path, w: bw}
+
+	array := jsonArrayOutput(path)
+	if array {
+		jw.writeString("[\n")
+	}
+	sc := table.Scanner(ctx, 0, 1, 1)
+	n := 0
+	for ; sc.Scan(); n++ {
+		if array && n > 0 {
+			jw.writeString(",\n")
+		}
+		jw.writeValue(sc.Value())
+		if !array {
+			jw.writeString("\n")
+		}
+	}
+	if array {
+		if n > 0 {
+			jw.writeString("\n")
+		}
+		jw.writeString("]\n")
+	}
+	if err := bw.Flush(); err != nil {
+		log.Panicf("write %v: %v", path, err)
+	}
+	if gwr != nil {
+		if err := gwr.Close(); err != nil {
+			log.Panicf("write %v: %v", path, err)
+		}
+	}
+	if err := out.Close(ctx); err != nil {
+		log.Panicf("write %v: close: %v", path, err)
+	}
+}
+
+// jsonWriter converts values to JSON.
+type jsonWriter struct {
+	ctx  context.Context
+	path string
+	w    *bufio.Writer
+}
+
+func (w *jsonWriter) writeString(s string) {
+	if _, err := w.w.WriteString(s); err != nil {
+		log.Panicf("write %v: %v", w.path, err)
+	}
+}
+
+func (w *jsonWriter) writeQuoted(s string) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		log.Panicf("write %v: %v", w.path, err)
+	}
+	if _, err := w.w.Write(data); err != nil {
+		log.Panicf("write %v: %v", w.path, err)
+	}
+}
+
+// writeValue writes the value as JSON. NA and a float that is NaN or infinite
+// become null, since JSON can't represent them. A struct becomes an object,
+// and a table becomes an array. A date, a datetime, a duration, and a char
+// become strings.
+func (w *jsonWriter) writeValue(v Value) {
+	switch v.Type() {
+	case NullType:
+		w.writeString("null")
+	case BoolType:
+		w.writeString(strconv.FormatBool(v.Bool(nil)))
+	case IntType:
+		w.writeString(strconv.FormatInt(v.Int(nil), 10))
+	case FloatType:
+		f := v.Float(nil)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			w.writeString("null")
+			return
+		}
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+		if isTSVIntString(s) {
+			// Keep the ".0", so that the value is read back as a float.
+			s += ".0"
+		}
+		w.writeString(s)
+	case StringType, FileNameType, EnumType:
+		w.writeQuoted(v.Str(nil))
+	case CharType:
+		w.writeQuoted(string(v.Char(nil)))
+	case DateType, DateTimeType, DurationType:
+		out := termutil.NewBufferPrinter()
+		v.Print(w.ctx, PrintArgs{Out: out, Mode: PrintValues})
+		w.writeQuoted(out.String())
+	case StructType:
+		s := v.Struct(nil)
+		w.writeString("{")
+		for fi := 0; fi < s.Len(); fi++ {
+			if fi > 0 {
+				w.writeString(",")
+			}
+			f := s.Field(fi)
+			w.writeQuoted(f.Name.Str())
+			w.writeString(":")
+			w.writeValue(f.Value)
+		}
+		w.writeString("}")
+	case TableType:
+		w.writeString("[")
+		sc := v.Table(nil).Scanner(w.ctx, 0, 1, 1)
+		for n := 0; sc.Scan(); n++ {
+			if n > 0 {
+				w.writeString(",")
+			}
+			w.writeValue(sc.Value())
+		}
+		w.writeString("]")
+	default:
+		log.Panicf("write %v: %v cannot be written as JSON", w.path, v)
+	}
+}
+
+// jsonFileHandler is a FileHandler implementation for JSON and JSON-Lines
+// files.
+type jsonFileHandler struct{}
+
+var singletonJSONFileHandler = &jsonFileHandler{}
+
+// Name implements FileHandler.
+func (*jsonFileHandler) Name() string { return "json" }
+
+// Open implements FileHandler.
+func (*jsonFileHandler) Open(ctx context.Context, path string, ast ASTNode, hash hash.Hash) Table {
+	return NewJSONTable(path, ast, hash)
+}
+
+// Write implements FileHandler.
+func (*jsonFileHandler) Write(ctx context.Context, path string, ast ASTNode, table Table, nShard int, overwrite bool) {
+	writeJSONFile(ctx, path, table, overwrite)
+}
+
+func init() {
+	RegisterFileHandler(singletonJSONFileHandler,
+		`\.jsonl`+OptionalCompression,
+		`\.ndjson`+OptionalCompression,
+		`\.json`+OptionalCompression)
+}
@@ -84,6 +84,18 @@ func (lex *lexer) next(sym *yySymType) int {
 		case "load":
 			sym.pos = lex.curPos
 			return tokLoad
+		case "matview":
+			// "matview(expr)" is a call to the builtin function. Otherwise, it
+			// starts a statement "matview name := expr".
+			if lex.sc.Peek() != '(' {
+				sym.pos = lex.curPos
+				return tokMatview
+			}
+			sym.stringNode = stringNode{pos: lex.curPos, str: str}
+			return tokIdent
+		case "const":
+			sym.pos = lex.curPos
+			return tokConst
 		case "false":
 			sym.expr = &ASTLiteral{Pos: lex.curPos, Literal: False}
 			return tokBool
@@ -260,6 +272,10 @@ var lexOpDefs = []lexOpDef{
 	{"!=", tokNE},
 	{">=", tokGEQ},
 	{"<=", tokLEQ},
+	{">?", tokGTNotNull},
+	{">=?", tokGEQNotNull},
+	{"<?", tokLTNotNull},
+	{"<=?", tokLEQNotNull},
 	{">", '>'},
 	{"<", '<'},
 	{"+", '+'},
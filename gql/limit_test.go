@@ -0,0 +1,53 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFirstNPushdown(t *testing.T) {
+	ctx := context.Background()
+	sess := newSession()
+	TestClearCache()
+	var rows []string
+	for i := 0; i < 100; i++ {
+		rows = append(rows, fmt.Sprintf("{a:%d}", i))
+	}
+	doEval(t, fmt.Sprintf("t0 := table(%s)", strings.Join(rows, ",")), sess)
+
+	pm := doEval(t, "pm := t0 | map({b:$a*2}, shards:=4)", sess)
+	ptable, ok := pm.Table(nil).(*parallelMapFilterTable)
+	require.True(t, ok)
+	assert.Equal(t,
+		[]string{"{b:0}", "{b:2}", "{b:4}"},
+		doReadTable(doEval(t, "pm | firstn(3)", sess)))
+	// Only the limited job ran.
+	assert.False(t, cacheEntryExists(ctx, ptable.hash.String()+".btsv"))
+	limited := limitTable(ctx, ptable, 3)
+	assert.NotEqual(t, ptable.Hash(), limited.Hash())
+	assert.Equal(t, limited, limitTable(ctx, limited, 10))
+
+	assert.Equal(t, "{b:84}", doEval(t, "pm | pick($b > 83)", sess).String())
+	assert.Equal(t, "NA", doEval(t, "pm | pick($b > 1000)", sess).String())
+
+	// The limit is pushed through map and flatten, but not through filter.
+	assert.Equal(t,
+		[]string{"{a:0}", "{a:1}"},
+		doReadTable(doEval(t, "flatten(table(t0, t0)) | firstn(2)", sess)))
+	assert.Equal(t,
+		[]string{"{a:98}", "{a:99}", "{a:0}"},
+		doReadTable(doEval(t, "flatten(table(t0 | filter($a >= 98), t0)) | firstn(3)", sess)))
+	mf := doEval(t, "t0 | map({c:$a}, filter:=$a>1)", sess).Table(nil)
+	assert.Equal(t, mf, limitTable(ctx, mf, 3))
+
+	// The full table is used once it is computed.
+	assert.Equal(t, 100, ptable.Len(ctx, Exact))
+	doReadTable(pm)
+	assert.True(t, cacheEntryExists(ctx, ptable.hash.String()+".btsv"))
+	assert.Equal(t, ptable, limitTable(ctx, ptable, 3))
+}
@@ -0,0 +1,101 @@
+package gql
+
+// This file implements the resource limits of an evaluation. See Limits.
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/grailbio/base/log"
+)
+
+// Limits bounds the resources used by one call to Session.EvalStatements,
+// Session.EvalFile, or Session.Eval. An evaluation that exceeds a limit fails
+// with an error that names the limit. Limits are set in Opts.Limits or by
+// Session.SetLimits. A zero field means no limit.
+//
+// Only the work done in this process is counted; the rows and bytes read by
+// remote bigslice workers are not.
+type Limits struct {
+	// MaxRows is the max number of rows scanned. The rows read from files and
+	// the rows produced by cross() and the table generators, such as range(),
+	// are counted.
+	MaxRows int64
+	// MaxBytes is the max number of bytes read from table files.
+	MaxBytes int64
+	// MaxDuration is the max wall time of the evaluation.
+	MaxDuration time.Duration
+}
+
+// defaultLimits is copied from Opts.Limits. It is the initial value of
+// Session.limits.
+var defaultLimits Limits
+
+// evalBudget tracks the resources used by an evaluation.
+type evalBudget struct {
+	limits Limits
+	start  time.Time
+	// rows and bytes are updated atomically.
+	rows, bytes int64
+}
+
+type evalBudgetKey struct{}
+
+// withEvalBudget returns a context that tracks the resources used against the
+// limits. If ctx already has a budget, e.g., when evaluating a loaded script,
+// the budget is shared.
+func withEvalBudget(ctx context.Context, limits Limits) (context.Context, context.CancelFunc) {
+	if limits == (Limits{}) || evalBudgetFromContext(ctx) != nil {
+		return ctx, func() {}
+	}
+	b := &evalBudget{limits: limits, start: time.Now()}
+	ctx = context.WithValue(ctx, evalBudgetKey{}, b)
+	if limits.MaxDuration > 0 {
+		// Cancelling the context aborts the file reads and the scans.
+		return context.WithTimeout(ctx, limits.MaxDuration)
+	}
+	return ctx, func() {}
+}
+
+// evalBudgetFromContext returns the budget of the evaluation, or nil if the
+// evaluation has no limit.
+func evalBudgetFromContext(ctx context.Context) *evalBudget {
+	b, _ := ctx.Value(evalBudgetKey{}).(*evalBudget)
+	return b
+}
+
+// addRows records that n rows have been scanned. It panics if a limit is
+// exceeded. It is a noop if b is nil.
+func (b *evalBudget) addRows(n int64) {
+	if b == nil {
+		return
+	}
+	rows := atomic.AddInt64(&b.rows, n)
+	if b.limits.MaxRows > 0 && rows > b.limits.MaxRows {
+		log.Panicf("evaluation aborted: scanned more than %d rows; see Limits.MaxRows", b.limits.MaxRows)
+	}
+	if rows%1024 == 0 {
+		b.checkDuration()
+	}
+}
+
+// addBytes records that n bytes have been read. It panics if a limit is
+// exceeded. It is a noop if b is nil.
+func (b *evalBudget) addBytes(n int64) {
+	if b == nil {
+		return
+	}
+	bytes := atomic.AddInt64(&b.bytes, n)
+	if b.limits.MaxBytes > 0 && bytes > b.limits.MaxBytes {
+		log.Panicf("evaluation aborted: read more than %d bytes; see Limits.MaxBytes", b.limits.MaxBytes)
+	}
+	b.checkDuration()
+}
+
+// checkDuration panics if the evaluation has run longer than the limit.
+func (b *evalBudget) checkDuration() {
+	if b.limits.MaxDuration > 0 && time.Since(b.start) > b.limits.MaxDuration {
+		log.Panicf("evaluation aborted: ran longer than %v; see Limits.MaxDuration", b.limits.MaxDuration)
+	}
+}
@@ -0,0 +1,43 @@
+package gql
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grailbio/testutil"
+	"github.com/grailbio/testutil/expect"
+	"github.com/grailbio/testutil/h"
+)
+
+func TestEvalLimits(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	sess := newSession()
+
+	sess.SetLimits(Limits{MaxRows: 100})
+	expect.EQ(t, doEval(t, "range(0, 50) | filter(_ > 0) | count()", sess).Int(nil), int64(49))
+	expect.That(t, func() { doEval(t, "range(0, 1000) | filter(_ > 0) | count()", sess) },
+		h.Panics(h.Regexp("scanned more than 100 rows")))
+	expect.That(t, func() { doEval(t, "cross(range(0, 50), range(0, 50)) | filter(_.t0 >= 0) | count()", sess) },
+		h.Panics(h.Regexp("scanned more than 100 rows")))
+	// Each evaluation has its own budget.
+	expect.EQ(t, doEval(t, "range(0, 50) | filter(_ > 0) | count()", sess).Int(nil), int64(49))
+
+	path := filepath.Join(tmpDir, "limits.tsv")
+	sess.SetLimits(Limits{})
+	doEval(t, fmt.Sprintf("range(0, 100) | map({a:_}) | write(`%s`)", path), sess)
+	sess.SetLimits(Limits{MaxBytes: 10})
+	expect.That(t, func() { doEval(t, fmt.Sprintf("read(`%s`) | filter(&a >= 0) | count()", path), sess) },
+		h.Panics(h.Regexp("read more than 10 bytes")))
+	sess.SetLimits(Limits{MaxBytes: 1000})
+	expect.EQ(t, doEval(t, fmt.Sprintf("read(`%s`) | filter(&a >= 0) | count()", path), sess).Int(nil), int64(100))
+
+	b := &evalBudget{limits: Limits{MaxDuration: time.Second}, start: time.Now().Add(-time.Minute)}
+	expect.That(t, b.checkDuration, h.Panics(h.Regexp("ran longer than 1s")))
+	// A nil budget has no limit.
+	var nb *evalBudget
+	nb.addRows(1)
+	nb.addBytes(1)
+}
@@ -0,0 +1,114 @@
+package gql
+
+// This file implements the small dense linear-algebra routines used by pca and
+// the clustering builtins. Matrices are stored in row-major []float64.
+
+import (
+	"math"
+	"sort"
+)
+
+// symmetricEigen computes the eigenvalues and the eigenvectors of the n*n
+// symmetric matrix a using the cyclic Jacobi method. The eigenvalues are sorted
+// in descending order. The i'th eigenvector is stored in column i of vecs
+// (row-major, n*n). The contents of a are destroyed.
+func symmetricEigen(a []float64, n int) (vals []float64, vecs []float64) {
+	v := make([]float64, n*n)
+	for i := 0; i < n; i++ {
+		v[i*n+i] = 1
+	}
+	for sweep := 0; sweep < 100; sweep++ {
+		off := 0.0
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				off += a[i*n+j] * a[i*n+j]
+			}
+		}
+		if off < 1e-22 {
+			break
+		}
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				apq := a[p*n+q]
+				if math.Abs(apq) < 1e-300 {
+					continue
+				}
+				theta := (a[q*n+q] - a[p*n+p]) / (2 * apq)
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < n; k++ {
+					akp, akq := a[k*n+p], a[k*n+q]
+					a[k*n+p] = c*akp - s*akq
+					a[k*n+q] = s*akp + c*akq
+				}
+				for k := 0; k < n; k++ {
+					apk, aqk := a[p*n+k], a[q*n+k]
+					a[p*n+k] = c*apk - s*aqk
+					a[q*n+k] = s*apk + c*aqk
+				}
+				for k := 0; k < n; k++ {
+					vkp, vkq := v[k*n+p], v[k*n+q]
+					v[k*n+p] = c*vkp - s*vkq
+					v[k*n+q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return a[order[i]*n+order[i]] > a[order[j]*n+order[j]] })
+	vals = make([]float64, n)
+	vecs = make([]float64, n*n)
+	for col, i := range order {
+		vals[col] = a[i*n+i]
+		for k := 0; k < n; k++ {
+			vecs[k*n+col] = v[k*n+i]
+		}
+	}
+	return vals, vecs
+}
+
+// orthonormalizeColumns orthonormalizes the columns of the rows*cols matrix a in
+// place, using the modified Gram-Schmidt process. A column that is linearly
+// dependent on the preceding ones becomes zero.
+func orthonormalizeColumns(a []float64, rows, cols int) {
+	for j := 0; j < cols; j++ {
+		for k := 0; k < j; k++ {
+			dot := 0.0
+			for i := 0; i < rows; i++ {
+				dot += a[i*cols+j] * a[i*cols+k]
+			}
+			for i := 0; i < rows; i++ {
+				a[i*cols+j] -= dot * a[i*cols+k]
+			}
+		}
+		norm := 0.0
+		for i := 0; i < rows; i++ {
+			norm += a[i*cols+j] * a[i*cols+j]
+		}
+		norm = math.Sqrt(norm)
+		for i := 0; i < rows; i++ {
+			if norm > 1e-12 {
+				a[i*cols+j] /= norm
+			} else {
+				a[i*cols+j] = 0
+			}
+		}
+	}
+}
+
+// squaredDistance computes the squared Euclidean distance between two vectors.
+func squaredDistance(x, y []float64) float64 {
+	d := 0.0
+	for i := range x {
+		diff := x[i] - y[i]
+		d += diff * diff
+	}
+	return d
+}
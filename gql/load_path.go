@@ -0,0 +1,92 @@
+package gql
+
+// This file implements resolution of paths in "load `path`" statements.
+//
+// A relative path is looked up in the following places, in order:
+//
+// 1. The directory of the script that contains the load statement.
+//
+// 2. The current directory.
+//
+// 3. The directories listed in Opts.LoadPath (flag -gql-path, or $GQLPATH).
+//
+// A directory may be an S3 prefix, e.g., "s3://bucket/gqllib". Scripts read
+// from S3 are cached in memory, and are reread only when they change.
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/base/log"
+)
+
+// cachedScript is an entry in scriptCache.
+type cachedScript struct {
+	modTime time.Time
+	size    int64
+	data    []byte
+}
+
+var (
+	scriptCacheMu sync.Mutex
+	// scriptCache maps a path of a remote script to its contents.
+	scriptCache = map[string]cachedScript{}
+)
+
+// resolveLoadPath finds the script named in a "load `path`" statement that
+// appears in script "from". It returns path itself if the script is not
+// found, so that the caller reports an error with the original name. See the
+// comment at the beginning of this file for the search rules.
+func resolveLoadPath(ctx context.Context, path, from string) string {
+	if strings.Contains(path, "://") || strings.HasPrefix(path, "/") {
+		return path
+	}
+	var candidates []string
+	// Skip pseudo script names such as "(input)".
+	if from != "" && !strings.HasPrefix(from, "(") {
+		if i := strings.LastIndexByte(from, '/'); i > 0 {
+			candidates = append(candidates, file.Join(from[:i], path))
+		}
+	}
+	candidates = append(candidates, path)
+	for _, dir := range loadPath {
+		candidates = append(candidates, file.Join(dir, path))
+	}
+	for _, c := range candidates {
+		if _, err := file.Stat(ctx, c); err == nil {
+			if c != path {
+				log.Debug.Printf("load %s: resolved to %s", path, c)
+			}
+			return c
+		}
+	}
+	return path
+}
+
+// readScript reads the contents of a script. Remote scripts are cached.
+func readScript(ctx context.Context, path string) ([]byte, error) {
+	if !strings.Contains(path, "://") {
+		return file.ReadFile(ctx, path)
+	}
+	info, err := file.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	scriptCacheMu.Lock()
+	ent, ok := scriptCache[path]
+	scriptCacheMu.Unlock()
+	if ok && ent.modTime.Equal(info.ModTime()) && ent.size == info.Size() {
+		return ent.data, nil
+	}
+	data, err := file.ReadFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	scriptCacheMu.Lock()
+	scriptCache[path] = cachedScript{modTime: info.ModTime(), size: info.Size(), data: data}
+	scriptCacheMu.Unlock()
+	return data, nil
+}
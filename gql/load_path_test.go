@@ -0,0 +1,42 @@
+package gql
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPath(t *testing.T) {
+	ctx := context.Background()
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	libDir, cleanup2 := testutil.TempDir(t, "", "")
+	defer cleanup2()
+
+	write := func(path, data string) {
+		require.NoError(t, file.WriteFile(ctx, path, []byte(data)))
+	}
+	// Relative paths are resolved against the directory of the loading script,
+	// not the current directory.
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "sub"), 0755))
+	write(filepath.Join(tmpDir, "main.gql"), "load `sub/a.gql`; loadPathA * 2")
+	write(filepath.Join(tmpDir, "sub/a.gql"), "load `b.gql`; loadPathA := loadPathB + 1")
+	write(filepath.Join(tmpDir, "sub/b.gql"), "loadPathB := 10")
+	sess := newSession()
+	assert.Equal(t, int64(22), sess.EvalFile(ctx, filepath.Join(tmpDir, "main.gql")).Int(nil))
+
+	// Scripts not found there are looked up in the search path.
+	write(filepath.Join(libDir, "c.gql"), "loadPathC := 5")
+	old := loadPath
+	defer func() { loadPath = old }()
+	loadPath = []string{tmpDir, libDir}
+	assert.Equal(t, int64(6), doEval(t, "load `c.gql`; loadPathC + 1", sess).Int(nil))
+	assert.Equal(t, filepath.Join(libDir, "c.gql"), resolveLoadPath(ctx, "c.gql", filepath.Join(tmpDir, "main.gql")))
+	assert.Equal(t, "nonexistent.gql", resolveLoadPath(ctx, "nonexistent.gql", "(input)"))
+}
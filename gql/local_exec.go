@@ -0,0 +1,180 @@
+package gql
+
+// This file implements the local executor, used when gql runs without a
+// bigslice cluster, i.e., Opts.BigsliceSession is unset. The builtins that
+// accept shards:=N behave the same with or without a cluster:
+//
+// - shards <= 0: the builtin runs sequentially in this process.
+//
+// - shards > 0: the builtin runs N tasks in parallel. Task i reads shard i of N
+// of the source table, i.e., Scanner(ctx, i, i+1, N). On a cluster, the tasks
+// run as bigslice workers. Without a cluster, they run as goroutines of this
+// process, at most Opts.LocalParallelism of them at a time. So shards is a
+// parallelism hint, and a script written for a cluster runs unchanged on a
+// laptop.
+//
+// Both executors write the result to the cache directory, so the result is
+// reused regardless of how it was computed.
+//
+// Unlike bigslice, the local executor doesn't spill to disk when grouping rows
+// for cogroup and reduce. It keeps all the groups in memory, and for cogroup,
+// all the rows of the groups. It logs a warning when the number of rows kept
+// exceeds maxLocalGroupRows.
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/grailbio/base/traverse"
+	"github.com/grailbio/gql/hash"
+)
+
+var (
+	// distributed is true if Opts.BigsliceSession is set. Set in Init.
+	distributed bool
+	// localParallelism is the max number of tasks the local executor runs at a
+	// time. Set in Init.
+	localParallelism = runtime.NumCPU()
+	// Source locations already reported by noteLocalExecution.
+	localExecutionNoted sync.Map
+	// maxLocalGroupRows is the number of rows kept in memory by groupLocally
+	// above which it logs a warning. Variable for unittests.
+	maxLocalGroupRows int64 = 10000000
+)
+
+// noteLocalExecution logs, once per call site, that the builtin "name"
+// invoked with shards>0 runs on the local machine because no bigslice cluster
+// is configured. It is a noop if shards <= 0 or a cluster is configured.
+func noteLocalExecution(ast ASTNode, name string, shards int) {
+	if distributed || shards <= 0 {
+		return
+	}
+	if _, loaded := localExecutionNoted.LoadOrStore(ast.pos().String()+":"+name, true); loaded {
+		return
+	}
+	Logf(ast, "%s: no bigslice cluster is configured; running %d shards locally, at most %d at a time",
+		name, shards, localParallelism)
+}
+
+// runLocalShards calls fn(shard) for each shard in [0, nshards), at most
+// localParallelism of them at a time. If fn panics, runLocalShards waits for
+// the running shards to finish, then panics with the first value. The progress
+// is recorded in the job of ctx, if any.
+func runLocalShards(ctx context.Context, ast ASTNode, nshards int, fn func(shard int)) {
+	var (
+		once     sync.Once
+		panicked interface{}
+		j        = jobFromContext(ctx)
+	)
+	traverse.Limit(localParallelism).Each(nshards, func(shard int) error { // nolint: errcheck
+		defer func() {
+			if e := recover(); e != nil {
+				j.shardFailed(shard, e)
+				once.Do(func() { panicked = e })
+			}
+		}()
+		Debugf(ast, "start local shard %d/%d", shard, nshards)
+		fn(shard)
+		j.shardDone(shard)
+		return nil
+	})
+	if panicked != nil {
+		panic(panicked)
+	}
+}
+
+// localGroup is a group of rows with the same key, computed by groupLocally.
+type localGroup struct {
+	key     Value
+	keyHash hash.Hash
+	values  []Value
+}
+
+// localGroups is a set of localGroups, in the order of their first appearance.
+type localGroups struct {
+	index  map[hash.Hash]int
+	groups []localGroup
+}
+
+// add adds the values to the group of the key. If reduce is non-nil, the
+// values are combined into one using reduce. It returns the number of values
+// newly stored in the group.
+func (g *localGroups) add(key Value, keyHash hash.Hash, values []Value, reduce func(acc, v Value) Value) int {
+	i, ok := g.index[keyHash]
+	if !ok {
+		i = len(g.groups)
+		g.index[keyHash] = i
+		g.groups = append(g.groups, localGroup{key: key, keyHash: keyHash})
+	}
+	group := &g.groups[i]
+	n := 0
+	for _, v := range values {
+		if reduce != nil && len(group.values) > 0 {
+			group.values[0] = reduce(group.values[0], v)
+			continue
+		}
+		group.values = append(group.values, v)
+		n++
+	}
+	return n
+}
+
+// groupLocally groups the rows of src by the value of keyExpr. It reads the
+// nshards shards of src in parallel. If mapExpr is non-nil, it is applied to
+// each row before grouping. If reduce is non-nil, the rows of each group are
+// combined into one using reduce; else each group contains all its rows, in the
+// order of the source table. The groups are sorted by key.
+//
+// All the groups are kept in memory, so the caller must not use it for a table
+// whose groups don't fit in memory. It logs a warning when the number of rows
+// kept exceeds maxLocalGroupRows.
+func groupLocally(ctx context.Context, ast ASTNode, src Table, nshards int, keyExpr, mapExpr *Func, reduce func(acc, v Value) Value) []localGroup {
+	var (
+		shards = make([]localGroups, nshards)
+		nRows  int64 // number of values stored in shards.
+		warn   sync.Once
+	)
+	runLocalShards(ctx, ast, nshards, func(shard int) {
+		g := localGroups{index: map[hash.Hash]int{}}
+		sc := src.Scanner(ctx, shard, shard+1, nshards)
+		for index := 0; sc.Scan(); index++ {
+			row := sc.Value()
+			key := evalRow(ctx, keyExpr, src, index, row)
+			if mapExpr != nil {
+				row = evalRow(ctx, mapExpr, src, index, row)
+			}
+			if n := g.add(key, key.Hash(), []Value{row}, reduce); n > 0 && atomic.AddInt64(&nRows, int64(n)) > maxLocalGroupRows {
+				warn.Do(func() {
+					Logf(ast, "warning: more than %d rows are grouped in memory; "+
+						"the process may run out of memory. Consider running on a bigslice cluster", maxLocalGroupRows)
+				})
+			}
+		}
+		shards[shard] = g
+	})
+	merged := localGroups{index: map[hash.Hash]int{}}
+	for _, g := range shards {
+		for _, group := range g.groups {
+			merged.add(group.key, group.keyHash, group.values, reduce)
+		}
+	}
+	sort.SliceStable(merged.groups, func(i, j int) bool {
+		return Compare(ast, merged.groups[i].key, merged.groups[j].key) < 0
+	})
+	return merged.groups
+}
+
+// writeLocalGroups writes the row created by fn for each group to a btsv file.
+// The groups for which fn returns false are skipped.
+func writeLocalGroups(ctx context.Context, path string, groups []localGroup, fn func(g localGroup) (Value, bool)) {
+	w := NewBTSVShardWriter(ctx, path, 0, 1, TableAttrs{})
+	for _, g := range groups {
+		if row, ok := fn(g); ok {
+			w.Append(row)
+		}
+	}
+	w.Close(ctx)
+}
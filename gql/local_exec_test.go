@@ -0,0 +1,42 @@
+package gql
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/testutil/expect"
+	"github.com/grailbio/testutil/h"
+)
+
+func TestLocalExecutor(t *testing.T) {
+	sess := newSession()
+	readRows := func(str string) []string {
+		return doReadTable(doEval(t, str, sess))
+	}
+	doEval(t, "t0 := range(0, 100) | map({k:_%7, v:_})", sess)
+	// Map preserves the row order regardless of sharding.
+	expect.EQ(t, readRows("t0 | map({$k, w:$v*2}, shards:=4)"), readRows("t0 | map({$k, w:$v*2})"))
+	expect.EQ(t, readRows("t0 | filter($v%3==0, shards:=5)"), readRows("t0 | filter($v%3==0)"))
+	// The groups of reduce and cogroup are sorted by key.
+	expect.EQ(t,
+		readRows("t0 | reduce($k, _acc+_val, map:=$v, shards:=3)"),
+		readRows("t0 | reduce($k, _acc+_val, map:=$v) | sort($key)"))
+	expect.EQ(t,
+		readRows("t0 | cogroup($k, shards:=3) | map({$key, n:count($value)})"),
+		readRows("t0 | reduce($k, _acc+_val, map:=1) | sort($key) | map({$key, n:$value})"))
+	expect.EQ(t, readRows("t0 | sort(-$v, shards:=3) | firstn(3)"), []string{"{k:1,v:99}", "{k:0,v:98}", "{k:6,v:97}"})
+
+	ctx := context.Background()
+	var n int32
+	runLocalShards(ctx, astUnknown, 10, func(shard int) { atomic.AddInt32(&n, 1) })
+	expect.EQ(t, n, int32(10))
+	expect.That(t, func() {
+		runLocalShards(ctx, astUnknown, 4, func(shard int) {
+			if shard == 2 {
+				log.Panicf("shard %d failed", shard)
+			}
+		})
+	}, h.Panics(h.Regexp("shard 2 failed")))
+}
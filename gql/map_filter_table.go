@@ -17,6 +17,9 @@ type mapFilterTable struct {
 
 	ast ASTNode // source-code location.
 	src Table   // The table to read from.
+	// prunedSrc, if nonnil, yields a subset of src that may satisfy filterExpr.
+	// It is scanned in lieu of src.
+	prunedSrc Table
 	// filter function, if nonnil, is invoked on each input row.
 	// Only the rows that evaluates true will be passed to the mapper.
 	filterExpr *Func
@@ -28,12 +31,22 @@ type mapFilterTable struct {
 	exactLen     int
 }
 
+// Parallelizable implements ParallelizableTable.
+func (t *mapFilterTable) Parallelizable(ctx context.Context) bool {
+	return IsParallelizable(ctx, t.src)
+}
+
 func (t *mapFilterTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	src := t.src
+	if t.prunedSrc != nil {
+		src = t.prunedSrc
+	}
 	sc := &mapFilterTableScanner{
 		ctx:         ctx,
 		parent:      t,
-		src:         t.src.Scanner(ctx, start, limit, total),
+		src:         src.Scanner(ctx, start, limit, total),
 		nextMapExpr: len(t.mapExprs),
+		tracker:     rowTrackerFromContext(ctx),
 	}
 	if t.filterExpr != nil {
 		sc.filterExpr = t.filterExpr
@@ -75,6 +88,7 @@ func unmarshalMapFilterTable(ctx UnmarshalContext, hash hash.Hash, dec *marshal.
 		src:  unmarshalTable(ctx, dec),
 	}
 	t.filterExpr = unmarshalFunc(ctx, dec)
+	t.prunedSrc = pruneTableForFilter(t.src, t.filterExpr)
 	n := int(dec.Varint())
 	t.mapExprs = make([]*Func, n)
 	for i := 0; i < n; i++ {
@@ -88,11 +102,49 @@ func (t *mapFilterTable) Len(ctx context.Context, mode CountMode) int {
 		return t.src.Len(ctx, Approx)
 	}
 	t.exactLenOnce.Do(func() {
-		t.exactLen = DefaultTableLen(ctx, t)
+		t.exactLen = mapFilterTableLen(ctx, t, t.src, t.filterExpr, t.mapExprs)
 	})
 	return t.exactLen
 }
 
+// mapFilterTableLen computes the exact number of rows in table t, which
+// implements map or filter of src. Without a filter, each source row yields
+// one row per map expression, so the count is derived from src.Len, which is
+// often cheap, e.g., for a btsv file.
+func mapFilterTableLen(ctx context.Context, t, src Table, filterExpr *Func, mapExprs []*Func) int {
+	if filterExpr != nil {
+		return DefaultTableLen(ctx, t)
+	}
+	n := len(mapExprs)
+	if n == 0 {
+		n = 1
+	}
+	return src.Len(ctx, Exact) * n
+}
+
+// mapFilterSrcLimit computes the number of source rows needed to produce n
+// rows of a map without a filter.
+func mapFilterSrcLimit(n int, mapExprs []*Func) int {
+	k := len(mapExprs)
+	if k == 0 {
+		k = 1
+	}
+	return (n + k - 1) / k
+}
+
+// Limit implements LimitableTable. Without a filter, the limit is pushed down
+// to the source table.
+func (t *mapFilterTable) Limit(ctx context.Context, n int) Table {
+	if t.filterExpr != nil {
+		return t
+	}
+	src := limitTable(ctx, t.src, mapFilterSrcLimit(n, t.mapExprs))
+	if src == t.src {
+		return t
+	}
+	return &mapFilterTable{ast: t.ast, src: src, mapExprs: t.mapExprs}
+}
+
 func (t *mapFilterTable) Prefetch(ctx context.Context) { t.src.Prefetch(ctx) }
 
 func (t *mapFilterTable) Hash() hash.Hash {
@@ -136,13 +188,30 @@ type mapFilterTableScanner struct {
 	nextMapExpr int
 	src         TableScanner
 	row         Value
+	// nRows is the number of rows read from src.
+	nRows int
+	// tracker, if nonnil, records the row being evaluated. It is set when
+	// the scanner runs as a shard of a parallel map.
+	tracker *rowTracker
+}
+
+// eval evaluates fn(row), where row is the last row read from src. A panic
+// raised by fn is annotated with the row, or recorded in the tracker.
+func (sc *mapFilterTableScanner) eval(fn *Func, row Value) Value {
+	if sc.tracker == nil {
+		return evalRow(sc.ctx, fn, sc.parent.src, sc.nRows-1, row)
+	}
+	sc.tracker.begin(fn, sc.parent.src, sc.nRows-1, row)
+	v := fn.Eval(sc.ctx, row)
+	sc.tracker.end()
+	return v
 }
 
 func (sc *mapFilterTableScanner) Value() Value { return sc.row }
 
 func (sc *mapFilterTableScanner) Scan() bool {
 	if sc.nextMapExpr < len(sc.mapExprs) {
-		sc.row = sc.mapExprs[sc.nextMapExpr].Eval(sc.ctx, sc.src.Value())
+		sc.row = sc.eval(sc.mapExprs[sc.nextMapExpr], sc.src.Value())
 		sc.nextMapExpr++
 		return true
 	}
@@ -150,14 +219,15 @@ func (sc *mapFilterTableScanner) Scan() bool {
 		if !sc.src.Scan() {
 			return false
 		}
+		sc.nRows++
 		row := sc.src.Value()
 		if sc.filterExpr != nil {
-			if !sc.filterExpr.Eval(sc.ctx, row).Bool(sc.filterExpr.ast) {
+			if !sc.eval(sc.filterExpr, row).Bool(sc.filterExpr.ast) {
 				continue
 			}
 		}
 		if len(sc.mapExprs) > 0 {
-			sc.row = sc.mapExprs[0].Eval(sc.ctx, row)
+			sc.row = sc.eval(sc.mapExprs[0], row)
 			sc.nextMapExpr = 1
 		} else {
 			sc.row = row
@@ -180,22 +250,25 @@ func NewMapFilterTable(
 	filterExpr *Func, /*maybe null, defaults to true */
 	mapExprs []*Func, /*maybe nil, defaults to an ID transformation */
 	nshards int /*<=0 for sequential execution*/) Value {
+	checkUnitMixes(ctx, ast, srcTable, filterExpr, mapExprs)
 	if nshards <= 0 {
 		t := &mapFilterTable{
 			ast:        ast,
 			src:        srcTable,
+			prunedSrc:  pruneTableForFilter(srcTable, filterExpr),
 			filterExpr: filterExpr,
 			mapExprs:   mapExprs,
 		}
 		return NewTable(t)
 	}
 
-	var tableBuf marshal.Encoder
-	mctx := newMarshalContext(ctx)
-
 	// TODO(saito) don't compute hash here. Do it in marshal...
 	hash := hashMapFilterTable(srcTable, filterExpr, mapExprs)
-	marshalMapFilterTable(mctx, &tableBuf, hash, ast, srcTable, filterExpr, mapExprs)
+	noteLocalExecution(ast, "map", nshards)
+	srcTable = shardableTable(ctx, ast, srcTable, nshards)
+	marshalledEnv, marshalledTable := marshalRemoteArgs(ctx, ast, func(mctx MarshalContext, enc *marshal.Encoder) {
+		marshalMapFilterTable(mctx, enc, hash, ast, srcTable, filterExpr, mapExprs)
+	})
 	t := &parallelMapFilterTable{
 		hash:            hash,
 		ast:             ast,
@@ -203,8 +276,16 @@ func NewMapFilterTable(
 		filterExpr:      filterExpr,
 		mapExprs:        mapExprs,
 		nshards:         nshards,
-		marshalledEnv:   mctx.marshal(),
-		marshalledTable: tableBuf.Bytes(),
+		marshalledEnv:   marshalledEnv,
+		marshalledTable: marshalledTable,
+		shardSrc: &mapFilterTable{
+			hash:       hash,
+			ast:        ast,
+			src:        srcTable,
+			prunedSrc:  pruneTableForFilter(srcTable, filterExpr),
+			filterExpr: filterExpr,
+			mapExprs:   mapExprs,
+		},
 	}
 	return NewTable(t)
 }
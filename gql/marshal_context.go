@@ -1,13 +1,64 @@
 package gql
 
+// This file implements the serialization of closures, and defines the
+// envelope of the data sent to bigslice workers and stored in btsv files.
+//
+// Wire format
+//
+// A marshaled context has the following form. Integers are varints unless
+// noted otherwise.
+//
+//   context := header frame* zerohash
+//   header  := "GQLM" version symhash
+//   frame   := framehash nvars (symbol value)*
+//
+// Version is marshalFormatVersion. Symhash is symbol.PreInternedHash(), a
+// 32-byte digest of the symbols interned during initialization; those symbols
+// are encoded as small integers, so their numbering must agree between the
+// sender and the receiver. Framehash and zerohash are 32-byte hashes.
+//
+// A value is a type byte (ValueType) followed by a type-specific payload:
+//
+//   NullType           1 for NA, 0xff for -NA
+//   BoolType           0 or 1
+//   IntType, CharType,
+//   DurationType       varint
+//   FloatType          IEEE754 bits, 8 bytes little endian
+//   DateType,
+//   DateTimeType       UnixNano (8 bytes little endian), zone offset, zone name
+//   StringType, FileNameType,
+//   EnumType           length, bytes
+//   StructType         nfields (symbol value)*
+//   TableType          magic (2 bytes), hash (32 bytes), then a payload
+//                      specific to the table type. See RegisterTableUnmarshaler.
+//   FuncType           0 (nil), 1 symbol (builtin), or 2 hash bindings
+//                      formalargs(gob) body(gob) (closure)
+//   MatrixType         nrows, row names, ncols, column names, then the cells
+//                      in row-major order (IEEE754 bits, 8 bytes each)
+//
+// A marshaled table or value is always accompanied by the marshaled context
+// that was used to encode it, and the context is decoded first, so the header
+// guards both. The decoder panics if the header is missing, or if the version
+// or the symbol digest differs from the running binary. Bump
+// marshalFormatVersion whenever the encoding of a value, a table, or a
+// closure changes incompatibly.
+
 import (
+	"bytes"
 	"context"
 
 	"github.com/grailbio/base/log"
 	"github.com/grailbio/gql/hash"
 	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
 )
 
+// marshalFormatVersion is the version of the wire format described above.
+const marshalFormatVersion = 1
+
+// marshalFormatMagic starts a marshaled context.
+var marshalFormatMagic = []byte("GQLM")
+
 // Types MarshalContext and UnmarshalContext store state needed while marshaling
 // or unmarshaling a table. They keep track of call frames referenced by
 // closures, so that reference cycles involving closure -> frame -> a closure
@@ -34,6 +85,24 @@ func newMarshalContext(ctx context.Context) MarshalContext {
 	return MarshalContext{ctx: ctx, frames: map[hash.Hash]*callFrame{}}
 }
 
+// marshalRemoteArgs marshals the args of a distributed computation started by
+// the expression ast. Callback cb should marshal the args into enc. It returns
+// the marshaled frames (to be passed to newUnmarshalContext) and the marshaled
+// args. If marshaling fails, e.g., because the args reference a table that
+// cannot be shipped to remote machines, it panics with an error that names ast.
+func marshalRemoteArgs(ctx context.Context, ast ASTNode, cb func(ctx MarshalContext, enc *marshal.Encoder)) (env, args []byte) {
+	defer func() {
+		if e := recover(); e != nil {
+			Panicf(ast, "cannot marshal the expression for distributed execution: %v", e)
+		}
+	}()
+	mctx := newMarshalContext(ctx)
+	enc := marshal.NewEncoder(nil)
+	cb(mctx, enc)
+	args = marshal.ReleaseEncoder(enc)
+	return mctx.marshal(), args
+}
+
 // PutFrame adds the given frame to the cache. It is used when transferring
 // frames across machine.
 func (ctx MarshalContext) putFrame(frame *callFrame) {
@@ -43,6 +112,25 @@ func (ctx MarshalContext) putFrame(frame *callFrame) {
 	}
 }
 
+// frameHashes returns the set of frames registered so far. It is used with
+// dropFramesExcept to undo a marshal call.
+func (ctx MarshalContext) frameHashes() map[hash.Hash]struct{} {
+	hashes := make(map[hash.Hash]struct{}, len(ctx.frames))
+	for h := range ctx.frames {
+		hashes[h] = struct{}{}
+	}
+	return hashes
+}
+
+// dropFramesExcept removes the frames that are not in the given set.
+func (ctx MarshalContext) dropFramesExcept(hashes map[hash.Hash]struct{}) {
+	for h := range ctx.frames {
+		if _, ok := hashes[h]; !ok {
+			delete(ctx.frames, h)
+		}
+	}
+}
+
 // marshalBindings should be called for every Closure. b is the variable
 // bindings, and fv should be the free variables used by the closure.
 //
@@ -63,6 +151,9 @@ func marshalBindings(ctx MarshalContext, enc *marshal.Encoder, b *bindings) {
 // to recreate the frames.
 func (ctx MarshalContext) marshal() []byte {
 	enc := marshal.NewEncoder(nil)
+	enc.PutRawBytes(marshalFormatMagic)
+	enc.PutVarint(marshalFormatVersion)
+	enc.PutHash(symbol.PreInternedHash())
 	n := 0
 	done := map[hash.Hash]bool{}
 	for {
@@ -96,10 +187,55 @@ func (ctx MarshalContext) marshal() []byte {
 	return marshal.ReleaseEncoder(enc)
 }
 
+// readMarshalHeader reads the header of a marshaled context. It returns false
+// if the data has no header, i.e., it was written by a binary that predates
+// format versioning. It panics if the version is not supported.
+func readMarshalHeader(dec *marshal.Decoder, data []byte) (symHash hash.Hash, ok bool) {
+	if !bytes.HasPrefix(data, marshalFormatMagic) {
+		return hash.Zero, false
+	}
+	dec.Skip(len(marshalFormatMagic))
+	if v := dec.Varint(); v != marshalFormatVersion {
+		log.Panicf("unmarshal: data is encoded in format version %d, but this binary supports only version %d. "+
+			"Make sure that all the machines run the same gql binary", v, marshalFormatVersion)
+	}
+	return dec.Hash(), true
+}
+
 // NewUnmarshalContext creates a new context. Arg "data" should be produced by
-// MarshalContext.marshal.
+// MarshalContext.marshal, by the same gql binary. It panics if the data was
+// produced by an incompatible binary.
 func newUnmarshalContext(data []byte) UnmarshalContext {
 	dec := marshal.NewDecoder(data)
+	symHash, ok := readMarshalHeader(dec, data)
+	if !ok {
+		log.Panicf("unmarshal: data has no format header; it may have been encoded by an old gql binary")
+	}
+	if symHash != symbol.PreInternedHash() {
+		log.Panicf("unmarshal: data was encoded by a gql binary with different builtin symbols. " +
+			"Make sure that all the machines run the same gql binary")
+	}
+	return unmarshalFrames(dec)
+}
+
+// newStoredUnmarshalContext is similar to newUnmarshalContext, but it is used
+// for contexts stored in files, so it also accepts data written by older
+// binaries. A context written by a binary with different builtin symbols is
+// accepted only if it contains no frames.
+func newStoredUnmarshalContext(data []byte) UnmarshalContext {
+	dec := marshal.NewDecoder(data)
+	if symHash, ok := readMarshalHeader(dec, data); ok && symHash != symbol.PreInternedHash() {
+		var h hash.Hash
+		if dec.Len() != len(h) {
+			log.Panicf("unmarshal: the file stores closures encoded by a gql binary with different builtin symbols")
+		}
+	}
+	return unmarshalFrames(dec)
+}
+
+// unmarshalFrames reads the frames of a marshaled context that follow the
+// header.
+func unmarshalFrames(dec *marshal.Decoder) UnmarshalContext {
 	ctx := UnmarshalContext{
 		ctx:    BackgroundContext,
 		frames: map[hash.Hash]*callFrame{},
@@ -133,6 +269,9 @@ func newUnmarshalContext(data []byte) UnmarshalContext {
 // UnmarshalBindings unserializes a binding.
 func unmarshalBindings(ctx UnmarshalContext, dec *marshal.Decoder) *bindings {
 	n := dec.Varint()
+	if n < 0 || n > int64(dec.Len()) {
+		log.Panicf("unmarshal bindings: corrupt frame count %d", n)
+	}
 	b := &bindings{
 		frames: make([]*callFrame, n+1),
 	}
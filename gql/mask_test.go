@@ -0,0 +1,45 @@
+package gql_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/grailbio/gql/gql"
+	"github.com/grailbio/gql/gqltest"
+	"github.com/grailbio/gql/symbol"
+	"github.com/grailbio/testutil/expect"
+)
+
+// maskedString returns the value that mask(how:="hash") produces for the given
+// string.
+func maskedString(s string) string {
+	mask := gql.MaskColumns([]string{"x"}, gql.MaskHash)
+	row := gql.NewStruct(gql.NewSimpleStruct(gql.StructField{Name: symbol.Intern("x"), Value: gql.NewString(s)}))
+	return mask(context.Background(), "", row).Struct(nil).Field(0).Value.Str(nil)
+}
+
+func TestMask(t *testing.T) {
+	env := gqltest.NewSession()
+	h0 := maskedString("ab0")
+	h1 := maskedString("ab1")
+	expect.NE(t, h0, h1)
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, "read(`testdata/file0.tsv`) | mask(&B)", env)),
+		[]string{"{A:10,B:" + h0 + ",C:cd0}", "{A:11,B:" + h1 + ",C:cd1}"})
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, "read(`testdata/file0.tsv`) | mask({&B, &C}, how:=\"null\")", env)),
+		[]string{"{A:10,B:NA,C:NA}", "{A:11,B:NA,C:NA}"})
+}
+
+func TestRowTransformers(t *testing.T) {
+	env := gqltest.NewSession()
+	defer gql.TestSetRowTransformers([]gql.RowTransformer{{
+		Name:      "redact",
+		PathRE:    regexp.MustCompile("file0"),
+		Transform: gql.MaskColumns([]string{"C"}, gql.MaskNull),
+	}})()
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, "read(`testdata/file0.tsv`)", env)),
+		[]string{"{A:10,B:ab0,C:NA}", "{A:11,B:ab1,C:NA}"})
+}
@@ -0,0 +1,171 @@
+package gql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"unsafe"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+	"github.com/grailbio/gql/symbol"
+)
+
+// Matrix is a dense matrix of float64s with named rows and columns. It is meant
+// for modest-size numeric data, such as a methylation beta matrix, that fits in
+// memory. A missing cell is stored as NaN. A Matrix is immutable once it is
+// wrapped in a Value.
+type Matrix struct {
+	// RowNames and ColNames are the names of the rows and the columns.
+	RowNames, ColNames []string
+	// Data stores the cells in row-major order.
+	// len(Data) = len(RowNames) * len(ColNames).
+	Data []float64
+
+	hash hash.Hash
+}
+
+// NRow returns the number of rows.
+func (m *Matrix) NRow() int { return len(m.RowNames) }
+
+// NCol returns the number of columns.
+func (m *Matrix) NCol() int { return len(m.ColNames) }
+
+// At returns the value of the given cell.
+func (m *Matrix) At(row, col int) float64 { return m.Data[row*len(m.ColNames)+col] }
+
+// NewMatrix creates a Value object from a matrix. The caller must not modify m
+// afterwards.
+func NewMatrix(m *Matrix) Value {
+	if len(m.Data) != len(m.RowNames)*len(m.ColNames) {
+		log.Panicf("NewMatrix: %d cells found for a %dx%d matrix", len(m.Data), len(m.RowNames), len(m.ColNames))
+	}
+	m.hash = hashMatrix(m)
+	return Value{typ: MatrixType, p: unsafe.Pointer(m)}
+}
+
+// Matrix extracts a matrix from the value. "ast" is used only to report source
+// code location on error.
+//
+// REQUIRES: v.Type() == MatrixType
+func (v Value) Matrix(ast ASTNode) *Matrix {
+	if v.typ != MatrixType {
+		v.wrongTypeError(ast, "matrix")
+	}
+	return (*Matrix)(v.p)
+}
+
+// Matrix retrieves the matrix from arg.Value. A shorthand for arg.Value.Matrix(arg.Expr).
+func (arg *ActualArg) Matrix() *Matrix { return arg.Value.Matrix(arg.Expr) }
+
+func hashMatrix(m *Matrix) hash.Hash {
+	h := hash.Hash{
+		0x3e, 0x71, 0x0c, 0x9a, 0x52, 0xd4, 0x8b, 0x17,
+		0xa6, 0x2f, 0xe0, 0x45, 0x93, 0x6c, 0x1d, 0xb8,
+		0x0f, 0x84, 0x5a, 0xc7, 0x39, 0xe2, 0x76, 0x0b,
+		0xd1, 0x68, 0x24, 0x9f, 0x4e, 0xb3, 0x85, 0x50}
+	h = h.Merge(hash.Int(int64(len(m.RowNames)))).Merge(hash.Int(int64(len(m.ColNames))))
+	for _, name := range m.RowNames {
+		h = h.Merge(hash.String(name))
+	}
+	for _, name := range m.ColNames {
+		h = h.Merge(hash.String(name))
+	}
+	buf := make([]byte, 8*len(m.Data))
+	for i, v := range m.Data {
+		if math.IsNaN(v) {
+			v = math.NaN() // Ignore the NaN payload.
+		}
+		binary.LittleEndian.PutUint64(buf[i*8:], math.Float64bits(v))
+	}
+	return h.Merge(hash.Bytes(buf))
+}
+
+func marshalMatrix(m *Matrix, enc *marshal.Encoder) {
+	enc.PutVarint(int64(len(m.RowNames)))
+	for _, name := range m.RowNames {
+		enc.PutString(name)
+	}
+	enc.PutVarint(int64(len(m.ColNames)))
+	for _, name := range m.ColNames {
+		enc.PutString(name)
+	}
+	for _, v := range m.Data {
+		enc.PutUint64(math.Float64bits(v))
+	}
+}
+
+func unmarshalMatrix(dec *marshal.Decoder) *Matrix {
+	readNames := func() []string {
+		n := int(dec.Varint())
+		if n < 0 || n > dec.Len() {
+			log.Panicf("unmarshalMatrix: corrupt dimension %d", n)
+		}
+		names := make([]string, n)
+		for i := range names {
+			names[i] = dec.String()
+		}
+		return names
+	}
+	m := &Matrix{RowNames: readNames(), ColNames: readNames()}
+	n := len(m.RowNames) * len(m.ColNames)
+	if n*8 > dec.Len() {
+		log.Panicf("unmarshalMatrix: %d cells expected, but only %d bytes remain", n, dec.Len())
+	}
+	m.Data = make([]float64, n)
+	for i := range m.Data {
+		m.Data[i] = math.Float64frombits(dec.Uint64())
+	}
+	m.hash = hashMatrix(m)
+	return m
+}
+
+// compareMatrix compares two matrices by their shapes, then by their cells in
+// row-major order.
+func compareMatrix(m0, m1 *Matrix) int {
+	if m0.hash == m1.hash {
+		return 0
+	}
+	if c := compareFloat(float64(m0.NRow()), float64(m1.NRow())); c != 0 {
+		return c
+	}
+	if c := compareFloat(float64(m0.NCol()), float64(m1.NCol())); c != 0 {
+		return c
+	}
+	for i := range m0.Data {
+		if c := compareFloat(m0.Data[i], m1.Data[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// matrixCellValue converts a matrix cell to a Value. NaN is converted to NA.
+func matrixCellValue(v float64) Value {
+	if math.IsNaN(v) {
+		return Null
+	}
+	return NewFloat(v)
+}
+
+// Table converts the matrix to a table. The table has one row per matrix row.
+// Each row has column "row", which stores the row name, followed by one
+// column per matrix column. NaN cells are converted to NA.
+func (m *Matrix) Table() Table {
+	cols := make([]symbol.ID, len(m.ColNames))
+	for i, name := range m.ColNames {
+		cols[i] = symbol.Intern(name)
+	}
+	rows := make([]Value, len(m.RowNames))
+	for r, name := range m.RowNames {
+		fields := make([]StructField, 0, len(cols)+1)
+		fields = append(fields, StructField{Name: symbol.Row, Value: NewString(name)})
+		for c, col := range cols {
+			fields = append(fields, StructField{Name: col, Value: matrixCellValue(m.At(r, c))})
+		}
+		rows[r] = NewStruct(NewSimpleStruct(fields...))
+	}
+	h := hash.String("matrix_table").Merge(m.hash)
+	return NewSimpleTable(rows, h, TableAttrs{Name: fmt.Sprintf("matrix(%dx%d)", m.NRow(), m.NCol())})
+}
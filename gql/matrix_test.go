@@ -0,0 +1,61 @@
+package gql_test
+
+import (
+	"testing"
+
+	"github.com/grailbio/gql/gqltest"
+	"github.com/grailbio/testutil/expect"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatrix(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `m := table(
+		{row:"r1", col:"c1", value:1},
+		{row:"r1", col:"c2", value:2.0},
+		{row:"r2", col:"c1", value:3},
+		{row:"r2", col:"c2", value:4}) | to_matrix()`, env)
+
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `m | matrix_table()`, env)),
+		[]string{"{row:r1,c1:1,c2:2}", "{row:r2,c1:3,c2:4}"})
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `m | mtranspose() | matrix_table()`, env)),
+		[]string{"{row:c1,r1:1,r2:3}", "{row:c2,r1:2,r2:4}"})
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `matmul(m, mtranspose(m)) | matrix_table()`, env)),
+		[]string{"{row:r1,r1:5,r2:11}", "{row:r2,r1:11,r2:25}"})
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `madd(mmul(m, 2), m) | matrix_table()`, env)),
+		[]string{"{row:r1,c1:3,c2:6}", "{row:r2,c1:9,c2:12}"})
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `msub(10, mdiv(m, 2.0)) | matrix_table()`, env)),
+		[]string{"{row:r1,c1:9.5,c2:9}", "{row:r2,c1:8.5,c2:8}"})
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `m | mrowagg("mean")`, env)),
+		[]string{"{row:r1,value:1.5}", "{row:r2,value:3.5}"})
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `m | mcolagg("sum")`, env)),
+		[]string{"{col:c1,value:4}", "{col:c2,value:6}"})
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `m | mscale(scale:=false) | matrix_table()`, env)),
+		[]string{"{row:r1,c1:-1,c2:-1}", "{row:r2,c1:1,c2:1}"})
+
+	// Missing cells are NA, and they are skipped by the aggregations.
+	gqltest.Eval(t, `m2 := table(
+		{row:"r1", col:"c1", value:1},
+		{row:"r2", col:"c2", value:4}) | to_matrix()`, env)
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `m2 | matrix_table()`, env)),
+		[]string{"{row:r1,c1:1,c2:NA}", "{row:r2,c1:NA,c2:4}"})
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `m2 | mcolagg("count")`, env)),
+		[]string{"{col:c1,value:1}", "{col:c2,value:1}"})
+
+	assert.True(t, gqltest.Eval(t, `m == mtranspose(mtranspose(m))`, env).Bool(nil))
+	assert.False(t, gqltest.Eval(t, `m == m2`, env).Bool(nil))
+	assert.Panics(t, func() {
+		gqltest.Eval(t, `matmul(m, table({row:"x", col:"y", value:1}) | to_matrix())`, env)
+	})
+	assert.Panics(t, func() { gqltest.Eval(t, `madd(m, m2 | mtranspose())`, env) })
+}
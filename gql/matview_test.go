@@ -0,0 +1,48 @@
+package gql
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grailbio/testutil"
+	"github.com/grailbio/testutil/expect"
+)
+
+func TestMatview(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	sess := TestNewSession()
+	defer func(d time.Duration) { matviewCheckInterval = d }(matviewCheckInterval)
+	matviewCheckInterval = 0
+
+	path := filepath.Join(tmpDir, "src.tsv")
+	expect.NoError(t, ioutil.WriteFile(path, []byte("a\n1\n2\n-1\n"), 0600))
+	statements, err := sess.Parse("(input)", []byte(
+		"matview v := read(`"+path+"`) | filter(&a > 0); v"))
+	expect.NoError(t, err)
+	v := sess.EvalStatements(ctx, statements).Table(nil)
+	expect.EQ(t, v.Len(ctx, Exact), 2)
+	h0 := v.Hash()
+	expect.EQ(t, v.Hash(), h0)
+
+	// Make sure that the modtime changes.
+	time.Sleep(10 * time.Millisecond)
+	expect.NoError(t, ioutil.WriteFile(path, []byte("a\n1\n2\n3\n-1\n"), 0600))
+	expect.EQ(t, v.Len(ctx, Exact), 3)
+	// The hash is derived from the contents of the view, so it changes on
+	// refresh.
+	expect.NE(t, v.Hash(), h0)
+	expect.EQ(t, v.Attrs(ctx).Name, "matview:v")
+
+	// The function form is equivalent.
+	statements, err = sess.Parse("(input)", []byte(
+		"matview(read(`"+path+"`) | filter(&a > 0))"))
+	expect.NoError(t, err)
+	v2 := sess.EvalStatements(ctx, statements).Table(nil)
+	expect.EQ(t, v2.Len(ctx, Exact), 3)
+	expect.EQ(t, v2.Hash(), v.Hash())
+}
@@ -36,7 +36,7 @@ var parallelMinNFunc = bigslice.Func(func(marshalledEnv []byte, tableHash hash.H
 	minn int64, nshards int) (slice bigslice.Slice) {
 	type shardState struct {
 		ch      chan string // Emits pathnames of the recordio containing sorted records.
-		sortKey *Func    // thread-local copy of the sortkey closure.
+		sortKey *Func       // thread-local copy of the sortkey closure.
 	}
 	ctx := newUnmarshalContext(marshalledEnv)
 	dec := marshal.NewDecoder(marshalledTable)
@@ -44,6 +44,9 @@ var parallelMinNFunc = bigslice.Func(func(marshalledEnv []byte, tableHash hash.H
 	dec.GOB(&ast)
 	table := unmarshalTable(ctx, dec)
 	sortKey := unmarshalFunc(ctx, dec)
+	order := NAOrder(dec.Varint())
+	byExpr := unmarshalFunc(ctx, dec)
+	keepTies := dec.Bool()
 	if l := dec.Len(); l > 0 {
 		Panicf(ast, "%d byte junk found in table", l)
 	}
@@ -60,7 +63,7 @@ var parallelMinNFunc = bigslice.Func(func(marshalledEnv []byte, tableHash hash.H
 					sortKey: sortKey,
 				}
 				go func() {
-					for _, path := range sortShard(ctx.ctx, astUnknown, tableHash, table, (*state).sortKey, minn, shard, nshards) {
+					for _, path := range sortShard(ctx.ctx, astUnknown, tableHash, table, (*state).sortKey, byExpr, order, minn, keepTies, shard, nshards) {
 						(*state).ch <- path
 					}
 					close((*state).ch)
@@ -84,10 +87,17 @@ type minnTable struct {
 	hash     hash.Hash // hash of inputs to the minntable.
 	ast      ASTNode   // source-code location
 	attrs    TableAttrs
-	srcTable Table    // table to read rows from.
-	sortKey  *Func // computes the sort key from each row.
-	minn     int64    // # of rows to retain.
-	shards   int      // If >0, do distributed mergesort using bigslice.
+	srcTable Table   // table to read rows from.
+	sortKey  *Func   // computes the sort key from each row.
+	naOrder  NAOrder // placement of NAs in the sort keys.
+	minn     int64   // # of rows to retain.
+	shards   int     // If >0, do distributed mergesort using bigslice.
+	// keepTies, if true, causes the rows whose sort keys equal that of the
+	// minn'th row to be retained too.
+	keepTies bool
+	// byExpr, if nonnil, computes the group of each row. minn rows are
+	// retained for each group.
+	byExpr *Func
 
 	marshalledEnv, marshalledTable []byte
 
@@ -102,16 +112,58 @@ type minnTable struct {
 type minnElem struct {
 	rec     Value // Record read from the source table.
 	sortKey Value // Created by invoking the sortkey callback.
+	// seq is the position of the row in the source table. It breaks ties
+	// between equal sort keys, so that the result is the same as a stable sort
+	// regardless of how the table is sharded.
+	seq int64
+}
+
+// minnSeq computes minnElem.seq for the given row in a source table shard.
+func minnSeq(shard, row int) int64 { return int64(shard)<<40 | int64(row) }
+
+// compareMinNElems compares two rows by their sort keys, then by their
+// positions in the source table.
+func compareMinNElems(ast ASTNode, key0 Value, seq0 int64, key1 Value, seq1 int64, order NAOrder) int {
+	if c := CompareNA(ast, key0, key1, order); c != 0 {
+		return c
+	}
+	switch {
+	case seq0 < seq1:
+		return -1
+	case seq0 > seq1:
+		return 1
+	}
+	return 0
+}
+
+// truncateMinN retains the first minn of the sorted rows. If keepTies is set,
+// the following rows whose sort keys equal the key of the minn'th row are also
+// retained.
+func truncateMinN(ast ASTNode, rows []minnElem, minn int64, keepTies bool, order NAOrder) []minnElem {
+	if int64(len(rows)) <= minn {
+		return rows
+	}
+	n := int(minn)
+	if keepTies && n > 0 {
+		for n < len(rows) && CompareNA(ast, rows[n].sortKey, rows[minn-1].sortKey, order) == 0 {
+			n++
+		}
+	}
+	return rows[:n]
 }
 
 // This function sorts the shard (out of nshards) of src table.  It invokes
-// sortExpr for each input row and uses the result to sort rows. In the end, it
+// sortExpr for each input row and uses the result to sort rows, placing NAs as
+// specified by order. If byExpr is set, the rows are sorted by the pair of
+// byExpr and sortExpr values. Ties are broken by the row positions. If
+// keepTies is set, the rows that tie with the minn'th row are retained. In the
+// end, it
 // creates >=1 btsv tables, each containing a sorted list of rows in the
 // shard. The union of rows in the btsv tables equals the rows in the srctable
 // shard.
 //
 // It returns a list of btsv files. Rows in each btsv file is sorted.
-func sortShard(ctx context.Context, ast ASTNode, hash hash.Hash, src Table, sortExpr *Func, minn int64, shard, nshards int) []string {
+func sortShard(ctx context.Context, ast ASTNode, hash hash.Hash, src Table, sortExpr, byExpr *Func, order NAOrder, minn int64, keepTies bool, shard, nshards int) []string {
 	var (
 		wg        sync.WaitGroup
 		mu        sync.Mutex // guards the next two variables.
@@ -122,14 +174,15 @@ func sortShard(ctx context.Context, ast ASTNode, hash hash.Hash, src Table, sort
 	Debugf(ast, "minn: start shard %d/%d", shard, nshards)
 	tmpID := int32(0)
 	saveRowsToTempFile := func(rows []minnElem) {
-		tmpPath := generateUniqueCachePath(
+		tmpPath := newTempPath(ctx,
 			fmt.Sprintf("%s-minn-tmp-%06d-%06d-%06d.btsv", hash, atomic.AddInt32(&tmpID, 1), shard, nshards))
 		Debugf(ast, "minn: shard %d/%d creating %s", shard, nshards, tmpPath)
 		w := NewBTSVShardWriter(ctx, tmpPath, 0, 1, TableAttrs{})
 		for _, row := range rows {
 			w.Append(NewStruct(NewSimpleStruct(
 				StructField{Name: symbol.Value, Value: row.rec},
-				StructField{Name: symbol.Key, Value: row.sortKey})))
+				StructField{Name: symbol.Key, Value: row.sortKey},
+				StructField{Name: symbol.Index, Value: NewInt(row.seq)})))
 		}
 		w.Close(ctx)
 		mu.Lock()
@@ -140,8 +193,8 @@ func sortShard(ctx context.Context, ast ASTNode, hash hash.Hash, src Table, sort
 	// Runs in a separate goroutine
 	flushTmpRows := func(tmpRows []minnElem) {
 		defer wg.Done()
-		sort.SliceStable(tmpRows, func(i, j int) bool {
-			return Compare(astUnknown /*TODO:fix*/, tmpRows[i].sortKey, tmpRows[j].sortKey) < 0
+		sort.Slice(tmpRows, func(i, j int) bool {
+			return compareMinNElems(astUnknown /*TODO:fix*/, tmpRows[i].sortKey, tmpRows[i].seq, tmpRows[j].sortKey, tmpRows[j].seq, order) < 0
 		})
 		if int64(len(tmpRows)) < minn {
 			// Likely a full sorting of the srctable is requested. Dump the tmprows to
@@ -151,15 +204,13 @@ func sortShard(ctx context.Context, ast ASTNode, hash hash.Hash, src Table, sort
 		}
 		// The minn param is smaller than the input table. Keep the minn elements in
 		// memory and read the rest of the srctable.
-		tmpRows = tmpRows[:minn]
+		tmpRows = truncateMinN(ast, tmpRows, minn, keepTies, order)
 		mu.Lock()
 		minRows = append(minRows, tmpRows...)
-		sort.SliceStable(minRows, func(i, j int) bool {
-			return Compare(ast, minRows[i].sortKey, minRows[j].sortKey) < 0
+		sort.Slice(minRows, func(i, j int) bool {
+			return compareMinNElems(ast, minRows[i].sortKey, minRows[i].seq, minRows[j].sortKey, minRows[j].seq, order) < 0
 		})
-		if int64(len(minRows)) > minn {
-			minRows = minRows[:minn]
-		}
+		minRows = truncateMinN(ast, minRows, minn, keepTies, order)
 		mu.Unlock()
 	}
 
@@ -167,10 +218,15 @@ func sortShard(ctx context.Context, ast ASTNode, hash hash.Hash, src Table, sort
 	nRows := 0
 	tmpRows := []minnElem{}
 	for sc.Scan() {
-		nRows++
 		rec := sc.Value()
-		sortKey := sortExpr.Eval(ctx, rec)
-		tmpRows = append(tmpRows, minnElem{rec, sortKey})
+		sortKey := evalRow(ctx, sortExpr, src, nRows, rec)
+		if byExpr != nil {
+			sortKey = NewStruct(NewSimpleStruct(
+				StructField{Name: symbol.Group, Value: evalRow(ctx, byExpr, src, nRows, rec)},
+				StructField{Name: symbol.Key, Value: sortKey}))
+		}
+		tmpRows = append(tmpRows, minnElem{rec, sortKey, minnSeq(shard, nRows)})
+		nRows++
 		if len(tmpRows) >= MinNMaxRowsPerShard {
 			Logf(ast, "shard %d/%d, %d rows read", shard, nshards, nRows)
 			wg.Add(1)
@@ -191,27 +247,31 @@ func sortShard(ctx context.Context, ast ASTNode, hash hash.Hash, src Table, sort
 }
 
 // minnInputQueue performs an N-way merge sort.
-type minnInputQueue []TableScanner
+type minnInputQueue struct {
+	scanners []TableScanner
+	order    NAOrder // placement of NAs in the sort keys.
+}
 
-func (q minnInputQueue) Len() int { return len(q) }
-func (q minnInputQueue) Less(i, j int) bool {
-	iv := q[i].Value().Struct(astUnknown).Field(1) // field 0 is rec, 1 is sortkey
-	jv := q[j].Value().Struct(astUnknown).Field(1)
-	c := Compare(nil, iv.Value, jv.Value)
+func (q *minnInputQueue) Len() int { return len(q.scanners) }
+func (q *minnInputQueue) Less(i, j int) bool {
+	// Field 0 is rec, 1 is sortkey, 2 is seq.
+	is := q.scanners[i].Value().Struct(astUnknown)
+	js := q.scanners[j].Value().Struct(astUnknown)
+	c := compareMinNElems(nil, is.Field(1).Value, is.Field(2).Value.Int(nil), js.Field(1).Value, js.Field(2).Value.Int(nil), q.order)
 	return c < 0
 }
 
-func (q minnInputQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *minnInputQueue) Swap(i, j int) { q.scanners[i], q.scanners[j] = q.scanners[j], q.scanners[i] }
 
 func (q *minnInputQueue) Push(x interface{}) {
-	*q = append(*q, x.(TableScanner))
+	q.scanners = append(q.scanners, x.(TableScanner))
 }
 
 func (q *minnInputQueue) Pop() interface{} {
-	old := *q
+	old := q.scanners
 	n := len(old)
 	x := old[n-1]
-	*q = old[0 : n-1]
+	q.scanners = old[0 : n-1]
 	return x
 }
 
@@ -250,47 +310,75 @@ func (t *minnTable) init(ctx context.Context) {
 			t.btsvTable = NewBTSVTable(btsvPath, t.ast, t.hash)
 			return
 		}
+		defer abandonCacheEntry(ctx, btsvPath)
 		var tmpPaths []string
-		if t.shards == 0 {
-			tmpPaths = t.initLocally(ctx)
+		if t.shards <= 0 {
+			n := t.srcTable.Len(ctx, Approx)/MinNMinRowsPerShard + 1
+			if n > MinNParallelism {
+				n = MinNParallelism
+			}
+			tmpPaths = t.initLocally(ctx, n)
 		} else {
-			tmpPaths = t.initWithBigSlice(ctx)
+			runJob(ctx, t.ast, "minn", t.shards, func(ctx context.Context) {
+				tmpPaths = t.initLocally(ctx, t.shards)
+			}, func() error {
+				tmpPaths = t.initWithBigSlice(ctx)
+				return nil
+			})
 		}
 		sort.Strings(tmpPaths) // make the output as deterministic.
-		pq := make(minnInputQueue, len(tmpPaths))
+		pq := minnInputQueue{scanners: make([]TableScanner, len(tmpPaths)), order: t.naOrder}
 		tmpTables := make([]Table, len(tmpPaths))
 		traverse.Parallel.Each(len(tmpPaths), func(i int) error { // nolint: errcheck
 			tmpTables[i] = NewBTSVTable(tmpPaths[i], t.ast, t.hash.Merge(hash.String(tmpPaths[i])))
-			pq[i] = tmpTables[i].Scanner(ctx, 0, 1, 1)
-			if !pq[i].Scan() {
-				pq[i] = nil
+			pq.scanners[i] = tmpTables[i].Scanner(ctx, 0, 1, 1)
+			if !pq.scanners[i].Scan() {
+				pq.scanners[i] = nil
 			}
 			return nil
 		})
 		// Remove the scanners that have already reached EOF.
 		j := 0
-		for i := range pq {
-			if pq[i] != nil {
-				pq[j] = pq[i]
+		for i := range pq.scanners {
+			if pq.scanners[i] != nil {
+				pq.scanners[j] = pq.scanners[i]
 				j++
 			}
 		}
-		pq = pq[:j]
+		pq.scanners = pq.scanners[:j]
 		heap.Init(&pq)
 
 		w := NewBTSVShardWriter(ctx, btsvPath, 0, 1, t.attrs)
-		nRowsRead := int64(0)
-		for nRowsRead < t.minn && len(pq) > 0 {
-			row := pq[0].Value().Struct(t.ast).Field(0).Value
-			w.Append(row)
+		var (
+			nRowsInGroup int64 // # of rows written for the current group.
+			group        Value // the current group. Valid only if byExpr!=nil.
+			lastKey      Value // the sort key of the last row written.
+		)
+		for pq.Len() > 0 {
+			st := pq.scanners[0].Value().Struct(t.ast)
+			row, key := st.Field(0).Value, st.Field(1).Value
+			if t.byExpr != nil {
+				ks := key.Struct(t.ast)
+				if nRowsInGroup > 0 && Compare(t.ast, ks.Field(0).Value, group) != 0 {
+					nRowsInGroup = 0
+				}
+				group, key = ks.Field(0).Value, ks.Field(1).Value
+			}
+			if nRowsInGroup < t.minn || (t.keepTies && nRowsInGroup > 0 && CompareNA(t.ast, key, lastKey, t.naOrder) == 0) {
+				w.Append(row)
+				nRowsInGroup++
+				lastKey = key
+			} else if t.byExpr == nil {
+				break
+			}
 			child := heap.Pop(&pq).(TableScanner)
 			if child.Scan() {
 				heap.Push(&pq, child)
 			}
-			nRowsRead++
 		}
 		w.Close(ctx)
 		ActivateCache(ctx, cacheName, btsvPath)
+		reportTableMaterialized(t.hash, btsvPath, w.nrows)
 		traverse.Each(len(tmpPaths), func(i int) error { // nolint:errcheck
 			if err := file.RemoveAll(ctx, tmpPaths[i]); err != nil {
 				Errorf(t.ast, "remove %s: %v", tmpPaths[i], err)
@@ -301,23 +389,19 @@ func (t *minnTable) init(ctx context.Context) {
 	})
 }
 
-// InitLocally creates a set of sorted btsv files. These files are merged during
-// scans. It is invoked when shards:=0.
-func (t *minnTable) initLocally(ctx context.Context) []string {
-	n := t.srcTable.Len(ctx, Approx)/MinNMinRowsPerShard + 1
-	if n > MinNParallelism {
-		n = MinNParallelism
-	}
+// InitLocally creates a set of sorted btsv files by sorting n shards of the
+// source in this process. These files are merged during scans. It is invoked
+// when shards:=0, or when shards>0 and no bigslice cluster is configured.
+func (t *minnTable) initLocally(ctx context.Context, n int) []string {
 	var (
 		mu       sync.Mutex
 		tmpPaths []string
 	)
-	traverse.Each(n, func(shard int) error { // nolint:errcheck
-		paths := sortShard(ctx, t.ast, t.hash, t.srcTable, t.sortKey, t.minn, shard, n)
+	runLocalShards(ctx, t.ast, n, func(shard int) {
+		paths := sortShard(ctx, t.ast, t.hash, t.srcTable, t.sortKey, t.byExpr, t.naOrder, t.sortMinN(), t.keepTies, shard, n)
 		mu.Lock()
 		tmpPaths = append(tmpPaths, paths...)
 		mu.Unlock()
-		return nil
 	})
 	return tmpPaths
 }
@@ -326,7 +410,7 @@ func (t *minnTable) initLocally(ctx context.Context) []string {
 // scans. It is invoked when shards>0.
 func (t *minnTable) initWithBigSlice(ctx context.Context) []string {
 	// Run bigslice.
-	result, err := bsSession.Run(ctx, parallelMinNFunc, t.marshalledEnv, t.hash, t.marshalledTable, t.minn, t.shards)
+	result, err := bsSession.Run(ctx, parallelMinNFunc, t.marshalledEnv, t.hash, t.marshalledTable, t.sortMinN(), t.shards)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -348,6 +432,15 @@ func (t *minnTable) initWithBigSlice(ctx context.Context) []string {
 	return tmpPaths
 }
 
+// sortMinN computes the number of rows to be retained by sortShard. With
+// byExpr, the groups are sorted in full, then truncated during the merge.
+func (t *minnTable) sortMinN() int64 {
+	if t.byExpr != nil {
+		return math.MaxInt64
+	}
+	return t.minn
+}
+
 // Scanner implements the TableScanner interface.
 func (t *minnTable) Scanner(ctx context.Context, start, limit, nshards int) TableScanner {
 	t.init(ctx)
@@ -357,7 +450,12 @@ func (t *minnTable) Scanner(ctx context.Context, start, limit, nshards int) Tabl
 // NewMinNTable creates a table that yields the smallest minn rows in
 // srcTable. If minn<0, it is treated as ∞. The row order is determined by
 // applying sortKey to each row, then comparing the results lexicographically.
-func NewMinNTable(ctx context.Context, ast ASTNode, attrs TableAttrs, srcTable Table, sortKey *Func, minn int64, shards int) Table {
+// Rows with equal keys appear in the order of srcTable. Arg order specifies
+// where the NAs in the sort keys are placed. If keepTies is set, the rows whose
+// keys equal that of the minn'th row are also yielded. If byExpr is nonnil, the
+// table yields the smallest minn rows for each distinct value of byExpr,
+// ordered by the byExpr value.
+func NewMinNTable(ctx context.Context, ast ASTNode, attrs TableAttrs, srcTable Table, sortKey *Func, order NAOrder, minn int64, shards int, keepTies bool, byExpr *Func) Table {
 	h := hash.Hash{
 		0x77, 0x27, 0x9e, 0x46, 0x7d, 0xc0, 0x27, 0x1c,
 		0x0f, 0x20, 0xff, 0x5d, 0xd7, 0x0d, 0x96, 0xb4,
@@ -367,6 +465,15 @@ func NewMinNTable(ctx context.Context, ast ASTNode, attrs TableAttrs, srcTable T
 	h = h.Merge(hash.Int(minn))
 	h = h.Merge(sortKey.Hash())
 	h = h.Merge(hash.Int(int64(shards)))
+	if order != NAOrderDefault {
+		h = h.Merge(hash.Int(int64(order)))
+	}
+	if keepTies {
+		h = h.Merge(hash.Bool(keepTies))
+	}
+	if byExpr != nil {
+		h = h.Merge(byExpr.Hash())
+	}
 	if minn < 0 {
 		minn = math.MaxInt64
 	}
@@ -374,13 +481,16 @@ func NewMinNTable(ctx context.Context, ast ASTNode, attrs TableAttrs, srcTable T
 	var marshalledEnv, marshalledTable []byte
 
 	if shards > 0 {
-		ctx := newMarshalContext(ctx)
-		enc := marshal.NewEncoder(nil)
-		enc.PutGOB(&ast)
-		srcTable.Marshal(ctx, enc)
-		sortKey.Marshal(ctx, enc)
-		marshalledTable = marshal.ReleaseEncoder(enc)
-		marshalledEnv = ctx.marshal()
+		noteLocalExecution(ast, "minn", shards)
+		srcTable = shardableTable(ctx, ast, srcTable, shards)
+		marshalledEnv, marshalledTable = marshalRemoteArgs(ctx, ast, func(mctx MarshalContext, enc *marshal.Encoder) {
+			enc.PutGOB(&ast)
+			srcTable.Marshal(mctx, enc)
+			sortKey.Marshal(mctx, enc)
+			enc.PutVarint(int64(order))
+			byExpr.Marshal(mctx, enc)
+			enc.PutBool(keepTies)
+		})
 	}
-	return &minnTable{hash: h, ast: ast, attrs: attrs, srcTable: srcTable, sortKey: sortKey, minn: minn, marshalledEnv: marshalledEnv, marshalledTable: marshalledTable, shards: shards}
+	return &minnTable{hash: h, ast: ast, attrs: attrs, srcTable: srcTable, sortKey: sortKey, naOrder: order, minn: minn, marshalledEnv: marshalledEnv, marshalledTable: marshalledTable, shards: shards, keepTies: keepTies, byExpr: byExpr}
 }
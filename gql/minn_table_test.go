@@ -5,6 +5,7 @@ import (
 
 	"github.com/grailbio/gql/symbol"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCompare(t *testing.T) {
@@ -26,3 +27,49 @@ func TestCompare(t *testing.T) {
 		assert.Equal(t, Compare(nil, v1, v1), 0)
 	}
 }
+
+func TestCompareNA(t *testing.T) {
+	one, na, negNA := NewInt(1), NewNull(PosNull), NewNull(NegNull)
+	assert.Equal(t, -1, CompareNA(nil, one, na, NAOrderDefault))
+	assert.Equal(t, 1, CompareNA(nil, one, negNA, NAOrderDefault))
+	assert.Equal(t, 1, CompareNA(nil, one, na, NAOrderFirst))
+	assert.Equal(t, 1, CompareNA(nil, one, negNA, NAOrderFirst))
+	assert.Equal(t, -1, CompareNA(nil, one, na, NAOrderLast))
+	assert.Equal(t, 1, CompareNA(nil, negNA, one, NAOrderLast))
+	assert.Equal(t, 0, CompareNA(nil, na, negNA, NAOrderLast))
+
+	s0 := NewStruct(NewSimpleStruct(StructField{Name: symbol.Intern("a"), Value: na}))
+	s1 := NewStruct(NewSimpleStruct(StructField{Name: symbol.Intern("a"), Value: one}))
+	assert.Equal(t, 1, CompareNA(nil, s0, s1, NAOrderDefault))
+	assert.Equal(t, -1, CompareNA(nil, s0, s1, NAOrderFirst))
+
+	order, err := ParseNAOrder("first")
+	require.NoError(t, err)
+	assert.Equal(t, NAOrderFirst, order)
+	_, err = ParseNAOrder("middle")
+	assert.Error(t, err)
+}
+
+func TestSessionNAOrder(t *testing.T) {
+	sess := newSession()
+	old := naOrder
+	defer func() { naOrder = old }()
+
+	doEval(t, "naOrderT0 := table({k:2}, {k:NA}, {k:1})", sess)
+	naOrder = NAOrderDefault
+	assert.Equal(t, NegNull, doEval(t, "(naOrderT0 | sort(-&k) | pick(true)).k", sess).Null())
+	assert.Equal(t, PosNull, doEval(t, "max(1, NA)", sess).Null())
+	assert.Equal(t, int64(1), doEval(t, "min(1, NA)", sess).Int(nil))
+
+	naOrder = NAOrderLast
+	assert.Equal(t, int64(2), doEval(t, "(naOrderT0 | sort(-&k) | pick(true)).k", sess).Int(nil))
+	// An explicit na:= overrides the session default.
+	assert.Equal(t, NegNull, doEval(t, `(naOrderT0 | sort(-&k, na:="default") | pick(true)).k`, sess).Null())
+
+	naOrder = NAOrderFirst
+	assert.Equal(t, PosNull, doEval(t, "(naOrderT0 | sort(&k) | pick(true)).k", sess).Null())
+	assert.Equal(t, int64(1), doEval(t, "max(1, NA)", sess).Int(nil))
+	assert.Equal(t, PosNull, doEval(t, "min(1, NA)", sess).Null())
+	// The merge join sorts the inputs and compares the keys in the same order.
+	assert.Equal(t, int64(3), doEval(t, "count(join({a:naOrderT0, b:naOrderT0}, a.k==b.k))", sess).Int(nil))
+}
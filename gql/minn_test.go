@@ -54,6 +54,27 @@ func TestParallelMinNSmall(t *testing.T) { testMinNSmall(t, 1) }
 func TestMinNNested(t *testing.T)         { testMinNNested(t, 0) }
 func TestParallelMinNNested(t *testing.T) { testMinNNested(t, 1) }
 
+func testMinNNAOrder(t *testing.T, shards int) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, "t0 := table({k:2}, {k:NA}, {k:1}, {k:3})", env)
+	sortTable := func(key, na string) []string {
+		return gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("t0 | sort(%s, na:=%q, shards:=%d)", key, na, shards), env))
+	}
+	// By default, NA is the largest value, and -NA the smallest.
+	assert.Equal(t, []string{"{k:1}", "{k:2}", "{k:3}", "{k:NA}"}, sortTable("&k", ""))
+	assert.Equal(t, []string{"{k:NA}", "{k:3}", "{k:2}", "{k:1}"}, sortTable("-&k", ""))
+	assert.Equal(t, []string{"{k:1}", "{k:2}", "{k:3}", "{k:NA}"}, sortTable("&k", "last"))
+	assert.Equal(t, []string{"{k:3}", "{k:2}", "{k:1}", "{k:NA}"}, sortTable("-&k", "last"))
+	assert.Equal(t, []string{"{k:NA}", "{k:1}", "{k:2}", "{k:3}"}, sortTable("&k", "first"))
+	assert.Equal(t, []string{"{k:NA}", "{k:3}", "{k:2}", "{k:1}"}, sortTable("-&k", "first"))
+	assert.Equal(t, []string{"{k:NA}", "{k:1}"},
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf(`t0 | minn(2, {&k}, na:="first", shards:=%d)`, shards), env)))
+	assert.Panics(t, func() { sortTable("&k", "middle") })
+}
+
+func TestMinNNAOrder(t *testing.T)         { testMinNNAOrder(t, 0) }
+func TestParallelMinNNAOrder(t *testing.T) { testMinNNAOrder(t, 1) }
+
 func testMinNLarge(t *testing.T, shards int) {
 	t.Parallel()
 	tmpDir, cleanup := testutil.TempDir(t, "", "")
@@ -96,3 +117,40 @@ func testMinNLarge(t *testing.T, shards int) {
 
 func TestMinNLarge(t *testing.T)         { testMinNLarge(t, 0) }
 func TestParallelMinNLarge(t *testing.T) { testMinNLarge(t, 2) }
+
+func testMinNTies(t *testing.T, shards int) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, "t0 := table({k:2, v:0}, {k:1, v:1}, {k:2, v:2}, {k:1, v:3}, {k:2, v:4}, {k:3, v:5})", env)
+	minn := func(args string) []string {
+		return gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("t0 | minn(%s, shards:=%d)", args, shards), env))
+	}
+	// Ties are broken by the row order in the source table.
+	assert.Equal(t, []string{"{k:1,v:1}", "{k:1,v:3}", "{k:2,v:0}"}, minn(`3, &k`))
+	assert.Equal(t, []string{"{k:1,v:1}", "{k:1,v:3}", "{k:2,v:0}"}, minn(`3, &k, ties:="first"`))
+	assert.Equal(t, []string{"{k:1,v:1}", "{k:1,v:3}", "{k:2,v:0}", "{k:2,v:2}", "{k:2,v:4}"}, minn(`3, &k, ties:="all"`))
+	assert.Equal(t, []string{"{k:3,v:5}"}, minn(`1, -&k, ties:="all"`))
+	// minn(n, key) is the same as sort(key) | firstn(n).
+	assert.Equal(t,
+		gqltest.ReadTable(gqltest.Eval(t, fmt.Sprintf("t0 | sort(&k, shards:=%d) | firstn(4)", shards), env)),
+		minn(`4, &k`))
+	assert.Panics(t, func() { minn(`3, &k, ties:="some"`) })
+}
+
+func testMinNBy(t *testing.T, shards int) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, "t0 := table({g:\"b\", v:3}, {g:\"a\", v:5}, {g:\"b\", v:1}, {g:\"a\", v:2}, {g:\"b\", v:2}, {g:\"a\", v:9})", env)
+	table := gqltest.Eval(t, fmt.Sprintf("t0 | minn(2, &v, by:=&g, shards:=%d)", shards), env)
+	assert.Equal(t,
+		[]string{"{g:a,v:2}", "{g:a,v:5}", "{g:b,v:1}", "{g:b,v:2}"},
+		gqltest.ReadTable(table))
+	table = gqltest.Eval(t, fmt.Sprintf("t0 | minn(1, -&v, by:=&g, shards:=%d)", shards), env)
+	assert.Equal(t,
+		[]string{"{g:a,v:9}", "{g:b,v:3}"},
+		gqltest.ReadTable(table))
+}
+
+func TestMinNTies(t *testing.T)         { testMinNTies(t, 0) }
+func TestParallelMinNTies(t *testing.T) { testMinNTies(t, 1) }
+
+func TestMinNBy(t *testing.T)         { testMinNBy(t, 0) }
+func TestParallelMinNBy(t *testing.T) { testMinNBy(t, 1) }
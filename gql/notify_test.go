@@ -0,0 +1,80 @@
+package gql_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grailbio/gql/gql"
+	"github.com/grailbio/gql/gqltest"
+	"github.com/grailbio/testutil/expect"
+)
+
+type fakeNotifier struct {
+	sent []gql.Notification
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, msg gql.Notification) error {
+	n.sent = append(n.sent, msg)
+	return nil
+}
+
+func TestNotify(t *testing.T) {
+	env := gqltest.NewSession()
+	fake := &fakeNotifier{}
+	defer gql.TestSetNotifiers(map[string]gql.Notifier{"fake": fake})()
+
+	expect.False(t, gqltest.Eval(t, `notify(message:="hello", channel:="nosuchchannel")`, env).Bool(nil))
+	expect.True(t, gqltest.Eval(t,
+		`notify(message:="done", channel:="fake", target:="#pipeline", attach:=table({a:1, b:"x"}, {a:2, b:"y"}))`, env).Bool(nil))
+	expect.EQ(t, len(fake.sent), 1)
+	expect.EQ(t, fake.sent[0].Message, "done")
+	expect.EQ(t, fake.sent[0].Target, "#pipeline")
+	expect.EQ(t, string(fake.sent[0].Attachment), "a\tb\n1\tx\n2\ty\n")
+}
+
+func TestSlackNotifier(t *testing.T) {
+	var payload map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expect.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+	}))
+	defer server.Close()
+	n := &gql.SlackNotifier{WebhookURL: server.URL}
+	expect.NoError(t, n.Notify(context.Background(), gql.Notification{
+		Target:         "#pipeline",
+		Message:        "done",
+		AttachmentName: "qc.tsv",
+		Attachment:     []byte("a\n1\n"),
+	}))
+	expect.EQ(t, payload["channel"], "#pipeline")
+	expect.EQ(t, payload["text"], "done\n*qc.tsv*\n```a\n1\n```")
+}
+
+func TestEmailNotifierContext(t *testing.T) {
+	// The server accepts connections but never responds.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	expect.NoError(t, err)
+	defer l.Close() // nolint: errcheck
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+		}
+		for _, conn := range conns {
+			conn.Close() // nolint: errcheck
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	n := &gql.EmailNotifier{Addr: l.Addr().String(), From: "gql@example.com"}
+	err = n.Notify(ctx, gql.Notification{Target: "a@example.com", Message: "done"})
+	expect.EQ(t, err, context.DeadlineExceeded)
+}
@@ -22,32 +22,31 @@ var parallelMapFunc = bigslice.Func(func(
 	ctx := newUnmarshalContext(marshaledEnv)
 	ast := astUnknown // TODO(saito) compute proper position.
 	slice = bigslice.ReaderFunc(nshards,
-		func(shard int, scanner *TableScanner, rows []Value) (n int, err error) {
-			if *scanner == nil {
+		func(shard int, state *parallelMapShard, rows []Value) (n int, err error) {
+			if state.scanner == nil {
 				table := unmarshalTable(ctx, marshal.NewDecoder(marshaledTable))
 				Logf(ast, "start shard %d/%d", shard, nshards)
-				*scanner = table.Scanner(ctx.ctx, shard, shard+1, nshards)
+				var shardCtx context.Context
+				shardCtx, state.tracker = withRowTracker(ctx.ctx)
+				state.scanner = table.Scanner(shardCtx, shard, shard+1, nshards)
 			}
-			for i := range rows {
-				if !(*scanner).Scan() {
-					return i, sliceio.EOF
+			eof := false
+			if rowErr := catchRowError(ctx.ctx, state.tracker, shard, nshards, func() {
+				for ; n < len(rows); n++ {
+					if !state.scanner.Scan() {
+						eof = true
+						return
+					}
+					rows[n] = materializeMapOutput(ctx.ctx, ast, state.scanner.Value())
 				}
-				v := (*scanner).Value()
-				if v.Type() == TableType {
-					// If the value is a subtable, force it to the storage. This improves
-					// performance in a common usage where parallel map is given a list of
-					// filenames, and the map function reads each file and runs an
-					// expersive computation.  materializeTable causes the file-reading to
-					// run in parallel. Without materializeTable, the reading will be
-					// delayed until the downstream expression starts reading the result
-					// of the parallel map. If the downstream reader runs serially, the
-					// file reading will also happen serially, which isn't what users
-					// want.
-					v = NewTable(materializeTable(ctx.ctx, v.Table(ast), nil))
-				}
-				rows[i] = v
+			}); rowErr != nil {
+				// Report the row instead of the worker's stack trace.
+				return n, rowErr.remoteError()
+			}
+			if eof {
+				return n, sliceio.EOF
 			}
-			return len(rows), nil
+			return n, nil
 		},
 	)
 	slice = bigslice.Scan(slice, func(shard int, scan *sliceio.Scanner) error {
@@ -69,6 +68,29 @@ var parallelMapFunc = bigslice.Func(func(
 	return
 })
 
+// parallelMapShard is the state of a shard of parallelMapFunc.
+type parallelMapShard struct {
+	scanner TableScanner
+	tracker *rowTracker
+}
+
+// materializeMapOutput is applied to each row yielded by a parallel map.
+func materializeMapOutput(ctx context.Context, ast ASTNode, v Value) Value {
+	if v.Type() == TableType && !isMaterialized(v.Table(ast)) {
+		// If the value is a subtable, force it to the storage. This improves
+		// performance in a common usage where parallel map is given a list of
+		// filenames, and the map function reads each file and runs an
+		// expersive computation.  materializeTable causes the file-reading to
+		// run in parallel. Without materializeTable, the reading will be
+		// delayed until the downstream expression starts reading the result
+		// of the parallel map. If the downstream reader runs serially, the
+		// file reading will also happen serially, which isn't what users
+		// want.
+		v = NewTable(materializeTable(ctx, v.Table(ast), nil))
+	}
+	return v
+}
+
 // mapFilterTable implements a table that does filter, then map.
 type parallelMapFilterTable struct {
 	hash hash.Hash
@@ -84,8 +106,15 @@ type parallelMapFilterTable struct {
 	// # of bigslice shards to run.
 	nshards int
 	once    sync.Once
+	// limit, if positive, is the max number of rows yielded by each shard. Cf.
+	// Limit.
+	limit int
 
 	marshalledEnv, marshalledTable []byte
+	// shardSrc is the table marshalled in marshalledTable. Shard i of shardSrc
+	// yields the rows of shard i of this table. It is used by the local
+	// executor.
+	shardSrc Table
 
 	btsvTable Table
 
@@ -100,29 +129,102 @@ func (t *parallelMapFilterTable) init(ctx context.Context) {
 		if found {
 			Logf(t.ast, "cache hit: %s", btsvPath)
 		} else {
+			defer abandonCacheEntry(ctx, btsvPath)
 			Logf(t.ast, "start parallel mapreduce, shards=%d", t.nshards)
-			if _, err := bsSession.Run(ctx, parallelMapFunc, t.marshalledEnv, t.hash, btsvPath, t.marshalledTable, t.nshards); err != nil {
-				log.Panic(err)
-			}
+			runJob(ctx, t.ast, "map", t.nshards, func(ctx context.Context) {
+				t.runLocally(ctx, btsvPath)
+			}, func() error {
+				_, err := bsSession.Run(ctx, parallelMapFunc, t.marshalledEnv, t.hash, btsvPath, t.marshalledTable, t.nshards)
+				return err
+			})
 			ActivateCache(ctx, cacheName, btsvPath)
+			reportTableMaterialized(t.hash, btsvPath, -1)
 		}
 		t.btsvTable = NewBTSVTable(btsvPath, t.ast, t.hash)
 	})
 }
 
+// runLocally computes the table using the local executor. Like
+// parallelMapFunc, shard i of the output is computed from shard i of the
+// source.
+func (t *parallelMapFilterTable) runLocally(ctx context.Context, btsvPath string) {
+	runLocalShards(ctx, t.ast, t.nshards, func(shard int) {
+		w := NewBTSVShardWriter(ctx, btsvPath, shard, t.nshards, TableAttrs{})
+		shardCtx, tracker := withRowTracker(ctx)
+		sc := t.shardSrc.Scanner(shardCtx, shard, shard+1, t.nshards)
+		if rowErr := catchRowError(ctx, tracker, shard, t.nshards, func() {
+			for sc.Scan() {
+				w.Append(materializeMapOutput(ctx, t.ast, sc.Value()))
+			}
+		}); rowErr != nil {
+			panic(rowErr)
+		}
+		w.Close(ctx)
+	})
+}
+
 // Len implements Table interface
 func (t *parallelMapFilterTable) Len(ctx context.Context, mode CountMode) int {
 	if mode == Approx {
 		return t.src.Len(ctx, Approx)
 	}
 	t.exactLenOnce.Do(func() {
-		t.exactLen = DefaultTableLen(ctx, t)
+		if t.limit > 0 {
+			t.exactLen = DefaultTableLen(ctx, t)
+			return
+		}
+		t.exactLen = mapFilterTableLen(ctx, t, t.src, t.filterExpr, t.mapExprs)
 	})
 	return t.exactLen
 }
 
+// Limit implements LimitableTable. Unless the whole table is already
+// computed, it returns a table computed by a separate bigslice job, in which
+// each shard stops reading its input after yielding n rows. The first n rows
+// of the concatenation of the shards are the first n rows of this table.
+func (t *parallelMapFilterTable) Limit(ctx context.Context, n int) Table {
+	if t.limit > 0 && t.limit <= n {
+		return t
+	}
+	if cacheEntryExists(ctx, t.hash.String()+".btsv") {
+		return t
+	}
+	src := t.src
+	if t.filterExpr == nil {
+		src = limitTable(ctx, src, mapFilterSrcLimit(n, t.mapExprs))
+	}
+	h := t.hash.Merge(hash.String("limit")).Merge(hash.Int(int64(n)))
+	limited := &shardLimitTable{
+		hash: h,
+		ast:  t.ast,
+		src:  &mapFilterTable{hash: h, ast: t.ast, src: src, filterExpr: t.filterExpr, mapExprs: t.mapExprs},
+		n:    n,
+	}
+	marshalledEnv, marshalledTable := marshalRemoteArgs(ctx, t.ast, func(mctx MarshalContext, enc *marshal.Encoder) {
+		limited.Marshal(mctx, enc)
+	})
+	return &parallelMapFilterTable{
+		hash:            h,
+		ast:             t.ast,
+		src:             src,
+		filterExpr:      t.filterExpr,
+		mapExprs:        t.mapExprs,
+		nshards:         t.nshards,
+		limit:           n,
+		marshalledEnv:   marshalledEnv,
+		marshalledTable: marshalledTable,
+		shardSrc:        limited,
+	}
+}
+
 // Marshal implements Table interface
 func (t *parallelMapFilterTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	if t.limit > 0 {
+		// The rows depend on the sharding, so the remote side can't recompute
+		// them.
+		MarshalTableOutline(ctx, enc, t)
+		return
+	}
 	marshalMapFilterTable(ctx, enc, t.hash, t.ast, t.src, t.filterExpr, t.mapExprs)
 }
 
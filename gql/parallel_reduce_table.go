@@ -25,7 +25,7 @@ var parallelReduceFunc = bigslice.Func(func(
 	ast := rtable.ast
 	srcTable := rtable.src
 	keyExpr := rtable.keyExpr
-	reduceExpr := rtable.reduceExpr
+	r := rtable.reducer
 	mapExpr := rtable.mapExpr
 
 	type shardState struct {
@@ -58,9 +58,10 @@ var parallelReduceFunc = bigslice.Func(func(
 					Logf(ast, "read %+v: shard %d/%d: %d rows", srcTable.Attrs(ctx.ctx), shard, nshards, (*scanner).nrows)
 				}
 				row := (*scanner).scanner.Value()
-				key := (*scanner).keyExpr.Eval(ctx.ctx, row)
+				index := (*scanner).nrows - 1
+				key := evalRow(ctx.ctx, (*scanner).keyExpr, srcTable, index, row)
 				if (*scanner).mapExpr != nil {
-					row = (*scanner).mapExpr.Eval(ctx.ctx, row)
+					row = evalRow(ctx.ctx, (*scanner).mapExpr, srcTable, index, row)
 				}
 				rows[i] = row
 				keys[i] = key
@@ -71,7 +72,7 @@ var parallelReduceFunc = bigslice.Func(func(
 		if !acc.Valid() || !m.Valid() {
 			Panicf(ast, "null %v %v", acc, m)
 		}
-		val := reduceExpr.Eval(ctx.ctx, acc, m)
+		val := r.Combine(ctx.ctx, acc, m)
 		return val
 	})
 	slice = bigslice.Scan(slice, func(shard int, scan *sliceio.Scanner) error {
@@ -82,7 +83,7 @@ var parallelReduceFunc = bigslice.Func(func(
 		for scan.Scan(ctx.ctx, &key, &acc) {
 			row := NewStruct(NewSimpleStruct(
 				StructField{Name: symbol.Key, Value: key},
-				StructField{Name: symbol.Value, Value: acc}))
+				StructField{Name: symbol.Value, Value: r.Finish(acc)}))
 			w.Append(row)
 			nrows++
 		}
@@ -103,9 +104,9 @@ type parallelReduceTable struct {
 	// The table to read from.
 	src Table
 	// Bindings for keyExpr and redcueExpr
-	keyExpr    *Func
-	reduceExpr *Func
-	mapExpr    *Func
+	keyExpr *Func
+	reducer *reducer
+	mapExpr *Func
 
 	// # of bigslice shards to run.
 	nshards int
@@ -121,13 +122,13 @@ type parallelReduceTable struct {
 
 var parallelReduceMagic = UnmarshalMagic{0xff, 0x33}
 
-func marshalParallelReduceTable(ctx MarshalContext, enc *marshal.Encoder, hash hash.Hash, ast ASTNode, src Table, keyExpr, reduceExpr, mapExpr *Func) {
+func marshalParallelReduceTable(ctx MarshalContext, enc *marshal.Encoder, hash hash.Hash, ast ASTNode, src Table, keyExpr *Func, r *reducer, mapExpr *Func) {
 	enc.PutRawBytes(parallelReduceMagic[:])
 	enc.PutHash(hash)
 	enc.PutGOB(&ast)
 	src.Marshal(ctx, enc)
 	keyExpr.Marshal(ctx, enc)
-	reduceExpr.Marshal(ctx, enc)
+	r.marshal(ctx, enc)
 	mapExpr.Marshal(ctx, enc)
 }
 
@@ -138,17 +139,35 @@ func (t *parallelReduceTable) init(ctx context.Context) {
 		if found {
 			Logf(t.ast, "cache hit: %s", btsvPath)
 		} else {
+			defer abandonCacheEntry(ctx, btsvPath)
 			Logf(t.ast, "start bigslice for table %v", t.hash)
-			if _, err := bsSession.Run(ctx, parallelReduceFunc, t.marshalledEnv, t.hash, btsvPath, t.marshalledTable, t.nshards); err != nil {
-				log.Panic(err)
-			}
+			runJob(ctx, t.ast, "reduce", t.nshards, func(ctx context.Context) {
+				t.runLocally(ctx, btsvPath)
+			}, func() error {
+				_, err := bsSession.Run(ctx, parallelReduceFunc, t.marshalledEnv, t.hash, btsvPath, t.marshalledTable, t.nshards)
+				return err
+			})
 			ActivateCache(ctx, cacheName, btsvPath)
+			reportTableMaterialized(t.hash, btsvPath, -1)
 			Logf(t.ast, "finished bigslice for table %v", t.hash)
 		}
 		t.btsvTable = NewBTSVTable(btsvPath, t.ast, t.hash)
 	})
 }
 
+// runLocally computes the table using the local executor. Each shard reduces
+// its rows, then the per-shard results are reduced in the shard order.
+func (t *parallelReduceTable) runLocally(ctx context.Context, btsvPath string) {
+	groups := groupLocally(ctx, t.ast, t.src, t.nshards, t.keyExpr, t.mapExpr, func(acc, v Value) Value {
+		return t.reducer.Combine(ctx, acc, v)
+	})
+	writeLocalGroups(ctx, btsvPath, groups, func(g localGroup) (Value, bool) {
+		return NewStruct(NewSimpleStruct(
+			StructField{Name: symbol.Key, Value: g.key},
+			StructField{Name: symbol.Value, Value: t.reducer.Finish(g.values[0])})), true
+	})
+}
+
 func (t *parallelReduceTable) Len(ctx context.Context, mode CountMode) int {
 	if mode == Approx {
 		return 100000
@@ -158,7 +177,7 @@ func (t *parallelReduceTable) Len(ctx context.Context, mode CountMode) int {
 }
 
 func (t *parallelReduceTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
-	marshalParallelReduceTable(ctx, enc, t.hash, t.ast, t.src, t.keyExpr, t.reduceExpr, t.mapExpr)
+	marshalParallelReduceTable(ctx, enc, t.hash, t.ast, t.src, t.keyExpr, t.reducer, t.mapExpr)
 }
 
 func (t *parallelReduceTable) Attrs(ctx context.Context) TableAttrs {
@@ -182,7 +201,7 @@ func unmarshalParallelReduceTable(ctx UnmarshalContext, hash hash.Hash, dec *mar
 		src:  unmarshalTable(ctx, dec),
 	}
 	t.keyExpr = unmarshalFunc(ctx, dec)
-	t.reduceExpr = unmarshalFunc(ctx, dec)
+	t.reducer = unmarshalReducer(ctx, ast, dec)
 	t.mapExpr = unmarshalFunc(ctx, dec)
 	return t
 }
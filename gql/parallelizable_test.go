@@ -0,0 +1,50 @@
+package gql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsParallelizable(t *testing.T) {
+	ctx := context.Background()
+	sess := newSession()
+	doEval(t, "t0 := table({a:1}, {a:2}, {a:3}, {a:4}, {a:5}, {a:6})", sess)
+	for _, test := range []struct {
+		expr string
+		want bool
+	}{
+		{"t0", true},
+		{"t0 | map({b:$a})", true},
+		{"t0 | firstn(4)", false},
+		{"t0 | firstn(4) | map({b:$a})", false},
+		{"t0 | reduce($a, |x,y|(x+y))", false},
+		{`t0 | map({id:$a, v:$a}) | to_long("id")`, true},
+		{`t0 | map({id:$a, v:$a}) | to_long("id") | to_wide("id")`, false},
+	} {
+		assert.Equal(t, test.want, IsParallelizable(ctx, doEval(t, test.expr, sess).Table(nil)), test.expr)
+	}
+}
+
+func TestShardableTable(t *testing.T) {
+	ctx := context.Background()
+	sess := newSession()
+	src := doEval(t, "table({a:1}, {a:2}, {a:3}, {a:4}, {a:5}, {a:6}) | firstn(5)", sess).Table(nil)
+	assert.Equal(t, src, shardableTable(ctx, astUnknown, src, 1))
+
+	sharded := shardableTable(ctx, astUnknown, src, 3)
+	assert.True(t, IsParallelizable(ctx, sharded))
+	assert.Equal(t, src.Hash(), sharded.Hash())
+	var all []string
+	for shard := 0; shard < 3; shard++ {
+		var rows []string
+		sc := sharded.Scanner(ctx, shard, shard+1, 3)
+		for sc.Scan() {
+			rows = append(rows, sc.Value().String())
+		}
+		assert.NotEmpty(t, rows, "shard %d", shard)
+		all = append(all, rows...)
+	}
+	assert.Equal(t, []string{"{a:1}", "{a:2}", "{a:3}", "{a:4}", "{a:5}"}, all)
+}
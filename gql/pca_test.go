@@ -0,0 +1,70 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pcaColumn reads a float column of the named table in the pca result.
+func pcaColumn(t *testing.T, result Value, table, col string) []float64 {
+	tv, ok := result.Struct(nil).Value(symbol.Intern(table))
+	require.True(t, ok)
+	var vals []float64
+	sc := tv.Table(nil).Scanner(context.Background(), 0, 1, 1)
+	for sc.Scan() {
+		v, ok := sc.Value().Struct(nil).Value(symbol.Intern(col))
+		require.True(t, ok)
+		vals = append(vals, v.Float(nil))
+	}
+	return vals
+}
+
+func TestPCA(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewSource(0))
+	// The data is nearly rank one: (a, b, c) = x * (1, 2, -1) + noise.
+	var rows []Value
+	for i := 0; i < 100; i++ {
+		x := r.NormFloat64()
+		rows = append(rows, NewStruct(NewSimpleStruct(
+			StructField{Name: symbol.Intern("id"), Value: NewString(fmt.Sprint(i))},
+			StructField{Name: symbol.Intern("a"), Value: NewFloat(x + 0.01*r.NormFloat64())},
+			StructField{Name: symbol.Intern("b"), Value: NewFloat(2*x + 0.01*r.NormFloat64())},
+			StructField{Name: symbol.Intern("c"), Value: NewFloat(-x + 0.01*r.NormFloat64())})))
+	}
+	tbl := NewSimpleTable(rows, hash.String("pcatest"), TableAttrs{Name: "pcatest"})
+
+	exact := pca(ctx, astUnknown, tbl, nil, 2, false)
+	loadings := pcaColumn(t, exact, "loadings", "PC1")
+	want := []float64{1 / math.Sqrt(6), 2 / math.Sqrt(6), -1 / math.Sqrt(6)}
+	for i := range want {
+		assert.InDelta(t, want[i], loadings[i], 1e-3)
+	}
+	fraction := pcaColumn(t, exact, "variance", "fraction")
+	assert.Len(t, fraction, 2)
+	assert.True(t, fraction[0] > 0.999, fraction)
+	scores := pcaColumn(t, exact, "scores", "PC1")
+	assert.Len(t, scores, 100)
+
+	// The randomized SVD should produce the same top component.
+	saved := maxExactPCACols
+	maxExactPCACols = 0
+	defer func() { maxExactPCACols = saved }()
+	randomized := pca(ctx, astUnknown, tbl, []symbol.ID{symbol.Intern("a"), symbol.Intern("b"), symbol.Intern("c")}, 2, false)
+	rLoadings := pcaColumn(t, randomized, "loadings", "PC1")
+	for i := range want {
+		assert.InDelta(t, want[i], rLoadings[i], 1e-3)
+	}
+	rScores := pcaColumn(t, randomized, "scores", "PC1")
+	for i := range scores {
+		assert.InDelta(t, scores[i], rScores[i], 1e-6)
+	}
+}
@@ -0,0 +1,121 @@
+package gql
+
+// This file implements the columns and on_missing args of read(), and
+// missing_columns(), which lists the columns filled with NA.
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+)
+
+// missingColumn is a column not found in a file read with on_missing:="na".
+type missingColumn struct {
+	path string
+	col  symbol.ID
+}
+
+// missingColumns lists the columns filled with NA, in the order of discovery.
+var missingColumns struct {
+	mu   sync.Mutex
+	seen map[missingColumn]bool
+	list []missingColumn
+}
+
+// recordMissingColumn records that the file lacks the column. It logs a warning
+// the first time a column is reported for the file.
+func recordMissingColumn(ast ASTNode, path string, col symbol.ID) {
+	key := missingColumn{path: path, col: col}
+	missingColumns.mu.Lock()
+	defer missingColumns.mu.Unlock()
+	if missingColumns.seen[key] {
+		return
+	}
+	if missingColumns.seen == nil {
+		missingColumns.seen = map[missingColumn]bool{}
+	}
+	missingColumns.seen[key] = true
+	missingColumns.list = append(missingColumns.list, key)
+	log.Printf("%v: read %s: column '%s' not found; filling it with NA", ast.pos(), path, col.Str())
+}
+
+// requireColumns returns a table whose rows contain the columns listed in
+// cols, a comma-separated string. The columns missing in a row of t are
+// appended with NA value if onMissing is "na"; else they cause an error.
+func requireColumns(ast ASTNode, path string, t Table, cols string, onMissing string) Table {
+	h := t.Hash().Merge(hash.String("read:on_missing:" + onMissing))
+	var colIDs []symbol.ID
+	for _, col := range strings.Split(cols, ",") {
+		if col = strings.TrimSpace(col); col == "" {
+			Panicf(ast, "read %s: columns '%s': empty column name", path, cols)
+		}
+		colIDs = append(colIDs, symbol.Intern(col))
+		h = h.Merge(colIDs[len(colIDs)-1].Hash())
+	}
+	return &rowTransformTable{
+		src:  t,
+		hash: h,
+		fn: func(ctx context.Context, row Value) Value {
+			st := row.Struct(ast)
+			var fields []StructField
+			for _, col := range colIDs {
+				if _, ok := st.Value(col); ok {
+					continue
+				}
+				if onMissing != "na" {
+					Panicf(ast, "read %s: column '%s' not found%s\nset on_missing:=\"na\" to fill it with NA", path, col.Str(), columnNotFoundHint(col, st))
+				}
+				if fields == nil {
+					fields = make([]StructField, st.Len(), st.Len()+len(colIDs))
+					for fi := range fields {
+						fields[fi] = st.Field(fi)
+					}
+				}
+				fields = append(fields, StructField{Name: col, Value: Null})
+				recordMissingColumn(ast, path, col)
+			}
+			if fields == nil {
+				return row
+			}
+			return NewStruct(NewSimpleStruct(fields...))
+		},
+	}
+}
+
+func init() {
+	RegisterBuiltinFunc("missing_columns",
+		`
+    missing_columns()
+
+Missing_columns returns a table that lists the columns filled with NA by
+::read(path, columns:=cols, on_missing:="na")::, because the file lacked them.
+Each row has columns {path, column}. The table is computed when it is read, so
+it lists the files read so far by this process.
+
+Example:
+
+    t := flatten(
+        read("run1.tsv", columns:="sample,depth", on_missing:="na"),
+        read("run2.tsv", columns:="sample,depth", on_missing:="na"))
+    t | count()
+    missing_columns()
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			return NewTable(newSnapshotTable(ast, "missing_columns", func() []Value {
+				missingColumns.mu.Lock()
+				defer missingColumns.mu.Unlock()
+				rows := make([]Value, len(missingColumns.list))
+				for i, m := range missingColumns.list {
+					rows[i] = NewStruct(NewSimpleStruct(
+						StructField{Name: symbol.Path, Value: NewString(m.path)},
+						StructField{Name: checkDictColumnSymbolID, Value: NewString(m.col.Str())}))
+				}
+				return rows
+			}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType })
+}
@@ -9,12 +9,14 @@ import (
 	"testing"
 
 	"github.com/grailbio/base/log"
-	"github.com/grailbio/testutil"
-	"github.com/stretchr/testify/assert"
 	"github.com/grailbio/gql/gql"
 	"github.com/grailbio/gql/gqltest"
 	"github.com/grailbio/gql/hash"
 	"github.com/grailbio/gql/symbol"
+	"github.com/grailbio/testutil"
+	"github.com/grailbio/testutil/expect"
+	"github.com/grailbio/testutil/h"
+	"github.com/stretchr/testify/assert"
 )
 
 func doSmallReduceTest(t *testing.T, parallel bool) {
@@ -58,6 +60,77 @@ func TestSmallParallelReduce(t *testing.T) {
 	doSmallReduceTest(t, true)
 }
 
+func doReduceCombinerTest(t *testing.T, parallel bool) {
+	env := gqltest.NewSession()
+	doEval := func(expr string) gql.Value {
+		if parallel {
+			re := regexp.MustCompile(`\)$`)
+			expr = re.ReplaceAllString(expr, ", shards:=1)")
+		}
+		return gqltest.Eval(t, expr, env)
+	}
+
+	gqltest.Eval(t, `T0 := table(
+{i:0, s:1},
+{i:3, s:2},
+{i:0, s:3},
+{i:3, s:4},
+{i:1, s:5},
+{i:4, s:NA},
+{i:4, s:2},
+{i:5, s:NA})`, env)
+	expect.EQ(t,
+		gqltest.ReadTableSorted(doEval(`reduce(T0, &i, combiner:=sum, map:=&s)`)),
+		[]string{"{key:0,value:4}", "{key:1,value:5}", "{key:3,value:6}", "{key:4,value:2}", "{key:5,value:NA}"})
+	expect.EQ(t,
+		gqltest.ReadTableSorted(doEval(`reduce(T0, &i, combiner:=min, map:=&s)`)),
+		[]string{"{key:0,value:1}", "{key:1,value:5}", "{key:3,value:2}", "{key:4,value:2}", "{key:5,value:NA}"})
+	expect.EQ(t,
+		gqltest.ReadTableSorted(doEval(`reduce(T0, &i, combiner:=max, map:=&s)`)),
+		[]string{"{key:0,value:3}", "{key:1,value:5}", "{key:3,value:4}", "{key:4,value:2}", "{key:5,value:NA}"})
+
+	// Adding 1.0 to 1e16 is lost in the naive summation.
+	gqltest.Eval(t, `T1 := table({k:0, v:1e16}, {k:0, v:1.0}, {k:0, v:1.0}, {k:0, v:1.0}, {k:0, v:1.0})`, env)
+	gqltest.Eval(t, `naive := reduce(T1, &k, _acc+_val, map:=&v)`, env)
+	doEval(`kahan := reduce(T1, &k, combiner:=kahan_sum, map:=&v)`)
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `naive | map({&key, value: &value - 1e16})`, env)),
+		[]string{"{key:0,value:0}"})
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `kahan | map({&key, value: &value - 1e16})`, env)),
+		[]string{"{key:0,value:4}"})
+
+	if !parallel {
+		gqltest.Eval(t, `T2 := T0 | filter(&i < 4)`, env)
+		expect.EQ(t,
+			gqltest.ReadTableSorted(doEval(`reduce(T2, &i, combiner:=concat, map:=string(&s))`)),
+			[]string{"{key:0,value:1,3}", "{key:1,value:5}", "{key:3,value:2,4}"})
+		expect.EQ(t,
+			gqltest.ReadTableSorted(doEval(`reduce(T2, &i, _acc+_val, map:=&s, check:=true)`)),
+			[]string{"{key:0,value:4}", "{key:1,value:5}", "{key:3,value:6}"})
+		expect.That(t,
+			func() { gqltest.ReadTable(doEval(`reduce(T2, &i, _acc-_val, map:=&s, check:=true)`)) },
+			h.Panics(h.Regexp("reduceexpr is not commutative")))
+		expect.That(t,
+			func() { gqltest.ReadTable(doEval(`reduce(T2, &i, float(_acc+_val), map:=&s, check:=true)`)) },
+			h.Panics(h.Regexp("its type must be the same as the args")))
+		expect.That(t,
+			func() { doEval(`reduce(T0, &i, _acc+_val, combiner:=sum)`) },
+			h.Panics(h.Regexp("exactly one of reduceexpr and combiner:= must be set")))
+		expect.That(t,
+			func() { doEval(`reduce(T0, &i, combiner:=avg)`) },
+			h.Panics(h.Regexp("unknown combiner 'avg'")))
+	}
+}
+
+func TestReduceCombiner(t *testing.T) {
+	doReduceCombinerTest(t, false)
+}
+
+func TestParallelReduceCombiner(t *testing.T) {
+	doReduceCombinerTest(t, true)
+}
+
 func doLargeReduceTest(t *testing.T, nRow, randSeed int) {
 	r := rand.New(rand.NewSource(int64(randSeed)))
 	env := gqltest.NewSession()
@@ -0,0 +1,33 @@
+package gql
+
+import (
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/grailbio/testutil/h"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequiresVersion(t *testing.T) {
+	sess := newSession()
+	old := Version
+	defer func() { Version = old }()
+
+	Version = "devel"
+	assert.Equal(t, "devel", doEval(t, `requires_version("99.0")`, sess).Str(nil))
+
+	Version = "v2.1.3"
+	assert.Equal(t, "v2.1.3", doEval(t, `requires_version("2.1")`, sess).Str(nil))
+	assert.Equal(t, "v2.1.3", doEval(t, `requires_version("v2.1.3")`, sess).Str(nil))
+	// The requirement is checked before the rest of the script is analyzed or
+	// loaded.
+	expect.That(t,
+		func() { doEval(t, `requires_version("2.2"); requires_version_test_undefined + 1`, sess) },
+		h.Panics(h.Regexp(`requires gql version 2.2 or newer, but this binary is version v2.1.3`)))
+	expect.That(t,
+		func() { doEval(t, "load `/nonexistent/requires_version.gql`; requires_version(\"3\")", sess) },
+		h.Panics(h.Regexp(`requires gql version 3 or newer`)))
+	expect.That(t,
+		func() { doEval(t, `requires_version("latest")`, sess) },
+		h.Panics(h.Regexp(`invalid version 'latest'`)))
+}
@@ -0,0 +1,45 @@
+package gql_test
+
+import (
+	"testing"
+
+	"github.com/grailbio/gql/gqltest"
+	"github.com/grailbio/testutil/expect"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBootstrap(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `tbl := table({x:1}, {x:2}, {x:3}, {x:4}, {x:5}, {x:6}, {x:7}, {x:8}, {x:9}, {x:10});
+nbig := func(t) { t | filter($x > 5) | count() }`, env)
+
+	expect.EQ(t, gqltest.Eval(t, `(tbl | bootstrap(nbig, n:=50, seed:=1)).observed`, env).Int(nil), int64(5))
+	expect.EQ(t, gqltest.Eval(t, `(tbl | bootstrap(nbig, n:=50, seed:=1)).replicates | count()`, env).Int(nil), int64(50))
+	assert.True(t, gqltest.Eval(t, `b := tbl | bootstrap(nbig, n:=50, seed:=1); b.lo <= b.mean && b.mean <= b.hi && b.se > 0.0`, env).Bool(nil))
+
+	// The replicates don't depend on the sharding.
+	local := gqltest.ReadTable(gqltest.Eval(t, `(tbl | bootstrap(nbig, n:=20, seed:=2)).replicates | sort($replicate)`, env))
+	sharded := gqltest.ReadTable(gqltest.Eval(t, `(tbl | bootstrap(nbig, n:=20, seed:=2, shards:=3)).replicates | sort($replicate)`, env))
+	expect.EQ(t, sharded, local)
+	assert.Len(t, local, 20)
+
+	// Different seeds yield different replicates.
+	assert.NotEqual(t, local, gqltest.ReadTable(gqltest.Eval(t, `(tbl | bootstrap(nbig, n:=20, seed:=3)).replicates | sort($replicate)`, env)))
+	assert.Panics(t, func() { gqltest.Eval(t, `tbl | bootstrap(nbig, n:=0)`, env) })
+}
+
+func TestPermuteTest(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `tbl := table(
+		{g:"a", x:1}, {g:"a", x:2}, {g:"a", x:3}, {g:"a", x:4}, {g:"a", x:5},
+		{g:"b", x:6}, {g:"b", x:7}, {g:"b", x:8}, {g:"b", x:9}, {g:"b", x:10});
+tstat := func(t) { (t | t_test(value:=&x, group:=&g)).statistic }`, env)
+
+	r := `tbl | permute_test(tstat, group:="g", n:=200, seed:=1)`
+	expect.EQ(t, gqltest.Eval(t, `(`+r+`).observed`, env).Float(nil), -5.0)
+	assert.True(t, gqltest.Eval(t, `(`+r+`).pvalue < 0.05`, env).Bool(nil))
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `(tbl | permute_test(tstat, group:="g", n:=10, seed:=1, shards:=2)).replicates | sort($replicate)`, env)),
+		gqltest.ReadTable(gqltest.Eval(t, `(tbl | permute_test(tstat, group:="g", n:=10, seed:=1)).replicates | sort($replicate)`, env)))
+	assert.Panics(t, func() { gqltest.Eval(t, `tbl | permute_test(tstat, n:=10)`, env) })
+}
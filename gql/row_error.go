@@ -0,0 +1,173 @@
+package gql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/grailbio/base/errors"
+)
+
+// maxRowErrorLen is the max length of the row shown in an error message.
+const maxRowErrorLen = 1024
+
+// truncateRow formats the row for an error message.
+func truncateRow(row Value) string {
+	s := row.String()
+	if len(s) > maxRowErrorLen {
+		s = s[:maxRowErrorLen] + "..."
+	}
+	return s
+}
+
+// describeStage names the pipeline stage implemented by the table, for an
+// error message.
+func describeStage(ctx context.Context, t Table) string {
+	attrs := t.Attrs(ctx)
+	if attrs.Path != "" {
+		return fmt.Sprintf("'%s' (%s)", attrs.Name, attrs.Path)
+	}
+	return fmt.Sprintf("'%s'", attrs.Name)
+}
+
+// formatRowContext produces the suffix of an error message raised while
+// evaluating a function on a row.
+func formatRowContext(stage string, index int, row string) string {
+	return fmt.Sprintf("\nrow #%d of %s: %s", index, stage, row)
+}
+
+// evalRow evaluates fn(row), where row is the index'th row read from src. If fn
+// panics, the row, the name of src, and the index are appended to the panic
+// message. The index counts the rows read by the scanner, so it is only
+// approximate for a sharded or filtered scan.
+func evalRow(ctx context.Context, fn *Func, src Table, index int, row Value) Value {
+	defer func() {
+		if e := recover(); e != nil {
+			panic(fmt.Sprintf("%v%s", e, formatRowContext(describeStage(ctx, src), index, truncateRow(row))))
+		}
+	}()
+	return fn.Eval(ctx, row)
+}
+
+// rowTracker records the row being processed by a filter or map function of a
+// parallel map, so that a panic raised by the function can be sent to the
+// driver along with the row. Methods can be called on a nil rowTracker, in
+// which case they are noops.
+type rowTracker struct {
+	fn    *Func // the function being evaluated.
+	src   Table // the table that yielded row.
+	index int   // the index of row in the scan of src.
+	row   Value // the arg to fn.
+	busy  bool  // true while fn is being evaluated.
+}
+
+type rowTrackerKey struct{}
+
+// withRowTracker creates a context that makes the mapFilterTable scanners
+// created under it record their rows in the returned tracker. A tracker must be
+// used by only one goroutine.
+func withRowTracker(ctx context.Context) (context.Context, *rowTracker) {
+	tr := &rowTracker{}
+	return context.WithValue(ctx, rowTrackerKey{}, tr), tr
+}
+
+// rowTrackerFromContext returns the tracker installed by withRowTracker, or
+// nil.
+func rowTrackerFromContext(ctx context.Context) *rowTracker {
+	tr, _ := ctx.Value(rowTrackerKey{}).(*rowTracker)
+	return tr
+}
+
+// begin is called before evaluating fn(row), where row is the index'th row
+// read from src.
+func (tr *rowTracker) begin(fn *Func, src Table, index int, row Value) {
+	if tr != nil {
+		tr.fn, tr.src, tr.index, tr.row, tr.busy = fn, src, index, row, true
+	}
+}
+
+// end is called after fn(row) returns.
+func (tr *rowTracker) end() {
+	if tr != nil {
+		tr.busy = false
+	}
+}
+
+// RowError describes a panic raised by a filter or map function while
+// processing a row in a parallel map or filter. The workers send it back to the
+// driver, which panics with it in lieu of the raw bigslice error.
+type RowError struct {
+	// Pos is the source-code location of the function.
+	Pos string
+	// Shard and NShards identify the shard that processed the row.
+	Shard, NShards int
+	// Stage names the table that yielded the row, and Index is the
+	// approximate index of the row in the shard.
+	Stage string
+	Index int
+	// Row is the offending row, truncated to maxRowErrorLen bytes.
+	Row string
+	// Message is the value of the panic.
+	Message string
+}
+
+// Error implements error.
+func (e *RowError) Error() string {
+	return fmt.Sprintf("%s: shard %d/%d: %s%s", e.Pos, e.Shard, e.NShards, e.Message, formatRowContext(e.Stage, e.Index, e.Row))
+}
+
+// rowErrorMarker precedes the JSON encoding of a RowError in the message of
+// the error returned by a bigslice worker.
+const rowErrorMarker = "gql-row-error:"
+
+// remoteError converts e into an error that can be returned by a bigslice
+// worker. The error message embeds e, which can be extracted by findRowError.
+func (e *RowError) remoteError() error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+	return errors.E(errors.Fatal, rowErrorMarker+string(data))
+}
+
+// findRowError extracts the RowError embedded in an error returned by
+// bigslice. It returns nil if err doesn't contain a RowError.
+func findRowError(err error) *RowError {
+	msg := err.Error()
+	i := strings.Index(msg, rowErrorMarker)
+	if i < 0 {
+		return nil
+	}
+	e := &RowError{}
+	if err := json.NewDecoder(strings.NewReader(msg[i+len(rowErrorMarker):])).Decode(e); err != nil {
+		return nil
+	}
+	return e
+}
+
+// catchRowError runs fn. If fn panics while a filter or map function is being
+// evaluated, it returns the panic as a RowError. Other panics are propagated.
+func catchRowError(ctx context.Context, tr *rowTracker, shard, nshards int, fn func()) (rowErr *RowError) {
+	defer func() {
+		e := recover()
+		if e == nil {
+			return
+		}
+		if tr == nil || !tr.busy {
+			panic(e)
+		}
+		tr.busy = false
+		rowErr = &RowError{
+			Pos:     tr.fn.ast.pos().String(),
+			Shard:   shard,
+			NShards: nshards,
+			Stage:   describeStage(ctx, tr.src),
+			Index:   tr.index,
+			Row:     truncateRow(tr.row),
+			Message: fmt.Sprint(e),
+		}
+	}()
+	fn()
+	return nil
+}
@@ -0,0 +1,60 @@
+package gql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil/expect"
+	"github.com/grailbio/testutil/h"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParallelMapRowError(t *testing.T) {
+	sess := newSession()
+	doEval(t, "t0 := table({a:1}, {a:2}, {b:3}, {a:4})", sess)
+	catch := func(expr string) (rowErr *RowError) {
+		defer func() {
+			rowErr, _ = recover().(*RowError)
+		}()
+		doReadTable(doEval(t, expr, sess))
+		return nil
+	}
+	for _, expr := range []string{
+		"t0 | map({x:$a * 2}, shards:=2)",
+		"t0 | filter($a > 1, shards:=2)",
+	} {
+		rowErr := catch(expr)
+		require.NotNil(t, rowErr, expr)
+		expect.EQ(t, rowErr.Row, "{b:3}")
+		expect.EQ(t, rowErr.NShards, 2)
+		expect.HasSubstr(t, rowErr.Pos, "(input):1:")
+		expect.HasSubstr(t, rowErr.Message, "column 'a' not found")
+	}
+
+	// The error survives the trip through the bigslice error message.
+	rowErr := &RowError{Pos: "foo.gql:1:10", Shard: 3, NShards: 10, Row: strings.Repeat("x", 10), Message: "bad\nvalue"}
+	expect.EQ(t, findRowError(fmt.Errorf("task map@10:3: %v", rowErr.remoteError())), rowErr)
+	expect.True(t, findRowError(fmt.Errorf("some other error")) == nil)
+}
+
+func TestRowErrorContext(t *testing.T) {
+	sess := newSession()
+	doEval(t, "t0 := table({a:1}, {a:2}, {b:3}, {a:4})", sess)
+	for _, expr := range []string{
+		"t0 | map({x:$a * 2})",
+		"t0 | filter($a > 1)",
+		"t0 | reduce($a, _acc+_val, map:=1)",
+		"t0 | sort($a)",
+	} {
+		expect.That(t,
+			func() { doReadTable(doEval(t, expr, sess)) },
+			h.Panics(h.Regexp(`column 'a' not found[^\n]*\nrow #2 of '[^']*': \{b:3\}`)), expr)
+	}
+
+	// The row is truncated in the message.
+	doEval(t, fmt.Sprintf("t1 := table({s:\"%s\"})", strings.Repeat("x", 2*maxRowErrorLen)), sess)
+	expect.That(t,
+		func() { doReadTable(doEval(t, "t1 | map($a)", sess)) },
+		h.Panics(h.Regexp(`row #0 of '[^']*': \{s:x+\.\.\.$`)))
+}
@@ -0,0 +1,250 @@
+package gql
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+)
+
+// Support for reading a particular version of an object in a versioned S3
+// bucket, i.e., read(path, version_id:=...) and read(path, as_of:=...).
+//
+// The object version is downloaded once into the cache directory, and the
+// table is read from there. Object versions are immutable, so the table hash is
+// computed from the pathname and the version ID.
+
+// s3DefaultRegion is used to discover the region of a bucket.
+const s3DefaultRegion = "us-west-2"
+
+var (
+	s3ClientsMu sync.Mutex
+	s3Session   *session.Session
+	// s3Clients caches the client for each bucket.
+	s3Clients = map[string]s3iface.S3API{}
+
+	// newS3Client creates a client for the given bucket. Replaced in unittests.
+	newS3Client = func(ctx context.Context, bucket string) (s3iface.S3API, error) {
+		if s3Session == nil {
+			sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+			if err != nil {
+				return nil, err
+			}
+			s3Session = sess
+		}
+		region, err := s3manager.GetBucketRegion(ctx, s3Session, bucket, s3DefaultRegion)
+		if err != nil {
+			return nil, err
+		}
+		return s3.New(s3Session, aws.NewConfig().WithRegion(region)), nil
+	}
+)
+
+// s3ObjectVersion identifies one version of an S3 object.
+type s3ObjectVersion struct {
+	bucket, key string
+	versionID   string
+	modTime     time.Time
+}
+
+func getS3Client(ctx context.Context, bucket string) (s3iface.S3API, error) {
+	s3ClientsMu.Lock()
+	defer s3ClientsMu.Unlock()
+	if client, ok := s3Clients[bucket]; ok {
+		return client, nil
+	}
+	client, err := newS3Client(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	s3Clients[bucket] = client
+	return client, nil
+}
+
+// parseS3Path splits "s3://bucket/key" into bucket and key.
+func parseS3Path(path string) (bucket, key string, ok bool) {
+	if !strings.HasPrefix(path, "s3://") {
+		return "", "", false
+	}
+	parts := strings.SplitN(path[len("s3://"):], "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// resolveS3Version finds the version of the S3 object to read. If versionID
+// is nonempty, it checks that the version exists. Else, it finds the latest
+// version that was created at or before asOf.
+func resolveS3Version(ctx context.Context, ast ASTNode, path, versionID string, asOf time.Time) s3ObjectVersion {
+	bucket, key, ok := parseS3Path(path)
+	if !ok {
+		Panicf(ast, "read %s: version_id and as_of can be used only for S3 objects", path)
+	}
+	client, err := getS3Client(ctx, bucket)
+	if err != nil {
+		Panicf(ast, "read %s: %v", path, err)
+	}
+	if versionID != "" {
+		out, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket:    aws.String(bucket),
+			Key:       aws.String(key),
+			VersionId: aws.String(versionID),
+		})
+		if err != nil {
+			Panicf(ast, "read %s: version %s: %v", path, versionID, err)
+		}
+		return s3ObjectVersion{bucket: bucket, key: key, versionID: versionID, modTime: aws.TimeValue(out.LastModified)}
+	}
+
+	var (
+		found   bool
+		deleted bool
+		best    s3ObjectVersion
+	)
+	visit := func(objKey, objVersionID *string, modTimePtr *time.Time, isDeleteMarker bool) {
+		modTime := aws.TimeValue(modTimePtr)
+		if aws.StringValue(objKey) != key || modTime.After(asOf) {
+			return
+		}
+		if !found || modTime.After(best.modTime) {
+			found = true
+			deleted = isDeleteMarker
+			best = s3ObjectVersion{bucket: bucket, key: key, versionID: aws.StringValue(objVersionID), modTime: modTime}
+		}
+	}
+	err = client.ListObjectVersionsPagesWithContext(ctx,
+		&s3.ListObjectVersionsInput{Bucket: aws.String(bucket), Prefix: aws.String(key)},
+		func(out *s3.ListObjectVersionsOutput, _ bool) bool {
+			for _, v := range out.Versions {
+				visit(v.Key, v.VersionId, v.LastModified, false)
+			}
+			for _, m := range out.DeleteMarkers {
+				visit(m.Key, m.VersionId, m.LastModified, true)
+			}
+			return true
+		})
+	if err != nil {
+		Panicf(ast, "read %s: list versions: %v", path, err)
+	}
+	if !found || deleted {
+		Panicf(ast, "read %s: object did not exist as of %v", path, asOf)
+	}
+	return best
+}
+
+// fetchS3Version downloads the object version into the cache directory, and
+// returns the path of the local copy.
+func fetchS3Version(ctx context.Context, ast ASTNode, v s3ObjectVersion) string {
+	h := hash.String(v.bucket).Merge(hash.String(v.key)).Merge(hash.String(v.versionID))
+	cacheName := h.String() + "-" + file.Base(v.key)
+	cachePath, found := LookupCache(ctx, cacheName)
+	if found {
+		return cachePath
+	}
+	defer abandonCacheEntry(ctx, cachePath)
+	client, err := getS3Client(ctx, v.bucket)
+	if err != nil {
+		Panicf(ast, "read s3://%s/%s: %v", v.bucket, v.key, err)
+	}
+	out, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(v.bucket),
+		Key:       aws.String(v.key),
+		VersionId: aws.String(v.versionID),
+	})
+	if err != nil {
+		Panicf(ast, "read s3://%s/%s: version %s: %v", v.bucket, v.key, v.versionID, err)
+	}
+	defer out.Body.Close() // nolint: errcheck
+	w, err := file.Create(ctx, cachePath)
+	if err != nil {
+		Panicf(ast, "create %s: %v", cachePath, err)
+	}
+	if _, err := io.Copy(w.Writer(ctx), out.Body); err != nil {
+		Panicf(ast, "read s3://%s/%s: version %s: %v", v.bucket, v.key, v.versionID, err)
+	}
+	if err := w.Close(ctx); err != nil {
+		Panicf(ast, "close %s: %v", cachePath, err)
+	}
+	ActivateCache(ctx, cacheName, cachePath)
+	return cachePath
+}
+
+// versionedTable is a table read from a particular version of an S3 object.
+// It reports the original S3 path and the version in its attributes.
+type versionedTable struct {
+	Table
+	ast     ASTNode
+	fh      FileHandler
+	path    string
+	version string
+}
+
+var versionedTableMagic = UnmarshalMagic{0x7e, 0x3d}
+
+// Marshal implements the Table interface. It encodes the S3 path and the
+// version ID, since the local copy of the object may not be accessible from the
+// remote machine.
+func (t *versionedTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	enc.PutRawBytes(versionedTableMagic[:])
+	enc.PutHash(t.Hash())
+	enc.PutGOB(&t.ast)
+	enc.PutString(t.fh.Name())
+	enc.PutString(t.path)
+	enc.PutString(t.version)
+}
+
+func unmarshalVersionedTable(ctx UnmarshalContext, h hash.Hash, dec *marshal.Decoder) Table {
+	var ast ASTNode
+	dec.GOB(&ast)
+	fh := GetFileHandlerByName(dec.String())
+	path := dec.String()
+	versionID := dec.String()
+	return newVersionedS3Table(ctx.ctx, ast, path, fh, versionID, time.Time{})
+}
+
+// Attrs implements the Table interface.
+func (t *versionedTable) Attrs(ctx context.Context) TableAttrs {
+	attrs := t.Table.Attrs(ctx)
+	attrs.Path = t.path
+	attrs.Version = t.version
+	return attrs
+}
+
+// newVersionedS3Table creates a table that reads the given version of an S3
+// object. Exactly one of versionID or asOf must be set. The RowTransformers that
+// match the S3 path are applied to the table.
+func newVersionedS3Table(ctx context.Context, ast ASTNode, path string, fh FileHandler, versionID string, asOf time.Time) Table {
+	if fh == nil {
+		if fh = GetFileHandlerByPath(path); fh == nil {
+			Panicf(ast, "open %s: unknown file type", path)
+		}
+	}
+	v := resolveS3Version(ctx, ast, path, versionID, asOf)
+	localPath := fetchS3Version(ctx, ast, v)
+	h := hash.String(path).Merge(hash.String(v.versionID))
+	recordInputFile(ctx, localPath)
+	// The RowTransformers are keyed by the S3 path, not by the path of the
+	// local copy.
+	return applyRowTransformers(path, &versionedTable{
+		Table:   fh.Open(ctx, localPath, ast, h),
+		ast:     ast,
+		fh:      fh,
+		path:    path,
+		version: v.versionID,
+	})
+}
+
+func init() {
+	RegisterTableUnmarshaler(versionedTableMagic, unmarshalVersionedTable)
+}
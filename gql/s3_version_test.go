@@ -0,0 +1,144 @@
+package gql
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/grailbio/testutil/expect"
+)
+
+type fakeS3Version struct {
+	id      string
+	modTime time.Time
+	data    string
+}
+
+// fakeVersionedS3 implements a single versioned S3 object.
+type fakeVersionedS3 struct {
+	s3iface.S3API
+	key      string
+	versions []fakeS3Version
+}
+
+func (c *fakeVersionedS3) find(key, id *string) (fakeS3Version, error) {
+	for _, v := range c.versions {
+		if aws.StringValue(key) == c.key && aws.StringValue(id) == v.id {
+			return v, nil
+		}
+	}
+	return fakeS3Version{}, awserr.New("NoSuchVersion", "not found", nil)
+}
+
+func (c *fakeVersionedS3) HeadObjectWithContext(_ aws.Context, in *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+	v, err := c.find(in.Key, in.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.HeadObjectOutput{LastModified: aws.Time(v.modTime), VersionId: aws.String(v.id)}, nil
+}
+
+func (c *fakeVersionedS3) GetObjectWithContext(_ aws.Context, in *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	v, err := c.find(in.Key, in.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader([]byte(v.data)))}, nil
+}
+
+func (c *fakeVersionedS3) ListObjectVersionsPagesWithContext(_ aws.Context, in *s3.ListObjectVersionsInput, cb func(*s3.ListObjectVersionsOutput, bool) bool, _ ...request.Option) error {
+	out := &s3.ListObjectVersionsOutput{}
+	for _, v := range c.versions {
+		out.Versions = append(out.Versions, &s3.ObjectVersion{
+			Key:          aws.String(c.key),
+			VersionId:    aws.String(v.id),
+			LastModified: aws.Time(v.modTime),
+		})
+	}
+	cb(out, true)
+	return nil
+}
+
+// installFakeVersionedS3 makes the S3 client return the given fake. It returns a
+// function that restores the old client.
+func installFakeVersionedS3(fake *fakeVersionedS3) (restore func()) {
+	old := newS3Client
+	newS3Client = func(context.Context, string) (s3iface.S3API, error) { return fake, nil }
+	s3ClientsMu.Lock()
+	s3Clients = map[string]s3iface.S3API{}
+	s3ClientsMu.Unlock()
+	return func() {
+		newS3Client = old
+		s3ClientsMu.Lock()
+		s3Clients = map[string]s3iface.S3API{}
+		s3ClientsMu.Unlock()
+	}
+}
+
+func TestReadS3Version(t *testing.T) {
+	ctx := context.Background()
+	sess := TestNewSession()
+	fake := &fakeVersionedS3{
+		key: "samples.tsv",
+		versions: []fakeS3Version{
+			{"v1", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), "a\n1\n"},
+			{"v2", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), "a\n2\n3\n"},
+		},
+	}
+	defer installFakeVersionedS3(fake)()
+
+	eval := func(expr string) Table {
+		statements, err := sess.Parse("(input)", []byte(expr))
+		expect.NoError(t, err)
+		return sess.EvalStatements(ctx, statements).Table(nil)
+	}
+	t1 := eval("read(`s3://fakebucket/samples.tsv`, as_of:=2024-01-15)")
+	expect.EQ(t, t1.Len(ctx, Exact), 1)
+	expect.EQ(t, t1.Attrs(ctx).Path, "s3://fakebucket/samples.tsv")
+	expect.EQ(t, t1.Attrs(ctx).Version, "v1")
+
+	t2 := eval("read(`s3://fakebucket/samples.tsv`, version_id:=\"v2\")")
+	expect.EQ(t, t2.Len(ctx, Exact), 2)
+	expect.EQ(t, t2.Attrs(ctx).Version, "v2")
+	expect.NE(t, t1.Hash(), t2.Hash())
+
+	t3 := eval("read(`s3://fakebucket/samples.tsv`, as_of:=2024-03-01)")
+	expect.EQ(t, t3.Attrs(ctx).Version, "v2")
+	expect.EQ(t, t3.Hash(), t2.Hash())
+}
+
+func TestReadS3VersionRowTransformers(t *testing.T) {
+	ctx := context.Background()
+	sess := TestNewSession()
+	fake := &fakeVersionedS3{
+		key: "secret.tsv",
+		versions: []fakeS3Version{
+			{"v1", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), "a\tb\n1\tx\n"},
+		},
+	}
+	defer installFakeVersionedS3(fake)()
+	defer TestSetRowTransformers([]RowTransformer{{
+		Name:      "redact",
+		PathRE:    regexp.MustCompile("^s3://fakebucket/secret"),
+		Transform: MaskColumns([]string{"b"}, MaskNull),
+	}})()
+
+	for _, expr := range []string{
+		"read(`s3://fakebucket/secret.tsv`, version_id:=\"v1\")",
+		"read(`s3://fakebucket/secret.tsv`, as_of:=2024-01-15)",
+	} {
+		statements, err := sess.Parse("(input)", []byte(expr))
+		expect.NoError(t, err)
+		tbl := sess.EvalStatements(ctx, statements).Table(nil)
+		expect.EQ(t, tbl.Attrs(ctx).Version, "v1")
+		expect.EQ(t, doReadTable(NewTable(tbl)), []string{"{a:1,b:NA}"}, expr)
+	}
+}
@@ -0,0 +1,427 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/hash"
+)
+
+// Temporary files, such as the intermediate runs of an external sort, are
+// stored in a per-process directory "<root>/tmp/<host>-<pid>-<starttime>". The
+// root is normally the cache directory. If the cache directory is on the local
+// disk and the disk is running out of space, the temp files are instead stored
+// under Opts.RemoteScratchDir.
+//
+// Each Session stores its temp files in its own subdirectory
+// "<host>-<pid>-<starttime>/session-<id>", which Session.Close removes. Temp
+// files created outside of a Session evaluation are stored directly in the
+// per-process directory.
+//
+// A cache entry is also a temp file until it is activated: if the computation
+// that fills it fails, the entry is removed along with the temp files of the
+// session. A manifest of such pending entries is kept in the per-process
+// directory on the local disk, so that the entries left behind by a crashed
+// process are removed too.
+//
+// CleanupTempFiles removes the per-process directories on shutdown. Directories
+// left behind by crashed processes are swept by Init.
+
+const (
+	scratchSubdir = "tmp"
+	// DefaultLocalScratchMinFree is the default value of
+	// Opts.LocalScratchMinFree.
+	DefaultLocalScratchMinFree = 4 << 30
+	// scratchMaxAge is the age after which a scratch directory is assumed to be
+	// orphaned, even if its owner can't be determined to be dead.
+	scratchMaxAge = 7 * 24 * time.Hour
+)
+
+var (
+	// remoteScratchRoot is copied from Opts.RemoteScratchDir.
+	remoteScratchRoot string
+	// localScratchMinFree is copied from Opts.LocalScratchMinFree.
+	localScratchMinFree int64 = DefaultLocalScratchMinFree
+
+	scratchStartTime = time.Now()
+
+	scratchMu sync.Mutex
+	// scratchDirs is the set of scratch directories created by this process.
+	scratchDirs = map[string]struct{}{}
+	// pendingCacheEntries maps the unique path of a cache entry that is being
+	// filled by this process to its state.
+	pendingCacheEntries = map[string]pendingCacheEntry{}
+
+	nextTempNamespaceID int64
+)
+
+// tempNamespace is the set of temp files owned by a Session.
+type tempNamespace struct {
+	// subdir is the name of the namespace directory under the per-process
+	// scratch directory. It is "" for the process-wide namespace.
+	subdir string
+
+	mu   sync.Mutex
+	dirs map[string]struct{} // scratch directories created for the namespace.
+	// materialized maps the hash of a table to its copy written by
+	// materializeTable under the namespace.
+	materialized map[hash.Hash]*btsvTable
+}
+
+// processTempNamespace is the namespace for temp files created outside a
+// Session evaluation.
+var processTempNamespace = &tempNamespace{}
+
+func newTempNamespace() *tempNamespace {
+	scratchMu.Lock()
+	nextTempNamespaceID++
+	id := nextTempNamespaceID
+	scratchMu.Unlock()
+	return &tempNamespace{subdir: fmt.Sprintf("session-%d", id)}
+}
+
+type tempNamespaceKey struct{}
+
+// withTempNamespace returns a context that causes the temp files created under
+// it to be stored in ns.
+func withTempNamespace(ctx context.Context, ns *tempNamespace) context.Context {
+	return context.WithValue(ctx, tempNamespaceKey{}, ns)
+}
+
+// tempNamespaceFromContext returns the temp namespace attached to ctx by
+// withTempNamespace, or the process-wide namespace.
+func tempNamespaceFromContext(ctx context.Context) *tempNamespace {
+	if ctx != nil {
+		if ns, ok := ctx.Value(tempNamespaceKey{}).(*tempNamespace); ok {
+			return ns
+		}
+	}
+	return processTempNamespace
+}
+
+// dir returns the directory for the namespace under the given scratch root.
+func (ns *tempNamespace) dir(root string) string {
+	dir := file.Join(root, scratchSubdir, scratchDirName())
+	scratchMu.Lock()
+	scratchDirs[dir] = struct{}{}
+	scratchMu.Unlock()
+	if ns.subdir != "" {
+		dir = file.Join(dir, ns.subdir)
+	}
+	ns.mu.Lock()
+	if ns.dirs == nil {
+		ns.dirs = map[string]struct{}{}
+	}
+	ns.dirs[dir] = struct{}{}
+	ns.mu.Unlock()
+	return dir
+}
+
+// materializedTable returns the copy of the table with hash h written by
+// materializeTable under the namespace, or nil.
+func (ns *tempNamespace) materializedTable(h hash.Hash) *btsvTable {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	return ns.materialized[h]
+}
+
+// addMaterializedTable records that t is a copy of the table with hash h
+// written under the namespace.
+func (ns *tempNamespace) addMaterializedTable(h hash.Hash, t *btsvTable) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if ns.materialized == nil {
+		ns.materialized = map[hash.Hash]*btsvTable{}
+	}
+	ns.materialized[h] = t
+}
+
+// cleanup removes the temp files in the namespace, and the cache entries that
+// were looked up, but not activated, under the namespace.
+func (ns *tempNamespace) cleanup(ctx context.Context) {
+	ns.mu.Lock()
+	dirs := ns.dirs
+	ns.dirs = nil
+	ns.materialized = nil
+	ns.mu.Unlock()
+	for dir := range dirs {
+		if err := file.RemoveAll(ctx, dir); err != nil {
+			log.Error.Printf("cleanup %s: %v", dir, err)
+		}
+	}
+	scratchMu.Lock()
+	var paths []string
+	for path, e := range pendingCacheEntries {
+		if e.ns == ns {
+			paths = append(paths, path)
+		}
+	}
+	scratchMu.Unlock()
+	for _, path := range paths {
+		abandonCacheEntry(ctx, path)
+	}
+}
+
+// pendingCacheEntry is a cache entry that is being filled by this process.
+type pendingCacheEntry struct {
+	name         string         // the cache entry name passed to LookupCache.
+	manifestPath string         // the manifest file that records path.
+	ns           *tempNamespace // the namespace that looked up the entry.
+}
+
+// pendingCacheManifestRoot returns the directory under which the manifest of
+// pending cache entries is stored. The manifest is updated on every cache miss,
+// so it is kept on the local disk even if the cache directory is remote.
+func pendingCacheManifestRoot() string {
+	if root := cacheWriteRoot(); isLocalPath(root) {
+		return root
+	}
+	if localCacheRoot != "" {
+		return localCacheRoot
+	}
+	return readOnlyCacheRoot
+}
+
+// pendingCacheManifestDir is the directory that stores the manifest of
+// pending cache entries of the process that owns scratchDir.
+func pendingCacheManifestDir(scratchDir string) string {
+	return file.Join(scratchDir, "pending-cache")
+}
+
+// addPendingCacheEntry records that the cache entry "name" will be produced in
+// path. The entry is removed by Session.Close or CleanupTempFiles unless it is
+// activated by then.
+func addPendingCacheEntry(ctx context.Context, name, path string) {
+	dir := file.Join(pendingCacheManifestRoot(), scratchSubdir, scratchDirName())
+	e := pendingCacheEntry{
+		name:         name,
+		manifestPath: file.Join(pendingCacheManifestDir(dir), fmt.Sprintf("%016x-%x", time.Now().UnixNano(), rand.Uint64())),
+		ns:           tempNamespaceFromContext(ctx),
+	}
+	if err := file.WriteFile(ctx, e.manifestPath, []byte(path)); err != nil {
+		// The manifest is needed only for crash recovery.
+		log.Error.Printf("cache %s: write manifest: %v", name, err)
+		e.manifestPath = ""
+	}
+	scratchMu.Lock()
+	scratchDirs[dir] = struct{}{}
+	pendingCacheEntries[path] = e
+	scratchMu.Unlock()
+}
+
+// promotePendingCacheEntries is called when the cache entry "name" is
+// activated. The paths generated for the entry are no longer temp files.
+func promotePendingCacheEntries(ctx context.Context, name string) {
+	var promoted []pendingCacheEntry
+	scratchMu.Lock()
+	for path, e := range pendingCacheEntries {
+		if e.name == name {
+			promoted = append(promoted, e)
+			delete(pendingCacheEntries, path)
+		}
+	}
+	scratchMu.Unlock()
+	for _, e := range promoted {
+		if e.manifestPath == "" {
+			continue
+		}
+		if err := file.Remove(ctx, e.manifestPath); err != nil {
+			log.Error.Printf("cache %s: remove manifest: %v", name, err)
+		}
+	}
+}
+
+// abandonCacheEntry removes the contents of the pending cache entry stored in
+// path and releases its lease, so that another process can produce the entry.
+// It is a no-op if the entry has been activated, so the producer of an entry
+// defers it right after LookupCache returns.
+func abandonCacheEntry(ctx context.Context, path string) {
+	scratchMu.Lock()
+	e, ok := pendingCacheEntries[path]
+	delete(pendingCacheEntries, path)
+	scratchMu.Unlock()
+	if !ok {
+		return
+	}
+	log.Debug.Printf("cache %s: removing unfinished entry %s", e.name, path)
+	if err := file.RemoveAll(ctx, path); err != nil {
+		log.Error.Printf("cache %s: remove %s: %v", e.name, path, err)
+	}
+	if e.manifestPath != "" {
+		file.Remove(ctx, e.manifestPath) // nolint: errcheck
+	}
+	if !readOnlyCache {
+		releaseCacheLease(ctx, e.name)
+	}
+}
+
+// isLocalPath checks if the path refers to a local file.
+func isLocalPath(path string) bool {
+	return !strings.Contains(path, "://")
+}
+
+// localFreeBytes returns the number of bytes available to the user in the
+// filesystem that stores dir. Dir need not exist. It returns -1 on error.
+func localFreeBytes(dir string) int64 {
+	for {
+		var st syscall.Statfs_t
+		err := syscall.Statfs(dir, &st)
+		if err == nil {
+			return int64(uint64(st.Bavail) * uint64(st.Bsize))
+		}
+		parent := filepath.Dir(dir)
+		if !os.IsNotExist(err) || parent == dir {
+			return -1
+		}
+		dir = parent
+	}
+}
+
+func scratchDirName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), scratchStartTime.UnixNano())
+}
+
+// scratchRoot picks the directory under which temp files are created.
+func scratchRoot() string {
+	root := cacheWriteRoot()
+	if remoteScratchRoot != "" && isLocalPath(root) {
+		if free := localFreeBytes(root); free >= 0 && free < localScratchMinFree {
+			log.Debug.Printf("scratch: %s has only %d bytes free; using %s", root, free, remoteScratchRoot)
+			root = remoteScratchRoot
+		}
+	}
+	return root
+}
+
+// newTempPath generates a unique pathname for a temporary file or a btsv
+// directory. "name" should be of form "prefix.extension". The file is placed in
+// the temp namespace of the session evaluating ctx, if any. The caller should
+// remove the file once done, but the file will be removed by Session.Close or
+// CleanupTempFiles otherwise.
+func newTempPath(ctx context.Context, name string) string {
+	dir := tempNamespaceFromContext(ctx).dir(scratchRoot())
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s/%s-%016x-%x%s", dir, name[:len(name)-len(ext)], time.Now().UnixNano(), rand.Uint64(), ext)
+}
+
+// CleanupTempFiles removes the temp files created by this process. It should
+// be called once when the process exits.
+func CleanupTempFiles(ctx context.Context) {
+	scratchMu.Lock()
+	var paths []string
+	for path := range pendingCacheEntries {
+		paths = append(paths, path)
+	}
+	scratchMu.Unlock()
+	for _, path := range paths {
+		abandonCacheEntry(ctx, path)
+	}
+
+	scratchMu.Lock()
+	dirs := scratchDirs
+	scratchDirs = map[string]struct{}{}
+	scratchMu.Unlock()
+	for dir := range dirs {
+		if err := file.RemoveAll(ctx, dir); err != nil {
+			log.Error.Printf("cleanup %s: %v", dir, err)
+		}
+	}
+}
+
+// parseScratchDirName parses a directory name generated by scratchDirName.
+func parseScratchDirName(name string) (host string, pid int, start time.Time, ok bool) {
+	i := strings.LastIndexByte(name, '-')
+	if i < 0 {
+		return
+	}
+	startNano, err := strconv.ParseInt(name[i+1:], 10, 64)
+	if err != nil {
+		return
+	}
+	name = name[:i]
+	if i = strings.LastIndexByte(name, '-'); i < 0 {
+		return
+	}
+	if pid, err = strconv.Atoi(name[i+1:]); err != nil {
+		return
+	}
+	return name[:i], pid, time.Unix(0, startNano), true
+}
+
+// isOrphanedScratchDir checks if the scratch directory "name" was created by a
+// process that no longer exists. The liveness of a process on another host
+// can't be checked, so its directory is assumed to be orphaned once it is older
+// than scratchMaxAge.
+func isOrphanedScratchDir(name string) bool {
+	host, pid, start, ok := parseScratchDirName(name)
+	if !ok {
+		return false
+	}
+	if myHost, err := os.Hostname(); err == nil && host == myHost {
+		if pid == os.Getpid() {
+			// The directory is ours, or it was created by an earlier process
+			// with the same pid.
+			return start.UnixNano() != scratchStartTime.UnixNano()
+		}
+		return syscall.Kill(pid, 0) == syscall.ESRCH
+	}
+	return time.Since(start) > scratchMaxAge
+}
+
+// sweepOrphanedTempDirs removes scratch directories under root that were
+// left behind by crashed processes.
+func sweepOrphanedTempDirs(ctx context.Context, root string) {
+	dir := file.Join(root, scratchSubdir)
+	l := file.List(ctx, dir, false)
+	for l.Scan() {
+		if !l.IsDir() {
+			continue
+		}
+		name := file.Base(strings.TrimSuffix(l.Path(), "/"))
+		if !isOrphanedScratchDir(name) {
+			continue
+		}
+		log.Printf("scratch: removing orphaned temp dir %s", l.Path())
+		removeOrphanedCacheEntries(ctx, l.Path())
+		if err := file.RemoveAll(ctx, l.Path()); err != nil {
+			log.Error.Printf("scratch: remove %s: %v", l.Path(), err)
+		}
+	}
+	if err := l.Err(); err != nil && !os.IsNotExist(err) {
+		log.Debug.Printf("scratch: list %s: %v", dir, err)
+	}
+}
+
+// removeOrphanedCacheEntries removes the unfinished cache entries listed in the
+// manifest in the scratch directory of a crashed process.
+func removeOrphanedCacheEntries(ctx context.Context, scratchDir string) {
+	l := file.List(ctx, pendingCacheManifestDir(scratchDir), true)
+	for l.Scan() {
+		if l.IsDir() {
+			continue
+		}
+		data, err := file.ReadFile(ctx, l.Path())
+		if err != nil {
+			log.Error.Printf("scratch: read %s: %v", l.Path(), err)
+			continue
+		}
+		log.Printf("scratch: removing unfinished cache entry %s", data)
+		if err := file.RemoveAll(ctx, string(data)); err != nil {
+			log.Error.Printf("scratch: remove %s: %v", data, err)
+		}
+	}
+}
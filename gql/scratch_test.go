@@ -0,0 +1,146 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/testutil"
+	"github.com/grailbio/testutil/expect"
+	"github.com/grailbio/testutil/h"
+)
+
+func TestScratchSpill(t *testing.T) {
+	localDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	remoteDir, cleanup2 := testutil.TempDir(t, "", "")
+	defer cleanup2()
+	ctx := context.Background()
+
+	cacheRoot, remoteScratchRoot = localDir, remoteDir
+	defer func() { remoteScratchRoot, localScratchMinFree = "", DefaultLocalScratchMinFree }()
+
+	localScratchMinFree = 0
+	path := newTempPath(ctx, "foo.btsv")
+	expect.That(t, path, h.HasPrefix(filepath.Join(localDir, scratchSubdir)))
+
+	localScratchMinFree = math.MaxInt64
+	path = newTempPath(ctx, "foo.btsv")
+	expect.That(t, path, h.HasPrefix(filepath.Join(remoteDir, scratchSubdir)))
+	expect.NoError(t, ioutil.WriteFile(path, []byte("blah"), 0600))
+
+	CleanupTempFiles(ctx)
+	_, err := os.Stat(path)
+	expect.True(t, os.IsNotExist(err))
+}
+
+func TestScratchSweep(t *testing.T) {
+	tempDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+
+	host, err := os.Hostname()
+	expect.NoError(t, err)
+	// A process with a pid this large can't exist.
+	dead := filepath.Join(tempDir, scratchSubdir, fmt.Sprintf("%s-%d-%d", host, math.MaxInt32, scratchStartTime.UnixNano()))
+	alive := filepath.Join(tempDir, scratchSubdir, scratchDirName())
+	// A live process is never swept, however old it is.
+	old := scratchStartTime.Add(-2 * scratchMaxAge).UnixNano()
+	oldAlive := filepath.Join(tempDir, scratchSubdir, fmt.Sprintf("%s-%d-%d", host, os.Getppid(), old))
+	// The liveness of a process on another host can't be checked, so its
+	// directory is swept only once it is old.
+	remoteOld := filepath.Join(tempDir, scratchSubdir, fmt.Sprintf("otherhost-%d-%d", os.Getpid(), old))
+	remoteNew := filepath.Join(tempDir, scratchSubdir, fmt.Sprintf("otherhost-%d-%d", os.Getpid(), scratchStartTime.UnixNano()))
+	for _, dir := range []string{dead, alive, oldAlive, remoteOld, remoteNew} {
+		expect.NoError(t, os.MkdirAll(dir, 0700))
+	}
+	sweepOrphanedTempDirs(ctx, tempDir)
+	for _, dir := range []string{dead, remoteOld} {
+		_, err = os.Stat(dir)
+		expect.True(t, os.IsNotExist(err), dir)
+	}
+	for _, dir := range []string{alive, oldAlive, remoteNew} {
+		_, err = os.Stat(dir)
+		expect.NoError(t, err, dir)
+	}
+}
+
+func TestSessionTempFiles(t *testing.T) {
+	tempDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	defer func(old string) { cacheRoot = old }(cacheRoot)
+	cacheRoot = tempDir
+
+	sess0, sess1 := NewSession(), NewSession()
+	path0 := newTempPath(withTempNamespace(ctx, sess0.temps), "foo.tsv")
+	path1 := newTempPath(withTempNamespace(ctx, sess1.temps), "foo.tsv")
+	expect.That(t, path0, h.Not(h.EQ(path1)))
+	for _, path := range []string{path0, path1} {
+		expect.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		expect.NoError(t, ioutil.WriteFile(path, []byte("blah"), 0600))
+	}
+
+	// A cache entry that is activated survives Close, but an unfinished one
+	// doesn't.
+	sctx := withTempNamespace(ctx, sess0.temps)
+	donePath, found := LookupCache(sctx, "done.tsv")
+	expect.False(t, found)
+	expect.NoError(t, ioutil.WriteFile(donePath, []byte("done"), 0600))
+	ActivateCache(sctx, "done.tsv", donePath)
+	failedPath, found := LookupCache(sctx, "failed.tsv")
+	expect.False(t, found)
+	expect.NoError(t, ioutil.WriteFile(failedPath, []byte("partial"), 0600))
+
+	// A materialized table is a temp file of the session. Materializing it
+	// again reuses the file.
+	src := NewSimpleTable([]Value{NewInt(1), NewInt(2)}, hash.String("TestSessionTempFiles"), TableAttrs{})
+	materialized := materializeTable(sctx, src, nil)
+	expect.That(t, materialized.dir, h.HasPrefix(filepath.Dir(path0)))
+	expect.True(t, materializeTable(sctx, src, nil) == materialized)
+
+	sess0.Close(ctx)
+	for _, path := range []string{path0, failedPath, materialized.dir} {
+		_, err := os.Stat(path)
+		expect.True(t, os.IsNotExist(err), path)
+	}
+	for _, path := range []string{path1, donePath} {
+		_, err := os.Stat(path)
+		expect.NoError(t, err)
+	}
+	path, found := LookupCache(ctx, "done.tsv")
+	expect.True(t, found)
+	expect.EQ(t, path, donePath)
+	// The lease on the failed entry is released.
+	_, leased := readCacheLease(ctx, "failed.tsv")
+	expect.False(t, leased)
+
+	sess1.Close(ctx)
+	_, err := os.Stat(path1)
+	expect.True(t, os.IsNotExist(err))
+}
+
+func TestScratchSweepCacheEntries(t *testing.T) {
+	tempDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+
+	host, err := os.Hostname()
+	expect.NoError(t, err)
+	dead := filepath.Join(tempDir, scratchSubdir, fmt.Sprintf("%s-%d-%d", host, math.MaxInt32, scratchStartTime.UnixNano()))
+	unfinished := filepath.Join(tempDir, "foo-unfinished.btsv")
+	expect.NoError(t, os.MkdirAll(unfinished, 0700))
+	expect.NoError(t, os.MkdirAll(pendingCacheManifestDir(dead), 0700))
+	expect.NoError(t, ioutil.WriteFile(filepath.Join(pendingCacheManifestDir(dead), "0"), []byte(unfinished), 0600))
+
+	sweepOrphanedTempDirs(ctx, tempDir)
+	for _, path := range []string{dead, unfinished} {
+		_, err = os.Stat(path)
+		expect.True(t, os.IsNotExist(err), path)
+	}
+}
@@ -0,0 +1,47 @@
+package gql_test
+
+import (
+	"testing"
+
+	"github.com/grailbio/gql/gql"
+	"github.com/grailbio/gql/gqltest"
+	"github.com/grailbio/testutil/expect"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetOps(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `t0 := table({s:"a", n:1}, {s:"b", n:2}, {s:"a", n:1}, {s:"c", n:3});
+t1 := table({s:"b", n:2}, {s:"d", n:4}, {s:"c", n:30})`, env)
+	expect.EQ(t, gqltest.ReadTable(gqltest.Eval(t, `union(t0, t1)`, env)),
+		[]string{"{s:a,n:1}", "{s:b,n:2}", "{s:c,n:3}", "{s:d,n:4}", "{s:c,n:30}"})
+	expect.EQ(t, gqltest.ReadTable(gqltest.Eval(t, `intersect(t0, t1)`, env)),
+		[]string{"{s:b,n:2}"})
+	expect.EQ(t, gqltest.ReadTable(gqltest.Eval(t, `except(t0, t1)`, env)),
+		[]string{"{s:a,n:1}", "{s:c,n:3}"})
+
+	// With a key, the first row with each key is kept.
+	expect.EQ(t, gqltest.ReadTable(gqltest.Eval(t, `union(t0, t1, key:=&s)`, env)),
+		[]string{"{s:a,n:1}", "{s:b,n:2}", "{s:c,n:3}", "{s:d,n:4}"})
+	expect.EQ(t, gqltest.ReadTable(gqltest.Eval(t, `intersect(t0, t1, key:=&s)`, env)),
+		[]string{"{s:b,n:2}", "{s:c,n:3}"})
+	expect.EQ(t, gqltest.ReadTable(gqltest.Eval(t, `except(t0, t1, key:=&s)`, env)),
+		[]string{"{s:a,n:1}"})
+	expect.EQ(t, gqltest.Eval(t, `except(t0, t0) | count()`, env).Int(nil), int64(0))
+}
+
+func TestSetOpsSpill(t *testing.T) {
+	old := gql.SetOpMaxRowsPerPartition
+	gql.SetOpMaxRowsPerPartition = 10
+	defer func() { gql.SetOpMaxRowsPerPartition = old }()
+
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `t0 := range(0, 100) | map({x: _ % 50});
+t1 := range(25, 75) | map({x: _})`, env)
+	expect.EQ(t, gqltest.Eval(t, `union(t0, t1) | count()`, env).Int(nil), int64(75))
+	expect.EQ(t, gqltest.Eval(t, `intersect(t0, t1) | count()`, env).Int(nil), int64(25))
+	expect.EQ(t, gqltest.Eval(t, `except(t0, t1) | count()`, env).Int(nil), int64(25))
+	assert.Equal(t,
+		gqltest.ReadTable(gqltest.Eval(t, `range(25, 50) | map({x: _})`, env)),
+		gqltest.ReadTable(gqltest.Eval(t, `intersect(t0, t1) | sort(&x)`, env)))
+}
@@ -0,0 +1,199 @@
+package gql
+
+// This file implements evaluation of a table-valued subexpression that appears
+// more than once in a statement, e.g., "t" in
+//
+//   t := read("x.tsv") | map({a:$x*2});
+//   join({a: t, b: t}, a.a==b.a)
+//
+// or "read(...) | filter(...)" written twice in one expression. Without
+// sharing, each occurrence scans the table separately, so the whole pipeline
+// that produces it runs twice.
+//
+// If Opts.ShareTableSubexprs is set, shareTableSubexprs finds table-valued
+// subexpressions that appear two or more times in a statement during analysis,
+// and wraps each occurrence in an ASTSharedTable. During evaluation, the
+// occurrences with the same AST hash evaluate to one sharedTable. Since the
+// table is known to be referenced at least twice, the first full scan of a
+// sharedTable materializes it in the cache, and all the scans read the
+// materialized table. Tables read from files and tables whose rows are in
+// memory are cheap to rescan, so they are not shared.
+
+import (
+	"context"
+	"sync"
+	"text/scanner"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/marshal"
+)
+
+// ASTSharedTable wraps a table-valued expression that appears more than once
+// in a statement. See the comment at the beginning of this file.
+type ASTSharedTable struct {
+	Expr ASTNode
+}
+
+var _ ASTNode = &ASTSharedTable{}
+
+// pos implements ASTNode.
+func (n *ASTSharedTable) pos() scanner.Position { return n.Expr.pos() }
+
+// String implements ASTNode.
+func (n *ASTSharedTable) String() string { return n.Expr.String() }
+
+// hash implements ASTNode. The wrapper doesn't change the value of the
+// expression, so it doesn't change the hash either.
+func (n *ASTSharedTable) hash(b *bindings) hash.Hash { return n.Expr.hash(b) }
+
+// eval implements ASTNode.
+func (n *ASTSharedTable) eval(ctx context.Context, env *bindings) Value {
+	memo := sharedTablesFromContext(ctx)
+	if memo == nil {
+		return n.Expr.eval(ctx, env)
+	}
+	h := n.Expr.hash(env)
+	memo.mu.Lock()
+	val, ok := memo.vals[h]
+	memo.mu.Unlock()
+	if ok {
+		return val
+	}
+	val = n.Expr.eval(ctx, env)
+	if val.Type() == TableType {
+		if t := val.Table(n); !isCheapToRescan(t) {
+			if _, ok := t.(*sharedTable); !ok {
+				val = NewTable(&sharedTable{Table: t, ast: n})
+			}
+		}
+	}
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+	if v, ok := memo.vals[h]; ok {
+		// Another goroutine evaluated the same expression concurrently.
+		return v
+	}
+	memo.vals[h] = val
+	return val
+}
+
+// sharedTables memoizes the values of ASTSharedTable nodes during the
+// evaluation of one statement. It is keyed by the AST hash.
+type sharedTables struct {
+	mu   sync.Mutex
+	vals map[hash.Hash]Value
+}
+
+type sharedTablesKey struct{}
+
+// withSharedTables returns a context for evaluating one statement. The
+// occurrences of a shared table-valued subexpression in the statement are
+// evaluated once.
+func withSharedTables(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sharedTablesKey{}, &sharedTables{vals: map[hash.Hash]Value{}})
+}
+
+// sharedTablesFromContext returns the memo attached by withSharedTables, or nil.
+func sharedTablesFromContext(ctx context.Context) *sharedTables {
+	if ctx == nil {
+		return nil
+	}
+	memo, _ := ctx.Value(sharedTablesKey{}).(*sharedTables)
+	return memo
+}
+
+// isCheapToRescan checks if scanning the table again costs about as much as
+// reading its materialized copy, i.e., the table is read from a file, or its
+// rows are in memory.
+func isCheapToRescan(t Table) bool {
+	switch t := t.(type) {
+	case *simpleTable, *nullTable, *TSVTable, *tsvPrunedTable, *btsvPrunedTable, *jsonTable, *bamTable, *versionedTable:
+		return true
+	case *rowTransformTable:
+		return isCheapToRescan(t.src)
+	}
+	return isMaterialized(t)
+}
+
+// sharedTable is a table that is read more than once in a statement. The first
+// full scan materializes the source table, and all the full scans read the
+// result. It has the same hash as the source.
+type sharedTable struct {
+	// Table is the source table.
+	Table
+	ast ASTNode
+
+	once   sync.Once
+	forced Table // materialized copy of Table. Set on the first full scan.
+}
+
+var _ Table = &sharedTable{}
+
+func (t *sharedTable) force(ctx context.Context) Table {
+	t.once.Do(func() {
+		Logf(t.ast, "materializing the table read by multiple references to %v", t.ast)
+		t.forced = forceTable(ctx, t.ast, t.Table, "btsv", "", 1)
+	})
+	return t.forced
+}
+
+// Scanner implements the Table interface.
+func (t *sharedTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	return t.force(ctx).Scanner(ctx, start, limit, total)
+}
+
+// Len implements the Table interface.
+func (t *sharedTable) Len(ctx context.Context, mode CountMode) int {
+	if mode == Exact {
+		return t.force(ctx).Len(ctx, mode)
+	}
+	return t.Table.Len(ctx, mode)
+}
+
+// Limit implements LimitableTable. A prefix of the table is computed from the
+// source, without materializing the whole table.
+func (t *sharedTable) Limit(ctx context.Context, n int) Table {
+	return limitTable(ctx, t.Table, n)
+}
+
+// Marshal implements the Table interface. The receiver of the table scans it,
+// so marshaling counts as a scan.
+func (t *sharedTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	t.force(ctx.ctx).Marshal(ctx, enc)
+}
+
+// shareTableSubexprs wraps the table-valued subexpressions that appear two or
+// more times under *nptr in ASTSharedTable nodes. Occurrences are compared by
+// their string representations here, and by their AST hashes during
+// evaluation. It doesn't look inside lambdas, blocks, or function bodies,
+// whose subexpressions may depend on local variables.
+func shareTableSubexprs(t *astTypes, nptr *ASTNode) {
+	var (
+		candidates []*ASTNode
+		counts     = map[string]int{}
+	)
+	var visit func(nptr *ASTNode)
+	visit = func(nptr *ASTNode) {
+		switch n := (*nptr).(type) {
+		case *ASTLambda, *ASTBlock, *ASTLiteral, *ASTSharedTable:
+			return
+		case *ASTFuncall, *ASTVarRef:
+			if t.getType(n).Type == TableType {
+				candidates = append(candidates, nptr)
+				counts[n.String()]++
+			}
+		}
+		for _, child := range astChildren(*nptr) {
+			visit(child)
+		}
+	}
+	visit(nptr)
+	for _, c := range candidates {
+		if counts[(*c).String()] < 2 {
+			continue
+		}
+		shared := &ASTSharedTable{Expr: *c}
+		t.addType(shared, t.getType(*c))
+		*c = shared
+	}
+}
@@ -0,0 +1,75 @@
+package gql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedTableSubexprs(t *testing.T) {
+	defer TestSetShareTableSubexprs(true)()
+	sess := newSession()
+	ctx := context.Background()
+	doEval(t, "t0 := table({a:1}, {a:2})", sess)
+	doEval(t, "t1 := t0 | map({b:$a*2})", sess)
+
+	assert.Equal(t,
+		[]string{"{b:2}", "{b:4}", "{b:4}", "{b:2}", "{b:4}"},
+		doReadTable(doEval(t, "flatten(table(t1, t1 | filter($b > 2), t1))", sess)))
+
+	// Both references to t1 yield the same table, and so do the two copies of
+	// the map expression.
+	for _, expr := range []string{
+		"table(t1, t1)",
+		"table(t0 | map({c:$a}), t0 | map({c:$a}))",
+	} {
+		sc := doEval(t, expr, sess).Table(nil).Scanner(ctx, 0, 1, 1)
+		var tables []Table
+		for sc.Scan() {
+			tables = append(tables, sc.Value().Table(nil))
+		}
+		require.Len(t, tables, 2, expr)
+		st, ok := tables[0].(*sharedTable)
+		require.True(t, ok, expr)
+		assert.True(t, tables[0] == tables[1], expr)
+		// The first full scan materializes the table, and the later scans
+		// read the materialized copy.
+		assert.Nil(t, st.forced, expr)
+		assert.Equal(t, 2, st.Len(ctx, Exact), expr)
+		forced := st.forced
+		assert.NotNil(t, forced, expr)
+		assert.Equal(t, 2, st.Len(ctx, Exact), expr)
+		assert.True(t, st.forced == forced, expr)
+	}
+
+	// A table used once, used in a lambda, read from a file, or stored in
+	// memory is not shared.
+	for _, expr := range []string{
+		"table(t1, t0 | map({c:$a}))",
+		"table(t0, t0)",
+		"table(read(`./testdata/data.tsv`), read(`./testdata/data.tsv`))",
+	} {
+		sc := doEval(t, expr, sess).Table(nil).Scanner(ctx, 0, 1, 1)
+		for sc.Scan() {
+			_, ok := sc.Value().Table(nil).(*sharedTable)
+			assert.False(t, ok, expr)
+		}
+	}
+	assert.Equal(t,
+		[]string{"{a:1,n:2}", "{a:2,n:2}"},
+		doReadTable(doEval(t, "t0 | map({a:$a, n:count(t1) + 0 * count(t1)})", sess)))
+}
+
+func TestSharedTableSubexprsDisabled(t *testing.T) {
+	defer TestSetShareTableSubexprs(false)()
+	sess := newSession()
+	ctx := context.Background()
+	doEval(t, "t0 := table({a:1}, {a:2})", sess)
+	sc := doEval(t, "table(t0 | map({c:$a}), t0 | map({c:$a}))", sess).Table(nil).Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		_, ok := sc.Value().Table(nil).(*sharedTable)
+		assert.False(t, ok)
+	}
+}
@@ -0,0 +1,62 @@
+package gql_test
+
+import (
+	"testing"
+
+	"github.com/grailbio/gql/gqltest"
+	"github.com/grailbio/testutil/expect"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShuffle(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `tbl := table({x:0}, {x:1}, {x:2}, {x:3}, {x:4}, {x:5}, {x:6}, {x:7}, {x:8}, {x:9})`, env)
+	orig := gqltest.ReadTable(gqltest.Eval(t, `tbl`, env))
+	shuffled := gqltest.ReadTable(gqltest.Eval(t, `tbl | shuffle(seed:=1)`, env))
+	assert.ElementsMatch(t, orig, shuffled)
+	assert.NotEqual(t, orig, shuffled)
+	expect.EQ(t, gqltest.ReadTable(gqltest.Eval(t, `tbl | shuffle(seed:=1)`, env)), shuffled)
+	expect.EQ(t, gqltest.ReadTable(gqltest.Eval(t, `tbl | shuffle(seed:=1) | sort($x)`, env)), orig)
+}
+
+func TestSplit(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `tbl := table(
+		{id:0, label:"a"}, {id:1, label:"a"}, {id:2, label:"a"}, {id:3, label:"a"}, {id:4, label:"a"},
+		{id:5, label:"b"}, {id:6, label:"b"}, {id:7, label:"b"}, {id:8, label:"b"}, {id:9, label:"b"});
+s := tbl | split({train:0.8, valid:0.2}, stratify:=&label, seed:=1)`, env)
+	expect.EQ(t, gqltest.Eval(t, `s.train | count()`, env).Int(nil), int64(8))
+	expect.EQ(t, gqltest.Eval(t, `s.valid | count()`, env).Int(nil), int64(2))
+	// Each label is split 80:20.
+	expect.EQ(t, gqltest.Eval(t, `s.valid | filter($label=="b") | count()`, env).Int(nil), int64(1))
+	expect.EQ(t, gqltest.Eval(t, `s.valid | filter($label=="a") | count()`, env).Int(nil), int64(1))
+	// The parts are disjoint, and together they cover the table.
+	assert.ElementsMatch(t,
+		gqltest.ReadTable(gqltest.Eval(t, `tbl`, env)),
+		append(gqltest.ReadTable(gqltest.Eval(t, `s.train`, env)), gqltest.ReadTable(gqltest.Eval(t, `s.valid`, env))...))
+	// The split is reproducible.
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `(tbl | split({train:0.8, valid:0.2}, stratify:=&label, seed:=1)).valid`, env)),
+		gqltest.ReadTable(gqltest.Eval(t, `s.valid`, env)))
+	expect.EQ(t, gqltest.Eval(t, `(tbl | split({a:1, b:1, c:2})).c | count()`, env).Int(nil), int64(5))
+	assert.Panics(t, func() { gqltest.Eval(t, `tbl | split({a:"x"})`, env) })
+}
+
+func TestSampleBy(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `tbl := table(
+		{id:0, arm:"a"}, {id:1, arm:"b"}, {id:2, arm:"a"}, {id:3, arm:"a"}, {id:4, arm:"c"},
+		{id:5, arm:"a"}, {id:6, arm:"b"}, {id:7, arm:"a"}, {id:8, arm:"b"}, {id:9, arm:"b"});
+s := tbl | sample_by(group:=&arm, n:=2, seed:=1)`, env)
+	expect.EQ(t, gqltest.Eval(t, `s | count()`, env).Int(nil), int64(5))
+	expect.EQ(t, gqltest.Eval(t, `s | filter($arm=="a") | count()`, env).Int(nil), int64(2))
+	expect.EQ(t, gqltest.Eval(t, `s | filter($arm=="b") | count()`, env).Int(nil), int64(2))
+	// A group smaller than n is kept in full.
+	expect.EQ(t, gqltest.ReadTable(gqltest.Eval(t, `s | filter($arm=="c")`, env)), []string{"{id:4,arm:c}"})
+	// The rows keep their order, and the sample is reproducible.
+	sampled := gqltest.ReadTable(gqltest.Eval(t, `s`, env))
+	expect.EQ(t, gqltest.ReadTable(gqltest.Eval(t, `s | sort($id)`, env)), sampled)
+	expect.EQ(t, gqltest.ReadTable(gqltest.Eval(t, `tbl | sample_by(group:=&arm, n:=2, seed:=1)`, env)), sampled)
+	expect.EQ(t, gqltest.Eval(t, `tbl | sample_by(group:=&arm, n:=100) | count()`, env).Int(nil), int64(10))
+	assert.Panics(t, func() { gqltest.Eval(t, `tbl | sample_by(group:=&arm, n:=0)`, env) })
+}
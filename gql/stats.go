@@ -0,0 +1,218 @@
+package gql
+
+// This file implements the probability distributions used by the statistical
+// test builtins.
+
+import (
+	"math"
+	"sort"
+)
+
+// normalSF computes P(Z > z) for the standard normal distribution.
+func normalSF(z float64) float64 {
+	return 0.5 * math.Erfc(z/math.Sqrt2)
+}
+
+// studentTTwoSidedP computes the two-sided p-value P(|T| > |t|) for Student's
+// t distribution with df degrees of freedom.
+func studentTTwoSidedP(t, df float64) float64 {
+	if math.IsNaN(t) || math.IsNaN(df) || df <= 0 {
+		return math.NaN()
+	}
+	if math.IsInf(t, 0) {
+		return 0
+	}
+	return regIncBeta(df/2, 0.5, df/(df+t*t))
+}
+
+// chiSquareSF computes P(X > x) for the chi-square distribution with df
+// degrees of freedom.
+func chiSquareSF(x, df float64) float64 {
+	if math.IsNaN(x) || df <= 0 {
+		return math.NaN()
+	}
+	if x <= 0 {
+		return 1
+	}
+	return 1 - regIncGamma(df/2, x/2)
+}
+
+// lbeta computes log(B(a, b)).
+func lbeta(a, b float64) float64 {
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	return la + lb - lab
+}
+
+// regIncBeta computes the regularized incomplete beta function I_x(a, b).
+func regIncBeta(a, b, x float64) float64 {
+	switch {
+	case x <= 0:
+		return 0
+	case x >= 1:
+		return 1
+	}
+	front := math.Exp(math.Log(x)*a + math.Log(1-x)*b - lbeta(a, b))
+	// The continued fraction converges quickly for x < (a+1)/(a+b+2). Use the
+	// symmetry I_x(a,b) = 1-I_{1-x}(b,a) otherwise.
+	if x < (a+1)/(a+b+2) {
+		return front * betaContinuedFraction(a, b, x) / a
+	}
+	return 1 - front*betaContinuedFraction(b, a, 1-x)/b
+}
+
+// betaContinuedFraction evaluates the continued fraction for the incomplete
+// beta function using the modified Lentz's method.
+func betaContinuedFraction(a, b, x float64) float64 {
+	const (
+		maxIter = 300
+		eps     = 1e-15
+		tiny    = 1e-300
+	)
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= maxIter; m++ {
+		fm := float64(m)
+		// Even step.
+		num := fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+		// Odd step.
+		num = -(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < eps {
+			break
+		}
+	}
+	return h
+}
+
+// regIncGamma computes the regularized lower incomplete gamma function P(a, x).
+func regIncGamma(a, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	lga, _ := math.Lgamma(a)
+	if x < a+1 {
+		// Series expansion.
+		sum, term := 1/a, 1/a
+		for n := 1; n < 1000; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*1e-15 {
+				break
+			}
+		}
+		return sum * math.Exp(-x+a*math.Log(x)-lga)
+	}
+	// Continued fraction for Q(a, x), using the modified Lentz's method.
+	const tiny = 1e-300
+	b := x + 1 - a
+	c, d := 1/tiny, 1/b
+	h := d
+	for i := 1; i < 1000; i++ {
+		an := -float64(i) * (float64(i) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < 1e-15 {
+			break
+		}
+	}
+	return 1 - math.Exp(-x+a*math.Log(x)-lga)*h
+}
+
+// logChoose computes log(n choose k).
+func logChoose(n, k int) float64 {
+	ln, _ := math.Lgamma(float64(n + 1))
+	lk, _ := math.Lgamma(float64(k + 1))
+	lnk, _ := math.Lgamma(float64(n - k + 1))
+	return ln - lk - lnk
+}
+
+// fisherExactTest computes the two-sided p-value of Fisher's exact test for the
+// 2x2 table [[a, b], [c, d]]. The p-value is the sum of the probabilities of
+// the tables with the same margins that are no more likely than the observed
+// one.
+func fisherExactTest(a, b, c, d int) float64 {
+	row1, col1, n := a+b, a+c, a+b+c+d
+	logP := func(x int) float64 {
+		return logChoose(row1, x) + logChoose(n-row1, col1-x) - logChoose(n, col1)
+	}
+	lo := col1 - (n - row1)
+	if lo < 0 {
+		lo = 0
+	}
+	hi := row1
+	if col1 < hi {
+		hi = col1
+	}
+	observed := logP(a)
+	p := 0.0
+	for x := lo; x <= hi; x++ {
+		// The relative tolerance absorbs rounding errors in logP.
+		if lp := logP(x); lp <= observed+1e-7 {
+			p += math.Exp(lp)
+		}
+	}
+	return math.Min(p, 1)
+}
+
+// benjaminiHochberg computes the Benjamini-Hochberg adjusted p-values. NaN
+// values are ignored, and they remain NaN.
+func benjaminiHochberg(pvals []float64) []float64 {
+	var order []int
+	for i, p := range pvals {
+		if !math.IsNaN(p) {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return pvals[order[i]] < pvals[order[j]] })
+	adj := make([]float64, len(pvals))
+	for i := range adj {
+		adj[i] = math.NaN()
+	}
+	m := float64(len(order))
+	min := 1.0
+	for r := len(order) - 1; r >= 0; r-- {
+		i := order[r]
+		v := pvals[i] * m / float64(r+1)
+		if v < min {
+			min = v
+		}
+		adj[i] = min
+	}
+	return adj
+}
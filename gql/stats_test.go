@@ -0,0 +1,77 @@
+package gql
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/grailbio/gql/symbol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatDistributions(t *testing.T) {
+	assert.InDelta(t, 0.05, 2*normalSF(1.959964), 1e-6)
+	assert.InDelta(t, 0.05, studentTTwoSidedP(2.306004, 8), 1e-6)
+	assert.InDelta(t, 0.05, studentTTwoSidedP(-2.306004, 8), 1e-6)
+	assert.InDelta(t, 0.05, chiSquareSF(3.841459, 1), 1e-6)
+	assert.InDelta(t, 0.05, chiSquareSF(11.070498, 5), 1e-6)
+	assert.InDelta(t, 34.0/70, fisherExactTest(3, 1, 1, 3), 1e-12)
+	assert.InDelta(t, 1.0, fisherExactTest(1, 1, 1, 1), 1e-12)
+
+	adj := benjaminiHochberg([]float64{0.01, 0.04, math.NaN(), 0.03, 0.005})
+	assert.InDelta(t, 0.02, adj[0], 1e-12)
+	assert.InDelta(t, 0.04, adj[1], 1e-12)
+	assert.True(t, math.IsNaN(adj[2]))
+	assert.InDelta(t, 0.04, adj[3], 1e-12)
+	assert.InDelta(t, 0.02, adj[4], 1e-12)
+}
+
+// statField extracts a float field from the result of a statistical test.
+func statField(t *testing.T, v Value, name string) float64 {
+	f, ok := v.Struct(nil).Value(symbol.Intern(name))
+	assert.True(t, ok, name)
+	return f.Float(nil)
+}
+
+func TestStatTests(t *testing.T) {
+	env := newSession()
+	doEval(t, `samples := table(
+		{g:"x", v:1}, {g:"x", v:2}, {g:"x", v:3}, {g:"x", v:4}, {g:"x", v:5}, {g:"x", v:NA},
+		{g:"y", v:6}, {g:"y", v:7}, {g:"y", v:8}, {g:"y", v:9}, {g:"y", v:10})`, env)
+
+	r := doEval(t, `samples | t_test(value:=&v, group:=&g)`, env)
+	assert.InDelta(t, -5.0, statField(t, r, "statistic"), 1e-12)
+	assert.InDelta(t, 8.0, statField(t, r, "df"), 1e-12)
+	assert.InDelta(t, 0.001052826, statField(t, r, "pvalue"), 1e-8)
+	assert.Equal(t, "x", doEval(t, `(samples | t_test(value:=&v, group:=&g)).group1`, env).Str(nil))
+
+	r = doEval(t, `table({g:"x", v:1}, {g:"x", v:2}, {g:"x", v:3}, {g:"y", v:4}, {g:"y", v:5}, {g:"y", v:6}) | wilcoxon(value:=&v, group:=&g)`, env)
+	assert.InDelta(t, 0.0, statField(t, r, "statistic"), 1e-12)
+	assert.InDelta(t, 0.0808556, statField(t, r, "pvalue"), 1e-6)
+
+	r = doEval(t, `fisher_exact(3, 1, 1, 3)`, env)
+	assert.InDelta(t, 9.0, statField(t, r, "odds_ratio"), 1e-12)
+	assert.InDelta(t, 34.0/70, statField(t, r, "pvalue"), 1e-12)
+
+	r = doEval(t, `table({a:1, b:"u"}, {a:1, b:"u"}, {a:2, b:"v"}, {a:2, b:"v"}) | chisq(&a, &b)`, env)
+	assert.InDelta(t, 4.0, statField(t, r, "statistic"), 1e-12)
+	assert.InDelta(t, 1.0, statField(t, r, "df"), 1e-12)
+	assert.InDelta(t, 0.04550026, statField(t, r, "pvalue"), 1e-7)
+
+	var padj []float64
+	sc := doEval(t, `table({p:0.01}, {p:0.04}, {p:NA}, {p:0.03}, {p:0.005}) | bh_adjust(&p)`, env).Table(nil).Scanner(context.Background(), 0, 1, 1)
+	for sc.Scan() {
+		v, ok := sc.Value().Struct(nil).Value(symbol.Intern("padj"))
+		assert.True(t, ok)
+		if v.Null() != NotNull {
+			padj = append(padj, math.NaN())
+		} else {
+			padj = append(padj, v.Float(nil))
+		}
+	}
+	assert.Len(t, padj, 5)
+	assert.InDelta(t, 0.04, padj[3], 1e-12)
+	assert.True(t, math.IsNaN(padj[2]))
+
+	assert.Panics(t, func() { doEval(t, `table({g:1, v:1}, {g:2, v:2}, {g:3, v:3}) | t_test(value:=&v, group:=&g)`, env) })
+}
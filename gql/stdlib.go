@@ -0,0 +1,185 @@
+package gql
+
+// This file implements the registry of standard library scripts. A library is
+// a GQL script that defines functions and variables. It is registered by
+// RegisterStdlib, typically from an init function in a Go package, and it is
+// evaluated in a session when the session first refers to one of the names
+// defined in the library.
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+)
+
+// stdlib is a library registered by RegisterStdlib.
+type stdlib struct {
+	name    string
+	version string
+	src     string
+	// Names defined by the toplevel statements in the library.
+	symbols []symbol.ID
+	// loaded is set when the library is loaded by a session. Guarded by
+	// stdlibMu.
+	loaded bool
+}
+
+var (
+	stdlibMu sync.Mutex
+	// stdlibs maps a library name to the library.
+	stdlibs = map[string]*stdlib{}
+	// stdlibSymbols maps a name to the library that defines it.
+	stdlibSymbols = map[symbol.ID]*stdlib{}
+)
+
+var stdlibVersionRE = regexp.MustCompile(`(?m)^//\s*version:\s*(\S+)`)
+
+// RegisterStdlib registers a library script. The library is evaluated in a
+// session when the session first refers to one of the variables or functions
+// defined at its toplevel. If a library with the same name is already
+// registered, it is replaced. If two libraries define the same name, the one
+// registered later is used.
+//
+// The library version, listed by stdlib(), is taken from a line of form "//
+// version: xxx" in src. If src has no such line, the version is a hash of src.
+//
+// It panics if src fails to parse. This function may be called before
+// gql.Init().
+func RegisterStdlib(name, src string) {
+	statements, err := parse(name, []byte(src))
+	if err != nil {
+		log.Panicf("RegisterStdlib %s: %v", name, err)
+	}
+	lib := &stdlib{name: name, src: src}
+	if m := stdlibVersionRE.FindStringSubmatch(src); m != nil {
+		lib.version = m[1]
+	} else {
+		h := hash.String(src)
+		lib.version = fmt.Sprintf("%x", h[:4])
+	}
+	for _, st := range statements {
+		if st.LoadPath != "" {
+			log.Panicf("RegisterStdlib %s: load statement is not allowed in a library", name)
+		}
+		if st.LHS != symbol.Invalid {
+			lib.symbols = append(lib.symbols, st.LHS)
+		}
+	}
+
+	stdlibMu.Lock()
+	defer stdlibMu.Unlock()
+	if old, ok := stdlibs[name]; ok {
+		for _, sym := range old.symbols {
+			if stdlibSymbols[sym] == old {
+				delete(stdlibSymbols, sym)
+			}
+		}
+	}
+	stdlibs[name] = lib
+	for _, sym := range lib.symbols {
+		stdlibSymbols[sym] = lib
+	}
+}
+
+// lookupStdlib finds the library that defines the given name. It returns nil
+// if not found.
+func lookupStdlib(sym symbol.ID) *stdlib {
+	stdlibMu.Lock()
+	lib := stdlibSymbols[sym]
+	stdlibMu.Unlock()
+	return lib
+}
+
+// loadStdlibs evaluates the libraries that define the names referenced, but not
+// defined, in the statements, unless they have been loaded in the session
+// already.
+func (s *Session) loadStdlibs(ctx context.Context, statements []ASTStatementOrLoad) {
+	var libs []*stdlib
+	s.mu.Lock()
+	for i := range statements {
+		if statements[i].Expr == nil {
+			continue
+		}
+		visitRawASTTree(&statements[i].Expr, func(nptr *ASTNode) bool {
+			ref, ok := (*nptr).(*ASTVarRef)
+			if !ok {
+				return true
+			}
+			if _, ok := s.aiEnv.Lookup(ref.Var); ok {
+				return true
+			}
+			lib := lookupStdlib(ref.Var)
+			if lib == nil || s.loadedStdlibs[lib.name] {
+				return true
+			}
+			if s.loadedStdlibs == nil {
+				s.loadedStdlibs = map[string]bool{}
+			}
+			s.loadedStdlibs[lib.name] = true
+			libs = append(libs, lib)
+			return true
+		})
+	}
+	s.mu.Unlock()
+	for _, lib := range libs {
+		stdlibMu.Lock()
+		lib.loaded = true
+		stdlibMu.Unlock()
+		// Parse the library again, since analysis modifies the syntax tree.
+		statements, err := parse(lib.name, []byte(lib.src))
+		if err != nil {
+			log.Panicf("load library %s: %v", lib.name, err)
+		}
+		log.Debug.Printf("loading library %s (version %s)", lib.name, lib.version)
+		s.EvalStatements(ctx, statements)
+	}
+}
+
+func init() {
+	RegisterBuiltinFunc("stdlib",
+		`
+    stdlib()
+
+Stdlib lists the registered library scripts. It returns a table with the
+following columns:
+
+ - Column 'name' is the name of the library.
+ - Column 'version' is the version of the library.
+ - Column 'symbols' is a comma-separated list of the names the library defines.
+ - Column 'loaded' is true if the library has been loaded by a session in
+   this process.
+
+A library is loaded when an expression first refers to one of its names.`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			stdlibMu.Lock()
+			defer stdlibMu.Unlock()
+			libs := make([]*stdlib, 0, len(stdlibs))
+			for _, lib := range stdlibs {
+				libs = append(libs, lib)
+			}
+			sort.Slice(libs, func(i, j int) bool { return libs[i].name < libs[j].name })
+			rows := make([]Value, len(libs))
+			h := hash.String("stdlib")
+			for i, lib := range libs {
+				names := make([]string, len(lib.symbols))
+				for j, sym := range lib.symbols {
+					names[j] = sym.Str()
+				}
+				rows[i] = NewStruct(NewSimpleStruct(
+					StructField{symbol.Name, NewString(lib.name)},
+					StructField{symbol.Version, NewString(lib.version)},
+					StructField{symbol.Symbols, NewString(strings.Join(names, ","))},
+					StructField{symbol.Loaded, NewBool(lib.loaded)}))
+				h = h.Merge(rows[i].Hash())
+			}
+			return NewTable(NewSimpleTable(rows, h, TableAttrs{Name: "stdlib"}))
+		},
+		func(ast ASTNode, _ []AIArg) AIType { return AITableType })
+}
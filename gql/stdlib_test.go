@@ -0,0 +1,32 @@
+package gql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStdlib(t *testing.T) {
+	sess := newSession()
+	RegisterStdlib("stdlibtest", `// version: 1.2
+func stdlib_test_double(x) x * 2;
+stdlib_test_k := 7`)
+	RegisterStdlib("stdlibtest2", "func stdlib_test_triple(x) stdlib_test_double(x) + x")
+
+	lib := func(name string) []string {
+		return doReadTable(doEval(t, `stdlib() | filter($name == "`+name+`")`, sess))
+	}
+	assert.Equal(t, []string{"{name:stdlibtest,version:1.2,symbols:stdlib_test_double,stdlib_test_k,loaded:false}"}, lib("stdlibtest"))
+
+	// The library is loaded on first use of one of its names. A library may use
+	// another library.
+	assert.Equal(t, int64(21), doEval(t, "stdlib_test_triple(stdlib_test_k)", sess).Int(nil))
+	assert.Equal(t, []string{"{name:stdlibtest,version:1.2,symbols:stdlib_test_double,stdlib_test_k,loaded:true}"}, lib("stdlibtest"))
+
+	// A library can be replaced. A session that has loaded the old version keeps
+	// using it.
+	RegisterStdlib("stdlibtest", "func stdlib_test_double(x) x * 20; stdlib_test_k := 7")
+	assert.Equal(t, int64(14), doEval(t, "stdlib_test_double(stdlib_test_k)", sess).Int(nil))
+	assert.Equal(t, int64(140), doEval(t, "stdlib_test_double(stdlib_test_k)", newSession()).Int(nil))
+	assert.Regexp(t, "^{name:stdlibtest,version:[0-9a-f]{8},", lib("stdlibtest")[0])
+}
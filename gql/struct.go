@@ -215,6 +215,9 @@ func marshalStruct(v Struct, ctx MarshalContext, enc *marshal.Encoder) {
 // unmarshalStruct reconstructs a struct from bytestream produced by MarshalGOB.
 func unmarshalStruct(ctx UnmarshalContext, dec *marshal.Decoder) Struct {
 	n := int(dec.Varint())
+	if n < 0 || n > dec.Len() { // Each field takes at least two bytes.
+		log.Panicf("unmarshal struct: corrupt field count %d, %d bytes remain", n, dec.Len())
+	}
 	switch {
 	case n <= 2:
 		v := &simpleStruct2Impl{
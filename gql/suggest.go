@@ -0,0 +1,126 @@
+package gql
+
+// This file implements the "did you mean" suggestions shown in the errors for
+// unknown variables and columns.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/grailbio/gql/symbol"
+)
+
+// maxListedColumns is the max number of column names listed in a "column not
+// found" error when no column is similar to the missing one.
+const maxListedColumns = 20
+
+// nameDistance computes the edit distance between the two names, ignoring
+// case. An insertion, deletion, substitution, or transposition of two adjacent
+// characters counts as one edit, so "strat" is at distance one from "start".
+func nameDistance(a, b string) int {
+	ra, rb := []rune(strings.ToLower(a)), []rune(strings.ToLower(b))
+	// d[i][j] is the distance between ra[:i] and rb[:j].
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, minInt(d[i][j-1]+1, d[i-1][j-1]+cost))
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// suggestName finds the candidate most similar to name. It returns "" if no
+// candidate is close enough to be a plausible typo. Ties are broken by the
+// lexicographic order of the candidates.
+func suggestName(name string, candidates []string) string {
+	maxDist := len([]rune(name)) / 3
+	if maxDist < 1 {
+		maxDist = 1
+	}
+	best, bestDist := "", maxDist+1
+	for _, c := range candidates {
+		if c == name {
+			continue
+		}
+		dist := nameDistance(name, c)
+		if dist < bestDist || (dist == bestDist && c < best) {
+			best, bestDist = c, dist
+		}
+	}
+	return best
+}
+
+// symbolNames converts the symbols to strings, omitting the internal ones,
+// e.g., "_".
+func symbolNames(syms []symbol.ID) []string {
+	names := make([]string, 0, len(syms))
+	for _, sym := range syms {
+		if sym == symbol.AnonRow || sym == symbol.AnonAcc || sym == symbol.AnonVal {
+			continue
+		}
+		names = append(names, sym.Str())
+	}
+	return names
+}
+
+// variableNotFoundError produces the message for a reference to an unknown
+// variable. Arg vars lists the variables visible at the reference. Arg dump,
+// called only if Opts.VerboseErrors is set, describes all the bindings.
+func variableNotFoundError(name symbol.ID, vars []symbol.ID, dump func() string) string {
+	msg := fmt.Sprintf("variable '%s' not found", name.Str())
+	if s := suggestName(name.Str(), symbolNames(vars)); s != "" {
+		msg += fmt.Sprintf("; did you mean '%s'?", s)
+	}
+	if verboseErrors {
+		msg += "\nbindings are:\n" + dump()
+	}
+	return msg
+}
+
+// columnNotFoundHint produces the suffix of the message for a reference to a
+// column missing in row st. It suggests the most similar column, or lists the
+// columns if none is similar. The row contents are shown only if
+// Opts.VerboseErrors is set.
+func columnNotFoundHint(col symbol.ID, st Struct) string {
+	names := make([]string, st.Len())
+	for fi := range names {
+		names[fi] = st.Field(fi).Name.Str()
+	}
+	var msg string
+	if s := suggestName(col.Str(), names); s != "" {
+		msg = fmt.Sprintf("; did you mean '%s'?", s)
+	} else {
+		sorted := append([]string(nil), names...)
+		sort.Strings(sorted)
+		if len(sorted) > maxListedColumns {
+			sorted = append(sorted[:maxListedColumns], "...")
+		}
+		msg = fmt.Sprintf("; columns are: %s", strings.Join(sorted, ", "))
+	}
+	if verboseErrors {
+		msg += fmt.Sprintf("\nrow contents are: %v", NewStruct(st))
+	}
+	return msg
+}
@@ -32,6 +32,7 @@ type stringListNode struct {
   structField ASTStructLiteralField
   structFields []ASTStructLiteralField
   paramVals []ASTParamVal
+  legacyBody legacyFunctionBody
 }
 
 %token <stringNode> tokIdent tokRegex
@@ -39,10 +40,11 @@ type stringListNode struct {
 %token <expr> tokOrOr tokAndAnd tokAssign
 %token <expr> tokEQEQ tokEQOrRhsNull tokEQOrLhsNull tokEQOrBothNull
 %token <expr> tokNE tokLEQ tokGEQ '>' '<'
-%token <pos> '|' '{' '$' '&' tokFunc tokLoad tokCond tokIf tokElse
+%token <expr> tokGTNotNull tokGEQNotNull tokLTNotNull tokLEQNotNull
+%token <pos> '|' '{' '}' '(' ')' '$' '&' tokFunc tokLoad tokMatview tokConst tokCond tokIf tokElse
 %type <statementOrLoad> loadStatement
 %type <statementsOrLoads> loadStatements
-%type <statements> legacyFunctionBlock
+%type <legacyBody> legacyFunctionBlock
 %type <statement> assignment toplevelStatement blockStatement
 %type <statements> toplevelStatements blockStatements
 %type <expr> expr term block
@@ -54,7 +56,7 @@ type stringListNode struct {
 %left tokOrOr
 %left tokAndAnd
 %left '|'
-%left '<' '>' tokLEQ tokGEQ tokNE tokEQEQ tokEQOrBothNull tokEQOrRhsNull tokEQOrLhsNull
+%left '<' '>' tokLEQ tokGEQ tokNE tokEQEQ tokEQOrBothNull tokEQOrRhsNull tokEQOrLhsNull tokGTNotNull tokGEQNotNull tokLTNotNull tokLEQNotNull
 %left '+' '-' '^'
 %left '*' '/' '%'
 %left unary
@@ -82,6 +84,7 @@ toplevelStatements: toplevelStatement { $$ = []ASTStatement{$1} }
 
 toplevelStatement: assignment
 | tokFunc tokIdent '(' paramNameList ')' expr {$$ = NewASTStatement($1, $2.str, NewASTLambda($1, $4.str, $6))}
+| tokMatview tokIdent tokAssign expr {$$ = NewASTMatview($1, $2.str, $4)}
 | expr { $$ = ASTStatement{Expr: $1} }
 
 
@@ -91,9 +94,10 @@ optionalSemicolon: /*empty*/
 loadStatements: loadStatement { $$ = []ASTStatementOrLoad{$1} }
 | loadStatements ';' loadStatement { $$ = append($1, $3) }
 
-loadStatement: tokLoad tokString { $$ = ASTStatementOrLoad{LoadPath: $2.(*ASTLiteral).Literal.Str(nil)} }
+loadStatement: tokLoad tokString { $$ = ASTStatementOrLoad{ASTStatement: ASTStatement{Pos: $1}, LoadPath: $2.(*ASTLiteral).Literal.Str(nil)} }
 
 assignment: tokIdent tokAssign expr { $$ = ASTStatement{Pos:$1.pos, LHS: symbol.Intern($1.str), Expr:$3} }
+| tokConst tokIdent tokAssign expr { $$ = ASTStatement{Pos:$1, LHS: symbol.Intern($2.str), Expr:$4, Const: true} }
 
 // '{ ... }'. A block must start with an assignment statement to distinguish between a block and a struct literal.
 block: '{' blockStatements ';' expr optionalSemicolon '}' {$$ = &ASTBlock{Pos: $1, Statements: append($2, ASTStatement{Pos: $4.pos(), Expr: $4})}}
@@ -103,11 +107,12 @@ blockStatements: blockStatement { $$ = []ASTStatement{$1} }
 
 blockStatement:  assignment
 | tokFunc tokIdent '(' paramNameList ')' expr {$$ = NewASTStatement($1, $2.str, NewASTLambda($1, $4.str, $6))}
+| tokMatview tokIdent tokAssign expr {$$ = NewASTMatview($1, $2.str, $4)}
 
 // Legacy lambda of form "func(args) { expr... }". The "expr..." part doesn't
 // need to start with an assignment, to keep backward compatibility.
-legacyFunctionBlock: block { $$ = []ASTStatement{{Pos:$1.pos(), Expr: $1}} }
-| '{' expr optionalSemicolon '}' { $$ = []ASTStatement{{Pos: $1, Expr: $2}} }
+legacyFunctionBlock: block { $$ = legacyFunctionBody{statements: []ASTStatement{{Pos:$1.pos(), Expr: $1}}} }
+| '{' expr optionalSemicolon '}' { $$ = legacyFunctionBody{statements: []ASTStatement{{Pos: $1, Expr: $2}}, bare: true, open: $1, close: $4} }
 
 expr: term
 | expr '(' paramList ')' { $$ = NewASTFuncall($1, $3) }
@@ -128,10 +133,14 @@ expr: term
 | expr tokGEQ expr { $$ = NewASTBuiltinFuncall($1.pos(), builtinGEValue, $1, $3) }
 | expr '<' expr { $$ = NewASTBuiltinFuncall($1.pos(), builtinGTValue, $3, $1) }
 | expr tokLEQ expr { $$ = NewASTBuiltinFuncall($1.pos(), builtinGEValue, $3, $1) }
+| expr tokGTNotNull expr { $$ = NewASTBuiltinFuncall($1.pos(), builtinGTNotNullValue, $1, $3) }
+| expr tokGEQNotNull expr { $$ = NewASTBuiltinFuncall($1.pos(), builtinGENotNullValue, $1, $3) }
+| expr tokLTNotNull expr { $$ = NewASTBuiltinFuncall($1.pos(), builtinGTNotNullValue, $3, $1) }
+| expr tokLEQNotNull expr { $$ = NewASTBuiltinFuncall($1.pos(), builtinGENotNullValue, $3, $1) }
 | '-' expr %prec unary { $$ = NewASTBuiltinFuncall($2.pos(), builtinNegateValue, $2) }
 | '!' expr %prec unary { $$ = NewASTBuiltinFuncall($2.pos(), builtinNotValue, $2) }
 | expr '.' tokIdent %prec deref { $$ = NewASTStructFieldRef($1, $3.str) }
-| tokFunc '(' paramNameList ')' legacyFunctionBlock {$$ = NewASTLambda($1, $3.str, &ASTBlock{Pos: $1, Statements:$5})}
+| tokFunc '(' paramNameList ')' legacyFunctionBlock {$$ = newLegacyASTLambda($1, $2, $3.str, $4, $5)}
 | '|' paramNameList '|' expr {$$ = NewASTLambda($1, $2.str, $4) }
 | tokCond '(' expr ',' expr ',' expr ')' { $$ = &ASTCondOp{Pos:$1, Cond:$3, Then:$5, Else:$7} }
 | tokIf expr expr tokElse expr {$$ = &ASTCondOp{Pos:$1, Cond:$2, Then:$3, Else:$5}}
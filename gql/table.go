@@ -24,6 +24,18 @@ type TableAttrs struct {
 	Columns []TSVColumn
 	// Description can be any string.
 	Description string
+	// Version is the S3 object version that the contents were read from. It is
+	// set only for tables created by read(..., version_id:=...) or
+	// read(..., as_of:=...).
+	Version string
+	// Metadata is the set of "##key=value" lines at the beginning of a TSV file,
+	// e.g., ones written by write(..., metadata:=...).
+	Metadata map[string]string
+	// Coercions counts, for each column of a TSV file, the cells whose values
+	// were changed when converted to the column type, e.g., "1.5" read as 1 in
+	// an int column. It covers only the rows read so far. Columns without such
+	// cells are omitted.
+	Coercions map[string]int
 }
 
 // CountMode controls the behavior of Table.Len().
@@ -209,9 +221,11 @@ func DefaultTableLen(ctx context.Context, t Table) int {
 	return n
 }
 
-// materializeTable saves the table in a btsv file. The path name is of form
-// "cachedir/hash.btsv", where hash is the hash of the table. So calling this
-// function multiple times for the same table will be cheap.
+// materializeTable saves the table in a btsv file. If the table has already
+// been forced into the cache, e.g., by force(), the cache entry is reused.
+// Otherwise the file is a temp file (see newTempPath), which is removed when the
+// session is closed. Calling this function multiple times for the same table in
+// a session is cheap.
 //
 // Writer should be a function that produces contents of the table in the given
 // writer. if writer==nil, then  the following fuction is used:
@@ -226,6 +240,11 @@ func materializeTable(ctx context.Context, t Table, writer func(w *BTSVShardWrit
 	if btsv, ok := t.(*btsvTable); ok {
 		return btsv
 	}
+	if ft, ok := t.(*forcedTable); ok {
+		if btsv, ok := ft.Table.(*btsvTable); ok {
+			return btsv
+		}
+	}
 	if writer == nil {
 		writer = func(w *BTSVShardWriter) {
 			sc := t.Scanner(ctx, 0, 1, 1)
@@ -234,18 +253,79 @@ func materializeTable(ctx context.Context, t Table, writer func(w *BTSVShardWrit
 			}
 		}
 	}
-	cacheName := t.Hash().String() + ".btsv"
-	btsvPath, found := LookupCache(ctx, cacheName)
-	if !found {
-		w := NewBTSVShardWriter(ctx, btsvPath, 0, 1, t.Attrs(ctx))
-		writer(w)
-		w.Close(ctx)
-		ActivateCache(ctx, cacheName, btsvPath)
+	h := t.Hash()
+	cacheName := h.String() + ".btsv"
+	if btsvPath, found := findCacheEntry(ctx, cacheName); found {
+		return NewBTSVTable(btsvPath, astUnknown /*TODO:fix*/, h)
 	}
-	bt := NewBTSVTable(btsvPath, astUnknown /*TODO:fix*/, t.Hash())
+	ns := tempNamespaceFromContext(ctx)
+	if bt := ns.materializedTable(h); bt != nil {
+		return bt
+	}
+	btsvPath := newTempPath(ctx, cacheName)
+	w := NewBTSVShardWriter(ctx, btsvPath, 0, 1, t.Attrs(ctx))
+	writer(w)
+	w.Close(ctx)
+	reportTableMaterialized(h, btsvPath, w.nrows)
+	bt := NewBTSVTable(btsvPath, astUnknown /*TODO:fix*/, h)
+	ns.addMaterializedTable(h, bt)
 	return bt
 }
 
+// ParallelizableTable is an optional interface implemented by a Table whose
+// ability to be scanned in parallel depends on its contents or its source. A
+// table whose Scanner yields all the rows for start=0 and no row for start>0
+// should implement this interface and return false.
+type ParallelizableTable interface {
+	// Parallelizable checks if Scanner(ctx, start, limit, total) partitions the
+	// rows of the table for total>1.
+	Parallelizable(ctx context.Context) bool
+}
+
+// IsParallelizable checks if the table can be scanned in parallel. A table
+// that does not implement ParallelizableTable is assumed to be
+// parallelizable.
+func IsParallelizable(ctx context.Context, t Table) bool {
+	if pt, ok := t.(ParallelizableTable); ok {
+		return pt.Parallelizable(ctx)
+	}
+	return true
+}
+
+// shardableTable returns a table with the same contents as t that can be
+// scanned in nshards parallel streams. If t cannot be scanned in parallel, it
+// materializes t in a btsv table, which supports range sharding, and logs a
+// warning, since otherwise the whole distributed stage would read t in one
+// stream.
+func shardableTable(ctx context.Context, ast ASTNode, t Table, nshards int) Table {
+	if nshards <= 1 || IsParallelizable(ctx, t) {
+		return t
+	}
+	Logf(ast, "warning: table %s cannot be scanned in parallel, so shards:=%d would degenerate to a single stream; materializing it first",
+		t.Attrs(ctx).Name, nshards)
+	return materializeTable(ctx, t, nil)
+}
+
+// LimitableTable is an optional interface implemented by a Table that can
+// produce a prefix of its rows more cheaply than the whole table. It lets
+// firstn() and pick() push their row budget down to the sources, e.g., to the
+// shards of a distributed map.
+type LimitableTable interface {
+	// Limit returns a table whose first n rows are the same as those of this
+	// table. The returned table may yield more than n rows, and it must have a
+	// hash different from this table's unless it is this table itself.
+	Limit(ctx context.Context, n int) Table
+}
+
+// limitTable returns a table whose first n rows are the same as those of t. It
+// returns t itself if t doesn't implement LimitableTable.
+func limitTable(ctx context.Context, t Table, n int) Table {
+	if lt, ok := t.(LimitableTable); ok {
+		return lt.Limit(ctx, n)
+	}
+	return t
+}
+
 // MarshalTableOutline marshals the given table by first writing its contents in
 // btsv format in the cachedir, then marshaling the pathname of the generated
 // btsv file.
@@ -377,6 +457,10 @@ func printTable(ctx context.Context, args PrintArgs, t Table, depth int) {
 		return
 	}
 
+	cellWidth := args.MaxCellWidth
+	if cellWidth == 0 {
+		cellWidth = maxCellWidth
+	}
 	sc := t.Scanner(ctx, 0, 1, 1)
 
 	// Read one row from the table.
@@ -400,7 +484,7 @@ func printTable(ctx context.Context, args PrintArgs, t Table, depth int) {
 					MaxInlinedTableLen: args.MaxInlinedTableLen,
 				}, depth+1)
 				values[ci].Name = col.Name
-				values[ci].Value = out.String()
+				values[ci].Value = termutil.TruncateCell(out.String(), cellWidth)
 			}
 		default:
 			out := termutil.NewBufferPrinter()
@@ -412,7 +496,7 @@ func printTable(ctx context.Context, args PrintArgs, t Table, depth int) {
 			}, depth+1)
 			values = []termutil.Column{{
 				Name:  symbol.AnonRow,
-				Value: out.String(),
+				Value: termutil.TruncateCell(out.String(), cellWidth),
 			}}
 		}
 		return
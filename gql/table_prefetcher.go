@@ -0,0 +1,211 @@
+package gql
+
+// This file implements tablePrefetcher, which reads the next few tables of a
+// table of tables (e.g., readdir(...) | map(read(_))) ahead of the consumer.
+// Each table is downloaded and parsed by its own goroutine, so a scan over many
+// S3 files overlaps the I/O of the upcoming files with the processing of the
+// current one.
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// Default values of the prefetch options in Opts.
+const (
+	DefaultPrefetchTables = 4
+	DefaultPrefetchMemory = 1 << 30
+)
+
+var (
+	// prefetchTables is the max number of tables read ahead by a
+	// tablePrefetcher. Copied from Opts.PrefetchTables.
+	prefetchTables = DefaultPrefetchTables
+	// prefetchMemory is the max total size, in bytes, of the rows buffered by
+	// all tablePrefetchers in the process. Copied from Opts.PrefetchMemory.
+	prefetchMemory int64 = DefaultPrefetchMemory
+
+	prefetchBudgetOnce sync.Once
+	prefetchBudget     *semaphore.Weighted
+)
+
+func getPrefetchBudget() *semaphore.Weighted {
+	prefetchBudgetOnce.Do(func() { prefetchBudget = semaphore.NewWeighted(prefetchMemory) })
+	return prefetchBudget
+}
+
+// approxValueSize estimates the memory used by the value, in bytes.
+func approxValueSize(v Value) int64 {
+	size := int64(16) // sizeof(Value)
+	switch {
+	case v.Type().LikeString():
+		size += int64(len(v.Str(nil)))
+	case v.Type() == StructType:
+		s := v.Struct(nil)
+		for i := 0; i < s.Len(); i++ {
+			size += 8 + approxValueSize(s.Field(i).Value)
+		}
+	}
+	return size
+}
+
+// prefetchedTable is a table being read by a background goroutine.
+type prefetchedTable struct {
+	table Table
+	done  chan struct{} // closed when the fields below are set.
+
+	// rows are the prefetched rows. If rest is nonnil, the memory budget ran out
+	// before the end of the table, and the remaining rows are read from rest.
+	rows []Value
+	rest TableScanner
+	// reserved is the number of bytes acquired from prefetchBudget.
+	reserved int64
+	err      error
+
+	// abandoned is set to 1 when the consumer no longer needs the table.
+	abandoned int32
+}
+
+func (pt *prefetchedTable) fetch(ctx context.Context) {
+	defer close(pt.done)
+	pt.err = Recover(func() {
+		budget := getPrefetchBudget()
+		sc := pt.table.Scanner(ctx, 0, 1, 1)
+		for sc.Scan() {
+			if atomic.LoadInt32(&pt.abandoned) != 0 {
+				return
+			}
+			v := sc.Value()
+			pt.rows = append(pt.rows, v)
+			size := approxValueSize(v)
+			if !budget.TryAcquire(size) {
+				// Out of budget. The consumer reads the rest of the table.
+				pt.rest = sc
+				return
+			}
+			pt.reserved += size
+		}
+	})
+}
+
+// release returns the memory reserved by pt to prefetchBudget.
+func (pt *prefetchedTable) release() {
+	if pt.reserved > 0 {
+		getPrefetchBudget().Release(pt.reserved)
+		pt.reserved = 0
+	}
+	pt.rows = nil
+}
+
+// prefetchedTableScanner implements TableScanner for a prefetchedTable.
+type prefetchedTableScanner struct {
+	rows  []Value
+	index int
+	rest  TableScanner
+}
+
+// Scan implements the TableScanner interface.
+func (sc *prefetchedTableScanner) Scan() bool {
+	if sc.index+1 < len(sc.rows) {
+		sc.index++
+		return true
+	}
+	if sc.rest == nil {
+		return false
+	}
+	sc.index = len(sc.rows)
+	return sc.rest.Scan()
+}
+
+// Value implements the TableScanner interface.
+func (sc *prefetchedTableScanner) Value() Value {
+	if sc.index < len(sc.rows) {
+		return sc.rows[sc.index]
+	}
+	return sc.rest.Value()
+}
+
+// tablePrefetcher iterates over the tables yielded by a scanner, reading up to
+// prefetchTables of them ahead of the consumer. The rows read ahead are kept
+// in memory, bounded by prefetchMemory across the process. A table that
+// doesn't fit is read partially, and the rest is read when the consumer gets
+// to it.
+//
+// A tablePrefetcher is not thread safe.
+type tablePrefetcher struct {
+	ctx context.Context
+	// src yields the tables. toTable extracts the table from a value yielded by
+	// src.
+	src     TableScanner
+	toTable func(v Value) Table
+	srcDone bool
+
+	queue []*prefetchedTable
+	cur   *prefetchedTable // the table being read by the consumer.
+}
+
+// newTablePrefetcher creates a tablePrefetcher.
+func newTablePrefetcher(ctx context.Context, src TableScanner, toTable func(v Value) Table) *tablePrefetcher {
+	p := &tablePrefetcher{ctx: ctx, src: src, toTable: toTable}
+	runtime.SetFinalizer(p, func(p *tablePrefetcher) {
+		// The consumer stopped before the end. Stop the outstanding reads and
+		// release their memory.
+		queue := p.queue
+		if p.cur != nil {
+			queue = append(queue, p.cur)
+		}
+		go func() {
+			for _, pt := range queue {
+				atomic.StoreInt32(&pt.abandoned, 1)
+				<-pt.done
+				pt.release()
+			}
+		}()
+	})
+	return p
+}
+
+func (p *tablePrefetcher) fill() {
+	for !p.srcDone && len(p.queue) < prefetchTables {
+		if !p.src.Scan() {
+			p.srcDone = true
+			break
+		}
+		pt := &prefetchedTable{table: p.toTable(p.src.Value()), done: make(chan struct{})}
+		p.queue = append(p.queue, pt)
+		go pt.fetch(p.ctx)
+	}
+}
+
+// Next returns a scanner for the next table. It returns false after the last
+// table.
+func (p *tablePrefetcher) Next() (TableScanner, bool) {
+	if p.cur != nil {
+		p.cur.release()
+		p.cur = nil
+	}
+	if prefetchTables <= 0 {
+		// Prefetching is disabled.
+		if !p.src.Scan() {
+			return nil, false
+		}
+		return p.toTable(p.src.Value()).Scanner(p.ctx, 0, 1, 1), true
+	}
+	p.fill()
+	if len(p.queue) == 0 {
+		return nil, false
+	}
+	pt := p.queue[0]
+	p.queue = p.queue[1:]
+	p.cur = pt
+	p.fill()
+	<-pt.done
+	if pt.err != nil {
+		panic(pt.err)
+	}
+	return &prefetchedTableScanner{rows: pt.rows, index: -1, rest: pt.rest}, true
+}
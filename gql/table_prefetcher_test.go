@@ -0,0 +1,57 @@
+package gql
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/semaphore"
+)
+
+func TestFlattenPrefetch(t *testing.T) {
+	tempDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	sess := newSession()
+
+	var (
+		paths []string
+		want  []string
+	)
+	for i := 0; i < 10; i++ {
+		path := filepath.Join(tempDir, fmt.Sprintf("f%d.tsv", i))
+		data := "a\tb\n"
+		for j := 0; j < 5; j++ {
+			data += fmt.Sprintf("%d\tx%d\n", i, j)
+			want = append(want, fmt.Sprintf("{a:%d,b:x%d}", i, j))
+		}
+		require.NoError(t, ioutil.WriteFile(path, []byte(data), 0600))
+		paths = append(paths, fmt.Sprintf("read(`%s`)", path))
+	}
+	expr := fmt.Sprintf("flatten(table(%s))", strings.Join(paths, ","))
+
+	getPrefetchBudget()
+	defer func(tables int, budget *semaphore.Weighted) {
+		prefetchTables, prefetchBudget = tables, budget
+	}(prefetchTables, prefetchBudget)
+	for _, test := range []struct {
+		tables int
+		budget int64
+	}{
+		{-1, DefaultPrefetchMemory},
+		{1, DefaultPrefetchMemory},
+		{4, DefaultPrefetchMemory},
+		// Tables that don't fit in the budget are read partially.
+		{4, 200},
+		{4, 0},
+	} {
+		prefetchTables, prefetchBudget = test.tables, semaphore.NewWeighted(test.budget)
+		assert.Equal(t, want, doReadTable(doEval(t, expr, sess)), "%+v", test)
+		// All the memory is released after the scan.
+		assert.True(t, prefetchBudget.TryAcquire(test.budget), "%+v", test)
+	}
+}
@@ -35,6 +35,30 @@ func TestNewSession() *Session {
 	return NewSession()
 }
 
+// TestSetRowTransformers replaces Opts.RowTransformers. It returns a function
+// that restores the old value.
+func TestSetRowTransformers(trs []RowTransformer) (restore func()) {
+	old := rowTransformers
+	rowTransformers = trs
+	return func() { rowTransformers = old }
+}
+
+// TestSetShareTableSubexprs replaces Opts.ShareTableSubexprs. It returns a
+// function that restores the old value.
+func TestSetShareTableSubexprs(v bool) (restore func()) {
+	old := shareSubexprs
+	shareSubexprs = v
+	return func() { shareSubexprs = old }
+}
+
+// TestSetNotifiers replaces Opts.Notifiers. It returns a function that
+// restores the old value.
+func TestSetNotifiers(n map[string]Notifier) (restore func()) {
+	old := notifiers
+	notifiers = n
+	return func() { notifiers = old }
+}
+
 func TestMarshalValue(t *testing.T, val Value) (ctxData, valData []byte) {
 	ctx := newMarshalContext(context.Background())
 	enc := marshal.NewEncoder(nil)
@@ -0,0 +1,165 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+)
+
+// DefaultMaxTmpVars is the default value of TmpVars.MaxVars.
+const DefaultMaxTmpVars = 256
+
+// TmpVars is used by Value.Print to give names to nested tables when values are
+// printed on screen.  TmpVars is a singleton object. Thread compatible.
+//
+// A variable is named after the hash of its value, as in "tmp_3fa2c1", so the
+// same table gets the same name across print calls and sessions.
+type TmpVars struct {
+	// Expr is the source expression being printed. It is recorded as the origin
+	// of the variables registered while it is set. It is reported by tmpvars().
+	Expr string
+	// MaxVars is the max number of variables kept. When more variables are
+	// registered, the oldest ones are expired; they are removed from the session
+	// on the next Flush. If MaxVars <= 0, DefaultMaxTmpVars is used.
+	MaxVars int
+
+	seq     int
+	hashes  map[hash.Hash]*tmpVarEntry
+	names   map[string]*tmpVarEntry
+	vars    map[string]Value // registered since the last Flush.
+	expired []string         // expired since the last Flush.
+}
+
+// tmpVarEntry is a variable registered in TmpVars.
+type tmpVarEntry struct {
+	name string
+	hash hash.Hash
+	val  Value
+	expr string
+	seq  int // registration order.
+}
+
+// tmpVarName computes the name of a variable for the given hash. Arg n is the
+// number of hash bytes to use.
+func tmpVarName(h hash.Hash, n int) string {
+	return fmt.Sprintf("tmp_%x", h[:n])
+}
+
+// Register assigns a name of form "tmp_xxxxxx" to the given value. If Register
+// is invoked for the same value multiple times, it returns the same name.
+func (a *TmpVars) Register(val Value) string {
+	if a.hashes == nil {
+		a.hashes = map[hash.Hash]*tmpVarEntry{}
+		a.names = map[string]*tmpVarEntry{}
+		a.vars = map[string]Value{}
+	}
+	h := val.Hash()
+	if e, ok := a.hashes[h]; ok {
+		return e.name
+	}
+	// Use three bytes of the hash. Use more if the name collides with another
+	// value.
+	name := tmpVarName(h, 3)
+	for n := 4; a.names[name] != nil; n++ {
+		name = tmpVarName(h, n)
+	}
+	e := &tmpVarEntry{name: name, hash: h, val: val, expr: a.Expr, seq: a.seq}
+	a.seq++
+	a.hashes[h] = e
+	a.names[name] = e
+	a.vars[name] = val
+	a.expire()
+	return name
+}
+
+// expire removes the oldest variables if there are more than MaxVars of them.
+func (a *TmpVars) expire() {
+	maxVars := a.MaxVars
+	if maxVars <= 0 {
+		maxVars = DefaultMaxTmpVars
+	}
+	if len(a.names) <= maxVars {
+		return
+	}
+	entries := a.sortedEntries()
+	for _, e := range entries[:len(entries)-maxVars] {
+		delete(a.hashes, e.hash)
+		delete(a.names, e.name)
+		if _, ok := a.vars[e.name]; ok {
+			delete(a.vars, e.name) // Not flushed yet.
+		} else {
+			a.expired = append(a.expired, e.name)
+		}
+	}
+}
+
+// sortedEntries lists the registered variables in registration order.
+func (a *TmpVars) sortedEntries() []*tmpVarEntry {
+	entries := make([]*tmpVarEntry, 0, len(a.names))
+	for _, e := range a.names {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+	return entries
+}
+
+// Flush adds the registered tmp variables as the global variables in the given
+// session, and removes the expired ones. The caller must ensure that "s" is not
+// concurrently used by other threads.
+func (a *TmpVars) Flush(s *Session) {
+	s.UnsetGlobals(a.expired)
+	a.expired = a.expired[:0]
+	s.SetGlobals(a.vars)
+	for k := range a.vars {
+		delete(a.vars, k)
+	}
+	flushedTmpVarsMu.Lock()
+	flushedTmpVars = a.sortedEntries()
+	flushedTmpVarsMu.Unlock()
+}
+
+// flushedTmpVars lists the variables in the TmpVars object that was flushed
+// last. It is reported by tmpvars().
+var (
+	flushedTmpVarsMu sync.Mutex
+	flushedTmpVars   []*tmpVarEntry
+)
+
+var tmpVarsExprSymbolID = symbol.Intern("expr")
+
+func init() {
+	RegisterBuiltinFunc("tmpvars",
+		`
+    tmpvars()
+
+Tmpvars lists the temporary variables, such as "tmp_3fa2c1", that are created
+when a nested table is too large to be printed inline. Each row has three
+columns: "name" is the variable name, "expr" is the expression whose value was
+being printed when the variable was created, and "value" is the value of the
+variable. The rows are sorted in creation order.
+
+A temporary variable is named after the hash of its value, so the same table
+always gets the same name. Only the most recent variables are kept (256 by
+default); older ones are removed from the session.
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			flushedTmpVarsMu.Lock()
+			entries := flushedTmpVars
+			flushedTmpVarsMu.Unlock()
+			rows := make([]Value, len(entries))
+			h := hash.String("tmpvars")
+			for i, e := range entries {
+				rows[i] = NewStruct(NewSimpleStruct(
+					StructField{Name: symbol.Name, Value: NewString(e.name)},
+					StructField{Name: tmpVarsExprSymbolID, Value: NewString(e.expr)},
+					StructField{Name: symbol.Value, Value: e.val}))
+				h = h.Merge(e.hash).Merge(hash.String(e.expr))
+			}
+			return NewTable(NewSimpleTable(rows, h, TableAttrs{Name: "tmpvars"}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType })
+}
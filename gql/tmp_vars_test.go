@@ -0,0 +1,48 @@
+package gql
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/grailbio/gql/symbol"
+	"github.com/grailbio/testutil/expect"
+)
+
+func TestTmpVars(t *testing.T) {
+	ctx := context.Background()
+	sess := TestNewSession()
+	eval := func(expr string) Value {
+		statements, err := sess.Parse("(input)", []byte(expr))
+		expect.NoError(t, err)
+		return sess.EvalStatements(ctx, statements)
+	}
+	t0 := eval("table({a:0})")
+	t1 := eval("table({a:1})")
+	t2 := eval("table({a:2})")
+
+	a := TmpVars{MaxVars: 2, Expr: "expr0"}
+	name0 := a.Register(t0)
+	expect.True(t, regexp.MustCompile(`^tmp_[0-9a-f]{6}$`).MatchString(name0))
+	expect.EQ(t, a.Register(t0), name0)
+	// Names depend only on the value.
+	expect.EQ(t, (&TmpVars{}).Register(t0), name0)
+	a.Expr = "expr1"
+	name1 := a.Register(t1)
+	expect.NE(t, name1, name0)
+	a.Flush(sess)
+	expect.EQ(t, doReadTable(eval(name0)), []string{"{a:0}"})
+	expect.EQ(t, doReadTable(eval(name1)), []string{"{a:1}"})
+	expect.EQ(t, doReadTable(eval("tmpvars() | map({$name, $expr})")),
+		[]string{"{name:" + name0 + ",expr:expr0}", "{name:" + name1 + ",expr:expr1}"})
+
+	// Registering the third value expires the first one.
+	name2 := a.Register(t2)
+	a.Flush(sess)
+	expect.EQ(t, doReadTable(eval(name2)), []string{"{a:2}"})
+	_, ok := sess.Bindings().Lookup(symbol.Intern(name0))
+	expect.False(t, ok)
+	_, ok = sess.Bindings().Lookup(symbol.Intern(name1))
+	expect.True(t, ok)
+	expect.EQ(t, eval("tmpvars() | count()").Int(nil), int64(2))
+}
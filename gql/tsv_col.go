@@ -31,8 +31,25 @@ func dictPathname(tpl *template.Template, gzipFiles bool) string {
 	return dict
 }
 
+// validateColumnarTemplate checks that the template generates distinct
+// pathnames for different columns. It is called before writing any file.
+func validateColumnarTemplate(tpl *template.Template, format string) {
+	paths := map[string]bool{}
+	for _, arg := range []columnarTemplate{{"col0", 0}, {"col1", 1}} {
+		obuf := &strings.Builder{}
+		if err := tpl.Execute(obuf, arg); err != nil {
+			log.Panicf("writecols: failed to execute template '%v': %v", format, err)
+		}
+		paths[obuf.String()] = true
+	}
+	if len(paths) < 2 {
+		log.Panicf("writecols: template '%v' generates the same pathname for different columns; it must contain {{.Name}} or {{.Number}}", format)
+	}
+}
+
 func colPathnames(tpl *template.Template, colIDs []symbol.ID, gzipFiles bool) []string {
 	pathnames := make([]string, len(colIDs))
+	pathCols := map[string]symbol.ID{}
 	for ci, colID := range colIDs {
 		obuf := &strings.Builder{}
 		col := colID.Str()
@@ -45,6 +62,10 @@ func colPathnames(tpl *template.Template, colIDs []symbol.ID, gzipFiles bool) []
 		if gzipFiles && (fileio.DetermineType(pathnames[ci]) != fileio.Gzip) {
 			pathnames[ci] += fileio.FileSuffix(fileio.Gzip)
 		}
+		if other, ok := pathCols[pathnames[ci]]; ok {
+			log.Panicf("writecols: columns '%v' and '%v' are both written to %v", other.Str(), colID.Str(), pathnames[ci])
+		}
+		pathCols[pathnames[ci]] = colID
 	}
 	return pathnames
 }
@@ -131,7 +152,7 @@ func newColumnarTSVWriter(ctx context.Context, paths []string, colIDs []symbol.I
 		buf:    make([][]Value, len(colIDs)),
 	}
 	traverse.Each(len(colIDs), func(shard int) error { // nolint:errcheck
-		w.w[shard] = newDefaultTSVWriter(ctx, paths[shard], []symbol.ID{colIDs[shard]}, true, gzipFiles)
+		w.w[shard] = newDefaultTSVWriter(ctx, paths[shard], []symbol.ID{colIDs[shard]}, true, gzipFiles, tsvWriterOpts{})
 		return nil
 	})
 	for ci := range colIDs {
@@ -168,7 +189,12 @@ func WriteColumnarTSV(ctx context.Context, table Table, format string, gzipFiles
 	if err != nil {
 		log.Panicf("failed to parse template '%v': %v", format, err)
 	}
+	validateColumnarTemplate(tpl, format)
 
+	dictPath := ""
+	if hasColumnDescriptions(table.Attrs(ctx)) {
+		dictPath = dictPathname(tpl, gzipFiles)
+	}
 	writeTSVHelper(ctx, func(colIDs []symbol.ID) tsvWriter {
 		paths := colPathnames(tpl, colIDs, gzipFiles)
 		fileExists := errors.New("exists")
@@ -187,5 +213,5 @@ func WriteColumnarTSV(ctx context.Context, table Table, format string, gzipFiles
 		// TODO(saito) Fix this codepath.
 		log.Panic("writecol: non-overwrite mode not yet supported")
 		return nil
-	}, "", table, gzipFiles)
+	}, dictPath, table, gzipFiles, nil)
 }
@@ -174,3 +174,14 @@ func TestColumnShardedLarge(t *testing.T) {
 		expect.EQ(t, rows[i], v)
 	}
 }
+
+func TestColumnShardedTemplateCollision(t *testing.T) {
+	env := gqltest.NewSession()
+	tmpDir, cleanup := testutil.TempDir(t, "", "colsharded-collision-")
+	defer cleanup()
+	expect.That(t,
+		func() {
+			gqltest.Eval(t, `table({a:1, b:2}) | writecols("`+filepath.Join(tmpDir, "cols.ctsv")+`")`, env)
+		},
+		h.Panics(h.Regexp(`generates the same pathname for different columns`)))
+}
@@ -0,0 +1,280 @@
+package gql
+
+// This file implements reading of tidy data dictionaries. A data dictionary is
+// a TSV file with columns "column_name", "type", "description", and optionally
+// "unit". It describes the columns of another TSV file. See also writeTSVDict.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/grailbio/base/compress"
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+)
+
+// tsvAutoDict is the value of read(..., dict:=...) that causes the data
+// dictionary to be looked up next to the TSV file.
+const tsvAutoDict = "auto"
+
+// tsvDictSuffixes lists the filename suffixes of the data dictionary files that
+// read(..., dict:="auto") looks for. For example, for "foo.tsv", it looks for
+// "foo_data_dictionary.tsv" and "foo_dict.tsv", in this order.
+var tsvDictSuffixes = []string{"_data_dictionary.tsv", "_dict.tsv"}
+
+// findTSVDict finds the data dictionary for the given TSV file. It returns ""
+// if the dictionary is not found.
+func findTSVDict(ctx context.Context, path string) string {
+	base := tsvSuffixRE.ReplaceAllString(path, "")
+	if base == path {
+		return ""
+	}
+	for _, suffix := range tsvDictSuffixes {
+		dictPath := base + suffix
+		if _, err := file.Stat(ctx, dictPath); err == nil {
+			return dictPath
+		}
+	}
+	return ""
+}
+
+// parseTSVDictType parses the "type" column of a data dictionary. It accepts
+// the names produced by writeTSVDict, as well as a few legacy names, e.g.,
+// "Integer". The name is case insensitive.
+func parseTSVDictType(name string) (ValueType, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if strings.HasPrefix(name, "enum") {
+		// The name may be followed by the list of values, as in "enum:a,b".
+		return EnumType, true
+	}
+	switch name {
+	case "bool", "boolean":
+		return BoolType, true
+	case "int", "integer":
+		return IntType, true
+	case "float", "double", "numeric":
+		return FloatType, true
+	case "string":
+		return StringType, true
+	case "filename":
+		return FileNameType, true
+	case "table":
+		return TableType, true
+	case "char":
+		return CharType, true
+	case "date":
+		return DateType, true
+	case "datetime":
+		return DateTimeType, true
+	case "duration":
+		return DurationType, true
+	}
+	return InvalidType, false
+}
+
+// readTSVDict reads a data dictionary file. The columns are returned in the
+// order listed in the dictionary.
+func readTSVDict(ctx context.Context, ast ASTNode, dictPath string) []TSVColumn {
+	in, err := file.Open(ctx, dictPath)
+	if err != nil {
+		Panicf(ast, "read dictionary %s: %v", dictPath, err)
+	}
+	defer in.Close(ctx) // nolint: errcheck
+	compressr, _ := compress.NewReader(in.Reader(ctx))
+	defer compressr.Close() // nolint: errcheck
+	r := newCSVReader(ctx, compressr)
+	header, err := r.Read()
+	if err != nil {
+		Panicf(ast, "read dictionary %s: header: %v", dictPath, err)
+	}
+	nameCol, typeCol, descCol, unitCol := -1, -1, -1, -1
+	for i, col := range header {
+		switch strings.ToLower(strings.TrimSpace(col)) {
+		case "column_name":
+			nameCol = i
+		case "type":
+			typeCol = i
+		case "description":
+			descCol = i
+		case "unit":
+			unitCol = i
+		}
+	}
+	if nameCol < 0 || typeCol < 0 {
+		Panicf(ast, "read dictionary %s: columns 'column_name' and 'type' are required, but found %v", dictPath, header)
+	}
+	var cols []TSVColumn
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			Panicf(ast, "read dictionary %s: %v", dictPath, err)
+		}
+		if nameCol >= len(row) || typeCol >= len(row) {
+			Panicf(ast, "read dictionary %s: too few columns in row %v", dictPath, row)
+		}
+		typ, ok := parseTSVDictType(row[typeCol])
+		if !ok {
+			Panicf(ast, "read dictionary %s: unknown type '%s' for column '%s'", dictPath, row[typeCol], row[nameCol])
+		}
+		col := TSVColumn{Name: row[nameCol], Type: typ}
+		if descCol >= 0 && descCol < len(row) && row[descCol] != "Unknown" {
+			col.Description = row[descCol]
+		}
+		if unitCol >= 0 && unitCol < len(row) {
+			col.Unit = strings.TrimSpace(row[unitCol])
+		}
+		cols = append(cols, col)
+	}
+	return cols
+}
+
+// tryReadTSVDict is similar to readTSVDict, but it logs an error and returns
+// nil if the dictionary can't be parsed. It is used for dictionaries found by
+// findTSVDict, which may be unrelated files that happen to have a matching
+// name.
+func tryReadTSVDict(ctx context.Context, ast ASTNode, dictPath string) (dict []TSVColumn) {
+	defer func() {
+		if e := recover(); e != nil {
+			log.Error.Printf("ignoring data dictionary %s: %v", dictPath, e)
+			dict = nil
+		}
+	}()
+	return readTSVDict(ctx, ast, dictPath)
+}
+
+// applyTSVDict overrides the types, descriptions, and units of the columns in
+// format with the ones listed in the dictionary. Columns not found in the
+// dictionary are unchanged.
+func applyTSVDict(format *TSVFormat, dict []TSVColumn) {
+	dictMap := make(map[string]TSVColumn, len(dict))
+	for _, col := range dict {
+		dictMap[col.Name] = col
+	}
+	for i := range format.Columns {
+		col, ok := dictMap[format.Columns[i].Name]
+		if !ok {
+			continue
+		}
+		format.Columns[i].Type = col.Type
+		format.Columns[i].Description = col.Description
+		format.Columns[i].Unit = col.Unit
+	}
+}
+
+// tsvDictTypeMatches checks if a value of type valType conforms to the column
+// type listed in a dictionary.
+func tsvDictTypeMatches(dictType, valType ValueType) bool {
+	if valType == dictType || valType == NullType {
+		return true
+	}
+	switch dictType {
+	case FloatType:
+		return valType == IntType
+	case StringType, FileNameType, EnumType:
+		return valType == StringType || valType == FileNameType || valType == EnumType
+	}
+	return false
+}
+
+var (
+	checkDictColumnSymbolID = symbol.Intern("column")
+	checkDictRowSymbolID    = symbol.Intern("row")
+	checkDictIssueSymbolID  = symbol.Intern("issue")
+)
+
+// checkDict validates the table against the data dictionary. It returns the
+// list of violations, at most one per column and kind of violation.
+func checkDict(ctx context.Context, ast ASTNode, t Table, dictPath string) []Value {
+	dict := readTSVDict(ctx, ast, dictPath)
+	dictMap := make(map[symbol.ID]TSVColumn, len(dict))
+	for _, col := range dict {
+		dictMap[symbol.Intern(col.Name)] = col
+	}
+	var issues []Value
+	addIssue := func(col string, row int64, format string, args ...interface{}) {
+		issues = append(issues, NewStruct(NewSimpleStruct(
+			StructField{Name: checkDictColumnSymbolID, Value: NewString(col)},
+			StructField{Name: checkDictRowSymbolID, Value: NewInt(row)},
+			StructField{Name: checkDictIssueSymbolID, Value: NewString(fmt.Sprintf(format, args...))})))
+	}
+	seen := map[symbol.ID]bool{}    // columns found in the table.
+	badType := map[symbol.ID]bool{} // columns with a type mismatch.
+	var row int64
+	sc := t.Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		st := sc.Value().Struct(ast)
+		for fi := 0; fi < st.Len(); fi++ {
+			f := st.Field(fi)
+			if !seen[f.Name] {
+				seen[f.Name] = true
+				if _, ok := dictMap[f.Name]; !ok {
+					addIssue(f.Name.Str(), row, "column not in dictionary")
+				}
+			}
+			col, ok := dictMap[f.Name]
+			if !ok || badType[f.Name] {
+				continue
+			}
+			if typ := f.Value.Type(); !tsvDictTypeMatches(col.Type, typ) {
+				badType[f.Name] = true
+				addIssue(col.Name, row, "expect type %v, but found %v (value %v)", col.Type, typ, f.Value)
+			}
+		}
+		row++
+	}
+	for _, col := range dict {
+		if !seen[symbol.Intern(col.Name)] {
+			addIssue(col.Name, -1, "column missing in table")
+		}
+	}
+	return issues
+}
+
+func init() {
+	RegisterBuiltinFunc("check_dict",
+		`
+    tbl | check_dict(dictpath)
+
+Arg types:
+
+- _dictpath_: string
+
+Check_dict validates _tbl_ against the tidy data dictionary stored in
+_dictpath_. A data dictionary is a TSV file with columns "column_name",
+"type", "description", and optionally "unit".
+
+Check_dict returns a table that lists the violations. Each row has three
+columns: "column" is the name of the offending column, "row" is the index of
+the first row that violates the dictionary (-1 if the violation is not specific
+to a row), and "issue" describes the violation. The following violations are
+reported, at most once per column:
+
+- the table has a column that is not listed in the dictionary;
+- a column listed in the dictionary is not found in the table;
+- a value does not match the column type listed in the dictionary. NA values
+  match any type, and int values are accepted in float columns.
+
+The result is empty if the table conforms to the dictionary.
+
+Example:
+
+    t := read("samples.tsv")
+    t | check_dict("samples_data_dictionary.tsv") | count() == 0
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			t := args[0].Table()
+			dictPath := args[1].Str()
+			h := hash.String("check_dict").Merge(t.Hash()).Merge(FileHash(ctx, dictPath, ast))
+			return NewTable(NewSimpleTable(checkDict(ctx, ast, t, dictPath), h, TableAttrs{Name: "check_dict", Path: dictPath}))
+		},
+		func(ast ASTNode, args []AIArg) AIType { return AITableType },
+		FormalArg{Positional: true, Required: true, Types: []ValueType{TableType}},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{StringType}})
+}
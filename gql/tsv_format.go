@@ -13,6 +13,9 @@ type TSVColumn struct {
 	Type ValueType
 	// Description is an optional description of the column.
 	Description string `json:",omitempty"`
+	// Unit is an optional unit of the values in the column, e.g., "ng/mL". See
+	// units.go.
+	Unit string `json:",omitempty"`
 }
 
 // TSVFormat defines the format of a TSV file. JSON encodable.
@@ -8,14 +8,22 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/csv"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
+	"os"
+	"regexp"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unicode/utf8"
 
 	"github.com/grailbio/base/compress"
+	"github.com/grailbio/base/errors"
 	"github.com/grailbio/base/file"
 	"github.com/grailbio/base/log"
 	"github.com/grailbio/base/traverse"
@@ -25,6 +33,7 @@ import (
 	"github.com/grailbio/gql/marshal"
 	"github.com/grailbio/gql/symbol"
 	"github.com/grailbio/gql/termutil"
+	"github.com/grailbio/hts/bgzf"
 )
 
 // the size of the buffer used for writing column files.
@@ -59,48 +68,150 @@ type TSVTable struct {
 
 	format *TSVFormat
 
+	// dictPath is the data dictionary specified by the user. If findDict is
+	// true, the dictionary is looked up next to the file instead, and it is
+	// ignored if it can't be parsed. The dictionary is used only when format is
+	// nil.
+	dictPath     string
+	findDict     bool
+	dictPathOnce sync.Once
+
+	// delimiter separates the cells in a row. It is '\t' for a TSV file, and
+	// ',' for a CSV file, unless set by read(..., delimiter:=...).
+	delimiter rune
+	// escape specifies how special characters in the cells are encoded.
+	escape tsvEscapeMode
+	// numFormat specifies the thousands and decimal separators of the numbers
+	// in the cells.
+	numFormat tsvNumberFormat
+	// duplicateColumns specifies how columns with the same name in the header
+	// are handled. See tsvReadOpts.
+	duplicateColumns string
+	// dropCols lists the indexes of the cells dropped from each row, in
+	// ascending order. Set in init for duplicateColumns=="keep_first".
+	dropCols []int
+	// metadata is parsed from the "##key=value" lines at the beginning of the
+	// file. Set in init.
+	metadata map[string]string
+	// strict causes a cell that can't be converted to the column type without
+	// loss to fail. See tsvReadOpts.
+	strict bool
+	// coercions[i] is the number of lossy conversions of the cells in the i'th
+	// column. It is updated atomically. Set in init.
+	coercions []int64
+
 	nRows int // # of rows. Set in init.
 	table Table
 
 	// Underlying open tsv file.
-	in file.File
+	in *retryingFile
 }
 
 type tsvTableScanner struct {
 	ctx    context.Context
 	parent *TSVTable
-	in     file.File
+	budget *evalBudget
+	in     *retryingFile
 	// For closing & checksum the compression reader.  it is a noop closer if the
 	// file is not compressed.
 	compressr io.Closer
 	r         *csv.Reader
 
+	// preds lists the conditions pushed down by filter(). The rows that fail
+	// them are dropped before the row struct is constructed.
+	preds   []tsvCellPredicate
 	tmpCols []StructField
 	row     Value
 }
 
+// tsvCellPredicate is a columnPredicate on the cell at the given index.
+type tsvCellPredicate struct {
+	cell int
+	typ  ValueType
+	pred columnPredicate
+}
+
 func (s *tsvTableScanner) Value() Value { return s.row }
 func (s *tsvTableScanner) Scan() bool {
-	rawRow, err := s.r.Read()
-	if err != nil {
-		if err == io.EOF {
-			return false
+	for {
+		rawRow, err := s.r.Read()
+		if err != nil {
+			if err == io.EOF {
+				return false
+			}
+			Panicf(s.parent.ast, "read %v: %v", s.parent.path, err)
+		}
+		CheckCancellation(s.ctx)
+		s.budget.addRows(1)
+		s.parent.escape.unescapeRow(rawRow)
+		s.parent.numFormat.normalizeRow(rawRow)
+		rawRow = s.parent.dropDuplicateCells(rawRow)
+		if !s.matchPredicates(rawRow) {
+			continue
 		}
-		Panicf(s.parent.ast, "read %v: %v", s.parent.path, err)
+		for fi, field := range s.parent.format.Columns {
+			if len(rawRow) < fi {
+				s.tmpCols[fi] = StructField{symbol.Intern(field.Name), Null}
+				continue
+			}
+			s.tmpCols[fi].Value = s.parent.parseRowString(fi, rawRow[fi], field.Type, true)
+		}
+		s.row = NewStruct(NewSimpleStruct(s.tmpCols...))
+		return true
 	}
-	CheckCancellation(s.ctx)
-	for fi, field := range s.parent.format.Columns {
-		if len(rawRow) < fi {
-			s.tmpCols[fi] = StructField{symbol.Intern(field.Name), Null}
+}
+
+// matchPredicates checks if the row may satisfy s.preds. It parses only the
+// cells used by the predicates. The cells are parsed again if the row matches,
+// so their lossy conversions are not counted here.
+func (s *tsvTableScanner) matchPredicates(rawRow []string) bool {
+	for _, p := range s.preds {
+		if p.cell >= len(rawRow) {
 			continue
 		}
-		s.tmpCols[fi].Value = s.parent.parseRowString(rawRow[fi], field.Type)
+		if !p.pred.match(s.parent.parseRowString(p.cell, rawRow[p.cell], p.typ, false)) {
+			return false
+		}
 	}
-	s.row = NewStruct(NewSimpleStruct(s.tmpCols...))
 	return true
 }
 
-func (t *TSVTable) parseRowString(rowStr string, typ ValueType) Value {
+// openNestedTable parses a cell of a column whose type is "table" in the data
+// dictionary. Such a cell stores the path of a btsv file, usually relative to
+// the directory of the TSV file, as produced by write(...,
+// nested_tables:="file"). The btsv file is opened lazily. A cell that doesn't
+// name a btsv file is read as a filename, as older versions of write() stored
+// other strings in table columns.
+func (t *TSVTable) openNestedTable(rowStr string) Value {
+	if !strings.HasSuffix(rowStr, ".btsv") {
+		return NewFileName(rowStr)
+	}
+	path := rowStr
+	if !strings.HasPrefix(path, "/") && !strings.Contains(path, "://") {
+		path = file.Join(file.Dir(t.path), path)
+	}
+	return NewTable(NewBTSVTable(path, t.ast, hash.String(path)))
+}
+
+// noteLossyCoercion is called when the cell of the given column is converted
+// to the column type with loss, e.g., when "1.5" is read as 1. It panics in the
+// strict mode. Else, it counts the conversion in t.coercions if count is true.
+func (t *TSVTable) noteLossyCoercion(col int, rowStr string, typ ValueType, count bool) {
+	name := t.format.Columns[col].Name
+	if t.strict || strictTSV {
+		Panicf(t.ast, "read %s: column '%s': %v cannot be read as %v without loss (strict mode)", t.path, name, rowStr, typ)
+	}
+	if !count {
+		return
+	}
+	if atomic.AddInt64(&t.coercions[col], 1) == 1 {
+		Logf(t.ast, "read %s: column '%s': %v is truncated to %v; such cells are counted in table_attrs(...).coercions", t.path, name, rowStr, typ)
+	}
+}
+
+// parseRowString parses a cell of the col'th column, whose type is typ. If count
+// is true, a lossy conversion of the cell is counted in t.coercions.
+func (t *TSVTable) parseRowString(col int, rowStr string, typ ValueType, count bool) Value {
 	if guessformat.IsNull(rowStr) {
 		return Null
 	}
@@ -112,6 +223,9 @@ func (t *TSVTable) parseRowString(rowStr string, typ ValueType) Value {
 		}
 		f, err := strconv.ParseFloat(rowStr, 64)
 		if err == nil && f <= math.MaxInt64 && f >= math.MinInt64 {
+			if f != math.Trunc(f) {
+				t.noteLossyCoercion(col, rowStr, typ, count)
+			}
 			return NewInt(int64(math.Trunc(f)))
 		}
 		Panicf(t.ast, "parserow: %v cannot be parsed as integer: %v", rowStr, err)
@@ -129,6 +243,8 @@ func (t *TSVTable) parseRowString(rowStr string, typ ValueType) Value {
 		return NewString(rowStr)
 	case FileNameType:
 		return NewFileName(rowStr)
+	case TableType:
+		return t.openNestedTable(rowStr)
 	case EnumType:
 		return NewEnum(rowStr)
 	case CharType:
@@ -143,6 +259,11 @@ func (t *TSVTable) parseRowString(rowStr string, typ ValueType) Value {
 			Panicf(t.ast, "parserow: %v cannot be parsed as datetime or date (%v)", rowStr, typ)
 		}
 		return v
+	case DurationType:
+		if v, err := time.ParseDuration(rowStr); err == nil {
+			return NewDuration(v)
+		}
+		Panicf(t.ast, "parserow: %v cannot be parsed as duration", rowStr)
 	}
 	Panicf(t.ast, "parserow: unknown data type %v", typ)
 	return Value{}
@@ -157,18 +278,27 @@ func newCSVReader(ctx context.Context, in io.Reader) *csv.Reader {
 	return csvr
 }
 
+// newReader creates a reader that splits the rows of the file into cells.
+func (t *TSVTable) newReader(ctx context.Context, in io.Reader) *csv.Reader {
+	csvr := newCSVReader(ctx, in)
+	csvr.Comma = t.delimiter
+	return csvr
+}
+
 func (t *TSVTable) init(ctx context.Context) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	if t.initialized {
 		return
 	}
-	in, err := file.Open(ctx, t.path)
+	in, err := openRetryingFile(ctx, t.path)
 	if err != nil {
 		Panicf(t.ast, "init %s: open: %v", t.path, err)
 	}
 	compressr, _ := compress.NewReader(in.Reader(ctx))
-	csvr := newCSVReader(ctx, compressr)
+	br := bufio.NewReader(compressr)
+	t.metadata = readTSVMetadata(br)
+	csvr := t.newReader(ctx, br)
 	rawRows := make([][]string, 0, MaxTSVRowsInMemory)
 	readAll := false
 	for i := 0; i < MaxTSVRowsInMemory; i++ {
@@ -180,13 +310,24 @@ func (t *TSVTable) init(ctx context.Context) {
 			}
 			Panicf(t.ast, "read %s: csv.ReadAll: %v", in.Name(), err)
 		}
+		t.escape.unescapeRow(row)
+		t.numFormat.normalizeRow(row)
 		rawRows = append(rawRows, row)
 	}
 
 	if t.format == nil {
 		format := guessTSVFormat(t.path, rawRows)
+		if dictPath := t.lookupDict(ctx); dictPath != "" {
+			if t.findDict {
+				applyTSVDict(&format, tryReadTSVDict(ctx, t.ast, dictPath))
+			} else {
+				applyTSVDict(&format, readTSVDict(ctx, t.ast, dictPath))
+			}
+		}
 		t.format = &format
+		t.resolveDuplicateColumns()
 	}
+	t.coercions = make([]int64, len(t.format.Columns))
 
 	Logf(t.ast, "read %v (%d rows, readall: %v), %d #header, %d cols",
 		in.Name(), len(rawRows), readAll, t.format.HeaderLines, len(t.format.Columns))
@@ -196,13 +337,15 @@ func (t *TSVTable) init(ctx context.Context) {
 		tmpCols[fi].Name = symbol.Intern(field.Name)
 	}
 	for li := t.format.HeaderLines; li < len(rawRows); li++ {
-		rawRow := rawRows[li]
+		rawRow := t.dropDuplicateCells(rawRows[li])
 		for fi, field := range t.format.Columns {
 			if len(rawRow) <= fi {
 				tmpCols[fi].Value = Null
 				continue
 			}
-			tmpCols[fi].Value = t.parseRowString(rawRow[fi], field.Type)
+			// If the file is too large, the scanners parse the rows again, so
+			// the coercions are counted there.
+			tmpCols[fi].Value = t.parseRowString(fi, rawRow[fi], field.Type, readAll)
 		}
 		rows = append(rows, NewStruct(NewSimpleStruct(tmpCols...)))
 	}
@@ -214,13 +357,65 @@ func (t *TSVTable) init(ctx context.Context) {
 			Errorf(t.ast, "close %s: %v", in.Name(), err)
 		}
 		t.nRows = len(rows)
-		t.table = NewSimpleTable(rows, t.Hash(), TableAttrs{Name: "tsv", Path: t.path, Columns: t.format.Columns})
+		t.table = NewSimpleTable(rows, t.Hash(), TableAttrs{Name: "tsv", Path: t.path, Columns: t.format.Columns, Metadata: t.metadata})
 	} else {
 		t.in = in
 	}
 	t.initialized = true
 }
 
+// readTSVMetadata reads the "##key=value" lines at the beginning of a TSV
+// file. It returns nil if the file has no such line. The csv reader skips these
+// lines as comments, so the rest of r can be read as usual.
+func readTSVMetadata(r *bufio.Reader) map[string]string {
+	var metadata map[string]string
+	for {
+		if prefix, err := r.Peek(2); err != nil || string(prefix) != "##" {
+			return metadata
+		}
+		line, err := r.ReadString('\n')
+		line = strings.TrimRight(line[2:], "\r\n")
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		if i := strings.IndexByte(line, '='); i >= 0 {
+			metadata[line[:i]] = line[i+1:]
+		} else {
+			metadata[line] = ""
+		}
+		if err != nil {
+			return metadata
+		}
+	}
+}
+
+// tsvMetadataLines creates the "key=value" lines written at the beginning of a
+// TSV file by write(..., metadata:=...). If params is a struct, it is recorded
+// in the "params" line.
+func tsvMetadataLines(table Table, params Value) []string {
+	clean := func(s string) string { return strings.NewReplacer("\n", " ", "\r", " ").Replace(s) }
+	lines := []string{
+		"gql_version=" + clean(Version),
+		"created=" + time.Now().UTC().Format(time.RFC3339),
+		"source_hash=" + table.Hash().String(),
+	}
+	if params.Type() == StructType {
+		lines = append(lines, "params="+clean(params.String()))
+	}
+	return lines
+}
+
+// lookupDict returns the path of the data dictionary for the file. It returns
+// "" if the file has no dictionary.
+func (t *TSVTable) lookupDict(ctx context.Context) string {
+	t.dictPathOnce.Do(func() {
+		if t.dictPath == "" && t.findDict {
+			t.dictPath = findTSVDict(ctx, t.path)
+		}
+	})
+	return t.dictPath
+}
+
 // Prefetch implements the Table interface.
 func (t *TSVTable) Prefetch(ctx context.Context) { go Recover(func() { t.init(ctx) }) }
 
@@ -235,15 +430,73 @@ func (t *TSVTable) Len(ctx context.Context, mode CountMode) int {
 		// file system?
 		return 100000
 	}
-	t.lenOnce.Do(func() { t.len = DefaultTableLen(ctx, t) })
+	t.lenOnce.Do(func() { t.len = t.countRows(ctx) })
 	return t.len
 }
 
+// countRows computes the exact number of rows in the file. Unlike
+// DefaultTableLen, it splits each line into fields, but it doesn't parse the
+// field values.
+func (t *TSVTable) countRows(ctx context.Context) int {
+	t.init(ctx)
+	t.mu.Lock()
+	if t.table != nil {
+		t.mu.Unlock()
+		return t.nRows
+	}
+	t.mu.Unlock()
+	in, err := openRetryingFile(ctx, t.path)
+	if err != nil {
+		Panicf(t.ast, "tsv open %v: %v", t.path, err)
+	}
+	defer in.Close(ctx) // nolint: errcheck
+	compressr, _ := compress.NewReader(in.Reader(ctx))
+	defer compressr.Close() // nolint: errcheck
+	csvr := t.newReader(ctx, compressr)
+	csvr.ReuseRecord = true
+	n := 0
+	for {
+		if _, err := csvr.Read(); err != nil {
+			if err == io.EOF {
+				break
+			}
+			Panicf(t.ast, "read %v: %v", t.path, err)
+		}
+		n++
+		if n%65536 == 0 {
+			CheckCancellation(ctx)
+		}
+	}
+	n -= t.format.HeaderLines
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
 // Hash implements the Table interface.
 func (t *TSVTable) Hash() hash.Hash {
 	t.hashOnce.Do(func() {
 		if t.hash == hash.Zero || VerifyFileHash {
 			h := FileHash(BackgroundContext, t.path, t.ast)
+			if dictPath := t.lookupDict(BackgroundContext); dictPath != "" {
+				h = h.Merge(FileHash(BackgroundContext, dictPath, t.ast))
+			}
+			if t.delimiter != '\t' {
+				h = h.Merge(hash.String("delimiter:" + string(t.delimiter)))
+			}
+			if t.escape != tsvEscapeNone {
+				h = h.Merge(hash.String("escape:" + t.escape.String()))
+			}
+			if !t.numFormat.isDefault() {
+				h = h.Merge(hash.String("numformat:" + t.numFormat.String()))
+			}
+			if t.duplicateColumns != "" {
+				h = h.Merge(hash.String("duplicate_columns:" + t.duplicateColumns))
+			}
+			if t.strict {
+				h = h.Merge(hash.String("strict"))
+			}
 			if t.hash != hash.Zero && t.hash != h {
 				Panicf(t.ast, "mismatched hash for '%s' (file changed in the background?)", t.path)
 			}
@@ -255,36 +508,70 @@ func (t *TSVTable) Hash() hash.Hash {
 
 // Marshal implements the Table interface.
 func (t *TSVTable) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
+	if t.findDict || t.dictPath != "" || t.delimiter != tsvDefaultDelimiter(t.fileHandler) || t.escape != tsvEscapeNone || !t.numFormat.isDefault() || t.duplicateColumns != "" || t.strict {
+		// The remote side cannot reconstruct the dictionary given by
+		// read(..., dict:=...), nor the other read options.
+		MarshalTableOutline(ctx, enc, t)
+		return
+	}
 	MarshalTablePath(enc, t.path, t.fileHandler, t.Hash())
 }
 
 // Attrs implements the Table interface
 func (t *TSVTable) Attrs(ctx context.Context) TableAttrs {
 	t.init(ctx)
-	return TableAttrs{Name: "tsv", Path: t.path, Columns: t.format.Columns}
+	var coercions map[string]int
+	for ci := range t.coercions {
+		if n := atomic.LoadInt64(&t.coercions[ci]); n > 0 {
+			if coercions == nil {
+				coercions = map[string]int{}
+			}
+			coercions[t.format.Columns[ci].Name] = int(n)
+		}
+	}
+	return TableAttrs{Name: "tsv", Path: t.path, Columns: t.format.Columns, Metadata: t.metadata, Coercions: coercions}
+}
+
+// Parallelizable implements ParallelizableTable. Only a table small enough to
+// be loaded in memory can be sharded.
+func (t *TSVTable) Parallelizable(ctx context.Context) bool {
+	t.init(ctx)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.table != nil
 }
 
 // Scanner implements the Table interface.
 func (t *TSVTable) Scanner(ctx context.Context, start, limit, total int) TableScanner {
+	return t.scanner(ctx, start, limit, total, nil)
+}
+
+// scanner creates a scanner that yields the rows that may satisfy the given
+// predicates. See filter_pushdown.go.
+func (t *TSVTable) scanner(ctx context.Context, start, limit, total int, preds []columnPredicate) TableScanner {
 	t.init(ctx)
 	t.mu.Lock()
 	if t.table != nil {
 		t.mu.Unlock()
-		return t.table.Scanner(ctx, start, limit, total)
+		sc := t.table.Scanner(ctx, start, limit, total)
+		if len(preds) > 0 {
+			sc = &columnPredicateScanner{src: sc, preds: preds}
+		}
+		return sc
 	}
 	// The table was too large
 	if start > 0 {
 		t.mu.Unlock()
 		return &NullTableScanner{}
 	}
-	var in file.File
+	var in *retryingFile
 	if t.in != nil {
 		in, t.in = t.in, nil
 	}
 	t.mu.Unlock()
 	if in == nil {
 		var err error
-		in, err = file.Open(ctx, t.path)
+		in, err = openRetryingFile(ctx, t.path)
 		if err != nil {
 			Panicf(t.ast, "tsv open %v: %v", t.path, err)
 		}
@@ -296,12 +583,18 @@ func (t *TSVTable) Scanner(ctx context.Context, start, limit, total int) TableSc
 	sc := &tsvTableScanner{
 		ctx:       ctx,
 		parent:    t,
+		budget:    evalBudgetFromContext(ctx),
 		in:        in,        //takes ownership
 		compressr: compressr, // takes ownership
-		r:         newCSVReader(ctx, compressr),
+		r:         t.newReader(ctx, compressr),
 		tmpCols:   make([]StructField, len(t.format.Columns))}
 	for fi, field := range t.format.Columns {
 		sc.tmpCols[fi].Name = symbol.Intern(field.Name)
+		for _, p := range preds {
+			if p.col == sc.tmpCols[fi].Name {
+				sc.preds = append(sc.preds, tsvCellPredicate{cell: fi, typ: field.Type, pred: p})
+			}
+		}
 	}
 	for i := 0; i < t.format.HeaderLines; i++ {
 		_, err := sc.r.Read()
@@ -337,6 +630,7 @@ func NewTSVTable(path string, ast ASTNode, h hash.Hash, fh FileHandler, format *
 		path:        path,
 		fileHandler: fh,
 		format:      format,
+		delimiter:   '\t',
 		nRows:       -1,
 	}
 	runtime.SetFinalizer(t, func(t *TSVTable) {
@@ -347,20 +641,133 @@ func NewTSVTable(path string, ast ASTNode, h hash.Hash, fh FileHandler, format *
 	return t
 }
 
+// tsvReadOpts is the set of options given to read() for a TSV file. The zero
+// value is the default.
+type tsvReadOpts struct {
+	// dictPath is the data dictionary file. If empty, no dictionary is used. If
+	// tsvAutoDict, the dictionary is looked up next to the TSV file (see
+	// findTSVDict).
+	dictPath string
+	// delimiter separates the cells in a row. If zero, the default for the
+	// file type is used. See tsvDefaultDelimiter.
+	delimiter byte
+	// escape specifies how the cells are decoded.
+	escape tsvEscapeMode
+	// numFormat specifies how the numbers are parsed.
+	numFormat tsvNumberFormat
+	// duplicateColumns specifies how columns with the same name in the header
+	// are handled: "suffix" renames the second and later ones "col_2",
+	// "col_3", etc.; "keep_first" drops them. "" causes an error.
+	duplicateColumns string
+	// strict causes a cell that can't be converted to the column type without
+	// loss, e.g., "1.5" in an int column, to fail. By default, such a cell is
+	// truncated, and the truncations are counted in TableAttrs.Coercions.
+	strict bool
+}
+
+// isDefault checks if opts is the default.
+func (opts tsvReadOpts) isDefault() bool {
+	return opts.dictPath == "" && opts.delimiter == 0 && opts.escape == tsvEscapeNone && opts.numFormat.isDefault() && opts.duplicateColumns == "" && !opts.strict
+}
+
+// newTSVTableWithOpts creates a Table for reading the given TSV or CSV file
+// using the given options. Arg fh is either TSVFileHandler or CSVFileHandler.
+// The column types and descriptions are read from the data dictionary given in
+// opts, if any.
+func newTSVTableWithOpts(path string, ast ASTNode, h hash.Hash, fh FileHandler, opts tsvReadOpts) Table {
+	t := NewTSVTable(path, ast, h, fh, nil).(*TSVTable)
+	t.delimiter = tsvDefaultDelimiter(fh)
+	if opts.delimiter != 0 {
+		t.delimiter = rune(opts.delimiter)
+	}
+	t.findDict = opts.dictPath == tsvAutoDict
+	if !t.findDict {
+		t.dictPath = opts.dictPath
+	}
+	t.escape = opts.escape
+	t.numFormat = opts.numFormat
+	t.duplicateColumns = opts.duplicateColumns
+	t.strict = opts.strict
+	return t
+}
+
+// resolveDuplicateColumns renames or drops the columns whose names appear
+// earlier in the header, as specified by t.duplicateColumns.
+func (t *TSVTable) resolveDuplicateColumns() {
+	names := make(map[string]bool, len(t.format.Columns))
+	for _, col := range t.format.Columns {
+		names[col.Name] = true
+	}
+	seen := make(map[string]int, len(t.format.Columns))
+	cols := make([]TSVColumn, 0, len(t.format.Columns))
+	for ci, col := range t.format.Columns {
+		seen[col.Name]++
+		n := seen[col.Name]
+		if n == 1 {
+			cols = append(cols, col)
+			continue
+		}
+		switch t.duplicateColumns {
+		case "suffix":
+			name := fmt.Sprintf("%s_%d", col.Name, n)
+			for names[name] {
+				n++
+				name = fmt.Sprintf("%s_%d", col.Name, n)
+			}
+			seen[col.Name] = n
+			names[name] = true
+			Logf(t.ast, "read %s: renamed duplicate column '%s' to '%s'", t.path, col.Name, name)
+			col.Name = name
+			cols = append(cols, col)
+		case "keep_first":
+			Logf(t.ast, "read %s: dropped duplicate column '%s' (column %d)", t.path, col.Name, ci)
+			t.dropCols = append(t.dropCols, ci)
+		default:
+			Panicf(t.ast, "read %s: duplicate column '%s' in the header; set duplicate_columns:=\"suffix\" or \"keep_first\"", t.path, col.Name)
+		}
+	}
+	t.format.Columns = cols
+}
+
+// dropDuplicateCells removes the cells listed in t.dropCols from the row.
+func (t *TSVTable) dropDuplicateCells(row []string) []string {
+	if len(t.dropCols) == 0 {
+		return row
+	}
+	out := make([]string, 0, len(row))
+	di := 0
+	for ci, col := range row {
+		if di < len(t.dropCols) && t.dropCols[di] == ci {
+			di++
+			continue
+		}
+		out = append(out, col)
+	}
+	return out
+}
+
 // TSV writer
 
 func (w *defaultTSVWriter) writeRow(cols []string) {
 	w.buf.Reset()
+	delim := w.opts.delimiterOrDefault()
 	for i, col := range cols {
 		if i > 0 {
-			w.buf.WriteByte('\t')
+			w.buf.WriteByte(delim)
 		}
-		for j := 0; j < len(col); j++ {
-			ch := col[j]
-			if ch == '\t' {
-				ch = ' '
+		switch w.opts.escape {
+		case tsvEscapeC:
+			w.writeCEscapedCell(col, i == 0)
+		case tsvEscapeQuote:
+			w.writeQuotedCell(col, i == 0)
+		default:
+			for j := 0; j < len(col); j++ {
+				ch := col[j]
+				if ch == '\t' || ch == delim || ch == '\n' || ch == '\r' {
+					ch = ' '
+				}
+				w.buf.WriteByte(ch)
 			}
-			w.buf.WriteByte(ch)
 		}
 	}
 	w.buf.WriteByte('\n')
@@ -369,13 +776,69 @@ func (w *defaultTSVWriter) writeRow(cols []string) {
 	}
 }
 
+// writeCEscapedCell writes the cell with tabs, newlines, carriage returns, and
+// backslashes encoded as \t, \n, \r, and \\. Double quotes are encoded as \"
+// so that the csv reader doesn't treat the cell as quoted. A '#' at the
+// start of a row is encoded as \# so that the row isn't read as a comment.
+func (w *defaultTSVWriter) writeCEscapedCell(col string, firstCol bool) {
+	for j := 0; j < len(col); j++ {
+		switch ch := col[j]; ch {
+		case '\t':
+			w.buf.WriteString(`\t`)
+		case '\n':
+			w.buf.WriteString(`\n`)
+		case '\r':
+			w.buf.WriteString(`\r`)
+		case '\\', '"':
+			w.buf.WriteByte('\\')
+			w.buf.WriteByte(ch)
+		case '#':
+			if firstCol && j == 0 {
+				w.buf.WriteByte('\\')
+			}
+			w.buf.WriteByte(ch)
+		default:
+			w.buf.WriteByte(ch)
+		}
+	}
+}
+
+// writeQuotedCell writes the cell as specified in RFC4180. A cell that
+// contains a tab, the delimiter, newline, carriage return, or double quote is
+// enclosed in double quotes, and each double quote in it is doubled. A cell
+// that starts a row with '#' is also quoted so that the row isn't read as a
+// comment.
+func (w *defaultTSVWriter) writeQuotedCell(col string, firstCol bool) {
+	if !strings.ContainsAny(col, "\t\n\r\"") && strings.IndexByte(col, w.opts.delimiterOrDefault()) < 0 && !(firstCol && strings.HasPrefix(col, "#")) {
+		w.buf.WriteString(col)
+		return
+	}
+	w.buf.WriteByte('"')
+	for j := 0; j < len(col); j++ {
+		if col[j] == '"' {
+			w.buf.WriteByte('"')
+		}
+		w.buf.WriteByte(col[j])
+	}
+	w.buf.WriteByte('"')
+}
+
 // A dummy column name used to produce an empty table. We can't produce an empty
 // file or tidy dictionary validator will complain.
 var dummyTSVCol = symbol.Intern("dummycol")
 
 // Create a tidy data dictionary file describing columns in the given table.
-func writeTSVDict(ctx context.Context, dictPath string, colIDs []symbol.ID, colTypes []ValueType, colDescs []string, gzipFile bool) {
-	w := newDefaultTSVWriter(ctx, dictPath, []symbol.ID{symbol.Intern("column_name"), symbol.Intern("type"), symbol.Intern("description")}, true, gzipFile)
+// The "unit" column is added only if some column has a unit.
+func writeTSVDict(ctx context.Context, dictPath string, colIDs []symbol.ID, colTypes []ValueType, colDescs, colUnits []string, gzipFile bool) {
+	header := []symbol.ID{symbol.Intern("column_name"), symbol.Intern("type"), symbol.Intern("description")}
+	hasUnits := false
+	for _, unit := range colUnits {
+		hasUnits = hasUnits || unit != ""
+	}
+	if hasUnits {
+		header = append(header, symbol.Intern("unit"))
+	}
+	w := newDefaultTSVWriter(ctx, dictPath, header, true, gzipFile, tsvWriterOpts{})
 	for ci, colID := range colIDs {
 		colName := colID.Str()
 		typeName := ""
@@ -396,7 +859,7 @@ func writeTSVDict(ctx context.Context, dictPath string, colIDs []symbol.ID, colT
 		case FileNameType:
 			typeName = "filename"
 		case TableType:
-			typeName = "filename"
+			typeName = "table"
 		case EnumType:
 			// TODO(saito) show enum values.
 			typeName = "enum:"
@@ -415,6 +878,10 @@ func writeTSVDict(ctx context.Context, dictPath string, colIDs []symbol.ID, colT
 		if colDescs != nil && colDescs[ci] != "" {
 			desc = colDescs[ci]
 		}
+		if hasUnits {
+			w.writeRow([]string{colName, typeName, desc, colUnits[ci]})
+			continue
+		}
 		w.writeRow([]string{colName, typeName, desc})
 	}
 	w.Close()
@@ -449,27 +916,254 @@ func (m tsvColumnMap) lookupByID(col symbol.ID) int {
 	return -1
 }
 
+// tsvWriterOpts is the set of optional parameters for writing a TSV file.
+type tsvWriterOpts struct {
+	// colOrder, if nonempty, specifies the column order. See orderTSVColumns
+	// for its format.
+	colOrder []string
+	// delimiter separates the cells in a row. If zero, '\t' is used.
+	delimiter byte
+	// floatFormat, if nonempty, is the fmt format used to print floats, e.g.,
+	// "%.3f". If empty, a float is printed in the shortest form that parses
+	// back to the same value.
+	floatFormat string
+	// trimFloatZero causes floats with integral values to be written without
+	// the trailing ".0", e.g., "3" instead of "3.0". The column may then be read
+	// back as integers.
+	trimFloatZero bool
+	// escape specifies how tabs, newlines, etc. in the cells are encoded.
+	escape tsvEscapeMode
+	// metadata is the list of "key=value" lines written as "##key=value"
+	// before the header line. See tsvMetadataLines.
+	metadata []string
+	// appendMode causes the rows to be added to the existing file, if any. See
+	// writeTSVFile.
+	appendMode bool
+	// appendFrom, if nonempty, is the file whose contents are copied before the
+	// rows. Set internally for appendMode.
+	appendFrom string
+	// nestedTables causes the cells that contain tables to be written as btsv
+	// files under tsvNestedTableDir, with the cells storing their paths. See
+	// writeNestedTable.
+	nestedTables bool
+	// bgzip causes a gzip-compressed file to be written in the BGZF format, so
+	// that it can be indexed by tabix.
+	bgzip bool
+}
+
+// delimiterOrDefault returns the delimiter of the cells.
+func (opts tsvWriterOpts) delimiterOrDefault() byte {
+	if opts.delimiter == 0 {
+		return '\t'
+	}
+	return opts.delimiter
+}
+
+// tsvEscapeMode specifies how tabs, newlines, and other special characters in
+// TSV cells are encoded.
+type tsvEscapeMode int
+
+const (
+	// tsvEscapeNone replaces tabs and newlines with spaces on write. On read,
+	// cells are taken verbatim, except that a cell enclosed in double quotes is
+	// unquoted.
+	tsvEscapeNone tsvEscapeMode = iota
+	// tsvEscapeC encodes the special characters C-style, e.g., "\t" and "\n".
+	tsvEscapeC
+	// tsvEscapeQuote encloses cells with special characters in double quotes,
+	// as specified in RFC4180.
+	tsvEscapeQuote
+)
+
+// String returns the name of the mode, as accepted by parseTSVEscapeMode.
+func (m tsvEscapeMode) String() string {
+	switch m {
+	case tsvEscapeC:
+		return "c"
+	case tsvEscapeQuote:
+		return "quote"
+	default:
+		return "none"
+	}
+}
+
+// parseTSVEscapeMode parses the value of the escape:= arg of read and write.
+func parseTSVEscapeMode(ast ASTNode, s string) tsvEscapeMode {
+	switch s {
+	case "", "none":
+		return tsvEscapeNone
+	case "c":
+		return tsvEscapeC
+	case "quote":
+		return tsvEscapeQuote
+	}
+	Panicf(ast, "escape '%s': must be one of \"none\", \"c\", or \"quote\"", s)
+	return tsvEscapeNone
+}
+
+// parseTSVDelimiter parses the value of the delimiter:= arg of read and write.
+// It returns zero for "", which stands for the default delimiter of the file
+// type.
+func parseTSVDelimiter(ast ASTNode, s string) byte {
+	if s == "" {
+		return 0
+	}
+	if len(s) != 1 || s[0] >= utf8.RuneSelf || strings.ContainsAny(s, "\"#\r\n") {
+		Panicf(ast, "delimiter '%s': must be a single ASCII character other than '\"', '#', or a newline", s)
+	}
+	return s[0]
+}
+
+// unescapeRow decodes the cells of a row read from a TSV file in place. The
+// csv reader already unquotes cells, so only tsvEscapeC needs decoding.
+func (m tsvEscapeMode) unescapeRow(row []string) {
+	if m != tsvEscapeC {
+		return
+	}
+	for i, col := range row {
+		if strings.IndexByte(col, '\\') >= 0 {
+			row[i] = unescapeCTSVCell(col)
+		}
+	}
+}
+
+// unescapeCTSVCell decodes a cell written by writeCEscapedCell. A backslash
+// followed by a character other than 't', 'n', or 'r' yields that character.
+func unescapeCTSVCell(col string) string {
+	buf := strings.Builder{}
+	buf.Grow(len(col))
+	for j := 0; j < len(col); j++ {
+		ch := col[j]
+		if ch == '\\' && j+1 < len(col) {
+			j++
+			switch ch = col[j]; ch {
+			case 't':
+				ch = '\t'
+			case 'n':
+				ch = '\n'
+			case 'r':
+				ch = '\r'
+			}
+		}
+		buf.WriteByte(ch)
+	}
+	return buf.String()
+}
+
+// tsvNumberFormat specifies the separators of the numbers in a TSV file, as
+// set by the thousands:= and decimal:= args of read. The zero value stands
+// for the default format, e.g., "1234.5".
+type tsvNumberFormat struct {
+	thousands, decimal string
+	// re matches a number in this format. It is nil for the default format.
+	re *regexp.Regexp
+}
+
+// parseTSVNumberFormat parses the thousands:= and decimal:= args of read. Each
+// separator must be a single character, or thousands may be "".
+func parseTSVNumberFormat(ast ASTNode, thousands, decimal string) tsvNumberFormat {
+	if utf8.RuneCountInString(decimal) != 1 || utf8.RuneCountInString(thousands) > 1 || thousands == decimal ||
+		strings.ContainsAny(thousands+decimal, "0123456789+-eE\t") {
+		Panicf(ast, "thousands '%s', decimal '%s': the separators must be distinct single characters, other than digits and signs", thousands, decimal)
+	}
+	if thousands == "" && decimal == "." {
+		return tsvNumberFormat{}
+	}
+	intPart := `\d+`
+	if thousands != "" {
+		intPart = `\d{1,3}(?:` + regexp.QuoteMeta(thousands) + `\d{3})+|\d+`
+	}
+	return tsvNumberFormat{
+		thousands: thousands,
+		decimal:   decimal,
+		re:        regexp.MustCompile(`^[+-]?(?:` + intPart + `)(?:` + regexp.QuoteMeta(decimal) + `\d*)?(?:[eE][+-]?\d+)?$`),
+	}
+}
+
+// isDefault checks if f is the default format.
+func (f tsvNumberFormat) isDefault() bool { return f.re == nil }
+
+// String returns a description of the format.
+func (f tsvNumberFormat) String() string {
+	return fmt.Sprintf("thousands:%q,decimal:%q", f.thousands, f.decimal)
+}
+
+// normalizeRow rewrites the cells that are numbers in format f into the
+// default format in place, e.g., "1.234,5" into "1234.5". Other cells are
+// unchanged, so they are read as strings.
+func (f tsvNumberFormat) normalizeRow(row []string) {
+	if f.re == nil {
+		return
+	}
+	for i, col := range row {
+		if !f.re.MatchString(col) {
+			continue
+		}
+		if f.thousands != "" {
+			col = strings.Replace(col, f.thousands, "", -1)
+		}
+		row[i] = strings.Replace(col, f.decimal, ".", 1)
+	}
+}
+
+// validateTSVFloatFormat checks that format is a fmt format that takes one
+// float, e.g., "%.17g".
+func validateTSVFloatFormat(ast ASTNode, format string) {
+	if format == "" {
+		return
+	}
+	if n := strings.Count(format, "%") - 2*strings.Count(format, "%%"); n != 1 {
+		Panicf(ast, "float_format '%s': must contain exactly one verb, e.g., '%%.17g'", format)
+	}
+	if s := fmt.Sprintf(format, 1.5); strings.Contains(s, "%!") {
+		Panicf(ast, "float_format '%s': invalid format for a float: %s", format, s)
+	}
+}
+
+// isTSVIntString checks if the string is a decimal integer, e.g., "-12".
+func isTSVIntString(s string) bool {
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		s = s[1:]
+	}
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 type defaultTSVWriter struct {
 	ctx            context.Context
 	path           string
+	opts           tsvWriterOpts
 	out            file.File
 	w              io.Writer
 	closeCallbacks []func()
+	// outBytes counts the bytes written to out.
+	outBytes *countingWriter
 
 	buf     bytes.Buffer
 	colMap  tsvColumnMap
 	tmpBuf  *termutil.BufferPrinter
 	tmpVars TmpVars
 	colVals []string
+	// nestedWritten records the nested tables written so far. Used only if
+	// opts.nestedTables is set.
+	nestedWritten map[hash.Hash]bool
 }
 
-func newDefaultTSVWriter(ctx context.Context, path string, colIDs []symbol.ID, headerLine, gzipFile bool) *defaultTSVWriter {
+func newDefaultTSVWriter(ctx context.Context, path string, colIDs []symbol.ID, headerLine, gzipFile bool, opts tsvWriterOpts) *defaultTSVWriter {
 	if len(colIDs) == 0 {
 		panic(path)
 	}
 	w := &defaultTSVWriter{
 		ctx:    ctx,
 		path:   path,
+		opts:   opts,
 		colMap: newTSVColumnMap(colIDs),
 		tmpBuf: termutil.NewBufferPrinter(),
 	}
@@ -477,9 +1171,18 @@ func newDefaultTSVWriter(ctx context.Context, path string, colIDs []symbol.ID, h
 	if w.out, err = file.Create(ctx, w.path); err != nil {
 		log.Panicf("writetsvdata %s: %v", path, err)
 	}
-	w.w = w.out.Writer(ctx)
+	w.outBytes = &countingWriter{w: w.out.Writer(ctx)}
+	w.w = w.outBytes
 
-	if gzipFile {
+	if gzipFile && opts.bgzip {
+		bwr := bgzf.NewWriter(w.w, runtime.GOMAXPROCS(0))
+		w.w = bwr
+		w.closeCallbacks = append(w.closeCallbacks, func() {
+			if err := bwr.Close(); err != nil {
+				log.Panicf("writetsvdata %v: %v", path, err)
+			}
+		})
+	} else if gzipFile {
 		gwr := gzip.NewWriter(w.w)
 		w.w = gwr
 		w.closeCallbacks = append(w.closeCallbacks, func() {
@@ -498,6 +1201,14 @@ func newDefaultTSVWriter(ctx context.Context, path string, colIDs []symbol.ID, h
 		}
 	})
 	w.w = buffered
+	if opts.appendFrom != "" {
+		w.copyFrom(opts.appendFrom)
+	}
+	for _, line := range opts.metadata {
+		if _, err := io.WriteString(w.w, "##"+line+"\n"); err != nil {
+			log.Panic(err)
+		}
+	}
 	if headerLine {
 		colNames := make([]string, len(w.colMap.ids))
 		for ci, colID := range w.colMap.ids {
@@ -509,10 +1220,54 @@ func newDefaultTSVWriter(ctx context.Context, path string, colIDs []symbol.ID, h
 	return w
 }
 
+// copyFrom copies the contents of the given file to the output. It adds a
+// newline if the file doesn't end with one. A compressed file is decompressed.
+func (w *defaultTSVWriter) copyFrom(path string) {
+	in, err := file.Open(w.ctx, path)
+	if err != nil {
+		log.Panicf("write %v: open %v: %v", w.path, path, err)
+	}
+	defer in.Close(w.ctx) // nolint: errcheck
+	compressr, _ := compress.NewReader(in.Reader(w.ctx))
+	defer compressr.Close() // nolint: errcheck
+	data, err := ioutil.ReadAll(compressr)
+	if err != nil {
+		log.Panicf("write %v: read %v: %v", w.path, path, err)
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	if _, err := w.w.Write(data); err != nil {
+		log.Panic(err)
+	}
+}
+
+// formatFloat converts a float to a string, as specified in w.opts. By
+// default, the string parses back to the same value, and a float with an
+// integral value keeps its ".0", so that the column is read back as floats.
+func (w *defaultTSVWriter) formatFloat(f float64) string {
+	var s string
+	if w.opts.floatFormat != "" {
+		s = fmt.Sprintf(w.opts.floatFormat, f)
+	} else {
+		s = strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	if !w.opts.trimFloatZero && isTSVIntString(s) {
+		s += ".0"
+	}
+	return s
+}
+
 func (w *defaultTSVWriter) valueToString(v Value) string {
 	if v.Type() == InvalidType {
 		panic(v)
 	}
+	if v.Type() == FloatType {
+		return w.formatFloat(v.Float(nil))
+	}
+	if v.Type() == TableType && w.opts.nestedTables {
+		return w.writeNestedTable(v.Table(nil))
+	}
 	w.tmpBuf.Reset()
 	v.Print(w.ctx, PrintArgs{
 		Out:     w.tmpBuf,
@@ -522,6 +1277,23 @@ func (w *defaultTSVWriter) valueToString(v Value) string {
 	return w.tmpBuf.String()
 }
 
+// writeNestedTable writes a table stored in a cell as a btsv file under
+// tsvNestedTableDir(w.path). It returns the path of the btsv file relative to
+// the directory of the TSV file. The btsv file is named after the table hash,
+// so a table that appears in many cells is written once.
+func (w *defaultTSVWriter) writeNestedTable(t Table) string {
+	dir := tsvNestedTableDir(w.path)
+	name := t.Hash().String() + ".btsv"
+	if !w.nestedWritten[t.Hash()] {
+		if w.nestedWritten == nil {
+			w.nestedWritten = map[hash.Hash]bool{}
+		}
+		writeBTSVTable(w.ctx, file.Join(dir, name), astUnknown, t, 1, true, btsvWriterOpts{})
+		w.nestedWritten[t.Hash()] = true
+	}
+	return file.Base(dir) + "/" + name
+}
+
 // Append implements tsvWriter
 func (w *defaultTSVWriter) Append(v Value) {
 	if v.Type() != StructType {
@@ -552,6 +1324,7 @@ func (w *defaultTSVWriter) Close() {
 	if err := w.out.Close(w.ctx); err != nil {
 		log.Panicf("writetsvdata %v: close: %v", w.path, err)
 	}
+	reportFileWrite(w.path, w.outBytes.n)
 }
 
 // Discard implements tsvWriter
@@ -581,10 +1354,11 @@ func maybeAddDummyColumn(colIDs []symbol.ID, colTypes []ValueType, colDescs []st
 // The arg writerFactory is a function that creates a tsvWriter for the given
 // set of columns. It may be called multiple times, but never concurrently.  Arg
 // table is the source table. gzipFiles controls whether the contents should be
-// gzip compressed.
+// gzip compressed. colOrder, if nonempty, specifies the order of the columns in
+// the output. See orderTSVColumns for its format.
 func writeTSVHelper(ctx context.Context,
 	writerFactory func(colIDs []symbol.ID) tsvWriter,
-	dictPath string, table Table, gzipFiles bool) {
+	dictPath string, table Table, gzipFiles bool, colOrder []string) {
 
 	// If the table is a btsvTable, or it already has a dump in the cache dir,
 	// skip the first step.
@@ -593,9 +1367,11 @@ func writeTSVHelper(ctx context.Context,
 		cacheName := table.Hash().String() + ".btsv"
 		btsvPath, found := LookupCache(ctx, cacheName)
 		if !found {
+			defer abandonCacheEntry(ctx, btsvPath)
 			// Step 1.
-			done := tryWriteToTSVAndBTSV(ctx, writerFactory, dictPath, btsvPath, table, gzipFiles)
+			done := tryWriteToTSVAndBTSV(ctx, writerFactory, dictPath, btsvPath, table, gzipFiles, colOrder)
 			ActivateCache(ctx, cacheName, btsvPath)
+			reportTableMaterialized(table.Hash(), btsvPath, -1)
 			if done {
 				return
 			}
@@ -627,6 +1403,12 @@ func writeTSVHelper(ctx context.Context,
 		colTypes[ci] = colMap[colID].typ
 		colDescs[ci] = colMap[colID].description
 	}
+	if len(colOrder) > 0 && len(colIDs) > 0 {
+		var missing string
+		if colIDs, colTypes, colDescs, missing = orderTSVColumns(colOrder, colIDs, colTypes, colDescs); missing != "" {
+			log.Panicf("write: column_order: column '%s' not found in the table", missing)
+		}
+	}
 	colIDs, colTypes, colDescs = maybeAddDummyColumn(colIDs, colTypes, colDescs)
 	w := writerFactory(colIDs)
 	sc := table.Scanner(ctx, 0, 1, 1)
@@ -635,8 +1417,129 @@ func writeTSVHelper(ctx context.Context,
 	}
 	w.Close()
 	if dictPath != "" {
-		writeTSVDict(ctx, dictPath, colIDs, colTypes, colDescs, gzipFiles)
+		writeTSVDict(ctx, dictPath, colIDs, colTypes, colDescs, tsvColumnUnits(table.Attrs(ctx), colIDs), gzipFiles)
+	}
+}
+
+// tsvRestOfColumns is the placeholder in a column order that stands for the
+// columns not listed explicitly.
+const tsvRestOfColumns = "..."
+
+// orderTSVColumns reorders the columns as specified in order. Order is a list
+// of column names. It may contain "..." once, which stands for the columns not
+// listed in order, in their original order. If order does not contain "...",
+// the unlisted columns are placed after the listed ones. Args colTypes and
+// colDescs, if non-nil, are permuted along with colIDs. If a column in order is
+// not in colIDs, it returns its name as "missing".
+func orderTSVColumns(order []string, colIDs []symbol.ID, colTypes []ValueType, colDescs []string) (
+	newIDs []symbol.ID, newTypes []ValueType, newDescs []string, missing string) {
+	colIdx := make(map[symbol.ID]int, len(colIDs))
+	for ci, colID := range colIDs {
+		colIdx[colID] = ci
+	}
+	var (
+		head, tail []int // indexes of the columns before & after "...".
+		listed     = make([]bool, len(colIDs))
+		seenRest   bool
+	)
+	for _, name := range order {
+		if name == tsvRestOfColumns {
+			seenRest = true
+			continue
+		}
+		ci, ok := colIdx[symbol.Intern(name)]
+		if !ok {
+			return nil, nil, nil, name
+		}
+		listed[ci] = true
+		if seenRest {
+			tail = append(tail, ci)
+		} else {
+			head = append(head, ci)
+		}
 	}
+	perm := head
+	for ci := range colIDs {
+		if !listed[ci] {
+			perm = append(perm, ci)
+		}
+	}
+	perm = append(perm, tail...)
+	newIDs = make([]symbol.ID, len(perm))
+	for i, ci := range perm {
+		newIDs[i] = colIDs[ci]
+	}
+	if colTypes != nil {
+		newTypes = make([]ValueType, len(perm))
+		for i, ci := range perm {
+			newTypes[i] = colTypes[ci]
+		}
+	}
+	if colDescs != nil {
+		newDescs = make([]string, len(perm))
+		for i, ci := range perm {
+			newDescs[i] = colDescs[ci]
+		}
+	}
+	return newIDs, newTypes, newDescs, ""
+}
+
+// parseTSVColumnOrder reads the list of column names given to
+// write(..., column_order:=...), e.g., table("chrom", "start", "end", "...").
+// Arg order is either a table of strings or Null.
+func parseTSVColumnOrder(ctx context.Context, ast ASTNode, order Value) []string {
+	if order.Type() != TableType {
+		return nil
+	}
+	var (
+		cols []string
+		seen = map[string]bool{}
+	)
+	sc := order.Table(ast).Scanner(ctx, 0, 1, 1)
+	for sc.Scan() {
+		v := sc.Value()
+		if v.Type() != StringType {
+			Panicf(ast, "column_order: column names must be strings, but found %v", v)
+		}
+		col := v.Str(ast)
+		if col == "" {
+			Panicf(ast, "column_order: empty column name")
+		}
+		if seen[col] {
+			Panicf(ast, "column_order: column '%s' listed more than once", col)
+		}
+		seen[col] = true
+		cols = append(cols, col)
+	}
+	return cols
+}
+
+// tsvColumnDescriptions returns the descriptions of the given columns, as
+// listed in attrs.Columns. A column without a description yields "".
+func tsvColumnDescriptions(attrs TableAttrs, colIDs []symbol.ID) []string {
+	descs := make([]string, len(colIDs))
+	for _, col := range attrs.Columns {
+		for ci, colID := range colIDs {
+			if colID.Str() == col.Name {
+				descs[ci] = col.Description
+			}
+		}
+	}
+	return descs
+}
+
+// tsvColumnUnits returns the units of the given columns, as listed in
+// attrs.Columns. A column without a unit yields "".
+func tsvColumnUnits(attrs TableAttrs, colIDs []symbol.ID) []string {
+	units := make([]string, len(colIDs))
+	for _, col := range attrs.Columns {
+		for ci, colID := range colIDs {
+			if colID.Str() == col.Name {
+				units[ci] = col.Unit
+			}
+		}
+	}
+	return units
 }
 
 // tryWriteToTSVAndBTSV does the first step of writeTSVHelper. It writes
@@ -647,7 +1550,7 @@ func writeTSVHelper(ctx context.Context,
 func tryWriteToTSVAndBTSV(
 	ctx context.Context,
 	writerFactory func(colIDs []symbol.ID) tsvWriter,
-	dictPath, btsvPath string, table Table, gzipFiles bool) bool {
+	dictPath, btsvPath string, table Table, gzipFiles bool, colOrder []string) bool {
 	var (
 		wg       sync.WaitGroup
 		tsvOK    = true // do all the rows we've seen so far have the same layout?
@@ -659,8 +1562,9 @@ func tryWriteToTSVAndBTSV(
 		colFound []bool
 		tsvReqCh chan []Value
 
+		attrs     = table.Attrs(ctx)
 		btsvReqCh = make(chan []Value, 100)
-		btsvW     = NewBTSVShardWriter(ctx, btsvPath, 0, 1, table.Attrs(ctx))
+		btsvW     = NewBTSVShardWriter(ctx, btsvPath, 0, 1, attrs)
 	)
 
 	// Start the BTSV writer.  The TSV writer will be created after seeing the
@@ -693,21 +1597,31 @@ func tryWriteToTSVAndBTSV(
 				colIDs = append(colIDs, row.Field(i).Name)
 				colTypes = append(colTypes, row.Field(i).Value.Type())
 			}
+			if len(colOrder) > 0 && len(colIDs) > 0 {
+				var missing string
+				if colIDs, colTypes, _, missing = orderTSVColumns(colOrder, colIDs, colTypes, nil); missing != "" {
+					// The column may appear in later rows. Let step 2 of
+					// writeTSVHelper handle the table.
+					tsvOK = false
+				}
+			}
 			colIDs, colTypes, _ = maybeAddDummyColumn(colIDs, colTypes, nil)
 			colMap = newTSVColumnMap(colIDs)
 			colFound = make([]bool, len(colIDs))
 
-			tsvW = writerFactory(colIDs)
-			tsvReqCh = make(chan []Value, 100)
-			wg.Add(1)
-			go func() {
-				for rows := range tsvReqCh {
-					for _, row := range rows {
-						tsvW.Append(row)
+			if tsvOK {
+				tsvW = writerFactory(colIDs)
+				tsvReqCh = make(chan []Value, 100)
+				wg.Add(1)
+				go func() {
+					for rows := range tsvReqCh {
+						for _, row := range rows {
+							tsvW.Append(row)
+						}
 					}
-				}
-				wg.Done()
-			}()
+					wg.Done()
+				}()
+			}
 		} else {
 			// Verify that this row has the same layout as the first row's.
 			if tsvOK {
@@ -744,7 +1658,7 @@ func tryWriteToTSVAndBTSV(
 	}
 
 	close(btsvReqCh)
-	if tsvW != nil {
+	if tsvReqCh != nil {
 		close(tsvReqCh)
 	}
 	wg.Wait()
@@ -763,9 +1677,9 @@ func tryWriteToTSVAndBTSV(
 			}
 			tsvW.Close()
 			if dictPath != "" {
-				writeTSVDict(ctx, dictPath, colIDs, colTypes, nil, gzipFiles)
+				writeTSVDict(ctx, dictPath, colIDs, colTypes, tsvColumnDescriptions(attrs, colIDs), tsvColumnUnits(attrs, colIDs), gzipFiles)
 			}
-		} else {
+		} else if tsvW != nil {
 			tsvW.Discard()
 		}
 		return nil
@@ -778,8 +1692,8 @@ func tryWriteToTSVAndBTSV(
 // compressed using gzip.
 func WriteTSV(ctx context.Context, path string, table Table, headerLine, gzip bool) {
 	writeTSVHelper(ctx, func(colIDs []symbol.ID) tsvWriter {
-		return newDefaultTSVWriter(ctx, path, colIDs, headerLine, gzip)
-	}, "", table, gzip)
+		return newDefaultTSVWriter(ctx, path, colIDs, headerLine, gzip, tsvWriterOpts{})
+	}, "", table, gzip, nil)
 }
 
 // TSVFileHandler is a FileHandler implementation for TSV files.
@@ -796,18 +1710,157 @@ func (fh *tsvFileHandler) Name() string { return "tsv" }
 
 // Open implements FileHandler.
 func (fh *tsvFileHandler) Open(ctx context.Context, path string, ast ASTNode, hash hash.Hash) Table {
-	return NewTSVTable(path, ast, hash, fh, nil)
+	return newTSVTableWithOpts(path, ast, hash, fh, tsvReadOpts{})
 }
 
 // Write implements FileHandler.
 func (*tsvFileHandler) Write(ctx context.Context, path string, ast ASTNode, table Table, nShard int, overwrite bool) {
-	if _, err := file.Stat(ctx, path); err == nil {
+	writeTSVFile(ctx, path, table, overwrite, tsvWriterOpts{})
+}
+
+// writeTSVFile writes the table to a TSV file with a header line. If the
+// table has column descriptions, or opts.nestedTables is set, it also writes
+// the data dictionary next to the file.
+//
+// If opts.appendMode is set and the file exists, the rows are added to the
+// file. The file is rewritten with the new rows after the existing contents,
+// without the header line. The columns of the table must match the header.
+func writeTSVFile(ctx context.Context, path string, table Table, overwrite bool, opts tsvWriterOpts) {
+	if opts.appendMode {
+		if header := readTSVHeader(ctx, path, opts); len(header) > 0 {
+			opts.appendFrom = path
+			opts.metadata = nil
+			writeTSVHelper(ctx, func(colIDs []symbol.ID) tsvWriter {
+				return newDefaultTSVWriter(ctx, path, tsvAppendColumns(path, header, colIDs), false, tsvGzipOutput(path, opts), opts)
+			}, "", table, false, nil)
+			return
+		}
+	} else if _, err := file.Stat(ctx, path); err == nil {
 		if !overwrite {
 			log.Printf("write %v: file already exists and --overwrite-files=false.", path)
 			return
 		}
 	}
-	WriteTSV(ctx, path, table, true, false)
+	dictPath := ""
+	if hasColumnDescriptions(table.Attrs(ctx)) || opts.nestedTables {
+		dictPath = tsvDictPath(path)
+	}
+	writeTSVHelper(ctx, func(colIDs []symbol.ID) tsvWriter {
+		return newDefaultTSVWriter(ctx, path, colIDs, true, tsvGzipOutput(path, opts), opts)
+	}, dictPath, table, false, opts.colOrder)
+}
+
+// tsvGzipOutput checks if a TSV, CSV, BED, or JSON file written to the path
+// should be gzip-compressed, i.e., if the path ends with ".gz". Other
+// compression suffixes are not supported for writing. Option bgzip requires a
+// ".gz" suffix.
+func tsvGzipOutput(path string, opts tsvWriterOpts) bool {
+	switch m := compressionSuffixRE.FindString(path); m {
+	case ".gz":
+		return true
+	case "":
+		if opts.bgzip {
+			log.Panicf("write %v: bgzip:=true requires a path that ends with \".gz\"", path)
+		}
+		return false
+	default:
+		log.Panicf("write %v: writing %s-compressed files is not supported; use \".gz\"", path, m[1:])
+		return false
+	}
+}
+
+// compressionSuffixRE matches the compression suffix of a path, if any.
+var compressionSuffixRE = regexp.MustCompile(OptionalCompression)
+
+// readTSVHeader reads the column names in the header line of the TSV file
+// written using the given options. It returns nil if the file doesn't exist or
+// is empty.
+func readTSVHeader(ctx context.Context, path string, opts tsvWriterOpts) []string {
+	in, err := file.Open(ctx, path)
+	if err != nil {
+		if errors.Is(errors.NotExist, err) || os.IsNotExist(err) {
+			return nil
+		}
+		log.Panicf("write %v: open: %v", path, err)
+	}
+	defer in.Close(ctx) // nolint: errcheck
+	compressr, _ := compress.NewReader(in.Reader(ctx))
+	defer compressr.Close() // nolint: errcheck
+	br := bufio.NewReader(compressr)
+	readTSVMetadata(br)
+	csvr := newCSVReader(ctx, br)
+	csvr.Comma = rune(opts.delimiterOrDefault())
+	header, err := csvr.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		log.Panicf("write %v: read header: %v", path, err)
+	}
+	opts.escape.unescapeRow(header)
+	return header
+}
+
+// tsvAppendColumns checks that the columns of the table match the header of
+// the file being appended to. It returns the columns in the header order.
+func tsvAppendColumns(path string, header []string, colIDs []symbol.ID) []symbol.ID {
+	headerIDs := make([]symbol.ID, len(header))
+	for i, name := range header {
+		headerIDs[i] = symbol.Intern(name)
+	}
+	if len(colIDs) == 1 && colIDs[0] == dummyTSVCol {
+		// The table is empty.
+		return headerIDs
+	}
+	match := len(colIDs) == len(headerIDs)
+	if match {
+		cols := make(map[symbol.ID]bool, len(colIDs))
+		for _, colID := range colIDs {
+			cols[colID] = true
+		}
+		for _, colID := range headerIDs {
+			if !cols[colID] {
+				match = false
+				break
+			}
+		}
+	}
+	if !match {
+		names := make([]string, len(colIDs))
+		for i, colID := range colIDs {
+			names[i] = colID.Str()
+		}
+		log.Panicf("write %v: append: columns %v don't match the columns %v in the file", path, names, header)
+	}
+	return headerIDs
+}
+
+// hasColumnDescriptions checks if any column in attrs has a description or a
+// unit, e.g., one set by with_attrs.
+func hasColumnDescriptions(attrs TableAttrs) bool {
+	for _, col := range attrs.Columns {
+		if col.Description != "" || col.Unit != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// tsvSuffixRE matches the suffix of a TSV or CSV file.
+var tsvSuffixRE = regexp.MustCompile(`\.[tc]sv` + OptionalCompression)
+
+// tsvNestedTableDir computes the directory that stores the nested tables of
+// the given TSV or CSV file written with write(..., nested_tables:="file").
+// For example, for "foo.tsv", it returns "foo_tables".
+func tsvNestedTableDir(path string) string {
+	return tsvSuffixRE.ReplaceAllString(path, "") + "_tables"
+}
+
+// tsvDictPath computes the path of the data dictionary for the given TSV or CSV
+// file. For example, for "foo.tsv.gz", it returns "foo_data_dictionary.tsv".
+// The dictionary of a CSV file is also a TSV file.
+func tsvDictPath(path string) string {
+	return tsvSuffixRE.ReplaceAllString(path, "") + "_data_dictionary.tsv"
 }
 
 func init() {
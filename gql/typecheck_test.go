@@ -130,7 +130,7 @@ func testTypeCheckOk(t *testing.T, expr string) ValueType {
 
 func TestTypeCheckErrors(t *testing.T) {
 	testTypeCheckError(t, "read(10)", "wrong argument type")
-	testTypeCheckError(t, "read(blahblah)", "variable not found")
+	testTypeCheckError(t, "read(blahblah)", "variable 'blahblah' not found")
 	testTypeCheckError(t, "string_len(10)", "wrong argument type")
 	testTypeCheckError(t, "-{a:1}", "wrong argument type")
 	testTypeCheckError(t, "flatten(10)", "wrong argument type")
@@ -0,0 +1,335 @@
+package gql
+
+// This file implements column units. A unit is attached to a column by
+// with_attrs(units:=...) or by the "unit" column of a data dictionary, and is
+// kept in TSVColumn.Unit. Convert_unit converts values between units, and map
+// and filter warn about expressions that mix columns of different units.
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+)
+
+// unitDef defines a unit. A value v in the unit equals v*scale in the base unit
+// of the dimension.
+type unitDef struct {
+	dim   string
+	scale float64
+}
+
+// knownUnits lists the units understood by convert_unit. The names are case
+// sensitive. "µ" is accepted in place of "u".
+var knownUnits = map[string]unitDef{
+	"ns":  {"time", 1e-9},
+	"us":  {"time", 1e-6},
+	"ms":  {"time", 1e-3},
+	"s":   {"time", 1},
+	"min": {"time", 60},
+	"h":   {"time", 3600},
+	"d":   {"time", 86400},
+
+	"bp": {"sequence length", 1},
+	"kb": {"sequence length", 1e3},
+	"Mb": {"sequence length", 1e6},
+	"Gb": {"sequence length", 1e9},
+
+	"x": {"coverage", 1},
+
+	"fraction": {"ratio", 1},
+	"%":        {"ratio", 1e-2},
+
+	"pg": {"mass", 1e-12},
+	"ng": {"mass", 1e-9},
+	"ug": {"mass", 1e-6},
+	"mg": {"mass", 1e-3},
+	"g":  {"mass", 1},
+
+	"uL": {"volume", 1e-6},
+	"mL": {"volume", 1e-3},
+	"L":  {"volume", 1},
+
+	"pg/mL": {"mass concentration", 1e-9},
+	"ng/mL": {"mass concentration", 1e-6},
+	"ug/mL": {"mass concentration", 1e-3},
+	"mg/mL": {"mass concentration", 1},
+	"pg/uL": {"mass concentration", 1e-6},
+	"ng/uL": {"mass concentration", 1e-3},
+	"ug/uL": {"mass concentration", 1},
+	"ng/L":  {"mass concentration", 1e-9},
+	"ug/L":  {"mass concentration", 1e-6},
+	"mg/L":  {"mass concentration", 1e-3},
+	"g/L":   {"mass concentration", 1},
+
+	"pM": {"molar concentration", 1e-12},
+	"nM": {"molar concentration", 1e-9},
+	"uM": {"molar concentration", 1e-6},
+	"mM": {"molar concentration", 1e-3},
+	"M":  {"molar concentration", 1},
+}
+
+// lookupUnit finds the definition of the unit.
+func lookupUnit(unit string) (unitDef, bool) {
+	def, ok := knownUnits[strings.Replace(strings.TrimSpace(unit), "µ", "u", -1)]
+	return def, ok
+}
+
+// convertUnit converts v from unit "from" to unit "to". Any unit can be
+// converted to itself, even if it is not in knownUnits.
+func convertUnit(ast ASTNode, v float64, from, to string) float64 {
+	if from == to {
+		return v
+	}
+	fromDef, ok := lookupUnit(from)
+	if !ok {
+		Panicf(ast, "convert_unit: unknown unit '%s'", from)
+	}
+	toDef, ok := lookupUnit(to)
+	if !ok {
+		Panicf(ast, "convert_unit: unknown unit '%s'", to)
+	}
+	if fromDef.dim != toDef.dim {
+		Panicf(ast, "convert_unit: cannot convert %s (%s) to %s (%s)", from, fromDef.dim, to, toDef.dim)
+	}
+	if fromDef.scale == toDef.scale {
+		return v
+	}
+	return v * fromDef.scale / toDef.scale
+}
+
+// convertValueUnit converts a cell value from unit "from" to unit "to". A
+// duration value is converted from nanoseconds, regardless of "from". NA is
+// returned as is.
+func convertValueUnit(ast ASTNode, v Value, from, to string) Value {
+	switch v.Type() {
+	case NullType:
+		return v
+	case IntType:
+		return NewFloat(convertUnit(ast, float64(v.Int(ast)), from, to))
+	case FloatType:
+		return NewFloat(convertUnit(ast, v.Float(ast), from, to))
+	case DurationType:
+		return NewFloat(convertUnit(ast, float64(v.Duration(ast)), "ns", to))
+	}
+	Panicf(ast, "convert_unit: %v: value must be an int, float, or duration", v)
+	return Value{}
+}
+
+// convertTableUnit converts the values of the given columns of the table to
+// unit "to". Each column is converted from the unit listed in the table
+// attributes, or from unit "from" if the column has no unit. The resulting
+// table lists "to" as the unit of the columns.
+func convertTableUnit(ctx context.Context, ast ASTNode, src Table, to, from, cols string) Table {
+	if cols == "" {
+		Panicf(ast, "convert_unit: columns:= must be set when the arg is a table")
+	}
+	srcUnits := map[string]string{}
+	for _, col := range src.Attrs(ctx).Columns {
+		srcUnits[col.Name] = col.Unit
+	}
+	h := src.Hash().Merge(hash.String("convert_unit:" + to))
+	fromUnits := map[symbol.ID]string{}
+	var newCols []TSVColumn
+	for _, col := range strings.Split(cols, ",") {
+		if col = strings.TrimSpace(col); col == "" {
+			Panicf(ast, "convert_unit: columns '%s': empty column name", cols)
+		}
+		unit := srcUnits[col]
+		if unit == "" {
+			unit = from
+		}
+		if unit == "" {
+			Panicf(ast, "convert_unit: column '%s' has no unit; set one using with_attrs(units:=...) or from:=", col)
+		}
+		// Check the units before reading the rows.
+		convertUnit(ast, 0, unit, to)
+		fromUnits[symbol.Intern(col)] = unit
+		newCols = append(newCols, TSVColumn{Name: col, Unit: to})
+		h = h.Merge(hash.String(col + ":" + unit))
+	}
+	converted := &rowTransformTable{
+		src:  src,
+		hash: h,
+		fn: func(ctx context.Context, row Value) Value {
+			st := row.Struct(ast)
+			fields := make([]StructField, st.Len())
+			for fi := range fields {
+				f := st.Field(fi)
+				if unit, ok := fromUnits[f.Name]; ok {
+					f.Value = convertValueUnit(ast, f.Value, unit, to)
+				}
+				fields[fi] = f
+			}
+			return NewStruct(NewSimpleStruct(fields...))
+		},
+	}
+	return &withAttrsTable{
+		src:     converted,
+		hash:    h.Merge(hash.String("with_attrs")),
+		columns: newCols,
+	}
+}
+
+// unitMixingOps lists the operators whose two sides must be of the same unit.
+var unitMixingOps = map[string]bool{
+	"infix:+": true, "infix:-": true,
+	"infix:==": true, "infix:!=": true, "infix:==?": true, "infix:?==": true, "infix:?==?": true,
+	"infix:>": true, "infix:>=": true, "infix:>?": true, "infix:>=?": true,
+}
+
+// columnPair is an expression that adds, subtracts, or compares two columns.
+type columnPair struct {
+	expr *ASTFuncall
+	cols [2]symbol.ID
+}
+
+// findColumnPairs lists the expressions in the body of f that add, subtract, or
+// compare two columns of the row.
+func findColumnPairs(f *Func) []columnPair {
+	if f == nil || f.builtin || len(f.formalArgs) != 1 {
+		return nil
+	}
+	row := f.formalArgs[0].Name
+	var pairs []columnPair
+	body := f.body
+	visitRawASTTree(&body, func(nptr *ASTNode) bool {
+		fc, ok := (*nptr).(*ASTFuncall)
+		if !ok || len(fc.Raw) != 2 {
+			return true
+		}
+		lit, ok := fc.Function.(*ASTLiteral)
+		if !ok || lit.Literal.Type() != FuncType || !unitMixingOps[lit.Literal.Func(lit).name.Str()] {
+			return true
+		}
+		col0, ok0 := rowColumnRef(fc.Raw[0].Expr, row)
+		col1, ok1 := rowColumnRef(fc.Raw[1].Expr, row)
+		if ok0 && ok1 {
+			pairs = append(pairs, columnPair{expr: fc, cols: [2]symbol.ID{col0, col1}})
+		}
+		return true
+	})
+	return pairs
+}
+
+// findUnitMixes lists the pairs whose columns are of different units. Arg
+// units maps a column name to its unit. A column without a unit matches any
+// unit.
+func findUnitMixes(pairs []columnPair, units map[symbol.ID]string) []string {
+	var mixes []string
+	for _, p := range pairs {
+		if u0, u1 := units[p.cols[0]], units[p.cols[1]]; u0 != "" && u1 != "" && u0 != u1 {
+			mixes = append(mixes, fmt.Sprintf("%v mixes %s (%s) and %s (%s)", p.expr, p.cols[0].Str(), u0, p.cols[1].Str(), u1))
+		}
+	}
+	return mixes
+}
+
+// unitMixesReported lists the warnings already logged by checkUnitMixes.
+var unitMixesReported sync.Map
+
+// checkUnitMixes logs a warning, once per expression, if the filter or map
+// expressions mix columns of different units of the source table.
+func checkUnitMixes(ctx context.Context, ast ASTNode, src Table, filterExpr *Func, mapExprs []*Func) {
+	var pairs []columnPair
+	for _, f := range append([]*Func{filterExpr}, mapExprs...) {
+		pairs = append(pairs, findColumnPairs(f)...)
+	}
+	if len(pairs) == 0 {
+		// Avoid reading the attributes, since they may require opening the file.
+		return
+	}
+	units := map[symbol.ID]string{}
+	for _, col := range src.Attrs(ctx).Columns {
+		if col.Unit != "" {
+			units[symbol.Intern(col.Name)] = col.Unit
+		}
+	}
+	mixes := findUnitMixes(pairs, units)
+	sort.Strings(mixes)
+	for _, mix := range mixes {
+		msg := fmt.Sprintf("%v: warning: %s; use convert_unit to convert them to the same unit", ast.pos(), mix)
+		if _, loaded := unitMixesReported.LoadOrStore(msg, true); !loaded {
+			log.Print(msg)
+		}
+	}
+}
+
+func init() {
+	RegisterBuiltinFunc("convert_unit",
+		`
+    convert_unit(x, unit [, from:=fromunit])
+    tbl | convert_unit(unit, columns:=cols [, from:=fromunit])
+
+Arg types:
+
+- _x_: int, float, or duration
+- _unit_: string
+- _fromunit_: string
+- _cols_: string
+
+Convert_unit converts _x_ from unit _fromunit_ to unit _unit_, and returns a
+float. If _x_ is a duration, _fromunit_ is not needed, and the result is the
+length of the duration in _unit_. NA is returned as is.
+
+If the first arg is a table, convert_unit converts the values of the columns
+listed in _cols_, a comma-separated string, to _unit_. Each column is converted
+from the unit attached by with_attrs(units:=...) or by the "unit" column of the
+data dictionary, or from _fromunit_ if the column has no unit. The resulting
+table lists _unit_ as the unit of the columns.
+
+The following units are known. A unit can be converted only to another unit in
+the same group. The names are case sensitive, and "µ" may be used in place of
+"u".
+
+- time: ns, us, ms, s, min, h, d
+- sequence length: bp, kb, Mb, Gb
+- coverage: x
+- ratio: fraction, %
+- mass: pg, ng, ug, mg, g
+- volume: uL, mL, L
+- mass concentration: pg/mL, ng/mL, ug/mL, mg/mL, pg/uL, ng/uL, ug/uL, ng/L, ug/L, mg/L, g/L
+- molar concentration: pM, nM, uM, mM, M
+
+Map and filter log a warning when an expression adds, subtracts, or compares
+two columns of different units, e.g., "&a + &b" where _a_ is in "ng/mL" and _b_
+is in "pg/mL".
+
+Example:
+
+    convert_unit(2.5, "pg/mL", from:="ng/mL") == 2500.0
+    convert_unit(1500ms, "s") == 1.5
+    read("assay.tsv") | with_attrs(units:={conc: "ng/mL"}) | convert_unit("pg/mL", columns:="conc")
+`,
+		func(ctx context.Context, ast ASTNode, args []ActualArg) Value {
+			x, unit, from := args[0].Value, args[1].Str(), args[2].Str()
+			if x.Type() == TableType {
+				return NewTable(convertTableUnit(ctx, ast, x.Table(ast), unit, from, args[3].Str()))
+			}
+			if args[3].Str() != "" {
+				Panicf(ast, "convert_unit: columns:= is allowed only when the arg is a table")
+			}
+			if from == "" && x.Type() != DurationType && x.Type() != NullType {
+				Panicf(ast, "convert_unit: the unit of %v is unknown; set from:=", x)
+			}
+			return convertValueUnit(ast, x, from, unit)
+		},
+		func(ast ASTNode, args []AIArg) AIType {
+			switch {
+			case args[0].Type.Any:
+				return AIAnyType
+			case args[0].Type.Type == TableType:
+				return AITableType
+			}
+			return AIFloatType
+		},
+		FormalArg{Positional: true, Required: true, Types: []ValueType{IntType, FloatType, DurationType, NullType, TableType}}, // x
+		FormalArg{Positional: true, Required: true, Types: []ValueType{StringType}},                                            // unit
+		FormalArg{Name: symbol.From, DefaultValue: NewString(""), Types: []ValueType{StringType}},
+		FormalArg{Name: symbol.Columns, DefaultValue: NewString(""), Types: []ValueType{StringType}})
+}
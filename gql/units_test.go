@@ -0,0 +1,37 @@
+package gql
+
+import (
+	"testing"
+
+	"github.com/grailbio/gql/symbol"
+	"github.com/grailbio/testutil/expect"
+	"github.com/grailbio/testutil/h"
+)
+
+func TestConvertUnit(t *testing.T) {
+	expect.EQ(t, convertUnit(astUnknown, 2, "h", "min"), 120.0)
+	expect.EQ(t, convertUnit(astUnknown, 3, "kb", "bp"), 3000.0)
+	expect.EQ(t, convertUnit(astUnknown, 1, "ng/mL", "pg/µL"), 1.0)
+	expect.EQ(t, convertUnit(astUnknown, 5, "widgets", "widgets"), 5.0)
+	expect.That(t, func() { convertUnit(astUnknown, 1, "ng/mL", "nM") }, h.Panics(h.Regexp("cannot convert ng/mL")))
+	expect.That(t, func() { convertUnit(astUnknown, 1, "widgets", "s") }, h.Panics(h.Regexp("unknown unit 'widgets'")))
+}
+
+func TestUnitMixes(t *testing.T) {
+	sess := newSession()
+	units := map[symbol.ID]string{
+		symbol.Intern("a"): "ng/mL",
+		symbol.Intern("b"): "pg/mL",
+		symbol.Intern("c"): "ng/mL",
+	}
+	mixes := func(expr string) []string {
+		return findUnitMixes(findColumnPairs(doEval(t, expr, sess).Func(astUnknown)), units)
+	}
+	expect.That(t, mixes("|_| &a + &b"), h.ElementsAre(h.Regexp(`mixes a \(ng/mL\) and b \(pg/mL\)`)))
+	expect.That(t, mixes("|r| r.b > r.c"), h.ElementsAre(h.Regexp(`mixes b \(pg/mL\) and c \(ng/mL\)`)))
+	expect.EQ(t, len(mixes("|_| &a - &c")), 0)
+	// Multiplication and division may combine any units.
+	expect.EQ(t, len(mixes("|_| &a * &b")), 0)
+	// A column without a unit matches any unit.
+	expect.EQ(t, len(mixes("|_| &a + &d")), 0)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"reflect"
 	"time"
 	"unsafe"
@@ -362,7 +363,13 @@ func (v Value) Hash() hash.Hash {
 	case IntType:
 		return hash.Int(v.Int(nil))
 	case FloatType:
-		return hash.Float(v.Float(nil))
+		f := v.Float(nil)
+		if math.IsNaN(f) {
+			// NaNs compare equal, so they must hash the same regardless of the
+			// payload bits.
+			f = math.NaN()
+		}
+		return hash.Float(f)
 	case StringType, FileNameType, EnumType:
 		return hash.String(v.Str(nil))
 	case DateType, DateTimeType:
@@ -375,6 +382,8 @@ func (v Value) Hash() hash.Hash {
 		return hashStruct(v.Struct(nil))
 	case TableType:
 		return v.Table(nil).Hash()
+	case MatrixType:
+		return v.Matrix(nil).hash
 	case StructFragmentType:
 		h := hash.Hash{
 			0xf6, 0xe4, 0x86, 0xe5, 0x83, 0x77, 0x20, 0x40,
@@ -426,6 +435,8 @@ func (v Value) Marshal(ctx MarshalContext, enc *marshal.Encoder) {
 		v.Table(nil).Marshal(ctx, enc)
 	case FuncType:
 		v.Func(nil).Marshal(ctx, enc)
+	case MatrixType:
+		marshalMatrix(v.Matrix(nil), enc)
 	default:
 		log.Panicf("MarshalGOB: invalid type %v", v.typ)
 	}
@@ -509,8 +520,10 @@ func (v *Value) Unmarshal(ctx UnmarshalContext, dec *marshal.Decoder) {
 		*v = NewTable(unmarshalTable(ctx, dec))
 	case FuncType:
 		*v = NewFunc(unmarshalFunc(ctx, dec))
+	case MatrixType:
+		*v = NewMatrix(unmarshalMatrix(dec))
 	default:
-		// log.Panicf("UnmarshalBinary: invalid type %v", v.typ)
+		log.Panicf("Value.Unmarshal: unknown value type %d; the data may have been encoded by a different version of gql", typ)
 	}
 }
 
@@ -542,52 +555,21 @@ type PrintArgs struct {
 
 	// MaxInlinedTableLen is the threshold for printing a nested table inline.
 	// When a table's compact representation exceeds this length (bytes), it is
-	// printed as "tmpNN" or "[omitted]" depending on whether TmpVars!=nil.
+	// printed as "tmp_xxxxxx" or "[omitted]" depending on whether TmpVars!=nil.
 	//
 	// If MaxInlinedTableLen <= 0, it is set to 78.
 	MaxInlinedTableLen int
+
+	// MaxCellWidth is the max width, in terminal columns, of a cell of a table
+	// printed in PrintValues mode. A wider cell is truncated and ends with "…".
+	// If MaxCellWidth == 0, Opts.MaxCellWidth is used. If MaxCellWidth < 0,
+	// cells are not truncated.
+	MaxCellWidth int
 }
 
 // defaultMaxInlineTablePrintLen is the default value for PrintArgs.MaxInlinedTableLen.
 const defaultMaxInlineTablePrintLen = 78
 
-// TmpVars is used by Value.Print to give names to nested tables when values are
-// printed on screen.  TmpVars is a singleton object. Thread compatible.
-type TmpVars struct {
-	seq    int
-	hashes map[hash.Hash]string
-	vars   map[string]Value
-}
-
-// Register assigns a name of form "tmpNNN" to the given value. If New is invoked for
-// the same value multiple times, it returns the same name.
-func (a *TmpVars) Register(val Value) string {
-	if a.hashes == nil {
-		a.hashes = map[hash.Hash]string{}
-		a.vars = map[string]Value{}
-	}
-	h := val.Hash()
-	if varName, ok := a.hashes[h]; ok {
-		return varName
-	}
-	seq := a.seq
-	a.seq++
-	varName := fmt.Sprintf("tmp%d", seq)
-	a.hashes[h] = varName
-	a.vars[varName] = val
-	return varName
-}
-
-// Flush adds the registered tmp variables as the global variables in the given
-// session. The caller must ensure that "s" is not concurrently used by other
-// threads.
-func (a *TmpVars) Flush(s *Session) {
-	s.SetGlobals(a.vars)
-	for k := range a.vars {
-		delete(a.vars, k)
-	}
-}
-
 // PrintValueList prints a list of values in form "[val0, val1, ...]".  Arg depth
 // is used as PrintArgs.Depth.
 func PrintValueList(vals []Value) string {
@@ -702,18 +684,7 @@ func (v Value) printRec(ctx context.Context, args PrintArgs, depth int) {
 		}
 		switch args.Mode {
 		case PrintCompact:
-			args.Out.WriteString("{")
-			nFields := st.Len()
-			for fi := 0; fi < nFields; fi++ {
-				val := st.Field(fi)
-				args.Out.WriteString(val.Name.Str())
-				args.Out.WriteString(":")
-				val.Value.printRec(ctx, shortArgs, depth+1)
-				if fi < nFields-1 {
-					args.Out.WriteString(",")
-				}
-			}
-			args.Out.WriteString("}")
+			printStructCompact(ctx, shortArgs, st, depth)
 		case PrintValues, PrintDescription:
 			args.Out.WriteString("{\n")
 			nFields := st.Len()
@@ -748,7 +719,22 @@ func (v Value) printRec(ctx context.Context, args PrintArgs, depth int) {
 			args.Out.WriteString("**Path**: " + attrs.Path + "\n\n")
 			args.Out.WriteString(attrs.Description + "\n\n")
 			for _, col := range attrs.Columns {
-				args.Out.WriteString("**" + col.Name + "**: (" + col.Type.String() + ")\n\n")
+				typ := ""
+				if col.Type != InvalidType {
+					// The type is unknown if, e.g., the column was described by with_attrs.
+					typ = col.Type.String()
+				}
+				if col.Unit != "" {
+					if typ != "" {
+						typ += ", "
+					}
+					typ += "unit: " + col.Unit
+				}
+				if typ == "" {
+					args.Out.WriteString("**" + col.Name + "**\n\n")
+				} else {
+					args.Out.WriteString("**" + col.Name + "**: (" + typ + ")\n\n")
+				}
 				if col.Description != "" {
 					args.Out.WriteString("> " + col.Description + "\n\n")
 				}
@@ -774,11 +760,57 @@ func (v Value) printRec(ctx context.Context, args PrintArgs, depth int) {
 			}
 			args.Out.WriteString(fmt.Sprintf("udf:%v(env: %s)", f.body, f.env.Describe()))
 		}
+	case MatrixType:
+		m := v.Matrix(nil)
+		if args.Mode == PrintDescription {
+			args.Out.WriteString(fmt.Sprintf("matrix(%dx%d)", m.NRow(), m.NCol()))
+			return
+		}
+		printTable(ctx, args, m.Table(), depth)
 	default:
 		log.Panicf("Print: invalid type %v", v.typ)
 	}
 }
 
+// printStructCompact prints a struct in PrintCompact mode. Nested structs are
+// printed using an explicit stack instead of recursion. A struct nested more
+// than maxExprDepth levels is printed as "{...}".
+func printStructCompact(ctx context.Context, args PrintArgs, st Struct, depth int) {
+	type item struct {
+		str   string // printed as is if !isVal.
+		val   Value
+		isVal bool
+		depth int
+	}
+	stack := []item{{val: NewStruct(st), isVal: true, depth: depth}}
+	for len(stack) > 0 {
+		it := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		switch {
+		case !it.isVal:
+			args.Out.WriteString(it.str)
+		case it.val.Type() != StructType:
+			it.val.printRec(ctx, args, it.depth)
+		case it.depth-depth >= maxExprDepth:
+			args.Out.WriteString("{...}")
+		default:
+			args.Out.WriteString("{")
+			st := it.val.Struct(nil)
+			stack = append(stack, item{str: "}"})
+			for fi := st.Len() - 1; fi >= 0; fi-- {
+				f := st.Field(fi)
+				stack = append(stack,
+					item{val: f.Value, isVal: true, depth: it.depth + 1},
+					item{str: ":"},
+					item{str: f.Name.Str()})
+				if fi > 0 {
+					stack = append(stack, item{str: ","})
+				}
+			}
+		}
+	}
+}
+
 // Hash32 implements the bigslice.Hasher interface.
 func (v Value) Hash32() uint32 {
 	h := v.Hash()
@@ -797,13 +829,80 @@ func hashValues(values []Value) hash.Hash {
 	return h
 }
 
+// compareFloat compares two floats. It defines a total order in which NaN is
+// equal to itself and is larger than any other value, including +Inf.
+func compareFloat(v0, v1 float64) int {
+	switch {
+	case v0 < v1:
+		return -1
+	case v0 > v1:
+		return 1
+	case v0 == v1:
+		return 0
+	}
+	nan0, nan1 := math.IsNaN(v0), math.IsNaN(v1)
+	switch {
+	case nan0 && nan1:
+		return 0
+	case nan0:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// NAOrder specifies where sort(), minn(), min(), max(), and merge joins place
+// NA values relative to the other values.
+type NAOrder int
+
+const (
+	// NAOrderDefault treats NA as larger, and -NA as smaller, than any other
+	// value. Since -&col turns NA into -NA, NAs come last in ascending sorts but
+	// first in descending sorts.
+	NAOrderDefault NAOrder = iota
+	// NAOrderFirst places both NA and -NA before all the other values.
+	NAOrderFirst
+	// NAOrderLast places both NA and -NA after all the other values.
+	NAOrderLast
+)
+
+// ParseNAOrder parses "default", "first", or "last".
+func ParseNAOrder(s string) (NAOrder, error) {
+	switch s {
+	case "default", "":
+		return NAOrderDefault, nil
+	case "first":
+		return NAOrderFirst, nil
+	case "last":
+		return NAOrderLast, nil
+	}
+	return NAOrderDefault, fmt.Errorf("NA order '%s': must be one of default, first, or last", s)
+}
+
 // Compare two scalar values (int, float, string, char, bool, null). The caller
 // must ensure that the two values are of the same type, and they are scalar.
 // "ast" is for displaying error messages.
 func compareScalar(ast ASTNode, v0, v1 Value) int {
+	return compareScalarNA(ast, v0, v1, NAOrderDefault)
+}
+
+// compareScalarNA is similar to compareScalar, but it places NAs as specified
+// by order.
+func compareScalarNA(ast ASTNode, v0, v1 Value, order NAOrder) int {
 	null0, null1 := v0.Null(), v1.Null()
 
 	if null0 != NotNull || null1 != NotNull {
+		if order != NAOrderDefault {
+			// The sign of the NA doesn't matter.
+			switch {
+			case null0 != NotNull && null1 != NotNull:
+				return 0
+			case (null0 != NotNull) == (order == NAOrderFirst):
+				return -1
+			default:
+				return 1
+			}
+		}
 		if null0 == null1 {
 			return 0
 		}
@@ -836,16 +935,7 @@ func compareScalar(ast ASTNode, v0, v1 Value) int {
 			return 1
 		}
 	case FloatType:
-		vv0 := v0.Float(ast)
-		vv1 := v1.Float(ast)
-		switch {
-		case vv0 < vv1:
-			return -1
-		case vv0 == vv1:
-			return 0
-		default:
-			return 1
-		}
+		return compareFloat(v0.Float(ast), v1.Float(ast))
 	case StringType, EnumType, FileNameType:
 		vv0 := v0.Str(ast)
 		vv1 := v1.Str(ast)
@@ -896,20 +986,53 @@ func compareScalar(ast ASTNode, v0, v1 Value) int {
 	}
 }
 
+// maxTableCompareRows is the max number of rows in a table that Compare
+// compares by content. Tables with identical hashes are equal regardless of
+// their size.
+var maxTableCompareRows = 100000
+
 // Compare compares the two values lexicographically. It returns -1,0,1 if
 // v0<v1, v0==v1, v0>v1, respectively. It crashes if the two values are not of
 // the same type. "ast" is for displaying error messages.
+//
+// Structs are compared field by field. They must have the same set of field
+// names, in the same order. Tables are compared row by row, unless their
+// hashes are identical.
 func Compare(ast ASTNode, v0, v1 Value) int {
-	if v0.Type() != StructType {
-		return compareScalar(ast, v0, v1)
+	return CompareNA(ast, v0, v1, NAOrderDefault)
+}
+
+// CompareNA is similar to Compare, but it places NAs, including those in struct
+// fields, as specified by order.
+func CompareNA(ast ASTNode, v0, v1 Value, order NAOrder) int {
+	return compareNA(BackgroundContext, ast, v0, v1, order)
+}
+
+// compareNA is similar to CompareNA, but it scans tables under ctx.
+func compareNA(ctx context.Context, ast ASTNode, v0, v1 Value, order NAOrder) int {
+	switch {
+	case v0.Type() == StructType && v1.Type() == StructType:
+		return compareStruct(ctx, ast, v0.Struct(ast), v1.Struct(ast), order)
+	case v0.Type() == TableType && v1.Type() == TableType:
+		return compareTable(ctx, ast, v0.Table(ast), v1.Table(ast), order)
+	case v0.Type() == MatrixType && v1.Type() == MatrixType:
+		return compareMatrix(v0.Matrix(ast), v1.Matrix(ast))
 	}
-	s0, s1 := v0.Struct(ast), v1.Struct(ast)
+	return compareScalarNA(ast, v0, v1, order)
+}
+
+func compareStruct(ctx context.Context, ast ASTNode, s0, s1 Struct, order NAOrder) int {
 	s0Len, s1Len := s0.Len(), s1.Len()
 	if s0Len != s1Len {
-		log.Panicf("struct signature mismatch: %v %v", v0, v1)
+		log.Panicf("struct signature mismatch: %v %v", NewStruct(s0), NewStruct(s1))
 	}
 	for ci := 0; ci < s0Len; ci++ {
-		cmp := Compare(ast, s0.Field(ci).Value, s1.Field(ci).Value)
+		f0, f1 := s0.Field(ci), s1.Field(ci)
+		if f0.Name != f1.Name {
+			log.Panicf("struct signature mismatch: field #%d is '%s' and '%s': %v %v",
+				ci, f0.Name.Str(), f1.Name.Str(), NewStruct(s0), NewStruct(s1))
+		}
+		cmp := compareNA(ctx, ast, f0.Value, f1.Value, order)
 		if cmp < 0 {
 			return -1
 		}
@@ -919,3 +1042,30 @@ func Compare(ast ASTNode, v0, v1 Value) int {
 	}
 	return 0
 }
+
+func compareTable(ctx context.Context, ast ASTNode, t0, t1 Table, order NAOrder) int {
+	if t0.Hash() == t1.Hash() {
+		return 0
+	}
+	for _, t := range []Table{t0, t1} {
+		if n := t.Len(ctx, Approx); n > maxTableCompareRows {
+			log.Panicf("compare: table %v has too many rows (%d > %d) to compare by content",
+				t.Attrs(ctx).Name, n, maxTableCompareRows)
+		}
+	}
+	sc0, sc1 := t0.Scanner(ctx, 0, 1, 1), t1.Scanner(ctx, 0, 1, 1)
+	for {
+		ok0, ok1 := sc0.Scan(), sc1.Scan()
+		switch {
+		case !ok0 && !ok1:
+			return 0
+		case !ok0:
+			return -1
+		case !ok1:
+			return 1
+		}
+		if cmp := compareNA(ctx, ast, sc0.Value(), sc1.Value(), order); cmp != 0 {
+			return cmp
+		}
+	}
+}
@@ -43,6 +43,8 @@ const (
 	TableType
 	// FuncType stores a Func
 	FuncType
+	// MatrixType stores a *Matrix
+	MatrixType
 )
 
 // LikeString checks if the value's representation is a string.
@@ -14,7 +14,7 @@ func (v ValueType) MarshalJSON() ([]byte, error) {
 // UnmarshalJSON implements json.Unmarshaler.
 func (v *ValueType) UnmarshalJSON(data []byte) error {
 	s := string(data)
-	for i := InvalidType; i <= FuncType; i++ {
+	for i := InvalidType; i <= MatrixType; i++ {
 		if s == i.String() {
 			*v = i
 			return nil
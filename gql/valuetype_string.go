@@ -4,9 +4,9 @@ package gql
 
 import "strconv"
 
-const _ValueType_name = "InvalidTypeNullTypeBoolTypeIntTypeFloatTypeStringTypeFileNameTypeEnumTypeCharTypeDateTypeDateTimeTypeDurationTypeUnusedTypeStructTypeStructFragmentTypeTableTypeFuncType"
+const _ValueType_name = "InvalidTypeNullTypeBoolTypeIntTypeFloatTypeStringTypeFileNameTypeEnumTypeCharTypeDateTypeDateTimeTypeDurationTypeUnusedTypeStructTypeStructFragmentTypeTableTypeFuncTypeMatrixType"
 
-var _ValueType_index = [...]uint8{0, 11, 19, 27, 34, 43, 53, 65, 73, 81, 89, 101, 113, 123, 133, 151, 160, 168}
+var _ValueType_index = [...]uint8{0, 11, 19, 27, 34, 43, 53, 65, 73, 81, 89, 101, 113, 123, 133, 151, 160, 168, 178}
 
 func (i ValueType) String() string {
 	if i >= ValueType(len(_ValueType_index)-1) {
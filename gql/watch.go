@@ -0,0 +1,209 @@
+package gql
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/hash"
+)
+
+// Support for "grail-query -watch". The files read by a script are recorded
+// while the script runs. After the script finishes, WaitForInputChange blocks
+// until one of them changes.
+
+// watchSettleTime is the time a changed file must stay unchanged before
+// WaitForInputChange returns. It avoids rerunning the script while a file is
+// still being written.
+var watchSettleTime = 2 * time.Second
+
+// fileVersion identifies a version of a file.
+type fileVersion struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// InputFiles is a set of files read by a script, along with their versions at
+// the time they were read.
+type InputFiles map[string]fileVersion
+
+var (
+	inputFilesMu sync.Mutex
+	// inputFiles is non-nil while RecordInputFiles is active.
+	inputFiles InputFiles
+)
+
+func statFileVersion(ctx context.Context, path string) fileVersion {
+	info, err := file.Stat(ctx, path)
+	if err != nil {
+		return fileVersion{}
+	}
+	return fileVersion{exists: true, size: info.Size(), modTime: info.ModTime()}
+}
+
+// RecordInputFiles starts recording the files read by the read() builtin and
+// the scripts loaded by EvalFile and the "load" statement. It returns a function
+// that stops the recording and returns the set of files read.
+//
+// REQUIRES: the previous recording, if any, has been stopped.
+func RecordInputFiles() (stop func() InputFiles) {
+	inputFilesMu.Lock()
+	if inputFiles != nil {
+		log.Panicf("RecordInputFiles: recording is already active")
+	}
+	inputFiles = InputFiles{}
+	inputFilesMu.Unlock()
+	return func() InputFiles {
+		inputFilesMu.Lock()
+		files := inputFiles
+		inputFiles = nil
+		inputFilesMu.Unlock()
+		return files
+	}
+}
+
+// recordInputFile adds the path to the set of files read by the script, if the
+// recording is active.
+func recordInputFile(ctx context.Context, path string) {
+	inputFilesMu.Lock()
+	if inputFiles == nil {
+		inputFilesMu.Unlock()
+		return
+	}
+	_, ok := inputFiles[path]
+	inputFilesMu.Unlock()
+	if ok {
+		return
+	}
+	// Stat the file before it is read, so that a write that races with the read
+	// is detected.
+	v := statFileVersion(ctx, path)
+	inputFilesMu.Lock()
+	if inputFiles != nil {
+		inputFiles[path] = v
+	}
+	inputFilesMu.Unlock()
+}
+
+// Paths returns the sorted list of files.
+func (f InputFiles) Paths() []string {
+	paths := make([]string, 0, len(f))
+	for path := range f {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// StatInputFiles returns the current versions of the given files.
+func StatInputFiles(ctx context.Context, paths []string) InputFiles {
+	f := InputFiles{}
+	for _, path := range paths {
+		f[path] = statFileVersion(ctx, path)
+	}
+	return f
+}
+
+// Hash computes a hash of the pathnames and the versions of the files.
+func (f InputFiles) Hash() hash.Hash {
+	h := hash.String("inputfiles")
+	for _, path := range f.Paths() {
+		v := f[path]
+		h = h.Merge(hash.String(path)).Merge(hash.Bool(v.exists)).
+			Merge(hash.Int(v.size)).Merge(hash.Time(v.modTime))
+	}
+	return h
+}
+
+// changed returns a file whose version differs from the recorded one.
+func (f InputFiles) changed(ctx context.Context) (string, bool) {
+	for _, path := range f.Paths() {
+		if statFileVersion(ctx, path) != f[path] {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// snapshot returns the current versions of the files.
+func (f InputFiles) snapshot(ctx context.Context) InputFiles {
+	s := InputFiles{}
+	for path := range f {
+		s[path] = statFileVersion(ctx, path)
+	}
+	return s
+}
+
+// WaitForInputChange blocks until one of the files changes, and the files stay
+// unchanged for a few seconds thereafter. It returns the pathname of the file
+// changed. Local files are watched using inotify. Other files, e.g., those on
+// S3, are polled every pollInterval.
+func WaitForInputChange(ctx context.Context, files InputFiles, pollInterval time.Duration) (string, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return "", err
+	}
+	defer watcher.Close() // nolint: errcheck
+
+	// Watch the parent directories, since files are often replaced by renaming.
+	localPaths := map[string]bool{}
+	watchedDirs := map[string]bool{}
+	for path := range files {
+		if !isLocalPath(path) {
+			continue
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		localPaths[abs] = true
+		dir := filepath.Dir(abs)
+		if watchedDirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			log.Error.Printf("watch %s: %v; falling back to polling", dir, err)
+		}
+		watchedDirs[dir] = true
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if path, ok := files.changed(ctx); ok {
+			waitForInputSettle(ctx, files)
+			return path, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case ev := <-watcher.Events:
+			if localPaths[ev.Name] {
+				log.Debug.Printf("watch: %v", ev)
+			}
+		case err := <-watcher.Errors:
+			log.Error.Printf("watch: %v", err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitForInputSettle waits until the files stop changing.
+func waitForInputSettle(ctx context.Context, files InputFiles) {
+	prev := files.snapshot(ctx)
+	for {
+		if err := sleepCtx(ctx, watchSettleTime); err != nil {
+			return
+		}
+		if _, ok := prev.changed(ctx); !ok {
+			return
+		}
+		prev = prev.snapshot(ctx)
+	}
+}
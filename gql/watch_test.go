@@ -0,0 +1,38 @@
+package gql
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grailbio/testutil"
+	"github.com/grailbio/testutil/expect"
+)
+
+func TestWatchInputFiles(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	ctx := context.Background()
+	sess := TestNewSession()
+	defer func(d time.Duration) { watchSettleTime = d }(watchSettleTime)
+	watchSettleTime = 10 * time.Millisecond
+
+	path := filepath.Join(tmpDir, "src.tsv")
+	expect.NoError(t, ioutil.WriteFile(path, []byte("a\n1\n"), 0600))
+	stop := RecordInputFiles()
+	statements, err := sess.Parse("(input)", []byte("read(`"+path+"`)"))
+	expect.NoError(t, err)
+	sess.EvalStatements(ctx, statements)
+	inputs := stop()
+	expect.EQ(t, inputs.Paths(), []string{path})
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		expect.NoError(t, ioutil.WriteFile(path, []byte("a\n1\n2\n"), 0600))
+	}()
+	changed, err := WaitForInputChange(ctx, inputs, time.Hour)
+	expect.NoError(t, err)
+	expect.EQ(t, changed, path)
+}
@@ -0,0 +1,57 @@
+package gql_test
+
+import (
+	"testing"
+
+	"github.com/grailbio/gql/gqltest"
+	"github.com/grailbio/testutil/expect"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWideLong(t *testing.T) {
+	env := gqltest.NewSession()
+	gqltest.Eval(t, `t0 := table(
+		{bin:"b1", s1:30, s2:NA, s3:32},
+		{bin:"b2", s1:40, s2:41, s3:NA})`, env)
+
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `t1 := t0 | to_long("bin")`, env)),
+		[]string{
+			"{bin:b1,name:s1,value:30}",
+			"{bin:b1,name:s2,value:NA}",
+			"{bin:b1,name:s3,value:32}",
+			"{bin:b2,name:s1,value:40}",
+			"{bin:b2,name:s2,value:41}",
+			"{bin:b2,name:s3,value:NA}",
+		})
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `t2 := t0 | to_long("bin", name_name:="sample", value_name:="reads", sparse:=true)`, env)),
+		[]string{
+			"{bin:b1,sample:s1,reads:30}",
+			"{bin:b1,sample:s3,reads:32}",
+			"{bin:b2,sample:s1,reads:40}",
+			"{bin:b2,sample:s2,reads:41}",
+		})
+
+	// The dense wide table has the same columns in every row.
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `t2 | to_wide("bin", name_name:="sample", value_name:="reads")`, env)),
+		[]string{
+			"{bin:b1,s1:30,s3:32,s2:NA}",
+			"{bin:b2,s1:40,s3:NA,s2:41}",
+		})
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `t2 | to_wide("bin", name_name:="sample", value_name:="reads", sparse:=true)`, env)),
+		[]string{
+			"{bin:b1,s1:30,s3:32}",
+			"{bin:b2,s1:40,s2:41}",
+		})
+	expect.EQ(t,
+		gqltest.ReadTable(gqltest.Eval(t, `t1 | to_wide("bin")`, env)),
+		gqltest.ReadTable(gqltest.Eval(t, `t0`, env)))
+
+	// Rows with the same ID must be adjacent.
+	assert.Panics(t, func() {
+		gqltest.ReadTable(gqltest.Eval(t, `t1 | sort($name) | to_wide("bin")`, env))
+	})
+}
@@ -0,0 +1,108 @@
+package gql
+
+// This file implements write(..., if_changed:=true). After writing a file, write
+// records a hash of the table and the write options in a file next to it, e.g.,
+// "foo.tsv.gqlhash" for "foo.tsv", along with a hash of the file's size and
+// modtime. The next write with if_changed:=true skips the file if both hashes
+// are unchanged, so the file keeps its timestamp. A write without if_changed:=
+// removes the hash file.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/grailbio/base/errors"
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/gql/hash"
+)
+
+// writeHashSuffix is appended to the path of a file to name the file that
+// records its write hash.
+const writeHashSuffix = ".gqlhash"
+
+// writeHashPath computes the path of the file that records the write hash of
+// the given file.
+func writeHashPath(path string) string {
+	return strings.TrimSuffix(path, "/") + writeHashSuffix
+}
+
+// computeWriteHash computes the hash of the contents of a file written by
+// write(). It covers the table as well as the options that affect the file
+// contents. Arg opts describes the options.
+func computeWriteHash(table Table, fh FileHandler, opts string) hash.Hash {
+	return table.Hash().Merge(hash.String("write:" + fh.Name() + ":" + opts))
+}
+
+// describeWriteOpts produces a string that lists the options of write() that
+// affect the file contents. Arg metadata is the metadata:= arg. The metadata
+// lines themselves contain the creation time, so they are not used.
+func describeWriteOpts(nShard int, tsvOpts tsvWriterOpts, btsvOpts btsvWriterOpts, metadata Value) string {
+	indexCols := make([]string, len(btsvOpts.indexCols))
+	for i, col := range btsvOpts.indexCols {
+		indexCols[i] = col.Str()
+	}
+	return fmt.Sprintf("shards=%d,index=%q,dict_encode=%v,column_order=%q,float_format=%q,trim_float_zero=%v,escape=%v,delimiter=%q,nested_tables=%v,bgzip=%v,metadata=%v",
+		nShard, indexCols, btsvOpts.dictEncode, tsvOpts.colOrder, tsvOpts.floatFormat, tsvOpts.trimFloatZero,
+		tsvOpts.escape, tsvOpts.delimiter, tsvOpts.nestedTables, tsvOpts.bgzip, metadata.Hash())
+}
+
+// writtenFileExists checks if the file produced by the handler exists. A btsv
+// file is a directory of shards, so it exists iff it has a shard.
+func writtenFileExists(ctx context.Context, ast ASTNode, path string, fh FileHandler) bool {
+	if fh == singletonBTSVFileHandler {
+		return len(listBTSVShardPaths(ctx, path, ast)) > 0
+	}
+	_, err := file.Stat(ctx, path)
+	return err == nil
+}
+
+// readWriteHash reads the write hash and the file hash recorded for the file.
+// It returns "" if none is recorded.
+func readWriteHash(ctx context.Context, ast ASTNode, path string) (writeHash, fileHash string) {
+	data, err := file.ReadFile(ctx, writeHashPath(path))
+	if err != nil {
+		if !errors.Is(errors.NotExist, err) && !os.IsNotExist(err) {
+			Errorf(ast, "write %s: read %s: %v", path, writeHashPath(path), err)
+		}
+		return "", ""
+	}
+	lines := strings.Fields(string(data))
+	if len(lines) != 2 {
+		return "", ""
+	}
+	return lines[0], lines[1]
+}
+
+// unchangedSinceLastWrite checks if the file exists, it was written from a
+// table with the given write hash, and it hasn't been modified since.
+func unchangedSinceLastWrite(ctx context.Context, ast ASTNode, path string, fh FileHandler, h hash.Hash) bool {
+	writeHash, fileHash := readWriteHash(ctx, ast, path)
+	if writeHash != h.String() {
+		return false
+	}
+	curFileHash, ok := writtenFileHash(ctx, ast, path, fh)
+	return ok && curFileHash.String() == fileHash
+}
+
+// recordWriteHash records the write hash of the file, and the hash of the file
+// itself.
+func recordWriteHash(ctx context.Context, ast ASTNode, path string, fh FileHandler, h hash.Hash) {
+	fileHash, ok := writtenFileHash(ctx, ast, path, fh)
+	if !ok {
+		Errorf(ast, "write %s: file not found after writing it", path)
+		return
+	}
+	if err := file.WriteFile(ctx, writeHashPath(path), []byte(fmt.Sprintf("%s\n%s\n", h.String(), fileHash.String()))); err != nil {
+		Errorf(ast, "write %s: record the hash in %s: %v", path, writeHashPath(path), err)
+	}
+}
+
+// removeWriteHash removes the write hash recorded for the file, if any. It is
+// called when the file is written without if_changed:=true.
+func removeWriteHash(ctx context.Context, ast ASTNode, path string) {
+	if err := file.Remove(ctx, writeHashPath(path)); err != nil && !errors.Is(errors.NotExist, err) && !os.IsNotExist(err) {
+		Errorf(ast, "write %s: remove %s: %v", path, writeHashPath(path), err)
+	}
+}
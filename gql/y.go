@@ -32,6 +32,7 @@ type yySymType struct {
 	structField    ASTStructLiteralField
 	structFields   []ASTStructLiteralField
 	paramVals      []ASTParamVal
+	legacyBody     legacyFunctionBody
 }
 
 const tokIdent = 57346
@@ -54,13 +55,19 @@ const tokEQOrBothNull = 57362
 const tokNE = 57363
 const tokLEQ = 57364
 const tokGEQ = 57365
-const tokFunc = 57366
-const tokLoad = 57367
-const tokCond = 57368
-const tokIf = 57369
-const tokElse = 57370
-const unary = 57371
-const deref = 57372
+const tokGTNotNull = 57366
+const tokGEQNotNull = 57367
+const tokLTNotNull = 57368
+const tokLEQNotNull = 57369
+const tokFunc = 57370
+const tokLoad = 57371
+const tokMatview = 57372
+const tokConst = 57373
+const tokCond = 57374
+const tokIf = 57375
+const tokElse = 57376
+const unary = 57377
+const deref = 57378
 
 var yyToknames = [...]string{
 	"$end",
@@ -88,12 +95,21 @@ var yyToknames = [...]string{
 	"tokGEQ",
 	"'>'",
 	"'<'",
+	"tokGTNotNull",
+	"tokGEQNotNull",
+	"tokLTNotNull",
+	"tokLEQNotNull",
 	"'|'",
 	"'{'",
+	"'}'",
+	"'('",
+	"')'",
 	"'$'",
 	"'&'",
 	"tokFunc",
 	"tokLoad",
+	"tokMatview",
+	"tokConst",
 	"tokCond",
 	"tokIf",
 	"tokElse",
@@ -107,15 +123,13 @@ var yyToknames = [...]string{
 	"'.'",
 	"'['",
 	"']'",
-	"'('",
-	"')'",
-	"'}'",
 	"deref",
 	"';'",
 	"'!'",
 	"','",
 	"':'",
 }
+
 var yyStatenames = [...]string{}
 
 const yyEofCode = 1
@@ -126,208 +140,225 @@ var yyExca = [...]int{
 	-1, 1,
 	1, -1,
 	-2, 0,
-	-1, 131,
-	51, 77,
-	-2, 57,
-	-1, 132,
-	34, 41,
-	38, 41,
-	39, 41,
-	40, 41,
-	-2, 28,
+	-1, 149,
+	57, 84,
+	-2, 64,
+	-1, 150,
+	43, 48,
+	47, 48,
+	48, 48,
+	49, 48,
+	-2, 31,
 }
 
 const yyPrivate = 57344
 
-const yyLast = 745
+const yyLast = 828
 
 var yyAct = [...]int{
-
-	9, 84, 117, 30, 70, 7, 17, 32, 69, 125,
-	159, 141, 106, 57, 60, 107, 107, 64, 122, 124,
-	56, 114, 61, 107, 33, 115, 113, 31, 164, 74,
-	76, 158, 55, 123, 71, 37, 138, 107, 85, 87,
-	88, 89, 90, 91, 92, 93, 94, 95, 96, 97,
-	98, 99, 100, 101, 102, 103, 117, 105, 116, 81,
-	35, 36, 80, 63, 108, 112, 5, 39, 40, 144,
-	46, 47, 48, 49, 50, 54, 52, 51, 53, 38,
-	156, 120, 126, 43, 44, 45, 56, 55, 41, 42,
-	37, 43, 44, 45, 34, 55, 3, 62, 37, 36,
-	79, 36, 147, 121, 157, 1, 128, 127, 104, 140,
-	130, 87, 132, 104, 134, 4, 74, 66, 139, 71,
-	136, 65, 135, 83, 82, 146, 145, 148, 77, 143,
-	149, 68, 11, 67, 150, 142, 41, 42, 151, 43,
-	44, 45, 154, 55, 2, 155, 37, 78, 0, 0,
-	71, 0, 0, 0, 0, 0, 0, 161, 162, 160,
-	163, 153, 59, 0, 18, 21, 24, 19, 25, 22,
-	23, 20, 39, 40, 0, 46, 47, 48, 49, 50,
-	54, 52, 51, 53, 110, 28, 26, 27, 58, 0,
-	15, 16, 0, 41, 111, 0, 43, 44, 45, 0,
-	55, 0, 0, 109, 0, 0, 39, 40, 13, 46,
-	47, 48, 49, 50, 54, 52, 51, 53, 38, 0,
-	0, 0, 0, 0, 0, 0, 0, 41, 42, 0,
-	43, 44, 45, 0, 55, 0, 0, 37, 0, 0,
-	0, 0, 0, 129, 137, 75, 18, 21, 24, 19,
-	25, 22, 23, 20, 0, 10, 0, 18, 21, 24,
-	19, 25, 22, 23, 20, 0, 14, 28, 26, 27,
-	58, 0, 15, 16, 0, 0, 12, 14, 28, 26,
-	27, 8, 6, 15, 16, 29, 0, 12, 0, 0,
-	13, 0, 0, 0, 0, 0, 29, 0, 0, 0,
-	0, 13, 73, 75, 18, 21, 24, 19, 25, 22,
-	23, 20, 0, 59, 0, 18, 21, 24, 19, 25,
-	22, 23, 20, 0, 14, 28, 26, 27, 72, 0,
-	15, 16, 0, 0, 12, 14, 28, 26, 27, 58,
-	0, 15, 16, 29, 0, 12, 0, 0, 13, 0,
-	0, 0, 0, 0, 29, 0, 0, 39, 40, 13,
-	46, 47, 48, 49, 50, 54, 52, 51, 53, 38,
-	0, 0, 0, 0, 0, 0, 0, 0, 41, 42,
-	0, 43, 44, 45, 0, 55, 0, 0, 37, 0,
-	0, 10, 152, 18, 21, 24, 19, 25, 22, 23,
-	20, 0, 86, 0, 18, 21, 24, 19, 25, 22,
-	23, 20, 0, 14, 28, 26, 27, 72, 0, 15,
-	16, 0, 0, 12, 14, 28, 26, 27, 58, 0,
-	15, 16, 29, 0, 12, 0, 0, 13, 0, 0,
-	0, 0, 0, 29, 0, 0, 0, 131, 13, 18,
-	21, 24, 19, 25, 22, 23, 20, 0, 10, 0,
-	18, 21, 24, 19, 25, 22, 23, 20, 0, 14,
-	28, 26, 27, 58, 0, 15, 16, 0, 0, 12,
-	14, 28, 26, 27, 8, 0, 15, 16, 29, 0,
-	12, 0, 0, 13, 0, 0, 0, 0, 0, 29,
-	0, 0, 39, 40, 13, 46, 47, 48, 49, 50,
-	54, 52, 51, 53, 38, 0, 0, 0, 0, 0,
-	0, 0, 0, 41, 42, 0, 43, 44, 45, 0,
-	55, 0, 0, 37, 165, 39, 40, 0, 46, 47,
-	48, 49, 50, 54, 52, 51, 53, 38, 0, 0,
-	0, 0, 0, 0, 0, 0, 41, 42, 0, 43,
-	44, 45, 0, 55, 0, 0, 37, 119, 39, 40,
-	0, 46, 47, 48, 49, 50, 54, 52, 51, 53,
-	38, 0, 0, 0, 0, 0, 0, 0, 133, 41,
-	42, 0, 43, 44, 45, 0, 55, 39, 40, 37,
-	46, 47, 48, 49, 50, 54, 52, 51, 53, 38,
-	0, 0, 0, 0, 0, 0, 0, 0, 41, 42,
-	0, 43, 44, 45, 0, 55, 39, 40, 37, 46,
-	47, 48, 49, 50, 54, 52, 51, 53, 38, 0,
-	0, 0, 0, 0, 0, 0, 0, 41, 42, 0,
-	43, 44, 45, 0, 118, 0, 40, 37, 46, 47,
-	48, 49, 50, 54, 52, 51, 53, 38, 0, 0,
-	0, 0, 0, 0, 0, 0, 41, 42, 0, 43,
-	44, 45, 0, 55, 0, 0, 37, 46, 47, 48,
-	49, 50, 54, 52, 51, 53, 38, 0, 0, 0,
-	0, 0, 0, 0, 0, 41, 42, 0, 43, 44,
-	45, 0, 55, 0, 0, 37, 46, 47, 48, 49,
-	50, 54, 52, 51, 53, 0, 0, 0, 0, 0,
-	0, 0, 0, 0, 41, 42, 0, 43, 44, 45,
-	0, 55, 0, 0, 37,
+	10, 94, 19, 32, 7, 78, 77, 34, 133, 142,
+	179, 160, 129, 138, 141, 65, 68, 35, 128, 72,
+	33, 140, 40, 63, 69, 156, 38, 89, 71, 184,
+	178, 83, 85, 122, 122, 79, 122, 130, 5, 121,
+	62, 95, 97, 98, 99, 100, 101, 102, 103, 104,
+	105, 106, 107, 108, 109, 110, 111, 112, 113, 114,
+	115, 116, 117, 90, 119, 133, 122, 131, 37, 3,
+	163, 176, 123, 127, 88, 157, 143, 120, 155, 84,
+	20, 23, 26, 21, 27, 24, 25, 22, 91, 4,
+	136, 63, 139, 36, 118, 159, 38, 38, 70, 166,
+	146, 132, 118, 86, 16, 30, 74, 31, 73, 28,
+	29, 66, 64, 39, 137, 17, 18, 1, 93, 14,
+	92, 144, 145, 87, 76, 148, 97, 150, 13, 152,
+	15, 83, 75, 79, 158, 153, 154, 161, 2, 40,
+	0, 162, 165, 164, 167, 0, 0, 0, 168, 0,
+	44, 45, 169, 46, 47, 48, 170, 62, 173, 0,
+	0, 174, 0, 0, 175, 0, 0, 0, 79, 0,
+	0, 0, 0, 0, 0, 0, 0, 181, 182, 180,
+	183, 172, 67, 0, 20, 23, 26, 21, 27, 24,
+	25, 22, 42, 43, 0, 49, 50, 51, 52, 53,
+	57, 55, 54, 56, 58, 59, 60, 61, 125, 30,
+	0, 124, 0, 28, 29, 66, 0, 0, 0, 17,
+	18, 0, 44, 126, 0, 46, 47, 48, 0, 62,
+	0, 0, 42, 43, 15, 49, 50, 51, 52, 53,
+	57, 55, 54, 56, 58, 59, 60, 61, 41, 0,
+	0, 40, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 44, 45, 0, 46, 47, 48, 0, 62,
+	0, 0, 0, 42, 43, 177, 49, 50, 51, 52,
+	53, 57, 55, 54, 56, 58, 59, 60, 61, 41,
+	0, 0, 40, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 44, 45, 0, 46, 47, 48, 0,
+	62, 0, 0, 0, 42, 43, 147, 49, 50, 51,
+	52, 53, 57, 55, 54, 56, 58, 59, 60, 61,
+	41, 0, 11, 40, 20, 23, 26, 21, 27, 24,
+	25, 22, 0, 0, 44, 45, 0, 46, 47, 48,
+	0, 62, 0, 0, 0, 171, 0, 0, 16, 30,
+	0, 31, 0, 28, 29, 8, 6, 9, 12, 17,
+	18, 0, 0, 14, 82, 84, 20, 23, 26, 21,
+	27, 24, 25, 22, 15, 0, 0, 11, 0, 20,
+	23, 26, 21, 27, 24, 25, 22, 0, 0, 0,
+	16, 30, 0, 31, 0, 28, 29, 80, 0, 81,
+	12, 17, 18, 16, 30, 14, 31, 0, 28, 29,
+	80, 0, 81, 12, 17, 18, 15, 11, 14, 20,
+	23, 26, 21, 27, 24, 25, 22, 0, 0, 15,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 16, 30, 40, 31, 0, 28, 29,
+	8, 0, 9, 12, 17, 18, 0, 0, 14, 46,
+	47, 48, 0, 62, 0, 0, 0, 42, 43, 15,
+	49, 50, 51, 52, 53, 57, 55, 54, 56, 58,
+	59, 60, 61, 41, 0, 0, 40, 185, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 44, 45, 0,
+	46, 47, 48, 67, 62, 20, 23, 26, 21, 27,
+	24, 25, 22, 43, 0, 49, 50, 51, 52, 53,
+	57, 55, 54, 56, 58, 59, 60, 61, 41, 16,
+	30, 40, 31, 0, 28, 29, 66, 0, 0, 0,
+	17, 18, 44, 45, 14, 46, 47, 48, 0, 62,
+	0, 0, 0, 42, 43, 15, 49, 50, 51, 52,
+	53, 57, 55, 54, 56, 58, 59, 60, 61, 41,
+	0, 0, 40, 135, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 44, 45, 0, 46, 47, 48, 96,
+	62, 20, 23, 26, 21, 27, 24, 25, 22, 0,
+	0, 49, 50, 51, 52, 53, 57, 55, 54, 56,
+	58, 59, 60, 61, 41, 16, 30, 40, 31, 0,
+	28, 29, 66, 0, 0, 0, 17, 18, 44, 45,
+	14, 46, 47, 48, 0, 62, 0, 0, 0, 42,
+	43, 15, 49, 50, 51, 52, 53, 57, 55, 54,
+	56, 58, 59, 60, 61, 41, 0, 149, 40, 20,
+	23, 26, 21, 27, 24, 25, 22, 0, 151, 44,
+	45, 0, 46, 47, 48, 0, 62, 0, 0, 0,
+	0, 0, 0, 16, 30, 0, 31, 0, 28, 29,
+	66, 0, 0, 0, 17, 18, 0, 0, 14, 0,
+	0, 0, 0, 0, 0, 0, 0, 42, 43, 15,
+	49, 50, 51, 52, 53, 57, 55, 54, 56, 58,
+	59, 60, 61, 41, 0, 0, 40, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 44, 45, 0,
+	46, 47, 48, 0, 62, 42, 43, 0, 49, 50,
+	51, 52, 53, 57, 55, 54, 56, 58, 59, 60,
+	61, 41, 0, 0, 40, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 44, 45, 0, 46, 47,
+	48, 0, 134, 49, 50, 51, 52, 53, 57, 55,
+	54, 56, 58, 59, 60, 61, 0, 0, 0, 40,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	44, 45, 0, 46, 47, 48, 0, 62,
 }
-var yyPact = [...]int{
 
-	251, -1000, -22, -25, -1000, -1000, 87, -1000, 56, 583,
-	70, -1000, 309, 309, 93, 18, 309, -1000, -1000, -1000,
-	-1000, -1000, -1000, -1000, -1000, -1000, 117, 113, 298, 309,
-	-1000, 251, -1000, 454, -1000, 17, 93, 398, 309, 309,
-	309, 309, 309, 309, 309, 309, 309, 309, 309, 309,
-	309, 309, 309, 309, 309, 109, 309, -10, 16, -1000,
-	-10, -14, -1000, 309, 158, -1000, -1000, -23, -26, -1000,
-	-1000, -1000, 54, 4, 612, -1000, 521, -25, -1000, -1000,
-	93, -28, -13, -32, -42, 583, 66, 699, 641, 670,
-	45, 45, -10, -10, -10, 101, 101, 101, 101, 101,
-	101, 101, 101, 101, -1000, 583, 309, 102, 192, 398,
-	443, 309, 554, 387, -1000, 240, -9, 309, 104, -1000,
-	-1000, -35, 42, -1000, 398, 98, 309, 699, -1000, 309,
-	521, -1000, -10, 309, 343, -1000, -1000, -50, 93, 583,
-	-1000, 309, -1000, -1000, 387, -42, 583, 64, 583, 53,
-	583, -16, -1000, -36, 583, 343, 309, 309, -1000, 309,
-	-19, 583, 488, 583, -1000, -1000,
+var yyPact = [...]int{
+	328, -1000, -35, -38, -1000, -1000, 86, -1000, 64, 109,
+	703, 75, 108, -1000, 509, 509, 94, -5, 509, -1000,
+	-1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, 104, 102,
+	370, 509, -1000, 328, -1000, 423, -1000, -6, 94, 72,
+	595, 509, 509, 509, 509, 509, 509, 509, 509, 509,
+	509, 509, 509, 509, 509, 509, 509, 509, 509, 509,
+	509, 509, 98, 509, 61, -11, -7, -1000, -11, 9,
+	-1000, 509, 178, -1000, -1000, -37, -20, -1000, -1000, -1000,
+	63, 97, 7, 741, -1000, 549, -38, -1000, -1000, 94,
+	-21, 509, -13, -43, -48, 703, 60, 776, 508, 594,
+	422, 422, -11, -11, -11, 106, 106, 106, 106, 106,
+	106, 106, 106, 106, 106, 106, 106, 106, -1000, 703,
+	509, 509, 96, 259, 595, 663, 509, 635, 383, -1000,
+	74, -8, 59, 509, 90, -1000, -1000, -23, 39, 703,
+	-1000, 595, 95, 509, 703, 776, -1000, 509, 549, -1000,
+	-11, 509, 300, -1000, -1000, -50, 94, 509, 703, -1000,
+	509, -1000, -1000, 383, -48, 703, 55, 703, 218, 703,
+	-2, -1000, -24, 703, 703, 300, 509, 509, -1000, 509,
+	-3, 703, 463, 703, -1000, -1000,
 }
-var yyPgo = [...]int{
 
-	0, 115, 144, 135, 5, 66, 8, 96, 133, 0,
-	132, 6, 4, 131, 22, 124, 123, 1, 105, 3,
+var yyPgo = [...]int{
+	0, 89, 138, 137, 4, 38, 6, 69, 132, 0,
+	128, 2, 5, 124, 24, 120, 118, 1, 117, 3,
 }
-var yyR1 = [...]int{
 
-	0, 18, 18, 18, 7, 7, 5, 5, 5, 19,
-	19, 2, 2, 1, 4, 11, 8, 8, 6, 6,
-	3, 3, 9, 9, 9, 9, 9, 9, 9, 9,
+var yyR1 = [...]int{
+	0, 18, 18, 18, 7, 7, 5, 5, 5, 5,
+	19, 19, 2, 2, 1, 4, 4, 11, 8, 8,
+	6, 6, 6, 3, 3, 9, 9, 9, 9, 9,
 	9, 9, 9, 9, 9, 9, 9, 9, 9, 9,
-	9, 9, 9, 9, 9, 9, 9, 9, 9, 10,
-	10, 10, 10, 10, 10, 10, 10, 10, 10, 10,
-	10, 10, 15, 15, 15, 15, 16, 16, 17, 17,
-	12, 12, 12, 12, 13, 13, 14, 14, 14,
+	9, 9, 9, 9, 9, 9, 9, 9, 9, 9,
+	9, 9, 9, 9, 9, 9, 10, 10, 10, 10,
+	10, 10, 10, 10, 10, 10, 10, 10, 10, 15,
+	15, 15, 15, 16, 16, 17, 17, 12, 12, 12,
+	12, 13, 13, 14, 14, 14,
 }
-var yyR2 = [...]int{
 
-	0, 2, 4, 2, 1, 3, 1, 6, 1, 0,
-	1, 1, 3, 2, 3, 6, 1, 3, 1, 6,
-	1, 4, 1, 4, 3, 3, 3, 3, 3, 3,
+var yyR2 = [...]int{
+	0, 2, 4, 2, 1, 3, 1, 6, 4, 1,
+	0, 1, 1, 3, 2, 3, 4, 6, 1, 3,
+	1, 6, 4, 1, 4, 1, 4, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 2, 2, 3, 5, 4, 8, 5, 1, 1,
-	1, 1, 1, 1, 1, 1, 1, 1, 2, 2,
-	3, 3, 0, 1, 3, 1, 1, 3, 3, 5,
-	3, 1, 3, 1, 1, 3, 0, 1, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 2, 2,
+	3, 5, 4, 8, 5, 1, 1, 1, 1, 1,
+	1, 1, 1, 1, 1, 2, 2, 3, 3, 0,
+	1, 3, 1, 1, 3, 3, 5, 3, 1, 3,
+	1, 1, 3, 0, 1, 3,
 }
-var yyChk = [...]int{
 
-	-1000, -18, -2, -7, -1, -5, 31, -4, 30, -9,
-	4, -10, 36, 50, 26, 32, 33, -11, 6, 9,
-	13, 7, 11, 12, 8, 10, 28, 29, 27, 45,
-	-19, 49, -19, 49, 7, 4, 45, 45, 26, 14,
-	15, 35, 36, 38, 39, 40, 17, 18, 19, 20,
-	21, 24, 23, 25, 22, 42, 16, -9, 30, 4,
-	-9, -14, 4, 45, -9, 4, 4, -8, -13, -6,
-	-12, -4, 30, 4, -9, 5, -9, -7, -1, -5,
-	45, -14, -15, -16, -17, -9, 4, -9, -9, -9,
+var yyChk = [...]int{
+	-1000, -18, -2, -7, -1, -5, 38, -4, 37, 39,
+	-9, 4, 40, -10, 45, 56, 30, 41, 42, -11,
+	6, 9, 13, 7, 11, 12, 8, 10, 35, 36,
+	31, 33, -19, 55, -19, 55, 7, 4, 33, 4,
+	33, 30, 14, 15, 44, 45, 47, 48, 49, 17,
+	18, 19, 20, 21, 24, 23, 25, 22, 26, 27,
+	28, 29, 51, 16, 4, -9, 37, 4, -9, -14,
+	4, 33, -9, 4, 4, -8, -13, -6, -12, -4,
+	37, 39, 4, -9, 5, -9, -7, -1, -5, 33,
+	-14, 16, -15, -16, -17, -9, 4, -9, -9, -9,
 	-9, -9, -9, -9, -9, -9, -9, -9, -9, -9,
-	-9, -9, -9, -9, 4, -9, 26, 51, -9, 45,
-	26, 36, -9, 49, 47, 51, 4, 52, 42, 46,
-	-19, -14, 46, 46, 51, 51, 16, -9, 4, 51,
-	-9, 4, -9, 34, -9, -6, -12, 4, 45, -9,
-	5, 46, -3, -11, 27, -17, -9, 4, -9, -9,
-	-9, -19, 49, -14, -9, -9, 16, 51, 47, 46,
-	-19, -9, -9, -9, 47, 46,
+	-9, -9, -9, -9, -9, -9, -9, -9, 4, -9,
+	16, 30, 57, -9, 33, 30, 45, -9, 55, 32,
+	57, 4, 4, 58, 51, 34, -19, -14, 34, -9,
+	34, 57, 57, 16, -9, -9, 4, 57, -9, 4,
+	-9, 43, -9, -6, -12, 4, 33, 16, -9, 5,
+	34, -3, -11, 31, -17, -9, 4, -9, -9, -9,
+	-19, 55, -14, -9, -9, -9, 16, 57, 32, 34,
+	-19, -9, -9, -9, 32, 34,
 }
-var yyDef = [...]int{
 
-	0, -2, 9, 9, 11, 4, 0, 6, 0, 8,
-	57, 22, 0, 0, 76, 0, 0, 48, 49, 50,
-	51, 52, 53, 54, 55, 56, 0, 0, 0, 0,
-	1, 10, 3, 10, 13, 0, 76, 62, 0, 0,
+var yyDef = [...]int{
+	0, -2, 10, 10, 12, 4, 0, 6, 0, 0,
+	9, 64, 0, 25, 0, 0, 83, 0, 0, 55,
+	56, 57, 58, 59, 60, 61, 62, 63, 0, 0,
+	0, 0, 1, 11, 3, 11, 14, 0, 83, 0,
+	69, 0, 0, 0, 0, 0, 0, 0, 0, 0,
 	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-	0, 0, 0, 0, 0, 0, 0, 41, 0, 57,
-	42, 0, 77, 0, 0, 58, 59, 0, 0, 16,
-	74, 18, 0, 57, 71, 73, 0, 9, 12, 5,
-	76, 0, 0, 63, 65, 66, 57, 24, 25, 26,
-	27, 28, 29, 30, 31, 32, 33, 34, 35, 36,
-	37, 38, 39, 40, 43, 14, 0, 0, 0, 62,
-	76, 0, 0, 0, 60, 0, 0, 0, 0, 61,
-	2, 0, 0, 23, 0, 0, 0, 45, 78, 0,
-	66, -2, -2, 0, 9, 17, 75, 57, 76, 70,
-	72, 0, 44, 20, 0, 64, 67, 0, 68, 0,
-	47, 0, 10, 0, 7, 9, 0, 0, 15, 0,
-	0, 69, 0, 19, 21, 46,
+	0, 0, 0, 0, 0, 48, 0, 64, 49, 0,
+	84, 0, 0, 65, 66, 0, 0, 18, 81, 20,
+	0, 0, 64, 78, 80, 0, 10, 13, 5, 83,
+	0, 0, 0, 70, 72, 73, 64, 27, 28, 29,
+	30, 31, 32, 33, 34, 35, 36, 37, 38, 39,
+	40, 41, 42, 43, 44, 45, 46, 47, 50, 15,
+	0, 0, 0, 0, 69, 83, 0, 0, 0, 67,
+	0, 0, 0, 0, 0, 68, 2, 0, 0, 8,
+	26, 0, 0, 0, 16, 52, 85, 0, 73, -2,
+	-2, 0, 10, 19, 82, 64, 83, 0, 77, 79,
+	0, 51, 23, 0, 71, 74, 0, 75, 0, 54,
+	0, 11, 0, 22, 7, 10, 0, 0, 17, 0,
+	0, 76, 0, 21, 24, 53,
 }
-var yyTok1 = [...]int{
 
+var yyTok1 = [...]int{
 	1, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 50, 3, 3, 28, 40, 29, 3,
-	45, 46, 38, 35, 51, 36, 42, 39, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 52, 49,
+	3, 3, 3, 56, 3, 3, 35, 49, 36, 3,
+	33, 34, 47, 44, 57, 45, 51, 48, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 58, 55,
 	25, 3, 24, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 43, 3, 44, 37, 3, 3, 3, 3, 3,
+	3, 52, 3, 53, 46, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 27, 26, 47,
+	3, 3, 3, 31, 30, 32,
 }
-var yyTok2 = [...]int{
 
+var yyTok2 = [...]int{
 	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
 	12, 13, 14, 15, 16, 17, 18, 19, 20, 21,
-	22, 23, 30, 31, 32, 33, 34, 41, 48,
+	22, 23, 26, 27, 28, 29, 37, 38, 39, 40,
+	41, 42, 43, 50, 54,
 }
+
 var yyTok3 = [...]int{
 	0,
 }
@@ -408,9 +439,9 @@ func yyErrorMessage(state, lookAhead int) string {
 	expected := make([]int, 0, 4)
 
 	// Look for shiftable tokens.
-	base := yyPact[state]
+	base := int(yyPact[state])
 	for tok := TOKSTART; tok-1 < len(yyToknames); tok++ {
-		if n := base + tok; n >= 0 && n < yyLast && yyChk[yyAct[n]] == tok {
+		if n := base + tok; n >= 0 && n < yyLast && int(yyChk[int(yyAct[n])]) == tok {
 			if len(expected) == cap(expected) {
 				return res
 			}
@@ -420,13 +451,13 @@ func yyErrorMessage(state, lookAhead int) string {
 
 	if yyDef[state] == -2 {
 		i := 0
-		for yyExca[i] != -1 || yyExca[i+1] != state {
+		for yyExca[i] != -1 || int(yyExca[i+1]) != state {
 			i += 2
 		}
 
 		// Look for tokens that we accept or reduce.
 		for i += 2; yyExca[i] >= 0; i += 2 {
-			tok := yyExca[i]
+			tok := int(yyExca[i])
 			if tok < TOKSTART || yyExca[i+1] == 0 {
 				continue
 			}
@@ -457,30 +488,30 @@ func yylex1(lex yyLexer, lval *yySymType) (char, token int) {
 	token = 0
 	char = lex.Lex(lval)
 	if char <= 0 {
-		token = yyTok1[0]
+		token = int(yyTok1[0])
 		goto out
 	}
 	if char < len(yyTok1) {
-		token = yyTok1[char]
+		token = int(yyTok1[char])
 		goto out
 	}
 	if char >= yyPrivate {
 		if char < yyPrivate+len(yyTok2) {
-			token = yyTok2[char-yyPrivate]
+			token = int(yyTok2[char-yyPrivate])
 			goto out
 		}
 	}
 	for i := 0; i < len(yyTok3); i += 2 {
-		token = yyTok3[i+0]
+		token = int(yyTok3[i+0])
 		if token == char {
-			token = yyTok3[i+1]
+			token = int(yyTok3[i+1])
 			goto out
 		}
 	}
 
 out:
 	if token == 0 {
-		token = yyTok2[1] /* unknown char */
+		token = int(yyTok2[1]) /* unknown char */
 	}
 	if yyDebug >= 3 {
 		__yyfmt__.Printf("lex %s(%d)\n", yyTokname(token), uint(char))
@@ -535,7 +566,7 @@ yystack:
 	yyS[yyp].yys = yystate
 
 yynewstate:
-	yyn = yyPact[yystate]
+	yyn = int(yyPact[yystate])
 	if yyn <= yyFlag {
 		goto yydefault /* simple state */
 	}
@@ -546,8 +577,8 @@ yynewstate:
 	if yyn < 0 || yyn >= yyLast {
 		goto yydefault
 	}
-	yyn = yyAct[yyn]
-	if yyChk[yyn] == yytoken { /* valid shift */
+	yyn = int(yyAct[yyn])
+	if int(yyChk[yyn]) == yytoken { /* valid shift */
 		yyrcvr.char = -1
 		yytoken = -1
 		yyVAL = yyrcvr.lval
@@ -560,7 +591,7 @@ yynewstate:
 
 yydefault:
 	/* default state action */
-	yyn = yyDef[yystate]
+	yyn = int(yyDef[yystate])
 	if yyn == -2 {
 		if yyrcvr.char < 0 {
 			yyrcvr.char, yytoken = yylex1(yylex, &yyrcvr.lval)
@@ -569,18 +600,18 @@ yydefault:
 		/* look through exception table */
 		xi := 0
 		for {
-			if yyExca[xi+0] == -1 && yyExca[xi+1] == yystate {
+			if yyExca[xi+0] == -1 && int(yyExca[xi+1]) == yystate {
 				break
 			}
 			xi += 2
 		}
 		for xi += 2; ; xi += 2 {
-			yyn = yyExca[xi+0]
+			yyn = int(yyExca[xi+0])
 			if yyn < 0 || yyn == yytoken {
 				break
 			}
 		}
-		yyn = yyExca[xi+1]
+		yyn = int(yyExca[xi+1])
 		if yyn < 0 {
 			goto ret0
 		}
@@ -602,10 +633,10 @@ yydefault:
 
 			/* find a state where "error" is a legal shift action */
 			for yyp >= 0 {
-				yyn = yyPact[yyS[yyp].yys] + yyErrCode
+				yyn = int(yyPact[yyS[yyp].yys]) + yyErrCode
 				if yyn >= 0 && yyn < yyLast {
-					yystate = yyAct[yyn] /* simulate a shift of "error" */
-					if yyChk[yystate] == yyErrCode {
+					yystate = int(yyAct[yyn]) /* simulate a shift of "error" */
+					if int(yyChk[yystate]) == yyErrCode {
 						goto yystack
 					}
 				}
@@ -641,7 +672,7 @@ yydefault:
 	yypt := yyp
 	_ = yypt // guard against "declared and not used"
 
-	yyp -= yyR2[yyn]
+	yyp -= int(yyR2[yyn])
 	// yyp is now the index of $0. Perform the default action. Iff the
 	// reduced production is ε, $1 is possibly out of range.
 	if yyp+1 >= len(yyS) {
@@ -652,16 +683,16 @@ yydefault:
 	yyVAL = yyS[yyp+1]
 
 	/* consult goto table to find next state */
-	yyn = yyR1[yyn]
-	yyg := yyPgo[yyn]
+	yyn = int(yyR1[yyn])
+	yyg := int(yyPgo[yyn])
 	yyj := yyg + yyS[yyp].yys + 1
 
 	if yyj >= yyLast {
-		yystate = yyAct[yyg]
+		yystate = int(yyAct[yyg])
 	} else {
-		yystate = yyAct[yyj]
-		if yyChk[yystate] != -yyn {
-			yystate = yyAct[yyg]
+		yystate = int(yyAct[yyj])
+		if int(yyChk[yystate]) != -yyn {
+			yystate = int(yyAct[yyg])
 		}
 	}
 	// dummy call; replaced with literal code
@@ -706,281 +737,316 @@ yydefault:
 			yyVAL.statement = NewASTStatement(yyDollar[1].pos, yyDollar[2].stringNode.str, NewASTLambda(yyDollar[1].pos, yyDollar[4].stringListNode.str, yyDollar[6].expr))
 		}
 	case 8:
+		yyDollar = yyS[yypt-4 : yypt+1]
+		{
+			yyVAL.statement = NewASTMatview(yyDollar[1].pos, yyDollar[2].stringNode.str, yyDollar[4].expr)
+		}
+	case 9:
 		yyDollar = yyS[yypt-1 : yypt+1]
 		{
 			yyVAL.statement = ASTStatement{Expr: yyDollar[1].expr}
 		}
-	case 11:
+	case 12:
 		yyDollar = yyS[yypt-1 : yypt+1]
 		{
 			yyVAL.statementsOrLoads = []ASTStatementOrLoad{yyDollar[1].statementOrLoad}
 		}
-	case 12:
+	case 13:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.statementsOrLoads = append(yyDollar[1].statementsOrLoads, yyDollar[3].statementOrLoad)
 		}
-	case 13:
+	case 14:
 		yyDollar = yyS[yypt-2 : yypt+1]
 		{
-			yyVAL.statementOrLoad = ASTStatementOrLoad{LoadPath: yyDollar[2].expr.(*ASTLiteral).Literal.Str(nil)}
+			yyVAL.statementOrLoad = ASTStatementOrLoad{ASTStatement: ASTStatement{Pos: yyDollar[1].pos}, LoadPath: yyDollar[2].expr.(*ASTLiteral).Literal.Str(nil)}
 		}
-	case 14:
+	case 15:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.statement = ASTStatement{Pos: yyDollar[1].stringNode.pos, LHS: symbol.Intern(yyDollar[1].stringNode.str), Expr: yyDollar[3].expr}
 		}
-	case 15:
+	case 16:
+		yyDollar = yyS[yypt-4 : yypt+1]
+		{
+			yyVAL.statement = ASTStatement{Pos: yyDollar[1].pos, LHS: symbol.Intern(yyDollar[2].stringNode.str), Expr: yyDollar[4].expr, Const: true}
+		}
+	case 17:
 		yyDollar = yyS[yypt-6 : yypt+1]
 		{
 			yyVAL.expr = &ASTBlock{Pos: yyDollar[1].pos, Statements: append(yyDollar[2].statements, ASTStatement{Pos: yyDollar[4].expr.pos(), Expr: yyDollar[4].expr})}
 		}
-	case 16:
+	case 18:
 		yyDollar = yyS[yypt-1 : yypt+1]
 		{
 			yyVAL.statements = []ASTStatement{yyDollar[1].statement}
 		}
-	case 17:
+	case 19:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.statements = append(yyDollar[1].statements, yyDollar[3].statement)
 		}
-	case 19:
+	case 21:
 		yyDollar = yyS[yypt-6 : yypt+1]
 		{
 			yyVAL.statement = NewASTStatement(yyDollar[1].pos, yyDollar[2].stringNode.str, NewASTLambda(yyDollar[1].pos, yyDollar[4].stringListNode.str, yyDollar[6].expr))
 		}
-	case 20:
+	case 22:
+		yyDollar = yyS[yypt-4 : yypt+1]
+		{
+			yyVAL.statement = NewASTMatview(yyDollar[1].pos, yyDollar[2].stringNode.str, yyDollar[4].expr)
+		}
+	case 23:
 		yyDollar = yyS[yypt-1 : yypt+1]
 		{
-			yyVAL.statements = []ASTStatement{{Pos: yyDollar[1].expr.pos(), Expr: yyDollar[1].expr}}
+			yyVAL.legacyBody = legacyFunctionBody{statements: []ASTStatement{{Pos: yyDollar[1].expr.pos(), Expr: yyDollar[1].expr}}}
 		}
-	case 21:
+	case 24:
 		yyDollar = yyS[yypt-4 : yypt+1]
 		{
-			yyVAL.statements = []ASTStatement{{Pos: yyDollar[1].pos, Expr: yyDollar[2].expr}}
+			yyVAL.legacyBody = legacyFunctionBody{statements: []ASTStatement{{Pos: yyDollar[1].pos, Expr: yyDollar[2].expr}}, bare: true, open: yyDollar[1].pos, close: yyDollar[4].pos}
 		}
-	case 23:
+	case 26:
 		yyDollar = yyS[yypt-4 : yypt+1]
 		{
 			yyVAL.expr = NewASTFuncall(yyDollar[1].expr, yyDollar[3].paramVals)
 		}
-	case 24:
+	case 27:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTPipe(yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 25:
+	case 28:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = &ASTLogicalOp{AndAnd: false, LHS: yyDollar[1].expr, RHS: yyDollar[3].expr}
 		}
-	case 26:
+	case 29:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = &ASTLogicalOp{AndAnd: true, LHS: yyDollar[1].expr, RHS: yyDollar[3].expr}
 		}
-	case 27:
+	case 30:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinPlusValue, yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 28:
+	case 31:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinMinusValue, yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 29:
+	case 32:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinMultiplyValue, yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 30:
+	case 33:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinDivideValue, yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 31:
+	case 34:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinModValue, yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 32:
+	case 35:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinEQValue, yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 33:
+	case 36:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinEQOrRhsNullValue, yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 34:
+	case 37:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinEQOrLhsNullValue, yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 35:
+	case 38:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinEQOrBothNullValue, yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 36:
+	case 39:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinNEValue, yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 37:
+	case 40:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinGTValue, yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 38:
+	case 41:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinGEValue, yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 39:
+	case 42:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinGTValue, yyDollar[3].expr, yyDollar[1].expr)
 		}
-	case 40:
+	case 43:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinGEValue, yyDollar[3].expr, yyDollar[1].expr)
 		}
-	case 41:
+	case 44:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinGTNotNullValue, yyDollar[1].expr, yyDollar[3].expr)
+		}
+	case 45:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinGENotNullValue, yyDollar[1].expr, yyDollar[3].expr)
+		}
+	case 46:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinGTNotNullValue, yyDollar[3].expr, yyDollar[1].expr)
+		}
+	case 47:
+		yyDollar = yyS[yypt-3 : yypt+1]
+		{
+			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[1].expr.pos(), builtinGENotNullValue, yyDollar[3].expr, yyDollar[1].expr)
+		}
+	case 48:
 		yyDollar = yyS[yypt-2 : yypt+1]
 		{
 			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[2].expr.pos(), builtinNegateValue, yyDollar[2].expr)
 		}
-	case 42:
+	case 49:
 		yyDollar = yyS[yypt-2 : yypt+1]
 		{
 			yyVAL.expr = NewASTBuiltinFuncall(yyDollar[2].expr.pos(), builtinNotValue, yyDollar[2].expr)
 		}
-	case 43:
+	case 50:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTStructFieldRef(yyDollar[1].expr, yyDollar[3].stringNode.str)
 		}
-	case 44:
+	case 51:
 		yyDollar = yyS[yypt-5 : yypt+1]
 		{
-			yyVAL.expr = NewASTLambda(yyDollar[1].pos, yyDollar[3].stringListNode.str, &ASTBlock{Pos: yyDollar[1].pos, Statements: yyDollar[5].statements})
+			yyVAL.expr = newLegacyASTLambda(yyDollar[1].pos, yyDollar[2].pos, yyDollar[3].stringListNode.str, yyDollar[4].pos, yyDollar[5].legacyBody)
 		}
-	case 45:
+	case 52:
 		yyDollar = yyS[yypt-4 : yypt+1]
 		{
 			yyVAL.expr = NewASTLambda(yyDollar[1].pos, yyDollar[2].stringListNode.str, yyDollar[4].expr)
 		}
-	case 46:
+	case 53:
 		yyDollar = yyS[yypt-8 : yypt+1]
 		{
 			yyVAL.expr = &ASTCondOp{Pos: yyDollar[1].pos, Cond: yyDollar[3].expr, Then: yyDollar[5].expr, Else: yyDollar[7].expr}
 		}
-	case 47:
+	case 54:
 		yyDollar = yyS[yypt-5 : yypt+1]
 		{
 			yyVAL.expr = &ASTCondOp{Pos: yyDollar[1].pos, Cond: yyDollar[2].expr, Then: yyDollar[3].expr, Else: yyDollar[5].expr}
 		}
-	case 57:
+	case 64:
 		yyDollar = yyS[yypt-1 : yypt+1]
 		{
 			yyVAL.expr = &ASTVarRef{Pos: yyDollar[1].stringNode.pos, Var: symbol.Intern(yyDollar[1].stringNode.str)}
 		}
-	case 58:
+	case 65:
 		yyDollar = yyS[yypt-2 : yypt+1]
 		{
 			yyVAL.expr = &ASTColumnRef{Pos: yyDollar[1].pos, Col: symbol.Intern(yyDollar[2].stringNode.str), Deprecated: true}
 		}
-	case 59:
+	case 66:
 		yyDollar = yyS[yypt-2 : yypt+1]
 		{
 			yyVAL.expr = &ASTImplicitColumnRef{Pos: yyDollar[1].pos, Col: symbol.Intern(yyDollar[2].stringNode.str)}
 		}
-	case 60:
+	case 67:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = NewASTStructLiteral(yyDollar[1].pos, yyDollar[2].structFields)
 		}
-	case 61:
+	case 68:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.expr = yyDollar[2].expr
 		}
-	case 62:
+	case 69:
 		yyDollar = yyS[yypt-0 : yypt+1]
 		{
 			yyVAL.paramVals = nil
 		}
-	case 64:
+	case 71:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.paramVals = append(yyDollar[1].paramVals, yyDollar[3].paramVals...)
 		}
-	case 66:
+	case 73:
 		yyDollar = yyS[yypt-1 : yypt+1]
 		{
 			yyVAL.paramVals = []ASTParamVal{NewASTParamVal(yyDollar[1].expr.pos(), "", yyDollar[1].expr)}
 		}
-	case 67:
+	case 74:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.paramVals = append(yyDollar[1].paramVals, NewASTParamVal(yyDollar[3].expr.pos(), "", yyDollar[3].expr))
 		}
-	case 68:
+	case 75:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.paramVals = []ASTParamVal{NewASTParamVal(yyDollar[1].stringNode.pos, yyDollar[1].stringNode.str, yyDollar[3].expr)}
 		}
-	case 69:
+	case 76:
 		yyDollar = yyS[yypt-5 : yypt+1]
 		{
 			yyVAL.paramVals = append(yyDollar[1].paramVals, NewASTParamVal(yyDollar[3].stringNode.pos, yyDollar[3].stringNode.str, yyDollar[5].expr))
 		}
-	case 70:
+	case 77:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.structField = NewASTStructLiteralField(yyDollar[1].stringNode.pos, yyDollar[1].stringNode.str, yyDollar[3].expr)
 		}
-	case 71:
+	case 78:
 		yyDollar = yyS[yypt-1 : yypt+1]
 		{
 			yyVAL.structField = NewASTStructLiteralField(yyDollar[1].expr.pos(), "", yyDollar[1].expr)
 		}
-	case 72:
+	case 79:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.structField = NewASTStructLiteralField(yyDollar[1].expr.pos(), "", NewASTStructFieldRegex(yyDollar[1].expr.pos(), yyDollar[1].expr, yyDollar[3].stringNode.str))
 		}
-	case 73:
+	case 80:
 		yyDollar = yyS[yypt-1 : yypt+1]
 		{
 			yyVAL.structField = NewASTStructLiteralField(yyDollar[1].stringNode.pos, "", NewASTStructFieldRegex(yyDollar[1].stringNode.pos, nil, yyDollar[1].stringNode.str))
 		}
-	case 74:
+	case 81:
 		yyDollar = yyS[yypt-1 : yypt+1]
 		{
 			yyVAL.structFields = []ASTStructLiteralField{yyDollar[1].structField}
 		}
-	case 75:
+	case 82:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.structFields = append(yyDollar[1].structFields, yyDollar[3].structField)
 		}
-	case 76:
+	case 83:
 		yyDollar = yyS[yypt-0 : yypt+1]
 		{
 			yyVAL.stringListNode = stringListNode{}
 		}
-	case 77:
+	case 84:
 		yyDollar = yyS[yypt-1 : yypt+1]
 		{
 			yyVAL.stringListNode = stringListNode{pos: yyDollar[1].stringNode.pos, str: []string{yyDollar[1].stringNode.str}}
 		}
-	case 78:
+	case 85:
 		yyDollar = yyS[yypt-3 : yypt+1]
 		{
 			yyVAL.stringListNode.str = append(yyDollar[1].stringListNode.str, yyDollar[3].stringNode.str)
@@ -107,6 +107,7 @@ func (h *jupyterHandler) HandleExecuteRequest(
 		printArgs.Out = termutil.NewBatchPrinter(&out)
 		mimeType = "text/markdown"
 	}
+	h.tmpVars.Expr = strings.TrimSpace(r.Code)
 	if err := h.eval(ctx, r.Code, printArgs); err != nil {
 		stream("stderr", "eval: `"+r.Code+"`: ` "+err.Error())
 		result.Status = "error"
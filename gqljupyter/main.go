@@ -7,7 +7,7 @@ import (
 	"github.com/grailbio/base/log"
 	"github.com/grailbio/bigslice/sliceconfig"
 	"github.com/grailbio/gql/gql"
-	"github.com/grailbio/gql/lib"
+	_ "github.com/grailbio/gql/lib" // Registers the standard library.
 )
 
 var (
@@ -26,10 +26,5 @@ func main() {
 	}
 	gql.Init(opts)
 	sess := gql.NewSession()
-	lib, err := sess.Parse("lib", []byte(lib.Script))
-	if err != nil {
-		log.Panicf("load lib: %v", err)
-	}
-	sess.EvalStatements(ctx, lib)
 	jupyterKernel(ctx, *jupyterConnectionFlag, sess)
 }
@@ -30,6 +30,10 @@ type BinaryTSVIndex struct {
 	Path             string                        `protobuf:"bytes,6,opt,name=path,proto3" json:"path,omitempty"`
 	Description      []string                      `protobuf:"bytes,4,rep,name=description,proto3" json:"description,omitempty"`
 	MarshaledContext []byte                        `protobuf:"bytes,7,opt,name=marshaled_context,json=marshaledContext,proto3" json:"marshaled_context,omitempty"`
+	StringDict       []string                      `protobuf:"bytes,8,rep,name=string_dict,json=stringDict,proto3" json:"string_dict,omitempty"`
+	TableDescription string                        `protobuf:"bytes,9,opt,name=table_description,json=tableDescription,proto3" json:"table_description,omitempty"`
+	Version          string                        `protobuf:"bytes,10,opt,name=version,proto3" json:"version,omitempty"`
+	Metadata         []string                      `protobuf:"bytes,11,rep,name=metadata,proto3" json:"metadata,omitempty"`
 }
 
 func (m *BinaryTSVIndex) Reset()         { *m = BinaryTSVIndex{} }
@@ -114,11 +118,40 @@ func (m *BinaryTSVIndex) GetMarshaledContext() []byte {
 	return nil
 }
 
+func (m *BinaryTSVIndex) GetStringDict() []string {
+	if m != nil {
+		return m.StringDict
+	}
+	return nil
+}
+
+func (m *BinaryTSVIndex) GetTableDescription() string {
+	if m != nil {
+		return m.TableDescription
+	}
+	return ""
+}
+
+func (m *BinaryTSVIndex) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+func (m *BinaryTSVIndex) GetMetadata() []string {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
 type BinaryTSVIndex_Column struct {
 	Col         int32  `protobuf:"varint,1,opt,name=col,proto3" json:"col,omitempty"`
 	Typ         int32  `protobuf:"varint,2,opt,name=typ,proto3" json:"typ,omitempty"`
 	Name        string `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
 	Description string `protobuf:"bytes,4,opt,name=description,proto3" json:"description,omitempty"`
+	Unit        string `protobuf:"bytes,5,opt,name=unit,proto3" json:"unit,omitempty"`
 }
 
 func (m *BinaryTSVIndex_Column) Reset()         { *m = BinaryTSVIndex_Column{} }
@@ -182,6 +215,13 @@ func (m *BinaryTSVIndex_Column) GetDescription() string {
 	return ""
 }
 
+func (m *BinaryTSVIndex_Column) GetUnit() string {
+	if m != nil {
+		return m.Unit
+	}
+	return ""
+}
+
 type BinaryTSVIndex_TimeLocation struct {
 	Str     string `protobuf:"bytes,1,opt,name=str,proto3" json:"str,omitempty"`
 	Name    string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
@@ -253,33 +293,37 @@ func init() {
 }
 
 var fileDescriptor_d4b5b4d6a3850c3a = []byte{
-	// 405 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x92, 0x41, 0xcb, 0xd3, 0x30,
-	0x1c, 0xc6, 0x9b, 0x75, 0xeb, 0x5c, 0x36, 0x75, 0xe6, 0x54, 0x07, 0xd6, 0xe2, 0x41, 0x0a, 0x6a,
-	0x0b, 0x2a, 0x78, 0xdf, 0xbc, 0x08, 0x82, 0x90, 0x0d, 0x05, 0x2f, 0xa3, 0xed, 0xb2, 0x2e, 0x90,
-	0x36, 0x5d, 0x92, 0xe9, 0xf6, 0x2d, 0xfc, 0x58, 0x3b, 0xee, 0xe8, 0x49, 0x64, 0xfb, 0x00, 0x7e,
-	0x05, 0x49, 0x32, 0x46, 0xdf, 0xbd, 0xbc, 0xbc, 0xb7, 0xe7, 0xff, 0x34, 0xcf, 0xef, 0xff, 0xa4,
-	0x2d, 0x7c, 0x5f, 0xf0, 0x44, 0x8a, 0x3c, 0x29, 0xa8, 0x5a, 0x6d, 0xb2, 0x38, 0xe7, 0x65, 0x52,
-	0x88, 0x94, 0xb2, 0x8c, 0xf2, 0xa4, 0x58, 0xb3, 0xa4, 0x16, 0x5c, 0xf1, 0x24, 0xa3, 0x55, 0x2a,
-	0x76, 0x4a, 0xfe, 0x88, 0xcd, 0x8c, 0x1e, 0x9b, 0x33, 0x76, 0x88, 0x8b, 0x35, 0x1b, 0xbd, 0x69,
-	0xe6, 0x79, 0xc1, 0x6d, 0x2e, 0xdb, 0x2c, 0xcd, 0x64, 0x21, 0x5a, 0xd9, 0xc8, 0x8b, 0x7f, 0x2e,
-	0x7c, 0x34, 0x36, 0xcc, 0xd9, 0xf4, 0xeb, 0xa7, 0x6a, 0x41, 0xb6, 0x08, 0xc1, 0xb6, 0xe0, 0x3f,
-	0xa5, 0x0f, 0x42, 0x10, 0xb9, 0xd8, 0x68, 0xf4, 0x11, 0x7a, 0x39, 0x67, 0x9b, 0xb2, 0xf2, 0x5b,
-	0xa1, 0x1b, 0xf5, 0xdf, 0xbe, 0x8c, 0xaf, 0xf6, 0xc6, 0x37, 0x21, 0xf1, 0xc4, 0x9c, 0x1e, 0xb7,
-	0xf7, 0x7f, 0x9e, 0x3b, 0xf8, 0x9c, 0x45, 0xdf, 0xe0, 0x43, 0x45, 0x4b, 0x32, 0x67, 0x3c, 0x4f,
-	0x15, 0xe5, 0x95, 0xef, 0x1a, 0xd8, 0xeb, 0xfb, 0x60, 0x33, 0x5a, 0x92, 0xcf, 0xe7, 0xcc, 0x19,
-	0x39, 0x50, 0x0d, 0x4f, 0x57, 0xae, 0xd2, 0x92, 0xf8, 0x9d, 0x10, 0x44, 0x3d, 0x6c, 0xb4, 0xf6,
-	0xea, 0x54, 0xad, 0x7c, 0xcf, 0x7a, 0x5a, 0xa3, 0x10, 0xf6, 0x17, 0x44, 0xe6, 0x82, 0xd6, 0x66,
-	0x7d, 0x3b, 0x74, 0xa3, 0x1e, 0x6e, 0x5a, 0xe8, 0x15, 0x7c, 0x52, 0xa6, 0x42, 0xae, 0x52, 0x46,
-	0x16, 0xf3, 0x9c, 0x57, 0x8a, 0x6c, 0x95, 0xdf, 0x0d, 0x41, 0x34, 0xc0, 0xc3, 0xcb, 0x83, 0x89,
-	0xf5, 0x47, 0x19, 0xf4, 0xec, 0x3d, 0xd1, 0x10, 0xba, 0x39, 0x67, 0xe6, 0x95, 0x75, 0xb0, 0x96,
-	0xda, 0x51, 0xbb, 0xda, 0x6f, 0x59, 0x47, 0xed, 0xea, 0x4b, 0x49, 0xb7, 0x51, 0xf2, 0x56, 0x21,
-	0x70, 0x55, 0x68, 0xf4, 0x05, 0x0e, 0x9a, 0xd7, 0xd7, 0x5c, 0xa9, 0x84, 0xd9, 0xd4, 0xc3, 0x5a,
-	0x5e, 0xb8, 0xad, 0x06, 0xf7, 0x29, 0x7c, 0xc0, 0x97, 0x4b, 0x49, 0xd4, 0x5c, 0x9a, 0x7d, 0x1d,
-	0xdc, 0xb5, 0xf3, 0x74, 0xfc, 0x61, 0x7f, 0x0c, 0xc0, 0xe1, 0x18, 0x80, 0xbf, 0xc7, 0x00, 0xfc,
-	0x3a, 0x05, 0xce, 0xe1, 0x14, 0x38, 0xbf, 0x4f, 0x81, 0xf3, 0xfd, 0xd9, 0x5d, 0xbf, 0x5e, 0xb1,
-	0x66, 0x75, 0x96, 0x79, 0xe6, 0xfb, 0xbc, 0xfb, 0x1f, 0x00, 0x00, 0xff, 0xff, 0x5b, 0xa2, 0x0e,
-	0x43, 0xa9, 0x02, 0x00, 0x00,
+	// 474 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x84, 0x53, 0x4f, 0x8b, 0xd3, 0x40,
+	0x1c, 0x6d, 0x36, 0xfd, 0x3b, 0xad, 0x5a, 0xe7, 0x34, 0x16, 0xcc, 0x06, 0x0f, 0x12, 0x50, 0x13,
+	0x50, 0xc1, 0x7b, 0xb7, 0x17, 0x41, 0x10, 0xb2, 0x8b, 0x82, 0x97, 0x30, 0x49, 0xa6, 0xe9, 0x40,
+	0x92, 0x49, 0x67, 0x7e, 0xad, 0xdb, 0x4f, 0xe0, 0xd5, 0x8f, 0xb5, 0xc7, 0x3d, 0x7a, 0x12, 0x69,
+	0xbf, 0x88, 0xcc, 0x4c, 0xb7, 0xc4, 0x15, 0xf1, 0xf6, 0xde, 0x9b, 0xdf, 0xef, 0xbd, 0xe1, 0x4d,
+	0x82, 0xde, 0x16, 0x22, 0x52, 0x32, 0x8b, 0x0a, 0x0e, 0xab, 0x4d, 0x1a, 0x66, 0xa2, 0x8a, 0x0a,
+	0x49, 0x79, 0x99, 0x72, 0x11, 0x15, 0xeb, 0x32, 0x6a, 0xa4, 0x00, 0x11, 0xa5, 0xbc, 0xa6, 0x72,
+	0x07, 0x6a, 0x1b, 0x1a, 0x8e, 0x1f, 0x99, 0x19, 0x4b, 0xc2, 0x62, 0x5d, 0xce, 0x5e, 0xb5, 0xf7,
+	0x45, 0x21, 0xec, 0x5e, 0xba, 0x59, 0x1a, 0x66, 0x4d, 0x34, 0xb2, 0x2b, 0xcf, 0xbe, 0xf5, 0xd0,
+	0xc3, 0xb9, 0xf1, 0xbc, 0xba, 0xfc, 0xf4, 0xbe, 0xce, 0xd9, 0x35, 0xc6, 0xa8, 0x2b, 0xc5, 0x57,
+	0x45, 0x1c, 0xdf, 0x09, 0xdc, 0xd8, 0x60, 0xbc, 0x40, 0xfd, 0x4c, 0x94, 0x9b, 0xaa, 0x26, 0x67,
+	0xbe, 0x1b, 0x8c, 0x5f, 0x3f, 0x0f, 0xef, 0xe5, 0x86, 0x7f, 0x9a, 0x84, 0x17, 0x66, 0x7a, 0xde,
+	0xbd, 0xf9, 0x79, 0xde, 0x89, 0x8f, 0xbb, 0xf8, 0x33, 0x7a, 0x00, 0xbc, 0x62, 0x49, 0x29, 0x32,
+	0x0a, 0x5c, 0xd4, 0xc4, 0x35, 0x66, 0x2f, 0xff, 0x67, 0x76, 0xc5, 0x2b, 0xf6, 0xe1, 0xb8, 0x73,
+	0xb4, 0x9c, 0x40, 0x4b, 0xd3, 0x57, 0xae, 0x69, 0xc5, 0x48, 0xcf, 0x77, 0x82, 0x51, 0x6c, 0xb0,
+	0xd6, 0x1a, 0x0a, 0x2b, 0xd2, 0xb7, 0x9a, 0xc6, 0xd8, 0x47, 0xe3, 0x9c, 0xa9, 0x4c, 0xf2, 0xc6,
+	0xc4, 0x77, 0x7d, 0x37, 0x18, 0xc5, 0x6d, 0x09, 0xbf, 0x40, 0x8f, 0x2b, 0x2a, 0xd5, 0x8a, 0x96,
+	0x2c, 0x4f, 0x32, 0x51, 0x03, 0xbb, 0x06, 0x32, 0xf0, 0x9d, 0x60, 0x12, 0x4f, 0x4f, 0x07, 0x17,
+	0x56, 0xc7, 0xe7, 0x68, 0xac, 0x40, 0xf2, 0xba, 0x48, 0x72, 0x9e, 0x01, 0x19, 0x1a, 0x3b, 0x64,
+	0xa5, 0x05, 0xcf, 0x40, 0xbb, 0x01, 0x4d, 0x4b, 0x96, 0xb4, 0x53, 0x47, 0xe6, 0x42, 0x53, 0x73,
+	0xb0, 0x68, 0x45, 0x13, 0x34, 0xd8, 0x32, 0xa9, 0xf4, 0x08, 0x32, 0x23, 0x77, 0x14, 0xcf, 0xd0,
+	0xb0, 0x62, 0x40, 0x73, 0x0a, 0x94, 0x8c, 0x4d, 0xc8, 0x89, 0xcf, 0xb6, 0xa8, 0x6f, 0xbb, 0xc6,
+	0x53, 0xe4, 0x66, 0xa2, 0x34, 0xcf, 0xd6, 0x8b, 0x35, 0xd4, 0x0a, 0xec, 0x1a, 0x72, 0x66, 0x15,
+	0xd8, 0x35, 0xa7, 0xa2, 0xdc, 0x56, 0x51, 0x7f, 0x95, 0xe2, 0xdc, 0x2f, 0x05, 0xa3, 0xee, 0xa6,
+	0xe6, 0x70, 0x57, 0xaf, 0xc6, 0xb3, 0x8f, 0x68, 0xd2, 0x7e, 0x16, 0x9d, 0xa5, 0x40, 0x9a, 0xf4,
+	0x51, 0xac, 0xe1, 0x29, 0xeb, 0xac, 0x95, 0xf5, 0x04, 0x0d, 0xc5, 0x72, 0xa9, 0x18, 0x24, 0xca,
+	0xdc, 0xa1, 0x17, 0x0f, 0x2c, 0xbf, 0x9c, 0xbf, 0xbb, 0xd9, 0x7b, 0xce, 0xed, 0xde, 0x73, 0x7e,
+	0xed, 0x3d, 0xe7, 0xfb, 0xc1, 0xeb, 0xdc, 0x1e, 0xbc, 0xce, 0x8f, 0x83, 0xd7, 0xf9, 0xf2, 0xf4,
+	0x5f, 0xbf, 0x44, 0xb1, 0x2e, 0x9b, 0x34, 0xed, 0x9b, 0xef, 0xe6, 0xcd, 0xef, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0x87, 0xb5, 0xe5, 0x79, 0x41, 0x03, 0x00, 0x00,
 }
 
 func (m *BinaryTSVIndex) Marshal() (dAtA []byte, err error) {
@@ -359,6 +403,48 @@ func (m *BinaryTSVIndex) MarshalTo(dAtA []byte) (int, error) {
 		i = encodeVarintBinarytsv(dAtA, i, uint64(len(m.MarshaledContext)))
 		i += copy(dAtA[i:], m.MarshaledContext)
 	}
+	if len(m.StringDict) > 0 {
+		for _, s := range m.StringDict {
+			dAtA[i] = 0x42
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
+	if len(m.TableDescription) > 0 {
+		dAtA[i] = 0x4a
+		i++
+		i = encodeVarintBinarytsv(dAtA, i, uint64(len(m.TableDescription)))
+		i += copy(dAtA[i:], m.TableDescription)
+	}
+	if len(m.Version) > 0 {
+		dAtA[i] = 0x52
+		i++
+		i = encodeVarintBinarytsv(dAtA, i, uint64(len(m.Version)))
+		i += copy(dAtA[i:], m.Version)
+	}
+	if len(m.Metadata) > 0 {
+		for _, s := range m.Metadata {
+			dAtA[i] = 0x5a
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
 	return i, nil
 }
 
@@ -399,6 +485,12 @@ func (m *BinaryTSVIndex_Column) MarshalTo(dAtA []byte) (int, error) {
 		i = encodeVarintBinarytsv(dAtA, i, uint64(len(m.Description)))
 		i += copy(dAtA[i:], m.Description)
 	}
+	if len(m.Unit) > 0 {
+		dAtA[i] = 0x2a
+		i++
+		i = encodeVarintBinarytsv(dAtA, i, uint64(len(m.Unit)))
+		i += copy(dAtA[i:], m.Unit)
+	}
 	return i, nil
 }
 
@@ -485,6 +577,26 @@ func (m *BinaryTSVIndex) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovBinarytsv(uint64(l))
 	}
+	if len(m.StringDict) > 0 {
+		for _, s := range m.StringDict {
+			l = len(s)
+			n += 1 + l + sovBinarytsv(uint64(l))
+		}
+	}
+	l = len(m.TableDescription)
+	if l > 0 {
+		n += 1 + l + sovBinarytsv(uint64(l))
+	}
+	l = len(m.Version)
+	if l > 0 {
+		n += 1 + l + sovBinarytsv(uint64(l))
+	}
+	if len(m.Metadata) > 0 {
+		for _, s := range m.Metadata {
+			l = len(s)
+			n += 1 + l + sovBinarytsv(uint64(l))
+		}
+	}
 	return n
 }
 
@@ -508,6 +620,10 @@ func (m *BinaryTSVIndex_Column) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovBinarytsv(uint64(l))
 	}
+	l = len(m.Unit)
+	if l > 0 {
+		n += 1 + l + sovBinarytsv(uint64(l))
+	}
 	return n
 }
 
@@ -790,6 +906,134 @@ func (m *BinaryTSVIndex) Unmarshal(dAtA []byte) error {
 				m.MarshaledContext = []byte{}
 			}
 			iNdEx = postIndex
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field StringDict", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBinarytsv
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthBinarytsv
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthBinarytsv
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.StringDict = append(m.StringDict, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 9:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TableDescription", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBinarytsv
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthBinarytsv
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthBinarytsv
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.TableDescription = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Version", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBinarytsv
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthBinarytsv
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthBinarytsv
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Version = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Metadata", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBinarytsv
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthBinarytsv
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthBinarytsv
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Metadata = append(m.Metadata, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipBinarytsv(dAtA[iNdEx:])
@@ -945,6 +1189,38 @@ func (m *BinaryTSVIndex_Column) Unmarshal(dAtA []byte) error {
 			}
 			m.Description = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Unit", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowBinarytsv
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthBinarytsv
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthBinarytsv
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Unit = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipBinarytsv(dAtA[iNdEx:])
@@ -0,0 +1,10 @@
+package lib
+
+import "github.com/grailbio/gql/gql"
+
+// The library is evaluated in a session when the session first uses one of the
+// functions defined in Script. Importing this package is sufficient to make them
+// available.
+func init() {
+	gql.RegisterStdlib("lib", Script)
+}
@@ -1,15 +1,13 @@
 package main
 
 import (
-	"context"
 	"flag"
-	"log"
 	"os"
 	"testing"
 
 	"github.com/grailbio/gql/gql"
 	"github.com/grailbio/gql/gqltest"
-	"github.com/grailbio/gql/lib"
+	_ "github.com/grailbio/gql/lib" // Registers the standard library.
 	"github.com/grailbio/testutil/expect"
 )
 
@@ -34,14 +32,6 @@ func TestBasename(t *testing.T) {
 
 func TestMain(m *testing.M) {
 	session = gqltest.NewSession()
-	ctx := context.Background()
-	if *manualFlag {
-		lib, err := session.Parse("lib", []byte(lib.Script))
-		if err != nil {
-			log.Panicf("load lib: %v", err)
-		}
-		session.EvalStatements(ctx, lib)
-	}
 	status := m.Run()
 	os.Exit(status)
 }
@@ -4,11 +4,14 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	awssession "github.com/aws/aws-sdk-go/aws/session"
 	"github.com/grailbio/base/file"
@@ -18,7 +21,9 @@ import (
 	"github.com/grailbio/bigslice/sliceconfig"
 	"github.com/grailbio/gql/cmd"
 	"github.com/grailbio/gql/gql"
-	"github.com/grailbio/gql/lib"
+	_ "github.com/grailbio/gql/lib" // Registers the standard library.
+	"github.com/grailbio/gql/pipeline"
+	"github.com/grailbio/gql/web"
 	"github.com/yasushi-saito/readline"
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -28,8 +33,41 @@ var (
 	overwriteFilesFlag = flag.Bool("overwrite-files", false, "If false, write() will become a noop if the target file already exists")
 	outputFlag         = flag.String("output", "", "File to write the final expression value to.")
 	cacheDirFlag       = flag.String("cache-dir", "", "The place to store btsv cache files.")
+	localCacheDirFlag  = flag.String("local-cache-dir", "", `If set, files read from -cache-dir are also copied to this local directory.
+Useful when -cache-dir is a shared S3 prefix.`)
+	cacheWritesFlag = flag.String("cache-writes", "always", `When to store new tables in -cache-dir. One of "always", "batch", or "never".
+If "batch", interactive sessions only read from -cache-dir, and store new tables in -local-cache-dir.`)
+	scratchDirFlag = flag.String("scratch-dir", "", `If set, temporary files are stored in this directory (typically an S3 prefix)
+when the local disk that stores -cache-dir is running out of space.`)
+	watchFlag = flag.Bool("watch", false, `If set, rerun the script whenever one of the files it reads changes.
+The -output file is replaced atomically on each run.`)
+	watchIntervalFlag  = flag.Duration("watch-interval", 30*time.Second, "Interval for polling remote (e.g., S3) files in -watch mode.")
+	nanAsNullFlag      = flag.Bool("nan-as-null", false, "If set, NaNs computed by arithmetic operators and float() become NA.")
 	immutableFilesFlag = flag.String("immutable-files", "", `Comma-separated list of regexps of files assumeb to be immutable.
 If empty, "^s3://grail-clinical.*" and "^s3://grail-results.*" are used.`)
+	renderFlag            = flag.String("render", "text", `How values are printed. One of "text", "json", or "html".`)
+	webFlag               = flag.String("web", "", `If set, serve a browser-based console at this address, e.g., ":8080". An address without a host binds to localhost only; use e.g. "0.0.0.0:8080", behind an authenticating proxy, to serve other machines.`)
+	slackWebhookFlag      = flag.String("slack-webhook", "", `If set, notify(channel:="slack") posts messages to this Slack incoming webhook URL.`)
+	webHistoryDirFlag     = flag.String("web-history-dir", "", "Directory to store per-user query history in -web mode. If empty, ~/.gql/web-history is used.")
+	webTrustedProxyFlag   = flag.String("web-trusted-proxy", "", `Comma-separated CIDRs of authenticating proxies in -web mode, e.g., "10.0.0.0/8". X-Forwarded-User is honored only for requests from them.`)
+	webCookieKeyFileFlag  = flag.String("web-cookie-key-file", "", "File storing the key that signs user cookies in -web mode. It is created if missing. If empty, ~/.gql/web-cookie-key is used.")
+	s3RetriesFlag         = flag.Int("s3-retries", gql.DefaultS3MaxRetries, "Max number of times a failed read of a table file is retried. If negative, reads are not retried.")
+	s3RetryBackoffFlag    = flag.Duration("s3-retry-backoff", gql.DefaultS3RetryBackoff, "Initial wait before retrying a failed read. It grows exponentially up to a minute.")
+	s3ReadAheadFlag       = flag.Int("s3-read-ahead", 0, "If positive, S3 files are read in chunks of this many bytes ahead of the consumer.")
+	s3ReadConcurrencyFlag = flag.Int("s3-read-concurrency", 1, "Max number of -s3-read-ahead chunks of a file fetched in parallel.")
+	maxExprDepthFlag      = flag.Int("max-expr-depth", gql.DefaultMaxExprDepth, "Max nesting depth of an expression. A pipeline of N stages counts as N levels.")
+	strictTSVFlag         = flag.Bool("strict-tsv", false, "If set, reading a TSV cell that can't be converted to the column type without loss, e.g., 1.5 in an int column, fails instead of truncating the value.")
+	verboseErrorsFlag     = flag.Bool("verbose-errors", false, "If set, errors for unknown variables and columns show all the bindings or the row contents, in addition to the \"did you mean\" suggestions.")
+	maxCellWidthFlag      = flag.Int("max-cell-width", 64, `Max width of a table cell printed in the terminal. Wider cells are truncated with "…". If <= 0, cells are not truncated.`)
+	denyDeprecatedFlag    = flag.Bool("deny-deprecated", false, "If set, a script that uses deprecated syntax, such as $col, fails instead of printing warnings.")
+	fixFlag               = flag.Bool("fix", false, "If set, rewrite deprecated syntax in the script files given in the commandline in place, then exit.")
+	gqlPathFlag           = flag.String("gql-path", os.Getenv("GQLPATH"), `Comma-separated list of directories searched for scripts named in "load" statements. They may be S3 prefixes. Defaults to $GQLPATH.`)
+	maxRowsFlag           = flag.Int64("max-rows", 0, "If positive, an evaluation fails once it has scanned more than this many rows.")
+	maxBytesFlag          = flag.Int64("max-bytes", 0, "If positive, an evaluation fails once it has read more than this many bytes from table files.")
+	maxEvalTimeFlag       = flag.Duration("max-eval-time", 0, "If positive, an evaluation fails once it has run longer than this duration.")
+	shadowingFlag         = flag.String("shadowing", "allow", `How to report a variable in a block that shadows another variable of the same name. One of "allow", "warn", or "error".`)
+	shareSubexprsFlag     = flag.Bool("share-table-subexprs", false, "If set, a table-valued subexpression that appears more than once in a statement is evaluated once, and it is materialized in the cache when it is read the first time.")
+	naOrderFlag           = flag.String("na-order", "default", `Where sort(), minn(), min(), max(), and joins place NA values. One of "default" (NA is the largest value, -NA the smallest), "first", or "last". sort() and minn() can override it with na:=.`)
 )
 
 func setGlobalVarFromFlags(arg string) {
@@ -63,6 +101,10 @@ func printValue(ctx context.Context, env *cmd.Env, val gql.Value) {
 		must.Truef(val.Type() == gql.TableType,
 			"--output value must be a table (it is %v)", val)
 		fh := gql.GetFileHandlerByPath(*outputFlag)
+		if *watchFlag {
+			writeOutputAtomically(ctx, fh, val.Table(nil))
+			return
+		}
 		fh.Write(ctx, *outputFlag, &gql.ASTUnknown{}, val.Table(nil), 1, *overwriteFilesFlag)
 	} else if val.Type() != gql.InvalidType {
 		out := env.NewOutput()
@@ -71,6 +113,108 @@ func printValue(ctx context.Context, env *cmd.Env, val gql.Value) {
 	}
 }
 
+// writeOutputAtomically writes the table to -output such that readers never
+// see a partially written file. A local output is first written to a temp path
+// in the same directory, then renamed. Files on S3 are replaced atomically by
+// the upload itself.
+func writeOutputAtomically(ctx context.Context, fh gql.FileHandler, table gql.Table) {
+	path := *outputFlag
+	if strings.Contains(path, "://") {
+		fh.Write(ctx, path, &gql.ASTUnknown{}, table, 1, true)
+		return
+	}
+	dir, base := filepath.Split(path)
+	tmpPath := filepath.Join(dir, fmt.Sprintf(".%s.tmp%d%s", base, os.Getpid(), filepath.Ext(base)))
+	must.Nil(os.RemoveAll(tmpPath))
+	fh.Write(ctx, tmpPath, &gql.ASTUnknown{}, table, 1, true)
+	// A btsv table is a directory, and rename(2) can't replace a nonempty
+	// directory. Move the old one aside first.
+	oldPath := ""
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		oldPath = filepath.Join(dir, fmt.Sprintf(".%s.old%d", base, os.Getpid()))
+		must.Nil(os.Rename(path, oldPath))
+	}
+	must.Nil(os.Rename(tmpPath, path))
+	if oldPath != "" {
+		must.Nil(os.RemoveAll(oldPath))
+	}
+}
+
+// fixScript rewrites deprecated syntax in the given script file in place.
+func fixScript(path string) {
+	info, err := os.Stat(path)
+	must.Nil(err)
+	text, err := ioutil.ReadFile(path)
+	must.Nil(err)
+	newText, deps, err := gql.FixDeprecatedSyntax(path, text)
+	must.Nilf(err, "fix %s", path)
+	if len(deps) == 0 {
+		return
+	}
+	for _, d := range deps {
+		fmt.Printf("%v: fixed: %s\n", d.Pos, d.Message)
+	}
+	must.Nil(ioutil.WriteFile(path, newText, info.Mode()))
+}
+
+// newSession creates a session. The standard library is loaded on demand.
+func newSession(ctx context.Context, interactive bool) (*gql.Session, *cmd.Env) {
+	sess := gql.NewSession()
+	renderer, err := cmd.NewRenderer(*renderFlag)
+	must.Nilf(err, "-render")
+	env := cmd.NewWithOpts(sess, cmd.Opts{Interactive: interactive, Renderer: renderer, WatchPollInterval: *watchIntervalFlag})
+	return sess, env
+}
+
+// runPipeline implements "grail-query run -dag pipeline.yaml". It runs the steps
+// in the pipeline and prints the run report. "run" is treated as a script if a
+// file with that name exists in the current directory.
+func runPipeline(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	dagFlag := flags.String("dag", "", "YAML file that lists the scripts to run.")
+	stateFlag := flags.String("state", "", "File that records the state of the last run. If empty, <dag>.state is used.")
+	forceFlag := flags.Bool("force", false, "If set, run all the steps, even if they are unchanged since the last run.")
+	must.Nil(flags.Parse(args))
+	must.True(*dagFlag != "", "run: -dag must be set")
+	report, err := pipeline.Run(ctx, *dagFlag, pipeline.Opts{
+		NewSession: func(ctx context.Context) *gql.Session {
+			sess, _ := newSession(ctx, false)
+			return sess
+		},
+		StatePath: *stateFlag,
+		Force:     *forceFlag,
+	})
+	if report != nil {
+		_, env := newSession(ctx, false)
+		printValue(ctx, env, gql.NewTable(report))
+	}
+	if err != nil {
+		log.Fatalf("run %s: %v", *dagFlag, err)
+	}
+}
+
+// watchScript runs the script repeatedly, each time one of the files read by
+// the previous run changes. It never returns.
+func watchScript(ctx context.Context, scriptPath string) {
+	for {
+		stop := gql.RecordInputFiles()
+		err := gql.Recover(func() {
+			sess, env := newSession(ctx, false)
+			printValue(ctx, env, sess.EvalFile(ctx, scriptPath))
+		})
+		inputs := stop()
+		if err != nil {
+			log.Error.Printf("watch: %s: %v", scriptPath, err)
+		}
+		log.Printf("watch: waiting for changes in %d files: %v", len(inputs), inputs.Paths())
+		path, err := gql.WaitForInputChange(ctx, inputs, *watchIntervalFlag)
+		if err != nil {
+			log.Fatalf("watch: %v", err)
+		}
+		log.Printf("watch: %s changed; rerunning %s", path, scriptPath)
+	}
+}
+
 func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
 	file.RegisterImplementation("s3", func() file.Implementation {
@@ -84,9 +228,53 @@ func main() {
 	ctx := context.Background()
 	opts := gql.Opts{
 		BackgroundContext: ctx,
-		OverwriteFiles:    *overwriteFilesFlag,
+		OverwriteFiles:    *overwriteFilesFlag || *watchFlag,
 		CacheDir:          *cacheDirFlag,
+		LocalCacheDir:     *localCacheDirFlag,
+		RemoteScratchDir:  *scratchDirFlag,
+		NaNAsNull:         *nanAsNullFlag,
 		BigsliceSession:   session,
+		S3MaxRetries:      *s3RetriesFlag,
+		S3RetryBackoff:    *s3RetryBackoffFlag,
+		S3ReadAhead:       *s3ReadAheadFlag,
+		S3ReadConcurrency: *s3ReadConcurrencyFlag,
+		MaxExprDepth:      *maxExprDepthFlag,
+		MaxCellWidth:      *maxCellWidthFlag,
+		VerboseErrors:     *verboseErrorsFlag,
+		StrictTSV:         *strictTSVFlag,
+		DenyDeprecated:    *denyDeprecatedFlag,
+		Limits: gql.Limits{
+			MaxRows:     *maxRowsFlag,
+			MaxBytes:    *maxBytesFlag,
+			MaxDuration: *maxEvalTimeFlag,
+		},
+	}
+	shadowing, err := gql.ParseShadowingCheck(*shadowingFlag)
+	if err != nil {
+		log.Fatalf("-shadowing: %v", err)
+	}
+	opts.Shadowing = shadowing
+	naOrder, err := gql.ParseNAOrder(*naOrderFlag)
+	if err != nil {
+		log.Fatalf("-na-order: %v", err)
+	}
+	opts.NAOrder = naOrder
+	opts.ShareTableSubexprs = *shareSubexprsFlag
+	if *slackWebhookFlag != "" {
+		opts.Notifiers = map[string]gql.Notifier{"slack": &gql.SlackNotifier{WebhookURL: *slackWebhookFlag}}
+	}
+	interactive := terminal.IsTerminal(syscall.Stdin) && terminal.IsTerminal(syscall.Stdout) && len(flag.Args()) == 0
+	switch *cacheWritesFlag {
+	case "always":
+	case "batch":
+		opts.ReadOnlyCache = interactive
+	case "never":
+		opts.ReadOnlyCache = true
+	default:
+		log.Fatalf("-cache-writes=%s: must be one of always, batch, or never", *cacheWritesFlag)
+	}
+	if *gqlPathFlag != "" {
+		opts.LoadPath = strings.Split(*gqlPathFlag, ",")
 	}
 	if *immutableFilesFlag != "" {
 		for _, re := range strings.Split(*immutableFilesFlag, ",") {
@@ -94,12 +282,62 @@ func main() {
 		}
 	}
 	gql.Init(opts)
-	sess := gql.NewSession()
-	interactive := terminal.IsTerminal(syscall.Stdin) && terminal.IsTerminal(syscall.Stdout) && len(flag.Args()) == 0
-	env := cmd.New(sess, interactive)
-	lib, err := sess.Parse("lib", []byte(lib.Script))
-	must.Nilf(err, "load lib")
-	sess.EvalStatements(ctx, lib)
+	defer gql.CleanupTempFiles(ctx)
+	if *fixFlag {
+		must.True(len(flag.Args()) > 0, "No script specified with -fix")
+		for _, path := range flag.Args() {
+			fixScript(path)
+		}
+		return
+	}
+	if flag.Arg(0) == "run" {
+		if _, err := os.Stat("run"); os.IsNotExist(err) {
+			runPipeline(ctx, flag.Args()[1:])
+			return
+		}
+	}
+	if *webFlag != "" {
+		historyDir := *webHistoryDirFlag
+		if historyDir == "" {
+			home, err := os.UserHomeDir()
+			must.Nilf(err, "-web-history-dir")
+			historyDir = filepath.Join(home, ".gql", "web-history")
+		}
+		keyFile := *webCookieKeyFileFlag
+		if keyFile == "" {
+			home, err := os.UserHomeDir()
+			must.Nilf(err, "-web-cookie-key-file")
+			keyFile = filepath.Join(home, ".gql", "web-cookie-key")
+		}
+		cookieKey, err := web.ReadOrCreateCookieKey(keyFile)
+		must.Nilf(err, "-web-cookie-key-file")
+		trustedProxies, err := web.ParseTrustedProxies(*webTrustedProxyFlag)
+		must.Nilf(err, "-web-trusted-proxy")
+		// Leave time to write the response of a query that runs up to
+		// -max-eval-time.
+		var webRequestTimeout time.Duration
+		if *maxEvalTimeFlag > 0 {
+			webRequestTimeout = *maxEvalTimeFlag + time.Minute
+		}
+		log.Fatal(web.ListenAndServe(ctx, *webFlag, web.Opts{
+			NewSession: func(ctx context.Context) *gql.Session {
+				sess, _ := newSession(ctx, false)
+				return sess
+			},
+			HistoryDir:     historyDir,
+			TrustedProxies: trustedProxies,
+			CookieKey:      cookieKey,
+			RequestTimeout: webRequestTimeout,
+		}))
+	}
+	if *watchFlag {
+		must.True(len(flag.Args()) > 0, "No script specified with -watch")
+		for _, arg := range flag.Args()[1:] {
+			setGlobalVarFromFlags(arg)
+		}
+		watchScript(ctx, flag.Arg(0))
+	}
+	sess, env := newSession(ctx, interactive)
 	if *evalFlag {
 		must.True(len(flag.Args()) > 0, "No expression specified with -eval")
 		statements, err := sess.Parse("(cmdline)", []byte(strings.Join(flag.Args(), " ")))
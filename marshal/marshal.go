@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/gob"
+	"io"
 	"sync"
 
 	"github.com/grailbio/base/log"
@@ -177,6 +178,28 @@ func (e *Encoder) PutSymbol(id string) {
 // Bytes returns the encoded data.
 func (e *Encoder) Bytes() []byte { return e.buf }
 
+// Mark is a position in an Encoder. It is created by Encoder.Mark.
+type Mark struct {
+	len   int
+	nSyms int
+}
+
+// Len returns the size of the encoded data when the mark was taken.
+func (m Mark) Len() int { return m.len }
+
+// Mark returns the current position of the encoder.
+func (e *Encoder) Mark() Mark { return Mark{len: len(e.buf), nSyms: len(e.syms)} }
+
+// Rewind discards the data and the symbols added after the mark was taken.
+func (e *Encoder) Rewind(m Mark) {
+	e.buf = e.buf[:m.len]
+	for k, v := range e.syms {
+		if v > int64(m.nSyms) {
+			delete(e.syms, k)
+		}
+	}
+}
+
 // Decoder is used to decode GQL values.
 type Decoder struct {
 	buf  *bytes.Reader
@@ -259,6 +282,9 @@ func (d *Decoder) Bytes() []byte {
 	if n == 0 {
 		return nil
 	}
+	if n < 0 || n > int64(d.buf.Len()) {
+		log.Panicf("unmarshalBytes: invalid length %d, %d bytes remain", n, d.buf.Len())
+	}
 	data := make([]byte, n)
 	nn, err := d.buf.Read(data)
 	if int64(nn) != n || err != nil {
@@ -267,6 +293,28 @@ func (d *Decoder) Bytes() []byte {
 	return data
 }
 
+// BytesTo reads data encoded by Encoder.PutBytes into buf, growing it as
+// needed, and returns the filled slice. Unlike Bytes, it does not allocate if
+// cap(buf) is large enough.
+func (d *Decoder) BytesTo(buf []byte) []byte {
+	n := int(d.Varint())
+	if n < 0 || n > d.buf.Len() {
+		log.Panicf("unmarshalBytes: invalid length %d, %d bytes remain", n, d.buf.Len())
+	}
+	if cap(buf) < n {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if n == 0 {
+		return buf
+	}
+	nn, err := d.buf.Read(buf)
+	if nn != n || err != nil {
+		log.Panicf("unmarshalBytes: %d %d %v", n, nn, err)
+	}
+	return buf
+}
+
 // Bytes reads data encoded by Encoder.PutString.
 func (d *Decoder) String() string {
 	data := d.Bytes()
@@ -290,6 +338,17 @@ func (d *Decoder) Symbol() string {
 	return id
 }
 
+// Skip discards the next n bytes. It crashes the process if the decoder stores
+// less than n bytes.
+func (d *Decoder) Skip(n int) {
+	if n > d.buf.Len() {
+		log.Panicf("skip: %d bytes requested, %d bytes remain", n, d.buf.Len())
+	}
+	if _, err := d.buf.Seek(int64(n), io.SeekCurrent); err != nil {
+		log.Panicf("skip: %v", err)
+	}
+}
+
 // Len returns the number of bytes that remains to be read.
 func (d *Decoder) Len() int { return d.buf.Len() }
 
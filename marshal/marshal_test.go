@@ -54,6 +54,33 @@ func TestBasic(t *testing.T) {
 	require.Equal(t, 0, d.Len())
 }
 
+func TestBytesTo(t *testing.T) {
+	m := marshal.NewEncoder(nil)
+	m.PutString("Hello")
+	m.PutString("")
+	m.PutString("a longer string")
+
+	d := marshal.NewDecoder(m.Bytes())
+	buf := make([]byte, 0, 8)
+	buf = d.BytesTo(buf)
+	require.Equal(t, "Hello", string(buf))
+	buf = d.BytesTo(buf)
+	require.Equal(t, "", string(buf))
+	buf = d.BytesTo(buf)
+	require.Equal(t, "a longer string", string(buf))
+	require.Equal(t, 0, d.Len())
+}
+
+func TestBytesCorruptLength(t *testing.T) {
+	for _, n := range []int64{-1, 100, 1 << 62} {
+		m := marshal.NewEncoder(nil)
+		m.PutVarint(n)
+		m.PutRawBytes([]byte("abc"))
+		require.Panics(t, func() { marshal.NewDecoder(m.Bytes()).Bytes() })
+		require.Panics(t, func() { marshal.NewDecoder(m.Bytes()).BytesTo(nil) })
+	}
+}
+
 // Test buffer resizing.
 func doRandomTest(t *testing.T, seed int64) {
 	m := marshal.NewEncoder(nil)
@@ -86,3 +113,22 @@ func TestRandom(t *testing.T) {
 		doRandomTest(t, int64(i))
 	}
 }
+
+func TestRewind(t *testing.T) {
+	m := marshal.NewEncoder(nil)
+	m.PutSymbol("a")
+	mark := m.Mark()
+	require.Equal(t, m.Len(), mark.Len())
+	m.PutSymbol("b")
+	m.PutString("discarded")
+	m.Rewind(mark)
+	require.Equal(t, mark.Len(), m.Len())
+	m.PutSymbol("c")
+	m.PutSymbol("a")
+
+	d := marshal.NewDecoder(m.Bytes())
+	require.Equal(t, "a", d.Symbol())
+	require.Equal(t, "c", d.Symbol())
+	require.Equal(t, "a", d.Symbol())
+	require.Equal(t, 0, d.Len())
+}
@@ -0,0 +1,408 @@
+// Package pipeline runs a set of GQL scripts in dependency order. The
+// pipeline is described in a YAML file:
+//
+//	steps:
+//	  - name: filter
+//	    script: filter.gql
+//	    params: {min_count: 10}
+//	    output: /tmp/filtered.btsv
+//	  - name: summarize
+//	    script: summarize.gql
+//	    deps: [filter]
+//	    output: /tmp/summary.tsv
+//
+// Each step runs the script in a fresh session, with the params bound to
+// global variables. If the output is set, the value of the last expression of
+// the script, which must be a table, is written there. The script pathname is
+// relative to the directory of the YAML file.
+//
+// The runner records the files read by each step, and the versions of the
+// inputs and outputs, in a state file. A step is skipped if its script,
+// params, input files, and output file are unchanged since its last successful
+// run, and none of its dependencies has been rerun.
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grailbio/base/file"
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/gql"
+	"github.com/grailbio/gql/hash"
+	"github.com/grailbio/gql/symbol"
+	"gopkg.in/yaml.v2"
+)
+
+// Step is one entry in a pipeline.
+type Step struct {
+	// Name identifies the step. Required.
+	Name string `yaml:"name"`
+	// Script is the pathname of the GQL script. Required.
+	Script string `yaml:"script"`
+	// Params are bound to global variables before the script runs.
+	Params map[string]interface{} `yaml:"params"`
+	// Deps lists the names of the steps that must run before this step.
+	Deps []string `yaml:"deps"`
+	// Output is the file that the script value is written to. Optional.
+	Output string `yaml:"output"`
+}
+
+// Spec is the contents of a pipeline file.
+type Spec struct {
+	Steps []Step `yaml:"steps"`
+}
+
+// Opts configures Run.
+type Opts struct {
+	// NewSession creates the session for running a step. It typically loads
+	// the standard library. Required.
+	NewSession func(ctx context.Context) *gql.Session
+	// StatePath is the file that records the state of the last run. If empty,
+	// "<pipeline path>.state" is used.
+	StatePath string
+	// Force causes all the steps to run, even if they are unchanged.
+	Force bool
+}
+
+// Step statuses reported in the run report.
+const (
+	StatusRan     = "ran"
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+	StatusNotRun  = "not_run"
+)
+
+// stepState records the last successful run of a step.
+type stepState struct {
+	// Fingerprint is the hash of the script contents, the params, and the output
+	// path.
+	Fingerprint string `json:"fingerprint"`
+	// Inputs is the list of files read by the step.
+	Inputs []string `json:"inputs"`
+	// InputsHash is the hash of the versions of the inputs.
+	InputsHash string `json:"inputs_hash"`
+	// OutputHash is the hash of the version of the output.
+	OutputHash string `json:"output_hash,omitempty"`
+}
+
+var paramNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Load reads and validates a pipeline file. It returns the steps in the order
+// they should run.
+func Load(ctx context.Context, path string) ([]Step, error) {
+	data, err := file.ReadFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	var spec Spec
+	if err := yaml.UnmarshalStrict(data, &spec); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	dir := filepath.Dir(path)
+	for i := range spec.Steps {
+		step := &spec.Steps[i]
+		if step.Name == "" || step.Script == "" {
+			return nil, fmt.Errorf("%s: step #%d: name and script must be set", path, i)
+		}
+		if !filepath.IsAbs(step.Script) && !strings.Contains(step.Script, "://") {
+			step.Script = filepath.Join(dir, step.Script)
+		}
+		for name := range step.Params {
+			if !paramNameRE.MatchString(name) {
+				return nil, fmt.Errorf("%s: step %s: invalid param name '%s'", path, step.Name, name)
+			}
+		}
+	}
+	steps, err := sortSteps(spec.Steps)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return steps, nil
+}
+
+// sortSteps orders the steps so that each step comes after its dependencies.
+// Steps that don't depend on each other stay in the original order.
+func sortSteps(steps []Step) ([]Step, error) {
+	index := map[string]int{}
+	for i, step := range steps {
+		if _, ok := index[step.Name]; ok {
+			return nil, fmt.Errorf("duplicate step name '%s'", step.Name)
+		}
+		index[step.Name] = i
+	}
+	nDeps := make([]int, len(steps))
+	dependents := make([][]int, len(steps))
+	for i, step := range steps {
+		for _, dep := range step.Deps {
+			di, ok := index[dep]
+			if !ok {
+				return nil, fmt.Errorf("step %s: unknown dependency '%s'", step.Name, dep)
+			}
+			nDeps[i]++
+			dependents[di] = append(dependents[di], i)
+		}
+	}
+	var (
+		ready  []int
+		sorted []Step
+	)
+	for i := range steps {
+		if nDeps[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	for len(ready) > 0 {
+		sort.Ints(ready)
+		i := ready[0]
+		ready = ready[1:]
+		sorted = append(sorted, steps[i])
+		for _, di := range dependents[i] {
+			if nDeps[di]--; nDeps[di] == 0 {
+				ready = append(ready, di)
+			}
+		}
+	}
+	if len(sorted) != len(steps) {
+		var cycle []string
+		for i, n := range nDeps {
+			if n > 0 {
+				cycle = append(cycle, steps[i].Name)
+			}
+		}
+		return nil, fmt.Errorf("dependency cycle among steps %v", cycle)
+	}
+	return sorted, nil
+}
+
+// paramValue converts a YAML value to a GQL value.
+func paramValue(v interface{}) (gql.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return gql.Null, nil
+	case bool:
+		return gql.NewBool(v), nil
+	case int:
+		return gql.NewInt(int64(v)), nil
+	case int64:
+		return gql.NewInt(v), nil
+	case float64:
+		return gql.NewFloat(v), nil
+	case string:
+		return gql.NewString(v), nil
+	}
+	return gql.Value{}, fmt.Errorf("unsupported param value %v (%T)", v, v)
+}
+
+// fingerprint computes the hash of the step definition.
+func fingerprint(ctx context.Context, step Step) (hash.Hash, error) {
+	script, err := file.ReadFile(ctx, step.Script)
+	if err != nil {
+		return hash.Zero, err
+	}
+	h := hash.Bytes(script).Merge(hash.String(step.Output))
+	names := make([]string, 0, len(step.Params))
+	for name := range step.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h = h.Merge(hash.String(name)).Merge(hash.String(fmt.Sprintf("%T:%v", step.Params[name], step.Params[name])))
+	}
+	return h, nil
+}
+
+func readState(path string) map[string]stepState {
+	state := map[string]stepState{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error.Printf("pipeline: read state %s: %v", path, err)
+		}
+		return state
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Error.Printf("pipeline: read state %s: %v", path, err)
+	}
+	return state
+}
+
+func writeState(path string, state map[string]stepState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// upToDate checks if the step can be skipped. If not, it returns the reason.
+func upToDate(ctx context.Context, step Step, fp hash.Hash, prev stepState, found bool) (bool, string) {
+	switch {
+	case !found:
+		return false, "never run"
+	case prev.Fingerprint != fp.String():
+		return false, "script, params, or output changed"
+	case gql.StatInputFiles(ctx, prev.Inputs).Hash().String() != prev.InputsHash:
+		return false, "inputs changed"
+	case step.Output != "" && gql.StatInputFiles(ctx, []string{step.Output}).Hash().String() != prev.OutputHash:
+		return false, "output changed"
+	}
+	return true, "unchanged"
+}
+
+// runStep runs the script and writes its output. It returns the files read by
+// the script.
+func runStep(ctx context.Context, step Step, opts Opts) (inputs gql.InputFiles, err error) {
+	stop := gql.RecordInputFiles()
+	defer func() { inputs = stop() }()
+	err = gql.Recover(func() {
+		sess := opts.NewSession(ctx)
+		vars := map[string]gql.Value{}
+		for name, v := range step.Params {
+			val, err := paramValue(v)
+			if err != nil {
+				log.Panicf("step %s: param %s: %v", step.Name, name, err)
+			}
+			vars[name] = val
+		}
+		sess.SetGlobals(vars)
+		val := sess.EvalFile(ctx, step.Script)
+		if step.Output == "" {
+			return
+		}
+		if val.Type() != gql.TableType {
+			log.Panicf("step %s: script must produce a table to write to %s, but found %v", step.Name, step.Output, val.Type())
+		}
+		fh := gql.GetFileHandlerByPath(step.Output)
+		if fh == nil {
+			log.Panicf("step %s: %s: unknown file type", step.Name, step.Output)
+		}
+		fh.Write(ctx, step.Output, &gql.ASTUnknown{}, val.Table(nil), 1, true)
+	})
+	return
+}
+
+// firstLine returns the first line of s. It is used to omit the stack trace
+// from an error message.
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// reportRow creates a row of the run report.
+func reportRow(step Step, status, reason string, duration time.Duration) gql.Value {
+	return gql.NewStruct(gql.NewSimpleStruct(
+		gql.StructField{Name: symbol.Intern("step"), Value: gql.NewString(step.Name)},
+		gql.StructField{Name: symbol.Intern("status"), Value: gql.NewString(status)},
+		gql.StructField{Name: symbol.Intern("reason"), Value: gql.NewString(reason)},
+		gql.StructField{Name: symbol.Intern("duration"), Value: gql.NewDuration(duration)},
+		gql.StructField{Name: symbol.Intern("output"), Value: gql.NewString(step.Output)}))
+}
+
+// Run runs the steps in the pipeline file. It returns a report table with one
+// row per step. Each row has columns step, status (one of "ran", "skipped",
+// "failed", and "not_run"), reason, duration, and output. A step is not run if
+// one of its dependencies failed. Run returns an error if the pipeline file is
+// invalid, or if a step failed.
+func Run(ctx context.Context, path string, opts Opts) (gql.Table, error) {
+	steps, err := Load(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if opts.StatePath == "" {
+		opts.StatePath = path + ".state"
+	}
+	var (
+		state    = readState(opts.StatePath)
+		statuses = map[string]string{}
+		rows     []gql.Value
+		firstErr error
+	)
+	for _, step := range steps {
+		status, reason := "", ""
+		depRan := false
+		for _, dep := range step.Deps {
+			switch statuses[dep] {
+			case StatusFailed, StatusNotRun:
+				status, reason = StatusNotRun, "dependency "+dep+" did not succeed"
+			case StatusRan:
+				depRan = true
+			}
+		}
+		if status != "" {
+			statuses[step.Name] = status
+			rows = append(rows, reportRow(step, status, reason, 0))
+			continue
+		}
+		fp, err := fingerprint(ctx, step)
+		if err != nil {
+			statuses[step.Name] = StatusFailed
+			rows = append(rows, reportRow(step, StatusFailed, err.Error(), 0))
+			if firstErr == nil {
+				firstErr = fmt.Errorf("step %s: %v", step.Name, err)
+			}
+			continue
+		}
+		prev, found := state[step.Name]
+		ok, reason := upToDate(ctx, step, fp, prev, found)
+		switch {
+		case opts.Force:
+			reason = "forced"
+		case depRan:
+			reason = "dependency reran"
+		case ok:
+			log.Printf("pipeline: step %s: skipped (%s)", step.Name, reason)
+			statuses[step.Name] = StatusSkipped
+			rows = append(rows, reportRow(step, StatusSkipped, reason, 0))
+			continue
+		}
+		log.Printf("pipeline: step %s: running %s (%s)", step.Name, step.Script, reason)
+		start := time.Now()
+		inputs, err := runStep(ctx, step, opts)
+		duration := time.Since(start)
+		if err != nil {
+			log.Error.Printf("pipeline: step %s: %v", step.Name, err)
+			delete(state, step.Name)
+			statuses[step.Name] = StatusFailed
+			rows = append(rows, reportRow(step, StatusFailed, firstLine(err.Error()), duration))
+			if firstErr == nil {
+				firstErr = fmt.Errorf("step %s: %v", step.Name, err)
+			}
+			continue
+		}
+		// The output may have been read by the step itself, e.g., to check if it
+		// exists. It is not an input.
+		delete(inputs, step.Output)
+		newState := stepState{
+			Fingerprint: fp.String(),
+			Inputs:      inputs.Paths(),
+			InputsHash:  inputs.Hash().String(),
+		}
+		if step.Output != "" {
+			newState.OutputHash = gql.StatInputFiles(ctx, []string{step.Output}).Hash().String()
+		}
+		state[step.Name] = newState
+		statuses[step.Name] = StatusRan
+		rows = append(rows, reportRow(step, StatusRan, reason, duration))
+	}
+	if err := writeState(opts.StatePath, state); err != nil {
+		return nil, fmt.Errorf("pipeline: write state %s: %v", opts.StatePath, err)
+	}
+	h := hash.String(path).Merge(hash.Time(time.Now()))
+	report := gql.NewSimpleTable(rows, h, gql.TableAttrs{Name: "pipeline", Path: path})
+	return report, firstErr
+}
@@ -0,0 +1,108 @@
+package pipeline
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/grailbio/gql/gql"
+	"github.com/grailbio/gql/gqltest"
+	"github.com/grailbio/testutil"
+	"github.com/grailbio/testutil/expect"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	expect.NoError(t, ioutil.WriteFile(path, []byte(data), 0644))
+}
+
+// reportRows reads the rows of a run report.
+func reportRows(report gql.Table) []string {
+	return gqltest.ReadTable(gql.NewTable(report))
+}
+
+func TestSortSteps(t *testing.T) {
+	steps, err := sortSteps([]Step{
+		{Name: "c", Deps: []string{"b"}},
+		{Name: "a"},
+		{Name: "b", Deps: []string{"a"}},
+		{Name: "d"},
+	})
+	expect.NoError(t, err)
+	var names []string
+	for _, s := range steps {
+		names = append(names, s.Name)
+	}
+	expect.EQ(t, names, []string{"a", "d", "b", "c"})
+
+	_, err = sortSteps([]Step{{Name: "a", Deps: []string{"b"}}, {Name: "b", Deps: []string{"a"}}})
+	expect.HasSubstr(t, err.Error(), "cycle")
+	_, err = sortSteps([]Step{{Name: "a", Deps: []string{"x"}}})
+	expect.HasSubstr(t, err.Error(), "unknown dependency")
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	gqltest.NewSession()
+
+	input := filepath.Join(tmpDir, "input.tsv")
+	out0 := filepath.Join(tmpDir, "out0.tsv")
+	out1 := filepath.Join(tmpDir, "out1.tsv")
+	writeFile(t, input, "A\tB\n1\tx\n2\ty\n3\tz\n")
+	writeFile(t, filepath.Join(tmpDir, "filter.gql"), "read(`"+input+"`) | filter(&A >= min_a)")
+	writeFile(t, filepath.Join(tmpDir, "count.gql"), "table({n: read(`"+out0+"`) | count()})")
+	dag := filepath.Join(tmpDir, "pipeline.yaml")
+	writeDAG := func(minA string) {
+		writeFile(t, dag, `
+steps:
+  - name: count
+    script: count.gql
+    deps: [filter]
+    output: `+out1+`
+  - name: filter
+    script: filter.gql
+    params: {min_a: `+minA+`}
+    output: `+out0+`
+`)
+	}
+	opts := Opts{NewSession: func(ctx context.Context) *gql.Session { return gqltest.NewSession() }}
+
+	writeDAG("2")
+	report, err := Run(ctx, dag, opts)
+	expect.NoError(t, err)
+	rows := reportRows(report)
+	expect.EQ(t, len(rows), 2)
+	expect.HasSubstr(t, rows[0], "step:filter,status:ran")
+	expect.HasSubstr(t, rows[1], "step:count,status:ran")
+	data, err := ioutil.ReadFile(out1)
+	expect.NoError(t, err)
+	expect.EQ(t, string(data), "n\n2\n")
+
+	// Nothing changed.
+	report, err = Run(ctx, dag, opts)
+	expect.NoError(t, err)
+	rows = reportRows(report)
+	expect.HasSubstr(t, rows[0], "step:filter,status:skipped")
+	expect.HasSubstr(t, rows[1], "step:count,status:skipped")
+
+	// A param change reruns the step and its dependents.
+	writeDAG("3")
+	report, err = Run(ctx, dag, opts)
+	expect.NoError(t, err)
+	rows = reportRows(report)
+	expect.HasSubstr(t, rows[0], "step:filter,status:ran")
+	expect.HasSubstr(t, rows[1], "step:count,status:ran")
+	data, err = ioutil.ReadFile(out1)
+	expect.NoError(t, err)
+	expect.EQ(t, string(data), "n\n1\n")
+
+	// A failed step prevents its dependents from running.
+	writeFile(t, filepath.Join(tmpDir, "filter.gql"), "nosuchvar")
+	report, err = Run(ctx, dag, opts)
+	expect.HasSubstr(t, err.Error(), "step filter")
+	rows = reportRows(report)
+	expect.HasSubstr(t, rows[0], "step:filter,status:failed")
+	expect.HasSubstr(t, rows[1], "step:count,status:not_run")
+}
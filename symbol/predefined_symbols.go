@@ -12,30 +12,103 @@ const AnonValName = "_val"
 
 var (
 	// List of frequently used symbols.
-	Chrom          = Intern("chrom")
-	Date           = Intern("date")
-	Default        = Intern("default")
-	End            = Intern("end")
-	Feat           = Intern("feat")
-	Filter         = Intern("filter")
-	Key            = Intern("key")
-	Length         = Intern("length")
-	Map            = Intern("map")
-	Name           = Intern("name")
-	Path           = Intern("path")
-	Pos            = Intern("pos")
-	Row            = Intern("row")
-	Shards         = Intern("shards")
-	Start          = Intern("start")
-	Type           = Intern("type")
-	Value          = Intern("value")
-	Subshard       = Intern("subshard")
-	Depth          = Intern("depth")
-	Mode           = Intern("mode")
-	GZIP           = Intern("gzip")
+	Chrom            = Intern("chrom")
+	Date             = Intern("date")
+	Default          = Intern("default")
+	End              = Intern("end")
+	Feat             = Intern("feat")
+	Filter           = Intern("filter")
+	Key              = Intern("key")
+	Length           = Intern("length")
+	Map              = Intern("map")
+	Name             = Intern("name")
+	Path             = Intern("path")
+	Pos              = Intern("pos")
+	Row              = Intern("row")
+	Shards           = Intern("shards")
+	Start            = Intern("start")
+	Type             = Intern("type")
+	Value            = Intern("value")
+	Subshard         = Intern("subshard")
+	Depth            = Intern("depth")
+	Mode             = Intern("mode")
+	GZIP             = Intern("gzip")
+	Index            = Intern("index")
+	Version          = Intern("version")
+	VersionID        = Intern("version_id")
+	AsOf             = Intern("as_of")
+	How              = Intern("how")
+	Inclusive        = Intern("inclusive")
+	Escape           = Intern("escape")
+	Message          = Intern("message")
+	Channel          = Intern("channel")
+	Target           = Intern("target")
+	Attach           = Intern("attach")
+	Description      = Intern("description")
+	Columns          = Intern("columns")
+	Dict             = Intern("dict")
+	ColumnOrder      = Intern("column_order")
+	DictEncode       = Intern("dict_encode")
+	Format           = Intern("format")
+	Strict           = Intern("strict")
+	Align            = Intern("align")
+	NameName         = Intern("name_name")
+	ValueName        = Intern("value_name")
+	Sparse           = Intern("sparse")
+	Rows             = Intern("rows")
+	Cols             = Intern("cols")
+	Values           = Intern("values")
+	Center           = Intern("center")
+	Scale            = Intern("scale")
+	K                = Intern("k")
+	Seed             = Intern("seed")
+	Cut              = Intern("cut")
+	Linkage          = Intern("linkage")
+	Group            = Intern("group")
+	N                = Intern("n")
+	Stratify         = Intern("stratify")
+	FloatFormat      = Intern("float_format")
+	TrimFloatZero    = Intern("trim_float_zero")
+	Metadata         = Intern("metadata")
+	To               = Intern("to")
+	Symbols          = Intern("symbols")
+	Loaded           = Intern("loaded")
+	Fill             = Intern("fill")
+	MaxRows          = Intern("max_rows")
+	By               = Intern("by")
+	Genome           = Intern("genome")
+	Size             = Intern("size")
+	Col              = Intern("col")
+	Ensembl          = Intern("ensembl")
+	Style            = Intern("style")
+	Sample           = Intern("sample")
+	Feature          = Intern("feature")
+	Manifest         = Intern("manifest")
+	OnMissing        = Intern("on_missing")
+	Units            = Intern("units")
+	From             = Intern("from")
+	Thousands        = Intern("thousands")
+	Decimal          = Intern("decimal")
+	DuplicateColumns = Intern("duplicate_columns")
+	Suffixes         = Intern("suffixes")
+	NestedTables     = Intern("nested_tables")
+	Full             = Intern("full")
+	NA               = Intern("na")
+	Sep              = Intern("sep")
+	Count            = Intern("count")
+	Having           = Intern("having")
+	KeyColumns       = Intern("key_columns")
+	Combiner         = Intern("combiner")
+	Check            = Intern("check")
+	Ties             = Intern("ties")
+	Coercions        = Intern("coercions")
+	Percent          = Intern("percent")
+	Delimiter        = Intern("delimiter")
+	IfChanged        = Intern("if_changed")
+	Bgzip            = Intern("bgzip")
 
 	// Fragment table field names.
-	Reference                     = Intern("reference")
+	Reference = Intern("reference")
 
 	// BAM table field names.
 	MapQ  = Intern("mapq")
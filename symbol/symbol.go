@@ -36,6 +36,8 @@ type table struct {
 	// have the same ID<->name mappings, so they can be transmitted across
 	// bigslice machines efficiently.
 	preInterned ID
+	// preInternedHash is a digest of the names of the pre-interned symbols.
+	preInternedHash hash.Hash
 
 	// The readers can access the following fields using acquire loads.
 	// The writers must synchronize using the mutex.
@@ -66,10 +68,24 @@ func (t *table) ids() []idInfo {
 
 // MarkPreInternedSymbols must be called at the end of gql initialization.
 func MarkPreInternedSymbols() {
-	symbols.preInterned = ID(len(symbols.ids()))
+	ids := symbols.ids()
+	symbols.preInterned = ID(len(ids))
+	h := hash.String("preinterned")
+	for _, info := range ids {
+		h = h.Merge(info.hash)
+	}
+	symbols.preInternedHash = h
 	log.Debug.Printf("Pre-interned %d symbols", symbols.preInterned)
 }
 
+// PreInternedHash returns a digest of the names and IDs of the pre-interned
+// symbols. Two processes can exchange marshaled symbols only if their
+// PreInternedHash values match. It returns hash.Zero if
+// MarkPreInternedSymbols has not been called.
+func PreInternedHash() hash.Hash {
+	return symbols.preInternedHash
+}
+
 // Hash hashes a symbol.
 func (id ID) Hash() hash.Hash {
 	return symbols.ids()[id].hash
@@ -121,6 +137,9 @@ func (id *ID) Unmarshal(dec *marshal.Decoder) {
 	switch b {
 	case 0:
 		*id = ID(dec.Varint())
+		if *id < 0 || *id >= symbols.preInterned {
+			log.Panicf("unmarshal symbol.id: pre-interned symbol %d out of range [0,%d)", *id, symbols.preInterned)
+		}
 	case 1:
 		*id = Intern(dec.Symbol())
 	default:
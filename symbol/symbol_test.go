@@ -42,6 +42,17 @@ func TestMarshal(t *testing.T) {
 	assert.Equal(t, rid, id1)
 }
 
+func TestPreInternedHash(t *testing.T) {
+	symbol.MarkPreInternedSymbols()
+	h0 := symbol.PreInternedHash()
+	assert.NotEqual(t, hash.Zero, h0)
+	symbol.MarkPreInternedSymbols()
+	assert.Equal(t, h0, symbol.PreInternedHash())
+	symbol.Intern("preinternedhashtest")
+	symbol.MarkPreInternedSymbols()
+	assert.NotEqual(t, h0, symbol.PreInternedHash())
+}
+
 func BenchmarkHashInterned(b *testing.B) {
 	sym := symbol.Intern("abcdefghijk")
 	symbol.MarkPreInternedSymbols()
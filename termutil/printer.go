@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"html"
 	"io"
 	"math"
 	"os"
@@ -21,6 +22,7 @@ import (
 	gunsafe "github.com/grailbio/base/unsafe"
 	"github.com/grailbio/gql/columnsorter"
 	"github.com/grailbio/gql/symbol"
+	runewidth "github.com/mattn/go-runewidth"
 	"github.com/yasushi-saito/readline"
 	"golang.org/x/crypto/ssh/terminal"
 )
@@ -138,6 +140,11 @@ func WithCancel(bg context.Context) (context.Context, context.CancelFunc) {
 	}
 }
 
+// ScreenSize returns the (width, height) of the terminal attached to the
+// standard output, as # of characters. A few rows are reserved at the top of
+// the screen. It returns a default size if the output is not a terminal.
+func ScreenSize() (int, int) { return screenSize() }
+
 func screenSize() (int, int) {
 	nCol, nRow, err := terminal.GetSize(syscall.Stdout)
 	if err != nil {
@@ -155,6 +162,29 @@ type Column struct {
 	Value string
 }
 
+// cellEllipsis is appended to a cell truncated by TruncateCell.
+const cellEllipsis = "…"
+
+// TruncateCell truncates the string so that it occupies at most maxWidth
+// columns on a terminal. A truncated string ends with "…". If maxWidth <= 0,
+// it returns the string unchanged.
+func TruncateCell(s string, maxWidth int) string {
+	if maxWidth <= 0 || runewidth.StringWidth(s) <= maxWidth {
+		return s
+	}
+	return runewidth.Truncate(s, maxWidth, cellEllipsis)
+}
+
+// padLeft pads the string with spaces on the left so that it occupies width
+// columns on a terminal. Unlike fmt's "%*s", it counts East Asian wide
+// characters as two columns, and combining characters as zero.
+func padLeft(s string, width int) string {
+	if n := width - runewidth.StringWidth(s); n > 0 {
+		return strings.Repeat(" ", n) + s
+	}
+	return s
+}
+
 // Printer is an interface for paging long outputs for an interactive shell.  It
 // is a superset of io.Writer.
 type Printer interface {
@@ -412,7 +442,7 @@ func defaultWriteTable(p Printer, rowCallback func() ([]Column, error)) {
 		// Print column names
 		p.WriteString(fmt.Sprintf("║ %*s", col0Width, "#"))
 		for i := range colWidth {
-			p.WriteString(fmt.Sprintf("║ %*s", colWidth[i], colNames[i].Str()))
+			p.WriteString("║ " + padLeft(colNames[i].Str(), colWidth[i]))
 		}
 		if !hasMoreColumns {
 			p.WriteString("║")
@@ -443,7 +473,7 @@ func defaultWriteTable(p Printer, rowCallback func() ([]Column, error)) {
 		hasMoreColumns bool) {
 		p.WriteString(fmt.Sprintf("│ %*d", col0Width, rowIndex))
 		for i := range colWidth {
-			p.WriteString(fmt.Sprintf("│ %*s", colWidth[i], vals[i]))
+			p.WriteString("│ " + padLeft(vals[i], colWidth[i]))
 		}
 		if !hasMoreColumns {
 			p.WriteString("│")
@@ -467,13 +497,13 @@ func defaultWriteTable(p Printer, rowCallback func() ([]Column, error)) {
 
 		col0Width := int(math.Log10(float64(rowIndex+len(rows)))) + 1
 		for i := range colNames {
-			colWidth[i] = len(colNames[i].Str())
+			colWidth[i] = runewidth.StringWidth(colNames[i].Str())
 		}
 		for _, row := range rows {
 			for _, col := range row {
 				i := cols.Index(col.Name)
-				if len(col.Value) > colWidth[i] {
-					colWidth[i] = len(col.Value)
+				if w := runewidth.StringWidth(col.Value); w > colWidth[i] {
+					colWidth[i] = w
 				}
 			}
 		}
@@ -763,7 +793,7 @@ func (p *htmlPrinter) WriteTable(rowCallback func() ([]Column, error)) {
 
 	p.WriteString("<table><thead><tr>")
 	for _, col := range colNames {
-		p.WriteString("<th>" + col.Str() + "</th>\n")
+		p.WriteString("<th>" + html.EscapeString(col.Str()) + "</th>\n")
 	}
 	p.WriteString("</tr></thead>	\n")
 
@@ -778,7 +808,7 @@ func (p *htmlPrinter) WriteTable(rowCallback func() ([]Column, error)) {
 		}
 		p.WriteString("<tr>")
 		for _, val := range vals {
-			p.WriteString("<td>" + val + "</td>\n")
+			p.WriteString("<td>" + html.EscapeString(val) + "</td>\n")
 		}
 		p.WriteString("</tr>\n")
 	}
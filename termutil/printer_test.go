@@ -1,10 +1,12 @@
 package termutil_test
 
 import (
+	"io"
 	"testing"
 
-	"github.com/grailbio/testutil/expect"
+	"github.com/grailbio/gql/symbol"
 	"github.com/grailbio/gql/termutil"
+	"github.com/grailbio/testutil/expect"
 )
 
 func TestBufferPrinter(t *testing.T) {
@@ -15,3 +17,32 @@ func TestBufferPrinter(t *testing.T) {
 	p.WriteString("olleh")
 	expect.EQ(t, p.String(), "olleh")
 }
+
+func TestTruncateCell(t *testing.T) {
+	expect.EQ(t, termutil.TruncateCell("hello", 5), "hello")
+	expect.EQ(t, termutil.TruncateCell("hello world", 5), "hell…")
+	expect.EQ(t, termutil.TruncateCell("hello world", 0), "hello world")
+	// Each of these characters occupies two columns.
+	expect.EQ(t, termutil.TruncateCell("日本語の文", 6), "日本…")
+}
+
+func TestWriteTableWideChars(t *testing.T) {
+	p := termutil.NewBufferPrinter()
+	rows := [][]termutil.Column{
+		{{Name: symbol.Intern("a"), Value: "日本"}},
+		{{Name: symbol.Intern("a"), Value: "abcde"}},
+	}
+	p.WriteTable(func() ([]termutil.Column, error) {
+		if len(rows) == 0 {
+			return nil, io.EOF
+		}
+		row := rows[0]
+		rows = rows[1:]
+		return row, nil
+	})
+	expect.EQ(t, p.String(), `║ #║     a║
+├──┼──────┤
+│ 0│  日本│
+│ 1│ abcde│
+`)
+}
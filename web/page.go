@@ -0,0 +1,192 @@
+package web
+
+// indexHTML is the console page. It talks to the server through the /api/...
+// endpoints.
+const indexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>GQL console</title>
+<style>
+body { margin: 0; font-family: sans-serif; font-size: 14px; display: flex; height: 100vh; }
+#sidebar { width: 260px; overflow: auto; border-right: 1px solid #ccc; padding: 8px; background: #fafafa; }
+#sidebar h3 { margin: 8px 0 4px 0; font-size: 14px; }
+#sidebar .table { font-weight: bold; cursor: pointer; margin-top: 6px; }
+#sidebar .column { margin-left: 12px; color: #444; }
+#sidebar .history { cursor: pointer; font-family: monospace; white-space: nowrap; overflow: hidden; text-overflow: ellipsis; }
+#main { flex: 1; display: flex; flex-direction: column; padding: 8px; overflow: hidden; }
+#editor { position: relative; height: 160px; border: 1px solid #ccc; }
+#editor textarea, #editor pre { position: absolute; top: 0; left: 0; margin: 0; padding: 6px; box-sizing: border-box;
+  width: 100%; height: 100%; font-family: monospace; font-size: 13px; line-height: 1.4; white-space: pre-wrap;
+  word-wrap: break-word; overflow: auto; border: 0; }
+#editor textarea { color: transparent; background: transparent; caret-color: black; resize: none; outline: none; }
+#editor pre { pointer-events: none; }
+.kw { color: #00f; } .str { color: #a31515; } .cmt { color: #080; } .num { color: #098658; } .col { color: #795e26; }
+#controls { margin: 6px 0; }
+#status { margin-left: 12px; color: #666; }
+#error { color: #c00; white-space: pre-wrap; font-family: monospace; }
+#result { flex: 1; overflow: auto; }
+#result table { border-collapse: collapse; }
+#result th, #result td { border: 1px solid #ddd; padding: 2px 6px; font-family: monospace; white-space: nowrap; }
+#result th { background: #eee; cursor: pointer; position: sticky; top: 0; }
+</style>
+</head>
+<body>
+<div id="sidebar">
+  <h3>Tables</h3><div id="schema"></div>
+  <h3>History</h3><div id="history"></div>
+</div>
+<div id="main">
+  <div id="editor"><pre id="highlight"></pre><textarea id="query" spellcheck="false"></textarea></div>
+  <div id="controls">
+    <button id="run">Run (Ctrl-Enter)</button>
+    <button id="prev">&lt; Prev</button>
+    <button id="next">Next &gt;</button>
+    <span id="status"></span>
+  </div>
+  <div id="error"></div>
+  <div id="result"></div>
+</div>
+<script>
+var pageSize = 100;
+var page = {start: 0, total: 0, sort: "", desc: false};
+
+function escapeHTML(s) {
+  return s.replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;")
+    .replace(/"/g, "&quot;").replace(/'/g, "&#39;");
+}
+
+// highlight colors GQL keywords, strings, comments, numbers, and &column refs.
+function highlight(text) {
+  var re = /(\/\/[^\n]*)|("(?:[^"\\\n]|\\.)*"|` + "`" + `[^` + "`" + `]*` + "`" + `)|(&[A-Za-z_][A-Za-z0-9_]*)|\b(func|load|matview|if|else|cond|NA|true|false)\b|\b(\d+(?:\.\d+)?)\b/g;
+  var out = "", last = 0, m;
+  while ((m = re.exec(text)) !== null) {
+    out += escapeHTML(text.substring(last, m.index));
+    var cls = m[1] ? "cmt" : m[2] ? "str" : m[3] ? "col" : m[4] ? "kw" : "num";
+    out += '<span class="' + cls + '">' + escapeHTML(m[0]) + "</span>";
+    last = re.lastIndex;
+  }
+  return out + escapeHTML(text.substring(last)) + "\n";
+}
+
+var query = document.getElementById("query");
+var hl = document.getElementById("highlight");
+function syncEditor() {
+  hl.innerHTML = highlight(query.value);
+  hl.scrollTop = query.scrollTop;
+}
+query.addEventListener("input", syncEditor);
+query.addEventListener("scroll", function() { hl.scrollTop = query.scrollTop; });
+query.addEventListener("keydown", function(e) {
+  if (e.key === "Enter" && (e.ctrlKey || e.metaKey)) { e.preventDefault(); run(); }
+});
+
+function setStatus(s) { document.getElementById("status").textContent = s; }
+function setError(s) { document.getElementById("error").textContent = s || ""; }
+
+function api(url, opts) {
+  return fetch(url, opts).then(function(r) {
+    return r.json().then(function(body) {
+      if (body.error) { throw new Error(body.error); }
+      return body;
+    });
+  });
+}
+
+function showPage(resp) {
+  page.start = resp.start;
+  page.total = resp.total;
+  var html = "<table><thead><tr>";
+  resp.columns.forEach(function(c) {
+    var mark = page.sort === c ? (page.desc ? " ▼" : " ▲") : "";
+    html += '<th data-col="' + escapeHTML(c) + '">' + escapeHTML(c) + mark + "</th>";
+  });
+  html += "</tr></thead><tbody>";
+  resp.rows.forEach(function(row) {
+    html += "<tr>";
+    row.forEach(function(v) { html += "<td>" + escapeHTML(v) + "</td>"; });
+    html += "</tr>";
+  });
+  html += "</tbody></table>";
+  document.getElementById("result").innerHTML = html;
+  document.querySelectorAll("#result th").forEach(function(th) {
+    th.addEventListener("click", function() { sortBy(th.dataset.col); });
+  });
+  var end = Math.min(resp.start + resp.rows.length, resp.total);
+  setStatus("rows " + (resp.total ? resp.start + 1 : 0) + "-" + end + " of " + resp.total);
+}
+
+function run() {
+  setError("");
+  setStatus("running...");
+  page = {start: 0, total: 0, sort: "", desc: false};
+  api("/api/run", {method: "POST", body: JSON.stringify({query: query.value, limit: pageSize})})
+    .then(function(resp) {
+      if (resp.columns !== undefined) {
+        showPage(resp);
+      } else {
+        document.getElementById("result").innerHTML = "<pre>" + escapeHTML(resp.value) + "</pre>";
+        setStatus("");
+      }
+    })
+    .catch(function(e) { setError(e.message); setStatus(""); })
+    .then(function() { loadSchema(); loadHistory(); });
+}
+
+function loadPage(start) {
+  if (start < 0 || (page.total && start >= page.total)) { return; }
+  var url = "/api/page?start=" + start + "&limit=" + pageSize +
+    "&sort=" + encodeURIComponent(page.sort) + "&desc=" + page.desc;
+  setStatus("loading...");
+  api(url).then(showPage).catch(function(e) { setError(e.message); setStatus(""); });
+}
+
+function sortBy(col) {
+  if (page.sort === col) {
+    page.desc = !page.desc;
+  } else {
+    page.sort = col;
+    page.desc = false;
+  }
+  loadPage(0);
+}
+
+function loadSchema() {
+  api("/api/schema").then(function(resp) {
+    var html = "";
+    resp.tables.forEach(function(t) {
+      html += '<div class="table" title="' + escapeHTML(t.path || "") + '">' + escapeHTML(t.name) + "</div>";
+      t.columns.forEach(function(c) {
+        html += '<div class="column" title="' + escapeHTML(c.description || "") + '">' +
+          escapeHTML(c.name) + ": " + escapeHTML(c.type) + "</div>";
+      });
+    });
+    document.getElementById("schema").innerHTML = html;
+  });
+}
+
+function loadHistory() {
+  api("/api/history").then(function(resp) {
+    var div = document.getElementById("history");
+    div.innerHTML = "";
+    resp.history.forEach(function(h) {
+      var e = document.createElement("div");
+      e.className = "history";
+      e.textContent = h.query;
+      e.title = h.time;
+      e.addEventListener("click", function() { query.value = h.query; syncEditor(); });
+      div.appendChild(e);
+    });
+  });
+}
+
+document.getElementById("run").addEventListener("click", run);
+document.getElementById("prev").addEventListener("click", function() { loadPage(Math.max(0, page.start - pageSize)); });
+document.getElementById("next").addEventListener("click", function() { loadPage(page.start + pageSize); });
+syncEditor();
+loadSchema();
+loadHistory();
+</script>
+</body>
+</html>
+`
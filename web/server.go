@@ -0,0 +1,733 @@
+// Package web implements a browser-based console for GQL. It serves a single
+// page with a query editor, a paginated result grid, a schema sidebar, and the
+// query history of the user.
+package web
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grailbio/base/log"
+	"github.com/grailbio/gql/gql"
+)
+
+const (
+	// defaultPageSize is the number of rows returned when the request does not
+	// specify a limit.
+	defaultPageSize = 100
+	// maxPageSize is the max number of rows returned by one request.
+	maxPageSize = 10000
+	// maxHistoryEntries is the max number of history entries returned to the page.
+	maxHistoryEntries = 1000
+	// DefaultMaxUsers is the default value of Opts.MaxUsers.
+	DefaultMaxUsers = 100
+	// DefaultIdleTimeout is the default value of Opts.IdleTimeout.
+	DefaultIdleTimeout = 24 * time.Hour
+	// DefaultRequestTimeout is the default value of Opts.RequestTimeout.
+	DefaultRequestTimeout = time.Hour
+	// readTimeout is the max time to read a request, including the body.
+	readTimeout = time.Minute
+	// connIdleTimeout is the max time a keep-alive connection stays open
+	// between requests.
+	connIdleTimeout = 2 * time.Minute
+	// userCookie is the cookie used to identify users when the server is not
+	// behind an authenticating proxy. Its value is "<id>.<signature>".
+	userCookie = "gql_user"
+	// resultVar is the variable name that the result table is bound to when it
+	// is sorted.
+	resultVar = "_web_result"
+)
+
+var (
+	// userHeaders lists the headers set by authenticating proxies, in the order
+	// of preference.
+	userHeaders = []string{"X-Forwarded-User", "X-Auth-Request-User", "X-Forwarded-Email"}
+	// columnNameRE matches column names that can be used as sort keys.
+	columnNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// unsafeUserCharsRE matches characters that may not appear in a history
+	// filename.
+	unsafeUserCharsRE = regexp.MustCompile(`[^A-Za-z0-9_.@-]`)
+)
+
+// Opts configures the server.
+type Opts struct {
+	// NewSession creates a GQL session for a new user. It typically loads the
+	// standard library. Required.
+	NewSession func(ctx context.Context) *gql.Session
+	// HistoryDir is the directory that stores the per-user query history. If
+	// empty, the history is kept only in memory.
+	HistoryDir string
+	// TrustedProxies lists the networks of the authenticating proxies. The user
+	// name set by a proxy in X-Forwarded-User, X-Auth-Request-User, or
+	// X-Forwarded-Email is honored only if the request comes from one of these
+	// networks. Otherwise, the user is identified by a signed cookie.
+	TrustedProxies []*net.IPNet
+	// CookieKey is used to sign the user cookies. If empty, a random key is
+	// generated, so the cookies issued by one server process are not accepted
+	// by another.
+	CookieKey []byte
+	// MaxUsers is the max number of user sessions kept in memory. When it is
+	// reached, the least recently used session is dropped. If <= 0,
+	// DefaultMaxUsers is used.
+	MaxUsers int
+	// IdleTimeout is the duration after which an unused session is dropped. If
+	// <= 0, DefaultIdleTimeout is used.
+	IdleTimeout time.Duration
+	// RequestTimeout is the max time ListenAndServe spends on one request,
+	// including the evaluation of the query. The connection is closed once it
+	// passes, so it should be longer than the evaluation time limit of the
+	// session. If <= 0, DefaultRequestTimeout is used.
+	RequestTimeout time.Duration
+}
+
+// Server serves the console. It implements http.Handler. Thread safe.
+type Server struct {
+	ctx  context.Context
+	opts Opts
+	mux  *http.ServeMux
+
+	mu    sync.Mutex
+	users map[string]*userState
+}
+
+// historyEntry is one query run by a user.
+type historyEntry struct {
+	Time  time.Time `json:"time"`
+	Query string    `json:"query"`
+}
+
+// userState is the state of one user. Queries of a user are run sequentially.
+type userState struct {
+	// lastAccess is the time of the last request of the user. Guarded by
+	// Server.mu.
+	lastAccess time.Time
+
+	mu   sync.Mutex
+	sess *gql.Session
+	// historyPath is the file that stores the history. It may be empty.
+	historyPath string
+	history     []historyEntry
+	// result is the table produced by the last query, if any.
+	result gql.Table
+	// sorted caches the result sorted by the given column.
+	sortCol string
+	sorted  gql.Table
+}
+
+// New creates a new server. Arg ctx is used to evaluate queries.
+func New(ctx context.Context, opts Opts) *Server {
+	if opts.NewSession == nil {
+		log.Panicf("web.New: Opts.NewSession must be set")
+	}
+	if len(opts.CookieKey) == 0 {
+		opts.CookieKey = make([]byte, 32)
+		if _, err := rand.Read(opts.CookieKey); err != nil {
+			log.Panicf("rand: %v", err)
+		}
+	}
+	if opts.MaxUsers <= 0 {
+		opts.MaxUsers = DefaultMaxUsers
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultIdleTimeout
+	}
+	s := &Server{
+		ctx:   ctx,
+		opts:  opts,
+		mux:   http.NewServeMux(),
+		users: map[string]*userState{},
+	}
+	s.mux.HandleFunc("/", s.handleIndex)
+	s.mux.HandleFunc("/api/run", s.handleRun)
+	s.mux.HandleFunc("/api/page", s.handlePage)
+	s.mux.HandleFunc("/api/schema", s.handleSchema)
+	s.mux.HandleFunc("/api/history", s.handleHistory)
+	return s
+}
+
+// ListenAndServe runs the console at the given address, e.g., ":8080". It
+// returns only on error.
+//
+// The console runs arbitrary queries, which may read any file readable by the
+// server process, so an address without a host, e.g., ":8080", binds to
+// localhost only. To accept connections from other machines, pass the host
+// explicitly, e.g., "0.0.0.0:8080", and put the server behind an
+// authenticating proxy listed in Opts.TrustedProxies.
+func ListenAndServe(ctx context.Context, addr string, opts Opts) error {
+	addr, err := listenAddr(addr)
+	if err != nil {
+		return err
+	}
+	if opts.RequestTimeout <= 0 {
+		opts.RequestTimeout = DefaultRequestTimeout
+	}
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           New(ctx, opts),
+		ReadHeaderTimeout: readTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      opts.RequestTimeout,
+		IdleTimeout:       connIdleTimeout,
+	}
+	log.Printf("web: serving GQL console at %s", addr)
+	return srv.ListenAndServe()
+}
+
+// listenAddr fills in localhost as the host of addr if it is empty.
+func listenAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("web: address %s: %v", addr, err)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// ParseTrustedProxies parses a comma-separated list of CIDRs, e.g.,
+// "10.0.0.0/8,127.0.0.1/32", for Opts.TrustedProxies.
+func ParseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(s, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ReadOrCreateCookieKey reads Opts.CookieKey from the given file. If the file
+// doesn't exist, it is created with a random key.
+func ReadOrCreateCookieKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// fromTrustedProxy checks if the request comes from one of
+// Opts.TrustedProxies.
+func (s *Server) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range s.opts.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// signUser computes the signature of the user ID stored in the cookie.
+func (s *Server) signUser(id string) string {
+	mac := hmac.New(sha256.New, s.opts.CookieKey)
+	mac.Write([]byte(id)) // nolint: errcheck
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// userName identifies the user of the request. It prefers the name set by an
+// authenticating proxy, if the request comes from a trusted proxy. Otherwise it
+// uses a random ID stored in a cookie signed by the server. A cookie with an
+// invalid signature is replaced by a new one.
+func (s *Server) userName(w http.ResponseWriter, r *http.Request) string {
+	if s.fromTrustedProxy(r) {
+		for _, h := range userHeaders {
+			if name := r.Header.Get(h); name != "" {
+				return unsafeUserCharsRE.ReplaceAllString(name, "_")
+			}
+		}
+	}
+	if c, err := r.Cookie(userCookie); err == nil {
+		if i := strings.IndexByte(c.Value, '.'); i > 0 {
+			id, sig := c.Value[:i], c.Value[i+1:]
+			if hmac.Equal([]byte(sig), []byte(s.signUser(id))) {
+				// The ID is hex, so it is safe to use as a filename.
+				return "cookie-" + id
+			}
+		}
+	}
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		log.Panicf("rand: %v", err)
+	}
+	id := hex.EncodeToString(buf[:])
+	http.SetCookie(w, &http.Cookie{
+		Name:     userCookie,
+		Value:    id + "." + s.signUser(id),
+		Path:     "/",
+		Expires:  time.Now().AddDate(10, 0, 0),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return "cookie-" + id
+}
+
+// getUser finds or creates the state of the user of the request. It drops the
+// sessions that have been idle for longer than Opts.IdleTimeout, and the least
+// recently used session if there are Opts.MaxUsers sessions already.
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) *userState {
+	name := s.userName(w, r)
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u, ok := s.users[name]; ok {
+		u.lastAccess = now
+		return u
+	}
+	var (
+		lruName string
+		lru     *userState
+	)
+	for n, u := range s.users {
+		if now.Sub(u.lastAccess) > s.opts.IdleTimeout {
+			delete(s.users, n)
+			continue
+		}
+		if lru == nil || u.lastAccess.Before(lru.lastAccess) {
+			lruName, lru = n, u
+		}
+	}
+	if len(s.users) >= s.opts.MaxUsers {
+		log.Printf("web: too many sessions; dropping the session of %s", lruName)
+		delete(s.users, lruName)
+	}
+	u := &userState{lastAccess: now, sess: s.opts.NewSession(s.ctx), historyPath: s.historyPath(name)}
+	if u.historyPath != "" {
+		u.history = readHistory(u.historyPath)
+	}
+	s.users[name] = u
+	return u
+}
+
+// sameOrigin checks if the request may have been sent by a page served by
+// another site. It trusts the Sec-Fetch-Site header if set, else the Origin
+// header. Requests that set neither are not from a browser, so they can't be
+// forged by another site.
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "":
+	default:
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func (s *Server) historyPath(user string) string {
+	if s.opts.HistoryDir == "" {
+		return ""
+	}
+	return filepath.Join(s.opts.HistoryDir, user+".jsonl")
+}
+
+// readHistory reads the history file. Each line is a JSON-encoded historyEntry.
+func readHistory(path string) []historyEntry {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error.Printf("web: read history %s: %v", path, err)
+		}
+		return nil
+	}
+	var history []historyEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		var e historyEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			log.Error.Printf("web: read history %s: %v", path, err)
+			continue
+		}
+		history = append(history, e)
+	}
+	return history
+}
+
+// appendHistory appends an entry to the history file.
+func appendHistory(path string, e historyEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		f.Close() // nolint: errcheck
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close() // nolint: errcheck
+		return err
+	}
+	return f.Close()
+}
+
+// catch runs cb and converts a GQL panic into an error.
+func catch(cb func()) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+	cb()
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error.Printf("web: write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := w.Write([]byte(indexHTML)); err != nil {
+		log.Error.Printf("web: write response: %v", err)
+	}
+}
+
+// runRequest is the body of /api/run.
+type runRequest struct {
+	Query string `json:"query"`
+	Limit int    `json:"limit"`
+}
+
+// handleRun evaluates a query. If the result is a table, it responds with the
+// first page of the table. Else, it responds with {"value": "..."}.
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s: POST required", r.URL.Path))
+		return
+	}
+	if !sameOrigin(r) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%s: cross-origin request", r.URL.Path))
+		return
+	}
+	var req runRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	u := s.getUser(w, r)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	entry := historyEntry{Time: time.Now(), Query: req.Query}
+	u.history = append(u.history, entry)
+	if u.historyPath != "" {
+		if err := appendHistory(u.historyPath, entry); err != nil {
+			log.Error.Printf("web: append history %s: %v", u.historyPath, err)
+		}
+	}
+
+	// Apply the resource limits to both the evaluation and the first page.
+	ctx, cancel := u.sess.WithLimits(s.ctx)
+	defer cancel()
+	var val gql.Value
+	err := catch(func() {
+		statements, err := u.sess.Parse("(web)", []byte(req.Query))
+		if err != nil {
+			panic(err)
+		}
+		val = u.sess.EvalStatements(ctx, statements)
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if val.Type() != gql.TableType {
+		u.result = nil
+		writeJSON(w, http.StatusOK, map[string]string{"value": val.String()})
+		return
+	}
+	u.result, u.sortCol, u.sorted = val.Table(nil), "", nil
+	resp, err := u.readPage(ctx, 0, req.Limit, "", false)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// pageResponse is a page of the result table.
+type pageResponse struct {
+	Columns []string   `json:"columns"`
+	Rows    [][]string `json:"rows"`
+	Start   int        `json:"start"`
+	Total   int        `json:"total"`
+}
+
+// sortedResult returns the result table sorted by the given column in
+// ascending order.
+func (u *userState) sortedResult(ctx context.Context, col string) (gql.Table, error) {
+	if col == "" {
+		return u.result, nil
+	}
+	if !columnNameRE.MatchString(col) {
+		return nil, fmt.Errorf("invalid sort column '%s'", col)
+	}
+	if u.sortCol == col {
+		return u.sorted, nil
+	}
+	var sorted gql.Table
+	err := catch(func() {
+		// Use a scratch session so that the variable binding doesn't leak into
+		// the user's session.
+		sess := gql.NewSession()
+		sess.SetGlobal(resultVar, gql.NewTable(u.result))
+		statements, err := sess.Parse("(web)", []byte(resultVar+" | sort(&"+col+")"))
+		if err != nil {
+			panic(err)
+		}
+		sorted = sess.EvalStatements(ctx, statements).Table(nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	u.sortCol, u.sorted = col, sorted
+	return sorted, nil
+}
+
+// readPage reads rows [start, start+limit) of the result table, sorted by
+// column sortCol if it is nonempty. A descending page is read from the
+// ascending table backwards.
+//
+// REQUIRES: u.mu is locked and u.result != nil.
+func (u *userState) readPage(ctx context.Context, start, limit int, sortCol string, desc bool) (pageResponse, error) {
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	if start < 0 {
+		start = 0
+	}
+	var resp pageResponse
+	err := catch(func() {
+		t, err := u.sortedResult(ctx, sortCol)
+		if err != nil {
+			panic(err)
+		}
+		resp.Total = t.Len(ctx, gql.Exact)
+		resp.Start = start
+		scanStart, scanLimit := start, start+limit
+		if desc {
+			scanStart, scanLimit = resp.Total-start-limit, resp.Total-start
+			if scanStart < 0 {
+				scanStart = 0
+			}
+		}
+		colIndex := map[string]int{}
+		var rows [][]gql.Value
+		sc := t.Scanner(ctx, 0, 1, 1)
+		for i := 0; i < scanLimit && sc.Scan(); i++ {
+			if i < scanStart {
+				continue
+			}
+			rows = append(rows, nil)
+			row := sc.Value()
+			if row.Type() != gql.StructType {
+				addColumn(&resp, colIndex, "_")
+				rows[len(rows)-1] = []gql.Value{row}
+				continue
+			}
+			st := row.Struct(nil)
+			vals := make([]gql.Value, len(resp.Columns), len(resp.Columns)+st.Len())
+			for fi := 0; fi < st.Len(); fi++ {
+				f := st.Field(fi)
+				ci := addColumn(&resp, colIndex, f.Name.Str())
+				for len(vals) <= ci {
+					vals = append(vals, gql.Value{})
+				}
+				vals[ci] = f.Value
+			}
+			rows[len(rows)-1] = vals
+		}
+		if desc {
+			for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+				rows[i], rows[j] = rows[j], rows[i]
+			}
+		}
+		resp.Rows = make([][]string, len(rows))
+		for ri, row := range rows {
+			resp.Rows[ri] = make([]string, len(resp.Columns))
+			for ci, v := range row {
+				if v.Type() != gql.InvalidType {
+					resp.Rows[ri][ci] = v.String()
+				}
+			}
+		}
+	})
+	return resp, err
+}
+
+// addColumn adds a column to the page unless it exists already. It returns the
+// index of the column.
+func addColumn(resp *pageResponse, colIndex map[string]int, name string) int {
+	if ci, ok := colIndex[name]; ok {
+		return ci
+	}
+	ci := len(resp.Columns)
+	colIndex[name] = ci
+	resp.Columns = append(resp.Columns, name)
+	return ci
+}
+
+// handlePage responds with a page of the result of the last query. The URL
+// parameters are start, limit, sort (column name), and desc (bool).
+func (s *Server) handlePage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	intParam := func(name string) int {
+		n, _ := strconv.Atoi(q.Get(name))
+		return n
+	}
+	desc, _ := strconv.ParseBool(q.Get("desc"))
+	u := s.getUser(w, r)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.result == nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("the last query did not produce a table"))
+		return
+	}
+	// Reading a page, especially a sorted one, may scan the whole table, so it
+	// is subject to the resource limits.
+	ctx, cancel := u.sess.WithLimits(s.ctx)
+	defer cancel()
+	resp, err := u.readPage(ctx, intParam("start"), intParam("limit"), q.Get("sort"), desc)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// schemaTable describes a table-typed global variable.
+type schemaTable struct {
+	Name        string          `json:"name"`
+	Path        string          `json:"path,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Columns     []schemaColumns `json:"columns"`
+}
+
+type schemaColumns struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+}
+
+// handleSchema responds with the attributes of the tables bound to global
+// variables, as reported by table_attrs.
+func (s *Server) handleSchema(w http.ResponseWriter, r *http.Request) {
+	u := s.getUser(w, r)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	// Attrs of some tables, e.g., a TSV file, read the file.
+	ctx, cancel := u.sess.WithLimits(s.ctx)
+	defer cancel()
+	tables := []schemaTable{}
+	for _, id := range u.sess.Bindings().GlobalVars() {
+		name := id.Str()
+		if strings.HasPrefix(name, "tmp") || strings.HasPrefix(name, "_") {
+			continue
+		}
+		val, _ := u.sess.Bindings().Lookup(id)
+		if val.Type() != gql.TableType {
+			continue
+		}
+		err := catch(func() {
+			attrs := val.Table(nil).Attrs(ctx)
+			st := schemaTable{Name: name, Path: attrs.Path, Description: attrs.Description, Columns: []schemaColumns{}}
+			for _, col := range attrs.Columns {
+				st.Columns = append(st.Columns, schemaColumns{Name: col.Name, Type: col.Type.String(), Description: col.Description})
+			}
+			tables = append(tables, st)
+		})
+		if err != nil {
+			log.Error.Printf("web: table_attrs(%s): %v", name, err)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tables": tables})
+}
+
+// handleHistory responds with the queries run by the user, newest first.
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	u := s.getUser(w, r)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	history := []historyEntry{}
+	for i := len(u.history) - 1; i >= 0 && len(history) < maxHistoryEntries; i-- {
+		history = append(history, u.history[i])
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"history": history})
+}
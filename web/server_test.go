@@ -0,0 +1,153 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grailbio/gql/gql"
+	"github.com/grailbio/gql/gqltest"
+	"github.com/grailbio/testutil"
+	"github.com/grailbio/testutil/expect"
+)
+
+func doRequest(t *testing.T, s *Server, method, url, body string, resp interface{}) int {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	// httptest.NewRequest sets RemoteAddr to 192.0.2.1.
+	req.Header.Set("X-Forwarded-User", "alice")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	expect.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+	return w.Code
+}
+
+func TestServer(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t, "", "")
+	defer cleanup()
+	_, proxy, err := net.ParseCIDR("192.0.2.0/24")
+	expect.NoError(t, err)
+	opts := Opts{
+		NewSession:     func(ctx context.Context) *gql.Session { return gqltest.NewSession() },
+		HistoryDir:     tmpDir,
+		TrustedProxies: []*net.IPNet{proxy},
+	}
+	s := New(context.Background(), opts)
+
+	var page pageResponse
+	expect.EQ(t, doRequest(t, s, "POST", "/api/run",
+		`{"query": "t := table({a:1, b:\"x\"}, {a:3, b:\"y\"}, {a:2, b:\"z\"}); t", "limit": 2}`, &page), http.StatusOK)
+	expect.EQ(t, page.Columns, []string{"a", "b"})
+	expect.EQ(t, page.Rows, [][]string{{"1", "x"}, {"3", "y"}})
+	expect.EQ(t, page.Total, 3)
+
+	expect.EQ(t, doRequest(t, s, "GET", "/api/page?start=0&limit=2&sort=a&desc=true", "", &page), http.StatusOK)
+	expect.EQ(t, page.Rows, [][]string{{"3", "y"}, {"2", "z"}})
+	expect.EQ(t, doRequest(t, s, "GET", "/api/page?start=2&limit=2&sort=a&desc=true", "", &page), http.StatusOK)
+	expect.EQ(t, page.Rows, [][]string{{"1", "x"}})
+	expect.EQ(t, doRequest(t, s, "GET", "/api/page?start=1&limit=5&sort=a", "", &page), http.StatusOK)
+	expect.EQ(t, page.Rows, [][]string{{"2", "z"}, {"3", "y"}})
+
+	var schema struct {
+		Tables []schemaTable `json:"tables"`
+	}
+	expect.EQ(t, doRequest(t, s, "GET", "/api/schema", "", &schema), http.StatusOK)
+	expect.EQ(t, len(schema.Tables), 1)
+	expect.EQ(t, schema.Tables[0].Name, "t")
+
+	var errResp map[string]string
+	expect.EQ(t, doRequest(t, s, "POST", "/api/run", `{"query": "nosuchvar"}`, &errResp), http.StatusBadRequest)
+	expect.True(t, errResp["error"] != "")
+
+	// The history persists across servers.
+	s = New(context.Background(), opts)
+	var history struct {
+		History []historyEntry `json:"history"`
+	}
+	expect.EQ(t, doRequest(t, s, "GET", "/api/history", "", &history), http.StatusOK)
+	expect.EQ(t, len(history.History), 2)
+	expect.EQ(t, history.History[0].Query, "nosuchvar")
+}
+
+func TestServerUserCookie(t *testing.T) {
+	s := New(context.Background(), Opts{
+		NewSession: func(ctx context.Context) *gql.Session { return gqltest.NewSession() },
+	})
+	run := func(cookie *http.Cookie, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/run", strings.NewReader(`{"query": "`+query+`"}`))
+		// Not from a trusted proxy, so the header is ignored.
+		req.Header.Set("X-Forwarded-User", "alice")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+	w := run(nil, "x := 10")
+	expect.EQ(t, w.Code, http.StatusOK)
+	cookies := w.Result().Cookies()
+	expect.EQ(t, len(cookies), 1)
+	cookie := cookies[0]
+
+	// The variable is visible only to the same user.
+	w = run(cookie, "x")
+	expect.EQ(t, w.Code, http.StatusOK)
+	expect.EQ(t, len(w.Result().Cookies()), 0)
+	w = run(&http.Cookie{Name: cookie.Name, Value: "0123." + strings.SplitN(cookie.Value, ".", 2)[1]}, "x")
+	expect.EQ(t, w.Code, http.StatusBadRequest)
+	expect.EQ(t, len(w.Result().Cookies()), 1)
+}
+
+func TestServerCrossOrigin(t *testing.T) {
+	s := New(context.Background(), Opts{
+		NewSession: func(ctx context.Context) *gql.Session { return gqltest.NewSession() },
+	})
+	for _, test := range []struct {
+		header, value string
+		want          int
+	}{
+		{"Origin", "http://example.com", http.StatusOK},
+		{"Origin", "http://evil.example.org", http.StatusForbidden},
+		{"Sec-Fetch-Site", "same-origin", http.StatusOK},
+		{"Sec-Fetch-Site", "cross-site", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("POST", "http://example.com/api/run", strings.NewReader(`{"query": "1"}`))
+		req.Header.Set(test.header, test.value)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		expect.EQ(t, w.Code, test.want, "%+v", test)
+	}
+}
+
+func TestServerMaxUsers(t *testing.T) {
+	s := New(context.Background(), Opts{
+		NewSession: func(ctx context.Context) *gql.Session { return gqltest.NewSession() },
+		MaxUsers:   2,
+	})
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest("GET", "/api/history", nil)
+		s.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	expect.EQ(t, len(s.users), 2)
+}
+
+func TestListenAddr(t *testing.T) {
+	for _, test := range []struct {
+		addr, want string
+	}{
+		{":8080", "localhost:8080"},
+		{"localhost:8080", "localhost:8080"},
+		{"0.0.0.0:8080", "0.0.0.0:8080"},
+		{"[::1]:8080", "[::1]:8080"},
+	} {
+		got, err := listenAddr(test.addr)
+		expect.NoError(t, err)
+		expect.EQ(t, got, test.want)
+	}
+	_, err := listenAddr("8080")
+	expect.HasSubstr(t, err.Error(), "missing port")
+}